@@ -0,0 +1,251 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package accountcmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var longDesc = strings.TrimSpace(`
+Manages Keppel accounts through the Keppel API.
+
+The environment variables must contain credentials for authenticating with the
+authentication method used by the target Keppel API. Responses from the API
+are printed to stdout as JSON.
+`)
+
+var (
+	authTenantID    string
+	accountFilePath string
+)
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "account <subcommand> <args...>",
+		Short: "Manages Keppel accounts.",
+		Long:  longDesc,
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "Lists all accounts that the current user can view.",
+		Args:  cobra.NoArgs,
+		Run:   runList,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "get <account>",
+		Short: "Shows the configuration of an account.",
+		Args:  cobra.ExactArgs(1),
+		Run:   runGet,
+	})
+
+	createCmd := &cobra.Command{
+		Use:     "create <account>",
+		Example: "  keppel account create myaccount --file myaccount.json",
+		Short:   "Creates an account, or updates its configuration if it already exists.",
+		Long: `Creates an account, or updates its configuration if it already exists.
+If given, the file must contain a JSON object with the same structure as the "account" object in the request body of PUT /keppel/v1/accounts/:name.
+Use "-" as file name to read from stdin.`,
+		Args: cobra.ExactArgs(1),
+		Run:  runCreate,
+	}
+	createCmd.Flags().StringVar(&authTenantID, "auth-tenant-id", "", "Auth tenant that the account shall belong to (default: the auth tenant of the current user).")
+	createCmd.Flags().StringVarP(&accountFilePath, "file", "f", "", "File containing the account configuration.")
+	cmd.AddCommand(createCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete <account>",
+		Short: "Deletes an account.",
+		Long: `Deletes an account.
+Depending on the configuration of the Keppel instance, the deletion may be scheduled to happen after a grace period.`,
+		Args: cobra.ExactArgs(1),
+		Run:  runDelete,
+	})
+
+	setQuotaCmd := &cobra.Command{
+		Use:   "set-quota <manifest-count>",
+		Short: "Sets the manifest quota for an auth tenant.",
+		Long: `Sets the manifest quota for an auth tenant.
+Quotas are shared between all accounts in the same auth tenant.`,
+		Args: cobra.ExactArgs(1),
+		Run:  runSetQuota,
+	}
+	setQuotaCmd.Flags().StringVar(&authTenantID, "auth-tenant-id", "", "Auth tenant whose quota shall be set (default: the auth tenant of the current user).")
+	cmd.AddCommand(setQuotaCmd)
+
+	parent.AddCommand(cmd)
+}
+
+func runList(cmd *cobra.Command, args []string) {
+	c := connect(cmd.Context())
+	c.printResponse(http.MethodGet, "/keppel/v1/accounts", nil)
+}
+
+func runGet(cmd *cobra.Command, args []string) {
+	accountName := parseAccountName(args[0])
+	c := connect(cmd.Context())
+	c.printResponse(http.MethodGet, "/keppel/v1/accounts/"+string(accountName), nil)
+}
+
+func runCreate(cmd *cobra.Command, args []string) {
+	accountName := parseAccountName(args[0])
+	c := connect(cmd.Context())
+
+	account := make(map[string]any)
+	if accountFilePath != "" {
+		var (
+			buf []byte
+			err error
+		)
+		if accountFilePath == "-" {
+			buf, err = io.ReadAll(os.Stdin)
+		} else {
+			buf, err = os.ReadFile(accountFilePath)
+		}
+		if err != nil {
+			logg.Fatal("cannot read account configuration: %s", err.Error())
+		}
+		err = json.Unmarshal(buf, &account)
+		if err != nil {
+			logg.Fatal("cannot parse account configuration: %s", err.Error())
+		}
+	}
+	if authTenantID != "" {
+		account["auth_tenant_id"] = authTenantID
+	}
+	if _, exists := account["auth_tenant_id"]; !exists {
+		account["auth_tenant_id"] = c.AuthDriver.CurrentAuthTenantID()
+	}
+
+	reqBody := map[string]any{"account": account}
+	c.printResponse(http.MethodPut, "/keppel/v1/accounts/"+string(accountName), reqBody)
+}
+
+func runDelete(cmd *cobra.Command, args []string) {
+	accountName := parseAccountName(args[0])
+	c := connect(cmd.Context())
+	c.printResponse(http.MethodDelete, "/keppel/v1/accounts/"+string(accountName), nil)
+	logg.Info("account %s was deleted or scheduled for deletion", accountName)
+}
+
+func runSetQuota(cmd *cobra.Command, args []string) {
+	manifestCount, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		logg.Fatal("invalid manifest count: %s", err.Error())
+	}
+	c := connect(cmd.Context())
+
+	tenantID := authTenantID
+	if tenantID == "" {
+		tenantID = c.AuthDriver.CurrentAuthTenantID()
+	}
+	reqBody := map[string]any{
+		"manifests": map[string]any{"quota": manifestCount},
+	}
+	c.printResponse(http.MethodPut, "/keppel/v1/quotas/"+tenantID, reqBody)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// helpers
+
+func parseAccountName(input string) models.AccountName {
+	if !models.IsAccountName(input) {
+		logg.Fatal("%q is not a valid account name", input)
+	}
+	return models.AccountName(input)
+}
+
+type apiClient struct {
+	Context    context.Context
+	AuthDriver client.AuthDriver
+}
+
+func connect(ctx context.Context) apiClient {
+	ad, err := client.NewAuthDriver(ctx)
+	if err != nil {
+		logg.Fatal("while setting up auth driver: %s", err.Error())
+	}
+	return apiClient{ctx, ad}
+}
+
+// Sends a request to the Keppel API and prints the response body (if any) to
+// stdout. Aborts the program on error.
+func (c apiClient) printResponse(method, path string, reqBody any) {
+	var body io.Reader
+	if reqBody != nil {
+		buf, err := json.Marshal(reqBody)
+		if err != nil {
+			logg.Fatal(err.Error())
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequestWithContext(c.Context, method, path, body)
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.AuthDriver.SendHTTPRequest(req)
+	if err != nil {
+		logg.Fatal("during %s %s: %s", method, path, err.Error())
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logg.Fatal("during %s %s: %s", method, path, err.Error())
+	}
+	if resp.StatusCode >= 400 {
+		logg.Fatal("during %s %s: got %s: %s", method, path, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	if len(respBody) == 0 {
+		return
+	}
+
+	var out bytes.Buffer
+	err = json.Indent(&out, respBody, "", "  ")
+	if err != nil {
+		// not JSON, print as-is
+		out.Reset()
+		out.Write(respBody)
+	}
+	fmt.Println(strings.TrimSpace(out.String()))
+}
@@ -21,10 +21,12 @@ package anycastmonitorcmd
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -70,6 +72,22 @@ var anycastmonitorMemberGauge = prometheus.NewGauge(
 	},
 )
 
+var anycastmonitorPullDurationHistogramVec = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "keppel_anycastmonitor_pull_duration_seconds",
+		Help:    "Duration of successful pulls (including validation) from the given account via the anycast endpoint.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 8), // 10ms .. ~160s
+	},
+	[]string{"account"},
+)
+
+var anycastmonitorCertExpiryGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "keppel_anycastmonitor_tls_cert_expiry_timestamp_seconds",
+		Help: "UNIX timestamp when the TLS certificate of the anycast endpoint expires, or 0 if the certificate could not be retrieved or verified.",
+	},
+)
+
 // AddCommandTo mounts this command into the command hierarchy.
 func AddCommandTo(parent *cobra.Command) {
 	cmd := &cobra.Command{
@@ -89,14 +107,19 @@ type anycastMonitorJob struct {
 
 func run(cmd *cobra.Command, args []string) {
 	keppel.SetTaskName("anycast-health-monitor")
-	prometheus.MustRegister(anycastmonitorResultGaugeVec)
-	prometheus.MustRegister(anycastmonitorMemberGauge)
 
 	anycastURL, err := url.Parse(args[0])
 	if err != nil {
 		logg.Fatal("cannot parse URL %q: %s", args[0], err)
 	}
 
+	prometheus.MustRegister(anycastmonitorResultGaugeVec)
+	prometheus.MustRegister(anycastmonitorMemberGauge)
+	prometheus.MustRegister(anycastmonitorPullDurationHistogramVec)
+	if anycastURL.Scheme == "https" {
+		prometheus.MustRegister(anycastmonitorCertExpiryGauge)
+	}
+
 	apiPublicHostname := args[1]
 
 	job := &anycastMonitorJob{
@@ -122,6 +145,7 @@ func run(cmd *cobra.Command, args []string) {
 	manifestRef := models.ManifestReference{Tag: "latest"}
 	job.ValidateImages(ctx, manifestRef) // once immediately to initialize the metrics
 	job.ValidateAnycastMembership(ctx, anycastURL, apiPublicHostname)
+	job.CheckCertificateExpiry(ctx, anycastURL)
 	tick := time.Tick(30 * time.Second)
 	for {
 		select {
@@ -130,17 +154,21 @@ func run(cmd *cobra.Command, args []string) {
 		case <-tick:
 			job.ValidateImages(ctx, manifestRef)
 			job.ValidateAnycastMembership(ctx, anycastURL, apiPublicHostname)
+			job.CheckCertificateExpiry(ctx, anycastURL)
 		}
 	}
 }
 
-// Validates the uploaded images and emits the keppel_anycastmonitor_result metric accordingly.
+// Validates the uploaded images and emits the keppel_anycastmonitor_result
+// and keppel_anycastmonitor_pull_duration_seconds metrics accordingly.
 func (j *anycastMonitorJob) ValidateImages(ctx context.Context, manifestRef models.ManifestReference) {
 	for accountName, repoClient := range j.RepoClients {
 		labels := prometheus.Labels{"account": accountName}
+		startedAt := time.Now()
 		err := repoClient.ValidateManifest(ctx, manifestRef, nil, nil)
 		if err == nil {
 			anycastmonitorResultGaugeVec.With(labels).Set(1)
+			anycastmonitorPullDurationHistogramVec.With(labels).Observe(time.Since(startedAt).Seconds())
 		} else {
 			anycastmonitorResultGaugeVec.With(labels).Set(0)
 			imageRef := models.ImageReference{
@@ -208,3 +236,44 @@ func (j *anycastMonitorJob) ValidateAnycastMembership(ctx context.Context, anyca
 		}
 	}
 }
+
+// Checks the TLS certificate presented by the anycast endpoint and emits the
+// keppel_anycastmonitor_tls_cert_expiry_timestamp_seconds metric accordingly.
+func (j *anycastMonitorJob) CheckCertificateExpiry(ctx context.Context, anycastURL *url.URL) {
+	if anycastURL.Scheme != "https" {
+		return
+	}
+	expiresAt, err := getCertificateExpiry(ctx, anycastURL)
+	if err != nil {
+		anycastmonitorCertExpiryGauge.Set(0)
+		logg.Error("TLS certificate check failed: %s", err.Error())
+		return
+	}
+	anycastmonitorCertExpiryGauge.Set(float64(expiresAt.Unix()))
+}
+
+func getCertificateExpiry(ctx context.Context, anycastURL *url.URL) (time.Time, error) {
+	address := anycastURL.Host
+	if anycastURL.Port() == "" {
+		address = net.JoinHostPort(anycastURL.Hostname(), "443")
+	}
+
+	dialer := tls.Dialer{Config: &tls.Config{MinVersion: tls.VersionTLS12}}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	// the certificate chain has already been verified during the handshake, so
+	// the leaf certificate is the one that matters
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return time.Time{}, fmt.Errorf("expected *tls.Conn, but got %T", conn)
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return time.Time{}, fmt.Errorf("no certificate presented by %s", address)
+	}
+	return certs[0].NotAfter, nil
+}
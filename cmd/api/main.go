@@ -20,9 +20,12 @@
 package apicmd
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/dlmiddlecote/sqlstats"
@@ -43,6 +46,7 @@ import (
 	keppelv1 "github.com/sapcc/keppel/internal/api/keppel"
 	peerv1 "github.com/sapcc/keppel/internal/api/peer"
 	registryv2 "github.com/sapcc/keppel/internal/api/registry"
+	uiapi "github.com/sapcc/keppel/internal/api/ui"
 	"github.com/sapcc/keppel/internal/keppel"
 )
 
@@ -66,11 +70,30 @@ func run(cmd *cobra.Command, args []string) {
 	cfg := keppel.ParseConfiguration()
 	ctx := httpext.ContextWithSIGINT(cmd.Context(), 10*time.Second)
 	auditor := must.Return(keppel.InitAuditTrail(ctx))
+	shutdownTracing := must.Return(keppel.InitTracing(ctx))
+	defer func() {
+		err := shutdownTracing(context.Background())
+		if err != nil {
+			logg.Error("while flushing pending traces: %s", err.Error())
+		}
+	}()
 
 	dbURL, dbName := keppel.GetDatabaseURLFromEnvironment()
 	dbConn := must.Return(easypg.Connect(dbURL, keppel.DBConfiguration()))
 	prometheus.MustRegister(sqlstats.NewStatsCollector(dbName, dbConn))
 	db := keppel.InitORM(dbConn)
+	roURL := must.Return(keppel.GetReadReplicaURLFromEnvironment())
+	if roURL != nil {
+		roConn := must.Return(sql.Open(keppel.PostgresDriverName(), roURL.String()))
+		prometheus.MustRegister(sqlstats.NewStatsCollector(dbName+"_ro", roConn))
+		db.AttachReadReplica(ctx, roConn)
+	}
+	if cfg.AccountCacheTTL > 0 {
+		db.EnableAccountCache(cfg.AccountCacheTTL)
+	}
+	if cfg.AuditEventRetention > 0 {
+		auditor = keppel.PersistAuditEvents(auditor, db)
+	}
 	must.Succeed(setupDBIfRequested(db))
 
 	rc := must.Return(initRedis())
@@ -78,17 +101,41 @@ func run(cmd *cobra.Command, args []string) {
 	fd := must.Return(keppel.NewFederationDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_FEDERATION"), ad, cfg))
 	sd := must.Return(keppel.NewStorageDriver(osext.MustGetenv("KEPPEL_DRIVER_STORAGE"), ad, cfg))
 	icd := must.Return(keppel.NewInboundCacheDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_INBOUND_CACHE"), cfg))
+	cdn := keppel.CDNDriver(nil)
+	cdnDriverName := os.Getenv("KEPPEL_DRIVER_CDN")
+	if cdnDriverName != "" {
+		cdn = must.Return(keppel.NewCDNDriver(cdnDriverName, ad, cfg))
+	}
+	scannerDriverName := keppel.GetScannerDriverNameFromEnvironment()
+	if scannerDriverName != "" {
+		cfg.Scanner = must.Return(keppel.NewScannerDriver(ctx, scannerDriverName, cfg))
+	}
+	usageReportDriverName := keppel.GetUsageReportDriverNameFromEnvironment()
+	if usageReportDriverName != "" {
+		cfg.UsageReporter = must.Return(keppel.NewUsageReportDriver(ctx, usageReportDriverName, cfg))
+	}
+	manifestValidationDriverName := keppel.GetManifestValidationDriverNameFromEnvironment()
+	if manifestValidationDriverName != "" {
+		cfg.ManifestValidator = must.Return(keppel.NewManifestValidationDriver(ctx, manifestValidationDriverName, cfg))
+	}
 
 	rle := (*keppel.RateLimitEngine)(nil)
+	uc := (*keppel.UploadCoordinator)(nil)
 	if rc != nil {
 		rld := must.Return(keppel.NewRateLimitDriver(osext.MustGetenv("KEPPEL_DRIVER_RATELIMIT"), ad, cfg))
 		rle = &keppel.RateLimitEngine{Driver: rld, Client: rc}
+		uc = &keppel.UploadCoordinator{Client: rc}
 	}
 
 	// start background goroutines
 	runPeering(ctx, cfg, db)
 
 	// wire up HTTP handlers
+	isEmbeddedGUIEnabled := osext.GetenvBool("KEPPEL_GUI_EMBEDDED")
+	guiURL := os.Getenv("KEPPEL_GUI_URI")
+	if guiURL == "" && isEmbeddedGUIEnabled {
+		guiURL = cfg.APIPathPrefix + "/ui/%ACCOUNT_NAME%/%REPO_NAME%"
+	}
 	corsMiddleware := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
 		AllowedMethods: []string{"HEAD", "GET", "POST", "PUT", "DELETE"},
@@ -97,8 +144,9 @@ func run(cmd *cobra.Command, args []string) {
 	handler := httpapi.Compose(
 		keppelv1.NewAPI(cfg, ad, fd, sd, icd, db, auditor, rle),
 		auth.NewAPI(cfg, ad, fd, db),
-		registryv2.NewAPI(cfg, ad, fd, sd, icd, db, auditor, rle),
-		peerv1.NewAPI(cfg, ad, db),
+		registryv2.NewAPI(cfg, ad, fd, sd, icd, cdn, db, auditor, rle, uc),
+		peerv1.NewAPI(cfg, ad, sd, db),
+		uiapi.NewAPI(cfg, ad, db, isEmbeddedGUIEnabled),
 		&headerReflector{logg.ShowDebug}, // the header reflection endpoint is only enabled where debugging is enabled (i.e. usually in dev/QA only)
 		httpapi.HealthCheckAPI{
 			SkipRequestLog: true,
@@ -111,10 +159,10 @@ func run(cmd *cobra.Command, args []string) {
 		pprofapi.API{IsAuthorized: pprofapi.IsRequestFromLocalhost},
 		// This needs to be at the end because it is the fallback match for all
 		// paths that are not otherwise defined.
-		&guiRedirecter{db, os.Getenv("KEPPEL_GUI_URI")},
+		&guiRedirecter{db, guiURL},
 	)
 	mux := http.NewServeMux()
-	mux.Handle("/", handler)
+	mux.Handle("/", keppel.WrapHandlerForTracing(stripPathPrefix(cfg.APIPathPrefix, handler)))
 	mux.Handle("/metrics", promhttp.Handler())
 
 	// start HTTP server
@@ -157,11 +205,13 @@ func setupDBIfRequested(db *keppel.DB) error {
 			// clean out all other tables before inserting account
 			"DELETE FROM manifest_blob_refs",
 			"DELETE FROM accounts",
+			"DELETE FROM blocked_digests",
 			"DELETE FROM peers",
 			"DELETE FROM quotas",
 
 			"INSERT INTO accounts (name, auth_tenant_id) VALUES ('conformance-test', 'bogus')",
-			"INSERT INTO quotas (auth_tenant_id, manifests) VALUES ('bogus', 100)",
+			// the tests for OCI Distribution Spec 1.1 push a lot of small artifacts for the Referrers API
+			"INSERT INTO quotas (auth_tenant_id, manifests) VALUES ('bogus', 1000)",
 		}
 
 		for _, query := range queries {
@@ -175,6 +225,24 @@ func setupDBIfRequested(db *keppel.DB) error {
 	return nil
 }
 
+// When keppel-api is mounted below a path prefix by a reverse proxy, requests
+// arrive with that prefix in the URL path. We remove it before routing, but
+// still accept requests without the prefix, e.g. for health checks that do not
+// go through the reverse proxy.
+func stripPathPrefix(prefix string, inner http.Handler) http.Handler {
+	if prefix == "" {
+		return inner
+	}
+	stripped := http.StripPrefix(prefix, inner)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, prefix+"/") {
+			stripped.ServeHTTP(w, r)
+		} else {
+			inner.ServeHTTP(w, r)
+		}
+	})
+}
+
 func reportClientIP(inner http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// This middleware adds the X-Keppel-Your-Ip header to all requests, which is used:
@@ -37,13 +37,21 @@ import (
 )
 
 type peeringConfig []struct {
-	Hostname             string `json:"hostname"`
-	UseForPullDelegation *bool  `json:"use_for_pull_delegation"`
+	Hostname                       string `json:"hostname"`
+	UseForPullDelegation           *bool  `json:"use_for_pull_delegation"`
+	ColdStartReplicationsPerMinute uint32 `json:"cold_start_replications_per_minute"`
+	ClientCertPath                 string `json:"client_cert_path"`
+	ClientKeyPath                  string `json:"client_key_path"`
+	ClientCAPath                   string `json:"client_ca_path"`
 }
 
 var createOrUpdatePeerQuery = sqlext.SimplifyWhitespace(`
-	INSERT INTO peers (hostname, use_for_pull_delegation) VALUES ($1, $2)
-		ON CONFLICT (hostname) DO UPDATE SET use_for_pull_delegation = EXCLUDED.use_for_pull_delegation
+	INSERT INTO peers (hostname, use_for_pull_delegation, cold_start_replications_per_minute, client_cert_path, client_key_path, client_ca_path)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (hostname) DO UPDATE SET use_for_pull_delegation = EXCLUDED.use_for_pull_delegation,
+			cold_start_replications_per_minute = EXCLUDED.cold_start_replications_per_minute,
+			client_cert_path = EXCLUDED.client_cert_path, client_key_path = EXCLUDED.client_key_path,
+			client_ca_path = EXCLUDED.client_ca_path
 `)
 
 func runPeering(ctx context.Context, cfg keppel.Configuration, db *keppel.DB) {
@@ -57,12 +65,16 @@ func runPeering(ctx context.Context, cfg keppel.Configuration, db *keppel.DB) {
 	// add missing entries to `peers` table
 	for _, peer := range peeringCfg {
 		isPeerHostName[peer.Hostname] = true
+		if (peer.ClientCertPath == "") != (peer.ClientKeyPath == "") {
+			logg.Fatal("invalid peering configuration for %s: client_cert_path and client_key_path must be given together", peer.Hostname)
+		}
 
 		useForPullDelegation := true
 		if peer.UseForPullDelegation != nil {
 			useForPullDelegation = *peer.UseForPullDelegation
 		}
-		_ = must.Return(db.Exec(createOrUpdatePeerQuery, peer.Hostname, useForPullDelegation))
+		_ = must.Return(db.Exec(createOrUpdatePeerQuery, peer.Hostname, useForPullDelegation, peer.ColdStartReplicationsPerMinute,
+			peer.ClientCertPath, peer.ClientKeyPath, peer.ClientCAPath))
 	}
 
 	// remove old entries from `peers` table
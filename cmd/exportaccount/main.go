@@ -0,0 +1,101 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package exportaccountcmd
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/must"
+	"github.com/sapcc/go-bits/osext"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/processor"
+)
+
+var (
+	accountName string
+	outputPath  string
+)
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "export-account",
+		Example: "  keppel server export-account --account myaccount --output myaccount.tar",
+		Short:   "Writes the DB records and storage contents of an account into a tar archive.",
+		Long: `Writes the DB records and storage contents of an account into a tar archive that can be restored with "keppel server import-account", possibly into a different Keppel instance.
+The storage driver and the DB are configured through environment variables as described in the operator guide.
+The account should be in read-only mode while the export is ongoing.`,
+		Args: cobra.NoArgs,
+		Run:  run,
+	}
+	cmd.Flags().StringVar(&accountName, "account", "", "The name of the account to export.")
+	cmd.Flags().StringVar(&outputPath, "output", "-", `The path of the file to write the archive into ("-" for stdout).`)
+	must.Succeed(cmd.MarkFlagRequired("account"))
+	parent.AddCommand(cmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	keppel.SetTaskName("export-account")
+
+	cfg := keppel.ParseConfiguration()
+	ctx := cmd.Context()
+
+	dbURL, _ := keppel.GetDatabaseURLFromEnvironment()
+	dbConn := must.Return(easypg.Connect(dbURL, keppel.DBConfiguration()))
+	db := keppel.InitORM(dbConn)
+
+	ad := must.Return(keppel.NewAuthDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_AUTH"), nil))
+	sd := must.Return(keppel.NewStorageDriver(osext.MustGetenv("KEPPEL_DRIVER_STORAGE"), ad, cfg))
+
+	account := must.Return(keppel.FindAccount(db, models.AccountName(accountName)))
+	if account == nil {
+		logg.Fatal("no such account: %q", accountName)
+	}
+
+	var w io.Writer = os.Stdout
+	if outputPath != "-" {
+		file := must.Return(os.Create(outputPath))
+		defer file.Close()
+		w = file
+	}
+	bw := bufio.NewWriter(w)
+
+	// only the storage driver and the DB are needed for this operation
+	proc := processor.New(cfg, db, sd, nil, nil, nil, time.Now)
+	startedAt := time.Now()
+	report, err := proc.ExportAccount(ctx, *account, bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		logg.Fatal("export of account %s failed: %s", account.Name, err.Error())
+	}
+
+	logg.Info("exported %d repos, %d manifests, %d tags and %d blobs (%d bytes) of account %s in %s",
+		report.Repos, report.Manifests, report.Tags, report.Blobs, report.BlobBytes, account.Name,
+		time.Since(startedAt).Round(time.Second))
+}
@@ -0,0 +1,162 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package grypeproxycmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/httpapi/pprofapi"
+	"github.com/sapcc/go-bits/httpext"
+	"github.com/sapcc/go-bits/must"
+	"github.com/sapcc/go-bits/osext"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/drivers/grype"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "grype-proxy",
+		Example: "  keppel grype-proxy",
+		Short:   "Starts a web server which offers the grype proxy API",
+		Long: `Starts a web server which offers the grype proxy API.
+The proxy server is going to exec the grype binary to scan images that it pulls from Keppel.
+The token is used to authenticate API requests to the proxy.`,
+		Run: run,
+	}
+	parent.AddCommand(cmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	keppel.SetTaskName("grype")
+
+	ctx := httpext.ContextWithSIGINT(cmd.Context(), 10*time.Second)
+
+	token := osext.MustGetenv("KEPPEL_GRYPE_TOKEN")
+	dbUpdateURL := os.Getenv("KEPPEL_GRYPE_DB_UPDATE_URL")
+
+	handler := httpapi.Compose(
+		NewAPI(token, dbUpdateURL),
+		httpapi.HealthCheckAPI{SkipRequestLog: true},
+		pprofapi.API{IsAuthorized: pprofapi.IsRequestFromLocalhost},
+	)
+	smux := http.NewServeMux()
+	smux.Handle("/", handler)
+	smux.Handle("/metrics", promhttp.Handler())
+
+	apiListenAddress := osext.GetenvOrDefault("KEPPEL_API_LISTEN_ADDRESS", ":8080")
+	must.Succeed(httpext.ListenAndServeContext(ctx, apiListenAddress, smux))
+}
+
+// API contains state variables used by the Grype API proxy.
+type API struct {
+	token       string
+	dbUpdateURL string
+}
+
+// NewAPI constructs a new API instance.
+func NewAPI(token, dbUpdateURL string) *API {
+	return &API{
+		token:       token,
+		dbUpdateURL: dbUpdateURL,
+	}
+}
+
+// AddTo implements the api.API interface.
+func (a *API) AddTo(r *mux.Router) {
+	r.Methods("GET").Path("/grype").HandlerFunc(a.proxyToGrype)
+}
+
+func (a *API) proxyToGrype(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/grype")
+
+	secretHeader := r.Header[http.CanonicalHeaderKey(grype.TokenHeader)]
+	if !slices.Contains(secretHeader, a.token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	imageURL := r.URL.Query().Get("image")
+	if imageURL == "" {
+		http.Error(w, "image query string must be supplied and cannot be empty", http.StatusUnprocessableEntity)
+		return
+	}
+	imageRef, _, err := models.ParseImageReference(imageURL)
+	if err != nil {
+		http.Error(w, "can't parse image reference: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	keppelToken := r.Header.Get(grype.KeppelTokenHeader)
+
+	stdout, stderr, err := a.runGrype(r.Context(), imageRef, keppelToken)
+	if err != nil {
+		cleanedErr := strings.ReplaceAll(strings.TrimSpace(string(stderr)), "\n", " ")
+		http.Error(w, fmt.Sprintf("grype: %s: %s", err, cleanedErr), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(stdout)
+}
+
+func (a *API) runGrype(ctx context.Context, imageRef models.ImageReference, keppelToken string) (stdout, stderr []byte, err error) {
+	// same timeout as for Trivy
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	//nolint:gosec // intended behaviour
+	cmd := exec.CommandContext(ctx,
+		"grype",
+		"registry:"+imageRef.String(), // pull directly from the registry instead of looking for a container runtime
+		"--output", "json",
+	)
+	// Grype does not take registry credentials on the command line
+	cmd.Env = append(os.Environ(),
+		"GRYPE_REGISTRY_AUTH_AUTHORITY="+imageRef.Host,
+		"GRYPE_REGISTRY_AUTH_TOKEN="+keppelToken,
+		"GRYPE_CHECK_FOR_APP_UPDATE=false",
+	)
+	if a.dbUpdateURL != "" {
+		cmd.Env = append(cmd.Env, "GRYPE_DB_UPDATE_URL="+a.dbUpdateURL)
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.Stdout = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+	cmd.WaitDelay = 3 * time.Second
+	err = cmd.Run()
+
+	return stdoutBuf.Bytes(), stderrBuf.Bytes(), err
+}
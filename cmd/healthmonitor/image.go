@@ -0,0 +1,113 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package healthmonitorcmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ociLayoutImage is a custom test image in the OCI image layout format
+// (see <https://github.com/opencontainers/image-spec/blob/main/image-layout.md>),
+// as given in the --image flag. The layout must contain exactly one image.
+type ociLayoutImage struct {
+	Path string
+}
+
+func (img ociLayoutImage) blobPath(d digest.Digest) string {
+	return filepath.Join(img.Path, imagespec.ImageBlobsDir, d.Algorithm().String(), d.Encoded())
+}
+
+// Upload pushes the image into the repository of the given client, and tags it as "latest".
+func (img ociLayoutImage) Upload(ctx context.Context, rc *client.RepoClient) (models.ManifestReference, error) {
+	buf, err := os.ReadFile(filepath.Join(img.Path, imagespec.ImageIndexFile))
+	if err != nil {
+		return models.ManifestReference{}, err
+	}
+	var index imagespec.Index
+	err = json.Unmarshal(buf, &index)
+	if err != nil {
+		return models.ManifestReference{}, fmt.Errorf("cannot parse %s: %w", imagespec.ImageIndexFile, err)
+	}
+	if len(index.Manifests) != 1 {
+		return models.ManifestReference{}, fmt.Errorf("expected exactly one image in %s, but found %d",
+			imagespec.ImageIndexFile, len(index.Manifests))
+	}
+
+	d, err := img.uploadManifest(ctx, rc, index.Manifests[0], "latest", make(map[digest.Digest]bool))
+	return models.ManifestReference{Digest: d}, err
+}
+
+// Uploads the given manifest after all the blobs and manifests referenced by it.
+func (img ociLayoutImage) uploadManifest(ctx context.Context, rc *client.RepoClient, desc imagespec.Descriptor, tagName string, uploadedBlobs map[digest.Digest]bool) (digest.Digest, error) {
+	contents, err := os.ReadFile(img.blobPath(desc.Digest))
+	if err != nil {
+		return "", err
+	}
+	parsed, _, err := keppel.ParseManifest(desc.MediaType, contents)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse manifest %s: %w", desc.Digest, err)
+	}
+
+	for _, childDesc := range parsed.ManifestReferences(nil) {
+		_, err := img.uploadManifest(ctx, rc, imagespec.Descriptor{MediaType: childDesc.MediaType, Digest: childDesc.Digest}, "", uploadedBlobs)
+		if err != nil {
+			return "", err
+		}
+	}
+	for _, blobDesc := range parsed.BlobReferences() {
+		if uploadedBlobs[blobDesc.Digest] {
+			continue
+		}
+		err := img.uploadBlob(ctx, rc, blobDesc.Digest)
+		if err != nil {
+			return "", err
+		}
+		uploadedBlobs[blobDesc.Digest] = true
+	}
+
+	d, err := rc.UploadManifest(ctx, contents, desc.MediaType, tagName)
+	if err != nil {
+		return "", fmt.Errorf("cannot upload manifest %s: %w", desc.Digest, err)
+	}
+	return d, nil
+}
+
+func (img ociLayoutImage) uploadBlob(ctx context.Context, rc *client.RepoClient, d digest.Digest) error {
+	f, err := os.Open(img.blobPath(d))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = rc.UploadMonolithicBlobFrom(ctx, d, f)
+	if err != nil {
+		return fmt.Errorf("cannot upload blob %s: %w", d, err)
+	}
+	return nil
+}
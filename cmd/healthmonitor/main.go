@@ -47,9 +47,19 @@ intervals. The health check result will be published as a Prometheus metric.
 
 The environment variables must contain credentials for authenticating with the
 authentication method used by the target Keppel API.
+
+By default, a built-in minimal image is used. To exercise realistic image
+sizes, a custom image can be given as a directory in the OCI image layout
+format (as written by "keppel pull" or "skopeo copy ... oci:<dir>").
 `)
 
-var listenAddress string
+var (
+	listenAddress     string
+	imagePath         string
+	tlsClientCertPath string
+	tlsClientKeyPath  string
+	checkInterval     time.Duration
+)
 
 var healthmonitorResultGauge = prometheus.NewGauge(
 	prometheus.GaugeOpts{
@@ -68,6 +78,10 @@ func AddCommandTo(parent *cobra.Command) {
 		Run:   run,
 	}
 	cmd.PersistentFlags().StringVarP(&listenAddress, "listen", "l", ":8080", "Listen address for Prometheus metrics endpoint")
+	cmd.PersistentFlags().StringVar(&imagePath, "image", "", "Directory in the OCI image layout format containing the test image (default: a built-in minimal image)")
+	cmd.PersistentFlags().StringVar(&tlsClientCertPath, "tls-client-cert", "", "Path to a PEM-encoded TLS client certificate to present on the Registry API (requires --tls-client-key)")
+	cmd.PersistentFlags().StringVar(&tlsClientKeyPath, "tls-client-key", "", "Path to the PEM-encoded private key for --tls-client-cert")
+	cmd.PersistentFlags().DurationVar(&checkInterval, "interval", 30*time.Second, "How often the test image is pulled and validated")
 	parent.AddCommand(cmd)
 }
 
@@ -85,6 +99,21 @@ func run(cmd *cobra.Command, args []string) {
 	keppel.SetTaskName("health-monitor")
 	prometheus.MustRegister(healthmonitorResultGauge)
 
+	if checkInterval <= 0 {
+		logg.Fatal("--interval must be positive")
+	}
+	if (tlsClientCertPath == "") != (tlsClientKeyPath == "") {
+		logg.Fatal("--tls-client-cert and --tls-client-key must be given together")
+	}
+	var httpClient *http.Client
+	if tlsClientCertPath != "" {
+		var err error
+		httpClient, err = keppel.NewHTTPClientWithClientCertificate(tlsClientCertPath, tlsClientKeyPath)
+		if err != nil {
+			logg.Fatal("cannot load TLS client certificate: %s", err.Error())
+		}
+	}
+
 	ad, err := client.NewAuthDriver(ctx)
 	if err != nil {
 		logg.Fatal("while setting up auth driver: %s", err.Error())
@@ -95,11 +124,12 @@ func run(cmd *cobra.Command, args []string) {
 		AuthDriver:  ad,
 		AccountName: models.AccountName(args[0]),
 		RepoClient: &client.RepoClient{
-			Scheme:   ad.ServerScheme(),
-			Host:     ad.ServerHost(),
-			RepoName: args[0] + "/healthcheck",
-			UserName: apiUser,
-			Password: apiPassword,
+			Scheme:     ad.ServerScheme(),
+			Host:       ad.ServerHost(),
+			RepoName:   args[0] + "/healthcheck",
+			UserName:   apiUser,
+			Password:   apiPassword,
+			HTTPClient: httpClient,
 		},
 		LastResultLock: &sync.RWMutex{},
 	}
@@ -124,7 +154,7 @@ func run(cmd *cobra.Command, args []string) {
 
 	// enter long-running check loop
 	job.ValidateImage(ctx, manifestRef) // once immediately to initialize the metric
-	tick := time.Tick(30 * time.Second)
+	tick := time.Tick(checkInterval)
 	for {
 		select {
 		case <-ctx.Done():
@@ -163,8 +193,13 @@ func (j *healthMonitorJob) PrepareKeppelAccount(ctx context.Context) error {
 	return nil
 }
 
-// Uploads a minimal complete image (one config blob, one layer blob and one manifest) for testing.
+// Uploads the image for testing: either the custom image from --image, or a
+// minimal complete image (one config blob, one layer blob and one manifest).
 func (j *healthMonitorJob) UploadImage(ctx context.Context) (models.ManifestReference, error) {
+	if imagePath != "" {
+		return ociLayoutImage{imagePath}.Upload(ctx, j.RepoClient)
+	}
+
 	_, err := j.RepoClient.UploadMonolithicBlob(ctx, []byte(minimalImageConfiguration))
 	if err != nil {
 		return models.ManifestReference{}, err
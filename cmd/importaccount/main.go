@@ -0,0 +1,86 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package importaccountcmd
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/must"
+	"github.com/sapcc/go-bits/osext"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/processor"
+)
+
+var inputPath string
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "import-account",
+		Example: "  keppel server import-account --input myaccount.tar",
+		Short:   "Restores an account from a tar archive written by export-account.",
+		Long: `Restores the DB records and storage contents of an account from a tar archive written by "keppel server export-account".
+The account must not exist yet in this Keppel instance. Its name is claimed through the federation driver like for a newly created account.
+The storage driver, federation driver and the DB are configured through environment variables as described in the operator guide.`,
+		Args: cobra.NoArgs,
+		Run:  run,
+	}
+	cmd.Flags().StringVar(&inputPath, "input", "-", `The path of the file to read the archive from ("-" for stdin).`)
+	parent.AddCommand(cmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	keppel.SetTaskName("import-account")
+
+	cfg := keppel.ParseConfiguration()
+	ctx := cmd.Context()
+
+	dbURL, _ := keppel.GetDatabaseURLFromEnvironment()
+	dbConn := must.Return(easypg.Connect(dbURL, keppel.DBConfiguration()))
+	db := keppel.InitORM(dbConn)
+
+	ad := must.Return(keppel.NewAuthDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_AUTH"), nil))
+	sd := must.Return(keppel.NewStorageDriver(osext.MustGetenv("KEPPEL_DRIVER_STORAGE"), ad, cfg))
+	fd := must.Return(keppel.NewFederationDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_FEDERATION"), ad, cfg))
+
+	var r io.Reader = os.Stdin
+	if inputPath != "-" {
+		file := must.Return(os.Open(inputPath))
+		defer file.Close()
+		r = file
+	}
+
+	proc := processor.New(cfg, db, sd, nil, nil, fd, time.Now)
+	startedAt := time.Now()
+	account, report, err := proc.ImportAccount(ctx, bufio.NewReader(r))
+	if err != nil {
+		logg.Fatal("import failed: %s", err.Error())
+	}
+
+	logg.Info("imported %d repos, %d manifests, %d tags and %d blobs (%d bytes) into account %s in %s",
+		report.Repos, report.Manifests, report.Tags, report.Blobs, report.BlobBytes, account.Name,
+		time.Since(startedAt).Round(time.Second))
+}
@@ -20,6 +20,7 @@ package janitorcmd
 
 import (
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/dlmiddlecote/sqlstats"
@@ -29,7 +30,6 @@ import (
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/httpapi/pprofapi"
 	"github.com/sapcc/go-bits/httpext"
-	"github.com/sapcc/go-bits/jobloop"
 	"github.com/sapcc/go-bits/must"
 	"github.com/sapcc/go-bits/osext"
 	"github.com/spf13/cobra"
@@ -61,29 +61,82 @@ func run(cmd *cobra.Command, args []string) {
 	dbConn := must.Return(easypg.Connect(dbURL, keppel.DBConfiguration()))
 	prometheus.MustRegister(sqlstats.NewStatsCollector(dbName, dbConn))
 	db := keppel.InitORM(dbConn)
+	if cfg.AuditEventRetention > 0 {
+		auditor = keppel.PersistAuditEvents(auditor, db)
+	}
 
 	ad := must.Return(keppel.NewAuthDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_AUTH"), nil))
 	amd := must.Return(keppel.NewAccountManagementDriver(osext.MustGetenv("KEPPEL_DRIVER_ACCOUNT_MANAGEMENT")))
 	fd := must.Return(keppel.NewFederationDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_FEDERATION"), ad, cfg))
 	sd := must.Return(keppel.NewStorageDriver(osext.MustGetenv("KEPPEL_DRIVER_STORAGE"), ad, cfg))
+	sd = keppel.ThrottleStorageDriver(sd, cfg.JanitorStorageOpsPerSecond)
 	icd := must.Return(keppel.NewInboundCacheDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_INBOUND_CACHE"), cfg))
+	cdn := keppel.CDNDriver(nil)
+	cdnDriverName := os.Getenv("KEPPEL_DRIVER_CDN")
+	if cdnDriverName != "" {
+		cdn = must.Return(keppel.NewCDNDriver(cdnDriverName, ad, cfg))
+	}
+	scannerDriverName := keppel.GetScannerDriverNameFromEnvironment()
+	if scannerDriverName != "" {
+		cfg.Scanner = must.Return(keppel.NewScannerDriver(ctx, scannerDriverName, cfg))
+	}
+	usageReportDriverName := keppel.GetUsageReportDriverNameFromEnvironment()
+	if usageReportDriverName != "" {
+		cfg.UsageReporter = must.Return(keppel.NewUsageReportDriver(ctx, usageReportDriverName, cfg))
+	}
+	manifestValidationDriverName := keppel.GetManifestValidationDriverNameFromEnvironment()
+	if manifestValidationDriverName != "" {
+		cfg.ManifestValidator = must.Return(keppel.NewManifestValidationDriver(ctx, manifestValidationDriverName, cfg))
+	}
 
 	// start task loops
-	janitor := tasks.NewJanitor(cfg, fd, sd, icd, db, amd, auditor)
+	janitor := tasks.NewJanitor(cfg, fd, sd, icd, cdn, db, amd, auditor)
 	go janitor.AccountFederationAnnouncementJob(nil).Run(ctx)
+	go janitor.AccountConfigSyncJob(nil).Run(ctx)
+	go janitor.UpstreamHealthCheckJob(nil).Run(ctx)
 	go janitor.AbandonedUploadCleanupJob(nil).Run(ctx)
+	go janitor.OrphanedReferrerCleanupJob(nil).Run(ctx)
 	go janitor.DeleteAccountsJob(nil).Run(ctx)
 	go janitor.EnforceManagedAccountsJob(nil).Run(ctx)
 	go janitor.ManifestGarbageCollectionJob(nil).Run(ctx)
+	go janitor.GCRunCleanupJob(nil).Run(ctx)
 	go janitor.BlobMountSweepJob(nil).Run(ctx)
 	go janitor.BlobSweepJob(nil).Run(ctx)
 	go janitor.StorageSweepJob(nil).Run(ctx)
 	go janitor.ManifestSyncJob(nil).Run(ctx)
 	go janitor.BlobValidationJob(nil).Run(ctx)
-	go janitor.ManifestValidationJob(nil).Run(ctx)
-	if cfg.Trivy != nil {
-		go janitor.CheckTrivySecurityStatusJob(nil).Run(ctx, jobloop.NumGoroutines(3))
+	go janitor.BlobPrefetchJob(nil).Run(ctx)
+	go janitor.ManifestValidationJob(nil).Run(ctx, janitor.NumGoroutines("manifest_validation", 1))
+	go janitor.ManifestPlatformCheckJob(nil).Run(ctx)
+	go janitor.SignatureVerificationJob(nil).Run(ctx)
+	go janitor.AttestationVerificationJob(nil).Run(ctx)
+	go janitor.ReplicaConsistencyCheckJob(nil).Run(ctx)
+	go janitor.ColdStartReplicationJob(nil).Run(ctx)
+	go janitor.ManifestVariantGenerationJob(nil).Run(ctx)
+	if cfg.AuditEventRetention > 0 {
+		go janitor.AuditEventCleanupJob(nil).Run(ctx)
+	}
+	if cfg.EOLReportInterval > 0 {
+		go janitor.EOLReportJob(nil).Run(ctx)
+	}
+	if cfg.AccountMetricsEnabled {
+		go janitor.AccountMetricsJob(nil).Run(ctx)
+	}
+	if cfg.NormalizeReferrersFallbackTags {
+		go janitor.ReferrersFallbackTagBackfillJob(nil).Run(ctx)
+	}
+	if cfg.UsageReporter != nil {
+		go janitor.UsageReportJob(nil).Run(ctx)
+	}
+	if cfg.GlobalBlobStore != nil {
+		go janitor.GlobalBlobDeduplicationJob(nil).Run(ctx)
+		go janitor.GlobalBlobStorageSweepJob(nil).Run(ctx)
+	}
+	if cfg.Scanner != nil {
+		go janitor.CheckTrivySecurityStatusJob(nil).Run(ctx, janitor.NumGoroutines("trivy_security_check", 3))
+		go janitor.SecuritySummarySnapshotJob(nil).Run(ctx)
 	}
+	go janitor.JobStatusReportJob(nil).Run(ctx)
 
 	// start HTTP server for Prometheus metrics and health check
 	handler := httpapi.Compose(
@@ -0,0 +1,101 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package migratestoragecmd
+
+import (
+	"time"
+
+	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/must"
+	"github.com/sapcc/go-bits/osext"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/processor"
+)
+
+var (
+	sourceDriverName string
+	targetDriverName string
+	accountName      string
+)
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "migrate-storage",
+		Example: "  keppel server migrate-storage --from swift --to filesystem --account myaccount",
+		Short:   "Copies the contents of an account from one storage driver into another.",
+		Long: `Copies all blobs and manifests of an account from one storage driver into another, verifying their digests along the way.
+Both storage drivers are configured through environment variables as described in the operator guide.
+The account must be in read-only mode while the migration is ongoing.
+Once all accounts have been migrated, KEPPEL_DRIVER_STORAGE can be switched to the target storage driver.`,
+		Args: cobra.NoArgs,
+		Run:  run,
+	}
+	cmd.Flags().StringVar(&sourceDriverName, "from", "", "The storage driver to copy from.")
+	cmd.Flags().StringVar(&targetDriverName, "to", "", "The storage driver to copy into.")
+	cmd.Flags().StringVar(&accountName, "account", "", "The name of the account whose contents shall be copied.")
+	must.Succeed(cmd.MarkFlagRequired("from"))
+	must.Succeed(cmd.MarkFlagRequired("to"))
+	must.Succeed(cmd.MarkFlagRequired("account"))
+	parent.AddCommand(cmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	keppel.SetTaskName("migrate-storage")
+	if sourceDriverName == targetDriverName {
+		logg.Fatal("source and target storage driver must be different")
+	}
+
+	cfg := keppel.ParseConfiguration()
+	ctx := cmd.Context()
+
+	dbURL, _ := keppel.GetDatabaseURLFromEnvironment()
+	dbConn := must.Return(easypg.Connect(dbURL, keppel.DBConfiguration()))
+	db := keppel.InitORM(dbConn)
+
+	ad := must.Return(keppel.NewAuthDriver(ctx, osext.MustGetenv("KEPPEL_DRIVER_AUTH"), nil))
+	sourceSD := must.Return(keppel.NewStorageDriver(sourceDriverName, ad, cfg))
+	targetSD := must.Return(keppel.NewStorageDriver(targetDriverName, ad, cfg))
+
+	account := must.Return(keppel.FindReducedAccount(db, models.AccountName(accountName)))
+	if account == nil {
+		logg.Fatal("no such account: %q", accountName)
+	}
+
+	// only the storage driver and the DB are needed for this operation
+	proc := processor.New(cfg, db, sourceSD, nil, nil, nil, time.Now)
+	startedAt := time.Now()
+	report, err := proc.MigrateAccountStorage(ctx, *account, targetSD)
+	if err != nil {
+		logg.Fatal("storage migration failed: %s", err.Error())
+	}
+
+	logg.Info("copied %d blobs (%d bytes) and %d manifests of account %s from %s to %s in %s",
+		report.CopiedBlobs, report.CopiedBytes, report.CopiedManifests, account.Name,
+		sourceDriverName, targetDriverName, time.Since(startedAt).Round(time.Second))
+	if report.StrayBlobs > 0 || report.StrayManifests > 0 {
+		logg.Info("skipped %d blobs and %d manifests in the source storage that are not referenced in the DB",
+			report.StrayBlobs, report.StrayManifests)
+	}
+}
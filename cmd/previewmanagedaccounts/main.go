@@ -0,0 +1,78 @@
+/*******************************************************************************
+*
+* Copyright 2026 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package previewmanagedaccountscmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/must"
+	"github.com/sapcc/go-bits/osext"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/tasks"
+)
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "preview-managed-accounts",
+		Example: "  keppel server preview-managed-accounts",
+		Short:   "Shows how keppel-janitor would change managed accounts.",
+		Long: `Shows which managed accounts keppel-janitor would create, update or delete based on the current configuration of the account management driver, and which fields of each account would change.
+The account management driver and the database are configured through environment variables in the same way as for keppel-janitor.
+The result is printed to stdout as JSON. No changes are made.
+This is intended to be used e.g. for checking changes to the account management configuration in CI before they are deployed.`,
+		Args: cobra.NoArgs,
+		Run:  run,
+	}
+	parent.AddCommand(cmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	keppel.SetTaskName("preview-managed-accounts")
+
+	cfg := keppel.ParseConfiguration()
+	ctx := cmd.Context()
+
+	dbURL, _ := keppel.GetDatabaseURLFromEnvironment()
+	dbConn := must.Return(easypg.Connect(dbURL, keppel.DBConfiguration()))
+	db := keppel.InitORM(dbConn)
+
+	amd := must.Return(keppel.NewAccountManagementDriver(osext.MustGetenv("KEPPEL_DRIVER_ACCOUNT_MANAGEMENT")))
+	// the scanner driver is needed because quarantine policies are only valid if it is configured
+	scannerDriverName := keppel.GetScannerDriverNameFromEnvironment()
+	if scannerDriverName != "" {
+		cfg.Scanner = must.Return(keppel.NewScannerDriver(ctx, scannerDriverName, cfg))
+	}
+
+	// only the account management driver and the DB are needed for this operation
+	janitor := tasks.NewJanitor(cfg, nil, nil, nil, nil, db, amd, nil)
+	changes := must.Return(janitor.PreviewManagedAccounts())
+	if changes == nil {
+		changes = []tasks.ManagedAccountChange{}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	must.Succeed(enc.Encode(changes))
+}
@@ -0,0 +1,264 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package pullcmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go"
+	imagespecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var (
+	authUserName      string
+	authPassword      string
+	platformFilterStr string
+)
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "pull <image> <dir>",
+		Example: "  keppel pull registry.example.org/library/alpine:3.9 ./alpine",
+		Short:   "Pulls an image from a registry into a local directory.",
+		Long: `Pulls an image from a registry into a local directory.
+The directory is written in the OCI image layout format, and can be used as input for "keppel push" or "skopeo copy oci:<dir> ...".
+If the directory already contains an OCI image layout, the pulled image is added to it.`,
+		Args: cobra.ExactArgs(2),
+		Run:  run,
+	}
+	cmd.PersistentFlags().StringVarP(&authUserName, "username", "u", "", "User name (only required for non-public images).")
+	cmd.PersistentFlags().StringVarP(&authPassword, "password", "p", "", "Password (only required for non-public images).")
+	cmd.PersistentFlags().StringVar(&platformFilterStr, "platform-filter", "[]", "When pulling a multi-architecture image, only recurse into the contained images matching one of the given platforms. The filter must be given as a JSON array of objects matching each having the same format as the `manifests[].platform` field in the <https://github.com/opencontainers/image-spec/blob/master/image-index.md>.")
+	parent.AddCommand(cmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	var platformFilter models.PlatformFilter
+	err := json.Unmarshal([]byte(platformFilterStr), &platformFilter)
+	if err != nil {
+		logg.Fatal("cannot parse platform filter: " + err.Error())
+	}
+
+	ref, interpretation, err := models.ParseImageReference(args[0])
+	logg.Info("interpreting %s as %s", args[0], interpretation)
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+
+	p := puller{
+		Client: &client.RepoClient{
+			Host:     ref.Host,
+			RepoName: ref.RepoName,
+			UserName: authUserName,
+			Password: authPassword,
+		},
+		Path:           args[1],
+		PlatformFilter: platformFilter,
+	}
+	desc, err := p.pullManifest(cmd.Context(), ref.Reference, 0)
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+	if ref.Reference.IsTag() {
+		desc.Annotations = map[string]string{imagespecv1.AnnotationRefName: ref.Reference.Tag}
+	}
+	err = p.writeIndex(desc)
+	if err != nil {
+		logg.Fatal("cannot write %s: %s", args[1], err.Error())
+	}
+	logg.Info("pulled %s@%s into %s", ref.RepoName, desc.Digest, args[1])
+}
+
+type puller struct {
+	Client         *client.RepoClient
+	Path           string
+	PlatformFilter models.PlatformFilter
+}
+
+// Pulls the given manifest and all the blobs and manifests referenced by it.
+func (p puller) pullManifest(ctx context.Context, reference models.ManifestReference, level int) (imagespecv1.Descriptor, error) {
+	contents, mediaType, err := p.Client.DownloadManifest(ctx, reference, nil)
+	if err != nil {
+		return imagespecv1.Descriptor{}, fmt.Errorf("cannot pull manifest %s: %w", reference, err)
+	}
+	parsed, desc, err := keppel.ParseManifest(mediaType, contents)
+	if err != nil {
+		return imagespecv1.Descriptor{}, fmt.Errorf("cannot parse manifest %s: %w", reference, err)
+	}
+	if reference.IsDigest() && desc.Digest != reference.Digest {
+		return imagespecv1.Descriptor{}, fmt.Errorf("manifest %s has actual digest %s", reference, desc.Digest)
+	}
+
+	for _, childDesc := range parsed.ManifestReferences(p.PlatformFilter) {
+		_, err := p.pullManifest(ctx, models.ManifestReference{Digest: childDesc.Digest}, level+1)
+		if err != nil {
+			return imagespecv1.Descriptor{}, err
+		}
+	}
+
+	for _, blobDesc := range parsed.BlobReferences() {
+		err := p.pullBlob(ctx, blobDesc.Digest)
+		if err != nil {
+			return imagespecv1.Descriptor{}, err
+		}
+		logg.Info("%spulled blob     %s", strings.Repeat("  ", level+1), blobDesc.Digest)
+	}
+
+	err = p.writeFile(p.blobPath(desc.Digest), func(w io.Writer) error {
+		_, err := w.Write(contents)
+		return err
+	})
+	if err != nil {
+		return imagespecv1.Descriptor{}, err
+	}
+	logg.Info("%spulled manifest %s", strings.Repeat("  ", level), desc.Digest)
+
+	return imagespecv1.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+	}, nil
+}
+
+func (p puller) pullBlob(ctx context.Context, d digest.Digest) error {
+	path := p.blobPath(d)
+	_, err := os.Stat(path)
+	if err == nil {
+		// blob was already pulled (e.g. a layer shared between multiple images)
+		return nil
+	}
+
+	contents, _, err := p.Client.DownloadBlob(ctx, d)
+	if err != nil {
+		return fmt.Errorf("cannot pull blob %s: %w", d, err)
+	}
+	defer contents.Close()
+
+	return p.writeFile(path, func(w io.Writer) error {
+		verifier := d.Verifier()
+		_, err := io.Copy(io.MultiWriter(w, verifier), contents)
+		if err != nil {
+			return fmt.Errorf("cannot pull blob %s: %w", d, err)
+		}
+		if !verifier.Verified() {
+			return fmt.Errorf("cannot pull blob %s: digest mismatch", d)
+		}
+		return nil
+	})
+}
+
+func (p puller) blobPath(d digest.Digest) string {
+	return filepath.Join(p.Path, imagespecv1.ImageBlobsDir, d.Algorithm().String(), d.Encoded())
+}
+
+// Writes a file atomically, so that an interrupted pull does not leave broken
+// blobs behind that would be skipped by the next pull.
+func (p puller) writeFile(path string, write func(io.Writer) error) error {
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op on success because of the rename below
+
+	err = write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Adds the given image to the OCI layout's index.json, replacing any existing
+// image with the same tag.
+func (p puller) writeIndex(desc imagespecv1.Descriptor) error {
+	index := imagespecv1.Index{
+		Versioned: imagespec.Versioned{SchemaVersion: 2},
+		MediaType: imagespecv1.MediaTypeImageIndex,
+	}
+	indexPath := filepath.Join(p.Path, imagespecv1.ImageIndexFile)
+	buf, err := os.ReadFile(indexPath)
+	switch {
+	case err == nil:
+		err = json.Unmarshal(buf, &index)
+		if err != nil {
+			return fmt.Errorf("cannot parse %s: %w", imagespecv1.ImageIndexFile, err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		// start with an empty index
+	default:
+		return err
+	}
+
+	manifests := []imagespecv1.Descriptor{}
+	for _, existing := range index.Manifests {
+		isReplaced := existing.Digest == desc.Digest
+		if tagName := desc.Annotations[imagespecv1.AnnotationRefName]; tagName != "" {
+			isReplaced = isReplaced || existing.Annotations[imagespecv1.AnnotationRefName] == tagName
+		}
+		if !isReplaced {
+			manifests = append(manifests, existing)
+		}
+	}
+	index.Manifests = append(manifests, desc)
+
+	buf, err = json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	err = p.writeFile(indexPath, func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	buf, err = json.Marshal(imagespecv1.ImageLayout{Version: imagespecv1.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	return p.writeFile(filepath.Join(p.Path, imagespecv1.ImageLayoutFile), func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+}
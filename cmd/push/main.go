@@ -0,0 +1,266 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package pushcmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/spf13/cobra"
+
+	"github.com/sapcc/keppel/internal/client"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var (
+	authUserName string
+	authPassword string
+)
+
+// AddCommandTo mounts this command into the command hierarchy.
+func AddCommandTo(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "push <dir-or-oci-layout> <image>",
+		Example: "  keppel push ./alpine registry.example.org/library/alpine:3.9",
+		Short:   "Pushes an image from a local directory into a registry.",
+		Long: `Pushes an image from a local directory into a registry.
+The directory can either be in the OCI image layout format (as written by "keppel pull" or "skopeo copy ... oci:<dir>"),
+or in the "dir" format written by "skopeo copy ... dir:<dir>".
+If an OCI image layout contains multiple images, the one whose "org.opencontainers.image.ref.name" annotation matches the tag of the target image is pushed.`,
+		Args: cobra.ExactArgs(2),
+		Run:  run,
+	}
+	cmd.PersistentFlags().StringVarP(&authUserName, "username", "u", "", "User name (only required for non-public images).")
+	cmd.PersistentFlags().StringVarP(&authPassword, "password", "p", "", "Password (only required for non-public images).")
+	parent.AddCommand(cmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ref, interpretation, err := models.ParseImageReference(args[1])
+	logg.Info("interpreting %s as %s", args[1], interpretation)
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+
+	src, err := openImageSource(args[0], ref.Reference.Tag)
+	if err != nil {
+		logg.Fatal("cannot read %s: %s", args[0], err.Error())
+	}
+
+	c := &client.RepoClient{
+		Host:     ref.Host,
+		RepoName: ref.RepoName,
+		UserName: authUserName,
+		Password: authPassword,
+	}
+	p := pusher{
+		Client:      c,
+		Source:      src,
+		PushedBlobs: make(map[digest.Digest]bool),
+	}
+	contents, mediaType, err := src.TopManifest()
+	if err != nil {
+		logg.Fatal("cannot read %s: %s", args[0], err.Error())
+	}
+	d, err := p.pushManifest(cmd.Context(), contents, mediaType, ref.Reference.Tag, 0)
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+	if ref.Reference.IsDigest() && d != ref.Reference.Digest {
+		logg.Fatal("pushed manifest has digest %s, but %s was expected", d, ref.Reference.Digest)
+	}
+	logg.Info("pushed %s@%s", ref.RepoName, d)
+}
+
+type pusher struct {
+	Client      *client.RepoClient
+	Source      imageSource
+	PushedBlobs map[digest.Digest]bool
+}
+
+// Pushes the given manifest after all the blobs and manifests referenced by it.
+func (p pusher) pushManifest(ctx context.Context, contents []byte, mediaType, tagName string, level int) (digest.Digest, error) {
+	parsed, desc, err := keppel.ParseManifest(mediaType, contents)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse manifest: %w", err)
+	}
+
+	for _, childDesc := range parsed.ManifestReferences(nil) {
+		childContents, err := p.Source.ReadManifest(childDesc.Digest)
+		if err != nil {
+			return "", err
+		}
+		_, err = p.pushManifest(ctx, childContents, childDesc.MediaType, "", level+1)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, blobDesc := range parsed.BlobReferences() {
+		if p.PushedBlobs[blobDesc.Digest] {
+			continue
+		}
+		err := p.pushBlob(ctx, blobDesc.Digest)
+		if err != nil {
+			return "", err
+		}
+		logg.Info("%spushed blob     %s", strings.Repeat("  ", level), blobDesc.Digest)
+		p.PushedBlobs[blobDesc.Digest] = true
+	}
+
+	d, err := p.Client.UploadManifest(ctx, contents, desc.MediaType, tagName)
+	if err != nil {
+		return "", fmt.Errorf("cannot push manifest %s: %w", desc.Digest, err)
+	}
+	logg.Info("%spushed manifest %s", strings.Repeat("  ", level), d)
+	return d, nil
+}
+
+func (p pusher) pushBlob(ctx context.Context, d digest.Digest) error {
+	f, err := p.Source.OpenBlob(d)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = p.Client.UploadMonolithicBlobFrom(ctx, d, f)
+	if err != nil {
+		return fmt.Errorf("cannot push blob %s: %w", d, err)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// image sources
+
+// imageSource is a local directory containing an image.
+type imageSource interface {
+	// TopManifest returns the manifest that shall be pushed.
+	TopManifest() (contents []byte, mediaType string, err error)
+	ReadManifest(d digest.Digest) ([]byte, error)
+	OpenBlob(d digest.Digest) (*os.File, error)
+}
+
+func openImageSource(path, tagName string) (imageSource, error) {
+	_, err := os.Stat(filepath.Join(path, imagespec.ImageLayoutFile))
+	switch {
+	case err == nil:
+		return ociLayoutSource{path, tagName}, nil
+	case errors.Is(err, os.ErrNotExist):
+		return dirSource{path}, nil
+	default:
+		return nil, err
+	}
+}
+
+// ociLayoutSource is an imageSource in the OCI image layout format
+// (see <https://github.com/opencontainers/image-spec/blob/main/image-layout.md>).
+type ociLayoutSource struct {
+	Path    string
+	TagName string
+}
+
+func (s ociLayoutSource) TopManifest() ([]byte, string, error) {
+	buf, err := os.ReadFile(filepath.Join(s.Path, imagespec.ImageIndexFile))
+	if err != nil {
+		return nil, "", err
+	}
+	var index imagespec.Index
+	err = json.Unmarshal(buf, &index)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot parse %s: %w", imagespec.ImageIndexFile, err)
+	}
+
+	var candidates []imagespec.Descriptor
+	if len(index.Manifests) == 1 {
+		candidates = index.Manifests
+	} else {
+		for _, desc := range index.Manifests {
+			if s.TagName != "" && desc.Annotations[imagespec.AnnotationRefName] == s.TagName {
+				candidates = append(candidates, desc)
+			}
+		}
+	}
+	if len(candidates) != 1 {
+		return nil, "", fmt.Errorf("expected exactly one image with %s = %q, but found %d",
+			imagespec.AnnotationRefName, s.TagName, len(candidates))
+	}
+
+	contents, err := s.ReadManifest(candidates[0].Digest)
+	return contents, candidates[0].MediaType, err
+}
+
+func (s ociLayoutSource) blobPath(d digest.Digest) string {
+	return filepath.Join(s.Path, imagespec.ImageBlobsDir, d.Algorithm().String(), d.Encoded())
+}
+
+func (s ociLayoutSource) ReadManifest(d digest.Digest) ([]byte, error) {
+	return os.ReadFile(s.blobPath(d))
+}
+
+func (s ociLayoutSource) OpenBlob(d digest.Digest) (*os.File, error) {
+	return os.Open(s.blobPath(d))
+}
+
+// dirSource is an imageSource in the format written by `skopeo copy ... dir:<path>`.
+type dirSource struct {
+	Path string
+}
+
+func (s dirSource) TopManifest() ([]byte, string, error) {
+	contents, err := os.ReadFile(filepath.Join(s.Path, "manifest.json"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	// this format does not record the media type outside of the manifest itself
+	var data struct {
+		MediaType string          `json:"mediaType"`
+		Manifests json.RawMessage `json:"manifests"`
+	}
+	err = json.Unmarshal(contents, &data)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot parse manifest.json: %w", err)
+	}
+	switch {
+	case data.MediaType != "":
+		return contents, data.MediaType, nil
+	case data.Manifests != nil:
+		return contents, imagespec.MediaTypeImageIndex, nil
+	default:
+		return contents, imagespec.MediaTypeImageManifest, nil
+	}
+}
+
+func (s dirSource) ReadManifest(d digest.Digest) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.Path, d.Encoded()+".manifest.json"))
+}
+
+func (s dirSource) OpenBlob(d digest.Digest) (*os.File, error) {
+	return os.Open(filepath.Join(s.Path, d.Encoded()))
+}
@@ -0,0 +1,194 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package trivyproxycmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/sapcc/keppel/internal/trivy"
+)
+
+var (
+	resultCacheHitCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "keppel_trivy_proxy_result_cache_hits",
+			Help: "Counter for scan requests that were served from the result cache.",
+		},
+	)
+	resultCacheMissCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "keppel_trivy_proxy_result_cache_misses",
+			Help: "Counter for scan requests that could not be served from the result cache.",
+		},
+	)
+)
+
+// How long the version of the Trivy DB is remembered before asking the Trivy server again.
+const dbVersionRefreshInterval = 1 * time.Minute
+
+// resultCache is an in-memory cache for scan results. Results are keyed by
+// the image digest and the version of the Trivy DB on the Trivy server, so
+// that results are reused across repositories and registries containing the
+// same image, but not across DB updates.
+type resultCache struct {
+	ttl        time.Duration
+	maxEntries int
+	trivyURL   string
+	token      string
+
+	mutex   sync.Mutex
+	entries map[string]resultCacheEntry
+
+	dbVersionMutex     sync.Mutex
+	dbVersion          string
+	dbVersionCheckedAt time.Time
+}
+
+type resultCacheEntry struct {
+	Contents  []byte
+	ExpiresAt time.Time
+}
+
+func newResultCache(ttl time.Duration, maxEntries int, trivyURL, token string) *resultCache {
+	return &resultCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		trivyURL:   trivyURL,
+		token:      token,
+		entries:    make(map[string]resultCacheEntry),
+	}
+}
+
+// Key returns the cache key for a scan of the given image, or false if the
+// result of this scan shall not be cached (e.g. because the image is not
+// referenced by digest).
+func (c *resultCache) Key(ctx context.Context, imageURL, format string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	_, digestStr, found := strings.Cut(imageURL, "@")
+	if !found {
+		return "", false
+	}
+	imageDigest, err := digest.Parse(digestStr)
+	if err != nil {
+		return "", false
+	}
+	dbVersion, err := c.getDBVersion(ctx)
+	if err != nil {
+		// not fatal, we can still scan without caching the result
+		logg.Error(err.Error())
+		return "", false
+	}
+	return fmt.Sprintf("%s/%s/%s", imageDigest, format, dbVersion), true
+}
+
+// Get returns the cached result for the given key, if any.
+func (c *resultCache) Get(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.ExpiresAt) {
+		resultCacheMissCounter.Inc()
+		return nil, false
+	}
+	resultCacheHitCounter.Inc()
+	return entry.Contents, true
+}
+
+// Put stores the given result in the cache.
+func (c *resultCache) Put(key string, contents []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.ExpiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	// if the cache is still full, evict the entry that would expire first
+	if len(c.entries) >= c.maxEntries {
+		var oldestKey string
+		var oldestExpiresAt time.Time
+		for k, entry := range c.entries {
+			if oldestKey == "" || entry.ExpiresAt.Before(oldestExpiresAt) {
+				oldestKey = k
+				oldestExpiresAt = entry.ExpiresAt
+			}
+		}
+		delete(c.entries, oldestKey)
+	}
+	c.entries[key] = resultCacheEntry{
+		Contents:  contents,
+		ExpiresAt: now.Add(c.ttl),
+	}
+}
+
+func (c *resultCache) getDBVersion(ctx context.Context) (string, error) {
+	c.dbVersionMutex.Lock()
+	defer c.dbVersionMutex.Unlock()
+	if c.dbVersion != "" && time.Since(c.dbVersionCheckedAt) < dbVersionRefreshInterval {
+		return c.dbVersion, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.trivyURL, "/")+"/version", http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(trivy.TokenHeader, c.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot get version of Trivy DB: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cannot get version of Trivy DB: expected 200, but got %s", resp.Status)
+	}
+
+	// see type VersionInfo in <https://github.com/aquasecurity/trivy/blob/main/pkg/version/app/version.go>
+	var data struct {
+		VulnerabilityDB *struct {
+			Version   int       `json:"Version"`
+			UpdatedAt time.Time `json:"UpdatedAt"`
+		} `json:"VulnerabilityDB"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return "", fmt.Errorf("cannot get version of Trivy DB: %w", err)
+	}
+	if data.VulnerabilityDB == nil {
+		return "", fmt.Errorf("cannot get version of Trivy DB: no DB metadata reported by %s", c.trivyURL)
+	}
+
+	c.dbVersion = fmt.Sprintf("v%d-%d", data.VulnerabilityDB.Version, data.VulnerabilityDB.UpdatedAt.Unix())
+	c.dbVersionCheckedAt = time.Now()
+	return c.dbVersion, nil
+}
@@ -26,6 +26,7 @@ import (
 	"os"
 	"os/exec"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -33,10 +34,12 @@ import (
 	"github.com/sapcc/keppel/internal/trivy"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/httpapi/pprofapi"
 	"github.com/sapcc/go-bits/httpext"
+	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/go-bits/must"
 	"github.com/sapcc/go-bits/osext"
 	"github.com/spf13/cobra"
@@ -63,9 +66,24 @@ func run(cmd *cobra.Command, args []string) {
 	token := osext.MustGetenv("KEPPEL_TRIVY_TOKEN")
 	dbMirrorPrefix := osext.MustGetenv("KEPPEL_TRIVY_DB_MIRROR_PREFIX")
 	trivyURL := osext.MustGetenv("KEPPEL_TRIVY_URL")
+	maxConcurrentScans := getenvPositiveInt("KEPPEL_TRIVY_MAX_CONCURRENT_SCANS", "4")
+	maxQueuedScans := getenvPositiveInt("KEPPEL_TRIVY_MAX_QUEUED_SCANS", "20")
+	prometheus.MustRegister(queuedScansGauge, runningScansGauge, rejectedScansCounter, scanDurationHistogram)
+
+	api := NewAPI(dbMirrorPrefix, token, trivyURL, maxConcurrentScans, maxQueuedScans)
+	cacheTTLStr := osext.GetenvOrDefault("KEPPEL_TRIVY_RESULT_CACHE_TTL", "15m")
+	cacheTTL, err := time.ParseDuration(cacheTTLStr)
+	if err != nil || cacheTTL < 0 {
+		logg.Fatal("invalid value for KEPPEL_TRIVY_RESULT_CACHE_TTL: %q", cacheTTLStr)
+	}
+	if cacheTTL > 0 {
+		cacheMaxEntries := getenvPositiveInt("KEPPEL_TRIVY_RESULT_CACHE_MAX_ENTRIES", "100")
+		api.cache = newResultCache(cacheTTL, cacheMaxEntries, trivyURL, token)
+		prometheus.MustRegister(resultCacheHitCounter, resultCacheMissCounter)
+	}
 
 	handler := httpapi.Compose(
-		NewAPI(dbMirrorPrefix, token, trivyURL),
+		api,
 		httpapi.HealthCheckAPI{SkipRequestLog: true},
 		pprofapi.API{IsAuthorized: pprofapi.IsRequestFromLocalhost},
 	)
@@ -77,22 +95,37 @@ func run(cmd *cobra.Command, args []string) {
 	must.Succeed(httpext.ListenAndServeContext(ctx, apiListenAddress, smux))
 }
 
+func getenvPositiveInt(key, defaultValue string) int {
+	valueStr := osext.GetenvOrDefault(key, defaultValue)
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value <= 0 {
+		logg.Fatal("invalid value for %s: %q", key, valueStr)
+	}
+	return value
+}
+
 // API contains state variables used by the Trivy API proxy.
 type API struct {
 	dbMirrorPrefix string
 	token          string
 	trivyURL       string
+	queue          *scanQueue
+	cache          *resultCache // optional
 }
 
 // NewAPI constructs a new API instance.
-func NewAPI(dbMirrorPrefix, token, trivyURL string) *API {
+func NewAPI(dbMirrorPrefix, token, trivyURL string, maxConcurrentScans, maxQueuedScans int) *API {
 	return &API{
 		dbMirrorPrefix: dbMirrorPrefix,
 		token:          token,
 		trivyURL:       trivyURL,
+		queue:          newScanQueue(maxConcurrentScans, maxQueuedScans),
 	}
 }
 
+// How long clients are asked to wait before retrying when the scan queue is full.
+const retryAfterSeconds = 10
+
 // AddTo implements the api.API interface.
 func (a *API) AddTo(r *mux.Router) {
 	r.Methods("GET").Path("/trivy").HandlerFunc(a.proxyToTrivy)
@@ -121,13 +154,37 @@ func (a *API) proxyToTrivy(w http.ResponseWriter, r *http.Request) {
 
 	keppelToken := r.Header.Get(trivy.KeppelTokenHeader)
 
-	stdout, stderr, err := a.runTrivy(r.Context(), imageURL, format, keppelToken)
+	cacheKey, useCache := a.cache.Key(r.Context(), imageURL, format)
+	if useCache {
+		contents, ok := a.cache.Get(cacheKey)
+		if ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(contents)
+			return
+		}
+	}
+
+	var stdout, stderr []byte
+	admitted, err := a.queue.Run(r.Context(), func() (err error) {
+		stdout, stderr, err = a.runTrivy(r.Context(), imageURL, format, keppelToken)
+		return err
+	})
+	if !admitted {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+		http.Error(w, "too many scans in progress, please retry later", http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
 		cleanedErr := strings.ReplaceAll(strings.TrimSpace(string(stderr)), "\n", " ")
 		http.Error(w, fmt.Sprintf("trivy: %s: %s", err, cleanedErr), http.StatusInternalServerError)
 		return
 	}
 
+	if useCache {
+		a.cache.Put(cacheKey, stdout)
+	}
+
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(stdout)
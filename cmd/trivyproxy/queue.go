@@ -0,0 +1,105 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package trivyproxycmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queuedScansGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "keppel_trivy_proxy_queued_scans",
+			Help: "Number of scan requests that are waiting for a free trivy process.",
+		},
+	)
+	runningScansGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "keppel_trivy_proxy_running_scans",
+			Help: "Number of trivy processes that are currently running.",
+		},
+	)
+	rejectedScansCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "keppel_trivy_proxy_rejected_scans",
+			Help: "Counter for scan requests that were rejected with 429 because the queue was full.",
+		},
+	)
+	scanDurationHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "keppel_trivy_proxy_scan_duration_seconds",
+			Help:    "Duration of trivy processes (not including the time spent waiting in the queue).",
+			Buckets: prometheus.ExponentialBuckets(0.5, 2, 11), // 0.5s .. ~8.5min
+		},
+		[]string{"task_outcome"},
+	)
+)
+
+// scanQueue limits how many trivy processes run at the same time, and how
+// many requests may wait for a free slot.
+type scanQueue struct {
+	// one token per admitted request (running or waiting)
+	admitted chan struct{}
+	// one token per running trivy process
+	running chan struct{}
+}
+
+func newScanQueue(maxConcurrentScans, maxQueuedScans int) *scanQueue {
+	return &scanQueue{
+		admitted: make(chan struct{}, maxConcurrentScans+maxQueuedScans),
+		running:  make(chan struct{}, maxConcurrentScans),
+	}
+}
+
+// Run executes the given action once a slot becomes free. If the queue is
+// full, false is returned immediately without executing the action. If the
+// context expires while waiting, the context error is returned.
+func (q *scanQueue) Run(ctx context.Context, action func() error) (admitted bool, err error) {
+	select {
+	case q.admitted <- struct{}{}:
+	default:
+		rejectedScansCounter.Inc()
+		return false, nil
+	}
+	defer func() { <-q.admitted }()
+
+	queuedScansGauge.Inc()
+	select {
+	case q.running <- struct{}{}:
+		queuedScansGauge.Dec()
+	case <-ctx.Done():
+		queuedScansGauge.Dec()
+		return true, ctx.Err()
+	}
+	defer func() { <-q.running }()
+
+	runningScansGauge.Inc()
+	defer runningScansGauge.Dec()
+	startedAt := time.Now()
+	err = action()
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	scanDurationHistogram.WithLabelValues(outcome).Observe(time.Since(startedAt).Seconds())
+	return true, err
+}
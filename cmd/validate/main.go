@@ -20,6 +20,7 @@ package validatecmd
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"strings"
 
@@ -35,6 +36,15 @@ var (
 	authUserName      string
 	authPassword      string
 	platformFilterStr string
+	outputFormat      string
+	parallelism       int
+)
+
+// Exit codes of this command, so that CI jobs can distinguish broken images
+// from a broken invocation.
+const (
+	exitCodeValidationFailed = 1
+	exitCodeInvalidArguments = 2
 )
 
 // AddCommandTo mounts this command into the command hierarchy.
@@ -50,6 +60,8 @@ If the image is in a Keppel replica account, this ensures that the image is repl
 	}
 	cmd.PersistentFlags().StringVarP(&authUserName, "username", "u", "", "User name (only required for non-public images).")
 	cmd.PersistentFlags().StringVarP(&authPassword, "password", "p", "", "Password (only required for non-public images).")
+	cmd.PersistentFlags().StringVar(&outputFormat, "format", "text", "Output format: \"text\" logs the validation progress, \"json\" validates all given images (even after a failure) and then prints a report for each image to stdout. Exit codes are the same for both formats: 0 if all images are valid, 1 if any image failed validation, 2 for invalid arguments.")
+	cmd.PersistentFlags().IntVar(&parallelism, "parallel", 1, "How many blobs of the same image may be validated concurrently. If set to more than 1, all blobs of an image are validated even if one of them fails, and validation failures are reported in the order of the blobs in the image manifest.")
 	cmd.PersistentFlags().StringVar(&platformFilterStr, "platform-filter", "[]", "When validating a multi-architecture image, only recurse into the contained images matching one of the given platforms. The filter must be given as a JSON array of objects matching each having the same format as the `manifests[].platform` field in the <https://github.com/opencontainers/image-spec/blob/master/image-index.md>.")
 	parent.AddCommand(cmd)
 }
@@ -84,22 +96,109 @@ func (l logger) LogBlob(d digest.Digest, level int, err error, isCached bool) {
 	}
 }
 
+// imageReport appears in the output of `--format json`.
+type imageReport struct {
+	Image            string              `json:"image"`
+	Valid            bool                `json:"valid"`
+	ManifestsChecked int                 `json:"manifests_checked"`
+	BlobsChecked     int                 `json:"blobs_checked"`
+	CacheHits        int                 `json:"cache_hits"`
+	Failures         []validationFailure `json:"failures,omitempty"`
+}
+
+// validationFailure appears in type imageReport.
+type validationFailure struct {
+	Kind      string `json:"kind"` // either "manifest" or "blob"
+	Reference string `json:"reference"`
+	Message   string `json:"message"`
+}
+
+// reportingLogger is a client.ValidationLogger that logs like type logger,
+// but also records the validation results into the report for the image
+// that is currently being validated.
+type reportingLogger struct {
+	logger
+	current *imageReport
+}
+
+// LogManifest implements the client.ValidationLogger interface.
+func (l *reportingLogger) LogManifest(reference models.ManifestReference, level int, err error, isCached bool) {
+	l.logger.LogManifest(reference, level, err, isCached)
+	l.record("manifest", reference.String(), err, isCached)
+}
+
+// LogBlob implements the client.ValidationLogger interface.
+func (l *reportingLogger) LogBlob(d digest.Digest, level int, err error, isCached bool) {
+	l.logger.LogBlob(d, level, err, isCached)
+	l.record("blob", d.String(), err, isCached)
+}
+
+func (l *reportingLogger) record(kind, reference string, err error, isCached bool) {
+	switch {
+	case isCached:
+		l.current.CacheHits++
+	case kind == "manifest":
+		l.current.ManifestsChecked++
+	default:
+		l.current.BlobsChecked++
+	}
+	if err != nil {
+		l.current.Failures = append(l.current.Failures, validationFailure{
+			Kind:      kind,
+			Reference: reference,
+			Message:   err.Error(),
+		})
+	}
+}
+
 func run(cmd *cobra.Command, args []string) {
+	if outputFormat != "text" && outputFormat != "json" {
+		logg.Error("invalid value for --format: %q (expected \"text\" or \"json\")", outputFormat)
+		os.Exit(exitCodeInvalidArguments)
+	}
+
+	if parallelism < 1 {
+		logg.Error("invalid value for --parallel: %d (expected a positive integer)", parallelism)
+		os.Exit(exitCodeInvalidArguments)
+	}
+
 	var platformFilter models.PlatformFilter
 	err := json.Unmarshal([]byte(platformFilterStr), &platformFilter)
 	if err != nil {
-		logg.Fatal("cannot parse platform filter: " + err.Error())
-	}
-
-	session := client.ValidationSession{
-		Logger: logger{},
+		logg.Error("cannot parse platform filter: " + err.Error())
+		os.Exit(exitCodeInvalidArguments)
 	}
 
-	for _, arg := range args {
+	// parse all image references before starting to validate, so that a typo
+	// in the last argument does not get reported only after a long validation
+	refs := make([]models.ImageReference, len(args))
+	for idx, arg := range args {
 		ref, interpretation, err := models.ParseImageReference(arg)
-		logg.Info("interpreting %s as %s", arg, interpretation)
 		if err != nil {
-			logg.Fatal(err.Error())
+			logg.Error(err.Error())
+			os.Exit(exitCodeInvalidArguments)
+		}
+		logg.Info("interpreting %s as %s", arg, interpretation)
+		refs[idx] = ref
+	}
+
+	var (
+		session = client.ValidationSession{Parallelism: parallelism}
+		rl      *reportingLogger
+	)
+	if outputFormat == "json" {
+		rl = &reportingLogger{}
+		session.Logger = rl
+	} else {
+		session.Logger = logger{}
+	}
+
+	reports := make([]imageReport, len(refs))
+	failed := false
+	for idx, ref := range refs {
+		reports[idx].Image = args[idx]
+		if rl != nil {
+			rl.current = &reports[idx]
 		}
 
 		c := &client.RepoClient{
@@ -108,9 +207,26 @@ func run(cmd *cobra.Command, args []string) {
 			UserName: authUserName,
 			Password: authPassword,
 		}
-		err = c.ValidateManifest(cmd.Context(), ref.Reference, &session, platformFilter)
+		err := c.ValidateManifest(cmd.Context(), ref.Reference, &session, platformFilter)
+		reports[idx].Valid = err == nil
+		if err != nil {
+			failed = true
+			if rl == nil {
+				// in text mode, the error has already been logged, and there is no
+				// report to complete
+				os.Exit(exitCodeValidationFailed)
+			}
+		}
+	}
+
+	if rl != nil {
+		buf, err := json.MarshalIndent(reports, "", "  ")
 		if err != nil {
-			os.Exit(1)
+			logg.Fatal(err.Error())
 		}
+		fmt.Println(string(buf))
+	}
+	if failed {
+		os.Exit(exitCodeValidationFailed)
 	}
 }
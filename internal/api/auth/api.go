@@ -73,7 +73,7 @@ func (a *API) handleGetAuth(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/auth")
 
 	// parse request
-	req, err := parseRequest(r.URL.RawQuery, a.cfg)
+	req, err := parseRequest(r.URL.RawQuery, a.cfg, a.db)
 	if respondWithError(w, http.StatusBadRequest, err) {
 		return
 	}
@@ -999,3 +999,62 @@ func TestIssuerKeyRotation(t *testing.T) {
 		},
 	}.Check(t, s.Handler)
 }
+
+func TestAccountCache(t *testing.T) {
+	s := setupPrimary(t, test.WithKeppelAPI)
+	s.DB.EnableAccountCache(time.Hour)
+	service := s.Config.APIPublicHostname
+
+	req := assert.HTTPRequest{
+		Method:       "GET",
+		Path:         fmt.Sprintf("/keppel/v1/auth?service=%s&scope=repository:test1/foo:pull", service),
+		ExpectStatus: http.StatusOK,
+	}
+	expectAnonPull := func(allowed bool) {
+		t.Helper()
+		expectedContents := jwtContents{
+			Audience: service,
+			Issuer:   "keppel-api@registry.example.org",
+		}
+		if allowed {
+			expectedContents.Access = []jwtAccess{{Type: "repository", Name: "test1/foo", Actions: []string{"pull"}}}
+		}
+		req.ExpectBody = expectedContents
+		req.Check(t, s.Handler)
+	}
+	setRBACPolicies := func(policies []keppel.RBACPolicy) {
+		t.Helper()
+		buf, err := json.Marshal(policies)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_, err = s.DB.Exec(`UPDATE accounts SET rbac_policies_json = $1 WHERE name = $2`, string(buf), "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// initially, anonymous pull is not allowed (and this result gets cached)
+	expectAnonPull(false)
+
+	// changes made behind the cache's back are not visible until the cache entry is invalidated...
+	setRBACPolicies([]keppel.RBACPolicy{policyAnonPull})
+	expectAnonPull(false)
+	s.DB.InvalidateCachedAccount("test1")
+	expectAnonPull(true)
+
+	// ...which happens automatically when the account is updated through the Keppel API
+	assert.HTTPRequest{
+		Method: "PUT",
+		Path:   "/keppel/v1/accounts/test1",
+		Header: map[string]string{"X-Test-Perms": "change:test1authtenant,view:test1authtenant"},
+		Body: assert.JSONObject{
+			"account": assert.JSONObject{
+				"auth_tenant_id": "test1authtenant",
+				"rbac_policies":  []assert.JSONObject{},
+			},
+		},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, s.Handler)
+	expectAnonPull(false)
+}
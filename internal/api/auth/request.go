@@ -36,7 +36,7 @@ type Request struct {
 	IntendedAudience auth.Audience
 }
 
-func parseRequest(rawQuery string, cfg keppel.Configuration) (Request, error) {
+func parseRequest(rawQuery string, cfg keppel.Configuration, db *keppel.DB) (Request, error) {
 	query, err := url.ParseQuery(rawQuery)
 	if err != nil {
 		return Request{}, fmt.Errorf("cannot parse query string: %s", err.Error())
@@ -50,7 +50,10 @@ func parseRequest(rawQuery string, cfg keppel.Configuration) (Request, error) {
 	}
 
 	serviceHost := query.Get("service")
-	result.IntendedAudience = auth.IdentifyAudience(serviceHost, cfg)
+	result.IntendedAudience, err = auth.ResolveAudience(serviceHost, cfg, db)
+	if err != nil {
+		return Request{}, err
+	}
 	if result.IntendedAudience.Hostname(cfg) != serviceHost {
 		return Request{}, fmt.Errorf("cannot issue tokens for service: %q", serviceHost)
 	}
@@ -19,9 +19,13 @@
 package keppelv1
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
 	"slices"
 	"time"
 
@@ -32,6 +36,7 @@ import (
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/respondwith"
 
+	"github.com/sapcc/keppel/internal/api"
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 )
@@ -41,7 +46,7 @@ const SubleaseHeader = "X-Keppel-Sublease-Token"
 func (a *API) handleGetAccounts(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts")
 	var accounts []models.Account
-	_, err := a.db.Select(&accounts, "SELECT * FROM accounts ORDER BY name")
+	_, err := a.db.ReadReplica().Select(&accounts, "SELECT * FROM accounts ORDER BY name")
 	if respondwith.ErrorText(w, err) {
 		return
 	}
@@ -94,7 +99,7 @@ func (a *API) handleGetAccount(w http.ResponseWriter, r *http.Request) {
 	if respondwith.ErrorText(w, err) {
 		return
 	}
-	respondwith.JSON(w, http.StatusOK, map[string]any{"account": accountRendered})
+	respondWithConditionalJSON(w, r, map[string]any{"account": accountRendered})
 }
 
 func (a *API) handlePutAccount(w http.ResponseWriter, r *http.Request) {
@@ -131,32 +136,147 @@ func (a *API) handlePutAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	getSubleaseTokenCallback := func(_ models.Peer) (keppel.SubleaseToken, error) {
-		t, err := keppel.ParseSubleaseToken(r.Header.Get(SubleaseHeader))
-		if err != nil {
-			return keppel.SubleaseToken{}, fmt.Errorf("malformed %s header: %w", SubleaseHeader, err)
-		}
-		return t, nil
+	account, rerr := a.processor().CreateOrUpdateAccount(r.Context(), req.Account, authz.UserIdentity.UserInfo(), r, subleaseTokenFromRequest(r), finalizeAccountFromAPI)
+	if rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
+
+	accountRendered, err := keppel.RenderAccount(account)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"account": accountRendered})
+}
+
+// Fields that are shown by GET, but cannot be changed through PATCH.
+var immutableAccountFields = []string{"name", "auth_tenant_id", "state", "deletion_scheduled_at", "metadata"}
+
+func (a *API) handlePatchAccount(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	dbAccount := a.findAccountFromRequest(w, r, authz)
+	if dbAccount == nil {
+		return
+	}
+	if dbAccount.IsDeleting {
+		http.Error(w, "account is being deleted", http.StatusConflict)
+		return
+	}
+
+	// decode request body (this is a JSON merge patch as per RFC 7396 on the
+	// "account" object, so we cannot decode into keppel.Account directly:
+	// we need to distinguish absent fields from fields set to their zero value)
+	var req struct {
+		Account map[string]any `json:"account"`
+	}
+	ok := decodeJSONRequestBody(w, r.Body, &req)
+	if !ok {
+		return
+	}
+	if req.Account == nil {
+		http.Error(w, `missing attribute "account" in request body`, http.StatusUnprocessableEntity)
+		return
 	}
-	finalizeAccountCallback := func(account *models.Account) *keppel.RegistryV2Error {
-		if account.IsManaged {
-			return keppel.ErrDenied.With("cannot manually change configuration of a managed account").WithStatus(http.StatusForbidden)
+
+	// apply the patch to the current account configuration, as shown by GET
+	accountRendered, err := keppel.RenderAccount(*dbAccount)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	buf, err := json.Marshal(accountRendered)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	var current map[string]any
+	err = json.Unmarshal(buf, &current)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	for _, field := range immutableAccountFields {
+		value, exists := req.Account[field]
+		if exists && !reflect.DeepEqual(value, current[field]) {
+			http.Error(w, fmt.Sprintf(`attribute "account.%s" in request body cannot be changed`, field), http.StatusUnprocessableEntity)
+			return
 		}
-		return nil
 	}
-	account, rerr := a.processor().CreateOrUpdateAccount(r.Context(), req.Account, authz.UserIdentity.UserInfo(), r, getSubleaseTokenCallback, finalizeAccountCallback)
+	buf, err = json.Marshal(applyJSONMergePatch(current, req.Account))
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	var patchedAccount keppel.Account
+	ok = decodeJSONRequestBody(w, bytes.NewReader(buf), &patchedAccount)
+	if !ok {
+		return
+	}
+	// the immutable fields are not accepted by CreateOrUpdateAccount, and we
+	// already checked above that they were not changed
+	patchedAccount.State = ""
+	patchedAccount.Metadata = nil
+	patchedAccount.DeletionScheduledAt = nil
+
+	account, rerr := a.processor().CreateOrUpdateAccount(r.Context(), patchedAccount, authz.UserIdentity.UserInfo(), r, subleaseTokenFromRequest(r), finalizeAccountFromAPI)
 	if rerr != nil {
 		rerr.WriteAsTextTo(w)
 		return
 	}
 
-	accountRendered, err := keppel.RenderAccount(account)
+	accountRendered, err = keppel.RenderAccount(account)
 	if respondwith.ErrorText(w, err) {
 		return
 	}
 	respondwith.JSON(w, http.StatusOK, map[string]any{"account": accountRendered})
 }
 
+// Applies a JSON merge patch (RFC 7396) to the given target. Both arguments
+// must be the result of unmarshaling JSON into an `any`.
+func applyJSONMergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = make(map[string]any, len(patchObj))
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+		} else {
+			targetObj[key] = applyJSONMergePatch(targetObj[key], value)
+		}
+	}
+	return targetObj
+}
+
+// Builds the getSubleaseToken callback for CreateOrUpdateAccount.
+func subleaseTokenFromRequest(r *http.Request) func(models.Peer) (keppel.SubleaseToken, error) {
+	return func(_ models.Peer) (keppel.SubleaseToken, error) {
+		t, err := keppel.ParseSubleaseToken(r.Header.Get(SubleaseHeader))
+		if err != nil {
+			return keppel.SubleaseToken{}, fmt.Errorf("malformed %s header: %w", SubleaseHeader, err)
+		}
+		return t, nil
+	}
+}
+
+// The setCustomFields callback for CreateOrUpdateAccount when accounts are
+// changed through the API by users.
+func finalizeAccountFromAPI(account *models.Account) *keppel.RegistryV2Error {
+	if account.IsManaged {
+		return keppel.ErrDenied.With("cannot manually change configuration of a managed account").WithStatus(http.StatusForbidden)
+	}
+	// secret references are resolved with the privileges of the Keppel process,
+	// so only the operator may use them (through the account management driver)
+	if account.ExternalPeerPasswordRef != "" {
+		return keppel.ErrDenied.With("password_secret_ref may only be used for managed accounts").WithStatus(http.StatusForbidden)
+	}
+	return nil
+}
+
 func (a *API) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account")
 	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
@@ -167,6 +287,10 @@ func (a *API) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
 	if account == nil {
 		return
 	}
+	if rerr := api.CheckReadOnlyMode(a.cfg, account.Reduced()); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
 
 	if account.IsDeleting {
 		w.WriteHeader(http.StatusNoContent)
@@ -184,6 +308,56 @@ func (a *API) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (a *API) handlePostCancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/cancel_deletion")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	err := a.processor().CancelScheduledAccountDeletion(*account, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no deletion is scheduled for this account", http.StatusConflict)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) handlePostCheckUpstreamCredentials(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/check_upstream_credentials")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	if account.ExternalPeerURL == "" {
+		http.Error(w, "operation only allowed for replica accounts with an external upstream", http.StatusBadRequest)
+		return
+	}
+
+	err := a.processor().CheckUpstreamCredentials(r.Context(), account.Reduced())
+	if err != nil {
+		http.Error(w, "upstream credentials could not be validated: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (a *API) handlePostAccountSublease(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/sublease")
 	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
@@ -19,6 +19,8 @@
 package keppelv1
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -32,8 +34,10 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/go-bits/respondwith"
 
+	"github.com/sapcc/keppel/internal/api"
 	"github.com/sapcc/keppel/internal/auth"
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
@@ -72,25 +76,63 @@ func (a *API) AddTo(r *mux.Router) {
 	//NOTE: Keppel account names are severely restricted because we used to
 	// derive Postgres database names from them.
 	r.Methods("GET").Path("/keppel/v1/accounts").HandlerFunc(a.handleGetAccounts)
-	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}").HandlerFunc(a.handleGetAccount)
+	r.Methods("GET", "HEAD").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}").HandlerFunc(a.handleGetAccount)
 	r.Methods("PUT").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}").HandlerFunc(a.handlePutAccount)
+	r.Methods("PATCH").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}").HandlerFunc(a.handlePatchAccount)
 	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}").HandlerFunc(a.handleDeleteAccount)
 	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/sublease").HandlerFunc(a.handlePostAccountSublease)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/cancel_deletion").HandlerFunc(a.handlePostCancelAccountDeletion)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/check_upstream_credentials").HandlerFunc(a.handlePostCheckUpstreamCredentials)
 	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/security_scan_policies").HandlerFunc(a.handleGetSecurityScanPolicies)
 	r.Methods("PUT").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/security_scan_policies").HandlerFunc(a.handlePutSecurityScanPolicies)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/audit-events").HandlerFunc(a.handleGetAuditEvents)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/blocked_digests").HandlerFunc(a.handleGetAccountBlockedDigests)
+	r.Methods("PUT").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/blocked_digests/{digest}").HandlerFunc(a.handlePutAccountBlockedDigest)
+	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/blocked_digests/{digest}").HandlerFunc(a.handleDeleteAccountBlockedDigest)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/gc-runs").HandlerFunc(a.handleGetGCRuns)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/manifests").HandlerFunc(a.handleSearchManifests)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/orphaned_blobs").HandlerFunc(a.handleGetOrphanedBlobs)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/replica_divergences").HandlerFunc(a.handleGetReplicaDivergences)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/security-summary").HandlerFunc(a.handleGetSecuritySummary)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/robot_tokens").HandlerFunc(a.handleGetRobotTokens)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/robot_tokens").HandlerFunc(a.handlePostRobotToken)
+	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/robot_tokens/{id:[0-9]+}").HandlerFunc(a.handleDeleteRobotToken)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/vanity_domains").HandlerFunc(a.handleGetVanityDomains)
+	r.Methods("PUT").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/vanity_domains/{hostname}").HandlerFunc(a.handlePutVanityDomain)
+	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/vanity_domains/{hostname}").HandlerFunc(a.handleDeleteVanityDomain)
 
-	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests").HandlerFunc(a.handleGetManifests)
+	r.Methods("GET", "HEAD").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests").HandlerFunc(a.handleGetManifests)
 	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}").HandlerFunc(a.handleDeleteManifest)
 	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/trivy_report").HandlerFunc(a.handleGetTrivyReport)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/security_scan").HandlerFunc(a.handlePostSecurityScan)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/vulnerabilities").HandlerFunc(a.handleGetManifestVulnerabilities)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/quarantine").HandlerFunc(a.handleGetQuarantineStatus)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/provenance_bundle").HandlerFunc(a.handleGetProvenanceBundle)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/attestations").HandlerFunc(a.handleGetAttestationStatus)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_manifests/{digest}/sbom").HandlerFunc(a.handleGetManifestSBOM)
 	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_tags/{tag_name}").HandlerFunc(a.handleDeleteTag)
+	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_tags/{tag_name}/history").HandlerFunc(a.handleGetTagHistory)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_assemble_index").HandlerFunc(a.handlePostAssembleImageIndex)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_promote").HandlerFunc(a.handlePostPromoteManifest)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_signed_url").HandlerFunc(a.handlePostSignedURL)
+	r.Methods("POST").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_verify/{digest}").HandlerFunc(a.handlePostVerifyManifest)
+	r.Methods("PUT").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}/_metadata").HandlerFunc(a.handlePutRepositoryMetadata)
 
-	r.Methods("GET").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories").HandlerFunc(a.handleGetRepositories)
+	r.Methods("GET", "HEAD").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories").HandlerFunc(a.handleGetRepositories)
 	r.Methods("DELETE").Path("/keppel/v1/accounts/{account:[a-z0-9-]{1,48}}/repositories/{repo_name:.+}").HandlerFunc(a.handleDeleteRepository)
 
+	r.Methods("GET").Path("/keppel/v1/blocked_digests").HandlerFunc(a.handleGetBlockedDigests)
+	r.Methods("PUT").Path("/keppel/v1/blocked_digests/{digest}").HandlerFunc(a.handlePutBlockedDigest)
+	r.Methods("DELETE").Path("/keppel/v1/blocked_digests/{digest}").HandlerFunc(a.handleDeleteBlockedDigest)
+
+	r.Methods("GET").Path("/keppel/v1/jobs").HandlerFunc(a.handleGetJobs)
+
 	r.Methods("GET").Path("/keppel/v1/peers").HandlerFunc(a.handleGetPeers)
 
+	r.Methods("GET").Path("/keppel/v1/quotas").HandlerFunc(a.handleListQuotas)
 	r.Methods("GET").Path("/keppel/v1/quotas/{auth_tenant_id}").HandlerFunc(a.handleGetQuotas)
 	r.Methods("PUT").Path("/keppel/v1/quotas/{auth_tenant_id}").HandlerFunc(a.handlePutQuotas)
+	r.Methods("GET").Path("/keppel/v1/quotas/{auth_tenant_id}/security-summary").HandlerFunc(a.handleGetTenantSecuritySummary)
 
 	// Besides the native Keppel API, this handler also implements LIQUID.
 	// Ref: <https://pkg.go.dev/github.com/sapcc/go-api-declarations/liquid>
@@ -121,12 +163,16 @@ func respondWithAuthError(w http.ResponseWriter, err *keppel.RegistryV2Error) bo
 	return true
 }
 
-func authTenantScope(perm keppel.Permission, authTenantID string) auth.ScopeSet {
-	return auth.NewScopeSet(auth.Scope{
-		ResourceType: "keppel_auth_tenant",
-		ResourceName: authTenantID,
-		Actions:      []string{string(perm)},
-	})
+func authTenantScope(perm keppel.Permission, authTenantIDs ...string) auth.ScopeSet {
+	scopes := make([]auth.Scope, len(authTenantIDs))
+	for idx, authTenantID := range authTenantIDs {
+		scopes[idx] = auth.Scope{
+			ResourceType: "keppel_auth_tenant",
+			ResourceName: authTenantID,
+			Actions:      []string{string(perm)},
+		}
+	}
+	return auth.NewScopeSet(scopes...)
 }
 
 func accountScopeFromRequest(r *http.Request, perm keppel.Permission) auth.ScopeSet {
@@ -163,7 +209,7 @@ func (a *API) authenticateRequest(w http.ResponseWriter, r *http.Request, ss aut
 		HTTPRequest:          r,
 		Scopes:               ss,
 		CorrectlyReturn403:   true,
-		PartialAccessAllowed: r.URL.Path == "/keppel/v1/accounts",
+		PartialAccessAllowed: r.URL.Path == "/keppel/v1/accounts" || r.URL.Path == "/keppel/v1/quotas",
 	}.Authorize(r.Context(), a.cfg, a.authDriver, a.db)
 	if rerr != nil {
 		rerr.WriteAsTextTo(w)
@@ -221,6 +267,34 @@ func decodeJSONRequestBody(w http.ResponseWriter, body io.Reader, target any) (o
 	return true
 }
 
+// respondWithConditionalJSON is like respondwith.JSON() with status 200, but
+// also sets an ETag header derived from the response body. If the request has
+// an If-None-Match header with this ETag, the body is omitted and the status
+// is 304 (Not Modified) instead.
+func respondWithConditionalJSON(w http.ResponseWriter, r *http.Request, data any) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(data)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(buf.Bytes()))
+	w.Header().Set("ETag", etag)
+	if api.ETagListContains(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, err = w.Write(buf.Bytes())
+		if err != nil {
+			logg.Error("could not write JSON response: " + err.Error())
+		}
+	}
+}
+
 func isValidRepoName(name string) bool {
 	if name == "" {
 		return false
@@ -238,6 +312,10 @@ type paginatedQuery struct {
 	MarkerField string
 	Options     url.Values
 	BindValues  []any
+	// If not empty, this is used instead of `MarkerField > $MARKER` to restrict
+	// the result to everything after the marker. It must contain the
+	// placeholder `$MARKER`, which is replaced with the marker's bind variable.
+	MarkerCondition string
 }
 
 func (q paginatedQuery) Prepare() (modifiedSQLQuery string, modifiedBindValues []any, limit uint64, err error) {
@@ -262,6 +340,12 @@ func (q paginatedQuery) Prepare() (modifiedSQLQuery string, modifiedBindValues [
 		query = strings.Replace(query, `$CONDITION`, `TRUE`, 1)
 		return query, q.BindValues, limit, nil
 	}
-	query = strings.Replace(query, `$CONDITION`, q.MarkerField+` > $2`, 1)
+	condition := q.MarkerCondition
+	if condition == "" {
+		condition = q.MarkerField + ` > $MARKER`
+	}
+	placeholder := "$" + strconv.Itoa(len(q.BindValues)+1)
+	condition = strings.ReplaceAll(condition, `$MARKER`, placeholder)
+	query = strings.Replace(query, `$CONDITION`, condition, 1)
 	return query, append(q.BindValues, marker), limit, nil
 }
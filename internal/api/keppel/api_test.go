@@ -22,6 +22,7 @@ package keppelv1_test
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"testing"
 
 	"github.com/sapcc/go-bits/assert"
@@ -88,3 +89,95 @@ func TestAlternativeAuthSchemes(t *testing.T) {
 		ExpectBody:   assert.JSONObject{"manifests": []assert.JSONObject{}},
 	}.Check(t, h)
 }
+
+func TestConditionalRequests(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+	viewHeader := map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"}
+
+	for _, path := range []string{
+		"/keppel/v1/accounts/test1",
+		"/keppel/v1/accounts/test1/repositories",
+		"/keppel/v1/accounts/test1/repositories/foo/_manifests",
+	} {
+		// a regular GET reports an ETag
+		resp, body := assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path,
+			Header:       viewHeader,
+			ExpectStatus: http.StatusOK,
+		}.Check(t, h)
+		etag := resp.Header.Get("Etag")
+		if etag == "" {
+			t.Fatalf("expected ETag header on GET %s", path)
+		}
+
+		// HEAD reports the same ETag, but no body
+		resp, headBody := assert.HTTPRequest{
+			Method:       "HEAD",
+			Path:         path,
+			Header:       viewHeader,
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{"Etag": etag},
+		}.Check(t, h)
+		if len(headBody) != 0 {
+			t.Errorf("expected empty body on HEAD %s, but got %q", path, string(headBody))
+		}
+		if resp.Header.Get("Content-Length") != strconv.Itoa(len(body)) {
+			t.Errorf("expected Content-Length %d on HEAD %s, but got %q", len(body), path, resp.Header.Get("Content-Length"))
+		}
+
+		// GET with a matching If-None-Match yields 304 without a body (weak
+		// comparison is used, so the weak form of the ETag also matches)
+		for _, ifNoneMatch := range []string{etag, `"something-else", W/` + etag, "*"} {
+			_, body := assert.HTTPRequest{
+				Method: "GET",
+				Path:   path,
+				Header: map[string]string{
+					"X-Test-Perms":  viewHeader["X-Test-Perms"],
+					"If-None-Match": ifNoneMatch,
+				},
+				ExpectStatus: http.StatusNotModified,
+				ExpectHeader: map[string]string{"Etag": etag},
+			}.Check(t, h)
+			if len(body) != 0 {
+				t.Errorf("expected empty body on 304 for GET %s, but got %q", path, string(body))
+			}
+		}
+
+		// GET with a non-matching If-None-Match yields the full response
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   path,
+			Header: map[string]string{
+				"X-Test-Perms":  viewHeader["X-Test-Perms"],
+				"If-None-Match": `"something-else"`,
+			},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.ByteData(body),
+		}.Check(t, h)
+	}
+
+	// the ETag changes when the resource changes
+	resp, _ := assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories",
+		Header:       viewHeader,
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+	etag := resp.Header.Get("Etag")
+	mustInsert(t, s.DB, &models.Repository{Name: "bar", AccountName: "test1"})
+	assert.HTTPRequest{
+		Method: "GET",
+		Path:   "/keppel/v1/accounts/test1/repositories",
+		Header: map[string]string{
+			"X-Test-Perms":  viewHeader["X-Test-Perms"],
+			"If-None-Match": etag,
+		},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, h)
+}
@@ -0,0 +1,142 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// AttestationVerificationReport is the response body of GET .../_manifests/:digest/attestations.
+// Its layout follows the verification result format of Ratify, so that
+// admission controllers that understand this format can consume it directly.
+type AttestationVerificationReport struct {
+	IsSuccess       bool                        `json:"isSuccess"`
+	VerifierReports []AttestationVerifierReport `json:"verifierReports"`
+}
+
+// AttestationVerifierReport appears in type AttestationVerificationReport.
+// There is one such report for each required predicate type.
+type AttestationVerifierReport struct {
+	Subject      string                      `json:"subject"`
+	IsSuccess    bool                        `json:"isSuccess"`
+	Name         string                      `json:"name"`
+	Type         string                      `json:"type"`
+	Message      string                      `json:"message,omitempty"`
+	ArtifactType string                      `json:"artifactType"`
+	Extensions   AttestationReportExtensions `json:"extensions"`
+}
+
+// AttestationReportExtensions appears in type AttestationVerifierReport.
+type AttestationReportExtensions struct {
+	PredicateType     string `json:"predicate_type"`
+	Status            string `json:"status"`
+	AttestationDigest string `json:"attestation_digest,omitempty"`
+	CheckedAt         *int64 `json:"checked_at,omitempty"`
+}
+
+func (a *API) handleGetAttestationStatus(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/attestations")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+	parsedDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	manifest, err := keppel.FindManifest(a.db, *repo, parsedDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	requiredTypes := account.Reduced().SplitRequiredAttestationTypes()
+	if len(requiredTypes) == 0 {
+		http.Error(w, "account does not require attestations", http.StatusNotFound)
+		return
+	}
+
+	var dbResults []models.AttestationResult
+	_, err = a.db.Select(&dbResults,
+		`SELECT * FROM attestation_results WHERE repo_id = $1 AND digest = $2`,
+		repo.ID, manifest.Digest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	resultsByType := make(map[string]models.AttestationResult, len(dbResults))
+	for _, result := range dbResults {
+		resultsByType[result.PredicateType] = result
+	}
+
+	// only the currently required predicate types are reported (results for
+	// other predicate types are left over from before a policy change)
+	subject := fmt.Sprintf("%s/%s@%s", a.cfg.APIPublicHostname, repo.FullName(), manifest.Digest)
+	report := AttestationVerificationReport{
+		IsSuccess:       true,
+		VerifierReports: make([]AttestationVerifierReport, len(requiredTypes)),
+	}
+	for idx, predicateType := range requiredTypes {
+		vr := AttestationVerifierReport{
+			Subject:      subject,
+			Name:         "keppel",
+			Type:         "cosign-attestation",
+			ArtifactType: keppel.DSSEEnvelopeMediaType,
+			Extensions: AttestationReportExtensions{
+				PredicateType: predicateType,
+				Status:        "unverified",
+			},
+		}
+		result, exists := resultsByType[predicateType]
+		if exists {
+			vr.IsSuccess = result.Status.IsTrusted()
+			vr.Message = result.Message
+			vr.Extensions.Status = string(result.Status)
+			vr.Extensions.AttestationDigest = result.AttestationDigest
+			vr.Extensions.CheckedAt = keppel.MaybeTimeToUnix(&result.CheckedAt)
+		} else {
+			vr.Message = "attestations have not been verified yet"
+		}
+		report.IsSuccess = report.IsSuccess && vr.IsSuccess
+		report.VerifierReports[idx] = vr
+	}
+	respondwith.JSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,155 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGetAttestationStatus(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+	repo := s.Repos[0]
+
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, *repo, "latest")
+	pathForImage := fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_manifests/%s/attestations", image.Manifest.Digest)
+	token := s.GetToken(t, "repository:test1/foo:pull")
+	subject := "registry.example.org/test1/foo@" + image.Manifest.Digest.String()
+
+	// check error cases
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage,
+		ExpectStatus: http.StatusUnauthorized,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_manifests/%s/attestations", test.DeterministicDummyDigest(1)),
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("not found\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage,
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("account does not require attestations\n"),
+	}.Check(t, h)
+
+	// before the first verification, all required predicate types are reported as unverified
+	const (
+		provenanceType = "https://slsa.dev/provenance/v1"
+		vulnType       = "https://cosign.sigstore.dev/attestation/vuln/v1"
+	)
+	mustExec(t, s.DB, `UPDATE accounts SET required_attestation_types = $1 WHERE name = $2`, provenanceType+","+vulnType, "test1")
+	makeReport := func(predicateType string, isSuccess bool, message string, extensions assert.JSONObject) assert.JSONObject {
+		extensions["predicate_type"] = predicateType
+		report := assert.JSONObject{
+			"subject":      subject,
+			"isSuccess":    isSuccess,
+			"name":         "keppel",
+			"type":         "cosign-attestation",
+			"artifactType": keppel.DSSEEnvelopeMediaType,
+			"extensions":   extensions,
+		}
+		if message != "" {
+			report["message"] = message
+		}
+		return report
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage,
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"isSuccess": false,
+			"verifierReports": []assert.JSONObject{
+				makeReport(provenanceType, false, "attestations have not been verified yet", assert.JSONObject{"status": "unverified"}),
+				makeReport(vulnType, false, "attestations have not been verified yet", assert.JSONObject{"status": "unverified"}),
+			},
+		},
+	}.Check(t, h)
+
+	// report verification results (as they would be written by the janitor)
+	attestationDigest := test.DeterministicDummyDigest(2)
+	for _, predicateType := range []string{provenanceType, vulnType} {
+		mustInsert(t, s.DB, &models.AttestationResult{
+			RepositoryID:      repo.ID,
+			Digest:            image.Manifest.Digest,
+			PredicateType:     predicateType,
+			Status:            models.AttestationValid,
+			AttestationDigest: attestationDigest.String(),
+			CheckedAt:         s.Clock.Now(),
+		})
+	}
+	validExtensions := func() assert.JSONObject {
+		return assert.JSONObject{
+			"status":             "valid",
+			"attestation_digest": attestationDigest.String(),
+			"checked_at":         s.Clock.Now().Unix(),
+		}
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage,
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"isSuccess": true,
+			"verifierReports": []assert.JSONObject{
+				makeReport(provenanceType, true, "", validExtensions()),
+				makeReport(vulnType, true, "", validExtensions()),
+			},
+		},
+	}.Check(t, h)
+
+	// one invalid result fails the overall verification
+	mustExec(t, s.DB, `UPDATE attestation_results SET status = $1, message = $2 WHERE predicate_type = $3`,
+		models.AttestationInvalid, "DSSE envelope is not signed by any of the trusted public keys", vulnType)
+	invalidExtensions := validExtensions()
+	invalidExtensions["status"] = "invalid"
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage,
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"isSuccess": false,
+			"verifierReports": []assert.JSONObject{
+				makeReport(provenanceType, true, "", validExtensions()),
+				makeReport(vulnType, false, "DSSE envelope is not signed by any of the trusted public keys", invalidExtensions),
+			},
+		},
+	}.Check(t, h)
+}
@@ -43,3 +43,49 @@ func (a AuditSecurityScanPolicy) Render() cadf.Resource {
 		},
 	}
 }
+
+// AuditAccountName implements the keppel.AccountScopedAuditTarget interface.
+func (a AuditSecurityScanPolicy) AuditAccountName() models.AccountName {
+	return a.Account.Name
+}
+
+// AuditBlockedDigest is an audittools.Target for entries on the instance-wide
+// blocklist of digests.
+type AuditBlockedDigest struct {
+	Entry BlockedDigest
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditBlockedDigest) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI: "docker-registry/blocked-digest",
+		ID:      a.Entry.Digest.String(),
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("payload", a.Entry)),
+		},
+	}
+}
+
+// AuditAccountBlockedDigest is an audittools.Target for entries on the
+// blocklist of digests of a single account.
+type AuditAccountBlockedDigest struct {
+	Account models.Account
+	Entry   BlockedDigest
+}
+
+// Render implements the audittools.Target interface.
+func (a AuditAccountBlockedDigest) Render() cadf.Resource {
+	return cadf.Resource{
+		TypeURI:   "docker-registry/account",
+		ID:        string(a.Account.Name),
+		ProjectID: a.Account.AuthTenantID,
+		Attachments: []cadf.Attachment{
+			must.Return(cadf.NewJSONAttachment("blocked-digest", a.Entry)),
+		},
+	}
+}
+
+// AuditAccountName implements the keppel.AccountScopedAuditTarget interface.
+func (a AuditAccountBlockedDigest) AuditAccountName() models.AccountName {
+	return a.Account.Name
+}
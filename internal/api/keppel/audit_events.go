@@ -0,0 +1,143 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// AuditEvent represents a persisted audit event in the API.
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	RecordedAt int64           `json:"recorded_at"`
+	Action     string          `json:"action"`
+	Event      json.RawMessage `json:"event"`
+}
+
+func (a *API) handleGetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/audit-events")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	if a.cfg.AuditEventRetention == 0 {
+		http.Error(w, "persistence of audit events is not enabled on this Keppel instance", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// build query from filter options
+	query := r.URL.Query()
+	conditions := []string{"account_name = $1"}
+	bindValues := []any{account.Name}
+	addCondition := func(format string, values ...any) {
+		placeholders := make([]any, len(values))
+		for idx := range values {
+			placeholders[idx] = fmt.Sprintf("$%d", len(bindValues)+idx+1)
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+		bindValues = append(bindValues, values...)
+	}
+
+	for _, param := range []struct {
+		Name     string
+		Operator string
+	}{{"since", ">="}, {"until", "<"}} {
+		valueStr := query.Get(param.Name)
+		if valueStr == "" {
+			continue
+		}
+		value, err := strconv.ParseInt(valueStr, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid value for %q: %s", param.Name, err.Error()), http.StatusBadRequest)
+			return
+		}
+		addCondition("recorded_at "+param.Operator+" %s", time.Unix(value, 0))
+	}
+
+	if actionsStr := query.Get("action"); actionsStr != "" {
+		var actions []any
+		for _, action := range strings.Split(actionsStr, ",") {
+			actions = append(actions, strings.TrimSpace(action))
+		}
+		addCondition("action IN ("+strings.Repeat("%s, ", len(actions)-1)+"%s)", actions...)
+	}
+
+	// pagination works like for the other list endpoints, except that the
+	// marker is an event ID and events are listed from newest to oldest
+	if markerStr := query.Get("marker"); markerStr != "" {
+		marker, err := strconv.ParseInt(markerStr, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid value for \"marker\": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		addCondition("id < %s", marker)
+	}
+	limit := 1000
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limitVal, err := strconv.Atoi(limitStr)
+		if err != nil || limitVal <= 0 {
+			http.Error(w, fmt.Sprintf("invalid value for \"limit\": %q", limitStr), http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, limitVal)
+	}
+
+	var dbEvents []models.AuditEvent
+	_, err := a.db.Select(&dbEvents,
+		fmt.Sprintf(`SELECT * FROM audit_events WHERE %s ORDER BY id DESC LIMIT %d`, strings.Join(conditions, " AND "), limit+1),
+		bindValues...)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	result := struct {
+		AuditEvents []AuditEvent `json:"audit_events"`
+		IsTruncated bool         `json:"truncated,omitempty"`
+	}{
+		AuditEvents: make([]AuditEvent, 0, len(dbEvents)),
+	}
+	if len(dbEvents) > limit {
+		dbEvents = dbEvents[:limit]
+		result.IsTruncated = true
+	}
+	for _, dbEvent := range dbEvents {
+		result.AuditEvents = append(result.AuditEvents, AuditEvent{
+			ID:         dbEvent.ID,
+			RecordedAt: dbEvent.RecordedAt.Unix(),
+			Action:     dbEvent.Action,
+			Event:      json.RawMessage(dbEvent.CADFJSON),
+		})
+	}
+	respondwith.JSON(w, http.StatusOK, result)
+}
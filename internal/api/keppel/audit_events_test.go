@@ -0,0 +1,135 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/assert"
+
+	keppelv1 "github.com/sapcc/keppel/internal/api/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGetAuditEvents(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAuditEventStore,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+
+	// generate some audit events: pushing an image creates a manifest and a tag...
+	s.Clock.StepBy(time.Hour)
+	uploadedAt := s.Clock.Now()
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, *s.Repos[0], "latest")
+
+	// ...and then we delete the tag again
+	s.Clock.StepBy(time.Hour)
+	deletedAt := s.Clock.Now()
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_tags/latest",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,delete:tenant1"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+
+	// audit events can only be viewed by account admins
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/audit-events",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1,delete:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	type eventSummary struct {
+		RecordedAt int64
+		Action     string
+		TargetType string
+	}
+	getEvents := func(query string, expectTruncated bool) []eventSummary {
+		t.Helper()
+		_, respBytes := assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/audit-events" + query,
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+			ExpectStatus: http.StatusOK,
+		}.Check(t, h)
+
+		var data struct {
+			AuditEvents []keppelv1.AuditEvent `json:"audit_events"`
+			IsTruncated bool                  `json:"truncated"`
+		}
+		err := json.Unmarshal(respBytes, &data)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "truncated", data.IsTruncated, expectTruncated)
+
+		result := make([]eventSummary, len(data.AuditEvents))
+		for idx, event := range data.AuditEvents {
+			var cadfEvent cadf.Event
+			err := json.Unmarshal(event.Event, &cadfEvent)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "event.Action", event.Action, string(cadfEvent.Action))
+			result[idx] = eventSummary{event.RecordedAt, event.Action, cadfEvent.Target.TypeURI}
+		}
+		return result
+	}
+
+	// events are listed from newest to oldest
+	deleteTagEvent := eventSummary{deletedAt.Unix(), "delete", "docker-registry/account/repository/tag"}
+	createTagEvent := eventSummary{uploadedAt.Unix(), "create", "docker-registry/account/repository/tag"}
+	createManifestEvent := eventSummary{uploadedAt.Unix(), "create", "docker-registry/account/repository/manifest"}
+	assert.DeepEqual(t, "all events", getEvents("", false),
+		[]eventSummary{deleteTagEvent, createTagEvent, createManifestEvent})
+
+	// test filters
+	assert.DeepEqual(t, "events with action=delete", getEvents("?action=delete", false),
+		[]eventSummary{deleteTagEvent})
+	assert.DeepEqual(t, "events with action=create,update", getEvents("?action=create,update", false),
+		[]eventSummary{createTagEvent, createManifestEvent})
+	assert.DeepEqual(t, "events since deletion", getEvents(fmt.Sprintf("?since=%d", deletedAt.Unix()), false),
+		[]eventSummary{deleteTagEvent})
+	assert.DeepEqual(t, "events until deletion", getEvents(fmt.Sprintf("?until=%d", deletedAt.Unix()), false),
+		[]eventSummary{createTagEvent, createManifestEvent})
+
+	// test pagination
+	assert.DeepEqual(t, "first page", getEvents("?limit=2", true),
+		[]eventSummary{deleteTagEvent, createTagEvent})
+
+	// test error cases
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/audit-events?since=yesterday",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("invalid value for \"since\": strconv.ParseInt: parsing \"yesterday\": invalid syntax\n"),
+	}.Check(t, h)
+}
@@ -0,0 +1,239 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// BlockedDigest represents a models.BlockedDigest in the API.
+type BlockedDigest struct {
+	Digest    digest.Digest `json:"digest"`
+	Reason    string        `json:"reason"`
+	CreatedBy string        `json:"created_by,omitempty"`
+	CreatedAt int64         `json:"created_at"`
+}
+
+func renderBlockedDigests(entries []models.BlockedDigest) []BlockedDigest {
+	result := make([]BlockedDigest, len(entries))
+	for idx, e := range entries {
+		result[idx] = BlockedDigest{
+			Digest:    e.Digest,
+			Reason:    e.Reason,
+			CreatedBy: e.CreatedBy,
+			CreatedAt: e.CreatedAt.Unix(),
+		}
+	}
+	return result
+}
+
+var (
+	listBlockedDigestsQuery = sqlext.SimplifyWhitespace(`
+		SELECT * FROM blocked_digests WHERE account_name IS NOT DISTINCT FROM $1 ORDER BY digest
+	`)
+	findBlockedDigestInListQuery = sqlext.SimplifyWhitespace(`
+		SELECT * FROM blocked_digests WHERE account_name IS NOT DISTINCT FROM $1 AND digest = $2
+	`)
+	upsertBlockedDigestQuery = sqlext.SimplifyWhitespace(`
+		INSERT INTO blocked_digests (account_name, digest, reason, created_by, created_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (COALESCE(account_name, ''), digest) DO UPDATE SET reason = EXCLUDED.reason
+		RETURNING *
+	`)
+	deleteBlockedDigestQuery = sqlext.SimplifyWhitespace(`
+		DELETE FROM blocked_digests WHERE account_name IS NOT DISTINCT FROM $1 AND digest = $2 RETURNING *
+	`)
+)
+
+func (a *API) handleGetBlockedDigests(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/blocked_digests")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.ClusterStatusScope))
+	if authz == nil {
+		return
+	}
+	a.respondWithBlockedDigests(w, nil)
+}
+
+func (a *API) handlePutBlockedDigest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/blocked_digests/:digest")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.ClusterConfigScope))
+	if authz == nil {
+		return
+	}
+	a.putBlockedDigest(w, r, authz, nil, func(entry BlockedDigest) audittools.Target {
+		return AuditBlockedDigest{Entry: entry}
+	})
+}
+
+func (a *API) handleDeleteBlockedDigest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/blocked_digests/:digest")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.ClusterConfigScope))
+	if authz == nil {
+		return
+	}
+	a.deleteBlockedDigest(w, r, authz, nil, func(entry BlockedDigest) audittools.Target {
+		return AuditBlockedDigest{Entry: entry}
+	})
+}
+
+func (a *API) handleGetAccountBlockedDigests(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/blocked_digests")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	a.respondWithBlockedDigests(w, &account.Name)
+}
+
+func (a *API) handlePutAccountBlockedDigest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/blocked_digests/:digest")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	a.putBlockedDigest(w, r, authz, &account.Name, func(entry BlockedDigest) audittools.Target {
+		return AuditAccountBlockedDigest{Account: *account, Entry: entry}
+	})
+}
+
+func (a *API) handleDeleteAccountBlockedDigest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/blocked_digests/:digest")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	a.deleteBlockedDigest(w, r, authz, &account.Name, func(entry BlockedDigest) audittools.Target {
+		return AuditAccountBlockedDigest{Account: *account, Entry: entry}
+	})
+}
+
+// The functions below implement the endpoints for both the instance-wide
+// blocklist (accountName == nil) and the per-account blocklists.
+
+func (a *API) respondWithBlockedDigests(w http.ResponseWriter, accountName *models.AccountName) {
+	var entries []models.BlockedDigest
+	_, err := a.db.Select(&entries, listBlockedDigestsQuery, accountName)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"blocked_digests": renderBlockedDigests(entries)})
+}
+
+func (a *API) putBlockedDigest(w http.ResponseWriter, r *http.Request, authz *auth.Authorization, accountName *models.AccountName, makeAuditTarget func(BlockedDigest) audittools.Target) {
+	blockedDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "invalid digest: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var req struct {
+		BlockedDigest struct {
+			Reason string `json:"reason"`
+		} `json:"blocked_digest"`
+	}
+	ok := decodeJSONRequestBody(w, r.Body, &req)
+	if !ok {
+		return
+	}
+	if req.BlockedDigest.Reason == "" {
+		http.Error(w, `missing attribute "blocked_digest.reason" in request body`, http.StatusUnprocessableEntity)
+		return
+	}
+
+	var existing models.BlockedDigest
+	err = a.db.SelectOne(&existing, findBlockedDigestInListQuery, accountName, blockedDigest.String())
+	isNew := errors.Is(err, sql.ErrNoRows)
+	if !isNew && respondwith.ErrorText(w, err) {
+		return
+	}
+
+	var entry models.BlockedDigest
+	err = a.db.SelectOne(&entry, upsertBlockedDigestQuery,
+		accountName, blockedDigest.String(), req.BlockedDigest.Reason, authz.UserIdentity.UserName(), a.timeNow())
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	rendered := renderBlockedDigests([]models.BlockedDigest{entry})[0]
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil && (isNew || existing.Reason != entry.Reason) {
+		action := cadf.UpdateAction
+		if isNew {
+			action = cadf.CreateAction
+		}
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     action,
+			Target:     makeAuditTarget(rendered),
+		})
+	}
+
+	respondwith.JSON(w, http.StatusOK, map[string]any{"blocked_digest": rendered})
+}
+
+func (a *API) deleteBlockedDigest(w http.ResponseWriter, r *http.Request, authz *auth.Authorization, accountName *models.AccountName, makeAuditTarget func(BlockedDigest) audittools.Target) {
+	var entry models.BlockedDigest
+	err := a.db.SelectOne(&entry, deleteBlockedDigestQuery, accountName, mux.Vars(r)["digest"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "blocked digest not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if userInfo := authz.UserIdentity.UserInfo(); userInfo != nil {
+		a.auditor.Record(audittools.Event{
+			Time:       a.timeNow(),
+			Request:    r,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     cadf.DeleteAction,
+			Target:     makeAuditTarget(renderBlockedDigests([]models.BlockedDigest{entry})[0]),
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,233 @@
+/*******************************************************************************
+*
+* Copyright 2026 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/assert"
+
+	keppelv1 "github.com/sapcc/keppel/internal/api/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestBlockedDigests(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	dummyDigest := test.DeterministicDummyDigest(1)
+
+	for _, path := range []string{"/keppel/v1/blocked_digests", "/keppel/v1/accounts/test1/blocked_digests"} {
+		// check permissions
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path,
+			Header:       map[string]string{"X-Test-Perms": "pull:tenant1"},
+			ExpectStatus: http.StatusForbidden,
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "PUT",
+			Path:         path + "/" + dummyDigest.String(),
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,viewcluster:"},
+			Body:         assert.JSONObject{"blocked_digest": assert.JSONObject{"reason": "malware"}},
+			ExpectStatus: http.StatusForbidden,
+		}.Check(t, h)
+	}
+
+	// the instance-wide blocklist is managed by cloud admins, the per-account
+	// blocklist by the account owners
+	header := map[string]string{"X-Test-Perms": "changecluster:,viewcluster:"}
+	path := "/keppel/v1/blocked_digests"
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       header,
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"blocked_digests": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// check validation errors
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path + "/sha256:foo",
+		Header:       header,
+		Body:         assert.JSONObject{"blocked_digest": assert.JSONObject{"reason": "malware"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path + "/" + dummyDigest.String(),
+		Header:       header,
+		Body:         assert.JSONObject{"blocked_digest": assert.JSONObject{"reason": ""}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("missing attribute \"blocked_digest.reason\" in request body\n"),
+	}.Check(t, h)
+	s.Auditor.ExpectEvents(t /*, nothing */)
+
+	// block a digest instance-wide
+	expectedEntry := assert.JSONObject{
+		"digest":     dummyDigest,
+		"reason":     "malware",
+		"created_at": s.Clock.Now().Unix(),
+	}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path + "/" + dummyDigest.String(),
+		Header:       header,
+		Body:         assert.JSONObject{"blocked_digest": assert.JSONObject{"reason": "malware"}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"blocked_digest": expectedEntry},
+	}.Check(t, h)
+	s.Auditor.ExpectEvents(t, cadf.Event{
+		RequestPath: path + "/" + dummyDigest.String(),
+		Action:      cadf.CreateAction,
+		Outcome:     "success",
+		Reason:      test.CADFReasonOK,
+		Target: cadf.Resource{
+			TypeURI: "docker-registry/blocked-digest",
+			ID:      dummyDigest.String(),
+			Attachments: []cadf.Attachment{{
+				Name:    "payload",
+				TypeURI: "mime:application/json",
+				Content: toJSONVia[keppelv1.BlockedDigest](expectedEntry),
+			}},
+		},
+	})
+
+	// repeating the PUT is idempotent and does not generate another audit event
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path + "/" + dummyDigest.String(),
+		Header:       header,
+		Body:         assert.JSONObject{"blocked_digest": assert.JSONObject{"reason": "malware"}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"blocked_digest": expectedEntry},
+	}.Check(t, h)
+	s.Auditor.ExpectEvents(t /*, nothing */)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       header,
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"blocked_digests": []assert.JSONObject{expectedEntry}},
+	}.Check(t, h)
+
+	// the per-account blocklist is separate from the instance-wide one
+	accountPath := "/keppel/v1/accounts/test1/blocked_digests"
+	accountHeader := map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         accountPath,
+		Header:       accountHeader,
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"blocked_digests": []assert.JSONObject{}},
+	}.Check(t, h)
+	expectedAccountEntry := assert.JSONObject{
+		"digest":     dummyDigest,
+		"reason":     "leaked credentials",
+		"created_at": s.Clock.Now().Unix(),
+	}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         accountPath + "/" + dummyDigest.String(),
+		Header:       accountHeader,
+		Body:         assert.JSONObject{"blocked_digest": assert.JSONObject{"reason": "leaked credentials"}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"blocked_digest": expectedAccountEntry},
+	}.Check(t, h)
+	s.Auditor.ExpectEvents(t, cadf.Event{
+		RequestPath: accountPath + "/" + dummyDigest.String(),
+		Action:      cadf.CreateAction,
+		Outcome:     "success",
+		Reason:      test.CADFReasonOK,
+		Target: cadf.Resource{
+			TypeURI:   "docker-registry/account",
+			ID:        "test1",
+			ProjectID: "tenant1",
+			Attachments: []cadf.Attachment{{
+				Name:    "blocked-digest",
+				TypeURI: "mime:application/json",
+				Content: toJSONVia[keppelv1.BlockedDigest](expectedAccountEntry),
+			}},
+		},
+	})
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         accountPath,
+		Header:       accountHeader,
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"blocked_digests": []assert.JSONObject{expectedAccountEntry}},
+	}.Check(t, h)
+
+	// unblock the digest again
+	for _, p := range []string{path, accountPath} {
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         p + "/" + dummyDigest.String(),
+			Header:       map[string]string{"X-Test-Perms": "changecluster:,change:tenant1"},
+			ExpectStatus: http.StatusNoContent,
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         p + "/" + dummyDigest.String(),
+			Header:       map[string]string{"X-Test-Perms": "changecluster:,change:tenant1"},
+			ExpectStatus: http.StatusNotFound,
+			ExpectBody:   assert.StringData("blocked digest not found\n"),
+		}.Check(t, h)
+	}
+	s.Auditor.ExpectEvents(t,
+		cadf.Event{
+			RequestPath: path + "/" + dummyDigest.String(),
+			Action:      cadf.DeleteAction,
+			Outcome:     "success",
+			Reason:      test.CADFReasonOK,
+			Target: cadf.Resource{
+				TypeURI: "docker-registry/blocked-digest",
+				ID:      dummyDigest.String(),
+				Attachments: []cadf.Attachment{{
+					Name:    "payload",
+					TypeURI: "mime:application/json",
+					Content: toJSONVia[keppelv1.BlockedDigest](expectedEntry),
+				}},
+			},
+		},
+		cadf.Event{
+			RequestPath: accountPath + "/" + dummyDigest.String(),
+			Action:      cadf.DeleteAction,
+			Outcome:     "success",
+			Reason:      test.CADFReasonOK,
+			Target: cadf.Resource{
+				TypeURI:   "docker-registry/account",
+				ID:        "test1",
+				ProjectID: "tenant1",
+				Attachments: []cadf.Attachment{{
+					Name:    "blocked-digest",
+					TypeURI: "mime:application/json",
+					Content: toJSONVia[keppelv1.BlockedDigest](expectedAccountEntry),
+				}},
+			},
+		},
+	)
+}
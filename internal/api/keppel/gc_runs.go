@@ -0,0 +1,136 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// GCRun represents a models.GCRun in the API.
+type GCRun struct {
+	ID                int64                      `json:"id"`
+	RepositoryName    string                     `json:"repository"`
+	StartedAt         int64                      `json:"started_at"`
+	FinishedAt        int64                      `json:"finished_at"`
+	PoliciesEvaluated uint64                     `json:"policies_evaluated"`
+	ManifestsDeleted  uint64                     `json:"manifests_deleted"`
+	BytesFreed        uint64                     `json:"bytes_freed"`
+	DeletedManifests  []keppel.GCDeletedManifest `json:"deleted_manifests"`
+	ErrorMessage      string                     `json:"error,omitempty"`
+}
+
+func (a *API) handleGetGCRuns(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/gc-runs")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	// build query from filter options
+	query := r.URL.Query()
+	conditions := []string{"account_name = $1"}
+	bindValues := []any{account.Name}
+	addCondition := func(format string, value any) {
+		conditions = append(conditions, fmt.Sprintf(format, fmt.Sprintf("$%d", len(bindValues)+1)))
+		bindValues = append(bindValues, value)
+	}
+
+	if repoName := query.Get("repository"); repoName != "" {
+		addCondition("repo_name = %s", repoName)
+	}
+	if query.Get("only_with_deletions") == "true" {
+		conditions = append(conditions, "manifests_deleted > 0")
+	}
+	if query.Get("only_with_errors") == "true" {
+		conditions = append(conditions, "error_message != ''")
+	}
+
+	// pagination works like for audit events: the marker is a run ID, and runs
+	// are listed from newest to oldest
+	if markerStr := query.Get("marker"); markerStr != "" {
+		marker, err := strconv.ParseInt(markerStr, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid value for \"marker\": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		addCondition("id < %s", marker)
+	}
+	limit := 1000
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limitVal, err := strconv.Atoi(limitStr)
+		if err != nil || limitVal <= 0 {
+			http.Error(w, fmt.Sprintf("invalid value for \"limit\": %q", limitStr), http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, limitVal)
+	}
+
+	var dbRuns []models.GCRun
+	_, err := a.db.Select(&dbRuns,
+		fmt.Sprintf(`SELECT * FROM gc_runs WHERE %s ORDER BY id DESC LIMIT %d`, strings.Join(conditions, " AND "), limit+1),
+		bindValues...)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	result := struct {
+		GCRuns      []GCRun `json:"gc_runs"`
+		IsTruncated bool    `json:"truncated,omitempty"`
+	}{
+		GCRuns: make([]GCRun, 0, len(dbRuns)),
+	}
+	if len(dbRuns) > limit {
+		dbRuns = dbRuns[:limit]
+		result.IsTruncated = true
+	}
+	for _, dbRun := range dbRuns {
+		deletedManifests := []keppel.GCDeletedManifest{}
+		err := json.Unmarshal([]byte(dbRun.DeletedManifestsJSON), &deletedManifests)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+		result.GCRuns = append(result.GCRuns, GCRun{
+			ID:                dbRun.ID,
+			RepositoryName:    dbRun.RepositoryName,
+			StartedAt:         dbRun.StartedAt.Unix(),
+			FinishedAt:        dbRun.FinishedAt.Unix(),
+			PoliciesEvaluated: dbRun.PoliciesEvaluated,
+			ManifestsDeleted:  dbRun.ManifestsDeleted,
+			BytesFreed:        dbRun.BytesFreed,
+			DeletedManifests:  deletedManifests,
+			ErrorMessage:      dbRun.ErrorMessage,
+		})
+	}
+	respondwith.JSON(w, http.StatusOK, result)
+}
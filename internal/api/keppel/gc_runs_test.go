@@ -0,0 +1,190 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGetGCRuns(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	// without any GC runs, the list is empty
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/gc-runs",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"gc_runs": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// setup some GC runs (one of them in a different account, which shall not be shown)
+	t0 := s.Clock.Now()
+	runs := []models.GCRun{
+		{
+			AccountName:          "test1",
+			RepositoryName:       "foo",
+			StartedAt:            t0,
+			FinishedAt:           t0.Add(time.Second),
+			PoliciesEvaluated:    1,
+			DeletedManifestsJSON: "[]",
+		},
+		{
+			AccountName:          "test2",
+			RepositoryName:       "foo",
+			StartedAt:            t0,
+			FinishedAt:           t0.Add(time.Second),
+			PoliciesEvaluated:    1,
+			DeletedManifestsJSON: "[]",
+		},
+		{
+			AccountName:          "test1",
+			RepositoryName:       "bar",
+			StartedAt:            t0.Add(time.Hour),
+			FinishedAt:           t0.Add(time.Hour + time.Second),
+			PoliciesEvaluated:    2,
+			ManifestsDeleted:     1,
+			BytesFreed:           1234,
+			DeletedManifestsJSON: `[{"digest":"sha256:a3f7b4f2f1b9d3f5e1a47f8f6c2d9e0b1c3d5e7f9a1b3c5d7e9f1a3b5c7d9e1f","tags":["latest"],"size_bytes":1234,"policy":{"match_repository":".*","only_untagged":true,"action":"delete"}}]`,
+		},
+		{
+			AccountName:          "test1",
+			RepositoryName:       "foo",
+			StartedAt:            t0.Add(2 * time.Hour),
+			FinishedAt:           t0.Add(2*time.Hour + time.Second),
+			PoliciesEvaluated:    1,
+			DeletedManifestsJSON: "[]",
+			ErrorMessage:         "something went wrong",
+		},
+	}
+	for _, run := range runs {
+		err := s.DB.Insert(&run)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// GC runs are listed from newest to oldest
+	runJSON1 := assert.JSONObject{
+		"id":                 1,
+		"repository":         "foo",
+		"started_at":         t0.Unix(),
+		"finished_at":        t0.Add(time.Second).Unix(),
+		"policies_evaluated": 1,
+		"manifests_deleted":  0,
+		"bytes_freed":        0,
+		"deleted_manifests":  []assert.JSONObject{},
+	}
+	runJSON3 := assert.JSONObject{
+		"id":                 3,
+		"repository":         "bar",
+		"started_at":         t0.Add(time.Hour).Unix(),
+		"finished_at":        t0.Add(time.Hour + time.Second).Unix(),
+		"policies_evaluated": 2,
+		"manifests_deleted":  1,
+		"bytes_freed":        1234,
+		"deleted_manifests": []assert.JSONObject{{
+			"digest":     "sha256:a3f7b4f2f1b9d3f5e1a47f8f6c2d9e0b1c3d5e7f9a1b3c5d7e9f1a3b5c7d9e1f",
+			"tags":       []string{"latest"},
+			"size_bytes": 1234,
+			"policy": assert.JSONObject{
+				"match_repository": ".*",
+				"only_untagged":    true,
+				"action":           "delete",
+			},
+		}},
+	}
+	runJSON4 := assert.JSONObject{
+		"id":                 4,
+		"repository":         "foo",
+		"started_at":         t0.Add(2 * time.Hour).Unix(),
+		"finished_at":        t0.Add(2*time.Hour + time.Second).Unix(),
+		"policies_evaluated": 1,
+		"manifests_deleted":  0,
+		"bytes_freed":        0,
+		"deleted_manifests":  []assert.JSONObject{},
+		"error":              "something went wrong",
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/gc-runs",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"gc_runs": []assert.JSONObject{runJSON4, runJSON3, runJSON1}},
+	}.Check(t, h)
+
+	// test filters
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/gc-runs?repository=foo",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"gc_runs": []assert.JSONObject{runJSON4, runJSON1}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/gc-runs?only_with_deletions=true",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"gc_runs": []assert.JSONObject{runJSON3}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/gc-runs?only_with_errors=true",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"gc_runs": []assert.JSONObject{runJSON4}},
+	}.Check(t, h)
+
+	// test pagination
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/gc-runs?limit=2",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"gc_runs": []assert.JSONObject{runJSON4, runJSON3}, "truncated": true},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/gc-runs?limit=2&marker=3",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"gc_runs": []assert.JSONObject{runJSON1}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/gc-runs?limit=foo",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("invalid value for \"limit\": \"foo\"\n"),
+	}.Check(t, h)
+}
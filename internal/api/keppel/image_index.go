@@ -0,0 +1,109 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/errext"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/api"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/processor"
+)
+
+func (a *API) handlePostAssembleImageIndex(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_assemble_index")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPushToAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	if rerr := api.CheckReadOnlyMode(a.cfg, account.Reduced()); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
+	if account.UpstreamPeerHostName != "" || account.ExternalPeerURL != "" {
+		http.Error(w, "cannot push into replica account", http.StatusMethodNotAllowed)
+		return
+	}
+	if account.IsDeleting {
+		http.Error(w, "account is being deleted", http.StatusConflict)
+		return
+	}
+
+	err := api.CheckRateLimit(r, a.rle, account.Reduced(), authz, keppel.ManifestPushAction, 1)
+	if err != nil {
+		if rerr, ok := errext.As[*keppel.RegistryV2Error](err); ok && rerr != nil {
+			rerr.WriteAsRegistryV2ResponseTo(w, r)
+			return
+		} else if respondwith.ErrorText(w, err) {
+			return
+		}
+	}
+
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+
+	var req struct {
+		Manifests []processor.ImageIndexEntry `json:"manifests"`
+		Tag       string                      `json:"tag"`
+	}
+	if !decodeJSONRequestBody(w, r.Body, &req) {
+		return
+	}
+	if req.Tag != "" && !models.TagNameRx.MatchString(req.Tag) {
+		http.Error(w, fmt.Sprintf("invalid tag name: %q", req.Tag), http.StatusUnprocessableEntity)
+		return
+	}
+	for _, entry := range req.Manifests {
+		if entry.Digest.Validate() != nil {
+			http.Error(w, fmt.Sprintf("invalid manifest digest: %q", entry.Digest), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	manifest, err := a.processor().AssembleImageIndex(r.Context(), account.Reduced(), *repo, req.Manifests, req.Tag, keppel.AuditContext{
+		UserIdentity: authz.UserIdentity,
+		Request:      r,
+	})
+	if err != nil {
+		keppel.AsRegistryV2Error(err).WriteAsTextTo(w)
+		return
+	}
+
+	result := struct {
+		Digest    digest.Digest `json:"digest"`
+		MediaType string        `json:"media_type"`
+		SizeBytes uint64        `json:"size_bytes"`
+		Tag       string        `json:"tag,omitempty"`
+	}{manifest.Digest, manifest.MediaType, manifest.SizeBytes, req.Tag}
+	respondwith.JSON(w, http.StatusCreated, map[string]any{"manifest": result})
+}
@@ -0,0 +1,143 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestAssembleImageIndex(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithQuotas,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+	repo := s.Repos[0]
+
+	image1 := test.GenerateImage(test.GenerateExampleLayer(1))
+	image1.MustUpload(t, s, *repo, "")
+	image2 := test.GenerateImage(test.GenerateExampleLayer(2))
+	image2.MustUpload(t, s, *repo, "")
+	imageList := test.GenerateImageList(image1, image2)
+	imageList.MustUpload(t, s, *repo, "list")
+
+	path := "/keppel/v1/accounts/test1/repositories/foo/_assemble_index"
+	header := map[string]string{"X-Test-Perms": "view:tenant1,push:tenant1"}
+	entry := func(d fmt.Stringer, os, arch string) assert.JSONObject {
+		return assert.JSONObject{"digest": d.String(), "platform": assert.JSONObject{"os": os, "architecture": arch}}
+	}
+
+	// check permission
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		Body:         assert.JSONObject{"manifests": []assert.JSONObject{entry(image1.Manifest.Digest, "linux", "amd64")}},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// check error cases
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"manifests": []assert.JSONObject{}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("cannot assemble image index without any manifests\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"manifests": []assert.JSONObject{entry(image1.Manifest.Digest, "linux", "amd64")}, "tag": "-invalid"},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("invalid tag name: \"-invalid\"\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"manifests": []assert.JSONObject{entry(image1.Manifest.Digest, "linux", "")}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData(fmt.Sprintf("platform for manifest %s must have at least \"os\" and \"architecture\"\n", image1.Manifest.Digest)),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"manifests": []assert.JSONObject{entry(test.DeterministicDummyDigest(1), "linux", "amd64")}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData(fmt.Sprintf("manifest %s does not exist in this repository\n", test.DeterministicDummyDigest(1))),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"manifests": []assert.JSONObject{entry(imageList.Manifest.Digest, "linux", "amd64")}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody: assert.StringData(fmt.Sprintf("manifest %s has media type %s, but only image manifests can be referenced by an image index\n",
+			imageList.Manifest.Digest, imageList.Manifest.MediaType)),
+	}.Check(t, h)
+
+	// success case
+	_, respBody := assert.HTTPRequest{
+		Method: "POST",
+		Path:   path,
+		Header: header,
+		Body: assert.JSONObject{
+			"manifests": []assert.JSONObject{
+				entry(image1.Manifest.Digest, "linux", "amd64"),
+				entry(image2.Manifest.Digest, "linux", "arm64"),
+			},
+			"tag": "latest",
+		},
+		ExpectStatus: http.StatusCreated,
+	}.Check(t, h)
+
+	// the image index is stored like a pushed manifest, including its references to the image manifests
+	indexDigest, err := s.DB.SelectStr(`SELECT digest FROM tags WHERE repo_id = $1 AND name = $2`, repo.ID, "latest")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "response body", string(respBody),
+		fmt.Sprintf(`{"manifest":{"digest":%q,"media_type":%q,"size_bytes":%d,"tag":"latest"}}`+"\n",
+			indexDigest, imgspecv1.MediaTypeImageIndex, mustSelectInt(t, s, `SELECT size_bytes FROM manifests WHERE repo_id = $1 AND digest = $2`, repo.ID, indexDigest)))
+	assert.DeepEqual(t, "number of child manifests", mustSelectInt(t, s,
+		`SELECT COUNT(*) FROM manifest_manifest_refs WHERE repo_id = $1 AND parent_digest = $2`, repo.ID, indexDigest), int64(2))
+}
+
+func mustSelectInt(t *testing.T, s test.Setup, query string, args ...any) int64 {
+	t.Helper()
+	result, err := s.DB.SelectInt(query, args...)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1
+
+import (
+	"net/http"
+
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// JanitorJob represents a models.JanitorJob in the API.
+type JanitorJob struct {
+	Name             string `json:"name"`
+	LastRunAt        *int64 `json:"last_run_at,omitempty"`
+	LastError        string `json:"last_error,omitempty"`
+	Backlog          uint64 `json:"backlog"`
+	BacklogCheckedAt *int64 `json:"backlog_checked_at,omitempty"`
+}
+
+func (a *API) handleGetJobs(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/jobs")
+	authz := a.authenticateRequest(w, r, auth.NewScopeSet(auth.ClusterStatusScope))
+	if authz == nil {
+		return
+	}
+
+	var dbJobs []models.JanitorJob
+	_, err := a.db.Select(&dbJobs, `SELECT * FROM janitor_jobs ORDER BY name`)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	jobs := make([]JanitorJob, len(dbJobs))
+	for idx, dbJob := range dbJobs {
+		jobs[idx] = JanitorJob{
+			Name:             dbJob.Name,
+			LastRunAt:        keppel.MaybeTimeToUnix(dbJob.LastRunAt),
+			LastError:        dbJob.LastError,
+			Backlog:          dbJob.Backlog,
+			BacklogCheckedAt: keppel.MaybeTimeToUnix(dbJob.BacklogCheckedAt),
+		}
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"jobs": jobs})
+}
@@ -0,0 +1,88 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestJobsAPI(t *testing.T) {
+	s := test.NewSetup(t, test.WithKeppelAPI)
+	h := s.Handler
+
+	// check empty response when the janitor has not reported anything yet
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/jobs",
+		Header:       map[string]string{"X-Test-Perms": "viewcluster:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"jobs": []any{}},
+	}.Check(t, h)
+
+	// add some job statuses
+	lastRunAt := time.Unix(2000, 0)
+	backlogCheckedAt := time.Unix(3000, 0)
+	mustInsert(t, s.DB, &models.JanitorJob{
+		Name:             "blob_sweep",
+		LastRunAt:        &lastRunAt,
+		Backlog:          5,
+		BacklogCheckedAt: &backlogCheckedAt,
+	})
+	mustInsert(t, s.DB, &models.JanitorJob{
+		Name:      "audit_event_cleanup",
+		LastRunAt: &lastRunAt,
+		LastError: "datacenter on fire",
+	})
+	mustInsert(t, s.DB, &models.JanitorJob{
+		Name: "manifest_sync",
+	})
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/jobs",
+		Header:       map[string]string{"X-Test-Perms": "viewcluster:"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"jobs": []assert.JSONObject{
+			{"name": "audit_event_cleanup", "last_run_at": 2000, "last_error": "datacenter on fire", "backlog": 0},
+			{"name": "blob_sweep", "last_run_at": 2000, "backlog": 5, "backlog_checked_at": 3000},
+			{"name": "manifest_sync", "backlog": 0},
+		}},
+	}.Check(t, h)
+
+	// error cases: permissions on individual auth tenants do not suffice
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/jobs",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,viewquota:tenant1,changequota:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/jobs",
+		ExpectStatus: http.StatusUnauthorized,
+	}.Check(t, h)
+}
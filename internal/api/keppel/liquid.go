@@ -20,6 +20,7 @@
 package keppelv1
 
 import (
+	"database/sql"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -27,13 +28,22 @@ import (
 	"github.com/sapcc/go-bits/errext"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
 
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/processor"
 )
 
 // Increment this whenever the output of handleLiquidGetInfo() changes.
-const LiquidInfoVersion int64 = 1
+const LiquidInfoVersion int64 = 2
+
+var accountEgressBytesByTenantQuery = sqlext.SimplifyWhitespace(`
+	SELECT a.name, COALESCE(e.bytes, 0)
+	  FROM accounts a
+	  LEFT OUTER JOIN account_egress_counters e ON e.account_name = a.name
+	 WHERE a.auth_tenant_id = $1
+	 ORDER BY a.name
+`)
 
 func (a *API) handleLiquidGetInfo(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/liquid/v1/info")
@@ -47,6 +57,13 @@ func (a *API) handleLiquidGetInfo(w http.ResponseWriter, r *http.Request) {
 				HasQuota:    true,
 			},
 		},
+		UsageMetricFamilies: map[liquid.MetricName]liquid.MetricFamilyInfo{
+			"egress_bytes": {
+				Type:      liquid.MetricTypeCounter,
+				Help:      "Number of bytes served for blob pulls from a Keppel account.",
+				LabelKeys: []string{"account"},
+			},
+		},
 	})
 }
 
@@ -86,7 +103,25 @@ func (a *API) handleLiquidReportUsage(w http.ResponseWriter, r *http.Request) {
 	if respondwith.ErrorText(w, err) {
 		return
 	}
-	respondwith.JSON(w, http.StatusOK, liquidConvertQuotaResponse(*resp))
+	report := liquidConvertQuotaResponse(*resp)
+
+	// egress is only counted if account metrics are enabled, but the metric
+	// family is always reported to keep the output consistent with our ServiceInfo
+	egressMetrics := []liquid.Metric{}
+	err = sqlext.ForeachRow(a.db, accountEgressBytesByTenantQuery, []any{authTenantID}, func(rows *sql.Rows) error {
+		var (
+			accountName string
+			bytes       uint64
+		)
+		err := rows.Scan(&accountName, &bytes)
+		egressMetrics = append(egressMetrics, liquid.Metric{Value: float64(bytes), LabelValues: []string{accountName}})
+		return err
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	report.Metrics["egress_bytes"] = egressMetrics
+	respondwith.JSON(w, http.StatusOK, report)
 }
 
 func pointerTo[T any](value T) *T {
@@ -0,0 +1,158 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// ManifestSearchResult represents a manifest in the response of
+// GET /keppel/v1/accounts/:account/manifests.
+type ManifestSearchResult struct {
+	RepositoryName  string          `json:"repository"`
+	Digest          digest.Digest   `json:"digest"`
+	MediaType       string          `json:"media_type"`
+	SizeBytes       uint64          `json:"size_bytes"`
+	PushedAt        int64           `json:"pushed_at"`
+	LastPulledAt    *int64          `json:"last_pulled_at"`
+	ArtifactType    string          `json:"artifact_type,omitempty"`
+	SubjectDigest   string          `json:"subject_digest,omitempty"`
+	AnnotationsJSON json.RawMessage `json:"annotations,omitempty"`
+}
+
+var manifestSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT r.name, m.digest, m.media_type, m.size_bytes, m.pushed_at, m.last_pulled_at, m.artifact_type, m.subject_digest, m.annotations_json
+	  FROM manifests m
+	  JOIN repos r ON r.id = m.repo_id
+	 WHERE r.account_name = $1 AND $FILTERS AND $CONDITION
+	 ORDER BY r.name || '@' || m.digest ASC
+	 LIMIT $LIMIT
+`)
+
+const annotationFilterPrefix = "annotation."
+
+func (a *API) handleSearchManifests(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/manifests")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	// build query from filter options
+	query := r.URL.Query()
+	filters := []string{"TRUE"}
+	bindValues := []any{account.Name}
+	addFilter := func(format string, value any) {
+		filters = append(filters, fmt.Sprintf(format, fmt.Sprintf("$%d", len(bindValues)+1)))
+		bindValues = append(bindValues, value)
+	}
+
+	if artifactType := query.Get("artifact_type"); artifactType != "" {
+		addFilter("m.artifact_type = %s", artifactType)
+	}
+	if subjectStr := query.Get("subject"); subjectStr != "" {
+		subjectDigest, err := digest.Parse(subjectStr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid value for \"subject\": %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		addFilter("m.subject_digest = %s", subjectDigest.String())
+	}
+	annotations := make(map[string]string)
+	for key, values := range query {
+		if !strings.HasPrefix(key, annotationFilterPrefix) {
+			continue
+		}
+		if len(values) != 1 {
+			http.Error(w, fmt.Sprintf("multiple values given for %q", key), http.StatusBadRequest)
+			return
+		}
+		annotations[strings.TrimPrefix(key, annotationFilterPrefix)] = values[0]
+	}
+	if len(annotations) > 0 {
+		annotationsJSON, err := json.Marshal(annotations)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+		addFilter("NULLIF(m.annotations_json, '')::jsonb @> %s::jsonb", string(annotationsJSON))
+	}
+
+	// the marker is "<repo>@<digest>" of the last manifest on the previous page
+	sqlQuery, bindValues, limit, err := paginatedQuery{
+		SQL:             strings.Replace(manifestSearchQuery, `$FILTERS`, strings.Join(filters, " AND "), 1),
+		MarkerCondition: `r.name || '@' || m.digest > $MARKER`,
+		Options:         query,
+		BindValues:      bindValues,
+	}.Prepare()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result struct {
+		Manifests   []ManifestSearchResult `json:"manifests"`
+		IsTruncated bool                   `json:"truncated,omitempty"`
+	}
+	err = sqlext.ForeachRow(a.db, sqlQuery, bindValues, func(rows *sql.Rows) error {
+		var (
+			m            ManifestSearchResult
+			pushedAt     time.Time
+			lastPulledAt *time.Time
+			annotations  string
+		)
+		err := rows.Scan(&m.RepositoryName, &m.Digest, &m.MediaType, &m.SizeBytes, &pushedAt, &lastPulledAt,
+			&m.ArtifactType, &m.SubjectDigest, &annotations)
+		if err == nil {
+			m.PushedAt = pushedAt.Unix()
+			m.LastPulledAt = keppel.MaybeTimeToUnix(lastPulledAt)
+			m.AnnotationsJSON = json.RawMessage(annotations)
+			result.Manifests = append(result.Manifests, m)
+		}
+		return err
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if result.Manifests == nil {
+		result.Manifests = []ManifestSearchResult{}
+	}
+	if uint64(len(result.Manifests)) > limit {
+		result.Manifests = result.Manifests[0:limit]
+		result.IsTruncated = true
+	}
+	respondwith.JSON(w, http.StatusOK, result)
+}
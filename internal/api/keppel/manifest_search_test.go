@@ -0,0 +1,189 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestSearchManifests(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+
+	fooRepo, err := keppel.FindOrCreateRepository(s.DB, "foo", "test1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	barRepo, err := keppel.FindOrCreateRepository(s.DB, "bar", "test1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	otherRepo, err := keppel.FindOrCreateRepository(s.DB, "foo", "test2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// setup an image, a signature referring to it, and an SBOM referring to some other image
+	// (plus another SBOM in a different account, which shall not be shown)
+	imageDigest := test.DeterministicDummyDigest(1)
+	signatureDigest := test.DeterministicDummyDigest(2)
+	sbomDigest := test.DeterministicDummyDigest(3)
+	otherDigest := test.DeterministicDummyDigest(4)
+	pushedAt := time.Unix(10000, 0)
+	manifests := []models.Manifest{
+		{
+			RepositoryID: fooRepo.ID,
+			Digest:       imageDigest,
+			MediaType:    "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:    1000,
+		},
+		{
+			RepositoryID:    fooRepo.ID,
+			Digest:          signatureDigest,
+			MediaType:       "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:       2000,
+			ArtifactType:    "application/vnd.dev.sigstore.bundle.v0.3+json",
+			SubjectDigest:   imageDigest.String(),
+			AnnotationsJSON: `{"org.opencontainers.image.created":"2025-01-01T00:00:00Z"}`,
+		},
+		{
+			RepositoryID:    barRepo.ID,
+			Digest:          sbomDigest,
+			MediaType:       "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:       3000,
+			ArtifactType:    "application/spdx+json",
+			SubjectDigest:   otherDigest.String(),
+			AnnotationsJSON: `{"com.example.kind":"sbom","org.opencontainers.image.created":"2025-01-01T00:00:00Z"}`,
+		},
+		{
+			RepositoryID:    otherRepo.ID,
+			Digest:          sbomDigest,
+			MediaType:       "application/vnd.oci.image.manifest.v1+json",
+			SizeBytes:       3000,
+			ArtifactType:    "application/spdx+json",
+			SubjectDigest:   otherDigest.String(),
+			AnnotationsJSON: `{"com.example.kind":"sbom"}`,
+		},
+	}
+	for _, manifest := range manifests {
+		manifest.PushedAt = pushedAt
+		manifest.NextValidationAt = pushedAt.Add(models.ManifestValidationInterval)
+		mustInsert(t, s.DB, &manifest)
+	}
+
+	signatureJSON := assert.JSONObject{
+		"repository":     "foo",
+		"digest":         signatureDigest.String(),
+		"media_type":     "application/vnd.oci.image.manifest.v1+json",
+		"size_bytes":     2000,
+		"pushed_at":      10000,
+		"last_pulled_at": nil,
+		"artifact_type":  "application/vnd.dev.sigstore.bundle.v0.3+json",
+		"subject_digest": imageDigest.String(),
+		"annotations":    assert.JSONObject{"org.opencontainers.image.created": "2025-01-01T00:00:00Z"},
+	}
+	sbomJSON := assert.JSONObject{
+		"repository":     "bar",
+		"digest":         sbomDigest.String(),
+		"media_type":     "application/vnd.oci.image.manifest.v1+json",
+		"size_bytes":     3000,
+		"pushed_at":      10000,
+		"last_pulled_at": nil,
+		"artifact_type":  "application/spdx+json",
+		"subject_digest": otherDigest.String(),
+		"annotations": assert.JSONObject{
+			"com.example.kind":                 "sbom",
+			"org.opencontainers.image.created": "2025-01-01T00:00:00Z",
+		},
+	}
+
+	// test filtering by each individual criterion
+	testCases := map[string][]assert.JSONObject{
+		"artifact_type=application/spdx%2Bjson":                            {sbomJSON},
+		"artifact_type=application/vnd.dev.sigstore.bundle.v0.3%2Bjson":    {signatureJSON},
+		"artifact_type=application/vnd.oci.image.config.v1%2Bjson":         {},
+		"subject=" + imageDigest.String():                                  {signatureJSON},
+		"subject=" + otherDigest.String():                                  {sbomJSON},
+		"annotation.com.example.kind=sbom":                                 {sbomJSON},
+		"annotation.com.example.kind=signature":                            {},
+		"annotation.org.opencontainers.image.created=2025-01-01T00:00:00Z": {sbomJSON, signatureJSON},
+		"annotation.com.example.kind=sbom&subject=" + imageDigest.String(): {},
+	}
+	for query, expected := range testCases {
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/manifests?" + query,
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.JSONObject{"manifests": expected},
+		}.Check(t, h)
+	}
+
+	// test pagination
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/manifests?annotation.org.opencontainers.image.created=2025-01-01T00:00:00Z&limit=1",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"manifests": []assert.JSONObject{sbomJSON}, "truncated": true},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/manifests?annotation.org.opencontainers.image.created=2025-01-01T00:00:00Z&limit=1&marker=bar@" + sbomDigest.String(),
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"manifests": []assert.JSONObject{signatureJSON}},
+	}.Check(t, h)
+
+	// test error cases
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/manifests?subject=foo",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("invalid value for \"subject\": invalid checksum digest format\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/manifests?annotation.foo=bar&annotation.foo=baz",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("multiple values given for \"annotation.foo\"\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/manifests",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant2"},
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.StringData("no permission for keppel_account:test1:view\n"),
+	}.Check(t, h)
+}
@@ -19,6 +19,7 @@
 package keppelv1
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -26,6 +27,8 @@ import (
 	"html"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/opencontainers/go-digest"
@@ -38,6 +41,7 @@ import (
 	"github.com/sapcc/keppel/internal/auth"
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/trivy"
 )
 
 // Manifest represents a manifest in the API.
@@ -54,6 +58,13 @@ type Manifest struct {
 	VulnerabilityScanErrorMessage string                     `json:"vulnerability_scan_error,omitempty"`
 	MinLayerCreatedAt             *int64                     `json:"min_layer_created_at"`
 	MaxLayerCreatedAt             *int64                     `json:"max_layer_created_at"`
+	QuarantineStatus              models.QuarantineStatus    `json:"quarantine_status,omitempty"`
+	SignatureStatus               models.SignatureStatus     `json:"signature_status,omitempty"`
+	ArtifactType                  string                     `json:"artifact_type,omitempty"`
+	SubjectDigest                 string                     `json:"subject_digest,omitempty"`
+	AnnotationsJSON               json.RawMessage            `json:"annotations,omitempty"`
+	MissingPlatforms              []string                   `json:"missing_platforms,omitempty"`
+	OrphanedAt                    *int64                     `json:"orphaned_at,omitempty"`
 }
 
 // Tag represents a tag in the API.
@@ -67,21 +78,30 @@ var manifestGetQuery = sqlext.SimplifyWhitespace(`
 	SELECT *
 	  FROM manifests
 	 WHERE repo_id = $1 AND $CONDITION
-	 ORDER BY digest ASC
+	   AND ($2 = '' OR EXISTS (
+	     SELECT 1 FROM tags t WHERE t.repo_id = manifests.repo_id AND t.digest = manifests.digest AND STARTS_WITH(t.name, $2)
+	   ))
+	 ORDER BY $ORDER
 	 LIMIT $LIMIT
 `)
 
+// Values for the `sort` query parameter of GET /keppel/v1/accounts/:account/repositories/:repo/_manifests,
+// other than the default sorting by digest. These sort by the respective
+// expression in descending order (i.e. most recent first), then by digest.
+var manifestSortExpressions = map[string]string{
+	"pushed_at":      "pushed_at",
+	"last_pulled_at": "COALESCE(last_pulled_at, TO_TIMESTAMP(0))",
+}
+
 var securityInfoGetQuery = sqlext.SimplifyWhitespace(`
 	SELECT * FROM trivy_security_info
-	WHERE repo_id = $1 AND $CONDITION
-	ORDER BY digest ASC
-	LIMIT $LIMIT
+	WHERE repo_id = $1 AND digest = ANY(string_to_array($2, ','))
 `)
 
 var tagGetQuery = sqlext.SimplifyWhitespace(`
 	SELECT *
 	  FROM tags
-	 WHERE repo_id = $1 AND digest >= $2 AND digest <= $3
+	 WHERE repo_id = $1 AND digest = ANY(string_to_array($2, ','))
 `)
 
 func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
@@ -99,11 +119,30 @@ func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	manifestQuery, vulnBindValues, manifestLimit, err := paginatedQuery{
-		SQL:         manifestGetQuery,
-		MarkerField: "digest",
-		Options:     r.URL.Query(),
-		BindValues:  []any{repo.ID},
+	query := manifestGetQuery
+	markerCondition := ""
+	switch sortKey := r.URL.Query().Get("sort"); sortKey {
+	case "", "digest":
+		query = strings.Replace(query, `$ORDER`, `digest ASC`, 1)
+	default:
+		expr, ok := manifestSortExpressions[sortKey]
+		if !ok {
+			http.Error(w, "invalid value for sort: "+strconv.Quote(sortKey), http.StatusBadRequest)
+			return
+		}
+		query = strings.Replace(query, `$ORDER`, expr+` DESC, digest ASC`, 1)
+		// the marker is still the digest of the last manifest on the previous
+		// page, so we need to look up its sort key to know where to continue
+		markerSortKey := fmt.Sprintf(`(SELECT %s FROM manifests WHERE repo_id = $1 AND digest = $MARKER)`, expr)
+		markerCondition = fmt.Sprintf(`(%[1]s < %[2]s OR (%[1]s = %[2]s AND digest > $MARKER))`, expr, markerSortKey)
+	}
+
+	manifestQuery, manifestBindValues, manifestLimit, err := paginatedQuery{
+		SQL:             query,
+		MarkerField:     "digest",
+		MarkerCondition: markerCondition,
+		Options:         r.URL.Query(),
+		BindValues:      []any{repo.ID, r.URL.Query().Get("tag_prefix")},
 	}.Prepare()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -111,24 +150,27 @@ func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var dbManifests []models.Manifest
-	_, err = a.db.Select(&dbManifests, manifestQuery, vulnBindValues...)
+	_, err = a.db.Select(&dbManifests, manifestQuery, manifestBindValues...)
 	if respondwith.ErrorText(w, err) {
 		return
 	}
 
-	securityInfoQuery, securityBindValues, _, err := paginatedQuery{
-		SQL:         securityInfoGetQuery,
-		MarkerField: "digest",
-		Options:     r.URL.Query(),
-		BindValues:  []any{repo.ID},
-	}.Prepare()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	var result struct {
+		Manifests   []*Manifest `json:"manifests"`
+		IsTruncated bool        `json:"truncated,omitempty"`
 	}
+	if uint64(len(dbManifests)) > manifestLimit {
+		dbManifests = dbManifests[:manifestLimit]
+		result.IsTruncated = true
+	}
+	digests := make([]string, len(dbManifests))
+	for idx, dbManifest := range dbManifests {
+		digests[idx] = dbManifest.Digest.String()
+	}
+	digestsStr := strings.Join(digests, ",")
 
 	var dbSecurityInfos []models.TrivySecurityInfo
-	_, err = a.db.Select(&dbSecurityInfos, securityInfoQuery, securityBindValues...)
+	_, err = a.db.Select(&dbSecurityInfos, securityInfoGetQuery, repo.ID, digestsStr)
 	if respondwith.ErrorText(w, err) {
 		return
 	}
@@ -138,16 +180,7 @@ func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 		securityInfos[securityInfo.Digest] = securityInfo
 	}
 
-	var result struct {
-		Manifests   []*Manifest `json:"manifests"`
-		IsTruncated bool        `json:"truncated,omitempty"`
-	}
 	for _, dbManifest := range dbManifests {
-		if uint64(len(result.Manifests)) >= manifestLimit {
-			result.IsTruncated = true
-			break
-		}
-
 		var (
 			securityInfo models.TrivySecurityInfo
 			ok           bool
@@ -169,19 +202,21 @@ func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 			VulnerabilityScanErrorMessage: securityInfo.Message,
 			MinLayerCreatedAt:             keppel.MaybeTimeToUnix(dbManifest.MinLayerCreatedAt),
 			MaxLayerCreatedAt:             keppel.MaybeTimeToUnix(dbManifest.MaxLayerCreatedAt),
+			QuarantineStatus:              dbManifest.QuarantineStatus,
+			SignatureStatus:               dbManifest.SignatureStatus,
+			ArtifactType:                  dbManifest.ArtifactType,
+			SubjectDigest:                 dbManifest.SubjectDigest,
+			AnnotationsJSON:               json.RawMessage(dbManifest.AnnotationsJSON),
+			MissingPlatforms:              dbManifest.SplitMissingPlatforms(),
+			OrphanedAt:                    keppel.MaybeTimeToUnix(dbManifest.OrphanedAt),
 		})
 	}
 
 	if len(result.Manifests) == 0 {
 		result.Manifests = []*Manifest{}
 	} else {
-		// since results were retrieved in sorted order, we know that for each
-		// manifest in the result set, its digest is >= the first digest and <= the
-		// last digest
-		firstDigest := result.Manifests[0].Digest
-		lastDigest := result.Manifests[len(result.Manifests)-1].Digest
 		var dbTags []models.Tag
-		_, err = a.db.Select(&dbTags, tagGetQuery, repo.ID, firstDigest, lastDigest)
+		_, err = a.db.Select(&dbTags, tagGetQuery, repo.ID, digestsStr)
 		if respondwith.ErrorText(w, err) {
 			return
 		}
@@ -203,7 +238,7 @@ func (a *API) handleGetManifests(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	respondwith.JSON(w, http.StatusOK, result)
+	respondWithConditionalJSON(w, r, result)
 }
 
 func (a *API) handleDeleteManifest(w http.ResponseWriter, r *http.Request) {
@@ -216,6 +251,10 @@ func (a *API) handleDeleteManifest(w http.ResponseWriter, r *http.Request) {
 	if account == nil {
 		return
 	}
+	if rerr := api.CheckReadOnlyMode(a.cfg, account.Reduced()); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
 	repo := a.findRepositoryFromRequest(w, r, account.Name)
 	if repo == nil {
 		return
@@ -226,10 +265,19 @@ func (a *API) handleDeleteManifest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.processor().DeleteManifest(r.Context(), account.Reduced(), *repo, parsedDigest, keppel.AuditContext{
+	actx := keppel.AuditContext{
 		UserIdentity: authz.UserIdentity,
 		Request:      r,
-	})
+	}
+	switch cascade := r.URL.Query().Get("cascade"); cascade {
+	case "":
+		err = a.processor().DeleteManifest(r.Context(), account.Reduced(), *repo, parsedDigest, actx)
+	case "referrers":
+		err = a.processor().DeleteManifestAndReferrers(r.Context(), account.Reduced(), *repo, parsedDigest, actx)
+	default:
+		http.Error(w, fmt.Sprintf(`unsupported value for "cascade": %q`, cascade), http.StatusBadRequest)
+		return
+	}
 	if errors.Is(err, sql.ErrNoRows) {
 		http.Error(w, "no such manifest", http.StatusNotFound)
 		return
@@ -251,6 +299,10 @@ func (a *API) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
 	if account == nil {
 		return
 	}
+	if rerr := api.CheckReadOnlyMode(a.cfg, account.Reduced()); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
 	repo := a.findRepositoryFromRequest(w, r, account.Name)
 	if repo == nil {
 		return
@@ -312,67 +364,204 @@ func (a *API) handleGetTrivyReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	securityInfo, err := keppel.GetSecurityInfo(a.db, repo.ID, parsedDigest)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	if format != "json" && format != "spdx-json" || (a.cfg.Scanner != nil && !a.cfg.Scanner.SupportsReportFormat(format)) {
+		http.Error(w, fmt.Sprintf("format %s not supported", html.EscapeString(format)), http.StatusBadRequest)
+		return
+	}
+
+	report, err := a.getTrivyReport(r.Context(), *account, *repo, *manifest, format)
 	if errors.Is(err, sql.ErrNoRows) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
+	if errors.Is(err, errNoTrivyReport) {
+		http.Error(w, "no vulnerability report found", http.StatusMethodNotAllowed)
+		return
+	}
 	if respondwith.ErrorText(w, err) {
 		return
 	}
 
-	// there is no vulnerability report if:
-	//- we don't have vulnerability scanning enabled at all
-	//- vulnerability scanning is not done yet
-	//- the image does not have any blobs that could be scanned for vulnerabilities
-	blobCount, err := a.db.SelectInt(
-		`SELECT COUNT(*) FROM manifest_blob_refs WHERE repo_id = $1 AND digest = $2`,
-		repo.ID, manifest.Digest,
-	)
-	if respondwith.ErrorText(w, err) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(report.Contents)
+}
+
+// Moves the next vulnerability check of a manifest (and its direct child
+// manifests, if any) to now, unless it is already due.
+var requestSecurityScanQuery = sqlext.SimplifyWhitespace(`
+	UPDATE trivy_security_info SET next_check_at = $3
+	 WHERE repo_id = $1 AND next_check_at > $3
+	   AND (digest = $2 OR digest IN (SELECT child_digest FROM manifest_manifest_refs WHERE repo_id = $1 AND parent_digest = $2))
+`)
+
+func (a *API) handlePostSecurityScan(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/security_scan")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPushToAccount))
+	if authz == nil {
 		return
 	}
-	if a.cfg.Trivy == nil || !securityInfo.VulnerabilityStatus.HasReport() || blobCount == 0 {
-		http.Error(w, "no vulnerability report found", http.StatusMethodNotAllowed)
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
 		return
 	}
-
-	imageRef := models.ImageReference{
-		Host:      a.cfg.APIPublicHostname,
-		RepoName:  fmt.Sprintf("%s/%s", account.Name, repo.Name),
-		Reference: models.ManifestReference{Digest: manifest.Digest},
+	if a.cfg.Scanner == nil {
+		http.Error(w, "vulnerability scanning is not enabled on this Keppel instance", http.StatusMethodNotAllowed)
+		return
 	}
 
-	format := r.URL.Query().Get("format")
-	if format == "" {
-		format = "json"
+	err := api.CheckRateLimit(r, a.rle, account.Reduced(), authz, keppel.SecurityScanRequestAction, 1)
+	if err != nil {
+		if rerr, ok := errext.As[*keppel.RegistryV2Error](err); ok && rerr != nil {
+			rerr.WriteAsRegistryV2ResponseTo(w, r)
+			return
+		} else if respondwith.ErrorText(w, err) {
+			return
+		}
 	}
 
-	if format != "json" && format != "spdx-json" {
-		http.Error(w, fmt.Sprintf("format %s not supported", html.EscapeString(format)), http.StatusBadRequest)
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+	parsedDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	manifest, err := keppel.FindManifest(a.db, *repo, parsedDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
 		return
 	}
 
-	tokenResp, err := auth.IssueTokenForTrivy(a.cfg, repo.FullName())
+	_, err = a.db.Exec(requestSecurityScanQuery, repo.ID, manifest.Digest, a.timeNow())
 	if respondwith.ErrorText(w, err) {
 		return
 	}
+	w.WriteHeader(http.StatusAccepted)
+}
 
-	report, err := a.cfg.Trivy.ScanManifest(r.Context(), tokenResp.Token, imageRef, format)
+// QuarantineReport represents the quarantine status of a manifest in the API.
+type QuarantineReport struct {
+	Status              models.QuarantineStatus    `json:"status"`
+	VulnerabilityStatus models.VulnerabilityStatus `json:"vulnerability_status"`
+	SeverityThreshold   models.VulnerabilityStatus `json:"severity_threshold,omitempty"`
+	PendingTags         []string                   `json:"pending_tags,omitempty"`
+}
+
+func (a *API) handleGetQuarantineStatus(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/quarantine")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+	parsedDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	manifest, err := keppel.FindManifest(a.db, *repo, parsedDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
 	if respondwith.ErrorText(w, err) {
 		return
 	}
+	if manifest.QuarantineStatus == models.NotQuarantined {
+		http.Error(w, "manifest was not held in quarantine", http.StatusNotFound)
+		return
+	}
 
-	relevantPolicies, err := keppel.GetSecurityScanPolicies(*account, *repo)
+	securityInfo, err := keppel.GetSecurityInfo(a.db, repo.ID, parsedDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
 	if respondwith.ErrorText(w, err) {
 		return
 	}
-	err = relevantPolicies.EnrichReport(&report)
+
+	var pendingTags []string
+	_, err = a.db.Select(&pendingTags,
+		`SELECT name FROM quarantined_tags WHERE repo_id = $1 AND digest = $2 ORDER BY name`,
+		repo.ID, manifest.Digest)
 	if respondwith.ErrorText(w, err) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(report.Contents)
+	respondwith.JSON(w, http.StatusOK, map[string]any{"quarantine": QuarantineReport{
+		Status:              manifest.QuarantineStatus,
+		VulnerabilityStatus: securityInfo.VulnerabilityStatus,
+		SeverityThreshold:   account.QuarantineSeverityThreshold,
+		PendingTags:         pendingTags,
+	}})
+}
+
+var errNoTrivyReport = errors.New("no vulnerability report found")
+
+// Retrieves the vulnerability report for the given manifest from Trivy.
+// Returns errNoTrivyReport if no report is available, and sql.ErrNoRows if
+// the manifest does not have a security info record.
+func (a *API) getTrivyReport(ctx context.Context, account models.Account, repo models.Repository, manifest models.Manifest, format string) (trivy.ReportPayload, error) {
+	securityInfo, err := keppel.GetSecurityInfo(a.db, repo.ID, manifest.Digest)
+	if err != nil {
+		return trivy.ReportPayload{}, err
+	}
+
+	// there is no vulnerability report if:
+	//- we don't have vulnerability scanning enabled at all
+	//- vulnerability scanning is not done yet
+	//- the image does not have any blobs that could be scanned for vulnerabilities
+	blobCount, err := a.db.SelectInt(
+		`SELECT COUNT(*) FROM manifest_blob_refs WHERE repo_id = $1 AND digest = $2`,
+		repo.ID, manifest.Digest,
+	)
+	if err != nil {
+		return trivy.ReportPayload{}, err
+	}
+	if a.cfg.Scanner == nil || !securityInfo.VulnerabilityStatus.HasReport() || blobCount == 0 {
+		return trivy.ReportPayload{}, errNoTrivyReport
+	}
+
+	imageRef := models.ImageReference{
+		Host:      a.cfg.APIPublicHostname,
+		RepoName:  fmt.Sprintf("%s/%s", account.Name, repo.Name),
+		Reference: models.ManifestReference{Digest: manifest.Digest},
+	}
+
+	tokenResp, err := auth.IssueTokenForTrivy(a.cfg, repo.FullName())
+	if err != nil {
+		return trivy.ReportPayload{}, err
+	}
+
+	report, err := a.cfg.Scanner.ScanManifest(ctx, tokenResp.Token, imageRef, format)
+	if err != nil {
+		return trivy.ReportPayload{}, err
+	}
+
+	relevantPolicies, err := keppel.GetSecurityScanPolicies(account, repo)
+	if err != nil {
+		return trivy.ReportPayload{}, err
+	}
+	err = relevantPolicies.EnrichReport(&report)
+	return report, err
 }
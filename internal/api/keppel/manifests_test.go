@@ -194,6 +194,9 @@ func TestManifestsAPI(t *testing.T) {
 		renderedManifests[1]["tags"] = []assert.JSONObject{
 			{"name": "second", "pushed_at": 20003, "last_pulled_at": nil},
 		}
+		// (keep a copy in push order for the tests with sorting below)
+		renderedManifestsInPushOrder := make([]assert.JSONObject, len(renderedManifests))
+		copy(renderedManifestsInPushOrder, renderedManifests)
 		sort.Slice(renderedManifests, func(i, j int) bool {
 			return renderedManifests[i]["digest"].(digest.Digest) < renderedManifests[j]["digest"].(digest.Digest)
 		})
@@ -248,7 +251,86 @@ func TestManifestsAPI(t *testing.T) {
 			}.Check(t, h)
 		}
 
+		// test GET with tag name prefix filter
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_manifests?tag_prefix=fi",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.JSONObject{"manifests": renderedManifestsInPushOrder[0:1]},
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_manifests?tag_prefix=s&sort=pushed_at",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody: assert.JSONObject{
+				"manifests": []assert.JSONObject{renderedManifestsInPushOrder[1], renderedManifestsInPushOrder[0]},
+			},
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_manifests?tag_prefix=third",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.JSONObject{"manifests": []assert.JSONObject{}},
+		}.Check(t, h)
+
+		// test GET with sorting by pushed_at (most recent first)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_manifests?sort=pushed_at&limit=2",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody: assert.JSONObject{
+				"manifests": []assert.JSONObject{renderedManifestsInPushOrder[9], renderedManifestsInPushOrder[8]},
+				"truncated": true,
+			},
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_manifests?sort=pushed_at&limit=2&marker=" + renderedManifestsInPushOrder[2]["digest"].(digest.Digest).String(),
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody: assert.JSONObject{
+				"manifests": []assert.JSONObject{renderedManifestsInPushOrder[1], renderedManifestsInPushOrder[0]},
+			},
+		}.Check(t, h)
+
+		// test GET with sorting by last_pulled_at (manifests that were never
+		// pulled come last, ties are broken by digest)
+		var neverPulledManifests []assert.JSONObject
+		for _, m := range renderedManifests {
+			if m["last_pulled_at"] == nil {
+				neverPulledManifests = append(neverPulledManifests, m)
+			}
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_manifests?sort=last_pulled_at&limit=1",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody: assert.JSONObject{
+				"manifests": renderedManifestsInPushOrder[0:1],
+				"truncated": true,
+			},
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_manifests?sort=last_pulled_at&marker=" + renderedManifestsInPushOrder[0]["digest"].(digest.Digest).String(),
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.JSONObject{"manifests": neverPulledManifests},
+		}.Check(t, h)
+
 		// test GET failure cases
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/keppel/v1/accounts/test1/repositories/repo1-1/_manifests?sort=size_bytes",
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   assert.StringData("invalid value for sort: \"size_bytes\"\n"),
+		}.Check(t, h)
 		assert.HTTPRequest{
 			Method:       "GET",
 			Path:         "/keppel/v1/accounts/doesnotexist/repositories/repo1-1/_manifests",
@@ -542,3 +624,102 @@ func TestRateLimitsTrivyReport(t *testing.T) {
 		failingReq.Check(t, h)
 	})
 }
+
+func TestPostSecurityScan(t *testing.T) {
+	limit := redis_rate.Limit{Rate: 1, Period: time.Minute, Burst: 1}
+	rld := basic.RateLimitDriver{
+		Limits: map[keppel.RateLimitedAction]redis_rate.Limit{
+			keppel.SecurityScanRequestAction: limit,
+		},
+	}
+	rle := &keppel.RateLimitEngine{Driver: rld}
+
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		s := test.NewSetup(t,
+			test.WithKeppelAPI,
+			test.WithTrivyDouble,
+			test.WithQuotas,
+			test.WithRateLimitEngine(rle),
+			test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+			test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+		)
+		h := s.Handler
+		repo := s.Repos[0]
+
+		image1 := test.GenerateImage(test.GenerateExampleLayer(1))
+		image1.MustUpload(t, s, *repo, "")
+		image2 := test.GenerateImage(test.GenerateExampleLayer(2))
+		image2.MustUpload(t, s, *repo, "")
+		imageList := test.GenerateImageList(image1, image2)
+		imageList.MustUpload(t, s, *repo, "")
+
+		// all manifests were scanned recently
+		s.Clock.StepBy(time.Hour)
+		nextCheckAt := s.Clock.Now().Add(time.Hour)
+		mustExec(t, s.DB, `UPDATE trivy_security_info SET next_check_at = $1`, nextCheckAt)
+		expectNextCheckAt := func(d digest.Digest, expected time.Time) {
+			t.Helper()
+			securityInfo, err := keppel.GetSecurityInfo(s.DB, repo.ID, d)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if !securityInfo.NextCheckAt.Equal(expected) {
+				t.Errorf("expected next_check_at of %s to be %s, but got %s", d, expected, securityInfo.NextCheckAt)
+			}
+		}
+
+		path := func(d digest.Digest) string {
+			return fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_manifests/%s/security_scan", d)
+		}
+		header := map[string]string{"X-Test-Perms": "view:tenant1,push:tenant1"}
+
+		// check permission
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         path(image1.Manifest.Digest),
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+			ExpectStatus: http.StatusForbidden,
+		}.Check(t, h)
+
+		// check error cases
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         path(test.DeterministicDummyDigest(1)),
+			Header:       header,
+			ExpectStatus: http.StatusNotFound,
+			ExpectBody:   assert.StringData("not found\n"),
+		}.Check(t, h)
+
+		// requesting a scan of the image list also schedules its constituent images
+		s.Clock.StepBy(time.Minute)
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         path(imageList.Manifest.Digest),
+			Header:       header,
+			ExpectStatus: http.StatusAccepted,
+		}.Check(t, h)
+		expectNextCheckAt(imageList.Manifest.Digest, s.Clock.Now())
+		expectNextCheckAt(image1.Manifest.Digest, s.Clock.Now())
+		expectNextCheckAt(image2.Manifest.Digest, s.Clock.Now())
+
+		// requests are rate-limited
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         path(image1.Manifest.Digest),
+			Header:       header,
+			ExpectStatus: http.StatusTooManyRequests,
+			ExpectBody:   test.ErrorCode(keppel.ErrTooManyRequests),
+		}.Check(t, h)
+
+		// once the rate limit has recovered, scans that are already due are not moved
+		scheduledAt := s.Clock.Now()
+		s.Clock.StepBy(time.Minute)
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         path(image1.Manifest.Digest),
+			Header:       header,
+			ExpectStatus: http.StatusAccepted,
+		}.Check(t, h)
+		expectNextCheckAt(image1.Manifest.Digest, scheduledAt)
+	})
+}
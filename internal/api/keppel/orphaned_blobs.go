@@ -0,0 +1,98 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// OrphanedBlob represents a blob that is not referenced by any manifest in the API.
+type OrphanedBlob struct {
+	Digest         digest.Digest `json:"digest"`
+	SizeBytes      uint64        `json:"size_bytes"`
+	MediaType      string        `json:"media_type,omitempty"`
+	PushedAt       int64         `json:"pushed_at"`
+	AgeSeconds     int64         `json:"age_seconds"`
+	MountCount     uint64        `json:"mount_count"`
+	CanBeDeletedAt *int64        `json:"can_be_deleted_at,omitempty"`
+}
+
+// Blobs that are pushed by clients are usually referenced by a manifest pushed
+// shortly afterwards. Fresh blobs are therefore not reported, since they are
+// most likely just waiting for their manifest.
+const orphanedBlobGracePeriod = 6 * time.Hour
+
+var orphanedBlobsQuery = sqlext.SimplifyWhitespace(`
+	SELECT b.digest, b.size_bytes, b.media_type, b.pushed_at, b.can_be_deleted_at,
+	       (SELECT COUNT(*) FROM blob_mounts bm WHERE bm.blob_id = b.id) AS mount_count
+	  FROM blobs b
+	 WHERE b.account_name = $1 AND b.pushed_at < $2
+	   AND b.id NOT IN (SELECT mbr.blob_id FROM manifest_blob_refs mbr JOIN repos r ON mbr.repo_id = r.id WHERE r.account_name = $1)
+	 ORDER BY b.pushed_at, b.digest
+`)
+
+func (a *API) handleGetOrphanedBlobs(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/orphaned_blobs")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	now := a.timeNow()
+	result := struct {
+		OrphanedBlobs  []OrphanedBlob `json:"orphaned_blobs"`
+		TotalSizeBytes uint64         `json:"total_size_bytes"`
+	}{
+		OrphanedBlobs: []OrphanedBlob{},
+	}
+	err := sqlext.ForeachRow(a.db, orphanedBlobsQuery, []any{account.Name, now.Add(-orphanedBlobGracePeriod)}, func(rows *sql.Rows) error {
+		var (
+			blob           OrphanedBlob
+			pushedAt       time.Time
+			canBeDeletedAt *time.Time
+		)
+		err := rows.Scan(&blob.Digest, &blob.SizeBytes, &blob.MediaType, &pushedAt, &canBeDeletedAt, &blob.MountCount)
+		if err != nil {
+			return err
+		}
+		blob.PushedAt = pushedAt.Unix()
+		blob.AgeSeconds = int64(now.Sub(pushedAt) / time.Second)
+		blob.CanBeDeletedAt = keppel.MaybeTimeToUnix(canBeDeletedAt)
+		result.OrphanedBlobs = append(result.OrphanedBlobs, blob)
+		result.TotalSizeBytes += blob.SizeBytes
+		return nil
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, result)
+}
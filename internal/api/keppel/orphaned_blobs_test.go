@@ -0,0 +1,113 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGetOrphanedBlobs(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+	repo := *s.Repos[0]
+
+	// push an image (whose blobs are referenced by its manifest) and some loose
+	// blobs (that are not referenced by any manifest)
+	s.Clock.StepBy(time.Hour)
+	pushedAt := s.Clock.Now()
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, repo, "latest")
+	looseBlob1 := test.GenerateExampleLayer(2).MustUpload(t, s, repo)
+	s.Clock.StepBy(time.Minute)
+	looseBlob2 := test.GenerateExampleLayer(3).MustUpload(t, s, repo)
+
+	// the report is only visible to account admins
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/orphaned_blobs",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1,delete:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// fresh blobs are not reported since their manifest might just not have been pushed yet
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/orphaned_blobs",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"orphaned_blobs":   []assert.JSONObject{},
+			"total_size_bytes": 0,
+		},
+	}.Check(t, h)
+
+	// simulate the janitor having unmounted and marked one of the loose blobs
+	s.Clock.StepBy(7 * time.Hour)
+	canBeDeletedAt := s.Clock.Now().Add(30 * time.Minute)
+	_, err := s.DB.Exec(`DELETE FROM blob_mounts WHERE blob_id = $1`, looseBlob2.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = s.DB.Exec(`UPDATE blobs SET can_be_deleted_at = $1 WHERE id = $2`, canBeDeletedAt, looseBlob2.ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// now both loose blobs are reported, but not the blobs of the image
+	ageSeconds := int64((7*time.Hour + time.Minute) / time.Second)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/orphaned_blobs",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,change:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"orphaned_blobs": []assert.JSONObject{
+				{
+					"digest":      looseBlob1.Digest,
+					"size_bytes":  looseBlob1.SizeBytes,
+					"media_type":  looseBlob1.MediaType,
+					"pushed_at":   pushedAt.Unix(),
+					"age_seconds": ageSeconds,
+					"mount_count": 1,
+				},
+				{
+					"digest":            looseBlob2.Digest,
+					"size_bytes":        looseBlob2.SizeBytes,
+					"media_type":        looseBlob2.MediaType,
+					"pushed_at":         pushedAt.Add(time.Minute).Unix(),
+					"age_seconds":       ageSeconds - 60,
+					"mount_count":       0,
+					"can_be_deleted_at": canBeDeletedAt.Unix(),
+				},
+			},
+			"total_size_bytes": looseBlob1.SizeBytes + looseBlob2.SizeBytes,
+		},
+	}.Check(t, h)
+}
@@ -0,0 +1,170 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/errext"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/api"
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func (a *API) handlePostPromoteManifest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_promote")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	if authz == nil {
+		return
+	}
+	sourceAccount := a.findAccountFromRequest(w, r, authz)
+	if sourceAccount == nil {
+		return
+	}
+	sourceRepo := a.findRepositoryFromRequest(w, r, sourceAccount.Name)
+	if sourceRepo == nil {
+		return
+	}
+
+	var req struct {
+		Reference string `json:"reference"`
+		Target    struct {
+			AccountName    models.AccountName `json:"account"`
+			RepositoryName string             `json:"repository"`
+			Tag            string             `json:"tag"`
+		} `json:"target"`
+	}
+	if !decodeJSONRequestBody(w, r.Body, &req) {
+		return
+	}
+	if req.Target.RepositoryName == "" {
+		req.Target.RepositoryName = sourceRepo.Name
+	}
+	switch {
+	case req.Reference == "":
+		http.Error(w, `missing value for "reference"`, http.StatusUnprocessableEntity)
+		return
+	case !models.IsAccountName(string(req.Target.AccountName)):
+		http.Error(w, fmt.Sprintf("invalid target account name: %q", req.Target.AccountName), http.StatusUnprocessableEntity)
+		return
+	case !isValidRepoName(req.Target.RepositoryName):
+		http.Error(w, fmt.Sprintf("invalid target repository name: %q", req.Target.RepositoryName), http.StatusUnprocessableEntity)
+		return
+	case req.Target.Tag != "" && !models.TagNameRx.MatchString(req.Target.Tag):
+		http.Error(w, fmt.Sprintf("invalid target tag name: %q", req.Target.Tag), http.StatusUnprocessableEntity)
+		return
+	case req.Target.AccountName == sourceAccount.Name && req.Target.RepositoryName == sourceRepo.Name:
+		http.Error(w, "source and target repository must be different (use the Registry API to add tags within a repository)", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// find source manifest
+	ref := models.ParseManifestReference(req.Reference)
+	manifestDigest := ref.Digest
+	if ref.IsTag() {
+		digestStr, err := a.db.SelectStr(`SELECT digest FROM tags WHERE repo_id = $1 AND name = $2`, sourceRepo.ID, ref.Tag)
+		if respondwith.ErrorText(w, err) {
+			return
+		}
+		manifestDigest = digest.Digest(digestStr)
+	}
+	// (if the tag does not exist, SelectStr yields an empty digest)
+	sourceManifest, err := keppel.FindManifest(a.db, *sourceRepo, manifestDigest)
+	if errors.Is(err, sql.ErrNoRows) || manifestDigest == "" {
+		http.Error(w, "manifest not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	// check access to target
+	targetScope := auth.NewScopeSet(auth.Scope{
+		ResourceType: "repository",
+		ResourceName: fmt.Sprintf("%s/%s", req.Target.AccountName, req.Target.RepositoryName),
+		Actions:      []string{string(keppel.CanPushToAccount)},
+	})
+	targetAuthz := a.authenticateRequest(w, r, targetScope)
+	if targetAuthz == nil {
+		return
+	}
+	targetAccount, err := keppel.FindAccount(a.db, req.Target.AccountName)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if targetAccount == nil {
+		http.Error(w, "target account not found", http.StatusNotFound)
+		return
+	}
+	if rerr := api.CheckReadOnlyMode(a.cfg, targetAccount.Reduced()); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
+	if targetAccount.UpstreamPeerHostName != "" || targetAccount.ExternalPeerURL != "" {
+		http.Error(w, "cannot promote into replica account", http.StatusMethodNotAllowed)
+		return
+	}
+	if targetAccount.IsDeleting {
+		http.Error(w, "target account is being deleted", http.StatusConflict)
+		return
+	}
+	err = api.CheckRateLimit(r, a.rle, targetAccount.Reduced(), targetAuthz, keppel.ManifestPushAction, 1)
+	if err != nil {
+		if rerr, ok := errext.As[*keppel.RegistryV2Error](err); ok && rerr != nil {
+			rerr.WriteAsRegistryV2ResponseTo(w, r)
+			return
+		} else if respondwith.ErrorText(w, err) {
+			return
+		}
+	}
+	targetRepo, err := keppel.FindOrCreateRepository(a.db, req.Target.RepositoryName, targetAccount.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	// promote
+	manifest, err := a.processor().PromoteManifest(r.Context(),
+		sourceAccount.Reduced(), *sourceRepo, *sourceManifest,
+		targetAccount.Reduced(), *targetRepo, req.Target.Tag,
+		keppel.AuditContext{
+			UserIdentity: targetAuthz.UserIdentity,
+			Request:      r,
+		})
+	if err != nil {
+		keppel.AsRegistryV2Error(err).WriteAsTextTo(w)
+		return
+	}
+
+	result := struct {
+		AccountName    models.AccountName `json:"account"`
+		RepositoryName string             `json:"repository"`
+		Digest         digest.Digest      `json:"digest"`
+		Tag            string             `json:"tag,omitempty"`
+	}{targetAccount.Name, targetRepo.Name, manifest.Digest, req.Target.Tag}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"promoted": result})
+}
@@ -0,0 +1,125 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestPromoteManifest(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithQuotas,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+	repo := s.Repos[0]
+
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, *repo, "latest")
+	signature := test.GenerateImage(test.GenerateExampleLayer(2))
+	signatureTagName := fmt.Sprintf("%s-%s.sig", image.Manifest.Digest.Algorithm(), image.Manifest.Digest.Encoded())
+	signature.MustUpload(t, s, *repo, signatureTagName)
+
+	path := "/keppel/v1/accounts/test1/repositories/foo/_promote"
+	header := map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1,push:tenant1"}
+	body := assert.JSONObject{
+		"reference": "latest",
+		"target":    assert.JSONObject{"account": "test2", "tag": "prod"},
+	}
+
+	// check permission: push access on the target is required
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		Body:         body,
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// check error cases
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"target": assert.JSONObject{"account": "test2"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("missing value for \"reference\"\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"reference": "latest", "target": assert.JSONObject{"account": "test1"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("source and target repository must be different (use the Registry API to add tags within a repository)\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"reference": "latest", "target": assert.JSONObject{"account": "test2", "tag": "-invalid"}},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("invalid target tag name: \"-invalid\"\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"reference": "unknown", "target": assert.JSONObject{"account": "test2"}},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("manifest not found\n"),
+	}.Check(t, h)
+
+	// happy path
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       header,
+		Body:         body,
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{"promoted": assert.JSONObject{
+			"account":    "test2",
+			"repository": "foo",
+			"digest":     image.Manifest.Digest.String(),
+			"tag":        "prod",
+		}},
+	}.Check(t, h)
+
+	// the manifest, its tag, its signature and its blobs are now present in the target repo
+	targetRepoID := mustSelectInt(t, s, `SELECT id FROM repos WHERE account_name = $1 AND name = $2`, "test2", "foo")
+	assert.DeepEqual(t, "manifest count in target repo",
+		mustSelectInt(t, s, `SELECT COUNT(*) FROM manifests WHERE repo_id = $1`, targetRepoID), int64(2))
+	assert.DeepEqual(t, "promoted tag",
+		mustSelectInt(t, s, `SELECT COUNT(*) FROM tags WHERE repo_id = $1 AND name = $2 AND digest = $3`, targetRepoID, "prod", image.Manifest.Digest.String()), int64(1))
+	assert.DeepEqual(t, "promoted signature tag",
+		mustSelectInt(t, s, `SELECT COUNT(*) FROM tags WHERE repo_id = $1 AND name = $2 AND digest = $3`, targetRepoID, signatureTagName, signature.Manifest.Digest.String()), int64(1))
+	assert.DeepEqual(t, "blob mount count in target repo",
+		mustSelectInt(t, s, `SELECT COUNT(*) FROM blob_mounts WHERE repo_id = $1`, targetRepoID), int64(4))
+}
@@ -0,0 +1,297 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1
+
+import (
+	"archive/tar"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ProvenanceStatement is the payload of the signed statement that is included
+// in provenance bundles as "statement.jwt".
+type ProvenanceStatement struct {
+	jwt.RegisteredClaims
+	// Manifest is the digest of the manifest that this bundle is about.
+	Manifest digest.Digest `json:"manifest"`
+	// Referrers maps the names of tags that refer to the manifest (e.g.
+	// signatures or SBOMs) to the digest of the manifest they point to.
+	Referrers map[string]digest.Digest `json:"referrers,omitempty"`
+	// Files maps the path of each other file in the bundle to its digest.
+	Files map[string]digest.Digest `json:"files"`
+}
+
+// A file in a provenance bundle. The contents are either given directly or
+// read from a blob in the storage.
+type provenanceBundleFile struct {
+	Path      string
+	Digest    digest.Digest
+	SizeBytes uint64
+	Contents  []byte
+	Blob      *models.Blob
+}
+
+func (a *API) handleGetProvenanceBundle(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/provenance_bundle")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+	parsedDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	manifest, err := keppel.FindManifest(a.db, *repo, parsedDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if !manifest.QuarantineStatus.IsPullable() {
+		http.Error(w, "manifest is held in quarantine", http.StatusForbidden)
+		return
+	}
+
+	// collect the manifest itself
+	var files []provenanceBundleFile
+	manifestBytes, err := a.readManifestContents(r.Context(), account.Reduced(), *repo, manifest.Digest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	files = append(files, provenanceBundleFile{
+		Path:      "manifests/" + manifest.Digest.Algorithm().String() + "/" + manifest.Digest.Encoded(),
+		Digest:    manifest.Digest,
+		SizeBytes: uint64(len(manifestBytes)),
+		Contents:  manifestBytes,
+	})
+
+	// collect referrers and their blobs
+	referrers, referrerFiles, err := a.collectProvenanceReferrers(r.Context(), account.Reduced(), *repo, *manifest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	files = append(files, referrerFiles...)
+
+	// collect the vulnerability report, if any
+	report, err := a.getTrivyReport(r.Context(), *account, *repo, *manifest, "json")
+	switch {
+	case err == nil:
+		files = append(files, provenanceBundleFile{
+			Path:      "trivy-report.json",
+			Digest:    digest.Canonical.FromBytes(report.Contents),
+			SizeBytes: uint64(len(report.Contents)),
+			Contents:  report.Contents,
+		})
+	case errors.Is(err, errNoTrivyReport), errors.Is(err, sql.ErrNoRows):
+		// the bundle just does not contain a report
+	default:
+		respondwith.ErrorText(w, err)
+		return
+	}
+
+	// sign a statement covering all files in the bundle
+	now := a.timeNow()
+	statement := ProvenanceStatement{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   "keppel-api@" + a.cfg.APIPublicHostname,
+			Subject:  fmt.Sprintf("%s/%s@%s", a.cfg.APIPublicHostname, repo.FullName(), manifest.Digest),
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+		Manifest:  manifest.Digest,
+		Referrers: referrers,
+		Files:     make(map[string]digest.Digest, len(files)),
+	}
+	for _, file := range files {
+		statement.Files[file.Path] = file.Digest
+	}
+	statementJWT, err := auth.SignStatement(a.cfg, statement)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	// write bundle (from this point on, errors can only be logged since the
+	// response status has already been sent)
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar"`, manifest.Digest.Encoded()))
+	w.WriteHeader(http.StatusOK)
+	err = a.writeProvenanceBundle(r.Context(), w, account.Reduced(), now, []byte(statementJWT), files)
+	if err != nil {
+		logg.Error("while writing provenance bundle for %s@%s: %s", repo.FullName(), manifest.Digest, err.Error())
+	}
+}
+
+// Finds manifests that refer to the given manifest through the tag naming
+// scheme used by cosign (e.g. "sha256-1234abcd.sig" for a signature of the
+// manifest "sha256:1234abcd"), and collects their contents and blobs.
+func (a *API) collectProvenanceReferrers(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifest models.Manifest) (map[string]digest.Digest, []provenanceBundleFile, error) {
+	tagPrefix := fmt.Sprintf("%s-%s.", manifest.Digest.Algorithm(), manifest.Digest.Encoded())
+	var tags []models.Tag
+	_, err := a.db.Select(&tags,
+		`SELECT * FROM tags WHERE repo_id = $1 AND name LIKE $2 || '%' ORDER BY name`,
+		repo.ID, tagPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tags) == 0 {
+		return nil, nil, nil
+	}
+
+	referrers := make(map[string]digest.Digest, len(tags))
+	var files []provenanceBundleFile
+	isCollected := make(map[digest.Digest]bool)
+	for _, tag := range tags {
+		referrers[tag.Name] = tag.Digest
+		if isCollected[tag.Digest] {
+			continue
+		}
+		isCollected[tag.Digest] = true
+
+		referrer, err := keppel.FindManifest(a.db, repo, tag.Digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		referrerBytes, err := a.readManifestContents(ctx, account, repo, referrer.Digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, provenanceBundleFile{
+			Path:      "manifests/" + referrer.Digest.Algorithm().String() + "/" + referrer.Digest.Encoded(),
+			Digest:    referrer.Digest,
+			SizeBytes: uint64(len(referrerBytes)),
+			Contents:  referrerBytes,
+		})
+
+		parsed, _, err := keppel.ParseManifest(referrer.MediaType, referrerBytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot parse manifest %s: %w", referrer.Digest, err)
+		}
+		for _, desc := range parsed.BlobReferences() {
+			if isCollected[desc.Digest] {
+				continue
+			}
+			isCollected[desc.Digest] = true
+
+			blob, err := keppel.FindBlobByRepository(a.db, desc.Digest, repo)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot find blob %s: %w", desc.Digest, err)
+			}
+			if blob.StorageID == "" {
+				// blob has not been replicated yet
+				return nil, nil, fmt.Errorf("blob %s is not available yet, please retry later", desc.Digest)
+			}
+			files = append(files, provenanceBundleFile{
+				Path:      "blobs/" + blob.Digest.Algorithm().String() + "/" + blob.Digest.Encoded(),
+				Digest:    blob.Digest,
+				SizeBytes: blob.SizeBytes,
+				Blob:      blob,
+			})
+		}
+	}
+
+	return referrers, files, nil
+}
+
+func (a *API) readManifestContents(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest) ([]byte, error) {
+	var result []byte
+	err := a.db.SelectOne(&result,
+		`SELECT content FROM manifest_contents WHERE repo_id = $1 AND digest = $2`,
+		repo.ID, manifestDigest,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a.sd.ReadManifest(ctx, account, repo.Name, manifestDigest)
+	}
+	return result, err
+}
+
+func (a *API) writeProvenanceBundle(ctx context.Context, w io.Writer, account models.ReducedAccount, modTime time.Time, statementJWT []byte, files []provenanceBundleFile) error {
+	tw := tar.NewWriter(w)
+	writeHeader := func(path string, size uint64) error {
+		return tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path,
+			Size:     int64(size), //nolint:gosec // sizes are far below MaxInt64
+			Mode:     0o644,
+			ModTime:  modTime,
+		})
+	}
+
+	err := writeHeader("statement.jwt", uint64(len(statementJWT)))
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(statementJWT)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err := writeHeader(file.Path, file.SizeBytes)
+		if err != nil {
+			return err
+		}
+		if file.Blob == nil {
+			_, err = tw.Write(file.Contents)
+		} else {
+			err = copyBlobContents(ctx, tw, a.sd, a.cfg.BlobStorageLocation(account, *file.Blob), *file.Blob)
+		}
+		if err != nil {
+			return fmt.Errorf("while writing %s: %w", file.Path, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+func copyBlobContents(ctx context.Context, w io.Writer, sd keppel.StorageDriver, location models.ReducedAccount, blob models.Blob) error {
+	reader, _, err := sd.ReadBlob(ctx, location, blob.StorageID)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(w, reader)
+	return err
+}
@@ -0,0 +1,137 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	keppelv1 "github.com/sapcc/keppel/internal/api/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGetProvenanceBundle(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+	repo := s.Repos[0]
+
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, *repo, "latest")
+	pathForImage := fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_manifests/%s/provenance_bundle", image.Manifest.Digest)
+
+	// check error cases
+	token := s.GetToken(t, "repository:test1/foo:pull")
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_manifests/%s/provenance_bundle", test.DeterministicDummyDigest(1)),
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("not found\n"),
+	}.Check(t, h)
+
+	// upload a signature for the image using the cosign tag naming scheme
+	signature := test.GenerateImage(test.GenerateExampleLayer(2))
+	signatureTag := fmt.Sprintf("%s-%s.sig", image.Manifest.Digest.Algorithm(), image.Manifest.Digest.Encoded())
+	signature.MustUpload(t, s, *repo, signatureTag)
+
+	// retrieve bundle
+	_, bodyBytes := assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage,
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+		ExpectHeader: map[string]string{
+			"Content-Type":        "application/x-tar",
+			"Content-Disposition": fmt.Sprintf(`attachment; filename="%s.tar"`, image.Manifest.Digest.Encoded()),
+		},
+	}.Check(t, h)
+
+	// unpack bundle
+	var (
+		fileNames    []string
+		fileContents = make(map[string][]byte)
+	)
+	tr := tar.NewReader(bytes.NewReader(bodyBytes))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		fileNames = append(fileNames, hdr.Name)
+		fileContents[hdr.Name] = contents
+	}
+
+	// check that the bundle contains exactly what we expect
+	pathFor := func(prefix string, d digest.Digest) string {
+		return fmt.Sprintf("%s/%s/%s", prefix, d.Algorithm(), d.Encoded())
+	}
+	expectedFiles := map[string][]byte{
+		pathFor("manifests", image.Manifest.Digest):     image.Manifest.Contents,
+		pathFor("manifests", signature.Manifest.Digest): signature.Manifest.Contents,
+		pathFor("blobs", signature.Config.Digest):       signature.Config.Contents,
+		pathFor("blobs", signature.Layers[0].Digest):    signature.Layers[0].Contents,
+	}
+	if len(fileNames) == 0 || fileNames[0] != "statement.jwt" {
+		t.Fatalf("expected statement.jwt to be the first file in the bundle, but got %v", fileNames)
+	}
+	assert.DeepEqual(t, "number of files in bundle", len(fileNames), len(expectedFiles)+1)
+	for path, contents := range expectedFiles {
+		assert.DeepEqual(t, "contents of "+path, string(fileContents[path]), string(contents))
+	}
+
+	// check statement
+	var statement keppelv1.ProvenanceStatement
+	_, err := jwt.ParseWithClaims(string(fileContents["statement.jwt"]), &statement, func(*jwt.Token) (any, error) {
+		return s.Config.JWTIssuerKeys[0].(crypto.Signer).Public(), nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "statement.Issuer", statement.Issuer, "keppel-api@registry.example.org")
+	assert.DeepEqual(t, "statement.Subject", statement.Subject, "registry.example.org/test1/foo@"+image.Manifest.Digest.String())
+	assert.DeepEqual(t, "statement.Manifest", statement.Manifest, image.Manifest.Digest)
+	assert.DeepEqual(t, "statement.Referrers", statement.Referrers, map[string]digest.Digest{signatureTag: signature.Manifest.Digest})
+	expectedFileDigests := make(map[string]digest.Digest, len(expectedFiles))
+	for path, contents := range expectedFiles {
+		expectedFileDigests[path] = digest.Canonical.FromBytes(contents)
+	}
+	assert.DeepEqual(t, "statement.Files", statement.Files, expectedFileDigests)
+}
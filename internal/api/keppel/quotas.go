@@ -20,6 +20,7 @@ package keppelv1
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/sapcc/go-bits/errext"
@@ -30,6 +31,52 @@ import (
 	"github.com/sapcc/keppel/internal/processor"
 )
 
+func (a *API) handleListQuotas(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/quotas")
+	query := r.URL.Query()
+	onlyOverQuota := false
+	if value := query.Get("over_quota"); value != "" {
+		var err error
+		onlyOverQuota, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, `invalid value for "over_quota": `+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tenants, err := a.processor().ListQuotas()
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	authTenantIDs := make([]string, len(tenants))
+	for idx, tenant := range tenants {
+		authTenantIDs[idx] = tenant.AuthTenantID
+	}
+	scopes := authTenantScope(keppel.CanViewQuotas, authTenantIDs...)
+
+	authz := a.authenticateRequest(w, r, scopes)
+	if authz == nil {
+		return
+	}
+	if authz.UserIdentity.UserType() == keppel.AnonymousUser {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// restrict tenants to those visible in the current scope
+	tenantsFiltered := []processor.TenantQuotaResponse{}
+	for idx, tenant := range tenants {
+		if !authz.ScopeSet.Contains(*scopes[idx]) {
+			continue
+		}
+		if onlyOverQuota && !tenant.IsOverQuota() {
+			continue
+		}
+		tenantsFiltered = append(tenantsFiltered, tenant)
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"quotas": tenantsFiltered})
+}
+
 func (a *API) handleGetQuotas(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/quotas/:auth_tenant_id")
 	authTenantID := mux.Vars(r)["auth_tenant_id"]
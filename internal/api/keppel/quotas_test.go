@@ -45,9 +45,15 @@ func TestQuotasAPI(t *testing.T) {
 			"manifests": assert.JSONObject{"quota": 0, "usage": 0},
 		},
 	}.Check(t, h)
-	buildLiquidResponse := func(quota, usage uint64) assert.JSONObject {
+	buildLiquidResponse := func(quota, usage uint64, egressMetrics ...assert.JSONObject) assert.JSONObject {
+		if egressMetrics == nil {
+			egressMetrics = []assert.JSONObject{}
+		}
 		return assert.JSONObject{
-			"infoVersion": 1,
+			"infoVersion": 2,
+			"metrics": assert.JSONObject{
+				"egress_bytes": egressMetrics,
+			},
 			"resources": map[string]assert.JSONObject{
 				"images": {
 					"forbidden": false,
@@ -251,7 +257,18 @@ func TestQuotasAPI(t *testing.T) {
 		Header:       map[string]string{"X-Test-Perms": "viewquota:tenant1"},
 		Body:         assert.JSONObject{"allAZs": []string{"dummy"}},
 		ExpectStatus: http.StatusOK,
-		ExpectBody:   buildLiquidResponse(100, 10),
+		ExpectBody:   buildLiquidResponse(100, 10, assert.JSONObject{"v": 0, "l": []string{"test1"}}),
+	}.Check(t, h)
+
+	// egress per account is reported as a usage metric
+	mustExec(t, s.DB, `INSERT INTO account_egress_counters (account_name, bytes) VALUES ($1, $2)`, "test1", 4096)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         "/liquid/v1/projects/tenant1/report-usage",
+		Header:       map[string]string{"X-Test-Perms": "viewquota:tenant1"},
+		Body:         assert.JSONObject{"allAZs": []string{"dummy"}},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   buildLiquidResponse(100, 10, assert.JSONObject{"v": 4096, "l": []string{"test1"}}),
 	}.Check(t, h)
 
 	// PUT error cases
@@ -287,3 +304,110 @@ func TestQuotasAPI(t *testing.T) {
 
 	// TODO audit events
 }
+
+func TestListQuotasAPI(t *testing.T) {
+	s := test.NewSetup(t, test.WithKeppelAPI)
+	h := s.Handler
+
+	// without any quotas or accounts, the list is empty
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/quotas",
+		Header:       map[string]string{"X-Test-Perms": "viewquota:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"quotas": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// tenant1 has a quota and some usage, tenant2 only has an account (and
+	// therefore the default quota of 0), tenant3 only has a quota
+	mustInsert(t, s.DB, &models.Quotas{AuthTenantID: "tenant1", ManifestCount: 100})
+	mustInsert(t, s.DB, &models.Quotas{AuthTenantID: "tenant3", ManifestCount: 20})
+	mustInsert(t, s.DB, &models.Account{
+		Name:                     "test1",
+		AuthTenantID:             "tenant1",
+		GCPoliciesJSON:           "[]",
+		SecurityScanPoliciesJSON: "[]",
+	})
+	mustInsert(t, s.DB, &models.Account{
+		Name:                     "test2",
+		AuthTenantID:             "tenant2",
+		GCPoliciesJSON:           "[]",
+		SecurityScanPoliciesJSON: "[]",
+	})
+	mustInsert(t, s.DB, &models.Repository{Name: "repo1", AccountName: "test1"})
+	mustInsert(t, s.DB, &models.Repository{Name: "repo2", AccountName: "test2"})
+	for idx := 1; idx <= 5; idx++ {
+		pushedAt := time.Unix(int64(10000+10*idx), 0)
+		repoID := int64(1)
+		if idx > 3 {
+			repoID = 2
+		}
+		mustInsert(t, s.DB, &models.Manifest{
+			RepositoryID:     repoID,
+			Digest:           test.DeterministicDummyDigest(idx),
+			MediaType:        "",
+			SizeBytes:        uint64(1000 * idx), //nolint:gosec // construction guarantees that value is positive
+			PushedAt:         pushedAt,
+			NextValidationAt: pushedAt.Add(models.ManifestValidationInterval),
+		})
+	}
+
+	tenant1Quota := assert.JSONObject{
+		"auth_tenant_id": "tenant1",
+		"manifests":      assert.JSONObject{"quota": 100, "usage": 3},
+	}
+	tenant2Quota := assert.JSONObject{
+		"auth_tenant_id": "tenant2",
+		"manifests":      assert.JSONObject{"quota": 0, "usage": 2},
+	}
+	tenant3Quota := assert.JSONObject{
+		"auth_tenant_id": "tenant3",
+		"manifests":      assert.JSONObject{"quota": 20, "usage": 0},
+	}
+
+	// only tenants visible to the user are shown
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/quotas",
+		Header:       map[string]string{"X-Test-Perms": "viewquota:tenant1,viewquota:tenant2,viewquota:tenant3"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"quotas": []assert.JSONObject{tenant1Quota, tenant2Quota, tenant3Quota}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/quotas",
+		Header:       map[string]string{"X-Test-Perms": "viewquota:tenant1,view:tenant2"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"quotas": []assert.JSONObject{tenant1Quota}},
+	}.Check(t, h)
+
+	// filter for over-quota tenants
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/quotas?over_quota=true",
+		Header:       map[string]string{"X-Test-Perms": "viewquota:tenant1,viewquota:tenant2,viewquota:tenant3"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"quotas": []assert.JSONObject{tenant2Quota}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/quotas?over_quota=false",
+		Header:       map[string]string{"X-Test-Perms": "viewquota:tenant1,viewquota:tenant3"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"quotas": []assert.JSONObject{tenant1Quota, tenant3Quota}},
+	}.Check(t, h)
+
+	// error cases
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/quotas?over_quota=maybe",
+		Header:       map[string]string{"X-Test-Perms": "viewquota:tenant1"},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("invalid value for \"over_quota\": strconv.ParseBool: parsing \"maybe\": invalid syntax\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/quotas",
+		ExpectStatus: http.StatusUnauthorized,
+	}.Check(t, h)
+}
@@ -0,0 +1,95 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ReplicaDivergence represents a models.ReplicaTagDivergence in the API.
+type ReplicaDivergence struct {
+	RepositoryName string                       `json:"repository"`
+	TagName        string                       `json:"tag"`
+	Kind           models.ReplicaDivergenceKind `json:"kind"`
+	ReplicaDigest  digest.Digest                `json:"replica_digest"`
+	PrimaryDigest  digest.Digest                `json:"primary_digest,omitempty"`
+	DetectedAt     int64                        `json:"detected_at"`
+	IsConfirmed    bool                         `json:"confirmed"`
+}
+
+var replicaDivergencesQuery = sqlext.SimplifyWhitespace(`
+	SELECT r.name, d.tag_name, d.replica_digest, d.primary_digest, d.detected_at, d.is_confirmed
+	  FROM replica_tag_divergences d
+	  JOIN repos r ON r.id = d.repo_id
+	 WHERE r.account_name = $1
+	 ORDER BY r.name, d.tag_name
+`)
+
+func (a *API) handleGetReplicaDivergences(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/replica_divergences")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	result := struct {
+		Divergences []ReplicaDivergence `json:"divergences"`
+	}{
+		Divergences: []ReplicaDivergence{},
+	}
+	err := sqlext.ForeachRow(a.db, replicaDivergencesQuery, []any{account.Name}, func(rows *sql.Rows) error {
+		var (
+			d          models.ReplicaTagDivergence
+			repoName   string
+			detectedAt time.Time
+		)
+		err := rows.Scan(&repoName, &d.TagName, &d.ReplicaDigest, &d.PrimaryDigest, &detectedAt, &d.IsConfirmed)
+		if err != nil {
+			return err
+		}
+		result.Divergences = append(result.Divergences, ReplicaDivergence{
+			RepositoryName: repoName,
+			TagName:        d.TagName,
+			Kind:           d.Kind(),
+			ReplicaDigest:  d.ReplicaDigest,
+			PrimaryDigest:  d.PrimaryDigest,
+			DetectedAt:     detectedAt.Unix(),
+			IsConfirmed:    d.IsConfirmed,
+		})
+		return nil
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,98 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGetReplicaDivergences(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+
+	// without any divergences, the report is empty
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/replica_divergences",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"divergences": []assert.JSONObject{}},
+	}.Check(t, h)
+
+	// simulate the janitor having found some divergences
+	s.Clock.StepBy(time.Hour)
+	replicaDigest := test.DeterministicDummyDigest(1)
+	primaryDigest := test.DeterministicDummyDigest(2)
+	for _, d := range []models.ReplicaTagDivergence{
+		{RepositoryID: s.Repos[0].ID, TagName: "latest", ReplicaDigest: replicaDigest, PrimaryDigest: primaryDigest, DetectedAt: s.Clock.Now(), IsConfirmed: true},
+		{RepositoryID: s.Repos[0].ID, TagName: "gone", ReplicaDigest: replicaDigest, DetectedAt: s.Clock.Now()},
+	} {
+		err := s.DB.Insert(&d)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// the report requires view permission on the account
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/replica_divergences",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant2"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/replica_divergences",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"divergences": []assert.JSONObject{
+				{
+					"repository":     "foo",
+					"tag":            "gone",
+					"kind":           "deleted_on_primary",
+					"replica_digest": replicaDigest,
+					"detected_at":    s.Clock.Now().Unix(),
+					"confirmed":      false,
+				},
+				{
+					"repository":     "foo",
+					"tag":            "latest",
+					"kind":           "digest_mismatch",
+					"replica_digest": replicaDigest,
+					"primary_digest": primaryDigest,
+					"detected_at":    s.Clock.Now().Unix(),
+					"confirmed":      true,
+				},
+			},
+		},
+	}.Check(t, h)
+}
@@ -20,23 +20,49 @@ package keppelv1
 
 import (
 	"database/sql"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/respondwith"
 	"github.com/sapcc/go-bits/sqlext"
 
+	"github.com/sapcc/keppel/internal/api"
 	"github.com/sapcc/keppel/internal/keppel"
 )
 
 // Repository represents a repository in the API.
 type Repository struct {
-	Name          string `json:"name"`
-	ManifestCount uint64 `json:"manifest_count"`
-	TagCount      uint64 `json:"tag_count"`
-	SizeBytes     uint64 `json:"size_bytes,omitempty"`
-	PushedAt      int64  `json:"pushed_at,omitempty"`
+	Name          string              `json:"name"`
+	ManifestCount uint64              `json:"manifest_count"`
+	TagCount      uint64              `json:"tag_count"`
+	SizeBytes     uint64              `json:"size_bytes,omitempty"`
+	PushedAt      int64               `json:"pushed_at,omitempty"`
+	LastPulledAt  int64               `json:"last_pulled_at,omitempty"`
+	Metadata      *RepositoryMetadata `json:"metadata,omitempty"`
+}
+
+// RepositoryMetadata appears in type Repository, and is also the request and
+// response body of PUT /keppel/v1/accounts/:account/repositories/:repo/_metadata.
+type RepositoryMetadata struct {
+	Description string `json:"description,omitempty"`
+	SourceURL   string `json:"source_url,omitempty"`
+	OwnerTeam   string `json:"owner_team,omitempty"`
+}
+
+func renderRepositoryMetadata(description, sourceURL, ownerTeam string) *RepositoryMetadata {
+	if description == "" && sourceURL == "" && ownerTeam == "" {
+		return nil
+	}
+	return &RepositoryMetadata{
+		Description: description,
+		SourceURL:   sourceURL,
+		OwnerTeam:   ownerTeam,
+	}
 }
 
 var repositoryGetQuery = sqlext.SimplifyWhitespace(`
@@ -48,28 +74,46 @@ var repositoryGetQuery = sqlext.SimplifyWhitespace(`
 			 GROUP BY bm.repo_id
 		),
 		manifest_stats AS (
-			SELECT repo_id, COUNT(*) AS count, MAX(pushed_at) AS pushed_at
+			SELECT repo_id, COUNT(*) AS count, MAX(pushed_at) AS pushed_at, MAX(last_pulled_at) AS last_pulled_at
 			  FROM manifests
 			 GROUP BY repo_id
 		),
 		tag_stats AS (
-			SELECT repo_id, COUNT(*) AS count, MAX(pushed_at) AS pushed_at
+			SELECT repo_id, COUNT(*) AS count, MAX(pushed_at) AS pushed_at, MAX(last_pulled_at) AS last_pulled_at
 			  FROM tags
 			 GROUP BY repo_id
+		),
+		repo_stats AS (
+			SELECT r.name AS name,
+			       r.description AS description,
+			       r.source_url AS source_url,
+			       r.owner_team AS owner_team,
+			       bs.size_bytes AS size_bytes,
+			       ms.count AS manifest_count,
+			       ts.count AS tag_count,
+			       COALESCE(GREATEST(ms.pushed_at, ts.pushed_at), TO_TIMESTAMP(0)) AS pushed_at,
+			       COALESCE(GREATEST(ms.last_pulled_at, ts.last_pulled_at), TO_TIMESTAMP(0)) AS last_pulled_at
+			  FROM repos r
+			  LEFT OUTER JOIN blob_stats     bs ON r.id = bs.repo_id
+			  LEFT OUTER JOIN manifest_stats ms ON r.id = ms.repo_id
+			  LEFT OUTER JOIN tag_stats      ts ON r.id = ts.repo_id
+			 WHERE r.account_name = $1 AND STARTS_WITH(r.name, $2)
 		)
-	SELECT r.name,
-	       bs.size_bytes,
-	       ms.count, ms.pushed_at,
-	       ts.count, ts.pushed_at
-	  FROM repos r
-	  LEFT OUTER JOIN blob_stats     bs ON r.id = bs.repo_id
-	  LEFT OUTER JOIN manifest_stats ms ON r.id = ms.repo_id
-	  LEFT OUTER JOIN tag_stats      ts ON r.id = ts.repo_id
-	 WHERE r.account_name = $1 AND $CONDITION
-	 ORDER BY name ASC
+	SELECT name, description, source_url, owner_team, size_bytes, manifest_count, tag_count, pushed_at, last_pulled_at
+	  FROM repo_stats
+	 WHERE $CONDITION
+	 ORDER BY $ORDER
 	 LIMIT $LIMIT
 `)
 
+// Values for the `sort` query parameter of GET /keppel/v1/accounts/:account/repositories,
+// other than the default sorting by name. These sort by the respective
+// column in descending order (i.e. most recent first), then by name.
+var repositorySortColumns = map[string]string{
+	"pushed_at":      "pushed_at",
+	"last_pulled_at": "last_pulled_at",
+}
+
 func (a *API) handleGetRepositories(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories")
 	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
@@ -81,11 +125,30 @@ func (a *API) handleGetRepositories(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query := repositoryGetQuery
+	markerCondition := ""
+	switch sortKey := r.URL.Query().Get("sort"); sortKey {
+	case "", "name":
+		query = strings.Replace(query, `$ORDER`, `name ASC`, 1)
+	default:
+		column, ok := repositorySortColumns[sortKey]
+		if !ok {
+			http.Error(w, "invalid value for sort: "+strconv.Quote(sortKey), http.StatusBadRequest)
+			return
+		}
+		query = strings.Replace(query, `$ORDER`, column+` DESC, name ASC`, 1)
+		// the marker is still the name of the last repository on the previous page,
+		// so we need to look up its sort key to know where to continue
+		markerSortKey := fmt.Sprintf(`(SELECT %s FROM repo_stats WHERE name = $MARKER)`, column)
+		markerCondition = fmt.Sprintf(`(%[1]s < %[2]s OR (%[1]s = %[2]s AND name > $MARKER))`, column, markerSortKey)
+	}
+
 	query, bindValues, limit, err := paginatedQuery{
-		SQL:         repositoryGetQuery,
-		MarkerField: "r.name",
-		Options:     r.URL.Query(),
-		BindValues:  []any{account.Name},
+		SQL:             query,
+		MarkerField:     "name",
+		MarkerCondition: markerCondition,
+		Options:         r.URL.Query(),
+		BindValues:      []any{account.Name, r.URL.Query().Get("prefix")},
 	}.Prepare()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -98,26 +161,26 @@ func (a *API) handleGetRepositories(w http.ResponseWriter, r *http.Request) {
 	}
 	err = sqlext.ForeachRow(a.db, query, bindValues, func(rows *sql.Rows) error {
 		var (
-			name                string
-			sizeBytes           *uint64
-			manifestCount       *uint64
-			maxManifestPushedAt *time.Time
-			tagCount            *uint64
-			maxTagPushedAt      *time.Time
-		)
-		err := rows.Scan(
-			&name,
-			&sizeBytes,
-			&manifestCount, &maxManifestPushedAt,
-			&tagCount, &maxTagPushedAt,
+			name          string
+			description   string
+			sourceURL     string
+			ownerTeam     string
+			sizeBytes     *uint64
+			manifestCount *uint64
+			tagCount      *uint64
+			pushedAt      time.Time
+			lastPulledAt  time.Time
 		)
+		err := rows.Scan(&name, &description, &sourceURL, &ownerTeam, &sizeBytes, &manifestCount, &tagCount, &pushedAt, &lastPulledAt)
 		if err == nil {
 			result.Repos = append(result.Repos, Repository{
 				Name:          name,
 				ManifestCount: unpackUint64OrZero(manifestCount),
 				TagCount:      unpackUint64OrZero(tagCount),
 				SizeBytes:     unpackUint64OrZero(sizeBytes),
-				PushedAt:      maxTimeToUnix(maxTagPushedAt, maxManifestPushedAt),
+				PushedAt:      pushedAt.Unix(),
+				LastPulledAt:  lastPulledAt.Unix(),
+				Metadata:      renderRepositoryMetadata(description, sourceURL, ownerTeam),
 			})
 		}
 		return err
@@ -133,7 +196,7 @@ func (a *API) handleGetRepositories(w http.ResponseWriter, r *http.Request) {
 		result.Repos = result.Repos[0:limit]
 		result.IsTruncated = true
 	}
-	respondwith.JSON(w, http.StatusOK, result)
+	respondWithConditionalJSON(w, r, result)
 }
 
 func unpackUint64OrZero(x *uint64) uint64 {
@@ -143,21 +206,6 @@ func unpackUint64OrZero(x *uint64) uint64 {
 	return *x
 }
 
-// Returns the Unix timestamp corresponding to the later of the input times (or
-// 0 if both are nil).
-func maxTimeToUnix(x, y *time.Time) int64 {
-	val := int64(0)
-	if x != nil {
-		val = x.Unix()
-	}
-	if y != nil {
-		if val < y.Unix() {
-			val = y.Unix()
-		}
-	}
-	return val
-}
-
 func (a *API) handleDeleteRepository(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo")
 	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanDeleteFromAccount))
@@ -168,6 +216,10 @@ func (a *API) handleDeleteRepository(w http.ResponseWriter, r *http.Request) {
 	if account == nil {
 		return
 	}
+	if rerr := api.CheckReadOnlyMode(a.cfg, account.Reduced()); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
 	repo := a.findRepositoryFromRequest(w, r, account.Name)
 	if repo == nil {
 		return
@@ -216,3 +268,66 @@ func (a *API) handleDeleteRepository(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+const (
+	maxRepositoryDescriptionLength = 1024
+	maxRepositoryOwnerTeamLength   = 128
+)
+
+// Validate returns an error message if the metadata is not acceptable.
+func (m RepositoryMetadata) Validate() string {
+	if len(m.Description) > maxRepositoryDescriptionLength {
+		return fmt.Sprintf("description may not be longer than %d bytes", maxRepositoryDescriptionLength)
+	}
+	if len(m.OwnerTeam) > maxRepositoryOwnerTeamLength {
+		return fmt.Sprintf("owner_team may not be longer than %d bytes", maxRepositoryOwnerTeamLength)
+	}
+	if m.SourceURL != "" {
+		u, err := url.Parse(m.SourceURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "source_url must be an absolute HTTP or HTTPS URL"
+		}
+	}
+	return ""
+}
+
+func (a *API) handlePutRepositoryMetadata(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_metadata")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPushToAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	if rerr := api.CheckReadOnlyMode(a.cfg, account.Reduced()); rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+
+	var req RepositoryMetadata
+	if !decodeJSONRequestBody(w, r.Body, &req) {
+		return
+	}
+	req.Description = strings.TrimSpace(req.Description)
+	req.SourceURL = strings.TrimSpace(req.SourceURL)
+	req.OwnerTeam = strings.TrimSpace(req.OwnerTeam)
+	if msg := req.Validate(); msg != "" {
+		http.Error(w, msg, http.StatusUnprocessableEntity)
+		return
+	}
+
+	_, err := a.db.Exec(
+		`UPDATE repos SET description = $1, source_url = $2, owner_team = $3 WHERE id = $4`,
+		req.Description, req.SourceURL, req.OwnerTeam, repo.ID,
+	)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, req)
+}
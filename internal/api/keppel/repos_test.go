@@ -21,6 +21,7 @@ package keppelv1_test
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -211,6 +212,90 @@ func TestReposAPI(t *testing.T) {
 		ExpectBody:   assert.StringData("strconv.ParseUint: parsing \"foo\": invalid syntax\n"),
 	}.Check(t, h)
 
+	// test GET with prefix filter
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories?prefix=repo1-",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"repositories": renderedRepos},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories?prefix=repo1-4",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"repositories": renderedRepos[3:4]},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories?prefix=repo2",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"repositories": []assert.JSONObject{}},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories?prefix=repo1-&limit=2&marker=repo1-3",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"repositories": renderedRepos[3:5]},
+	}.Check(t, h)
+
+	// test GET with sorting by pushed_at (most recent first, ties are broken by name)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories?sort=pushed_at&limit=2",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repositories": []assert.JSONObject{renderedRepos[2], renderedRepos[0]},
+			"truncated":    true,
+		},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories?sort=pushed_at&limit=2&marker=repo1-1",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repositories": []assert.JSONObject{renderedRepos[1], renderedRepos[3]},
+			"truncated":    true,
+		},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories?sort=pushed_at&limit=2&marker=repo1-4",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"repositories": renderedRepos[4:5]},
+	}.Check(t, h)
+
+	// test GET with sorting by last_pulled_at
+	mustExec(t, s.DB, `UPDATE tags SET last_pulled_at = $1 WHERE name = $2`, time.Unix(30000, 0), "tag2")
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories?sort=last_pulled_at&limit=2",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repositories": []assert.JSONObject{
+				{"name": "repo1-3", "manifest_count": 10, "tag_count": 3, "size_bytes": 110000, "pushed_at": 20030, "last_pulled_at": 30000},
+				renderedRepos[0],
+			},
+			"truncated": true,
+		},
+	}.Check(t, h)
+	mustExec(t, s.DB, `UPDATE tags SET last_pulled_at = NULL`)
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories?sort=size_bytes",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("invalid value for sort: \"size_bytes\"\n"),
+	}.Check(t, h)
+
 	// test DELETE happy case
 	easypg.AssertDBContent(t, s.DB.DbMap.Db, "fixtures/before-delete-repo.sql")
 	assert.HTTPRequest{
@@ -256,3 +341,117 @@ func TestReposAPI(t *testing.T) {
 		ExpectBody:   assert.StringData("cannot delete repository while there are still manifests in it\n"),
 	}.Check(t, h)
 }
+
+func TestRepositoryMetadata(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+	path := "/keppel/v1/accounts/test1/repositories/foo/_metadata"
+	header := map[string]string{"X-Test-Perms": "view:tenant1,push:tenant1"}
+
+	// check error cases
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"},
+		Body:         assert.JSONObject{"description": "Foo"},
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody:   assert.StringData("no permission for repository:test1/foo:push\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test1/repositories/bar/_metadata",
+		Header:       header,
+		Body:         assert.JSONObject{"description": "Foo"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("repo not found\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"owner": "team-foo"},
+		ExpectStatus: http.StatusBadRequest,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"source_url": "git@github.com:example/foo.git"},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("source_url must be an absolute HTTP or HTTPS URL\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"owner_team": strings.Repeat("x", 129)},
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("owner_team may not be longer than 128 bytes\n"),
+	}.Check(t, h)
+
+	// repositories without metadata do not show the "metadata" field
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories",
+		Header:       header,
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repositories": []assert.JSONObject{{"name": "foo", "manifest_count": 0, "tag_count": 0}},
+		},
+	}.Check(t, h)
+
+	// set metadata and check that it shows up in the repository listing
+	metadata := assert.JSONObject{
+		"description": "The foo service",
+		"source_url":  "https://github.com/example/foo",
+		"owner_team":  "team-foo",
+	}
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path,
+		Header:       header,
+		Body:         metadata,
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   metadata,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories",
+		Header:       header,
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repositories": []assert.JSONObject{{"name": "foo", "manifest_count": 0, "tag_count": 0, "metadata": metadata}},
+		},
+	}.Check(t, h)
+
+	// PUT replaces the metadata entirely, so omitted fields are cleared
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{"owner_team": "team-bar"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"owner_team": "team-bar"},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path,
+		Header:       header,
+		Body:         assert.JSONObject{},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{},
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories",
+		Header:       header,
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repositories": []assert.JSONObject{{"name": "foo", "manifest_count": 0, "tag_count": 0}},
+		},
+	}.Check(t, h)
+}
@@ -0,0 +1,178 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// RobotToken represents a models.RobotToken in the API.
+type RobotToken struct {
+	ID          int64  `json:"id"`
+	UserName    string `json:"username"`
+	Password    string `json:"password,omitempty"` // only shown once, upon creation
+	Repository  string `json:"repository"`
+	Description string `json:"description,omitempty"`
+	CreatedBy   string `json:"created_by,omitempty"`
+	CreatedAt   int64  `json:"created_at"`
+	ExpiresAt   int64  `json:"expires_at"`
+	RevokedAt   *int64 `json:"revoked_at,omitempty"`
+}
+
+func renderRobotToken(t models.RobotToken) RobotToken {
+	result := RobotToken{
+		ID:          t.ID,
+		UserName:    auth.RobotTokenUserName(t.ID),
+		Repository:  t.RepositoryName,
+		Description: t.Description,
+		CreatedBy:   t.CreatedBy,
+		CreatedAt:   t.CreatedAt.Unix(),
+		ExpiresAt:   t.ExpiresAt.Unix(),
+	}
+	if t.RevokedAt != nil {
+		revokedAt := t.RevokedAt.Unix()
+		result.RevokedAt = &revokedAt
+	}
+	return result
+}
+
+func (a *API) handleGetRobotTokens(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/robot_tokens")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var dbTokens []models.RobotToken
+	_, err := a.db.Select(&dbTokens, `SELECT * FROM robot_tokens WHERE account_name = $1 ORDER BY id`, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	result := make([]RobotToken, len(dbTokens))
+	for idx, t := range dbTokens {
+		result[idx] = renderRobotToken(t)
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"robot_tokens": result})
+}
+
+func (a *API) handlePostRobotToken(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/robot_tokens")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var req struct {
+		RobotToken struct {
+			Repository  string `json:"repository"`
+			Description string `json:"description"`
+			ExpiresAt   int64  `json:"expires_at"`
+		} `json:"robot_token"`
+	}
+	ok := decodeJSONRequestBody(w, r.Body, &req)
+	if !ok {
+		return
+	}
+	if !isValidRepoName(req.RobotToken.Repository) {
+		http.Error(w, "repo name invalid", http.StatusUnprocessableEntity)
+		return
+	}
+	now := a.timeNow()
+	expiresAt := time.Unix(req.RobotToken.ExpiresAt, 0)
+	if !expiresAt.After(now) {
+		http.Error(w, "expires_at must be in the future", http.StatusUnprocessableEntity)
+		return
+	}
+
+	secret, secretHash, err := auth.NewRobotTokenSecret()
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	dbToken := models.RobotToken{
+		AccountName:    account.Name,
+		RepositoryName: req.RobotToken.Repository,
+		SecretHash:     secretHash,
+		Description:    req.RobotToken.Description,
+		CreatedBy:      authz.UserIdentity.UserName(),
+		CreatedAt:      now,
+		ExpiresAt:      expiresAt,
+	}
+	err = a.db.Insert(&dbToken)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	result := renderRobotToken(dbToken)
+	result.Password = secret
+	respondwith.JSON(w, http.StatusCreated, map[string]any{"robot_token": result})
+}
+
+func (a *API) handleDeleteRobotToken(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/robot_tokens/:id")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	tokenID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "robot token not found", http.StatusNotFound)
+		return
+	}
+
+	// revoked tokens are kept around (instead of being deleted) to show who issued them and when they were revoked
+	result, err := a.db.Exec(
+		`UPDATE robot_tokens SET revoked_at = COALESCE(revoked_at, $1) WHERE id = $2 AND account_name = $3`,
+		a.timeNow(), tokenID, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	rowsUpdated, err := result.RowsAffected()
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if rowsUpdated == 0 {
+		http.Error(w, "robot token not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,251 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestRobotTokens(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	path := "/keppel/v1/accounts/test1/robot_tokens"
+
+	// the auth API checks token expiry against the real clock
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	makeRequest := func(repoName string, expiresAt int64) assert.JSONObject {
+		return assert.JSONObject{
+			"robot_token": assert.JSONObject{
+				"repository":  repoName,
+				"description": "for the CI cluster",
+				"expires_at":  expiresAt,
+			},
+		}
+	}
+
+	// check permissions
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		Body:         makeRequest("foo", expiresAt),
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "pull:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// check error cases
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         makeRequest("Invalid/Name", expiresAt),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("repo name invalid\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         makeRequest("foo", s.Clock.Now().Unix()),
+		ExpectStatus: http.StatusUnprocessableEntity,
+		ExpectBody:   assert.StringData("expires_at must be in the future\n"),
+	}.Check(t, h)
+
+	// create a token (the password is random, so we need to look at it separately)
+	_, respBodyBytes := assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         makeRequest("foo", expiresAt),
+		ExpectStatus: http.StatusCreated,
+	}.Check(t, h)
+	var respBody struct {
+		RobotToken map[string]any `json:"robot_token"`
+	}
+	err := json.Unmarshal(respBodyBytes, &respBody)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	password, ok := respBody.RobotToken["password"].(string)
+	if !ok || len(password) != 64 {
+		t.Fatalf("expected 64-character password in response, but got %#v", respBody.RobotToken["password"])
+	}
+	delete(respBody.RobotToken, "password")
+
+	expectedToken := assert.JSONObject{
+		"id":          1,
+		"username":    "robot@1",
+		"repository":  "foo",
+		"description": "for the CI cluster",
+		"created_by":  "correctusername",
+		"created_at":  s.Clock.Now().Unix(),
+		"expires_at":  expiresAt,
+	}
+	assert.DeepEqual(t, "created robot token", mustMarshalJSON(t, respBody.RobotToken), mustMarshalJSON(t, expectedToken))
+
+	// the password is not shown in the listing
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"robot_tokens": []assert.JSONObject{expectedToken}},
+	}.Check(t, h)
+
+	// the token grants pull access to its repository, but nothing else
+	expectRobotTokenAccess(t, h, "robot@1", password, "repository:test1/foo:pull,push", "repository:test1/foo:pull")
+	expectRobotTokenAccess(t, h, "robot@1", password, "repository:test1/bar:pull", "")
+	expectRobotTokenAccess(t, h, "robot@1", password, "keppel_account:test1:view", "")
+	expectRobotTokenAccess(t, h, "robot@1", "wrongpassword", "repository:test1/foo:pull", "unauthorized")
+	expectRobotTokenAccess(t, h, "robot@2", password, "repository:test1/foo:pull", "unauthorized")
+
+	// revoke the token (this is idempotent)
+	s.Clock.StepBy(time.Minute)
+	for range 2 {
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         path + "/1",
+			Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+			ExpectStatus: http.StatusNoContent,
+		}.Check(t, h)
+	}
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         path + "/2",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("robot token not found\n"),
+	}.Check(t, h)
+
+	expectedToken["revoked_at"] = s.Clock.Now().Unix()
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"robot_tokens": []assert.JSONObject{expectedToken}},
+	}.Check(t, h)
+	expectRobotTokenAccess(t, h, "robot@1", password, "repository:test1/foo:pull", "unauthorized")
+
+	// expired tokens cannot be used either
+	_, respBodyBytes = assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		Body:         makeRequest("foo", expiresAt),
+		ExpectStatus: http.StatusCreated,
+	}.Check(t, h)
+	err = json.Unmarshal(respBodyBytes, &respBody)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	password, ok = respBody.RobotToken["password"].(string)
+	if !ok {
+		t.Fatalf("expected password in response, but got %#v", respBody.RobotToken["password"])
+	}
+	expectRobotTokenAccess(t, h, "robot@2", password, "repository:test1/foo:pull", "repository:test1/foo:pull")
+	mustExec(t, s.DB, `UPDATE robot_tokens SET expires_at = $1 WHERE id = 2`, time.Now().Add(-time.Minute))
+	expectRobotTokenAccess(t, h, "robot@2", password, "repository:test1/foo:pull", "unauthorized")
+}
+
+// Obtains a token from the auth API with the given credentials, and checks
+// which access is granted by it. The expected access is given as a single
+// scope string, or "" for no access, or "unauthorized" if the credentials
+// shall be rejected.
+func expectRobotTokenAccess(t *testing.T, h http.Handler, userName, password, scope, expectedAccess string) {
+	t.Helper()
+	query := url.Values{"service": {"registry.example.org"}, "scope": {scope}}
+	req := assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/auth?" + query.Encode(),
+		Header:       map[string]string{"Authorization": keppel.BuildBasicAuthHeader(userName, password)},
+		ExpectStatus: http.StatusOK,
+	}
+	if expectedAccess == "unauthorized" {
+		req.ExpectStatus = http.StatusUnauthorized
+		req.ExpectBody = assert.JSONObject{"details": "invalid robot token credentials"}
+		req.Check(t, h)
+		return
+	}
+	_, respBodyBytes := req.Check(t, h)
+
+	var respBody struct {
+		Token string `json:"token"`
+	}
+	err := json.Unmarshal(respBodyBytes, &respBody)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tokenFields := strings.Split(respBody.Token, ".")
+	if len(tokenFields) != 3 {
+		t.Fatalf("expected token with 3 parts, got %q", respBody.Token)
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(tokenFields[1])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var payload struct {
+		Access []struct {
+			Type    string   `json:"type"`
+			Name    string   `json:"name"`
+			Actions []string `json:"actions"`
+		} `json:"access"`
+	}
+	err = json.Unmarshal(payloadBytes, &payload)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var accessStrings []string
+	for _, access := range payload.Access {
+		accessStrings = append(accessStrings, fmt.Sprintf("%s:%s:%s", access.Type, access.Name, strings.Join(access.Actions, ",")))
+	}
+	assert.DeepEqual(t, "access for "+scope, strings.Join(accessStrings, " "), expectedAccess)
+}
+
+func mustMarshalJSON(t *testing.T, data any) string {
+	t.Helper()
+	buf, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return string(buf)
+}
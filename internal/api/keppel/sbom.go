@@ -0,0 +1,157 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// Artifact types that identify a referrer manifest as an SBOM, grouped by
+// the value of the optional "format" query parameter.
+var sbomArtifactTypesByFormat = map[string][]string{
+	"spdx":      {"application/spdx+json", "text/spdx"},
+	"cyclonedx": {"application/vnd.cyclonedx+json", "application/vnd.cyclonedx+xml"},
+}
+
+var findReferrersQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM manifests
+	 WHERE repo_id = $1 AND subject_digest = $2
+	 ORDER BY pushed_at DESC, digest
+`)
+
+func (a *API) handleGetManifestSBOM(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/sbom")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+	parsedDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var artifactTypes []string
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		for _, types := range sbomArtifactTypesByFormat {
+			artifactTypes = append(artifactTypes, types...)
+		}
+	} else {
+		var exists bool
+		artifactTypes, exists = sbomArtifactTypesByFormat[format]
+		if !exists {
+			http.Error(w, fmt.Sprintf("invalid value for \"format\": %q", format), http.StatusBadRequest)
+			return
+		}
+	}
+
+	manifest, err := keppel.FindManifest(a.db, *repo, parsedDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if !manifest.QuarantineStatus.IsPullable() {
+		http.Error(w, "manifest is held in quarantine", http.StatusForbidden)
+		return
+	}
+
+	// find the most recent SBOM referrer that can be pulled
+	var referrers []models.Manifest
+	_, err = a.db.Select(&referrers, findReferrersQuery, repo.ID, manifest.Digest.String())
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	idx := slices.IndexFunc(referrers, func(m models.Manifest) bool {
+		return slices.Contains(artifactTypes, m.ArtifactType) && m.QuarantineStatus.IsPullable()
+	})
+	if idx == -1 {
+		http.Error(w, "no SBOM found for this manifest", http.StatusNotFound)
+		return
+	}
+	referrer := referrers[idx]
+
+	// the SBOM document is the first layer of the referrer
+	referrerBytes, err := a.readManifestContents(r.Context(), account.Reduced(), *repo, referrer.Digest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	parsed, _, err := keppel.ParseManifest(referrer.MediaType, referrerBytes)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	layers := parsed.FindImageLayerBlobs()
+	if len(layers) == 0 {
+		http.Error(w, fmt.Sprintf("SBOM manifest %s does not contain any layers", referrer.Digest), http.StatusUnprocessableEntity)
+		return
+	}
+	blob, err := keppel.FindBlobByRepository(a.db, layers[0].Digest, *repo)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if blob.StorageID == "" {
+		// blob has not been replicated yet
+		http.Error(w, fmt.Sprintf("blob %s is not available yet, please retry later", blob.Digest), http.StatusServiceUnavailable)
+		return
+	}
+
+	// prefer the layer media type if it is specific, otherwise fall back to the artifact type
+	contentType := layers[0].MediaType
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = referrer.ArtifactType
+	}
+
+	// write SBOM (from this point on, errors can only be logged since the
+	// response status has already been sent)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatUint(blob.SizeBytes, 10))
+	w.Header().Set("Docker-Content-Digest", blob.Digest.String())
+	w.Header().Set("X-Keppel-SBOM-Manifest-Digest", referrer.Digest.String())
+	w.WriteHeader(http.StatusOK)
+	err = copyBlobContents(r.Context(), w, a.sd, a.cfg.BlobStorageLocation(account.Reduced(), *blob), *blob)
+	if err != nil {
+		logg.Error("while writing SBOM for %s@%s: %s", repo.FullName(), manifest.Digest, err.Error())
+	}
+}
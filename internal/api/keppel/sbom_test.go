@@ -0,0 +1,129 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGetManifestSBOM(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+	repo := s.Repos[0]
+
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, *repo, "latest")
+	pathForImage := fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_manifests/%s/sbom", image.Manifest.Digest)
+	token := s.GetToken(t, "repository:test1/foo:pull")
+
+	// check error cases
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage,
+		ExpectStatus: http.StatusUnauthorized,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_manifests/%s/sbom", test.DeterministicDummyDigest(1)),
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("not found\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage,
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no SBOM found for this manifest\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage + "?format=swid",
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody:   assert.StringData("invalid value for \"format\": \"swid\"\n"),
+	}.Check(t, h)
+
+	// referrers that are not SBOMs are ignored
+	signatureLayer := test.NewBytes([]byte(`{"critical":{}}`))
+	signature := test.GenerateReferrerArtifact(image.Manifest, "application/vnd.dev.cosign.artifact.sig.v1+json", signatureLayer)
+	signature.MustUpload(t, s, *repo, "")
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage,
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no SBOM found for this manifest\n"),
+	}.Check(t, h)
+
+	// upload an SPDX SBOM for the image
+	sbomLayer := test.NewBytes([]byte(`{"spdxVersion":"SPDX-2.3","name":"foo"}`))
+	sbomLayer.MediaType = "application/spdx+json"
+	sbom := test.GenerateReferrerArtifact(image.Manifest, "application/spdx+json", sbomLayer)
+	sbom.MustUpload(t, s, *repo, "")
+
+	for _, query := range []string{"", "?format=spdx"} {
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         pathForImage + query,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{
+				"Content-Type":                  "application/spdx+json",
+				"Docker-Content-Digest":         sbomLayer.Digest.String(),
+				"X-Keppel-Sbom-Manifest-Digest": sbom.Manifest.Digest.String(),
+			},
+			ExpectBody: assert.ByteData(sbomLayer.Contents),
+		}.Check(t, h)
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage + "?format=cyclonedx",
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no SBOM found for this manifest\n"),
+	}.Check(t, h)
+
+	// when the layer does not declare a specific media type, the artifact type is used instead
+	cdxLayer := test.NewBytes([]byte(`{"bomFormat":"CycloneDX","specVersion":"1.5"}`))
+	cdx := test.GenerateReferrerArtifact(image.Manifest, "application/vnd.cyclonedx+json", cdxLayer)
+	cdx.MustUpload(t, s, *repo, "")
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         pathForImage + "?format=cyclonedx",
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+		ExpectHeader: map[string]string{
+			"Content-Type":                  "application/vnd.cyclonedx+json",
+			"X-Keppel-Sbom-Manifest-Digest": cdx.Manifest.Digest.String(),
+		},
+		ExpectBody: assert.ByteData(cdxLayer.Contents),
+	}.Check(t, h)
+}
@@ -0,0 +1,259 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1
+
+import (
+	"cmp"
+	"database/sql"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// RepositorySecuritySummary appears in the API response of GET /keppel/v1/accounts/:name/security-summary.
+type RepositorySecuritySummary struct {
+	Name                      string                                `json:"name"`
+	VulnerabilityStatusCounts map[models.VulnerabilityStatus]uint64 `json:"vulnerability_status_counts"`
+}
+
+var securitySummaryQuery = sqlext.SimplifyWhitespace(`
+	SELECT r.name, t.vuln_status, COUNT(t.digest)
+	  FROM repos r
+	  LEFT OUTER JOIN trivy_security_info t ON t.repo_id = r.id
+	 WHERE r.account_name = $1
+	 GROUP BY r.name, t.vuln_status
+	 ORDER BY r.name
+`)
+
+func (a *API) handleGetSecuritySummary(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/security-summary")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	result := struct {
+		Repositories []RepositorySecuritySummary           `json:"repositories"`
+		Totals       map[models.VulnerabilityStatus]uint64 `json:"totals"`
+	}{
+		Repositories: []RepositorySecuritySummary{},
+		Totals:       make(map[models.VulnerabilityStatus]uint64),
+	}
+	err := sqlext.ForeachRow(a.db, securitySummaryQuery, []any{account.Name}, func(rows *sql.Rows) error {
+		var (
+			repoName   string
+			vulnStatus *models.VulnerabilityStatus
+			count      uint64
+		)
+		err := rows.Scan(&repoName, &vulnStatus, &count)
+		if err != nil {
+			return err
+		}
+
+		// rows are sorted by repo name, so all rows for the same repo are adjacent
+		if len(result.Repositories) == 0 || result.Repositories[len(result.Repositories)-1].Name != repoName {
+			result.Repositories = append(result.Repositories, RepositorySecuritySummary{
+				Name:                      repoName,
+				VulnerabilityStatusCounts: make(map[models.VulnerabilityStatus]uint64),
+			})
+		}
+		if vulnStatus == nil {
+			// repo without any manifests (from the LEFT OUTER JOIN)
+			return nil
+		}
+		result.Repositories[len(result.Repositories)-1].VulnerabilityStatusCounts[*vulnStatus] = count
+		result.Totals[*vulnStatus] += count
+		return nil
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, result)
+}
+
+// AccountSecuritySummary appears in the API response of GET /keppel/v1/quotas/:auth_tenant_id/security-summary.
+type AccountSecuritySummary struct {
+	Name                      models.AccountName                    `json:"name"`
+	VulnerabilityStatusCounts map[models.VulnerabilityStatus]uint64 `json:"vulnerability_status_counts"`
+}
+
+// SecurityWorstOffender appears in the API response of GET /keppel/v1/quotas/:auth_tenant_id/security-summary.
+type SecurityWorstOffender struct {
+	AccountName         models.AccountName         `json:"account"`
+	RepositoryName      string                     `json:"repository"`
+	VulnerabilityStatus models.VulnerabilityStatus `json:"vulnerability_status"`
+	// ManifestCount counts how many manifests in this repo have the given status.
+	ManifestCount uint64 `json:"manifest_count"`
+}
+
+// SecuritySummaryTrendPoint appears in the API response of GET /keppel/v1/quotas/:auth_tenant_id/security-summary.
+type SecuritySummaryTrendPoint struct {
+	TakenAt                   int64                                 `json:"taken_at"`
+	VulnerabilityStatusCounts map[models.VulnerabilityStatus]uint64 `json:"vulnerability_status_counts"`
+}
+
+// how many repos are listed in the "worst_offenders" section of the tenant-level security summary
+const securityWorstOffendersLimit = 10
+
+var tenantSecuritySummaryQuery = sqlext.SimplifyWhitespace(`
+	SELECT a.name, r.name, t.vuln_status, COUNT(t.digest)
+	  FROM accounts a
+	  LEFT OUTER JOIN repos r ON r.account_name = a.name
+	  LEFT OUTER JOIN trivy_security_info t ON t.repo_id = r.id
+	 WHERE a.auth_tenant_id = $1
+	 GROUP BY a.name, r.name, t.vuln_status
+	 ORDER BY a.name, r.name
+`)
+
+var tenantSecurityTrendQuery = sqlext.SimplifyWhitespace(`
+	SELECT taken_at, vuln_status, manifest_count
+	  FROM security_summary_snapshots
+	 WHERE auth_tenant_id = $1
+	 ORDER BY taken_at
+`)
+
+func (a *API) handleGetTenantSecuritySummary(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/quotas/:auth_tenant_id/security-summary")
+	authTenantID := mux.Vars(r)["auth_tenant_id"]
+	authz := a.authenticateRequest(w, r, authTenantScope(keppel.CanViewAccount, authTenantID))
+	if authz == nil {
+		return
+	}
+
+	result := struct {
+		Accounts       []AccountSecuritySummary              `json:"accounts"`
+		Totals         map[models.VulnerabilityStatus]uint64 `json:"totals"`
+		WorstOffenders []SecurityWorstOffender               `json:"worst_offenders"`
+		Trend          []SecuritySummaryTrendPoint           `json:"trend"`
+	}{
+		Accounts:       []AccountSecuritySummary{},
+		Totals:         make(map[models.VulnerabilityStatus]uint64),
+		WorstOffenders: []SecurityWorstOffender{},
+		Trend:          []SecuritySummaryTrendPoint{},
+	}
+
+	// collect counts per account, and the worst status per repo
+	worstByRepo := make(map[string]*SecurityWorstOffender)
+	err := sqlext.ForeachRow(a.db, tenantSecuritySummaryQuery, []any{authTenantID}, func(rows *sql.Rows) error {
+		var (
+			accountName models.AccountName
+			repoName    *string
+			vulnStatus  *models.VulnerabilityStatus
+			count       uint64
+		)
+		err := rows.Scan(&accountName, &repoName, &vulnStatus, &count)
+		if err != nil {
+			return err
+		}
+
+		// rows are sorted by account name, so all rows for the same account are adjacent
+		if len(result.Accounts) == 0 || result.Accounts[len(result.Accounts)-1].Name != accountName {
+			result.Accounts = append(result.Accounts, AccountSecuritySummary{
+				Name:                      accountName,
+				VulnerabilityStatusCounts: make(map[models.VulnerabilityStatus]uint64),
+			})
+		}
+		if repoName == nil || vulnStatus == nil {
+			// account without repos, or repo without manifests (from the LEFT OUTER JOIN)
+			return nil
+		}
+		result.Accounts[len(result.Accounts)-1].VulnerabilityStatusCounts[*vulnStatus] += count
+		result.Totals[*vulnStatus] += count
+
+		// only statuses that are worse than "Clean" make a repo an offender
+		if !vulnStatus.HasReport() || !models.CleanSeverity.IsLessSevereThan(*vulnStatus) {
+			return nil
+		}
+		key := string(accountName) + "/" + *repoName
+		current := worstByRepo[key]
+		if current == nil || current.VulnerabilityStatus.IsLessSevereThan(*vulnStatus) {
+			worstByRepo[key] = &SecurityWorstOffender{
+				AccountName:         accountName,
+				RepositoryName:      *repoName,
+				VulnerabilityStatus: *vulnStatus,
+				ManifestCount:       count,
+			}
+		}
+		return nil
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	for _, offender := range worstByRepo {
+		result.WorstOffenders = append(result.WorstOffenders, *offender)
+	}
+	slices.SortFunc(result.WorstOffenders, func(lhs, rhs SecurityWorstOffender) int {
+		switch {
+		case lhs.VulnerabilityStatus != rhs.VulnerabilityStatus:
+			if lhs.VulnerabilityStatus.IsLessSevereThan(rhs.VulnerabilityStatus) {
+				return +1
+			}
+			return -1
+		case lhs.ManifestCount != rhs.ManifestCount:
+			return cmp.Compare(rhs.ManifestCount, lhs.ManifestCount)
+		case lhs.AccountName != rhs.AccountName:
+			return cmp.Compare(lhs.AccountName, rhs.AccountName)
+		default:
+			return cmp.Compare(lhs.RepositoryName, rhs.RepositoryName)
+		}
+	})
+	if len(result.WorstOffenders) > securityWorstOffendersLimit {
+		result.WorstOffenders = result.WorstOffenders[:securityWorstOffendersLimit]
+	}
+
+	err = sqlext.ForeachRow(a.db, tenantSecurityTrendQuery, []any{authTenantID}, func(rows *sql.Rows) error {
+		var (
+			takenAt    time.Time
+			vulnStatus models.VulnerabilityStatus
+			count      uint64
+		)
+		err := rows.Scan(&takenAt, &vulnStatus, &count)
+		if err != nil {
+			return err
+		}
+
+		// rows are sorted by timestamp, so all rows for the same snapshot are adjacent
+		if len(result.Trend) == 0 || result.Trend[len(result.Trend)-1].TakenAt != takenAt.Unix() {
+			result.Trend = append(result.Trend, SecuritySummaryTrendPoint{
+				TakenAt:                   takenAt.Unix(),
+				VulnerabilityStatusCounts: make(map[models.VulnerabilityStatus]uint64),
+			})
+		}
+		result.Trend[len(result.Trend)-1].VulnerabilityStatusCounts[vulnStatus] = count
+		return nil
+	})
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	respondwith.JSON(w, http.StatusOK, result)
+}
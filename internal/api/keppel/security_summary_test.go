@@ -0,0 +1,212 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGetSecuritySummary(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+		test.WithRepo(models.Repository{Name: "bar", AccountName: "test1"}),
+		test.WithRepo(models.Repository{Name: "empty", AccountName: "test1"}),
+	)
+	h := s.Handler
+
+	// insert some dummy manifests with different vulnerability statuses
+	statusesByRepoID := map[int64][]models.VulnerabilityStatus{
+		1: {models.CleanSeverity, models.CleanSeverity, models.CleanSeverity, models.HighSeverity, models.PendingVulnerabilityStatus},
+		2: {models.RottenVulnerabilityStatus, models.RottenVulnerabilityStatus},
+	}
+	counter := 0
+	for repoID, statuses := range statusesByRepoID {
+		for _, status := range statuses {
+			counter++
+			dummyDigest := test.DeterministicDummyDigest(counter)
+			mustInsert(t, s.DB, &models.Manifest{
+				RepositoryID:     repoID,
+				Digest:           dummyDigest,
+				MediaType:        "application/vnd.docker.distribution.manifest.v2+json",
+				SizeBytes:        1000,
+				PushedAt:         time.Unix(1000, 0),
+				NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+			})
+			mustInsert(t, s.DB, &models.TrivySecurityInfo{
+				RepositoryID:        repoID,
+				Digest:              dummyDigest,
+				VulnerabilityStatus: status,
+				NextCheckAt:         time.Unix(0, 0),
+			})
+		}
+	}
+
+	// the summary requires view permission on the account
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/security-summary",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant2"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/security-summary",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"repositories": []assert.JSONObject{
+				{
+					"name":                        "bar",
+					"vulnerability_status_counts": assert.JSONObject{"Rotten": 2},
+				},
+				{
+					"name":                        "empty",
+					"vulnerability_status_counts": assert.JSONObject{},
+				},
+				{
+					"name":                        "foo",
+					"vulnerability_status_counts": assert.JSONObject{"Clean": 3, "High": 1, "Pending": 1},
+				},
+			},
+			"totals": assert.JSONObject{"Clean": 3, "High": 1, "Pending": 1, "Rotten": 2},
+		},
+	}.Check(t, h)
+}
+
+func TestGetTenantSecuritySummary(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "tenant1"}),
+		test.WithAccount(models.Account{Name: "test3", AuthTenantID: "tenant1"}),
+		test.WithAccount(models.Account{Name: "other", AuthTenantID: "tenant2"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+		test.WithRepo(models.Repository{Name: "bar", AccountName: "test1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test2"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "other"}),
+	)
+	h := s.Handler
+
+	// insert some dummy manifests with different vulnerability statuses
+	statusesByRepoID := map[int64][]models.VulnerabilityStatus{
+		1: {models.CleanSeverity, models.HighSeverity, models.HighSeverity, models.PendingVulnerabilityStatus},
+		2: {models.CleanSeverity, models.RottenVulnerabilityStatus},
+		3: {models.HighSeverity, models.LowSeverity},
+		4: {models.CriticalSeverity},
+	}
+	counter := 0
+	for repoID, statuses := range statusesByRepoID {
+		for _, status := range statuses {
+			counter++
+			dummyDigest := test.DeterministicDummyDigest(counter)
+			mustInsert(t, s.DB, &models.Manifest{
+				RepositoryID:     repoID,
+				Digest:           dummyDigest,
+				MediaType:        "application/vnd.docker.distribution.manifest.v2+json",
+				SizeBytes:        1000,
+				PushedAt:         time.Unix(1000, 0),
+				NextValidationAt: time.Unix(1000, 0).Add(models.ManifestValidationInterval),
+			})
+			mustInsert(t, s.DB, &models.TrivySecurityInfo{
+				RepositoryID:        repoID,
+				Digest:              dummyDigest,
+				VulnerabilityStatus: status,
+				NextCheckAt:         time.Unix(0, 0),
+			})
+		}
+	}
+
+	// insert some snapshots for the trend (as written by the janitor)
+	day1 := time.Unix(86400, 0).UTC()
+	day2 := time.Unix(2*86400, 0).UTC()
+	for _, snapshot := range []models.SecuritySummarySnapshot{
+		{AuthTenantID: "tenant1", TakenAt: day1, VulnerabilityStatus: models.CriticalSeverity, ManifestCount: 3},
+		{AuthTenantID: "tenant1", TakenAt: day1, VulnerabilityStatus: models.CleanSeverity, ManifestCount: 1},
+		{AuthTenantID: "tenant1", TakenAt: day2, VulnerabilityStatus: models.HighSeverity, ManifestCount: 3},
+		{AuthTenantID: "tenant1", TakenAt: day2, VulnerabilityStatus: models.CleanSeverity, ManifestCount: 2},
+		{AuthTenantID: "tenant2", TakenAt: day2, VulnerabilityStatus: models.CriticalSeverity, ManifestCount: 1},
+	} {
+		mustInsert(t, s.DB, &snapshot)
+	}
+
+	// the summary requires view permission on the tenant
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/quotas/tenant1/security-summary",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant2"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/quotas/tenant1/security-summary",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"accounts": []assert.JSONObject{
+				{
+					"name":                        "test1",
+					"vulnerability_status_counts": assert.JSONObject{"Clean": 2, "High": 2, "Pending": 1, "Rotten": 1},
+				},
+				{
+					"name":                        "test2",
+					"vulnerability_status_counts": assert.JSONObject{"High": 1, "Low": 1},
+				},
+				{
+					"name":                        "test3",
+					"vulnerability_status_counts": assert.JSONObject{},
+				},
+			},
+			"totals": assert.JSONObject{"Clean": 2, "High": 3, "Low": 1, "Pending": 1, "Rotten": 1},
+			"worst_offenders": []assert.JSONObject{
+				{"account": "test1", "repository": "bar", "vulnerability_status": "Rotten", "manifest_count": 1},
+				{"account": "test1", "repository": "foo", "vulnerability_status": "High", "manifest_count": 2},
+				{"account": "test2", "repository": "foo", "vulnerability_status": "High", "manifest_count": 1},
+			},
+			"trend": []assert.JSONObject{
+				{"taken_at": day1.Unix(), "vulnerability_status_counts": assert.JSONObject{"Clean": 1, "Critical": 3}},
+				{"taken_at": day2.Unix(), "vulnerability_status_counts": assert.JSONObject{"Clean": 2, "High": 3}},
+			},
+		},
+	}.Check(t, h)
+
+	// tenants without any accounts get an empty summary
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/quotas/tenant3/security-summary",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant3"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"accounts":        []assert.JSONObject{},
+			"totals":          assert.JSONObject{},
+			"worst_offenders": []assert.JSONObject{},
+			"trend":           []assert.JSONObject{},
+		},
+	}.Check(t, h)
+}
@@ -0,0 +1,127 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+const (
+	defaultSignedURLExpiry = time.Hour
+	maxSignedURLExpiry     = 7 * 24 * time.Hour
+)
+
+func (a *API) handlePostSignedURL(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_signed_url")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+
+	var req struct {
+		SignedURL struct {
+			Kind             string        `json:"kind"`
+			Digest           digest.Digest `json:"digest"`
+			ExpiresInSeconds uint64        `json:"expires_in"`
+		} `json:"signed_url"`
+	}
+	ok := decodeJSONRequestBody(w, r.Body, &req)
+	if !ok {
+		return
+	}
+	err := req.SignedURL.Digest.Validate()
+	if err != nil {
+		http.Error(w, `invalid value for "signed_url.digest": `+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	expiresIn := defaultSignedURLExpiry
+	if req.SignedURL.ExpiresInSeconds > 0 {
+		expiresIn = time.Duration(req.SignedURL.ExpiresInSeconds) * time.Second //nolint:gosec // overflow is caught by the check below
+		if expiresIn <= 0 || expiresIn > maxSignedURLExpiry {
+			msg := fmt.Sprintf(`invalid value for "signed_url.expires_in": must not be larger than %d`, uint64(maxSignedURLExpiry.Seconds()))
+			http.Error(w, msg, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	// the signed URL can only be generated for content that exists in this repo
+	var urlPath string
+	switch req.SignedURL.Kind {
+	case "manifest":
+		_, err = keppel.FindManifest(a.db, *repo, req.SignedURL.Digest)
+		urlPath = fmt.Sprintf("/v2/%s/manifests/%s", repo.FullName(), req.SignedURL.Digest)
+	case "blob":
+		_, err = keppel.FindBlobByRepository(a.db, req.SignedURL.Digest, *repo)
+		urlPath = fmt.Sprintf("/v2/%s/blobs/%s", repo.FullName(), req.SignedURL.Digest)
+	default:
+		http.Error(w, `invalid value for "signed_url.kind": must be "manifest" or "blob"`, http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	// the token embedded in the URL only grants pull access to this repo, and
+	// is only accepted for the exact URL path that it was issued for
+	signedAuthz := auth.Authorization{
+		UserIdentity: authz.UserIdentity,
+		ScopeSet: auth.NewScopeSet(auth.Scope{
+			ResourceType: "repository",
+			ResourceName: repo.FullName(),
+			Actions:      []string{"pull"},
+		}),
+		Audience: auth.Audience{},
+	}
+	tokenResponse, err := signedAuthz.IssueSignedURLToken(a.cfg, urlPath, expiresIn)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	signedURL := auth.SignURL(url.URL{Scheme: "https", Host: a.cfg.APIPublicHostname, Path: a.cfg.APIPathPrefix + urlPath}, tokenResponse.Token)
+	respondwith.JSON(w, http.StatusOK, map[string]any{
+		"signed_url": map[string]any{
+			"url":        signedURL,
+			"expires_in": tokenResponse.ExpiresIn,
+		},
+	})
+}
@@ -0,0 +1,156 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestSignedURLs(t *testing.T) {
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		s := test.NewSetup(t,
+			test.WithKeppelAPI,
+			test.WithQuotas,
+			test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+			test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+		)
+		h := s.Handler
+		repo := s.Repos[0]
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, *repo, "latest")
+		path := "/keppel/v1/accounts/test1/repositories/foo/_signed_url"
+		header := map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"}
+		makeRequest := func(kind string, d digest.Digest, expiresIn int) assert.JSONObject {
+			return assert.JSONObject{"signed_url": assert.JSONObject{"kind": kind, "digest": d, "expires_in": expiresIn}}
+		}
+
+		// check permissions and validation errors
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         path,
+			Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+			Body:         makeRequest("manifest", image.Manifest.Digest, 0),
+			ExpectStatus: http.StatusForbidden,
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         path,
+			Header:       header,
+			Body:         makeRequest("tag", image.Manifest.Digest, 0),
+			ExpectStatus: http.StatusUnprocessableEntity,
+			ExpectBody:   assert.StringData("invalid value for \"signed_url.kind\": must be \"manifest\" or \"blob\"\n"),
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         path,
+			Header:       header,
+			Body:         makeRequest("manifest", image.Manifest.Digest, 30*24*3600),
+			ExpectStatus: http.StatusUnprocessableEntity,
+			ExpectBody:   assert.StringData("invalid value for \"signed_url.expires_in\": must not be larger than 604800\n"),
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         path,
+			Header:       header,
+			Body:         makeRequest("blob", image.Manifest.Digest, 0),
+			ExpectStatus: http.StatusNotFound,
+			ExpectBody:   assert.StringData("not found\n"),
+		}.Check(t, h)
+
+		// generate signed URLs for the manifest and a blob
+		getSignedURL := func(kind string, d digest.Digest) *url.URL {
+			t.Helper()
+			_, respBody := assert.HTTPRequest{
+				Method:       "POST",
+				Path:         path,
+				Header:       header,
+				Body:         makeRequest(kind, d, 600),
+				ExpectStatus: http.StatusOK,
+			}.Check(t, h)
+			var resp struct {
+				SignedURL struct {
+					URL       string `json:"url"`
+					ExpiresIn uint64 `json:"expires_in"`
+				} `json:"signed_url"`
+			}
+			err := json.Unmarshal(respBody, &resp)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if resp.SignedURL.ExpiresIn != 600 {
+				t.Errorf("expected expires_in = 600, but got %d", resp.SignedURL.ExpiresIn)
+			}
+			u, err := url.Parse(resp.SignedURL.URL)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			return u
+		}
+		manifestURL := getSignedURL("manifest", image.Manifest.Digest)
+		blobURL := getSignedURL("blob", image.Layers[0].Digest)
+		assert.DeepEqual(t, "manifestURL.Path", manifestURL.Path, "/v2/test1/foo/manifests/"+image.Manifest.Digest.String())
+		assert.DeepEqual(t, "blobURL.Path", blobURL.Path, "/v2/test1/foo/blobs/"+image.Layers[0].Digest.String())
+
+		// the signed URLs can be used without any auth headers
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         manifestURL.RequestURI(),
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         blobURL.RequestURI(),
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.ByteData(image.Layers[0].Contents),
+		}.Check(t, h)
+
+		// but only for the exact URL that they were issued for...
+		token := manifestURL.Query().Get(auth.SignedURLQueryParameter)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest?" + auth.SignedURLQueryParameter + "=" + token,
+			ExpectStatus: http.StatusUnauthorized,
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         manifestURL.RequestURI(),
+			ExpectStatus: http.StatusUnauthorized,
+		}.Check(t, h)
+
+		// ...and not as regular bearer tokens
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         manifestURL.Path,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusUnauthorized,
+		}.Check(t, h)
+	})
+}
@@ -0,0 +1,101 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// TagHistoryEntry represents a models.TagHistoryEntry in the API.
+type TagHistoryEntry struct {
+	OldDigest digest.Digest `json:"old_digest"`
+	NewDigest digest.Digest `json:"new_digest"`
+	MovedAt   int64         `json:"moved_at"`
+	MovedBy   string        `json:"moved_by,omitempty"`
+}
+
+func (a *API) handleGetTagHistory(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_tags/:name/history")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+	tagName := mux.Vars(r)["tag_name"]
+
+	var dbTags []models.Tag
+	_, err := a.db.Select(&dbTags, `SELECT * FROM tags WHERE repo_id = $1 AND name = $2`, repo.ID, tagName)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	var dbEntries []models.TagHistoryEntry
+	_, err = a.db.Select(&dbEntries,
+		`SELECT * FROM tag_history WHERE repo_id = $1 AND tag_name = $2 ORDER BY moved_at DESC, id DESC`,
+		repo.ID, tagName)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if len(dbTags) == 0 && len(dbEntries) == 0 {
+		http.Error(w, "no such tag", http.StatusNotFound)
+		return
+	}
+
+	type currentState struct {
+		Digest   digest.Digest `json:"digest"`
+		PushedAt int64         `json:"pushed_at"`
+	}
+	result := struct {
+		// omitted if the tag has been deleted since
+		Current *currentState     `json:"current,omitempty"`
+		History []TagHistoryEntry `json:"history"`
+	}{
+		History: make([]TagHistoryEntry, 0, len(dbEntries)),
+	}
+	if len(dbTags) > 0 {
+		result.Current = &currentState{
+			Digest:   dbTags[0].Digest,
+			PushedAt: dbTags[0].PushedAt.Unix(),
+		}
+	}
+	for _, e := range dbEntries {
+		result.History = append(result.History, TagHistoryEntry{
+			OldDigest: e.OldDigest,
+			NewDigest: e.NewDigest,
+			MovedAt:   e.MovedAt.Unix(),
+			MovedBy:   e.UserName,
+		})
+	}
+	respondwith.JSON(w, http.StatusOK, result)
+}
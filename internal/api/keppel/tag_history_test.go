@@ -0,0 +1,120 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGetTagHistory(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithQuotas,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+	repo := s.Repos[0]
+
+	path := "/keppel/v1/accounts/test1/repositories/foo/_tags/latest/history"
+	header := map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"}
+
+	// push a tag and move it twice (pushing the same manifest again does not count as a movement)
+	image1 := test.GenerateImage(test.GenerateExampleLayer(1))
+	image2 := test.GenerateImage(test.GenerateExampleLayer(2))
+	s.Clock.StepBy(time.Hour)
+	image1.MustUpload(t, s, *repo, "latest")
+	s.Clock.StepBy(time.Hour)
+	image2.MustUpload(t, s, *repo, "latest")
+	firstMoveAt := s.Clock.Now().Unix()
+	s.Clock.StepBy(time.Hour)
+	image2.MustUpload(t, s, *repo, "latest")
+	s.Clock.StepBy(time.Hour)
+	image1.MustUpload(t, s, *repo, "latest")
+	secondMoveAt := s.Clock.Now().Unix()
+
+	// check permission
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// check error cases
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_tags/unknown/history",
+		Header:       header,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("no such tag\n"),
+	}.Check(t, h)
+
+	// history is listed from newest to oldest
+	expectedHistory := []assert.JSONObject{
+		{
+			"old_digest": image2.Manifest.Digest.String(),
+			"new_digest": image1.Manifest.Digest.String(),
+			"moved_at":   secondMoveAt,
+			"moved_by":   "correctusername",
+		},
+		{
+			"old_digest": image1.Manifest.Digest.String(),
+			"new_digest": image2.Manifest.Digest.String(),
+			"moved_at":   firstMoveAt,
+			"moved_by":   "correctusername",
+		},
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       header,
+		ExpectStatus: http.StatusOK,
+		ExpectBody: assert.JSONObject{
+			"current": assert.JSONObject{
+				"digest":    image1.Manifest.Digest.String(),
+				"pushed_at": secondMoveAt,
+			},
+			"history": expectedHistory,
+		},
+	}.Check(t, h)
+
+	// the history remains available after the tag is deleted
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test1/repositories/foo/_tags/latest",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,delete:tenant1"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       header,
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"history": expectedHistory},
+	}.Check(t, h)
+}
@@ -0,0 +1,145 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// This matches a fully-qualified domain name with at least two labels, in lowercase.
+var vanityHostnameRx = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)+$`)
+
+var claimVanityDomainQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO vanity_domains (hostname, account_name) VALUES ($1, $2)
+	ON CONFLICT (hostname) DO NOTHING
+`)
+
+func (a *API) handleGetVanityDomains(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/vanity_domains")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanViewAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	var hostnames []string
+	_, err := a.db.Select(&hostnames, `SELECT hostname FROM vanity_domains WHERE account_name = $1 ORDER BY hostname`, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if len(hostnames) == 0 {
+		hostnames = []string{}
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]any{"vanity_domains": hostnames})
+}
+
+func (a *API) handlePutVanityDomain(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/vanity_domains/:hostname")
+	// since Keppel cannot verify who owns a hostname, claiming a vanity domain
+	// requires the operator's approval in addition to the account permission
+	scopes := accountScopeFromRequest(r, keppel.CanChangeAccount)
+	scopes.Add(auth.ClusterConfigScope)
+	authz := a.authenticateRequest(w, r, scopes)
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	hostname := mux.Vars(r)["hostname"]
+	if len(hostname) > 253 || !vanityHostnameRx.MatchString(hostname) {
+		http.Error(w, "hostname is not a valid lowercase domain name", http.StatusUnprocessableEntity)
+		return
+	}
+	// the hostnames of Keppel's own APIs (including all domain-remapped APIs)
+	// cannot be used as vanity domains
+	for _, apiHostname := range []string{a.cfg.APIPublicHostname, a.cfg.AnycastAPIPublicHostname} {
+		if apiHostname != "" && (hostname == apiHostname || strings.HasSuffix(hostname, "."+apiHostname)) {
+			http.Error(w, "hostname is reserved for Keppel's own APIs", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	// the same goes for the APIs of our peers
+	peerCount, err := a.db.SelectInt(`SELECT COUNT(*) FROM peers WHERE hostname = $1`, hostname)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if peerCount > 0 {
+		http.Error(w, "hostname is reserved for a peered Keppel instance", http.StatusUnprocessableEntity)
+		return
+	}
+
+	_, err = a.db.Exec(claimVanityDomainQuery, hostname, account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	ownerName, err := a.db.SelectStr(`SELECT account_name FROM vanity_domains WHERE hostname = $1`, hostname)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if ownerName != string(account.Name) {
+		http.Error(w, "hostname is already in use by another account", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) handleDeleteVanityDomain(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/vanity_domains/:hostname")
+	authz := a.authenticateRequest(w, r, accountScopeFromRequest(r, keppel.CanChangeAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	result, err := a.db.Exec(
+		`DELETE FROM vanity_domains WHERE hostname = $1 AND account_name = $2`,
+		mux.Vars(r)["hostname"], account.Name)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	rowsDeleted, err := result.RowsAffected()
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if rowsDeleted == 0 {
+		http.Error(w, "vanity domain not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
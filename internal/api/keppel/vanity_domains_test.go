@@ -0,0 +1,144 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestVanityDomains(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "tenant1"}),
+	)
+	h := s.Handler
+	path := "/keppel/v1/accounts/test1/vanity_domains"
+	err := s.DB.Insert(&models.Peer{HostName: "peer.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimHeader := map[string]string{"X-Test-Perms": "change:tenant1,changecluster:"}
+
+	// check permissions
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "pull:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path + "/teamxyz.example.com",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	// claiming a vanity domain requires both permission for the account and permission from the operator
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path + "/teamxyz.example.com",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         path + "/teamxyz.example.com",
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1,changecluster:"},
+		ExpectStatus: http.StatusForbidden,
+	}.Check(t, h)
+
+	// empty list initially
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"vanity_domains": []string{}},
+	}.Check(t, h)
+
+	// check validation errors
+	for _, hostname := range []string{"localhost", "Team.Example.com", "-team.example.com", "registry.example.org", "foo.registry.example.org", "peer.example.org"} {
+		assert.HTTPRequest{
+			Method:       "PUT",
+			Path:         path + "/" + hostname,
+			Header:       claimHeader,
+			ExpectStatus: http.StatusUnprocessableEntity,
+		}.Check(t, h)
+	}
+
+	// claim some hostnames (PUT is idempotent)
+	for _, hostname := range []string{"teamxyz.example.com", "teamxyz.example.com", "registry.teamxyz.example.com"} {
+		assert.HTTPRequest{
+			Method:       "PUT",
+			Path:         path + "/" + hostname,
+			Header:       claimHeader,
+			ExpectStatus: http.StatusNoContent,
+		}.Check(t, h)
+	}
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"vanity_domains": []string{"registry.teamxyz.example.com", "teamxyz.example.com"}},
+	}.Check(t, h)
+
+	// another account cannot claim or delete the same hostname
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/keppel/v1/accounts/test2/vanity_domains/teamxyz.example.com",
+		Header:       claimHeader,
+		ExpectStatus: http.StatusConflict,
+		ExpectBody:   assert.StringData("hostname is already in use by another account\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         "/keppel/v1/accounts/test2/vanity_domains/teamxyz.example.com",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+	}.Check(t, h)
+
+	// delete one hostname
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         path + "/registry.teamxyz.example.com",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		ExpectStatus: http.StatusNoContent,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "DELETE",
+		Path:         path + "/registry.teamxyz.example.com",
+		Header:       map[string]string{"X-Test-Perms": "change:tenant1"},
+		ExpectStatus: http.StatusNotFound,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         path,
+		Header:       map[string]string{"X-Test-Perms": "view:tenant1"},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.JSONObject{"vanity_domains": []string{"teamxyz.example.com"}},
+	}.Check(t, h)
+}
@@ -0,0 +1,218 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// VerificationReport is the response body of POST .../_verify/:digest.
+type VerificationReport struct {
+	Valid     bool                 `json:"valid"`
+	Manifests []VerificationResult `json:"manifests"`
+	Blobs     []VerificationResult `json:"blobs"`
+}
+
+// VerificationResult appears in type VerificationReport.
+type VerificationResult struct {
+	Digest    digest.Digest      `json:"digest"`
+	MediaType string             `json:"media_type,omitempty"`
+	SizeBytes uint64             `json:"size_bytes,omitempty"`
+	Status    VerificationStatus `json:"status"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// VerificationStatus is an enum that appears in type VerificationResult.
+type VerificationStatus string
+
+const (
+	// VerificationValid means that the object was read from the storage and matches its DB record.
+	VerificationValid VerificationStatus = "valid"
+	// VerificationInvalid means that the object could not be read, or does not match its DB record.
+	VerificationInvalid VerificationStatus = "invalid"
+	// VerificationNotReplicated means that the blob has not been replicated from upstream yet, so there is nothing to verify.
+	VerificationNotReplicated VerificationStatus = "not_replicated"
+)
+
+var verifyChildManifestsQuery = sqlext.SimplifyWhitespace(`
+	SELECT child_digest FROM manifest_manifest_refs WHERE repo_id = $1 AND parent_digest = $2 ORDER BY child_digest
+`)
+
+var verifyBlobsQuery = sqlext.SimplifyWhitespace(`
+	SELECT b.* FROM blobs b
+	  JOIN manifest_blob_refs r ON r.blob_id = b.id
+	 WHERE r.repo_id = $1 AND r.digest = $2
+	 ORDER BY b.digest
+`)
+
+func (a *API) handlePostVerifyManifest(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_verify/:digest")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+	parsedDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	_, err = keppel.FindManifest(a.db, *repo, parsedDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	v := manifestVerifier{
+		API:               a,
+		Account:           account.Reduced(),
+		Repo:              *repo,
+		IsManifestVisited: make(map[digest.Digest]bool),
+		IsBlobVisited:     make(map[digest.Digest]bool),
+		Report:            VerificationReport{Valid: true},
+	}
+	err = v.verifyManifest(r.Context(), parsedDigest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, v.Report)
+}
+
+// Walks the given manifest and everything referenced by it, validating each
+// object against the storage in the same way as the respective janitor jobs.
+type manifestVerifier struct {
+	API               *API
+	Account           models.ReducedAccount
+	Repo              models.Repository
+	IsManifestVisited map[digest.Digest]bool
+	IsBlobVisited     map[digest.Digest]bool
+	Report            VerificationReport
+}
+
+func (v *manifestVerifier) addResult(results *[]VerificationResult, result VerificationResult) {
+	if result.Status == VerificationInvalid {
+		v.Report.Valid = false
+	}
+	*results = append(*results, result)
+}
+
+// Only unexpected errors (e.g. DB errors) are returned. Validation failures
+// are recorded in the report instead.
+func (v *manifestVerifier) verifyManifest(ctx context.Context, manifestDigest digest.Digest) error {
+	if v.IsManifestVisited[manifestDigest] {
+		return nil
+	}
+	v.IsManifestVisited[manifestDigest] = true
+
+	manifest, err := keppel.FindManifest(v.API.db, v.Repo, manifestDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		v.addResult(&v.Report.Manifests, VerificationResult{
+			Digest: manifestDigest,
+			Status: VerificationInvalid,
+			Error:  "manifest not found in this repository",
+		})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	result := VerificationResult{
+		Digest:    manifest.Digest,
+		MediaType: manifest.MediaType,
+		SizeBytes: manifest.SizeBytes,
+		Status:    VerificationValid,
+	}
+	err = v.API.processor().ValidateExistingManifest(ctx, v.Account, v.Repo, manifest)
+	if err != nil {
+		result.Status = VerificationInvalid
+		result.Error = err.Error()
+	}
+	v.addResult(&v.Report.Manifests, result)
+
+	// verify blobs referenced by this manifest
+	var blobs []models.Blob
+	_, err = v.API.db.Select(&blobs, verifyBlobsQuery, v.Repo.ID, manifest.Digest)
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		v.verifyBlob(ctx, blob)
+	}
+
+	// verify manifests referenced by this manifest
+	var childDigests []digest.Digest
+	_, err = v.API.db.Select(&childDigests, verifyChildManifestsQuery, v.Repo.ID, manifest.Digest)
+	if err != nil {
+		return err
+	}
+	for _, childDigest := range childDigests {
+		err := v.verifyManifest(ctx, childDigest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *manifestVerifier) verifyBlob(ctx context.Context, blob models.Blob) {
+	if v.IsBlobVisited[blob.Digest] {
+		return
+	}
+	v.IsBlobVisited[blob.Digest] = true
+
+	result := VerificationResult{
+		Digest:    blob.Digest,
+		MediaType: blob.MediaType,
+		SizeBytes: blob.SizeBytes,
+		Status:    VerificationValid,
+	}
+	if blob.StorageID == "" {
+		result.Status = VerificationNotReplicated
+	} else {
+		err := v.API.processor().ValidateExistingBlob(ctx, v.Account, blob)
+		if err != nil {
+			result.Status = VerificationInvalid
+			result.Error = err.Error()
+		}
+	}
+	v.addResult(&v.Report.Blobs, result)
+}
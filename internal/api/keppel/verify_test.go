@@ -0,0 +1,108 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	keppelv1 "github.com/sapcc/keppel/internal/api/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestPostVerifyManifest(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithKeppelAPI,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+		test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+	)
+	h := s.Handler
+	repo := s.Repos[0]
+
+	layer := test.GenerateExampleLayer(1)
+	image := test.GenerateImage(layer)
+	image.MustUpload(t, s, *repo, "latest")
+	path := fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_verify/%s", image.Manifest.Digest)
+	token := s.GetToken(t, "repository:test1/foo:pull")
+
+	// check error cases
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         path,
+		ExpectStatus: http.StatusUnauthorized,
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "POST",
+		Path:         fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_verify/%s", test.DeterministicDummyDigest(1)),
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("not found\n"),
+	}.Check(t, h)
+
+	// intact image verifies successfully
+	verify := func() keppelv1.VerificationReport {
+		t.Helper()
+		_, body := assert.HTTPRequest{
+			Method:       "POST",
+			Path:         path,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+		}.Check(t, h)
+		var report keppelv1.VerificationReport
+		err := json.Unmarshal(body, &report)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return report
+	}
+	report := verify()
+	assert.DeepEqual(t, "report.Valid", report.Valid, true)
+	assert.DeepEqual(t, "report.Manifests", report.Manifests, []keppelv1.VerificationResult{{
+		Digest:    image.Manifest.Digest,
+		MediaType: image.Manifest.MediaType,
+		SizeBytes: image.SizeBytes(),
+		Status:    keppelv1.VerificationValid,
+	}})
+	assert.DeepEqual(t, "number of blobs", len(report.Blobs), 2)
+	for _, blob := range report.Blobs {
+		assert.DeepEqual(t, "status of blob "+blob.Digest.String(), blob.Status, keppelv1.VerificationValid)
+	}
+
+	// blob that does not match its DB record is reported as invalid
+	_, err := s.DB.Exec(`UPDATE blobs SET size_bytes = size_bytes + 1 WHERE digest = $1`, layer.Digest.String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	report = verify()
+	assert.DeepEqual(t, "report.Valid", report.Valid, false)
+	for _, blob := range report.Blobs {
+		if blob.Digest != layer.Digest {
+			assert.DeepEqual(t, "status of blob "+blob.Digest.String(), blob.Status, keppelv1.VerificationValid)
+			continue
+		}
+		assert.DeepEqual(t, "status of blob "+blob.Digest.String(), blob.Status, keppelv1.VerificationInvalid)
+		expectedError := fmt.Sprintf("expected %d bytes, but got %d bytes", len(layer.Contents)+1, len(layer.Contents))
+		assert.DeepEqual(t, "error of blob "+blob.Digest.String(), blob.Error, expectedError)
+	}
+}
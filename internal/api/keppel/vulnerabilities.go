@@ -0,0 +1,209 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/docker/distribution"
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/errext"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/api"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/trivy"
+)
+
+// LayerVulnerabilities appears in the API response of
+// GET /keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/vulnerabilities.
+type LayerVulnerabilities struct {
+	Digest              string                     `json:"digest,omitempty"`
+	DiffID              string                     `json:"diff_id,omitempty"`
+	VulnerabilityStatus models.VulnerabilityStatus `json:"vulnerability_status"`
+	Vulnerabilities     []Vulnerability            `json:"vulnerabilities"`
+}
+
+// Vulnerability appears in type LayerVulnerabilities.
+type Vulnerability struct {
+	ID               string                     `json:"id"`
+	PackageName      string                     `json:"package"`
+	InstalledVersion string                     `json:"installed_version,omitempty"`
+	FixedVersion     string                     `json:"fixed_version,omitempty"`
+	Severity         models.VulnerabilityStatus `json:"severity"`
+	ReportedSeverity models.VulnerabilityStatus `json:"reported_severity,omitempty"`
+}
+
+func (a *API) handleGetManifestVulnerabilities(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/keppel/v1/accounts/:account/repositories/:repo/_manifests/:digest/vulnerabilities")
+	authz := a.authenticateRequest(w, r, repoScopeFromRequest(r, keppel.CanPullFromAccount))
+	if authz == nil {
+		return
+	}
+	account := a.findAccountFromRequest(w, r, authz)
+	if account == nil {
+		return
+	}
+
+	err := api.CheckRateLimit(r, a.rle, account.Reduced(), authz, keppel.TrivyReportRetrieveAction, 1)
+	if err != nil {
+		if rerr, ok := errext.As[*keppel.RegistryV2Error](err); ok && rerr != nil {
+			rerr.WriteAsRegistryV2ResponseTo(w, r)
+			return
+		} else if respondwith.ErrorText(w, err) {
+			return
+		}
+	}
+
+	repo := a.findRepositoryFromRequest(w, r, account.Name)
+	if repo == nil {
+		return
+	}
+	parsedDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	manifest, err := keppel.FindManifest(a.db, *repo, parsedDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	// grouping by layer is the only supported mode for now, but the parameter
+	// is explicit to leave room for other groupings (e.g. by package) later
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = "layer"
+	}
+	if groupBy != "layer" {
+		http.Error(w, fmt.Sprintf("group_by=%s not supported", html.EscapeString(groupBy)), http.StatusBadRequest)
+		return
+	}
+
+	payload, err := a.getTrivyReport(r.Context(), *account, *repo, *manifest, "json")
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errNoTrivyReport) {
+		http.Error(w, "no vulnerability report found", http.StatusMethodNotAllowed)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	var report trivy.Report
+	err = json.Unmarshal(payload.Contents, &report)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	manifestBytes, err := a.readManifestContents(r.Context(), account.Reduced(), *repo, manifest.Digest)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	parsedManifest, _, err := keppel.ParseManifest(manifest.MediaType, manifestBytes)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	policies, err := keppel.GetSecurityScanPolicies(*account, *repo)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	layers, unattributed, err := groupVulnerabilitiesByLayer(report, parsedManifest.FindImageLayerBlobs(), policies)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	result := map[string]any{"layers": layers}
+	if len(unattributed) > 0 {
+		result["unattributed_vulnerabilities"] = unattributed
+	}
+	respondwith.JSON(w, http.StatusOK, result)
+}
+
+// Sorts the vulnerabilities from the given report into the layers that
+// introduced them, in the order in which the layers appear in the image.
+// Vulnerabilities that Trivy could not attribute to any layer are returned
+// separately.
+func groupVulnerabilitiesByLayer(report trivy.Report, layerBlobs []distribution.Descriptor, policies keppel.SecurityScanPolicySet) (layers []LayerVulnerabilities, unattributed []Vulnerability, err error) {
+	// Trivy identifies layers by their diff ID (the digest of the uncompressed
+	// layer), but users know them by the digest of the blob in the manifest;
+	// the i-th diff ID in the image config belongs to the i-th layer blob
+	layers = make([]LayerVulnerabilities, len(report.Metadata.DiffIDs))
+	layerIdxByDiffID := make(map[string]int, len(report.Metadata.DiffIDs))
+	for idx, diffID := range report.Metadata.DiffIDs {
+		layers[idx] = LayerVulnerabilities{
+			DiffID:              diffID,
+			VulnerabilityStatus: models.CleanSeverity,
+			Vulnerabilities:     []Vulnerability{},
+		}
+		if len(layerBlobs) == len(report.Metadata.DiffIDs) {
+			layers[idx].Digest = layerBlobs[idx].Digest.String()
+		}
+		layerIdxByDiffID[diffID] = idx
+	}
+
+	for _, result := range report.Results {
+		for _, vuln := range result.Vulnerabilities {
+			severity, ok := trivy.MapToTrivySeverity[vuln.Severity]
+			if !ok {
+				return nil, nil, fmt.Errorf("vulnerability severity with name %s returned from trivy is unknown and cannot be mapped", vuln.Severity)
+			}
+			v := Vulnerability{
+				ID:               vuln.VulnerabilityID,
+				PackageName:      vuln.PkgName,
+				InstalledVersion: vuln.InstalledVersion,
+				FixedVersion:     vuln.FixedVersion,
+				Severity:         severity,
+			}
+			policy := policies.PolicyForVulnerability(vuln)
+			if policy != nil {
+				v.Severity = policy.VulnerabilityStatus()
+				v.ReportedSeverity = severity
+			}
+
+			idx, ok := layerIdxByDiffID[vuln.Layer.DiffID]
+			if !ok {
+				unattributed = append(unattributed, v)
+				continue
+			}
+			layer := &layers[idx]
+			if layer.Digest == "" {
+				layer.Digest = vuln.Layer.Digest
+			}
+			layer.Vulnerabilities = append(layer.Vulnerabilities, v)
+			layer.VulnerabilityStatus = models.MergeVulnerabilityStatuses(layer.VulnerabilityStatus, v.Severity)
+		}
+	}
+	return layers, unattributed, nil
+}
@@ -0,0 +1,158 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppelv1_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/must"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGetManifestVulnerabilities(t *testing.T) {
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		s := test.NewSetup(t,
+			test.WithKeppelAPI,
+			test.WithTrivyDouble,
+			test.WithAccount(models.Account{Name: "test1", AuthTenantID: "tenant1"}),
+			test.WithRepo(models.Repository{Name: "foo", AccountName: "test1"}),
+		)
+		h := s.Handler
+		repo := s.Repos[0]
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1), test.GenerateExampleLayer(2), test.GenerateExampleLayer(3))
+		image.MustUpload(t, s, *repo, "latest")
+		path := fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_manifests/%s/vulnerabilities", image.Manifest.Digest)
+		header := map[string]string{"X-Test-Perms": "view:tenant1,pull:tenant1"}
+
+		// check error cases
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         fmt.Sprintf("/keppel/v1/accounts/test1/repositories/foo/_manifests/%s/vulnerabilities", test.DeterministicDummyDigest(1)),
+			Header:       header,
+			ExpectStatus: http.StatusNotFound,
+			ExpectBody:   assert.StringData("not found\n"),
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path + "?group_by=package",
+			Header:       header,
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   assert.StringData("group_by=package not supported\n"),
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path + "?group_by=layer",
+			Header:       header,
+			ExpectStatus: http.StatusMethodNotAllowed, // vulnerability status is still "Pending"
+			ExpectBody:   assert.StringData("no vulnerability report found\n"),
+		}.Check(t, h)
+
+		// once the image has been scanned, vulnerabilities are attributed to the
+		// layers that introduced them (those that cannot be attributed to any
+		// layer are reported separately)
+		mustExec(t, s.DB, `UPDATE trivy_security_info SET vuln_status = $1`, models.CriticalSeverity)
+		imageRef, _, err := models.ParseImageReference("registry.example.org/test1/foo@" + image.Manifest.Digest.String())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		s.TrivyDouble.ReportFixtures[imageRef] = "fixtures/trivy-report-layers.json"
+
+		policyJSON := must.Return(json.Marshal([]keppel.SecurityScanPolicy{{
+			RepositoryRx:      ".*",
+			VulnerabilityIDRx: "CVE-2019-8457",
+			Action: keppel.SecurityScanPolicyAction{
+				Assessment: "we accept the risk",
+				Severity:   models.LowSeverity,
+			},
+		}}))
+		mustExec(t, s.DB, `UPDATE accounts SET security_scan_policies_json = $1`, string(policyJSON))
+
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path + "?group_by=layer",
+			Header:       header,
+			ExpectStatus: http.StatusOK,
+			ExpectBody: assert.JSONObject{
+				"layers": []assert.JSONObject{
+					{
+						"digest":               image.Layers[0].Digest,
+						"diff_id":              "sha256:3af14c9a24c941c626553628cf1942dcd94d40729777f2fcfbcd3b8a3dfccdd6",
+						"vulnerability_status": models.LowSeverity,
+						"vulnerabilities": []assert.JSONObject{
+							{
+								"id":                "CVE-2011-3374",
+								"package":           "apt",
+								"installed_version": "2.2.4",
+								"severity":          models.LowSeverity,
+							},
+							{
+								"id":                "CVE-2019-8457",
+								"package":           "libdb5.3",
+								"installed_version": "5.3.28+dfsg1-0.8",
+								"severity":          models.LowSeverity,
+								"reported_severity": models.CriticalSeverity,
+							},
+						},
+					},
+					{
+						"digest":               image.Layers[1].Digest,
+						"diff_id":              "sha256:219c6c2423f19da93c40808cd3f8d84d65059c0b4ae26746e66c5352b5a25282",
+						"vulnerability_status": models.CleanSeverity,
+						"vulnerabilities":      []assert.JSONObject{},
+					},
+					{
+						"digest":               image.Layers[2].Digest,
+						"diff_id":              "sha256:16af495390990b358cfa28e324a7bf43b7b23ed05c6d343731a37e387851d9f0",
+						"vulnerability_status": models.HighSeverity,
+						"vulnerabilities": []assert.JSONObject{{
+							"id":                "CVE-2022-29458",
+							"package":           "libncursesw6",
+							"installed_version": "6.2+20201114-2",
+							"fixed_version":     "6.2+20201114-2+deb11u1",
+							"severity":          models.HighSeverity,
+						}},
+					},
+				},
+				"unattributed_vulnerabilities": []assert.JSONObject{{
+					"id":                "CVE-2024-6345",
+					"package":           "setuptools",
+					"installed_version": "65.5.1",
+					"fixed_version":     "70.0.0",
+					"severity":          models.HighSeverity,
+				}},
+			},
+		}.Check(t, h)
+
+		// grouping by layer is the default
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path,
+			Header:       header,
+			ExpectStatus: http.StatusOK,
+		}.Check(t, h)
+	})
+}
@@ -23,6 +23,8 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
 
 	"github.com/sapcc/keppel/internal/auth"
 	"github.com/sapcc/keppel/internal/keppel"
@@ -34,12 +36,13 @@ import (
 type API struct {
 	cfg keppel.Configuration
 	ad  keppel.AuthDriver
+	sd  keppel.StorageDriver
 	db  *keppel.DB
 }
 
 // NewAPI constructs a new API instance.
-func NewAPI(cfg keppel.Configuration, ad keppel.AuthDriver, db *keppel.DB) *API {
-	return &API{cfg, ad, db}
+func NewAPI(cfg keppel.Configuration, ad keppel.AuthDriver, sd keppel.StorageDriver, db *keppel.DB) *API {
+	return &API{cfg, ad, sd, db}
 }
 
 // AddTo implements the api.API interface.
@@ -48,8 +51,20 @@ func (a *API) AddTo(r *mux.Router) {
 	// subset of endpoints, the end of the path reflects the request that we make
 	// to upstream, so there is an additional /v2/ in there in reference to the
 	// Registry V2 API.
+	r.Methods("GET").Path("/peer/v1/version").HandlerFunc(a.handleGetVersion)
 	r.Methods("GET").Path("/peer/v1/delegatedpull/{hostname}/v2/{repo:.+}/manifests/{reference}").HandlerFunc(a.handleDelegatedPullManifest)
 	r.Methods("POST").Path("/peer/v1/sync-replica/{account}/{repo:.+}").HandlerFunc(a.handleSyncReplica)
+	r.Methods("GET").Path("/peer/v1/blob-location/{account}/{digest}").HandlerFunc(a.handleGetBlobLocation)
+}
+
+// Implementation for the GET /peer/v1/version endpoint.
+func (a *API) handleGetVersion(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/peer/v1/version")
+	peer := a.authenticateRequest(w, r)
+	if peer == nil {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, keppel.OurPeerAPIInfo())
 }
 
 func (a *API) authenticateRequest(w http.ResponseWriter, r *http.Request) *models.Peer {
@@ -75,5 +90,11 @@ func (a *API) authenticateRequest(w http.ResponseWriter, r *http.Request) *model
 		return nil
 	}
 
+	err = a.verifyClientCertificate(r, peer)
+	if err != nil {
+		keppel.ErrUnauthorized.With(err.Error()).WriteAsTextTo(w)
+		return nil
+	}
+
 	return &peer
 }
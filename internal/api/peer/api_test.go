@@ -20,12 +20,30 @@
 package peerv1_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sapcc/go-bits/assert"
 	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/httpapi"
 
+	peerv1 "github.com/sapcc/keppel/internal/api/peer"
+	"github.com/sapcc/keppel/internal/auth"
+	peerclient "github.com/sapcc/keppel/internal/client/peer"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
 	"github.com/sapcc/keppel/internal/test"
 )
 
@@ -53,3 +71,197 @@ func TestAlternativeAuthSchemes(t *testing.T) {
 	// bearer token auth and Keppel API auth do not even allow obtaining a token
 	// for the auth.PeerAPIScope.
 }
+
+func TestVersionNegotiation(t *testing.T) {
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		s1 := test.NewSetup(t, test.WithPeerAPI)
+		s2 := test.NewSetup(t, test.IsSecondaryTo(&s1))
+
+		var peer models.Peer
+		err := s2.DB.SelectOne(&peer, `SELECT * FROM peers WHERE hostname = $1`, s1.Config.APIPublicHostname)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		client, err := peerclient.New(s2.Ctx, s2.Config, peer, auth.PeerAPIScope)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// a current peer advertises its version and capabilities
+		info, err := client.GetPeerAPIInfo(s2.Ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "PeerAPIInfo", info, keppel.OurPeerAPIInfo())
+
+		// a peer that predates version negotiation answers with 404, which shall be
+		// interpreted as support for the legacy feature set
+		tt.Handlers[s1.Config.APIPublicHostname] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/peer/v1/version" {
+				http.NotFound(w, r)
+			} else {
+				s1.Handler.ServeHTTP(w, r)
+			}
+		})
+		info, err = client.GetPeerAPIInfo(s2.Ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "PeerAPIInfo", info, keppel.LegacyPeerAPIInfo())
+		assert.DeepEqual(t, "HasCapability", info.HasCapability(keppel.PeerCapabilitySyncReplica), true)
+		assert.DeepEqual(t, "HasCapability", info.HasCapability(keppel.PeerCapability("unknown")), false)
+	})
+}
+
+func TestCapabilityCaching(t *testing.T) {
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		s1 := test.NewSetup(t, test.WithPeerAPI)
+		s2 := test.NewSetup(t, test.IsSecondaryTo(&s1))
+
+		versionRequestCount := 0
+		versionEndpointBroken := false
+		tt.Handlers[s1.Config.APIPublicHostname] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/peer/v1/version" {
+				versionRequestCount++
+				if versionEndpointBroken {
+					http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+					return
+				}
+			}
+			s1.Handler.ServeHTTP(w, r)
+		})
+
+		var peer models.Peer
+		must(t, s2.DB.SelectOne(&peer, `SELECT * FROM peers WHERE hostname = $1`, s1.Config.APIPublicHostname))
+		client, err := peerclient.New(s2.Ctx, s2.Config, peer, auth.PeerAPIScope)
+		must(t, err)
+
+		// repeated capability checks do not ask the peer for its version every time
+		// (the version might already be cached from an earlier test run in this process)
+		for range 3 {
+			ok, err := client.HasCapability(s2.Ctx, keppel.PeerCapabilitySyncReplica)
+			must(t, err)
+			assert.DeepEqual(t, "HasCapability", ok, true)
+		}
+		if versionRequestCount > 1 {
+			t.Errorf("expected at most 1 request to GET /peer/v1/version, but got %d", versionRequestCount)
+		}
+
+		// when the peer is known, failures of its version endpoint do not break the capability check
+		versionEndpointBroken = true
+		ok, err := client.HasCapability(s2.Ctx, keppel.PeerCapabilitySyncReplica)
+		must(t, err)
+		assert.DeepEqual(t, "HasCapability", ok, true)
+	})
+}
+
+func TestClientCertificateVerification(t *testing.T) {
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		s1 := test.NewSetup(t, test.WithPeerAPI)
+		s2 := test.NewSetup(t, test.IsSecondaryTo(&s1))
+
+		// require a client certificate from the secondary, as forwarded by a TLS-terminating reverse proxy
+		ca := newTestCA(t)
+		caPath := filepath.Join(t.TempDir(), "ca.pem")
+		must(t, os.WriteFile(caPath, ca.certPEM, 0o600))
+		_, err := s1.DB.Exec(`UPDATE peers SET client_ca_path = $1 WHERE hostname = $2`, caPath, s2.Config.APIPublicHostname)
+		must(t, err)
+
+		cfg := s1.Config
+		cfg.PeerClientCertHeader = "X-Client-Cert"
+		peerAPIHandler := httpapi.Compose(peerv1.NewAPI(cfg, s1.AD, s1.SD, s1.DB))
+		var clientCertHeader string
+		tt.Handlers[s1.Config.APIPublicHostname] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/peer/") {
+				s1.Handler.ServeHTTP(w, r)
+				return
+			}
+			if clientCertHeader != "" {
+				r.Header.Set("X-Client-Cert", clientCertHeader)
+			}
+			peerAPIHandler.ServeHTTP(w, r)
+		})
+
+		var peer models.Peer
+		must(t, s2.DB.SelectOne(&peer, `SELECT * FROM peers WHERE hostname = $1`, s1.Config.APIPublicHostname))
+		client, err := peerclient.New(s2.Ctx, s2.Config, peer, auth.PeerAPIScope)
+		must(t, err)
+
+		// without a client certificate, the request is rejected
+		_, err = client.GetPeerAPIInfo(s2.Ctx)
+		if err == nil {
+			t.Error("expected GetPeerAPIInfo to fail without client certificate, but it succeeded")
+		}
+
+		// a client certificate for a different hostname is rejected
+		clientCertHeader = url.QueryEscape(string(ca.issueClientCertificate(t, "registry-other.example.org")))
+		_, err = client.GetPeerAPIInfo(s2.Ctx)
+		if err == nil {
+			t.Error("expected GetPeerAPIInfo to fail with client certificate for wrong hostname, but it succeeded")
+		}
+
+		// a client certificate from a different CA is rejected
+		clientCertHeader = url.QueryEscape(string(newTestCA(t).issueClientCertificate(t, s2.Config.APIPublicHostname)))
+		_, err = client.GetPeerAPIInfo(s2.Ctx)
+		if err == nil {
+			t.Error("expected GetPeerAPIInfo to fail with client certificate from wrong CA, but it succeeded")
+		}
+
+		// a matching client certificate is accepted
+		clientCertHeader = url.QueryEscape(string(ca.issueClientCertificate(t, s2.Config.APIPublicHostname)))
+		info, err := client.GetPeerAPIInfo(s2.Ctx)
+		must(t, err)
+		assert.DeepEqual(t, "PeerAPIInfo", info, keppel.OurPeerAPIInfo())
+	})
+}
+
+type testCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	must(t, err)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	must(t, err)
+	cert, err := x509.ParseCertificate(certDER)
+	must(t, err)
+	return testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})}
+}
+
+func (ca testCA) issueClientCertificate(t *testing.T, hostname string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	must(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, &key.PublicKey, ca.key)
+	must(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
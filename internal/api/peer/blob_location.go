@@ -0,0 +1,82 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package peerv1
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// Implementation for the GET /peer/v1/blob-location/:account/:digest endpoint.
+func (a *API) handleGetBlobLocation(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/peer/v1/blob-location/:account/:digest")
+	peer := a.authenticateRequest(w, r)
+	if peer == nil {
+		return
+	}
+
+	bcsd, ok := keppel.AsBlobCopyingStorageDriver(a.sd)
+	if !ok {
+		http.Error(w, "storage driver does not support copying blobs", http.StatusNotImplemented)
+		return
+	}
+
+	// find account
+	accountName := models.AccountName(mux.Vars(r)["account"])
+	account, err := keppel.FindAccount(a.db, accountName)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	if account == nil {
+		http.Error(w, "account not found", http.StatusNotFound)
+		return
+	}
+
+	// find blob (if it has not been replicated into this account yet, the peer
+	// needs to get it from somewhere else)
+	blobDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		http.Error(w, "invalid digest: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	blob, err := keppel.FindBlobByAccountName(a.db, blobDigest, accountName)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && blob.StorageID == "") {
+		http.Error(w, "blob not found", http.StatusNotFound)
+		return
+	}
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	location, err := bcsd.BlobLocation(r.Context(), a.cfg.BlobStorageLocation(account.Reduced(), *blob), blob.StorageID)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	respondwith.JSON(w, http.StatusOK, map[string]string{"location": location})
+}
@@ -0,0 +1,96 @@
+/*******************************************************************************
+*
+* Copyright 2026 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package peerv1
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// If the peer has a client CA configured, verifyClientCertificate checks that
+// the request was made with a client certificate that was issued by this CA
+// for the peer's hostname.
+//
+// The client certificate is taken from the TLS connection if there is one.
+// Otherwise, it is taken from the request header configured in
+// cfg.PeerClientCertHeader, which must be set by a TLS-terminating reverse
+// proxy (e.g. nginx's $ssl_client_escaped_cert) in front of us.
+func (a *API) verifyClientCertificate(r *http.Request, peer models.Peer) error {
+	if peer.ClientCAPath == "" {
+		return nil
+	}
+
+	cert, err := a.getClientCertificate(r)
+	if err != nil {
+		return err
+	}
+
+	caBundle, err := os.ReadFile(peer.ClientCAPath)
+	if err != nil {
+		return fmt.Errorf("cannot read client CA for peer %s: %w", peer.HostName, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return fmt.Errorf("client CA for peer %s does not contain any PEM-encoded certificates", peer.HostName)
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid client certificate: %w", err)
+	}
+	err = cert.VerifyHostname(peer.HostName)
+	if err != nil {
+		return fmt.Errorf("invalid client certificate: %w", err)
+	}
+	return nil
+}
+
+func (a *API) getClientCertificate(r *http.Request) (*x509.Certificate, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0], nil
+	}
+	if a.cfg.PeerClientCertHeader == "" {
+		return nil, errors.New("no client certificate presented")
+	}
+	hdr := r.Header.Get(a.cfg.PeerClientCertHeader)
+	if hdr == "" {
+		return nil, errors.New("no client certificate presented")
+	}
+
+	certPEM, err := url.QueryUnescape(hdr)
+	if err != nil {
+		return nil, fmt.Errorf("malformed client certificate header: %w", err)
+	}
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("malformed client certificate header: no PEM-encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/respondwith"
 
+	"github.com/sapcc/keppel/internal/api"
 	"github.com/sapcc/keppel/internal/auth"
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
@@ -45,17 +46,19 @@ type API struct {
 	fd      keppel.FederationDriver
 	sd      keppel.StorageDriver
 	icd     keppel.InboundCacheDriver
+	cdn     keppel.CDNDriver // may be nil
 	db      *keppel.DB
 	auditor audittools.Auditor
-	rle     *keppel.RateLimitEngine // may be nil
+	rle     *keppel.RateLimitEngine   // may be nil
+	uc      *keppel.UploadCoordinator // may be nil
 	// non-pure functions that can be replaced by deterministic doubles for unit tests
 	timeNow           func() time.Time
 	generateStorageID func() string
 }
 
 // NewAPI constructs a new API instance.
-func NewAPI(cfg keppel.Configuration, ad keppel.AuthDriver, fd keppel.FederationDriver, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, db *keppel.DB, auditor audittools.Auditor, rle *keppel.RateLimitEngine) *API {
-	return &API{cfg, ad, fd, sd, icd, db, auditor, rle, time.Now, keppel.GenerateStorageID}
+func NewAPI(cfg keppel.Configuration, ad keppel.AuthDriver, fd keppel.FederationDriver, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, cdn keppel.CDNDriver, db *keppel.DB, auditor audittools.Auditor, rle *keppel.RateLimitEngine, uc *keppel.UploadCoordinator) *API {
+	return &API{cfg, ad, fd, sd, icd, cdn, db, auditor, rle, uc, time.Now, keppel.GenerateStorageID}
 }
 
 // OverrideTimeNow replaces time.Now with a test double.
@@ -112,6 +115,14 @@ func (a *API) AddTo(r *mux.Router) {
 	r.Methods("GET").
 		Path("/v2/{repository:.+}/tags/list").
 		HandlerFunc(a.handleListTags)
+
+	// the Referrers API was added in version 1.1 of the OCI Distribution Spec;
+	// clients fall back to the referrers tag schema when it responds with 404
+	if a.cfg.EnableOCIDistributionSpecV11 {
+		r.Methods("GET").
+			Path("/v2/{repository:.+}/referrers/{digest}").
+			HandlerFunc(a.handleListReferrers)
+	}
 }
 
 func (a *API) processor() *processor.Processor {
@@ -235,7 +246,7 @@ func (a *API) checkAccountAccess(w http.ResponseWriter, r *http.Request, strateg
 
 	// we need to know the account to select the registry instance for this request
 	repoScope := scope.ParseRepositoryScope(authz.Audience)
-	account, err := keppel.FindReducedAccount(a.db, repoScope.AccountName)
+	account, err := keppel.FindReducedAccountCached(a.db, repoScope.AccountName)
 	if respondWithError(w, r, err) {
 		return nil, nil, nil
 	}
@@ -270,6 +281,15 @@ func (a *API) checkAccountAccess(w http.ResponseWriter, r *http.Request, strateg
 		return nil, nil, nil
 	}
 
+	// in read-only mode, only pulls are allowed
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		rerr := api.CheckReadOnlyMode(a.cfg, *account)
+		if rerr != nil {
+			rerr.WriteAsRegistryV2ResponseTo(w, r)
+			return nil, nil, nil
+		}
+	}
+
 	canCreateRepoIfMissing := false
 	canFirstPull := false
 	if strategy == createRepoIfMissing {
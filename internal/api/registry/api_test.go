@@ -159,3 +159,68 @@ func TestKeppelAPIAuth(t *testing.T) {
 		}.Check(t, h)
 	})
 }
+
+func TestAuthRealmOverride(t *testing.T) {
+	opts := []test.SetupOption{
+		test.WithAuthRealmOverride("test1", keppel.AuthRealmOverride{
+			Realm:   "https://auth.example.com/token",
+			Service: "keppel.example.com",
+		}),
+	}
+	testWithPrimary(t, opts, func(s test.Setup) {
+		h := s.Handler
+
+		// challenge for a repository in the overridden account points to the other authority
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       test.AddHeadersForCorrectAuthChallenge(nil),
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Www-Authenticate":    `Bearer realm="https://auth.example.com/token",service="keppel.example.com",scope="repository:test1/foo:pull"`,
+			},
+			ExpectBody: test.ErrorCode(keppel.ErrUnauthorized),
+		}.Check(t, h)
+
+		// same for the domain-remapped API of that account
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/",
+			Header: map[string]string{
+				"X-Forwarded-Host":  "test1.registry.example.org",
+				"X-Forwarded-Proto": "https",
+			},
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Www-Authenticate":    `Bearer realm="https://auth.example.com/token",service="keppel.example.com"`,
+			},
+			ExpectBody: test.ErrorCode(keppel.ErrUnauthorized),
+		}.Check(t, h)
+
+		// challenges that do not refer to the overridden account are unchanged
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test2/foo/manifests/latest",
+			Header:       test.AddHeadersForCorrectAuthChallenge(nil),
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Www-Authenticate":    `Bearer realm="https://registry.example.org/keppel/v1/auth",service="registry.example.org",scope="repository:test2/foo:pull"`,
+			},
+			ExpectBody: test.ErrorCode(keppel.ErrUnauthorized),
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/_catalog",
+			Header:       test.AddHeadersForCorrectAuthChallenge(nil),
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Www-Authenticate":    `Bearer realm="https://registry.example.org/keppel/v1/auth",service="registry.example.org",scope="registry:catalog:*"`,
+			},
+			ExpectBody: test.ErrorCode(keppel.ErrUnauthorized),
+		}.Check(t, h)
+	})
+}
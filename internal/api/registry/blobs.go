@@ -19,20 +19,25 @@
 package registryv2
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/opencontainers/go-digest"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
 
 	"github.com/sapcc/keppel/internal/api"
 	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
 	"github.com/sapcc/keppel/internal/processor"
 )
 
@@ -41,6 +46,16 @@ var isImageConfigBlobMediaType = map[string]bool{
 	"application/vnd.oci.image.config.v1+json":       true,
 }
 
+var countEgressBytesQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO egress_counters (auth_tenant_id, bytes) VALUES ($1, $2)
+	ON CONFLICT (auth_tenant_id) DO UPDATE SET bytes = egress_counters.bytes + EXCLUDED.bytes
+`)
+
+var countAccountEgressBytesQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO account_egress_counters (account_name, bytes) VALUES ($1, $2)
+	ON CONFLICT (account_name) DO UPDATE SET bytes = account_egress_counters.bytes + EXCLUDED.bytes
+`)
+
 // This implements the GET/HEAD /v2/<account>/<repository>/blobs/<digest> endpoint.
 func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 	httpapi.IdentifyEndpoint(r, "/v2/:account/:repo/blobs/:digest")
@@ -59,9 +74,13 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 		keppel.ErrDigestInvalid.With(err.Error()).WriteAsRegistryV2ResponseTo(w, r)
 		return
 	}
+	err = keppel.CheckDigestNotBlocked(a.db, account.Name, blobDigest)
+	if respondWithError(w, r, err) {
+		return
+	}
 
 	// locate this blob from the DB
-	blob, err := keppel.FindBlobByRepository(a.db, blobDigest, *repo)
+	blob, err := keppel.FindBlobByRepository(a.db.WithContext(r.Context()), blobDigest, *repo)
 	if errors.Is(err, sql.ErrNoRows) {
 		keppel.ErrBlobUnknown.With("blob does not exist in this repository").WriteAsRegistryV2ResponseTo(w, r)
 		return
@@ -87,7 +106,13 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// ...and answer GET requests by replicating the blob contents
+		// ...and answer GET requests by replicating the blob contents (unless
+		// we cannot write into the storage right now)
+		rerr := api.CheckReadOnlyMode(a.cfg, *account)
+		if rerr != nil {
+			rerr.WriteAsRegistryV2ResponseTo(w, r)
+			return
+		}
 		responseWasWritten, err := a.processor().ReplicateBlob(r.Context(), *blob, *account, *repo, w)
 
 		if err != nil {
@@ -113,13 +138,26 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// if the client only asks for part of the blob (e.g. to resume an interrupted
+	// download), only that part counts towards rate limits and metrics
+	rangeOffset, rangeLength, isRangeRequest, isSatisfiable := parseRangeHeader(r.Header.Get("Range"), blob.SizeBytes)
+	pulledBytes := blob.SizeBytes
+	if isRangeRequest {
+		if !isSatisfiable {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", blob.SizeBytes))
+			keppel.ErrSizeInvalid.With("requested range is not satisfiable").WithStatus(http.StatusRequestedRangeNotSatisfiable).WriteAsRegistryV2ResponseTo(w, r)
+			return
+		}
+		pulledBytes = rangeLength
+	}
+
 	// if a peer reverse-proxied to us to fulfill an anycast request, enforce the anycast rate limits
 	isAnycast := r.Header.Get("X-Keppel-Forwarded-By") != ""
 	if isAnycast {
 		// AnycastBlobBytePullAction is only relevant for GET requests since it
 		// limits the size of the response body (which is empty for HEAD)
 		if r.Method == http.MethodGet {
-			err = api.CheckRateLimit(r, a.rle, *account, authz, keppel.AnycastBlobBytePullAction, blob.SizeBytes)
+			err = api.CheckRateLimit(r, a.rle, *account, authz, keppel.AnycastBlobBytePullAction, pulledBytes)
 			if respondWithError(w, r, err) {
 				return
 			}
@@ -135,10 +173,30 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 			l["method"] = "registry-api+anycast"
 		}
 		api.BlobsPulledCounter.With(l).Inc()
-		api.BlobBytesPulledCounter.With(l).Add(float64(blob.SizeBytes))
+		api.BlobBytesPulledCounter.With(l).Add(float64(pulledBytes))
+
+		// update egress_counters if required for tasks.UsageReportJob (replication
+		// traffic between peers is not billed to the tenant)
+		if a.cfg.UsageReporter != nil && authz.UserIdentity.UserType() != keppel.PeerUser {
+			_, err := a.db.Exec(countEgressBytesQuery, account.AuthTenantID, pulledBytes)
+			if err != nil {
+				logg.Error("could not update egress_counters for auth tenant %s: %s", account.AuthTenantID, err.Error())
+			}
+		}
+
+		// update account_egress_counters if required for tasks.AccountMetricsJob
+		// (unlike above, this includes replication traffic since it also incurs
+		// egress cost for us)
+		if a.cfg.AccountMetricsEnabled {
+			_, err := a.db.Exec(countAccountEgressBytesQuery, account.Name, pulledBytes)
+			if err != nil {
+				logg.Error("could not update account_egress_counters for account %s: %s", account.Name, err.Error())
+			}
+		}
 	}
 
-	// prefer redirecting the client to a storage URL if the storage driver can give us one
+	// prefer redirecting the client to a CDN URL or a storage URL if the
+	// respective driver can give us one
 	//
 	// We do not do this for image config blobs. Those are rather small, so the
 	// optimization of redirecting rather than reverse-proxying is not as relevant
@@ -146,7 +204,7 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 	// CORS happens correctly. This is important for web UIs reading image config
 	// blobs in order to render informational UIs.
 	if !isImageConfigBlobMediaType[blob.MediaType] {
-		url, err := a.sd.URLForBlob(r.Context(), *account, blob.StorageID)
+		url, err := a.urlForBlob(r.Context(), *account, *blob)
 		if err == nil {
 			w.Header().Set("Docker-Content-Digest", blob.Digest.String())
 			w.Header().Set("Location", url)
@@ -159,16 +217,32 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// return the blob contents to the client directly (TODO: support range requests)
-	reader, lengthBytes, err := a.sd.ReadBlob(r.Context(), *account, blob.StorageID)
+	// return the blob contents to the client directly
+	var (
+		reader      io.ReadCloser
+		lengthBytes uint64
+	)
+	location := a.cfg.BlobStorageLocation(*account, *blob)
+	if isRangeRequest {
+		reader, err = a.sd.ReadBlobRange(r.Context(), location, blob.StorageID, rangeOffset, rangeLength)
+		lengthBytes = rangeLength
+	} else {
+		reader, lengthBytes, err = a.sd.ReadBlob(r.Context(), location, blob.StorageID)
+	}
 	if respondWithError(w, r, err) {
 		return
 	}
 	defer reader.Close()
+	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("Content-Length", strconv.FormatUint(lengthBytes, 10))
 	w.Header().Set("Content-Type", blob.SafeMediaType())
 	w.Header().Set("Docker-Content-Digest", blob.Digest.String())
-	w.WriteHeader(http.StatusOK)
+	if isRangeRequest {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rangeOffset, rangeOffset+rangeLength-1, blob.SizeBytes))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
 	if r.Method != http.MethodHead {
 		// The use of io.LimitReader() here is a hint to io.Copy() to not allocate
 		// a buffer bigger than the expected size of the blob if the blob is small.
@@ -179,6 +253,62 @@ func (a *API) handleGetOrHeadBlob(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Interprets the "Range" header of a blob GET request for a blob of the given
+// size. Returns isRangeRequest = false if the header is empty, if it cannot be
+// parsed, or if it requests multiple ranges. In all these cases, the header
+// shall be ignored and the full blob shall be served (as allowed by RFC 9110,
+// section 14.2). Otherwise, the requested range is returned as offset and
+// length, unless it cannot be satisfied for this blob.
+func parseRangeHeader(hdr string, sizeBytes uint64) (offset, length uint64, isRangeRequest, isSatisfiable bool) {
+	spec, ok := strings.CutPrefix(hdr, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false, false
+	}
+	firstStr, lastStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok || (firstStr == "" && lastStr == "") {
+		return 0, 0, false, false
+	}
+
+	if firstStr == "" {
+		// suffix range like "bytes=-500": the last N bytes (or the entire blob, if it is smaller than that)
+		suffixLength, err := strconv.ParseUint(lastStr, 10, 64)
+		if err != nil {
+			return 0, 0, false, false
+		}
+		suffixLength = min(suffixLength, sizeBytes)
+		return sizeBytes - suffixLength, suffixLength, true, suffixLength > 0
+	}
+
+	first, err := strconv.ParseUint(firstStr, 10, 64)
+	if err != nil {
+		return 0, 0, false, false
+	}
+	last := sizeBytes - 1
+	if lastStr != "" {
+		// range like "bytes=500-999" (a range like "bytes=500-" extends until the end of the blob)
+		last, err = strconv.ParseUint(lastStr, 10, 64)
+		if err != nil || last < first {
+			return 0, 0, false, false
+		}
+		last = min(last, sizeBytes-1)
+	}
+	if first >= sizeBytes {
+		return 0, 0, true, false
+	}
+	return first, last - first + 1, true, true
+}
+
+func (a *API) urlForBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob) (string, error) {
+	location := a.cfg.BlobStorageLocation(account, blob)
+	if a.cdn != nil {
+		url, err := a.cdn.URLForBlob(ctx, location, blob, a.timeNow())
+		if !errors.Is(err, keppel.ErrCannotGenerateURL) {
+			return url, err
+		}
+	}
+	return a.sd.URLForBlob(ctx, location, blob.StorageID)
+}
+
 func (a *API) handleGetOrHeadBlobAnycast(w http.ResponseWriter, r *http.Request, info anycastRequestInfo) {
 	//NOTE: Rate limits are enforced by the peer that we reverse-proxy to, not by
 	// us. We couldn't enforce them anyway because we don't have this account.
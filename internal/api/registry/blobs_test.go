@@ -25,6 +25,7 @@ import (
 	"net/url"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/sapcc/go-bits/assert"
 
@@ -480,9 +481,42 @@ func TestBlobStreamedAndChunkedUpload(t *testing.T) {
 				}
 			}
 
+			// test failure cases during PATCH: chunk digest is malformed or does not match the chunk contents
+			for _, wrongDigest := range []string{"wrong", test.DeterministicDummyDigest(2).String()} {
+				assert.HTTPRequest{
+					Method:       "PATCH",
+					Path:         keppel.AppendQuery(getBlobUploadURL(t, h, token, "test1/foo"), url.Values{"digest": {wrongDigest}}),
+					Header:       getHeadersForPATCH(0, len(blob.Contents)),
+					Body:         assert.ByteData(blob.Contents),
+					ExpectStatus: http.StatusBadRequest,
+					ExpectHeader: test.VersionHeader,
+					ExpectBody:   test.ErrorCode(keppel.ErrDigestInvalid),
+				}.Check(t, h)
+			}
+
 			// failed requests should not retain anything in the storage
 			expectStorageEmpty(t, s.SD, s.DB)
 
+			// test success case with chunk digest
+			resp, _ := assert.HTTPRequest{
+				Method:       "PATCH",
+				Path:         keppel.AppendQuery(getBlobUploadURL(t, h, token, "test1/foo"), url.Values{"digest": {blob.Digest.String()}}),
+				Header:       getHeadersForPATCH(0, len(blob.Contents)),
+				Body:         assert.ByteData(blob.Contents),
+				ExpectStatus: http.StatusAccepted,
+				ExpectHeader: map[string]string{
+					test.VersionHeaderKey: test.VersionHeaderValue,
+					"Range":               fmt.Sprintf("0-%d", len(blob.Contents)-1),
+				},
+			}.Check(t, h)
+			assert.HTTPRequest{
+				Method:       "PUT",
+				Path:         keppel.AppendQuery(resp.Header.Get("Location"), url.Values{"digest": {blob.Digest.String()}}),
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusCreated,
+			}.Check(t, h)
+			expectBlobExists(t, h, token, "test1/foo", blob, nil)
+
 			// test success case twice: should look the same also in the second pass
 			for range []int{1, 2} {
 				// test success case (with multiple chunks!)
@@ -667,6 +701,65 @@ func TestGetBlobUpload(t *testing.T) {
 	})
 }
 
+func TestConcurrentBlobUploadRequests(t *testing.T) {
+	testWithPrimary(t, []test.SetupOption{test.WithUploadCoordinator}, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+		blob := test.NewBytes([]byte("just some random data"))
+
+		// simulate a different keppel-api instance working on this upload
+		uploadURL, uploadUUID := getBlobUpload(t, h, token, "test1/foo")
+		release, err := s.UploadCoordinator.LockUpload(s.Ctx, uploadUUID)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// requests that modify the upload are rejected while the lock is held...
+		for _, method := range []string{"PATCH", "PUT", "DELETE"} {
+			assert.HTTPRequest{
+				Method: method,
+				Path:   uploadURL + "?digest=" + blob.Digest.String(),
+				Header: map[string]string{
+					"Authorization": "Bearer " + token,
+					"Content-Type":  "application/octet-stream",
+				},
+				Body:         assert.ByteData(blob.Contents),
+				ExpectStatus: http.StatusTooManyRequests,
+				ExpectHeader: map[string]string{
+					test.VersionHeaderKey: test.VersionHeaderValue,
+					"Retry-After":         "5",
+				},
+				ExpectBody: test.ErrorCode(keppel.ErrTooManyRequests),
+			}.Check(t, h)
+		}
+
+		// ...but reading the upload status is still allowed
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         uploadURL,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusNoContent,
+		}.Check(t, h)
+
+		// once the lock is released, the upload can continue
+		release()
+		assert.HTTPRequest{
+			Method: "PATCH",
+			Path:   uploadURL,
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  "application/octet-stream",
+			},
+			Body:         assert.ByteData(blob.Contents),
+			ExpectStatus: http.StatusAccepted,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Range":               fmt.Sprintf("0-%d", len(blob.Contents)-1),
+			},
+		}.Check(t, h)
+	})
+}
+
 func TestDeleteBlobUpload(t *testing.T) {
 	testWithPrimary(t, nil, func(s test.Setup) {
 		h := s.Handler
@@ -890,14 +983,14 @@ func TestCrossRepositoryBlobMount(t *testing.T) {
 			ExpectBody:   test.ErrorCode(keppel.ErrNameInvalid),
 		}.Check(t, h)
 
-		// test failure cases: cannot mount across accounts
+		// test failure cases: cannot mount across accounts without pull access to the source repo
 		assert.HTTPRequest{
 			Method:       "POST",
 			Path:         "/v2/test1/foo/blobs/uploads/?from=test2/foo&mount=" + blob.Digest.String(),
 			Header:       map[string]string{"Authorization": "Bearer " + token},
-			ExpectStatus: http.StatusMethodNotAllowed,
+			ExpectStatus: http.StatusUnauthorized,
 			ExpectHeader: test.VersionHeader,
-			ExpectBody:   test.ErrorCode(keppel.ErrUnsupported),
+			ExpectBody:   test.ErrorCode(keppel.ErrDenied),
 		}.Check(t, h)
 
 		// test failure cases: digest is malformed or wrong
@@ -946,3 +1039,166 @@ func TestCrossRepositoryBlobMount(t *testing.T) {
 		expectBlobExists(t, h, otherRepoToken, "test1/bar", blob, nil)
 	})
 }
+
+func TestCrossAccountBlobMount(t *testing.T) {
+	setupOptions := []test.SetupOption{
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "test2authtenant"}),
+	}
+	testWithPrimary(t, setupOptions, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+		crossAccountToken := s.GetToken(t, "repository:test1/foo:pull,push", "repository:test2/bar:pull")
+
+		// upload a blob to test2/bar so that we can test mounting it to test1/foo
+		blob := test.NewBytes([]byte("just some random data"))
+		blob.MustUpload(t, s, models.Repository{AccountName: "test2", Name: "bar"})
+
+		// test failure case: token does not cover the source repo
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/v2/test1/foo/blobs/uploads/?from=test2/bar&mount=" + blob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrDenied),
+		}.Check(t, h)
+
+		// test failure case: blob does not exist in source repo
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/v2/test1/foo/blobs/uploads/?from=test2/bar&mount=" + test.DeterministicDummyDigest(1).String(),
+			Header:       map[string]string{"Authorization": "Bearer " + crossAccountToken},
+			ExpectStatus: http.StatusNotFound,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrBlobUnknown),
+		}.Check(t, h)
+
+		// test success case: blob gets copied into the target account
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/v2/test1/foo/blobs/uploads/?from=test2/bar&mount=" + blob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + crossAccountToken},
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Content-Length":      "0",
+				"Location":            "/v2/test1/foo/blobs/" + blob.Digest.String(),
+			},
+		}.Check(t, h)
+		expectBlobExists(t, h, token, "test1/foo", blob, nil)
+
+		// the blob is now owned by the target account, so it is independent from the source account
+		blobCount, err := s.DB.SelectInt(`SELECT COUNT(*) FROM blobs WHERE digest = $1`, blob.Digest)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "blob count", blobCount, int64(2))
+
+		// mounting again reuses the existing blob in the target account
+		assert.HTTPRequest{
+			Method:       "POST",
+			Path:         "/v2/test1/bar/blobs/uploads/?from=test2/bar&mount=" + blob.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + s.GetToken(t, "repository:test1/bar:pull,push", "repository:test2/bar:pull")},
+			ExpectStatus: http.StatusCreated,
+		}.Check(t, h)
+		blobCount, err = s.DB.SelectInt(`SELECT COUNT(*) FROM blobs WHERE digest = $1`, blob.Digest)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "blob count", blobCount, int64(2))
+	})
+}
+
+func TestGetBlobViaCDN(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		layer := test.GenerateExampleLayer(1)
+		image := test.GenerateImage(layer)
+		image.MustUpload(t, s, fooRepoRef, "latest")
+
+		// as long as the CDN is not enabled for this account, blobs are served directly
+		expectBlobExists(t, h, token, "test1/foo", layer, nil)
+
+		// when the CDN is enabled, GET and HEAD on layers redirect to it
+		s.CDN.EnabledAccounts["test1"] = true
+		expectedURL := fmt.Sprintf("https://cdn.example.org/test1/%s?expires=%d",
+			layer.Digest, s.Clock.Now().Add(10*time.Minute).Unix())
+		for _, method := range []string{"GET", "HEAD"} {
+			assert.HTTPRequest{
+				Method:       method,
+				Path:         "/v2/test1/foo/blobs/" + layer.Digest.String(),
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusTemporaryRedirect,
+				ExpectHeader: map[string]string{
+					test.VersionHeaderKey:   test.VersionHeaderValue,
+					"Docker-Content-Digest": layer.Digest.String(),
+					"Location":              expectedURL,
+				},
+			}.Check(t, h)
+		}
+
+		// image config blobs are still served directly (see comment in handleGetOrHeadBlob)
+		expectBlobExists(t, h, token, "test1/foo", image.Config, nil)
+	})
+}
+
+func TestGetBlobWithRange(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		blob := test.NewBytes([]byte("0123456789"))
+		blob.MustUpload(t, s, fooRepoRef)
+
+		expectRange := func(rangeHeader, contentRange, body string) {
+			t.Helper()
+			assert.HTTPRequest{
+				Method: "GET",
+				Path:   "/v2/test1/foo/blobs/" + blob.Digest.String(),
+				Header: map[string]string{
+					"Authorization": "Bearer " + token,
+					"Range":         rangeHeader,
+				},
+				ExpectStatus: http.StatusPartialContent,
+				ExpectHeader: map[string]string{
+					test.VersionHeaderKey:   test.VersionHeaderValue,
+					"Accept-Ranges":         "bytes",
+					"Content-Length":        strconv.Itoa(len(body)),
+					"Content-Range":         contentRange,
+					"Docker-Content-Digest": blob.Digest.String(),
+				},
+				ExpectBody: assert.ByteData(body),
+			}.Check(t, h)
+		}
+
+		// various forms of single ranges are supported
+		expectRange("bytes=2-5", "bytes 2-5/10", "2345")
+		expectRange("bytes=7-", "bytes 7-9/10", "789")
+		expectRange("bytes=-3", "bytes 7-9/10", "789")
+		expectRange("bytes=8-20", "bytes 8-9/10", "89")
+		expectRange("bytes=-20", "bytes 0-9/10", "0123456789")
+
+		// ranges starting beyond the end of the blob cannot be satisfied
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/test1/foo/blobs/" + blob.Digest.String(),
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Range":         "bytes=10-",
+			},
+			ExpectStatus: http.StatusRequestedRangeNotSatisfiable,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Content-Range":       "bytes */10",
+			},
+			ExpectBody: test.ErrorCode(keppel.ErrSizeInvalid),
+		}.Check(t, h)
+
+		// malformed ranges and multiple ranges are ignored, so the entire blob is served
+		for _, rangeHeader := range []string{"bytes=5-2", "bytes=foo", "items=0-1", "bytes=0-1,4-5"} {
+			expectBlobExists(t, h, token, "test1/foo", blob, map[string]string{"Range": rangeHeader})
+		}
+	})
+}
@@ -29,10 +29,12 @@ import (
 	"strings"
 
 	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/httpext"
 	"github.com/sapcc/go-bits/respondwith"
 	"github.com/sapcc/go-bits/sqlext"
 
 	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 )
 
@@ -98,15 +100,34 @@ func (a *API) handleGetCatalog(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// find accessible accounts
+	// find accessible accounts: users can list all repos in accounts that they
+	// can view, and additionally those repos that RBAC policies allow them to
+	// pull from in other accounts
 	accountNames := authz.ScopeSet.AccountsWithCatalogAccess(markerAccountName)
+	rbacPullPolicies, err := a.getRBACPullPoliciesForCatalog(r, authz, accountNames, markerAccountName)
+	if respondWithError(w, r, err) {
+		return
+	}
+	for accountName := range rbacPullPolicies {
+		accountNames = append(accountNames, accountName)
+	}
 	slices.Sort(accountNames)
+	ip := httpext.GetRequesterIPFor(r)
+	userName := authz.UserIdentity.UserName()
 
 	// collect repository names from backend
 	var allNames []string
 	partialResult := false
 	for idx, accountName := range accountNames {
-		names, err := a.getCatalogForAccount(accountName, includeAccountName)
+		var isVisible func(repoName string) bool
+		if policies, ok := rbacPullPolicies[accountName]; ok {
+			isVisible = func(repoName string) bool {
+				return slices.ContainsFunc(policies, func(p keppel.RBACPolicy) bool {
+					return p.Matches(ip, repoName, userName)
+				})
+			}
+		}
+		names, err := a.getCatalogForAccount(accountName, includeAccountName, isVisible)
 		if respondWithError(w, r, err) {
 			return
 		}
@@ -136,7 +157,7 @@ func (a *API) handleGetCatalog(w http.ResponseWriter, r *http.Request) {
 		linkQuery := url.Values{}
 		linkQuery.Set("n", strconv.FormatUint(limit, 10))
 		linkQuery.Set("last", allNames[len(allNames)-1])
-		linkURL := url.URL{Path: "/v2/_catalog", RawQuery: linkQuery.Encode()}
+		linkURL := url.URL{Path: a.cfg.APIPathPrefix + "/v2/_catalog", RawQuery: linkQuery.Encode()}
 		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, linkURL.String()))
 	}
 	if len(allNames) == 0 {
@@ -149,21 +170,70 @@ func (a *API) handleGetCatalog(w http.ResponseWriter, r *http.Request) {
 
 const catalogGetQuery = `SELECT name FROM repos WHERE account_name = $1 ORDER BY name`
 
-func (a *API) getCatalogForAccount(accountName models.AccountName, includeAccountName bool) ([]string, error) {
+// If `isVisible` is not nil, only repos for which it returns true are listed.
+func (a *API) getCatalogForAccount(accountName models.AccountName, includeAccountName bool, isVisible func(string) bool) ([]string, error) {
 	var result []string
 	err := sqlext.ForeachRow(a.db, catalogGetQuery, []any{accountName},
 		func(rows *sql.Rows) error {
 			var name string
 			err := rows.Scan(&name)
-			if err == nil {
-				if includeAccountName {
-					result = append(result, fmt.Sprintf("%s/%s", accountName, name))
-				} else {
-					result = append(result, name)
-				}
+			if err != nil || (isVisible != nil && !isVisible(name)) {
+				return err
+			}
+			if includeAccountName {
+				result = append(result, fmt.Sprintf("%s/%s", accountName, name))
+			} else {
+				result = append(result, name)
 			}
-			return err
+			return nil
 		},
 	)
 	return result, err
 }
+
+var catalogRBACPoliciesQuery = sqlext.SimplifyWhitespace(`
+	SELECT name, rbac_policies_json FROM accounts
+	 WHERE rbac_policies_json NOT IN ('', '[]') AND name >= $1 AND ($2 = '' OR name = $2)
+`)
+
+// Returns the RBAC policies that grant pull access to the requesting user,
+// grouped by account, for all accounts that are not already fully visible.
+//
+// Policies granting only anonymous pull are ignored, since listing every
+// publicly pullable repo to every user would be surprising to account owners.
+func (a *API) getRBACPullPoliciesForCatalog(r *http.Request, authz *auth.Authorization, visibleAccountNames []models.AccountName, markerAccountName models.AccountName) (map[models.AccountName][]keppel.RBACPolicy, error) {
+	if authz.UserIdentity.UserType() == keppel.AnonymousUser {
+		return nil, nil
+	}
+	ip := httpext.GetRequesterIPFor(r)
+	userName := authz.UserIdentity.UserName()
+
+	var accounts []struct {
+		Name             models.AccountName `db:"name"`
+		RBACPoliciesJSON string             `db:"rbac_policies_json"`
+	}
+	_, err := a.db.ReadReplica().Select(&accounts, catalogRBACPoliciesQuery, markerAccountName, authz.Audience.AccountName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[models.AccountName][]keppel.RBACPolicy)
+	for _, account := range accounts {
+		if slices.Contains(visibleAccountNames, account.Name) {
+			continue
+		}
+		policies, err := keppel.ParseRBACPoliciesField(account.RBACPoliciesJSON)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing RBAC policies of account %q: %w", account.Name, err)
+		}
+		for _, policy := range policies {
+			// the repository pattern is checked later for each individual repo
+			withoutRepoPattern := policy
+			withoutRepoPattern.RepositoryPattern = ""
+			if slices.Contains(policy.Permissions, keppel.GrantsPull) && withoutRepoPattern.Matches(ip, "", userName) {
+				result[account.Name] = append(result[account.Name], policy)
+			}
+		}
+	}
+	return result, nil
+}
@@ -64,6 +64,7 @@ func TestCatalogEndpoint(t *testing.T) {
 	testDomainRemappedCatalog(t, s)
 	testAuthErrorsForCatalog(t, s)
 	testNoCatalogOnAnycast(t, s)
+	testCatalogWithRBACPolicies(t, s)
 }
 
 func testEmptyCatalog(t *testing.T, s test.Setup) {
@@ -286,3 +287,46 @@ func testNoCatalogOnAnycast(t *testing.T, s test.Setup) {
 		ExpectBody:   test.ErrorCode(keppel.ErrUnsupported),
 	}.Check(t, s.Handler)
 }
+
+func testCatalogWithRBACPolicies(t *testing.T, s test.Setup) {
+	h := s.Handler
+	token := s.GetToken(t,
+		"registry:catalog:*",
+		"keppel_account:test1:view",
+	)
+
+	// in test3, the user can pull from "foo" through an RBAC policy; the policy
+	// for anonymous pull on "qux" does not make "qux" show up in the catalog
+	rbacPoliciesJSON := `[{"match_repository":"fo+","match_username":"correct.*","permissions":["pull"]},{"match_repository":"qux","permissions":["anonymous_pull"]}]`
+	_, err := s.DB.Exec(`UPDATE accounts SET rbac_policies_json = $1 WHERE name = $2`, rbacPoliciesJSON, "test3")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// in test2, the RBAC policy does not match the user
+	rbacPoliciesJSON = `[{"match_repository":"fo+","match_username":"someoneelse","permissions":["pull"]}]`
+	_, err = s.DB.Exec(`UPDATE accounts SET rbac_policies_json = $1 WHERE name = $2`, rbacPoliciesJSON, "test2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/_catalog",
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+		ExpectHeader: test.VersionHeader,
+		ExpectBody: assert.JSONObject{"repositories": []string{
+			"test1/bar", "test1/foo", "test1/qux", "test3/foo",
+		}},
+	}.Check(t, h)
+
+	// pagination works across both types of visibility
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/_catalog?n=10&last=test1/qux",
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+		ExpectHeader: test.VersionHeader,
+		ExpectBody:   assert.JSONObject{"repositories": []string{"test3/foo"}},
+	}.Check(t, h)
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/sapcc/go-bits/assert"
 
 	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
 	"github.com/sapcc/keppel/internal/test"
 )
 
@@ -67,6 +68,62 @@ func TestRegistryAPIDomainRemap(t *testing.T) {
 	})
 }
 
+func TestRegistryAPIVanityDomain(t *testing.T) {
+	// test generic Registry API endpoints with request URLs using a vanity domain of an account
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		err := s.DB.Insert(&models.VanityDomain{Hostname: "teamxyz.example.com", AccountName: "test1"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// without token, expect auth challenge
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/",
+			Header: map[string]string{
+				"X-Forwarded-Host":  "teamxyz.example.com",
+				"X-Forwarded-Proto": "https",
+			},
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey: test.VersionHeaderValue,
+				"Www-Authenticate":    `Bearer realm="https://teamxyz.example.com/keppel/v1/auth",service="teamxyz.example.com"`,
+			},
+			ExpectBody: test.ErrorCode(keppel.ErrUnauthorized),
+		}.Check(t, h)
+
+		// with token, expect status code 200
+		token := s.GetVanityDomainToken(t, "test1", "teamxyz.example.com" /*, no scopes */)
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/",
+			Header: map[string]string{
+				"Authorization":     "Bearer " + token,
+				"X-Forwarded-Host":  "teamxyz.example.com",
+				"X-Forwarded-Proto": "https",
+			},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+
+		// a token for the regular domain-remapped API is not valid on the vanity domain
+		token = s.GetDomainRemappedToken(t, "test1" /*, no scopes */)
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/",
+			Header: map[string]string{
+				"Authorization":     "Bearer " + token,
+				"X-Forwarded-Host":  "teamxyz.example.com",
+				"X-Forwarded-Proto": "https",
+			},
+			ExpectStatus: http.StatusUnauthorized,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrUnauthorized),
+		}.Check(t, h)
+	})
+}
+
 func TestBlobAPIDomainRemap(t *testing.T) {
 	// test blob API with request URLs having the account name in the hostname instead of in the path
 	testWithPrimary(t, nil, func(s test.Setup) {
@@ -24,15 +24,18 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-gorp/gorp/v3"
 	"github.com/gorilla/mux"
 	"github.com/opencontainers/go-digest"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/httpapi"
 	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
 	accept "github.com/timewasted/go-accept-headers"
 
 	"github.com/sapcc/keppel/internal/api"
@@ -56,7 +59,55 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	reference := models.ParseManifestReference(mux.Vars(r)["reference"])
-	dbManifest, err := a.findManifestInDB(*repo, reference)
+
+	// if the account requires digest-pinned pulls, only allow resolving tags
+	// into digests with HEAD, but not pulling by tag with GET (other Keppels
+	// replicating from us and Trivy are exempt since they follow our tags)
+	userType := authz.UserIdentity.UserType()
+	if r.Method == http.MethodGet && reference.IsTag() && account.RequiresDigestPulls(repo.Name) && userType != keppel.PeerUser && userType != keppel.TrivyUser {
+		msg := fmt.Sprintf("manifests in this repository may only be pulled by digest; resolve the tag into a digest with HEAD /v2/%s/manifests/%s, then pull by digest",
+			getRepoNameForURLPath(*repo, authz), reference.Tag)
+		keppel.ErrDenied.With(msg).WithStatus(http.StatusForbidden).WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+
+	// references like "latest@{2024-05-01}" pull the manifest that the tag
+	// pointed to at that time, according to the tag history
+	tagName, asOf, isTimeMachinePull, rerr := parseTimeMachineReference(reference)
+	if rerr != nil {
+		rerr.WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+	if isTimeMachinePull {
+		refDigest, err := resolveTagAsOf(a.db.WithContext(r.Context()), *repo, tagName, asOf)
+		if errors.Is(err, sql.ErrNoRows) {
+			msg := fmt.Sprintf("tag %q did not exist at %s", tagName, asOf.UTC().Format(time.RFC3339))
+			keppel.ErrManifestUnknown.With(msg).WithDetail(reference.Tag).WriteAsRegistryV2ResponseTo(w, r)
+			return
+		}
+		if respondWithError(w, r, err) {
+			return
+		}
+		reference = models.ManifestReference{Digest: refDigest}
+	}
+
+	// blocked manifests may not be pulled (when pulling by digest, this is
+	// checked before replication to avoid pulling blocked content from upstream)
+	if reference.IsDigest() {
+		err := keppel.CheckDigestNotBlocked(a.db, account.Name, reference.Digest)
+		if respondWithError(w, r, err) {
+			return
+		}
+	}
+
+	// metadata lookups go to the read replica (if any), but since the replica
+	// may lag behind, a miss is double-checked against the primary before we
+	// report 404 or start replicating from upstream
+	roDB := a.db.ReadReplica()
+	dbManifest, err := a.findManifestInDB(roDB.WithContext(r.Context()), *account, *repo, reference)
+	if errors.Is(err, sql.ErrNoRows) && roDB != gorp.SqlExecutor(a.db) {
+		dbManifest, err = a.findManifestInDB(a.db.WithContext(r.Context()), *account, *repo, reference)
+	}
 	var manifestBytes []byte
 
 	if !errors.Is(err, sql.ErrNoRows) {
@@ -70,7 +121,6 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 		// from upstream (as an exception, other Keppels replicating from us always
 		// see the true 404 to properly replicate the non-existence of the manifest
 		// from this account into the replica account)
-		userType := authz.UserIdentity.UserType()
 		if (account.UpstreamPeerHostName != "" || account.ExternalPeerURL != "") && !account.IsDeleting && (userType != keppel.PeerUser && userType != keppel.TrivyUser) {
 			// when replicating from external, only authenticated users can trigger the replication
 			if account.ExternalPeerURL != "" && userType != keppel.RegularUser {
@@ -84,7 +134,14 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 
-			dbManifest, manifestBytes, err = a.processor().ReplicateManifest(r.Context(), *account, *repo, reference, keppel.AuditContext{
+			// replication writes into the storage, so it is not possible in read-only mode
+			rerr := api.CheckReadOnlyMode(a.cfg, *account)
+			if rerr != nil {
+				rerr.WriteAsRegistryV2ResponseTo(w, r)
+				return
+			}
+
+			dbManifest, manifestBytes, err = a.processor().ReplicateManifestOnFirstPull(r.Context(), *account, *repo, reference, keppel.AuditContext{
 				UserIdentity: authz.UserIdentity,
 				Request:      r,
 			})
@@ -96,6 +153,52 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
+		// in proxy cache accounts, tags are revalidated against upstream on every
+		// request, so that moving tags like "latest" are never served stale (if
+		// upstream cannot be reached, we fall back to serving what we have)
+		if account.IsProxyCache && reference.IsTag() && !account.IsDeleting && api.CheckReadOnlyMode(a.cfg, *account) == nil && (userType != keppel.PeerUser && userType != keppel.TrivyUser) {
+			newManifest, newManifestBytes, err := a.processor().RevalidateTagInProxyCache(r.Context(), *account, *repo, reference.Tag, dbManifest.Digest, keppel.AuditContext{
+				UserIdentity: authz.UserIdentity,
+				Request:      r,
+			})
+			if err != nil {
+				logg.Error("could not revalidate tag %s:%s against upstream (serving cached manifest %s instead): %s",
+					repo.FullName(), reference.Tag, dbManifest.Digest, err.Error())
+			} else if newManifest != nil {
+				dbManifest, manifestBytes = newManifest, newManifestBytes
+			}
+		}
+	}
+
+	if reference.IsTag() {
+		err := keppel.CheckDigestNotBlocked(a.db, account.Name, dbManifest.Digest)
+		if respondWithError(w, r, err) {
+			return
+		}
+	}
+
+	// manifests in quarantine may only be pulled by Trivy, since the outcome of
+	// the vulnerability scan decides whether they get promoted
+	if !dbManifest.QuarantineStatus.IsPullable() && userType != keppel.TrivyUser {
+		msg := fmt.Sprintf("manifest cannot be pulled because of its vulnerability scan quarantine (quarantine status: %s)", dbManifest.QuarantineStatus)
+		keppel.ErrDenied.With(msg).WithStatus(http.StatusForbidden).WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+
+	// if the account requires signatures, manifests without a valid signature are
+	// either rejected or flagged (Keppels replicating from us and Trivy are exempt
+	// since they need to see all manifests, and so are cosign artifacts like the
+	// signatures themselves, since those cannot be signed)
+	isSignatureRequired := account.RequiresSignature() && userType != keppel.PeerUser && userType != keppel.TrivyUser &&
+		!(reference.IsTag() && keppel.IsCosignArtifactTagName(reference.Tag))
+	if isSignatureRequired && !dbManifest.SignatureStatus.IsTrusted() && account.RequireSignatureMode == models.SignatureRejectUnsigned {
+		msg := fmt.Sprintf("manifest cannot be pulled because it does not have a valid signature from a trusted key (signature status: %s)",
+			renderSignatureStatus(dbManifest.SignatureStatus))
+		keppel.ErrDenied.With(msg).WithStatus(http.StatusForbidden).WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+
+	if manifestBytes == nil {
 		// if manifest was found in our DB, fetch the contents from the DB (or fall
 		// back to the storage if the DB entry is not there for some reason)
 		manifestBytes, err = a.getManifestContentFromDB(repo.ID, dbManifest.Digest)
@@ -147,7 +250,7 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 			}
 			for _, subManifestDesc := range manifestParsed.AcceptableAlternates(account.PlatformFilter) {
 				if acceptRules.Accepts(subManifestDesc.MediaType) {
-					url := fmt.Sprintf("/v2/%s/manifests/%s", getRepoNameForURLPath(*repo, authz), subManifestDesc.Digest.String())
+					url := a.cfg.APIPathPrefix + fmt.Sprintf("/v2/%s/manifests/%s", getRepoNameForURLPath(*repo, authz), subManifestDesc.Digest.String())
 					w.Header().Set("Docker-Content-Digest", subManifestDesc.Digest.String())
 					w.Header().Set("Location", url)
 					w.WriteHeader(http.StatusTemporaryRedirect)
@@ -174,10 +277,25 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// write response
-	w.Header().Set("Content-Length", strconv.FormatUint(uint64(len(manifestBytes)), 10))
-	w.Header().Set("Content-Type", dbManifest.MediaType)
+	// write response (the caching headers allow pull-through caches and CDNs in
+	// front of us to revalidate instead of transferring the manifest again;
+	// manifest contents are immutable, but access to them can change through
+	// quarantine, blocked digests etc., so even pulls by digest may only be
+	// cached briefly)
+	etag := fmt.Sprintf("%q", dbManifest.Digest.String())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", dbManifest.PushedAt.UTC().Format(http.TimeFormat))
+	// (time-machine pulls resolve to a digest, but the URL still names a tag
+	// whose resolution can change, e.g. for timestamps in the future)
+	if reference.IsDigest() && !isTimeMachinePull {
+		w.Header().Set("Cache-Control", "max-age="+strconv.FormatInt(int64(manifestByDigestMaxAge/time.Second), 10))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
 	w.Header().Set("Docker-Content-Digest", dbManifest.Digest.String())
+	if isSignatureRequired {
+		w.Header().Set("X-Keppel-Signature-Status", renderSignatureStatus(dbManifest.SignatureStatus))
+	}
 	if securityInfo != nil {
 		w.Header().Set("X-Keppel-Vulnerability-Status", string(securityInfo.VulnerabilityStatus))
 	}
@@ -187,9 +305,15 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 	if dbManifest.MaxLayerCreatedAt != nil {
 		w.Header().Set("X-Keppel-Max-Layer-Created-At", timeToString(*dbManifest.MaxLayerCreatedAt))
 	}
-	w.WriteHeader(http.StatusOK)
-	if r.Method != http.MethodHead {
-		w.Write(manifestBytes)
+	if api.ETagListContains(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatUint(uint64(len(manifestBytes)), 10))
+		w.Header().Set("Content-Type", dbManifest.MediaType)
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			w.Write(manifestBytes)
+		}
 	}
 
 	// count the pull unless a special header is set or the pull is performed by Trivy as part of our security scanning
@@ -216,6 +340,16 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 			logg.Error("could not update last_pulled_at timestamp on manifest %s@%s: %s", repo.FullName(), dbManifest.Digest, err.Error())
 		}
 
+		// update manifest_pull_counts if required for GC policies with a pull count constraint
+		if a.cfg.ManifestPullCountsEnabled {
+			_, err := a.db.Exec(recordManifestPullQuery,
+				dbManifest.RepositoryID, dbManifest.Digest, keppel.PullCountDay(a.timeNow()),
+			)
+			if err != nil {
+				logg.Error("could not update pull count on manifest %s@%s: %s", repo.FullName(), dbManifest.Digest, err.Error())
+			}
+		}
+
 		// also update tags.last_pulled_at if applicable
 		if reference.IsTag() {
 			_, err := a.db.Exec(
@@ -226,20 +360,60 @@ func (a *API) handleGetOrHeadManifest(w http.ResponseWriter, r *http.Request) {
 				logg.Error("could not update last_pulled_at timestamp on tag %s/%s: %s", repo.FullName(), reference.Tag, err.Error())
 			}
 		}
+
+		// update repos.pull_count if required for tasks.AccountMetricsJob
+		if a.cfg.AccountMetricsEnabled {
+			_, err := a.db.Exec(`UPDATE repos SET pull_count = pull_count + 1 WHERE id = $1`, dbManifest.RepositoryID)
+			if err != nil {
+				logg.Error("could not update pull_count on repo %s: %s", repo.FullName(), err.Error())
+			}
+		}
 	}
 }
 
-func (a *API) findManifestInDB(repo models.Repository, reference models.ManifestReference) (*models.Manifest, error) {
+var recordManifestPullQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO manifest_pull_counts (repo_id, digest, day, count) VALUES ($1, $2, $3, 1)
+	ON CONFLICT (repo_id, digest, day) DO UPDATE SET count = manifest_pull_counts.count + 1
+`)
+
+// Pulls by digest are cacheable for this long (see handleGetOrHeadManifest).
+const manifestByDigestMaxAge = 5 * time.Minute
+
+var findVariantDigestByTagQuery = sqlext.SimplifyWhitespace(`
+	SELECT mv.variant_digest
+	  FROM manifest_variants mv
+	  JOIN tags t ON t.repo_id = mv.repo_id AND t.digest = mv.source_digest
+	 WHERE t.repo_id = $1 AND t.name = $2 AND mv.transformation = $3 AND mv.variant_digest IS NOT NULL
+`)
+
+func (a *API) findManifestInDB(db gorp.SqlExecutor, account models.ReducedAccount, repo models.Repository, reference models.ManifestReference) (*models.Manifest, error) {
 	// resolve tag into digest if necessary
 	refDigest := reference.Digest
 	if reference.IsTag() {
-		digestStr, err := a.db.SelectStr(
+		digestStr, err := db.SelectStr(
 			`SELECT digest FROM tags WHERE repo_id = $1 AND name = $2`,
 			repo.ID, reference.Tag,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if digestStr == "" {
+			// if the tag does not exist literally, it might refer to a variant of
+			// a tagged image (e.g. "foo-squashed" for the squashed variant of "foo")
+			for _, t := range account.SplitImageTransformations() {
+				baseTag, ok := strings.CutSuffix(reference.Tag, t.DerivedTagSuffix())
+				if !ok || baseTag == "" {
+					continue
+				}
+				digestStr, err = db.SelectStr(findVariantDigestByTagQuery, repo.ID, baseTag, t)
+				if err != nil {
+					return nil, err
+				}
+				if digestStr != "" {
+					break
+				}
+			}
+		}
 		if digestStr == "" {
 			return nil, sql.ErrNoRows
 		}
@@ -250,16 +424,87 @@ func (a *API) findManifestInDB(repo models.Repository, reference models.Manifest
 	}
 
 	var dbManifest models.Manifest
-	err := a.db.SelectOne(&dbManifest,
+	err := db.SelectOne(&dbManifest,
 		`SELECT * FROM manifests WHERE repo_id = $1 AND digest = $2`,
 		repo.ID, refDigest.String(),
 	)
 	return &dbManifest, err
 }
 
+// Matches time-machine references like "latest@{2024-05-01}".
+var timeMachineReferenceRx = regexp.MustCompile(`^(.+)@\{(.+)\}$`)
+
+// Parses a time-machine reference into the tag name and the point in time.
+// The timestamp may be given as a date (meaning midnight UTC), in RFC3339
+// format, or as a UNIX timestamp. Returns ok = false if the reference does not
+// look like a time-machine reference at all.
+func parseTimeMachineReference(reference models.ManifestReference) (tagName string, asOf time.Time, ok bool, rerr *keppel.RegistryV2Error) {
+	if !reference.IsTag() {
+		return "", time.Time{}, false, nil
+	}
+	match := timeMachineReferenceRx.FindStringSubmatch(reference.Tag)
+	if match == nil || !models.TagNameRx.MatchString(match[1]) {
+		return "", time.Time{}, false, nil
+	}
+	tagName, timeStr := match[1], match[2]
+
+	if unixTime, err := strconv.ParseInt(timeStr, 10, 64); err == nil {
+		return tagName, time.Unix(unixTime, 0), true, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		asOf, err := time.Parse(layout, timeStr)
+		if err == nil {
+			return tagName, asOf, true, nil
+		}
+	}
+	msg := fmt.Sprintf("cannot parse %q as a timestamp (expected a date like 2006-01-02, an RFC3339 timestamp or a UNIX timestamp)", timeStr)
+	return "", time.Time{}, true, keppel.ErrTagInvalid.With(msg)
+}
+
+var (
+	// if the tag was moved at or before the given time, the last of those movements tells where it pointed to
+	findTagDigestAfterMovementQuery = sqlext.SimplifyWhitespace(`
+		SELECT new_digest FROM tag_history
+		 WHERE repo_id = $1 AND tag_name = $2 AND moved_at <= $3
+		 ORDER BY moved_at DESC, id DESC LIMIT 1
+	`)
+	// otherwise, if the tag was moved only after the given time, the first of those movements tells where it pointed to
+	// (unless the manifest in question was only pushed after the given time, in which case the tag cannot have existed yet)
+	findTagDigestBeforeMovementQuery = sqlext.SimplifyWhitespace(`
+		SELECT th.old_digest FROM tag_history th
+		  JOIN manifests m ON m.repo_id = th.repo_id AND m.digest = th.old_digest
+		 WHERE th.repo_id = $1 AND th.tag_name = $2 AND th.moved_at > $3 AND m.pushed_at <= $3
+		 ORDER BY th.moved_at ASC, th.id ASC LIMIT 1
+	`)
+	// otherwise, the tag was never moved, so it points where it always pointed if it existed at the given time
+	findUnmovedTagDigestQuery = sqlext.SimplifyWhitespace(`
+		SELECT digest FROM tags WHERE repo_id = $1 AND name = $2 AND pushed_at <= $3
+	`)
+)
+
+// Finds the digest of the manifest that the given tag pointed to at the given
+// point in time, using the tag history. Returns sql.ErrNoRows if the tag is
+// not known to have existed at that time.
+//
+// Since the tag history does not record the creation and deletion of tags,
+// tags are assumed to have existed since their first manifest was pushed, and
+// until after their last recorded movement.
+func resolveTagAsOf(db gorp.SqlExecutor, repo models.Repository, tagName string, asOf time.Time) (digest.Digest, error) {
+	for _, query := range []string{findTagDigestAfterMovementQuery, findTagDigestBeforeMovementQuery, findUnmovedTagDigestQuery} {
+		digestStr, err := db.SelectStr(query, repo.ID, tagName, asOf)
+		if err != nil {
+			return "", err
+		}
+		if digestStr != "" {
+			return digest.Parse(digestStr)
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
 func (a *API) getManifestContentFromDB(repoID int64, digestStr digest.Digest) ([]byte, error) {
 	var result []byte
-	err := a.db.SelectOne(&result,
+	err := a.db.ReadReplica().SelectOne(&result,
 		`SELECT content FROM manifest_contents WHERE repo_id = $1 AND digest = $2`,
 		repoID, digestStr,
 	)
@@ -288,14 +533,35 @@ func (a *API) handleDeleteManifest(w http.ResponseWriter, r *http.Request) {
 		UserIdentity: authz.UserIdentity,
 		Request:      r,
 	}
+	// with "?cascade=referrers", manifests referring to the deleted manifest
+	// through their subject (e.g. signatures or SBOMs) are deleted along with it
+	cascade := r.URL.Query().Get("cascade")
+	switch {
+	case cascade != "" && cascade != "referrers":
+		msg := fmt.Sprintf(`unsupported value for "cascade": %q (the only supported value is "referrers")`, cascade)
+		keppel.ErrUnsupported.With(msg).WithStatus(http.StatusBadRequest).WriteAsRegistryV2ResponseTo(w, r)
+		return
+	case cascade != "" && ref.IsTag():
+		msg := `"cascade=referrers" is only supported when deleting a manifest by digest`
+		keppel.ErrUnsupported.With(msg).WithStatus(http.StatusBadRequest).WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+
 	var err error
-	if ref.IsTag() {
+	switch {
+	case ref.IsTag():
 		err = a.processor().DeleteTag(*account, *repo, ref.Tag, actx)
-	} else {
+	case cascade == "referrers":
+		err = a.processor().DeleteManifestAndReferrers(r.Context(), *account, *repo, ref.Digest, actx)
+	default:
 		err = a.processor().DeleteManifest(r.Context(), *account, *repo, ref.Digest, actx)
 	}
 	if errors.Is(err, sql.ErrNoRows) {
-		keppel.ErrManifestUnknown.With("no such manifest").WriteAsRegistryV2ResponseTo(w, r)
+		if ref.IsTag() {
+			keppel.ErrManifestUnknown.With("no such tag").WriteAsRegistryV2ResponseTo(w, r)
+		} else {
+			keppel.ErrManifestUnknown.With("no such manifest").WriteAsRegistryV2ResponseTo(w, r)
+		}
 		return
 	}
 	if respondWithError(w, r, err) {
@@ -367,6 +633,33 @@ func (a *API) handlePutManifest(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Length", "0")
 	w.Header().Set("Docker-Content-Digest", manifest.Digest.String())
-	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", getRepoNameForURLPath(*repo, authz), manifest.Digest))
+	w.Header().Set("Location", a.cfg.APIPathPrefix+fmt.Sprintf("/v2/%s/manifests/%s", getRepoNameForURLPath(*repo, authz), manifest.Digest))
+	if manifest.QuarantineStatus != models.NotQuarantined {
+		w.Header().Set("X-Keppel-Quarantine-Status", string(manifest.QuarantineStatus))
+	}
+	if a.cfg.EnableOCIDistributionSpecV11 {
+		// tell the client that we have processed the subject, so that it does not
+		// need to update the referrers fallback tag
+		if manifest.SubjectDigest != "" {
+			w.Header().Set("OCI-Subject", manifest.SubjectDigest)
+		}
+		if _, isReferrersFallbackTag := keppel.ParseReferrersFallbackTagName(ref.Tag); ref.IsTag() && isReferrersFallbackTag {
+			addWarningHeader(w, "this registry supports the Referrers API, so referrers fallback tags are not required")
+		}
+	}
 	w.WriteHeader(http.StatusCreated)
 }
+
+// Renders a SignatureStatus for use in error messages and response headers.
+func renderSignatureStatus(status models.SignatureStatus) string {
+	if status == models.SignatureUnverified {
+		return "unverified"
+	}
+	return string(status)
+}
+
+// Adds a warning header as defined in the OCI Distribution Spec (v1.1 and
+// newer), which clients are expected to show to the user.
+func addWarningHeader(w http.ResponseWriter, msg string) {
+	w.Header().Add("Warning", fmt.Sprintf("299 - %q", msg))
+}
@@ -20,13 +20,16 @@ package registryv2_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/docker/distribution/manifest/manifestlist"
 	"github.com/docker/distribution/manifest/schema2"
 	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sapcc/go-api-declarations/cadf"
 	"github.com/sapcc/go-bits/assert"
 	"github.com/sapcc/go-bits/easypg"
@@ -649,6 +652,684 @@ func TestManifestRequiredLabels(t *testing.T) {
 	})
 }
 
+func TestManifestAllowedMediaTypes(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.Config.MustUpload(t, s, fooRepoRef)
+		image.Layers[0].MustUpload(t, s, fooRepoRef)
+
+		// allow the manifest and config media types, but not the layer media type
+		_, err := s.DB.Exec(
+			`UPDATE accounts SET allowed_media_types = $1 WHERE name = $2`,
+			schema2.MediaTypeManifest+","+schema2.MediaTypeImageConfig, "test1",
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// manifest push should fail
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  schema2.MediaTypeManifest,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusBadRequest,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrManifestInvalid,
+				Message: "media type not allowed in this account: " + schema2.MediaTypeLayer,
+			},
+		}.Check(t, h)
+
+		// allow all Docker image media types with a wildcard
+		_, err = s.DB.Exec(
+			`UPDATE accounts SET allowed_media_types = $1 WHERE name = $2`,
+			"application/vnd.docker.*", "test1",
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// manifest push should succeed
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  schema2.MediaTypeManifest,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+	})
+}
+
+func TestManifestEmptyConfigArtifact(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		artifact := test.GenerateEmptyConfigArtifact(
+			"application/vnd.example.sbom.v1+json",
+			map[string]string{"foo": "is there"},
+			test.NewBytes([]byte("some artifact payload")),
+		)
+		artifact.Config.MustUpload(t, s, fooRepoRef)
+		artifact.Layers[0].MustUpload(t, s, fooRepoRef)
+
+		mustExec := func(query string, args ...any) {
+			t.Helper()
+			_, err := s.DB.Exec(query, args...)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+		pushArtifact := func(expectStatus int, expectBody assert.HTTPResponseBody) {
+			t.Helper()
+			assert.HTTPRequest{
+				Method: "PUT",
+				Path:   "/v2/test1/foo/manifests/latest",
+				Header: map[string]string{
+					"Authorization": "Bearer " + token,
+					"Content-Type":  imagespec.MediaTypeImageManifest,
+				},
+				Body:         assert.ByteData(artifact.Manifest.Contents),
+				ExpectStatus: expectStatus,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   expectBody,
+			}.Check(t, h)
+		}
+
+		// artifacts with empty config can be rejected per account
+		mustExec(`UPDATE accounts SET reject_empty_config_artifacts = TRUE WHERE name = $1`, "test1")
+		pushArtifact(http.StatusBadRequest, test.ErrorCodeWithMessage{
+			Code:    keppel.ErrManifestInvalid,
+			Message: "artifacts with empty config are not allowed in this account",
+		})
+		mustExec(`UPDATE accounts SET reject_empty_config_artifacts = FALSE WHERE name = $1`, "test1")
+
+		// since these artifacts do not have labels, required labels are looked up in their annotations instead
+		mustExec(`UPDATE accounts SET required_labels = $1 WHERE name = $2`, "foo,bar", "test1")
+		pushArtifact(http.StatusBadRequest, test.ErrorCodeWithMessage{
+			Code:    keppel.ErrManifestInvalid,
+			Message: "missing required labels: bar",
+		})
+		mustExec(`UPDATE accounts SET required_labels = $1 WHERE name = $2`, "foo", "test1")
+		pushArtifact(http.StatusCreated, nil)
+
+		// the annotations are not reported as labels
+		labelsJSON, err := s.DB.SelectStr(`SELECT labels_json FROM manifests WHERE digest = $1`, artifact.Manifest.Digest.String())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "labels_json", labelsJSON, "")
+	})
+}
+
+func TestManifestValidationDriver(t *testing.T) {
+	testWithPrimary(t, []test.SetupOption{test.WithManifestValidator}, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		image := test.GenerateImageWithCustomConfig(func(cfg map[string]any) {
+			cfg["config"].(map[string]any)["Labels"] = map[string]string{"foo": "bar"}
+		}, test.GenerateExampleLayer(1))
+		image.Config.MustUpload(t, s, fooRepoRef)
+		image.Layers[0].MustUpload(t, s, fooRepoRef)
+
+		pushImage := func(expectStatus int, expectBody assert.HTTPResponseBody) {
+			t.Helper()
+			assert.HTTPRequest{
+				Method: "PUT",
+				Path:   "/v2/test1/foo/manifests/latest",
+				Header: map[string]string{
+					"Authorization": "Bearer " + token,
+					"Content-Type":  image.Manifest.MediaType,
+				},
+				Body:         assert.ByteData(image.Manifest.Contents),
+				ExpectStatus: expectStatus,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   expectBody,
+			}.Check(t, h)
+		}
+
+		// the push is rejected if the validation driver says so...
+		s.ManifestValidator.RejectionReason = "images must be signed off by the platform team"
+		pushImage(http.StatusForbidden, test.ErrorCodeWithMessage{
+			Code:    keppel.ErrDenied,
+			Message: "manifest was rejected by validation policy: images must be signed off by the platform team",
+		})
+		manifestCount, err := s.DB.SelectInt(`SELECT COUNT(*) FROM manifests`)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "manifest count", manifestCount, int64(0))
+
+		// ...and goes through otherwise
+		s.ManifestValidator.RejectionReason = ""
+		pushImage(http.StatusCreated, nil)
+		expectManifestExists(t, h, token, "test1/foo", image.Manifest, "latest", nil)
+
+		// the validation driver was given all the information it needs to make a decision
+		if len(s.ManifestValidator.Requests) != 2 {
+			t.Fatalf("expected 2 requests to the manifest validation driver, but got %d", len(s.ManifestValidator.Requests))
+		}
+		req := s.ManifestValidator.Requests[1]
+		assert.DeepEqual(t, "account name", req.Account.Name, models.AccountName("test1"))
+		assert.DeepEqual(t, "repo name", req.Repository.Name, "foo")
+		assert.DeepEqual(t, "tag name", req.TagName, "latest")
+		assert.DeepEqual(t, "digest", req.Digest, image.Manifest.Digest)
+		assert.DeepEqual(t, "media type", req.MediaType, image.Manifest.MediaType)
+		assert.DeepEqual(t, "contents", string(req.Contents), string(image.Manifest.Contents))
+		assert.DeepEqual(t, "config contents", string(req.ConfigContents), string(image.Config.Contents))
+		assert.DeepEqual(t, "labels", req.Labels, map[string]string{"foo": "bar"})
+	})
+}
+
+func TestManifestDigestPinnedPulls(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		otherRepoToken := s.GetToken(t, "repository:test1/bar:pull")
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		image.MustUpload(t, s, barRepoRef, "latest")
+
+		// require digest-pinned pulls in test1/foo only
+		_, err := s.DB.Exec(
+			`UPDATE accounts SET require_digest_pulls_repo_rx = $1 WHERE name = $2`,
+			"foo", "test1",
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// pulling by tag is rejected...
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusForbidden,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrDenied,
+				Message: "manifests in this repository may only be pulled by digest; resolve the tag into a digest with HEAD /v2/test1/foo/manifests/latest, then pull by digest",
+			},
+		}.Check(t, h)
+
+		// ...but resolving the tag with HEAD is allowed...
+		assert.HTTPRequest{
+			Method:       "HEAD",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey:   test.VersionHeaderValue,
+				"Docker-Content-Digest": image.Manifest.Digest.String(),
+			},
+		}.Check(t, h)
+
+		// ...as is pulling by digest
+		expectManifestExists(t, h, token, "test1/foo", image.Manifest, "", nil)
+
+		// repos that do not match the policy are not affected
+		expectManifestExists(t, h, otherRepoToken, "test1/bar", image.Manifest, "latest", nil)
+	})
+}
+
+func TestManifestTimeMachinePull(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		// push "latest" twice, with an hour in between
+		s.Clock.StepBy(time.Hour)
+		image1 := test.GenerateImage(test.GenerateExampleLayer(1))
+		image1.MustUpload(t, s, fooRepoRef, "latest")
+		pushedAt1 := s.Clock.Now().Unix()
+
+		s.Clock.StepBy(time.Hour)
+		image2 := test.GenerateImage(test.GenerateExampleLayer(2))
+		image2.MustUpload(t, s, fooRepoRef, "latest")
+		pushedAt2 := s.Clock.Now().Unix()
+
+		expectTagResolvesTo := func(reference string, image test.Image) {
+			t.Helper()
+			for _, method := range []string{"GET", "HEAD"} {
+				assert.HTTPRequest{
+					Method:       method,
+					Path:         "/v2/test1/foo/manifests/" + reference,
+					Header:       map[string]string{"Authorization": "Bearer " + token},
+					ExpectStatus: http.StatusOK,
+					ExpectHeader: map[string]string{
+						test.VersionHeaderKey:   test.VersionHeaderValue,
+						"Docker-Content-Digest": image.Manifest.Digest.String(),
+						// the URL names a tag, so caches must not hold on to the result
+						"Cache-Control": "no-cache",
+					},
+				}.Check(t, h)
+			}
+		}
+
+		// the tag resolves to whatever manifest it pointed to at the given time
+		expectTagResolvesTo(fmt.Sprintf("latest@{%d}", pushedAt1), image1)
+		expectTagResolvesTo(fmt.Sprintf("latest@{%d}", pushedAt2-1), image1)
+		expectTagResolvesTo(fmt.Sprintf("latest@{%d}", pushedAt2), image2)
+		expectTagResolvesTo(time.Unix(pushedAt2+60, 0).UTC().Format("latest@{2006-01-02T15:04:05Z}"), image2)
+
+		// before the first push, the tag did not exist yet
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         fmt.Sprintf("/v2/test1/foo/manifests/latest@{%d}", pushedAt1-1),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusNotFound,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrManifestUnknown,
+				Message: fmt.Sprintf("tag %q did not exist at %s", "latest", time.Unix(pushedAt1-1, 0).UTC().Format(time.RFC3339)),
+			},
+		}.Check(t, h)
+
+		// tags that were never moved work, too
+		image2.MustUpload(t, s, fooRepoRef, "stable")
+		expectTagResolvesTo(fmt.Sprintf("stable@{%d}", pushedAt2), image2)
+
+		// malformed timestamps are rejected
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest@{yesterday}",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrTagInvalid,
+				Message: `cannot parse "yesterday" as a timestamp (expected a date like 2006-01-02, an RFC3339 timestamp or a UNIX timestamp)`,
+			},
+		}.Check(t, h)
+	})
+}
+
+func TestManifestPullCounts(t *testing.T) {
+	testWithPrimary(t, []test.SetupOption{test.WithManifestPullCounts}, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+
+		pull := func(method, reference string, header map[string]string) {
+			t.Helper()
+			header["Authorization"] = "Bearer " + token
+			assert.HTTPRequest{
+				Method:       method,
+				Path:         "/v2/test1/foo/manifests/" + reference,
+				Header:       header,
+				ExpectStatus: http.StatusOK,
+			}.Check(t, h)
+		}
+		expectPullCount := func(day time.Time, expected int64) {
+			t.Helper()
+			count, err := s.DB.SelectInt(
+				`SELECT COALESCE(SUM(count), 0) FROM manifest_pull_counts WHERE digest = $1 AND day = $2`,
+				image.Manifest.Digest.String(), keppel.PullCountDay(day),
+			)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "pull count", count, expected)
+		}
+
+		// pulls by tag and by digest are counted in the same row, but HEAD
+		// requests and pulls that opt out of counting are not counted
+		day1 := s.Clock.Now()
+		pull("GET", "latest", map[string]string{})
+		pull("GET", image.Manifest.Digest.String(), map[string]string{})
+		pull("HEAD", "latest", map[string]string{})
+		pull("GET", "latest", map[string]string{"X-Keppel-No-Count-Towards-Last-Pulled": "1"})
+		expectPullCount(day1, 2)
+
+		// pulls on the next day go into a new row
+		s.Clock.StepBy(24 * time.Hour)
+		day2 := s.Clock.Now()
+		pull("GET", "latest", map[string]string{})
+		expectPullCount(day1, 2)
+		expectPullCount(day2, 1)
+	})
+
+	// without the respective config option, pulls are not counted
+	testWithPrimary(t, nil, func(s test.Setup) {
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+		}.Check(t, s.Handler)
+
+		count, err := s.DB.SelectInt(`SELECT COUNT(*) FROM manifest_pull_counts`)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "number of pull count rows", count, int64(0))
+	})
+}
+
+func TestManifestQuarantine(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		_, err := s.DB.Exec(
+			`UPDATE accounts SET quarantine_severity_threshold = $1 WHERE name = $2`,
+			models.HighSeverity, "test1",
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		for _, blob := range append(image.Layers, image.Config) {
+			blob.MustUpload(t, s, fooRepoRef)
+		}
+
+		// pushing succeeds, but reports that the manifest is in quarantine
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  image.Manifest.MediaType,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey:        test.VersionHeaderValue,
+				"Docker-Content-Digest":      image.Manifest.Digest.String(),
+				"X-Keppel-Quarantine-Status": "pending",
+			},
+		}.Check(t, h)
+
+		// the tag is not visible yet...
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusNotFound,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrManifestUnknown),
+		}.Check(t, h)
+
+		// ...and the manifest cannot be pulled by digest either
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/" + image.Manifest.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusForbidden,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrDenied),
+		}.Check(t, h)
+
+		// once the manifest is promoted, it can be pulled as usual
+		_, err = s.DB.Exec(
+			`UPDATE manifests SET quarantine_status = $1 WHERE digest = $2`,
+			models.QuarantinePromoted, image.Manifest.Digest,
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		expectManifestExists(t, h, token, "test1/foo", image.Manifest, "", nil)
+	})
+}
+
+func TestManifestBlockedDigest(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+
+		// block the manifest instance-wide, and the layer within the account
+		_, err := s.DB.Exec(
+			`INSERT INTO blocked_digests (account_name, digest, reason, created_at) VALUES (NULL, $1, $2, $3), ($4, $5, $6, $3)`,
+			image.Manifest.Digest, "malware", s.Clock.Now(),
+			"test1", image.Layers[0].Digest, "leaked credentials",
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// pulls of the blocked manifest fail, both by digest and by tag
+		for _, ref := range []string{image.Manifest.Digest.String(), "latest"} {
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/manifests/" + ref,
+				Header:       map[string]string{"Authorization": "Bearer " + token},
+				ExpectStatus: http.StatusUnavailableForLegalReasons,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   test.ErrorCode(keppel.ErrDenied),
+			}.Check(t, h)
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/blobs/" + image.Layers[0].Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusUnavailableForLegalReasons,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrDenied),
+		}.Check(t, h)
+
+		// the same content cannot be pushed again
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/other",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  image.Manifest.MediaType,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusUnavailableForLegalReasons,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody:   test.ErrorCode(keppel.ErrDenied),
+		}.Check(t, h)
+
+		// blobs that are not blocked can still be pulled
+		expectBlobExists(t, h, token, "test1/foo", image.Config, nil)
+	})
+}
+
+func TestManifestConditionalRequests(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull")
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		dbManifest := image.MustUpload(t, s, fooRepoRef, "latest")
+		etag := fmt.Sprintf("%q", image.Manifest.Digest.String())
+		lastModified := dbManifest.PushedAt.UTC().Format(http.TimeFormat)
+
+		// pulls by digest may be cached for a short time, pulls by tag need to be
+		// revalidated every time
+		cacheControlByRef := map[string]string{
+			image.Manifest.Digest.String(): "max-age=300",
+			"latest":                       "no-cache",
+		}
+		for ref, cacheControl := range cacheControlByRef {
+			for _, method := range []string{"GET", "HEAD"} {
+				assert.HTTPRequest{
+					Method:       method,
+					Path:         "/v2/test1/foo/manifests/" + ref,
+					Header:       map[string]string{"Authorization": "Bearer " + token},
+					ExpectStatus: http.StatusOK,
+					ExpectHeader: map[string]string{
+						test.VersionHeaderKey: test.VersionHeaderValue,
+						"ETag":                etag,
+						"Last-Modified":       lastModified,
+						"Cache-Control":       cacheControl,
+					},
+				}.Check(t, h)
+
+				// with a matching If-None-Match, the manifest is not sent again
+				assert.HTTPRequest{
+					Method: method,
+					Path:   "/v2/test1/foo/manifests/" + ref,
+					Header: map[string]string{
+						"Authorization": "Bearer " + token,
+						"If-None-Match": etag,
+					},
+					ExpectStatus: http.StatusNotModified,
+					ExpectHeader: map[string]string{
+						"ETag":                  etag,
+						"Docker-Content-Digest": image.Manifest.Digest.String(),
+					},
+					ExpectBody: assert.StringData(""),
+				}.Check(t, h)
+			}
+		}
+
+		// a non-matching If-None-Match yields the full manifest
+		assert.HTTPRequest{
+			Method: "GET",
+			Path:   "/v2/test1/foo/manifests/latest",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"If-None-Match": fmt.Sprintf("%q", test.DeterministicDummyDigest(1).String()),
+			},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{"ETag": etag},
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, h)
+	})
+}
+
+func TestDeleteTagInQuarantine(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		deleteToken := s.GetToken(t, "repository:test1/foo:delete")
+
+		_, err := s.DB.Exec(
+			`UPDATE accounts SET quarantine_severity_threshold = $1 WHERE name = $2`,
+			models.HighSeverity, "test1",
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+
+		// a tag that is pending in quarantine can be deleted...
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + deleteToken},
+			ExpectStatus: http.StatusAccepted,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+
+		// ...and will therefore not appear once the manifest is promoted
+		count, err := s.DB.SelectInt(`SELECT COUNT(*) FROM quarantined_tags`)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "number of quarantined tags", count, int64(0))
+
+		// deleting the tag again fails
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + deleteToken},
+			ExpectStatus: http.StatusNotFound,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrManifestUnknown,
+				Message: "no such tag",
+			},
+		}.Check(t, h)
+	})
+}
+
+func TestDeleteTagWithPendingPushInQuarantine(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		deleteToken := s.GetToken(t, "repository:test1/foo:delete")
+
+		// push a tag, then push a new manifest for the same tag into quarantine
+		image1 := test.GenerateImage(test.GenerateExampleLayer(1))
+		image1.MustUpload(t, s, fooRepoRef, "latest")
+		_, err := s.DB.Exec(
+			`UPDATE accounts SET quarantine_severity_threshold = $1 WHERE name = $2`,
+			models.HighSeverity, "test1",
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		image2 := test.GenerateImage(test.GenerateExampleLayer(2))
+		image2.MustUpload(t, s, fooRepoRef, "latest")
+		s.Auditor.IgnoreEventsUntilNow()
+
+		expectTagCounts := func(expectedLive, expectedQuarantined int64) {
+			t.Helper()
+			count, err := s.DB.SelectInt(`SELECT COUNT(*) FROM tags`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "number of tags", count, expectedLive)
+			count, err = s.DB.SelectInt(`SELECT COUNT(*) FROM quarantined_tags`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "number of quarantined tags", count, expectedQuarantined)
+		}
+		expectTagCounts(1, 1)
+
+		// a single DELETE removes both the live tag and the pending push, and
+		// audits the removal of both digests
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + deleteToken},
+			ExpectStatus: http.StatusAccepted,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+		expectedEvents := make([]cadf.Event, 0, 2)
+		for _, image := range []test.Image{image1, image2} {
+			expectedEvents = append(expectedEvents, cadf.Event{
+				RequestPath: "/v2/test1/foo/manifests/latest",
+				Action:      cadf.DeleteAction,
+				Outcome:     "success",
+				Reason:      test.CADFReasonOK,
+				Target: cadf.Resource{
+					TypeURI:   "docker-registry/account/repository/tag",
+					Name:      "test1/foo:latest",
+					ID:        image.Manifest.Digest.String(),
+					ProjectID: authTenantID,
+				},
+			})
+		}
+		s.Auditor.ExpectEvents(t, expectedEvents...)
+		expectTagCounts(0, 0)
+
+		// the tag is gone entirely
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + deleteToken},
+			ExpectStatus: http.StatusNotFound,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+	})
+}
+
 func expectLabelsJSONOnManifest(t *testing.T, db *keppel.DB, manifestDigest digest.Digest, expected map[string]string) {
 	t.Helper()
 	labelsJSONStr, err := db.SelectStr(`SELECT labels_json FROM manifests WHERE digest = $1`, manifestDigest.String())
@@ -664,6 +1345,73 @@ func expectLabelsJSONOnManifest(t *testing.T, db *keppel.DB, manifestDigest dige
 	assert.DeepEqual(t, "labels_json", actual, expected)
 }
 
+func TestDeleteManifestWithReferrers(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		deleteToken := s.GetToken(t, "repository:test1/foo:delete")
+
+		// upload an image with an SBOM, and a signature for that SBOM
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		sbom := test.GenerateReferrerArtifact(image.Manifest, "application/vnd.example.sbom.v1+json", test.NewBytes([]byte("sbom")))
+		sbom.MustUpload(t, s, fooRepoRef, "")
+		signature := test.GenerateReferrerArtifact(sbom.Manifest, "application/vnd.example.signature.v1+json", test.NewBytes([]byte("signature")))
+		signature.MustUpload(t, s, fooRepoRef, "")
+		otherImage := test.GenerateImage(test.GenerateExampleLayer(2))
+		otherImage.MustUpload(t, s, fooRepoRef, "other")
+
+		expectManifestCount := func(expected int64) {
+			t.Helper()
+			count, err := s.DB.SelectInt(`SELECT COUNT(*) FROM manifests`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			assert.DeepEqual(t, "manifest count", count, expected)
+		}
+		expectManifestCount(4)
+
+		// cascading only works for deleting manifests, not tags
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         "/v2/test1/foo/manifests/latest?cascade=referrers",
+			Header:       map[string]string{"Authorization": "Bearer " + deleteToken},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrUnsupported,
+				Message: `"cascade=referrers" is only supported when deleting a manifest by digest`,
+			},
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         "/v2/test1/foo/manifests/" + image.Manifest.Digest.String() + "?cascade=everything",
+			Header:       map[string]string{"Authorization": "Bearer " + deleteToken},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectHeader: test.VersionHeader,
+			ExpectBody: test.ErrorCodeWithMessage{
+				Code:    keppel.ErrUnsupported,
+				Message: `unsupported value for "cascade": "everything" (the only supported value is "referrers")`,
+			},
+		}.Check(t, h)
+		expectManifestCount(4)
+
+		// deleting with cascade removes the image and all its referrers (recursively), but nothing else
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         "/v2/test1/foo/manifests/" + image.Manifest.Digest.String() + "?cascade=referrers",
+			Header:       map[string]string{"Authorization": "Bearer " + deleteToken},
+			ExpectStatus: http.StatusAccepted,
+			ExpectHeader: test.VersionHeader,
+		}.Check(t, h)
+		expectManifestCount(1)
+		digestStr, err := s.DB.SelectStr(`SELECT digest FROM manifests`)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "remaining manifest", digestStr, otherImage.Manifest.Digest.String())
+	})
+}
+
 func TestImageManifestWrongBlobSize(t *testing.T) {
 	testWithPrimary(t, nil, func(s test.Setup) {
 		h := s.Handler
@@ -693,7 +1441,7 @@ func TestImageManifestWrongBlobSize(t *testing.T) {
 
 func TestImageManifestCmdEntrypointAsString(t *testing.T) {
 	testWithPrimary(t, nil, func(s test.Setup) {
-		j := tasks.NewJanitor(s.Config, s.FD, s.SD, s.ICD, s.DB, s.AMD, s.Auditor).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next)
+		j := tasks.NewJanitor(s.Config, s.FD, s.SD, s.ICD, s.CDN, s.DB, s.AMD, s.Auditor).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next)
 		j.DisableJitter()
 		validateManifestJob := j.ManifestValidationJob(s.Registry)
 
@@ -710,3 +1458,171 @@ func TestImageManifestCmdEntrypointAsString(t *testing.T) {
 		}
 	})
 }
+
+func TestManifestSignatureRequirement(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull")
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		signatureTagName := keppel.CosignSignatureTagName(image.Manifest.Digest)
+		signature := test.GenerateImage(test.GenerateExampleLayer(2))
+		signature.MustUpload(t, s, fooRepoRef, signatureTagName)
+
+		setPolicyAndStatus := func(mode models.SignatureEnforcement, status models.SignatureStatus) {
+			t.Helper()
+			_, err := s.DB.Exec(`UPDATE accounts SET require_signature_mode = $1 WHERE name = $2`, mode, "test1")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			_, err = s.DB.Exec(`UPDATE manifests SET signature_status = $1 WHERE digest = $2`, status, image.Manifest.Digest)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+
+		// in "reject" mode, manifests without a verified signature cannot be pulled
+		for _, status := range []models.SignatureStatus{models.SignatureUnverified, models.SignatureMissing, models.SignatureInvalid} {
+			setPolicyAndStatus(models.SignatureRejectUnsigned, status)
+			for _, ref := range []string{"latest", image.Manifest.Digest.String()} {
+				assert.HTTPRequest{
+					Method:       "GET",
+					Path:         "/v2/test1/foo/manifests/" + ref,
+					Header:       map[string]string{"Authorization": "Bearer " + token},
+					ExpectStatus: http.StatusForbidden,
+					ExpectHeader: test.VersionHeader,
+					ExpectBody:   test.ErrorCode(keppel.ErrDenied),
+				}.Check(t, h)
+			}
+		}
+
+		// once the signature is verified, the manifest can be pulled
+		setPolicyAndStatus(models.SignatureRejectUnsigned, models.SignatureValid)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey:       test.VersionHeaderValue,
+				"Docker-Content-Digest":     image.Manifest.Digest.String(),
+				"X-Keppel-Signature-Status": "valid",
+			},
+			ExpectBody: assert.ByteData(image.Manifest.Contents),
+		}.Check(t, h)
+
+		// the signature itself can always be pulled by its tag, so that clients can verify it
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/" + signatureTagName,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey:       test.VersionHeaderValue,
+				"X-Keppel-Signature-Status": "",
+			},
+			ExpectBody: assert.ByteData(signature.Manifest.Contents),
+		}.Check(t, h)
+
+		// in "flag" mode, manifests without a verified signature can be pulled, but are flagged
+		setPolicyAndStatus(models.SignatureFlagUnsigned, models.SignatureUnverified)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey:       test.VersionHeaderValue,
+				"X-Keppel-Signature-Status": "unverified",
+			},
+			ExpectBody: assert.ByteData(image.Manifest.Contents),
+		}.Check(t, h)
+
+		// without a policy, the header is not shown
+		setPolicyAndStatus(models.SignatureNotRequired, models.SignatureUnverified)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/manifests/latest",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{
+				test.VersionHeaderKey:       test.VersionHeaderValue,
+				"X-Keppel-Signature-Status": "",
+			},
+			ExpectBody: assert.ByteData(image.Manifest.Contents),
+		}.Check(t, h)
+	})
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+		deleteToken := s.GetToken(t, "repository:test1/foo:delete")
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		otherImage := test.GenerateImage(test.GenerateExampleLayer(2))
+
+		_, err := s.DB.Exec(`UPDATE accounts SET is_read_only = TRUE WHERE name = $1`, "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		expectedHeader := map[string]string{
+			test.VersionHeaderKey: test.VersionHeaderValue,
+			"Retry-After":         "300",
+		}
+		expectedBody := test.ErrorCodeWithMessage{
+			Code:    keppel.ErrUnavailable,
+			Message: "account is in read-only mode for maintenance",
+		}
+
+		// pushing blobs or manifests is rejected...
+		assert.HTTPRequest{
+			Method: "POST",
+			Path:   "/v2/test1/foo/blobs/uploads/?digest=" + otherImage.Layers[0].Digest.String(),
+			Header: map[string]string{
+				"Authorization":  "Bearer " + token,
+				"Content-Length": strconv.Itoa(len(otherImage.Layers[0].Contents)),
+				"Content-Type":   "application/octet-stream",
+			},
+			Body:         assert.ByteData(otherImage.Layers[0].Contents),
+			ExpectStatus: http.StatusServiceUnavailable,
+			ExpectHeader: expectedHeader,
+			ExpectBody:   expectedBody,
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/other",
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  image.Manifest.MediaType,
+			},
+			Body:         assert.ByteData(image.Manifest.Contents),
+			ExpectStatus: http.StatusServiceUnavailable,
+			ExpectHeader: expectedHeader,
+			ExpectBody:   expectedBody,
+		}.Check(t, h)
+
+		// ...as is deleting manifests...
+		assert.HTTPRequest{
+			Method:       "DELETE",
+			Path:         "/v2/test1/foo/manifests/" + image.Manifest.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + deleteToken},
+			ExpectStatus: http.StatusServiceUnavailable,
+			ExpectHeader: expectedHeader,
+			ExpectBody:   expectedBody,
+		}.Check(t, h)
+
+		// ...but pulling still works
+		expectManifestExists(t, h, token, "test1/foo", image.Manifest, "latest", nil)
+
+		// after the maintenance, writes work again
+		_, err = s.DB.Exec(`UPDATE accounts SET is_read_only = FALSE WHERE name = $1`, "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		image.MustUpload(t, s, fooRepoRef, "other")
+	})
+}
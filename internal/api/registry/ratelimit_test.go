@@ -19,6 +19,7 @@
 package registryv2_test
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 	"testing"
@@ -200,3 +201,158 @@ func TestAnycastRateLimits(t *testing.T) {
 		})
 	})
 }
+
+func TestAnonymousRateLimits(t *testing.T) {
+	limit := redis_rate.Limit{Rate: 2, Period: time.Minute, Burst: 3}
+	rld := basic.RateLimitDriver{
+		Limits: map[keppel.RateLimitedAction]redis_rate.Limit{
+			keppel.AnonymousBlobPullAction:     limit,
+			keppel.AnonymousManifestPullAction: limit,
+			// all other rate limits are set to "unlimited"
+		},
+	}
+	rle := &keppel.RateLimitEngine{Driver: rld, Client: nil}
+	setupOptions := []test.SetupOption{
+		test.WithRateLimitEngine(rle),
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: authTenantID}),
+	}
+
+	testWithPrimary(t, setupOptions, func(s test.Setup) {
+		// allow anonymous pulls from both accounts
+		for _, accountName := range []models.AccountName{"test1", "test2"} {
+			_, err := keppel.FindOrCreateRepository(s.DB, "foo", accountName)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			_, err = s.DB.Exec(`UPDATE accounts SET rbac_policies_json = $2 WHERE name = $1`, accountName,
+				test.ToJSON([]keppel.RBACPolicy{{
+					RepositoryPattern: "foo",
+					Permissions:       []keppel.RBACPermission{keppel.GrantsAnonymousPull},
+				}}),
+			)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		bogusDigest := test.DeterministicDummyDigest(1).String()
+
+		for _, objectType := range []string{"blobs", "manifests"} {
+			expectedError := keppel.ErrBlobUnknown
+			if objectType == "manifests" {
+				expectedError = keppel.ErrManifestUnknown
+			}
+			anonReq := func(accountName, clientIP string) assert.HTTPRequest {
+				return assert.HTTPRequest{
+					Method:       "GET",
+					Path:         fmt.Sprintf("/v2/%s/foo/%s/%s", accountName, objectType, bogusDigest),
+					Header:       map[string]string{"X-Forwarded-For": clientIP},
+					ExpectStatus: http.StatusNotFound,
+					ExpectHeader: test.VersionHeader,
+					ExpectBody:   test.ErrorCode(expectedError),
+				}
+			}
+			rateLimited := func(req assert.HTTPRequest) assert.HTTPRequest {
+				req.ExpectStatus = http.StatusTooManyRequests
+				req.ExpectBody = test.ErrorCode(keppel.ErrTooManyRequests)
+				req.ExpectHeader = map[string]string{
+					test.VersionHeaderKey: test.VersionHeaderValue,
+					"Retry-After":         "30",
+				}
+				return req
+			}
+
+			s.Clock.StepBy(time.Hour)
+
+			// anonymous requests from the same IP share one budget across all accounts
+			anonReq("test1", "192.0.2.1").Check(t, h)
+			anonReq("test1", "192.0.2.1").Check(t, h)
+			anonReq("test2", "192.0.2.1").Check(t, h)
+			rateLimited(anonReq("test1", "192.0.2.1")).Check(t, h)
+			rateLimited(anonReq("test2", "192.0.2.1")).Check(t, h)
+
+			// anonymous requests from a different IP are unaffected
+			anonReq("test2", "192.0.2.2").Check(t, h)
+
+			// authenticated requests from the same IP are not subject to the anonymous rate limit
+			authReq := anonReq("test1", "192.0.2.1")
+			authReq.Header["Authorization"] = "Bearer " + token
+			authReq.Check(t, h)
+		}
+	})
+}
+
+func TestAnonymousRateLimitsDoNotAffectOtherClients(t *testing.T) {
+	rld := basic.RateLimitDriver{
+		Limits: map[keppel.RateLimitedAction]redis_rate.Limit{
+			keppel.ManifestPullAction:          {Rate: 1, Period: time.Minute, Burst: 5},
+			keppel.AnonymousManifestPullAction: {Rate: 1, Period: time.Minute, Burst: 2},
+		},
+	}
+	rle := &keppel.RateLimitEngine{Driver: rld, Client: nil}
+	setupOptions := []test.SetupOption{
+		test.WithRateLimitEngine(rle),
+	}
+
+	testWithPrimary(t, setupOptions, func(s test.Setup) {
+		_, err := keppel.FindOrCreateRepository(s.DB, "foo", "test1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_, err = s.DB.Exec(`UPDATE accounts SET rbac_policies_json = $1 WHERE name = 'test1'`,
+			test.ToJSON([]keppel.RBACPolicy{{
+				RepositoryPattern: "foo",
+				Permissions:       []keppel.RBACPermission{keppel.GrantsAnonymousPull},
+			}}),
+		)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull")
+		bogusDigest := test.DeterministicDummyDigest(1).String()
+		s.Clock.StepBy(time.Hour)
+
+		// the anonymous client and the authenticated user are on different hosts
+		req := func(withToken bool) assert.HTTPRequest {
+			header := map[string]string{"X-Forwarded-For": "192.0.2.1"}
+			if withToken {
+				header["X-Forwarded-For"] = "192.0.2.2"
+				header["Authorization"] = "Bearer " + token
+			}
+			return assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/manifests/" + bogusDigest,
+				Header:       header,
+				ExpectStatus: http.StatusNotFound,
+				ExpectHeader: test.VersionHeader,
+				ExpectBody:   test.ErrorCode(keppel.ErrManifestUnknown),
+			}
+		}
+		rateLimited := func(req assert.HTTPRequest) assert.HTTPRequest {
+			req.ExpectStatus = http.StatusTooManyRequests
+			req.ExpectBody = test.ErrorCode(keppel.ErrTooManyRequests)
+			req.ExpectHeader = nil
+			return req
+		}
+
+		// the anonymous client uses up its per-IP budget...
+		req(false).Check(t, h)
+		req(false).Check(t, h)
+
+		// ...and then keeps hammering the account
+		for range 10 {
+			rateLimited(req(false)).Check(t, h)
+		}
+
+		// the per-account budget is tracked per client IP, so the authenticated
+		// user on a different host still has the full budget of 5 requests
+		for range 5 {
+			req(true).Check(t, h)
+		}
+		rateLimited(req(true)).Check(t, h)
+	})
+}
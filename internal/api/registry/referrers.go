@@ -0,0 +1,132 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package registryv2
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	imgspecs "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/api"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var referrersListQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM manifests WHERE repo_id = $1 AND subject_digest = $2 ORDER BY digest ASC
+`)
+
+// This implements the GET /v2/<repo>/referrers/<digest> endpoint.
+func (a *API) handleListReferrers(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/v2/:account/:repo/referrers/:digest")
+	account, repo, authz := a.checkAccountAccess(w, r, failIfRepoMissing, nil)
+	if account == nil {
+		return
+	}
+
+	err := api.CheckRateLimit(r, a.rle, *account, authz, keppel.ManifestPullAction, 1)
+	if respondWithError(w, r, err) {
+		return
+	}
+
+	subjectDigest, err := digest.Parse(mux.Vars(r)["digest"])
+	if err != nil {
+		keppel.ErrDigestInvalid.With(err.Error()).WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+
+	// the list of referrers is returned even if the subject manifest does not
+	// exist (yet), since referrers may be pushed before their subject
+	var referrers []models.Manifest
+	_, err = a.db.ReadReplica().Select(&referrers, referrersListQuery, repo.ID, subjectDigest.String())
+	if respondWithError(w, r, err) {
+		return
+	}
+
+	artifactTypeFilter := r.URL.Query().Get("artifactType")
+	descriptors := []imgspecv1.Descriptor{}
+	for _, referrer := range referrers {
+		desc := imgspecv1.Descriptor{
+			MediaType:    referrer.MediaType,
+			Digest:       referrer.Digest,
+			Size:         int64(referrer.SizeBytes), //nolint:gosec // manifests are never that large
+			ArtifactType: referrer.ArtifactType,
+		}
+		// as per the OCI Distribution Spec, the artifactType of a manifest
+		// without explicit artifactType is the media type of its config
+		if desc.ArtifactType == "" {
+			manifestBytes, err := a.getManifestContentFromDB(repo.ID, referrer.Digest)
+			if respondWithError(w, r, err) {
+				return
+			}
+			desc.ArtifactType, err = getConfigMediaType(manifestBytes)
+			if respondWithError(w, r, err) {
+				return
+			}
+		}
+		if artifactTypeFilter != "" && desc.ArtifactType != artifactTypeFilter {
+			continue
+		}
+		desc.Annotations, err = referrer.ParseAnnotations()
+		if respondWithError(w, r, err) {
+			return
+		}
+		descriptors = append(descriptors, desc)
+	}
+
+	if artifactTypeFilter != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	// not using respondwith.JSON() because the response must have the image
+	// index media type instead of "application/json"
+	buf, err := json.Marshal(imgspecv1.Index{
+		Versioned: imgspecs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: descriptors,
+	})
+	if respondWithError(w, r, err) {
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
+
+// Returns the media type of the config blob of the given manifest, or an
+// empty string if the manifest does not have a config blob (e.g. an image index).
+func getConfigMediaType(manifestBytes []byte) (string, error) {
+	var data struct {
+		Config *struct {
+			MediaType string `json:"mediaType"`
+		} `json:"config"`
+	}
+	err := json.Unmarshal(manifestBytes, &data)
+	if err != nil || data.Config == nil {
+		return "", err
+	}
+	return data.Config.MediaType, nil
+}
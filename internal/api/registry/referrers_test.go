@@ -0,0 +1,160 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package registryv2_test
+
+import (
+	"net/http"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestListReferrers(t *testing.T) {
+	testWithPrimary(t, []test.SetupOption{test.WithOCIDistributionSpecV11}, func(s test.Setup) {
+		h := s.Handler
+		token := s.GetToken(t, "repository:test1/foo:pull,push")
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+		path := "/v2/test1/foo/referrers/" + image.Manifest.Digest.String()
+
+		// initially, there are no referrers
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{"Content-Type": imgspecv1.MediaTypeImageIndex},
+			ExpectBody: assert.JSONObject{
+				"schemaVersion": 2,
+				"mediaType":     imgspecv1.MediaTypeImageIndex,
+				"manifests":     []assert.JSONObject{},
+			},
+		}.Check(t, h)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/referrers/sha256:foo",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   test.ErrorCode(keppel.ErrDigestInvalid),
+		}.Check(t, h)
+
+		// pushing a referrer reports that its subject has been processed
+		sbom := test.GenerateReferrerArtifact(image.Manifest, "application/vnd.example.sbom.v1+json", test.NewBytes([]byte("sbom")))
+		for _, blob := range append(sbom.Layers, sbom.Config) {
+			blob.MustUpload(t, s, fooRepoRef)
+		}
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/" + sbom.Manifest.Digest.String(),
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  sbom.Manifest.MediaType,
+			},
+			Body:         assert.ByteData(sbom.Manifest.Contents),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: map[string]string{"OCI-Subject": image.Manifest.Digest.String()},
+		}.Check(t, h)
+		signature := test.GenerateReferrerArtifact(image.Manifest, "application/vnd.example.signature.v1+json", test.NewBytes([]byte("signature")))
+		signature.MustUpload(t, s, fooRepoRef, "")
+
+		// pushing a referrers fallback tag is still allowed, but generates a warning
+		fallbackTagName := keppel.ReferrersFallbackTagName(image.Manifest.Digest)
+		otherImage := test.GenerateImage(test.GenerateExampleLayer(2))
+		for _, blob := range append(otherImage.Layers, otherImage.Config) {
+			blob.MustUpload(t, s, fooRepoRef)
+		}
+		assert.HTTPRequest{
+			Method: "PUT",
+			Path:   "/v2/test1/foo/manifests/" + fallbackTagName,
+			Header: map[string]string{
+				"Authorization": "Bearer " + token,
+				"Content-Type":  otherImage.Manifest.MediaType,
+			},
+			Body:         assert.ByteData(otherImage.Manifest.Contents),
+			ExpectStatus: http.StatusCreated,
+			ExpectHeader: map[string]string{
+				"Warning": `299 - "this registry supports the Referrers API, so referrers fallback tags are not required"`,
+			},
+		}.Check(t, h)
+
+		// both referrers are listed, sorted by digest
+		sbomDesc := assert.JSONObject{
+			"mediaType":    sbom.Manifest.MediaType,
+			"digest":       sbom.Manifest.Digest,
+			"size":         len(sbom.Manifest.Contents),
+			"artifactType": "application/vnd.example.sbom.v1+json",
+		}
+		signatureDesc := assert.JSONObject{
+			"mediaType":    signature.Manifest.MediaType,
+			"digest":       signature.Manifest.Digest,
+			"size":         len(signature.Manifest.Contents),
+			"artifactType": "application/vnd.example.signature.v1+json",
+		}
+		expectedDescs := []assert.JSONObject{sbomDesc, signatureDesc}
+		if signature.Manifest.Digest < sbom.Manifest.Digest {
+			expectedDescs = []assert.JSONObject{signatureDesc, sbomDesc}
+		}
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path,
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectBody: assert.JSONObject{
+				"schemaVersion": 2,
+				"mediaType":     imgspecv1.MediaTypeImageIndex,
+				"manifests":     expectedDescs,
+			},
+		}.Check(t, h)
+
+		// referrers can be filtered by artifact type
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path + "?artifactType=application/vnd.example.sbom.v1%2Bjson",
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{"OCI-Filters-Applied": "artifactType"},
+			ExpectBody: assert.JSONObject{
+				"schemaVersion": 2,
+				"mediaType":     imgspecv1.MediaTypeImageIndex,
+				"manifests":     []assert.JSONObject{sbomDesc},
+			},
+		}.Check(t, h)
+	})
+}
+
+func TestListReferrersDisabled(t *testing.T) {
+	testWithPrimary(t, nil, func(s test.Setup) {
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s, fooRepoRef, "latest")
+
+		// without the OCI Distribution Spec 1.1 additions, the Referrers API is
+		// not available, so clients fall back to the referrers tag schema
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         "/v2/test1/foo/referrers/" + image.Manifest.Digest.String(),
+			Header:       map[string]string{"Authorization": "Bearer " + s.GetToken(t, "repository:test1/foo:pull")},
+			ExpectStatus: http.StatusNotFound,
+		}.Check(t, s.Handler)
+	})
+}
@@ -566,3 +566,84 @@ func TestReplicationFailingOverIntoPullDelegation(t *testing.T) {
 		})
 	})
 }
+
+func TestReplicationProxyCacheRevalidatesTags(t *testing.T) {
+	testWithPrimary(t, nil, func(s1 test.Setup) {
+		image1 := test.GenerateImage(test.GenerateExampleLayer(1))
+		image2 := test.GenerateImage(test.GenerateExampleLayer(2))
+		image1.MustUpload(t, s1, fooRepoRef, "latest")
+
+		testWithReplica(t, s1, "proxy_cache", func(firstPass bool, s2 test.Setup) {
+			h2 := s2.Handler
+			token := s2.GetToken(t, "repository:test1/foo:pull")
+
+			if firstPass {
+				// first pull replicates the tag as usual
+				expectManifestExists(t, h2, token, "test1/foo", image1.Manifest, "latest", nil)
+
+				// when the tag moves upstream, the next pull follows it immediately
+				// (without the proxy cache, the replica would keep serving image1
+				// until the next manifest sync)
+				image2.MustUpload(t, s1, fooRepoRef, "latest")
+				s1.Clock.StepBy(time.Second)
+				expectManifestExists(t, h2, token, "test1/foo", image2.Manifest, "latest", nil)
+
+				// the previous manifest is still there, but not tagged anymore
+				expectManifestExists(t, h2, token, "test1/foo", image1.Manifest, "", nil)
+			} else {
+				// when upstream is unavailable, the last known state of the tag is served
+				expectManifestExists(t, h2, token, "test1/foo", image2.Manifest, "latest", nil)
+			}
+		})
+	})
+}
+
+func TestReplicationWithSharedStorage(t *testing.T) {
+	testWithPrimary(t, []test.SetupOption{test.WithPeerAPI}, func(s1 test.Setup) {
+		// upload image to primary account
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s1, fooRepoRef, "first")
+
+		// set up a replica that uses the same storage backend as the primary
+		s2 := test.NewSetup(t,
+			test.IsSecondaryTo(&s1),
+			test.WithSharedStorage,
+			test.WithAnycast(currentlyWithAnycast),
+			test.WithAccount(models.Account{Name: "test1", AuthTenantID: authTenantID, UpstreamPeerHostName: "registry.example.org"}),
+			test.WithQuotas,
+		)
+
+		// observe which blobs are downloaded from the primary
+		tt := http.DefaultTransport.(*test.RoundTripper)
+		var downloadedBlobPaths []string
+		tt.Handlers[s1.Config.APIPublicHostname] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.URL.Path, "/blobs/") {
+				downloadedBlobPaths = append(downloadedBlobPaths, r.URL.Path)
+			}
+			s1.Handler.ServeHTTP(w, r)
+		})
+		defer func() {
+			tt.Handlers[s1.Config.APIPublicHostname] = s1.Handler
+			tt.Handlers[s2.Config.APIPublicHostname] = nil
+			_, err := s1.DB.Exec(`DELETE FROM peers`)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}()
+
+		// replicating the manifest also replicates the config blob, which is
+		// copied within the storage instead of being downloaded
+		h2 := s2.Handler
+		token := s2.GetToken(t, "repository:test1/foo:pull")
+		expectManifestExists(t, h2, token, "test1/foo", image.Manifest, "first", nil)
+		assert.DeepEqual(t, "downloaded blobs", downloadedBlobPaths, []string(nil))
+		expectBlobExists(t, h2, token, "test1/foo", image.Config, nil)
+
+		// when the layer is pulled by a client, it is streamed through to the
+		// client since the client needs the contents anyway
+		expectBlobExists(t, h2, token, "test1/foo", image.Layers[0], nil)
+		assert.DeepEqual(t, "downloaded blobs", downloadedBlobPaths, []string{
+			"/v2/test1/foo/blobs/" + image.Layers[0].Digest.String(),
+		})
+	})
+}
@@ -78,10 +78,11 @@ func testWithReplica(t *testing.T, s1 test.Setup, strategy string, action func(f
 	switch strategy {
 	case "on_first_use":
 		testAccount.UpstreamPeerHostName = "registry.example.org"
-	case "from_external_on_first_use":
+	case "from_external_on_first_use", "proxy_cache":
 		testAccount.ExternalPeerURL = "registry.example.org/test1"
 		testAccount.ExternalPeerUserName = "replication@registry-secondary.example.org"
 		testAccount.ExternalPeerPassword = test.GetReplicationPassword()
+		testAccount.IsProxyCache = strategy == "proxy_cache"
 	default:
 		t.Fatalf("unknown strategy: %q", strategy)
 	}
@@ -90,7 +90,7 @@ func (a *API) handleListTags(w http.ResponseWriter, r *http.Request) {
 		linkQuery.Set("n", strconv.FormatUint(limit, 10))
 		linkQuery.Set("last", tags[len(tags)-1])
 		linkURL := url.URL{
-			Path:     fmt.Sprintf("/v2/%s/tags/list", repo.FullName()),
+			Path:     fmt.Sprintf("%s/v2/%s/tags/list", a.cfg.APIPathPrefix, repo.FullName()),
 			RawQuery: linkQuery.Encode(),
 		}
 		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, linkURL.String()))
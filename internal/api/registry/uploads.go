@@ -144,7 +144,7 @@ func (a *API) handleStartBlobUpload(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Blob-Upload-Session-Id", upload.UUID)
 	w.Header().Set("Content-Length", "0")
-	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", getRepoNameForURLPath(*repo, authz), upload.UUID))
+	w.Header().Set("Location", a.cfg.APIPathPrefix+fmt.Sprintf("/v2/%s/blobs/uploads/%s", getRepoNameForURLPath(*repo, authz), upload.UUID))
 	w.Header().Set("Range", "0-0")
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -153,7 +153,13 @@ func (a *API) performCrossRepositoryBlobMount(w http.ResponseWriter, r *http.Req
 	// validate source repository
 	sourceRepoName, ok := strings.CutPrefix(sourceRepoFullName, string(account.Name)+"/")
 	if !ok {
-		keppel.ErrUnsupported.With("cannot mount blobs across different accounts").WriteAsRegistryV2ResponseTo(w, r)
+		// mounting from other accounts is only possible on the regular API,
+		// since domain-remapped APIs cannot refer to other accounts
+		if authz.Audience.AccountName != "" {
+			keppel.ErrUnsupported.With("cannot mount blobs across different accounts on a domain-remapped API").WriteAsRegistryV2ResponseTo(w, r)
+			return
+		}
+		a.performCrossAccountBlobMount(w, r, account, targetRepo, authz, sourceRepoFullName, blobDigestStr)
 		return
 	}
 	if !models.RepoNameWithLeadingSlashRx.MatchString("/" + sourceRepoName) {
@@ -175,6 +181,10 @@ func (a *API) performCrossRepositoryBlobMount(w http.ResponseWriter, r *http.Req
 		keppel.ErrDigestInvalid.With(err.Error()).WriteAsRegistryV2ResponseTo(w, r)
 		return
 	}
+	err = keppel.CheckDigestNotBlocked(a.db, account.Name, blobDigest)
+	if respondWithError(w, r, err) {
+		return
+	}
 	blob, err := keppel.FindBlobByRepository(a.db, blobDigest, *sourceRepo)
 	if errors.Is(err, sql.ErrNoRows) {
 		keppel.ErrBlobUnknown.With("blob does not exist in source repository").WriteAsRegistryV2ResponseTo(w, r)
@@ -197,7 +207,86 @@ func (a *API) performCrossRepositoryBlobMount(w http.ResponseWriter, r *http.Req
 	}
 	w.Header().Set("Blob-Upload-Session-Id", uuidV4.String())
 	w.Header().Set("Content-Length", "0")
-	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", getRepoNameForURLPath(targetRepo, authz), blobDigest.String()))
+	w.Header().Set("Location", a.cfg.APIPathPrefix+fmt.Sprintf("/v2/%s/blobs/%s", getRepoNameForURLPath(targetRepo, authz), blobDigest.String()))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (a *API) performCrossAccountBlobMount(w http.ResponseWriter, r *http.Request, targetAccount models.ReducedAccount, targetRepo models.Repository, authz *auth.Authorization, sourceRepoFullName, blobDigestStr string) {
+	if !models.RepoNameWithLeadingSlashRx.MatchString("/" + sourceRepoFullName) {
+		keppel.ErrNameInvalid.With("source repository is invalid").WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+
+	// the user needs pull permission on the source repo (for bearer tokens, this
+	// means that the token must have been issued for both repos; we check this
+	// before looking at the source account to avoid leaking its existence)
+	sourceScope := auth.Scope{
+		ResourceType: "repository",
+		ResourceName: sourceRepoFullName,
+		Actions:      []string{"pull"},
+	}
+	_, rerr := auth.IncomingRequest{
+		HTTPRequest:           r,
+		Scopes:                auth.NewScopeSet(sourceScope),
+		AllowsDomainRemapping: true,
+	}.Authorize(r.Context(), a.cfg, a.ad, a.db)
+	if rerr != nil {
+		rerr.WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+
+	// find source repository
+	sourceRepoScope := sourceScope.ParseRepositoryScope(authz.Audience)
+	sourceAccount, err := keppel.FindReducedAccount(a.db, sourceRepoScope.AccountName)
+	if respondWithError(w, r, err) {
+		return
+	}
+	if sourceAccount == nil {
+		keppel.ErrNameUnknown.With("source account does not exist").WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+	sourceRepo, err := keppel.FindRepository(a.db, sourceRepoScope.RepositoryName, sourceAccount.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		keppel.ErrNameUnknown.With("source repository does not exist").WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+	if respondWithError(w, r, err) {
+		return
+	}
+
+	// validate blob
+	blobDigest, err := digest.Parse(blobDigestStr)
+	if err != nil {
+		keppel.ErrDigestInvalid.With(err.Error()).WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+	err = keppel.CheckDigestNotBlocked(a.db, targetAccount.Name, blobDigest)
+	if respondWithError(w, r, err) {
+		return
+	}
+	blob, err := keppel.FindBlobByRepository(a.db, blobDigest, *sourceRepo)
+	if errors.Is(err, sql.ErrNoRows) {
+		keppel.ErrBlobUnknown.With("blob does not exist in source repository").WriteAsRegistryV2ResponseTo(w, r)
+		return
+	}
+	if respondWithError(w, r, err) {
+		return
+	}
+
+	// copy blob into target account if missing, and create blob mount
+	err = a.processor().MountBlobFromForeignAccount(r.Context(), *blob, *sourceAccount, targetAccount, targetRepo)
+	if respondWithError(w, r, err) {
+		return
+	}
+
+	// the spec wants a Blob-Upload-Session-Id header even though the upload is done, so just make something up
+	uuidV4, err := uuid.NewV4()
+	if respondWithError(w, r, err) {
+		return
+	}
+	w.Header().Set("Blob-Upload-Session-Id", uuidV4.String())
+	w.Header().Set("Content-Length", "0")
+	w.Header().Set("Location", a.cfg.APIPathPrefix+fmt.Sprintf("/v2/%s/blobs/%s", getRepoNameForURLPath(targetRepo, authz), blobDigest.String()))
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -293,7 +382,7 @@ func (a *API) performMonolithicUpload(w http.ResponseWriter, r *http.Request, ac
 	}
 	w.Header().Set("Blob-Upload-Session-Id", uuidV4.String())
 	w.Header().Set("Content-Length", "0")
-	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", getRepoNameForURLPath(repo, authz), blobDigest.String()))
+	w.Header().Set("Location", a.cfg.APIPathPrefix+fmt.Sprintf("/v2/%s/blobs/%s", getRepoNameForURLPath(repo, authz), blobDigest.String()))
 	w.WriteHeader(http.StatusCreated)
 	return true
 }
@@ -305,6 +394,11 @@ func (a *API) handleDeleteBlobUpload(w http.ResponseWriter, r *http.Request) {
 	if account == nil {
 		return
 	}
+	release := a.lockUpload(w, r)
+	if release == nil {
+		return
+	}
+	defer release()
 	upload := a.findUpload(w, r, *repo)
 	if upload == nil {
 		return
@@ -361,13 +455,13 @@ func (a *API) handleGetBlobUpload(w http.ResponseWriter, r *http.Request) {
 	if upload.SizeBytes == 0 {
 		// case 1: if the upload did not have any data sent into it, we can build
 		// the upload URL from our DB alone
-		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s",
+		w.Header().Set("Location", a.cfg.APIPathPrefix+fmt.Sprintf("/v2/%s/blobs/uploads/%s",
 			getRepoNameForURLPath(*repo, authz), upload.UUID,
 		))
 	} else if stateStr := r.URL.Query().Get("state"); stateStr != "" {
 		// case 2: if the upload had data sent into it, we need the hash state
 		// that's included in the Location URL
-		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s?%s",
+		w.Header().Set("Location", a.cfg.APIPathPrefix+fmt.Sprintf("/v2/%s/blobs/uploads/%s?%s",
 			getRepoNameForURLPath(*repo, authz), upload.UUID, url.Values{"state": {stateStr}}.Encode(),
 		))
 	}
@@ -382,10 +476,29 @@ func (a *API) handleContinueBlobUpload(w http.ResponseWriter, r *http.Request) {
 	if account == nil {
 		return
 	}
+	release := a.lockUpload(w, r)
+	if release == nil {
+		return
+	}
+	defer release()
 	upload := a.findUpload(w, r, *repo)
 	if upload == nil {
 		return
 	}
+
+	// if the client provided the digest of this chunk, we will verify the chunk
+	// contents against it, so that a corrupted chunk is rejected immediately
+	// instead of only when the upload is finalized
+	var chunkDigest digest.Digest
+	if chunkDigestStr := r.URL.Query().Get("digest"); chunkDigestStr != "" {
+		var err error
+		chunkDigest, err = digest.Parse(chunkDigestStr)
+		if err != nil {
+			keppel.ErrDigestInvalid.With(err.Error()).WriteAsRegistryV2ResponseTo(w, r)
+			return
+		}
+	}
+
 	dw, rerr := a.resumeUpload(r.Context(), *account, upload, r.URL.Query().Get("state"))
 	if rerr != nil {
 		rerr.WriteAsRegistryV2ResponseTo(w, r)
@@ -416,7 +529,7 @@ func (a *API) handleContinueBlobUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// append request body to upload
-	digestState, err := a.streamIntoUpload(r.Context(), *account, upload, dw, r.Body, chunkSizeBytes)
+	digestState, err := a.streamIntoUpload(r.Context(), *account, upload, dw, r.Body, chunkSizeBytes, chunkDigest)
 	if respondWithError(w, r, err) {
 		return
 	}
@@ -425,7 +538,7 @@ func (a *API) handleContinueBlobUpload(w http.ResponseWriter, r *http.Request) {
 	query.Set("state", digestState)
 	w.Header().Set("Blob-Upload-Session-Id", upload.UUID)
 	w.Header().Set("Content-Length", "0")
-	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s?%s", getRepoNameForURLPath(*repo, authz), upload.UUID, query.Encode()))
+	w.Header().Set("Location", a.cfg.APIPathPrefix+fmt.Sprintf("/v2/%s/blobs/uploads/%s?%s", getRepoNameForURLPath(*repo, authz), upload.UUID, query.Encode()))
 	w.Header().Set("Range", makeRangeHeader(upload.SizeBytes))
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -437,6 +550,11 @@ func (a *API) handleFinishBlobUpload(w http.ResponseWriter, r *http.Request) {
 	if account == nil {
 		return
 	}
+	release := a.lockUpload(w, r)
+	if release == nil {
+		return
+	}
+	defer release()
 	upload := a.findUpload(w, r, *repo)
 	if upload == nil {
 		return
@@ -457,7 +575,7 @@ func (a *API) handleFinishBlobUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if contentLength > 0 {
-			_, err = a.streamIntoUpload(r.Context(), *account, upload, dw, r.Body, &contentLength)
+			_, err = a.streamIntoUpload(r.Context(), *account, upload, dw, r.Body, &contentLength, "")
 			if respondWithError(w, r, err) {
 				return
 			}
@@ -500,10 +618,27 @@ func (a *API) handleFinishBlobUpload(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Length", "0")
 	w.Header().Set("Content-Range", makeRangeHeader(blob.SizeBytes))
 	w.Header().Set("Docker-Content-Digest", blob.Digest.String())
-	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", getRepoNameForURLPath(*repo, authz), blob.Digest))
+	w.Header().Set("Location", a.cfg.APIPathPrefix+fmt.Sprintf("/v2/%s/blobs/%s", getRepoNameForURLPath(*repo, authz), blob.Digest))
 	w.WriteHeader(http.StatusCreated)
 }
 
+// lockUpload ensures that concurrent requests for the same upload do not
+// interfere with each other, even when they are handled by different
+// keppel-api instances. If nil is returned, an error response has been written.
+func (a *API) lockUpload(w http.ResponseWriter, r *http.Request) (release func()) {
+	release, err := a.uc.LockUpload(r.Context(), mux.Vars(r)["uuid"])
+	if errors.Is(err, keppel.ErrUploadLocked) {
+		// same reasoning for 429 as for concurrent blob replication in handleGetOrHeadBlob()
+		w.Header().Set("Retry-After", "5")
+		keppel.ErrTooManyRequests.With(err.Error()+", please retry in a few seconds").WriteAsRegistryV2ResponseTo(w, r)
+		return nil
+	}
+	if respondWithError(w, r, err) {
+		return nil
+	}
+	return release
+}
+
 func (a *API) findUpload(w http.ResponseWriter, r *http.Request, repo models.Repository) *models.Upload {
 	uploadUUID := mux.Vars(r)["uuid"]
 
@@ -615,7 +750,15 @@ func (a *API) parseContentRange(upload *models.Upload, hdr http.Header) (uint64,
 	return length, nil
 }
 
-func (a *API) streamIntoUpload(ctx context.Context, account models.ReducedAccount, upload *models.Upload, dw *digestWriter, chunk io.Reader, chunkSizeBytes *uint64) (digestState string, returnErr error) {
+// The condition on num_chunks ensures that we do not overwrite the result of
+// a concurrent request that appended to the same upload.
+var updateUploadQuery = sqlext.SimplifyWhitespace(`
+	UPDATE uploads SET size_bytes = $1, digest = $2, num_chunks = $3, updated_at = $4
+	 WHERE repo_id = $5 AND uuid = $6 AND num_chunks = $7
+`)
+
+// If expectedChunkDigest is not empty, the chunk contents are verified against it.
+func (a *API) streamIntoUpload(ctx context.Context, account models.ReducedAccount, upload *models.Upload, dw *digestWriter, chunk io.Reader, chunkSizeBytes *uint64, expectedChunkDigest digest.Digest) (digestState string, returnErr error) {
 	// if anything happens during this operation, we likely have produced an
 	// inconsistent state between DB, storage backend and our internal book
 	// keeping (esp. the digestState in dw.Hash), so we will have to abort the
@@ -635,8 +778,15 @@ func (a *API) streamIntoUpload(ctx context.Context, account models.ReducedAccoun
 		}
 	}()
 
+	var chunkVerifier digest.Verifier
+	if expectedChunkDigest != "" {
+		chunkVerifier = expectedChunkDigest.Verifier()
+		chunk = io.TeeReader(chunk, chunkVerifier)
+	}
+
 	// stream data from request body into storage
 	sizeBytesBefore := upload.SizeBytes
+	numChunksBefore := upload.NumChunks
 	err := a.processor().AppendToBlob(ctx, account, upload, io.TeeReader(chunk, dw), chunkSizeBytes)
 	if err != nil {
 		return "", err
@@ -651,6 +801,11 @@ func (a *API) streamIntoUpload(ctx context.Context, account models.ReducedAccoun
 		return "", keppel.ErrSizeInvalid.With(msg).WithStatus(http.StatusRequestedRangeNotSatisfiable)
 	}
 
+	// if the chunk digest is known, check that the chunk was not corrupted in transit
+	if chunkVerifier != nil && !chunkVerifier.Verified() {
+		return "", keppel.ErrDigestInvalid.With("chunk contents do not match the provided digest")
+	}
+
 	// serialize digest state for next resumeUpload() - note that we do this
 	// BEFORE digest.NewDigest() because digest.NewDigest() may alter the
 	// internal state of `dw.Hash`
@@ -662,10 +817,23 @@ func (a *API) streamIntoUpload(ctx context.Context, account models.ReducedAccoun
 	// update Upload object in DB
 	upload.Digest = digest.NewDigest(digest.SHA256, dw.Hash).String()
 	upload.UpdatedAt = a.timeNow()
-	_, err = a.db.Update(upload)
+	result, err := a.db.Exec(updateUploadQuery,
+		upload.SizeBytes, upload.Digest, upload.NumChunks, upload.UpdatedAt,
+		upload.RepositoryID, upload.UUID, numChunksBefore)
 	if err != nil {
 		return "", err
 	}
+	rowsUpdated, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rowsUpdated == 0 {
+		// this can only happen if concurrent requests for the same upload were
+		// not serialized by lockUpload() (e.g. because Redis is not configured);
+		// the chunks in the storage are inconsistent now, so the upload needs to
+		// be aborted
+		return "", keppel.ErrBlobUploadInvalid.With("upload was modified by a concurrent request")
+	}
 
 	return base64.URLEncoding.EncodeToString(digestStateBytes), nil
 }
@@ -682,6 +850,10 @@ func (a *API) createBlobFromUpload(ctx context.Context, account models.ReducedAc
 	if blobDigest.String() != upload.Digest {
 		return nil, keppel.ErrDigestInvalid.With("")
 	}
+	err = keppel.CheckDigestNotBlocked(a.db, account.Name, blobDigest)
+	if err != nil {
+		return nil, err
+	}
 
 	// prepare database changes
 	tx, err := a.db.Begin()
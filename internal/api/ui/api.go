@@ -0,0 +1,272 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package uiapi
+
+import (
+	"bytes"
+	"database/sql"
+	"embed"
+	"errors"
+	"html/template"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/httpapi"
+	"github.com/sapcc/go-bits/respondwith"
+
+	"github.com/sapcc/keppel/internal/auth"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"formatTime": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}).ParseFS(templateFS, "templates/*.html"))
+
+// API contains state variables used by the embedded web UI. This is a minimal
+// read-only UI that shows those accounts, repositories and manifests that the
+// requesting user is allowed to pull from.
+type API struct {
+	cfg       keppel.Configuration
+	ad        keppel.AuthDriver
+	db        *keppel.DB
+	isEnabled bool
+}
+
+// NewAPI constructs a new API instance.
+func NewAPI(cfg keppel.Configuration, ad keppel.AuthDriver, db *keppel.DB, isEnabled bool) *API {
+	return &API{cfg, ad, db, isEnabled}
+}
+
+// AddTo implements the api.API interface.
+func (a *API) AddTo(r *mux.Router) {
+	// check if this feature is enabled
+	if !a.isEnabled {
+		return
+	}
+
+	r.Methods("GET").Path("/ui").Handler(http.RedirectHandler(a.cfg.APIPathPrefix+"/ui/", http.StatusMovedPermanently))
+	r.Methods("GET").Path("/ui/").HandlerFunc(a.handleGetAccounts)
+	r.Methods("GET").Path("/ui/{account:[a-z0-9-]{1,48}}").HandlerFunc(a.handleGetAccount)
+	r.Methods("GET").Path("/ui/{account:[a-z0-9-]{1,48}}/{repo_name:.+}").HandlerFunc(a.handleGetRepository)
+}
+
+// Returns the subset of the given repos that the requesting user may pull from.
+// If false is returned, an error was written into `w` and the caller shall return.
+func (a *API) filterVisibleRepos(w http.ResponseWriter, r *http.Request, repos []models.Repository) ([]models.Repository, bool) {
+	scopeFor := func(repo models.Repository) auth.Scope {
+		return auth.Scope{
+			ResourceType: "repository",
+			ResourceName: repo.FullName(),
+			Actions:      []string{"pull"},
+		}
+	}
+
+	var ss auth.ScopeSet
+	for _, repo := range repos {
+		ss.Add(scopeFor(repo))
+	}
+	authz, rerr := auth.IncomingRequest{
+		HTTPRequest:          r,
+		Scopes:               ss,
+		CorrectlyReturn403:   true,
+		PartialAccessAllowed: true,
+	}.Authorize(r.Context(), a.cfg, a.ad, a.db)
+	if rerr != nil {
+		rerr.WriteAsTextTo(w)
+		return nil, false
+	}
+
+	var result []models.Repository
+	for _, repo := range repos {
+		if authz.ScopeSet.Contains(scopeFor(repo)) {
+			result = append(result, repo)
+		}
+	}
+	return result, true
+}
+
+func (a *API) render(w http.ResponseWriter, templateName string, data map[string]any) {
+	// all links need to include the path prefix if we are mounted below one
+	data["PathPrefix"] = a.cfg.APIPathPrefix
+
+	// render into a buffer first, so that we can still report errors properly
+	var buf bytes.Buffer
+	err := templates.ExecuteTemplate(&buf, templateName, data)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+type accountSummary struct {
+	Name      models.AccountName
+	RepoCount int
+}
+
+func (a *API) handleGetAccounts(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/ui/")
+	var repos []models.Repository
+	_, err := a.db.ReadReplica().Select(&repos, `SELECT * FROM repos ORDER BY account_name, name`)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	repos, ok := a.filterVisibleRepos(w, r, repos)
+	if !ok {
+		return
+	}
+
+	var accounts []accountSummary
+	for _, repo := range repos {
+		if len(accounts) == 0 || accounts[len(accounts)-1].Name != repo.AccountName {
+			accounts = append(accounts, accountSummary{Name: repo.AccountName})
+		}
+		accounts[len(accounts)-1].RepoCount++
+	}
+
+	a.render(w, "accounts.html", map[string]any{
+		"Accounts": accounts,
+	})
+}
+
+func (a *API) handleGetAccount(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/ui/:account")
+	accountName := models.AccountName(mux.Vars(r)["account"])
+	var repos []models.Repository
+	_, err := a.db.ReadReplica().Select(&repos, `SELECT * FROM repos WHERE account_name = $1 ORDER BY name`, accountName)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	repos, ok := a.filterVisibleRepos(w, r, repos)
+	if !ok {
+		return
+	}
+
+	// to avoid leaking which accounts exist, accounts without visible repos
+	// look the same as nonexistent accounts
+	if len(repos) == 0 {
+		http.Error(w, "account not found", http.StatusNotFound)
+		return
+	}
+
+	a.render(w, "account.html", map[string]any{
+		"AccountName":  accountName,
+		"Repositories": repos,
+	})
+}
+
+type manifestSummary struct {
+	Digest              digest.Digest
+	MediaType           string
+	SizeBytes           uint64
+	PushedAt            time.Time
+	Tags                []string
+	VulnerabilityStatus models.VulnerabilityStatus
+}
+
+func (a *API) handleGetRepository(w http.ResponseWriter, r *http.Request) {
+	httpapi.IdentifyEndpoint(r, "/ui/:account/:repo")
+	accountName := models.AccountName(mux.Vars(r)["account"])
+	var repos []models.Repository
+	repo, err := keppel.FindRepository(a.db, mux.Vars(r)["repo_name"], accountName)
+	switch {
+	case err == nil:
+		repos = []models.Repository{*repo}
+	case errors.Is(err, sql.ErrNoRows):
+		// same as for accounts, nonexistent repos look the same as invisible repos
+	default:
+		respondwith.ErrorText(w, err)
+		return
+	}
+	repos, ok := a.filterVisibleRepos(w, r, repos)
+	if !ok {
+		return
+	}
+	if len(repos) == 0 {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	var manifests []models.Manifest
+	_, err = a.db.Select(&manifests, `SELECT * FROM manifests WHERE repo_id = $1 ORDER BY pushed_at DESC, digest`, repo.ID)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	var tags []models.Tag
+	_, err = a.db.Select(&tags, `SELECT * FROM tags WHERE repo_id = $1 ORDER BY name`, repo.ID)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+	var securityInfos []models.TrivySecurityInfo
+	_, err = a.db.Select(&securityInfos, `SELECT * FROM trivy_security_info WHERE repo_id = $1`, repo.ID)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	tagsByDigest := make(map[digest.Digest][]string)
+	for _, tag := range tags {
+		tagsByDigest[tag.Digest] = append(tagsByDigest[tag.Digest], tag.Name)
+	}
+	vulnStatusByDigest := make(map[digest.Digest]models.VulnerabilityStatus)
+	for _, securityInfo := range securityInfos {
+		vulnStatusByDigest[securityInfo.Digest] = securityInfo.VulnerabilityStatus
+	}
+
+	var summaries []manifestSummary
+	for _, manifest := range manifests {
+		// manifests in quarantine are not visible to regular users
+		if !manifest.QuarantineStatus.IsPullable() {
+			continue
+		}
+		summaries = append(summaries, manifestSummary{
+			Digest:              manifest.Digest,
+			MediaType:           manifest.MediaType,
+			SizeBytes:           manifest.SizeBytes,
+			PushedAt:            manifest.PushedAt,
+			Tags:                tagsByDigest[manifest.Digest],
+			VulnerabilityStatus: vulnStatusByDigest[manifest.Digest],
+		})
+	}
+	// show tagged manifests first
+	slices.SortStableFunc(summaries, func(lhs, rhs manifestSummary) int {
+		switch {
+		case len(lhs.Tags) > 0 && len(rhs.Tags) == 0:
+			return -1
+		case len(lhs.Tags) == 0 && len(rhs.Tags) > 0:
+			return +1
+		default:
+			return 0
+		}
+	})
+
+	a.render(w, "repository.html", map[string]any{
+		"AccountName": accountName,
+		"Repository":  *repo,
+		"Manifests":   summaries,
+		"PullPrefix":  a.cfg.APIPublicHostname + "/" + repo.FullName(),
+	})
+}
@@ -0,0 +1,110 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package uiapi_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/easypg"
+	"github.com/sapcc/go-bits/httpapi"
+
+	uiapi "github.com/sapcc/keppel/internal/api/ui"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestMain(m *testing.M) {
+	easypg.WithTestDB(m, func() int { return m.Run() })
+}
+
+func TestEmbeddedGUI(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithAccount(models.Account{
+			Name:             "test1",
+			AuthTenantID:     "test1authtenant",
+			RBACPoliciesJSON: `[{"match_repository":"public","permissions":["anonymous_pull"]}]`,
+		}),
+		test.WithRepo(models.Repository{AccountName: "test1", Name: "private"}),
+		test.WithRepo(models.Repository{AccountName: "test1", Name: "public"}),
+		test.WithQuotas,
+	)
+	h := httpapi.Compose(uiapi.NewAPI(s.Config, s.AD, s.DB, true))
+
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, *s.Repos[1], "latest")
+
+	expectPage := func(path string, header map[string]string, expectedSnippets ...string) {
+		t.Helper()
+		_, body := assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path,
+			Header:       header,
+			ExpectStatus: http.StatusOK,
+			ExpectHeader: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		}.Check(t, h)
+		for _, snippet := range expectedSnippets {
+			if !strings.Contains(string(body), snippet) {
+				t.Errorf("expected %s to contain %q, but got: %s", path, snippet, string(body))
+			}
+		}
+	}
+
+	// anonymous users only see repos that allow anonymous pulling
+	expectPage("/ui/", nil, `<a href="/ui/test1">test1</a></td><td>1</td>`)
+	expectPage("/ui/test1", nil, `<a href="/ui/test1/public">public</a>`)
+	expectPage("/ui/test1/public", nil,
+		`<span class="tag">latest</span>`,
+		image.Manifest.Digest.String(),
+		"docker pull registry.example.org/test1/public:&lt;tag&gt;",
+	)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/ui/test1/private",
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("repository not found\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/ui/test1/unknown",
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("repository not found\n"),
+	}.Check(t, h)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/ui/test2",
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody:   assert.StringData("account not found\n"),
+	}.Check(t, h)
+
+	// users with pull permission see all repos in the account
+	pullHeader := map[string]string{"X-Test-Perms": "pull:test1authtenant"}
+	expectPage("/ui/", pullHeader, `<a href="/ui/test1">test1</a></td><td>2</td>`)
+	expectPage("/ui/test1", pullHeader, `<a href="/ui/test1/private">private</a>`, `<a href="/ui/test1/public">public</a>`)
+	expectPage("/ui/test1/private", pullHeader, "This repository does not contain any manifests.")
+
+	// the UI is not available unless enabled
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/ui/",
+		ExpectStatus: http.StatusNotFound,
+	}.Check(t, httpapi.Compose(uiapi.NewAPI(s.Config, s.AD, s.DB, false)))
+}
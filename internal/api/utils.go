@@ -21,6 +21,7 @@ package api
 import (
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sapcc/go-bits/httpext"
@@ -30,6 +31,27 @@ import (
 	"github.com/sapcc/keppel/internal/models"
 )
 
+// Clients are asked to retry writes after this long when the account or the
+// entire Keppel instance is in read-only mode.
+const readOnlyModeRetryAfter = 5 * time.Minute
+
+// CheckReadOnlyMode returns an error if writes into the given account are not
+// allowed right now because the account or the entire Keppel instance is in
+// read-only mode.
+func CheckReadOnlyMode(cfg keppel.Configuration, account models.ReducedAccount) *keppel.RegistryV2Error {
+	var msg string
+	switch {
+	case cfg.ReadOnlyMode:
+		msg = "registry is in read-only mode for maintenance"
+	case account.IsReadOnly:
+		msg = "account is in read-only mode for maintenance"
+	default:
+		return nil
+	}
+	retryAfterStr := strconv.FormatInt(int64(readOnlyModeRetryAfter/time.Second), 10)
+	return keppel.ErrUnavailable.With(msg).WithHeader("Retry-After", retryAfterStr)
+}
+
 func CheckRateLimit(r *http.Request, rle *keppel.RateLimitEngine, account models.ReducedAccount, authz *auth.Authorization, action keppel.RateLimitedAction, amount uint64) error {
 	// rate-limiting is optional
 	if rle == nil {
@@ -44,14 +66,43 @@ func CheckRateLimit(r *http.Request, rle *keppel.RateLimitEngine, account models
 		return nil
 	}
 
-	allowed, result, err := rle.RateLimitAllows(r.Context(), httpext.GetRequesterIPFor(r), account, action, amount)
-	if err != nil {
-		return err
+	// anonymous pulls are additionally limited per client IP across all
+	// accounts (this is checked first, so that requests rejected by this limit
+	// do not also use up the per-account budget of the same client IP, which
+	// authenticated requests from that IP count towards as well)
+	var actions []keppel.RateLimitedAction
+	if userType == keppel.AnonymousUser {
+		anonAction, ok := action.AnonymousVariant()
+		if ok {
+			actions = append(actions, anonAction)
+		}
 	}
-	if !allowed {
-		retryAfterStr := strconv.FormatUint(keppel.AtLeastZero(int64(result.RetryAfter/time.Second)), 10)
-		return keppel.ErrTooManyRequests.With("").WithHeader("Retry-After", retryAfterStr)
+	actions = append(actions, action)
+
+	remoteAddr := httpext.GetRequesterIPFor(r)
+	for _, action := range actions {
+		allowed, result, err := rle.RateLimitAllows(r.Context(), remoteAddr, account, action, amount)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			retryAfterStr := strconv.FormatUint(keppel.AtLeastZero(int64(result.RetryAfter/time.Second)), 10)
+			return keppel.ErrTooManyRequests.With("").WithHeader("Retry-After", retryAfterStr)
+		}
 	}
 
 	return nil
 }
+
+// ETagListContains checks whether the value of an If-None-Match header matches
+// the given ETag. As required by RFC 9110, section 13.1.2, this uses weak
+// comparison.
+func ETagListContains(headerValue, etag string) bool {
+	for _, candidate := range strings.Split(headerValue, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -35,8 +35,12 @@ import (
 type Audience struct {
 	IsAnycast bool
 	// When using a domain-remapped API, contains the account name specified in the domain name.
+	// When using a vanity domain, contains the account name that the vanity domain belongs to.
 	// Otherwise, contains the empty string.
 	AccountName models.AccountName
+	// When using a vanity domain (see type models.VanityDomain), contains its hostname.
+	// Otherwise, contains the empty string. Vanity domains are never anycast.
+	VanityHostname string
 }
 
 // IdentifyAudience returns the Audience corresponding to the given domain name.
@@ -77,12 +81,34 @@ func IdentifyAudience(hostname string, cfg keppel.Configuration) Audience {
 	return Audience{IsAnycast: false, AccountName: ""}
 }
 
+// ResolveAudience is like IdentifyAudience, but also recognizes vanity
+// domains. Since this requires a database lookup, the lookup is only performed
+// for hostnames that are not recognized by IdentifyAudience.
+func ResolveAudience(hostname string, cfg keppel.Configuration, db *keppel.DB) (Audience, error) {
+	audience := IdentifyAudience(hostname, cfg)
+	if hostname == "" || audience.Hostname(cfg) == hostname {
+		return audience, nil
+	}
+
+	hostname = strings.ToLower(hostname)
+	accountName, err := db.ReadReplica().SelectStr(`SELECT account_name FROM vanity_domains WHERE hostname = $1`, hostname)
+	if err != nil || accountName == "" {
+		return audience, err
+	}
+	return Audience{IsAnycast: false, AccountName: models.AccountName(accountName), VanityHostname: hostname}, nil
+}
+
 // Hostname returns the hostname that is used as the "audience" value in tokens
 // and as the "service" value in auth challenges. This is the inverse operation
 // of IdentifyAudience in the following sense:
 //
 //	audience == IdentifyAudience(audience.Hostname(cfg), cfg)
+//
+// For audiences with a vanity domain, the same applies to ResolveAudience instead.
 func (a Audience) Hostname(cfg keppel.Configuration) string {
+	if a.VanityHostname != "" {
+		return a.VanityHostname
+	}
 	result := cfg.APIPublicHostname
 	if a.IsAnycast {
 		result = cfg.AnycastAPIPublicHostname
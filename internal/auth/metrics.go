@@ -0,0 +1,39 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package auth
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// TokensValidatedByPreviousIssuerKeyCounter is a prometheus.CounterVec.
+	TokensValidatedByPreviousIssuerKeyCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_tokens_validated_by_previous_issuer_key",
+			Help: "Counts tokens that were successfully validated with one of the previous issuer keys instead of the current one.",
+		},
+		[]string{"audience", "key_index"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(TokensValidatedByPreviousIssuerKeyCounter)
+}
@@ -26,8 +26,10 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
 )
 
 // IncomingRequest describes everything we need to know about an incoming API
@@ -61,6 +63,17 @@ type IncomingRequest struct {
 // Authorize checks if the given incoming request has a proper Authorization.
 // If an error is returned, the given `errHeaders` must be added to the HTTP response.
 func (ir IncomingRequest) Authorize(ctx context.Context, cfg keppel.Configuration, ad keppel.AuthDriver, db *keppel.DB) (*Authorization, *keppel.RegistryV2Error) {
+	ctx, endSpan := keppel.StartSpan(ctx, "auth.Authorize")
+	authz, rerr := ir.authorize(ctx, cfg, ad, db)
+	var err error
+	if rerr != nil {
+		err = rerr
+	}
+	endSpan(&err)
+	return authz, rerr
+}
+
+func (ir IncomingRequest) authorize(ctx context.Context, cfg keppel.Configuration, ad keppel.AuthDriver, db *keppel.DB) (*Authorization, *keppel.RegistryV2Error) {
 	r := ir.HTTPRequest
 
 	// find audience
@@ -69,7 +82,11 @@ func (ir IncomingRequest) Authorize(ctx context.Context, cfg keppel.Configuratio
 		audience = *ir.AudienceForTokenIssuance
 	} else {
 		u := keppel.OriginalRequestURL(r)
-		audience = IdentifyAudience(u.Hostname(), cfg)
+		var err error
+		audience, err = ResolveAudience(u.Hostname(), cfg, db)
+		if err != nil {
+			return nil, keppel.AsRegistryV2Error(err)
+		}
 
 		// special case: an anycast request was explicitly reverse-proxied to our
 		// non-anycast API by the keppel-api that originally received it
@@ -133,6 +150,15 @@ func (ir IncomingRequest) Authorize(ctx context.Context, cfg keppel.Configuratio
 		tokenFound = true
 		allowChallenge = true
 
+	case authHeader == "" && r.URL.Query().Has(SignedURLQueryParameter):
+		// token from a signed URL (see IssueSignedURLToken)
+		var rerr *keppel.RegistryV2Error
+		authz, rerr = parseSignedURLToken(cfg, ad, audience, r)
+		if rerr != nil {
+			return nil, rerr
+		}
+		tokenFound = true
+
 	case authHeader == "" || authHeader == "keppel":
 		// possibly a request for driver auth, but fallback on AnonymousUserIdentity
 		// if driver auth does not detect any matching headers
@@ -206,10 +232,16 @@ func (ir IncomingRequest) buildAuthChallenge(cfg keppel.Configuration, audience
 	requestURL := keppel.OriginalRequestURL(ir.HTTPRequest)
 	apiURL := (&url.URL{Scheme: requestURL.Scheme, Host: requestURL.Host})
 
-	fields := fmt.Sprintf(
-		`realm="%s/keppel/v1/auth",service="%s"`,
-		apiURL, audience.Hostname(cfg),
-	)
+	realm := apiURL.String() + cfg.APIPathPrefix + "/keppel/v1/auth"
+	service := audience.Hostname(cfg)
+	if override, ok := cfg.AuthRealmOverrides[ir.challengedAccountName(audience)]; ok {
+		realm = override.Realm
+		if override.Service != "" {
+			service = override.Service
+		}
+	}
+
+	fields := fmt.Sprintf(`realm="%s",service="%s"`, realm, service)
 	for _, scope := range ir.Scopes {
 		if !scope.Contains(InfoAPIScope) {
 			fields += fmt.Sprintf(`,scope="%s"`, scope)
@@ -221,6 +253,37 @@ func (ir IncomingRequest) buildAuthChallenge(cfg keppel.Configuration, audience
 	return "Bearer " + fields
 }
 
+// Returns the name of the account that all scopes of this request refer to, or
+// "" if the request does not refer to exactly one account. Auth realm overrides
+// are not applied on the anycast API since tokens for it must be signed with
+// the anycast issuer keys.
+func (ir IncomingRequest) challengedAccountName(audience Audience) models.AccountName {
+	if audience.IsAnycast {
+		return ""
+	}
+	if audience.AccountName != "" {
+		return audience.AccountName
+	}
+
+	var result models.AccountName
+	for _, scope := range ir.Scopes {
+		var accountName models.AccountName
+		switch scope.ResourceType {
+		case "repository":
+			accountName = scope.ParseRepositoryScope(audience).AccountName
+		case "keppel_account":
+			accountName = models.AccountName(scope.ResourceName)
+		default:
+			continue
+		}
+		if result != "" && result != accountName {
+			return ""
+		}
+		result = accountName
+	}
+	return result
+}
+
 var errMalformedAuthHeader = keppel.ErrUnauthorized.With("malformed Authorization header")
 
 func checkBasicAuth(ctx context.Context, authHeader string, ad keppel.AuthDriver, db *keppel.DB) (keppel.UserIdentity, error) {
@@ -248,6 +311,22 @@ func checkBasicAuth(ctx context.Context, authHeader string, ad keppel.AuthDriver
 		return &PeerUserIdentity{PeerHostName: peerHostName}, nil
 	}
 
+	// recognize robot token credentials
+	if strings.HasPrefix(userName, robotUserNamePrefix) {
+		token, err := checkRobotCredentials(db, userName, password, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if token == nil {
+			return nil, keppel.ErrUnauthorized.With("invalid robot token credentials")
+		}
+		return &RobotUserIdentity{
+			TokenID:        token.ID,
+			AccountName:    token.AccountName,
+			RepositoryName: token.RepositoryName,
+		}, nil
+	}
+
 	// recognize regular user credentials
 	uid, rerr := ad.AuthenticateUser(ctx, userName, password)
 	return uid, safelyReturnRegistryError(rerr)
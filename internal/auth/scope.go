@@ -132,6 +132,22 @@ var PeerAPIScope = Scope{
 	Actions:      []string{"access"},
 }
 
+// ClusterStatusScope is the Scope for endpoints that show the status of the
+// Keppel deployment as a whole, e.g. `GET /keppel/v1/jobs`.
+var ClusterStatusScope = Scope{
+	ResourceType: "keppel_api",
+	ResourceName: "cluster",
+	Actions:      []string{"view"},
+}
+
+// ClusterConfigScope is the Scope for endpoints that change the configuration
+// of the Keppel deployment as a whole, e.g. `PUT /keppel/v1/blocked_digests/:digest`.
+var ClusterConfigScope = Scope{
+	ResourceType: "keppel_api",
+	ResourceName: "cluster",
+	Actions:      []string{"change"},
+}
+
 // InfoAPIScope is the Scope for all informational endpoints that are allowed for all non-anon users.
 var InfoAPIScope = Scope{
 	ResourceType: "keppel_api",
@@ -0,0 +1,64 @@
+/*******************************************************************************
+*
+* Copyright 2026 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// SignedURLQueryParameter is the name of the query parameter that carries the
+// token in a signed URL (see IssueSignedURLToken).
+const SignedURLQueryParameter = "X-Keppel-Signed-Token"
+
+// IssueSignedURLToken is like IssueTokenWithExpires, but the resulting token
+// is only valid for GET and HEAD requests for the given URL path, and only
+// when given in the query parameter SignedURLQueryParameter instead of an
+// Authorization header. This allows for handing out URLs for pulling a
+// particular manifest or blob to clients that cannot hold credentials.
+func (a Authorization) IssueSignedURLToken(cfg keppel.Configuration, urlPath string, expiresIn time.Duration) (*TokenResponse, error) {
+	return a.issueToken(cfg, expiresIn, urlPath)
+}
+
+// SignURL appends the given token to the given URL as SignedURLQueryParameter.
+func SignURL(u url.URL, token string) string {
+	query := u.Query()
+	query.Set(SignedURLQueryParameter, token)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func parseSignedURLToken(cfg keppel.Configuration, ad keppel.AuthDriver, audience Audience, r *http.Request) (*Authorization, *keppel.RegistryV2Error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil, keppel.ErrUnauthorized.With("signed URLs can only be used for GET and HEAD requests")
+	}
+
+	claims, rerr := parseTokenClaims(cfg, ad, audience, r.URL.Query().Get(SignedURLQueryParameter))
+	if rerr != nil {
+		return nil, rerr
+	}
+	if claims.URLPath == "" || claims.URLPath != r.URL.Path {
+		return nil, keppel.ErrUnauthorized.With("token is not valid for this URL")
+	}
+	return claims.authorization(audience), nil
+}
@@ -27,6 +27,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
@@ -45,15 +46,32 @@ type tokenClaims struct {
 	jwt.RegisteredClaims
 	Access   []Scope              `json:"access"`
 	Embedded embeddedUserIdentity `json:"kea"` // kea = keppel embedded authorization ("UserIdentity" used to be called "Authorization")
+	// only set for tokens that are embedded in signed URLs (see IssueSignedURLToken)
+	URLPath string `json:"kup,omitempty"` // kup = keppel URL path
 }
 
 func parseToken(cfg keppel.Configuration, ad keppel.AuthDriver, audience Audience, tokenStr string) (*Authorization, *keppel.RegistryV2Error) {
+	claims, rerr := parseTokenClaims(cfg, ad, audience, tokenStr)
+	if rerr != nil {
+		return nil, rerr
+	}
+	// tokens from signed URLs are only valid for their respective URL, so they
+	// must not be accepted as bearer tokens
+	if claims.URLPath != "" {
+		return nil, keppel.ErrUnauthorized.With("token is only valid as part of a signed URL")
+	}
+	return claims.authorization(audience), nil
+}
+
+func parseTokenClaims(cfg keppel.Configuration, ad keppel.AuthDriver, audience Audience, tokenStr string) (*tokenClaims, *keppel.RegistryV2Error) {
 	// this function is used by jwt.ParseWithClaims() to select which public key to use for validation
+	usedKeyIndex := -1
 	keyFunc := func(t *jwt.Token) (any, error) {
 		// check the token header to see which key we used for signing
 		ourIssuerKeys := audience.IssuerKeys(cfg)
-		for _, ourIssuerKey := range ourIssuerKeys {
+		for idx, ourIssuerKey := range ourIssuerKeys {
 			if t.Header["jwk"] == serializePublicKey(ourIssuerKey) {
+				usedKeyIndex = idx
 				// check that the signing method matches what we generate
 				ourSigningMethod := chooseSigningMethod(ourIssuerKey)
 				if !equalSigningMethods(ourSigningMethod, t.Method) {
@@ -93,6 +111,20 @@ func parseToken(cfg keppel.Configuration, ad keppel.AuthDriver, audience Audienc
 		return nil, keppel.ErrUnauthorized.With("token invalid")
 	}
 
+	// to allow operators to see when a previous issuer key can be removed from
+	// the configuration, count how many tokens still need these keys
+	if usedKeyIndex > 0 {
+		audienceLabel := "regular"
+		if audience.IsAnycast {
+			audienceLabel = "anycast"
+		}
+		TokensValidatedByPreviousIssuerKeyCounter.WithLabelValues(audienceLabel, strconv.Itoa(usedKeyIndex)).Inc()
+	}
+
+	return &claims, nil
+}
+
+func (claims tokenClaims) authorization(audience Audience) *Authorization {
 	var ss ScopeSet
 	for _, scope := range claims.Access {
 		ss.Add(scope)
@@ -101,7 +133,7 @@ func parseToken(cfg keppel.Configuration, ad keppel.AuthDriver, audience Audienc
 		UserIdentity: claims.Embedded.UserIdentity,
 		ScopeSet:     ss,
 		Audience:     audience,
-	}, nil
+	}
 }
 
 // TokenResponse is the format expected by Docker in an auth response. The Token
@@ -121,6 +153,10 @@ func (a Authorization) IssueToken(cfg keppel.Configuration) (*TokenResponse, err
 // IssueTokenWithExpires renders the given Authorization into a JWT token that can be used
 // as a Bearer token to authenticate on Keppel's various APIs with configurable expiring time
 func (a Authorization) IssueTokenWithExpires(cfg keppel.Configuration, expiresIn time.Duration) (*TokenResponse, error) {
+	return a.issueToken(cfg, expiresIn, "")
+}
+
+func (a Authorization) issueToken(cfg keppel.Configuration, expiresIn time.Duration, urlPath string) (*TokenResponse, error) {
 	now := time.Now()
 	expiresAt := now.Add(expiresIn)
 
@@ -133,7 +169,7 @@ func (a Authorization) IssueTokenWithExpires(cfg keppel.Configuration, expiresIn
 
 	// fill the "issuer" field with a dummy audience that has anycast forced to
 	// false to reveal the identity of the Keppel API that issued the token
-	issuer := Audience{IsAnycast: false, AccountName: a.Audience.AccountName}
+	issuer := Audience{IsAnycast: false, AccountName: a.Audience.AccountName, VanityHostname: a.Audience.VanityHostname}
 
 	uuidV4, err := uuid.NewV4()
 	if err != nil {
@@ -153,6 +189,7 @@ func (a Authorization) IssueTokenWithExpires(cfg keppel.Configuration, expiresIn
 		// access permissions granted to this token
 		Access:   a.ScopeSet.Flatten(),
 		Embedded: embeddedUserIdentity{UserIdentity: a.UserIdentity},
+		URLPath:  urlPath,
 	})
 	// we need to remember which key we used for this token, to choose the right
 	// key for validation during parseToken()
@@ -166,6 +203,21 @@ func (a Authorization) IssueTokenWithExpires(cfg keppel.Configuration, expiresIn
 	}, err
 }
 
+// SignStatement renders the given claims into a JWT that is signed with the
+// same key that we use for issuing tokens. This is used for statements that
+// third parties need to verify without contacting us, e.g. in provenance
+// bundles. The public key is identified in the "jwk" header of the JWT.
+func SignStatement(cfg keppel.Configuration, claims jwt.Claims) (string, error) {
+	if len(cfg.JWTIssuerKeys) == 0 {
+		return "", errors.New("no issuer keys configured")
+	}
+	issuerKey := cfg.JWTIssuerKeys[0]
+
+	token := jwt.NewWithClaims(chooseSigningMethod(issuerKey), claims)
+	token.Header["jwk"] = serializePublicKey(issuerKey)
+	return token.SignedString(issuerKey)
+}
+
 func chooseSigningMethod(key crypto.PrivateKey) jwt.SigningMethod {
 	switch key.(type) {
 	case ed25519.PrivateKey:
@@ -0,0 +1,144 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sapcc/go-bits/audittools"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func init() {
+	keppel.UserIdentityRegistry.Add(func() keppel.UserIdentity { return &RobotUserIdentity{} })
+}
+
+const robotUserNamePrefix = "robot@"
+
+// RobotUserIdentity is a keppel.UserIdentity for robot tokens issued by Keppel
+// itself. Robot tokens only grant pull access to a single repository, which is
+// why HasPermission() never grants anything: Access to that repository is
+// handled specially in filterRepoActions().
+type RobotUserIdentity struct {
+	TokenID        int64              `json:"id"`
+	AccountName    models.AccountName `json:"account"`
+	RepositoryName string             `json:"repo"`
+}
+
+// PluginTypeID implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) PluginTypeID() string {
+	return "robot"
+}
+
+// HasPermission implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) HasPermission(perm keppel.Permission, tenantID string) bool {
+	return false
+}
+
+// UserType implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) UserType() keppel.UserType {
+	return keppel.RegularUser
+}
+
+// UserName implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) UserName() string {
+	return RobotTokenUserName(uid.TokenID)
+}
+
+// UserInfo implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) UserInfo() audittools.UserInfo {
+	return nil
+}
+
+// SerializeToJSON implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) SerializeToJSON() (payload []byte, err error) {
+	return json.Marshal(uid)
+}
+
+// DeserializeFromJSON implements the keppel.UserIdentity interface.
+func (uid *RobotUserIdentity) DeserializeFromJSON(in []byte, _ keppel.AuthDriver) error {
+	return json.Unmarshal(in, uid)
+}
+
+// allowsPull returns whether this robot token grants pull access to the given repository.
+func (uid *RobotUserIdentity) allowsPull(repoScope ParsedRepositoryScope) bool {
+	return uid.AccountName == repoScope.AccountName && uid.RepositoryName == repoScope.RepositoryName
+}
+
+// RobotTokenUserName returns the username that clients need to present
+// together with the secret of the robot token with the given ID.
+func RobotTokenUserName(tokenID int64) string {
+	return robotUserNamePrefix + strconv.FormatInt(tokenID, 10)
+}
+
+// NewRobotTokenSecret generates a new random secret for a robot token. The
+// secret itself is only shown to the user once, the DB only stores its hash.
+func NewRobotTokenSecret() (secret, secretHash string, err error) {
+	buf := make([]byte, 32)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+	secret = hex.EncodeToString(buf)
+	return secret, hashRobotTokenSecret(secret), nil
+}
+
+func hashRobotTokenSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// Returns whether the given robot token credentials are valid. On success, the
+// RobotToken instance is returned. If the credentials do not match, or if the
+// token is expired or revoked, (nil, nil) is returned. Error values are only
+// returned for unexpected failures.
+func checkRobotCredentials(db *keppel.DB, userName, secret string, now time.Time) (*models.RobotToken, error) {
+	tokenID, err := strconv.ParseInt(strings.TrimPrefix(userName, robotUserNamePrefix), 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+
+	var token models.RobotToken
+	err = db.SelectOne(&token, `SELECT * FROM robot_tokens WHERE id = $1`, tokenID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !token.IsUsableAt(now) {
+		return nil, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(token.SecretHash), []byte(hashRobotTokenSecret(secret))) != 1 {
+		return nil, nil
+	}
+	return &token, nil
+}
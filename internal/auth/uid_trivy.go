@@ -84,7 +84,7 @@ func IssueTokenForTrivy(cfg keppel.Configuration, repoFullName string) (*TokenRe
 		Actions:      []string{"pull"},
 	}}
 
-	for _, repo := range cfg.Trivy.AdditionalPullableRepos {
+	for _, repo := range cfg.ScannerAdditionalPullableRepos {
 		scopes = append(scopes, Scope{
 			ResourceType: "repository",
 			ResourceName: repo,
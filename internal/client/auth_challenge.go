@@ -45,6 +45,13 @@ var challengeFieldRx = regexp.MustCompile(`^(\w+)\s*=\s*"([^"]*)"\s*,?\s*`)
 // ParseAuthChallenge parses the auth challenge from the response headers of an
 // unauthenticated request to a registry API.
 func ParseAuthChallenge(hdr http.Header) (AuthChallenge, error) {
+	return parseAuthChallenge(hdr, true)
+}
+
+// Like ParseAuthChallenge, but the scope field can be made optional. This is
+// needed for requests to the API root "/v2/", which are not scoped to any
+// resource.
+func parseAuthChallenge(hdr http.Header, requireScope bool) (AuthChallenge, error) {
 	input := hdr.Get("Www-Authenticate")
 	if input == "" {
 		return AuthChallenge{}, errors.New("missing Www-Authenticate header")
@@ -85,14 +92,14 @@ func ParseAuthChallenge(hdr http.Header) (AuthChallenge, error) {
 	if c.Service == "" {
 		return AuthChallenge{}, fmt.Errorf("missing service in Www-Authenticate: Bearer %s", input)
 	}
-	if c.Scope == "" {
+	if requireScope && c.Scope == "" {
 		return AuthChallenge{}, fmt.Errorf("missing scope in Www-Authenticate: Bearer %s", input)
 	}
 	return c, nil
 }
 
 // GetToken obtains a token that satisfies this challenge.
-func (c AuthChallenge) GetToken(ctx context.Context, userName, password string) (string, error) {
+func (c AuthChallenge) GetToken(ctx context.Context, httpClient *http.Client, userName, password string) (string, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Realm, http.NoBody)
 	if err != nil {
 		return "", err
@@ -102,10 +109,12 @@ func (c AuthChallenge) GetToken(ctx context.Context, userName, password string)
 	}
 	q := make(url.Values)
 	q.Set("service", c.Service)
-	q.Set("scope", c.Scope)
+	if c.Scope != "" {
+		q.Set("scope", c.Scope)
+	}
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -20,6 +20,7 @@ package client
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
@@ -96,3 +97,38 @@ func (c *RepoClient) DownloadManifest(ctx context.Context, reference models.Mani
 
 	return respBytes, resp.Header.Get("Content-Type"), nil
 }
+
+// RevalidateManifest sends a HEAD request for a manifest in this repository
+// and returns the digest that the reference currently resolves to. If
+// knownDigest is not empty, it is sent in an If-None-Match header, and if the
+// server responds with 304 (Not Modified), knownDigest is returned. If an
+// error is returned, it's usually a *keppel.RegistryV2Error.
+func (c *RepoClient) RevalidateManifest(ctx context.Context, reference models.ManifestReference, knownDigest digest.Digest) (digest.Digest, error) {
+	hdr := make(http.Header)
+	hdr.Set("Accept", strings.Join(distribution.ManifestMediaTypes(), ", "))
+	hdr.Set("X-Keppel-No-Count-Towards-Last-Pulled", "1")
+	if knownDigest != "" {
+		hdr.Set("If-None-Match", fmt.Sprintf("%q", knownDigest.String()))
+	}
+
+	resp, err := c.doRequest(ctx, repoRequest{
+		Method:            http.MethodHead,
+		Path:              "manifests/" + reference.String(),
+		Headers:           hdr,
+		ExpectStatus:      http.StatusOK,
+		AcceptNotModified: knownDigest != "",
+	})
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return knownDigest, nil
+	}
+	result, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		return "", fmt.Errorf("during HEAD %s/manifests/%s: malformed Docker-Content-Digest header: %w", c.RepoName, reference.String(), err)
+	}
+	return result, nil
+}
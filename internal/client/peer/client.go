@@ -34,15 +34,24 @@ import (
 // Client can be used for API access to one of our peers (using our peering
 // credentials).
 type Client struct {
-	peer  models.Peer
-	token string
+	peer       models.Peer
+	token      string
+	httpClient *http.Client
 }
 
 // New obtains a token for API access to the given peer (using our peering
 // credentials), and wraps it into a Client instance.
 func New(ctx context.Context, cfg keppel.Configuration, peer models.Peer, scope auth.Scope) (Client, error) {
-	c := Client{peer, ""}
-	err := c.initToken(ctx, cfg, scope)
+	httpClient, err := keppel.NewHTTPClientForPeer(peer)
+	if err != nil {
+		return Client{}, err
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	c := Client{peer, "", httpClient}
+	err = c.initToken(ctx, cfg, scope)
 	if err != nil {
 		return Client{}, fmt.Errorf("while trying to obtain a peer token for %s in scope %s: %w", peer.HostName, scope, err)
 	}
@@ -89,7 +98,7 @@ func (c Client) doRequest(ctx context.Context, method, url string, body io.Reade
 		req.Header.Set(k, v)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, 0, nil, fmt.Errorf("during %s %s: %w", method, url, err)
 	}
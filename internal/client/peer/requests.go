@@ -24,11 +24,101 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
 
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 )
 
+// GetPeerAPIInfo asks the peer which version of the peer API it implements,
+// and which optional capabilities it supports.
+//
+// Peers that predate version negotiation do not have this endpoint and respond
+// with 404. For those, keppel.LegacyPeerAPIInfo() is returned.
+func (c Client) GetPeerAPIInfo(ctx context.Context) (keppel.PeerAPIInfo, error) {
+	reqURL := c.buildRequestURL("peer/v1/version")
+
+	respBodyBytes, respStatusCode, _, err := c.doRequest(ctx, http.MethodGet, reqURL, http.NoBody, nil)
+	if err != nil {
+		return keppel.PeerAPIInfo{}, err
+	}
+	if respStatusCode == http.StatusNotFound {
+		return keppel.LegacyPeerAPIInfo(), nil
+	}
+	if respStatusCode != http.StatusOK {
+		return keppel.PeerAPIInfo{}, fmt.Errorf("during GET %s: expected 200, got %d with response: %s",
+			reqURL, respStatusCode, string(respBodyBytes))
+	}
+
+	// NOTE: Not using jsonUnmarshalStrict() here since newer peers may report
+	// additional fields that we do not know about yet.
+	var info keppel.PeerAPIInfo
+	err = json.Unmarshal(respBodyBytes, &info)
+	if err != nil {
+		return keppel.PeerAPIInfo{}, fmt.Errorf("while parsing response from GET %s: %w", reqURL, err)
+	}
+	return info, nil
+}
+
+// How long HasCapability() reuses the PeerAPIInfo of a peer before asking
+// the peer again.
+const peerAPIInfoCacheTTL = 5 * time.Minute
+
+type peerAPIInfoCacheEntry struct {
+	info      keppel.PeerAPIInfo
+	expiresAt time.Time
+}
+
+// peerAPIInfoCache holds the PeerAPIInfo of each peer by hostname, so that
+// HasCapability() does not need to ask the peer on every call.
+var peerAPIInfoCache = struct {
+	mutex   sync.Mutex
+	entries map[string]peerAPIInfoCacheEntry
+}{entries: make(map[string]peerAPIInfoCacheEntry)}
+
+// HasCapability is a shorthand for GetPeerAPIInfo() followed by
+// PeerAPIInfo.HasCapability().
+//
+// The PeerAPIInfo is cached for a few minutes. If it cannot be refreshed
+// after that, the last known PeerAPIInfo of this peer is used instead.
+func (c Client) HasCapability(ctx context.Context, capability keppel.PeerCapability) (bool, error) {
+	info, err := c.getCachedPeerAPIInfo(ctx)
+	if err != nil {
+		return false, err
+	}
+	return info.HasCapability(capability), nil
+}
+
+func (c Client) getCachedPeerAPIInfo(ctx context.Context) (keppel.PeerAPIInfo, error) {
+	peerAPIInfoCache.mutex.Lock()
+	entry, exists := peerAPIInfoCache.entries[c.peer.HostName]
+	peerAPIInfoCache.mutex.Unlock()
+	if exists && time.Now().Before(entry.expiresAt) {
+		return entry.info, nil
+	}
+
+	info, err := c.GetPeerAPIInfo(ctx)
+	if err != nil {
+		if exists {
+			// a peer that is temporarily unreachable has most likely not
+			// changed its version in the meantime
+			return entry.info, nil
+		}
+		return keppel.PeerAPIInfo{}, err
+	}
+
+	peerAPIInfoCache.mutex.Lock()
+	peerAPIInfoCache.entries[c.peer.HostName] = peerAPIInfoCacheEntry{
+		info:      info,
+		expiresAt: time.Now().Add(peerAPIInfoCacheTTL),
+	}
+	peerAPIInfoCache.mutex.Unlock()
+	return info, nil
+}
+
 // DownloadManifestViaPullDelegation asks the peer to download a manifest from
 // an external registry for us. This gets used when the external registry
 // denies the pull to us because we hit our rate limit.
@@ -141,6 +231,35 @@ func (c Client) PerformReplicaSync(ctx context.Context, fullRepoName string, pay
 	return &respPayload, nil
 }
 
+// GetBlobLocation asks the peer where the given blob is located in its
+// storage backend, for use with keppel.BlobCopyingStorageDriver. If the peer
+// does not have the blob in its storage, or if its storage driver does not
+// support this, an empty string is returned.
+func (c Client) GetBlobLocation(ctx context.Context, accountName models.AccountName, blobDigest digest.Digest) (string, error) {
+	reqURL := c.buildRequestURL(fmt.Sprintf("peer/v1/blob-location/%s/%s", accountName, blobDigest))
+
+	respBodyBytes, respStatusCode, _, err := c.doRequest(ctx, http.MethodGet, reqURL, http.NoBody, nil)
+	if err != nil {
+		return "", err
+	}
+	if respStatusCode == http.StatusNotFound || respStatusCode == http.StatusNotImplemented {
+		return "", nil
+	}
+	if respStatusCode != http.StatusOK {
+		return "", fmt.Errorf("during GET %s: expected 200, got %d with response: %s",
+			reqURL, respStatusCode, string(respBodyBytes))
+	}
+
+	data := struct {
+		Location string `json:"location"`
+	}{}
+	err = jsonUnmarshalStrict(respBodyBytes, &data)
+	if err != nil {
+		return "", fmt.Errorf("while parsing response from GET %s: %w", reqURL, err)
+	}
+	return data.Location, nil
+}
+
 // Like yaml.UnmarshalStrict(), but for JSON.
 func jsonUnmarshalStrict(buf []byte, target any) error {
 	dec := json.NewDecoder(bytes.NewReader(buf))
@@ -26,6 +26,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/sapcc/keppel/internal/keppel"
 )
@@ -40,8 +41,13 @@ type RepoClient struct {
 	UserName string
 	Password string
 
-	// auth state
-	token string
+	// optional; if nil, http.DefaultClient is used
+	HTTPClient *http.Client
+
+	// auth state (guarded by a mutex since blobs may be downloaded concurrently,
+	// e.g. by ValidateManifest)
+	token      string
+	tokenMutex sync.Mutex
 }
 
 type repoRequest struct {
@@ -50,14 +56,31 @@ type repoRequest struct {
 	Headers      http.Header
 	Body         io.ReadSeeker
 	ExpectStatus int
+	// if true, 304 (Not Modified) is accepted in addition to ExpectStatus
+	AcceptNotModified bool
 }
 
 // SetToken can be used in tests to inject a pre-computed token and bypass the
 // username/password requirement.
 func (c *RepoClient) SetToken(token string) {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
 	c.token = token
 }
 
+func (c *RepoClient) getToken() string {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	return c.token
+}
+
+func (c *RepoClient) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
 func (c *RepoClient) sendRequest(ctx context.Context, r repoRequest, uri string) (*http.Response, *http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, r.Method, uri, r.Body)
 	if err != nil {
@@ -66,10 +89,10 @@ func (c *RepoClient) sendRequest(ctx context.Context, r repoRequest, uri string)
 	for k, v := range r.Headers {
 		req.Header[k] = v
 	}
-	if c.token != "" {
-		req.Header.Set("Authorization", "Bearer "+c.token)
+	if token := c.getToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient().Do(req)
 	if err != nil {
 		return nil, nil, keppel.ErrUnavailable.With(err.Error())
 	}
@@ -96,10 +119,11 @@ func (c *RepoClient) doRequest(ctx context.Context, r repoRequest) (*http.Respon
 		if err != nil {
 			return nil, fmt.Errorf("cannot parse auth challenge from 401 response to %s %s: %w", r.Method, uri, err)
 		}
-		c.token, err = authChallenge.GetToken(ctx, c.UserName, c.Password)
+		token, err := authChallenge.GetToken(ctx, c.httpClient(), c.UserName, c.Password)
 		if err != nil {
 			return nil, fmt.Errorf("authentication failed: %w", err)
 		}
+		c.SetToken(token)
 
 		// ...then resend the GET request with the token
 		if r.Body != nil {
@@ -114,7 +138,7 @@ func (c *RepoClient) doRequest(ctx context.Context, r repoRequest) (*http.Respon
 		}
 	}
 
-	if resp.StatusCode != r.ExpectStatus {
+	if resp.StatusCode != r.ExpectStatus && !(r.AcceptNotModified && resp.StatusCode == http.StatusNotModified) {
 		defer resp.Body.Close()
 
 		// on error, try to parse the upstream RegistryV2Error so that we can proxy it
@@ -132,12 +156,56 @@ func (c *RepoClient) doRequest(ctx context.Context, r repoRequest) (*http.Respon
 			}
 		}
 
+		// responses to HEAD do not have a body, so we can only infer the error from the status code
+		if r.Method == http.MethodHead && resp.StatusCode == http.StatusNotFound && strings.HasPrefix(r.Path, "manifests/") {
+			return nil, keppel.ErrManifestUnknown.With("").WithStatus(http.StatusNotFound)
+		}
+
 		return nil, unexpectedStatusCodeError{req, http.StatusOK, resp.Status}
 	}
 
 	return resp, nil
 }
 
+// CheckCredentials checks whether the registry accepts the configured
+// credentials. Since this uses the API root "/v2/" instead of any particular
+// repository, this does not check whether the credentials grant any access to
+// the repository.
+func (c *RepoClient) CheckCredentials(ctx context.Context) error {
+	if c.Scheme == "" {
+		c.Scheme = "https"
+	}
+	uri := fmt.Sprintf("%s://%s/v2/", c.Scheme, c.Host)
+	r := repoRequest{Method: http.MethodGet, ExpectStatus: http.StatusOK}
+
+	resp, req, err := c.sendRequest(ctx, r, uri)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		authChallenge, err := parseAuthChallenge(resp.Header, false)
+		if err != nil {
+			return fmt.Errorf("cannot parse auth challenge from 401 response to GET %s: %w", uri, err)
+		}
+		token, err := authChallenge.GetToken(ctx, c.httpClient(), c.UserName, c.Password)
+		if err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+		c.SetToken(token)
+		resp, req, err = c.sendRequest(ctx, r, uri)
+		if err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != r.ExpectStatus {
+		return unexpectedStatusCodeError{req, r.ExpectStatus, resp.Status}
+	}
+	return nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 type unexpectedStatusCodeError struct {
@@ -21,6 +21,7 @@ package client
 import (
 	"bytes"
 	"context"
+	"io"
 	"net/http"
 	"strconv"
 
@@ -47,6 +48,26 @@ func (c *RepoClient) UploadMonolithicBlob(ctx context.Context, contents []byte)
 	return d, err
 }
 
+// UploadMonolithicBlobFrom is like UploadMonolithicBlob, but streams the blob
+// contents from the given reader instead of holding them in memory. Since the
+// digest cannot be computed in advance without reading the contents twice,
+// the caller must supply it.
+func (c *RepoClient) UploadMonolithicBlobFrom(ctx context.Context, d digest.Digest, contents io.ReadSeeker) error {
+	resp, err := c.doRequest(ctx, repoRequest{
+		Method: "POST",
+		Path:   "blobs/uploads/?digest=" + d.String(),
+		Headers: http.Header{
+			"Content-Type": {"application/octet-stream"},
+		},
+		Body:         contents,
+		ExpectStatus: http.StatusCreated,
+	})
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
 // UploadManifest uploads a manifest. If `tagName` is not empty, this tag name
 // is used, otherwise the manifest is uploaded to its canonical digest. On
 // success, the manifest's digest is returned.
@@ -20,9 +20,12 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
 
+	"github.com/docker/distribution"
 	"github.com/opencontainers/go-digest"
 
 	"github.com/sapcc/keppel/internal/keppel"
@@ -47,7 +50,13 @@ func (noopLogger) LogBlob(digest.Digest, int, error, bool)                {}
 // referenced multiple times. The session instance should only be used for as
 // long as the caller wishes to cache validation results.
 type ValidationSession struct {
-	Logger  ValidationLogger
+	Logger ValidationLogger
+	// How many blobs of the same manifest may be validated concurrently.
+	// Values below 2 mean that blobs are validated sequentially.
+	Parallelism int
+
+	// guards isValid and calls into Logger
+	mutex   sync.Mutex
 	isValid map[string]bool
 }
 
@@ -66,6 +75,32 @@ func (s *ValidationSession) applyDefaults() *ValidationSession {
 	return s
 }
 
+func (s *ValidationSession) isCached(cacheKey string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.isValid[cacheKey]
+}
+
+func (s *ValidationSession) markValid(cacheKeys ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, cacheKey := range cacheKeys {
+		s.isValid[cacheKey] = true
+	}
+}
+
+func (s *ValidationSession) logManifest(reference models.ManifestReference, level int, validationResult error, resultFromCache bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Logger.LogManifest(reference, level, validationResult, resultFromCache)
+}
+
+func (s *ValidationSession) logBlob(d digest.Digest, level int, validationResult error, resultFromCache bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Logger.LogBlob(d, level, validationResult, resultFromCache)
+}
+
 func (c *RepoClient) validationCacheKey(digestOrTagName string) string {
 	// We allow sharing a ValidationSession between multiple RepoClients to keep
 	// the API simple. But we cannot share validation results between repos: For
@@ -83,15 +118,15 @@ func (c *RepoClient) ValidateManifest(ctx context.Context, reference models.Mani
 }
 
 func (c *RepoClient) doValidateManifest(ctx context.Context, reference models.ManifestReference, level int, session *ValidationSession, platformFilter models.PlatformFilter) (returnErr error) {
-	if session.isValid[c.validationCacheKey(reference.String())] {
-		session.Logger.LogManifest(reference, level, nil, true)
+	if session.isCached(c.validationCacheKey(reference.String())) {
+		session.logManifest(reference, level, nil, true)
 		return nil
 	}
 
 	logged := false
 	defer func() {
 		if !logged {
-			session.Logger.LogManifest(reference, level, returnErr, false)
+			session.logManifest(reference, level, returnErr, false)
 		}
 	}()
 
@@ -105,15 +140,13 @@ func (c *RepoClient) doValidateManifest(ctx context.Context, reference models.Ma
 	}
 
 	// the manifest itself looks good...
-	session.Logger.LogManifest(models.ManifestReference{Digest: manifestDesc.Digest}, level, nil, false)
+	session.logManifest(models.ManifestReference{Digest: manifestDesc.Digest}, level, nil, false)
 	logged = true
 
 	// ...now recurse into the manifests and blobs that it references
-	for _, desc := range manifest.BlobReferences() {
-		err := c.doValidateBlobContents(ctx, desc.Digest, level+1, session)
-		if err != nil {
-			return err
-		}
+	err = c.validateBlobsOfManifest(ctx, manifest.BlobReferences(), level+1, session)
+	if err != nil {
+		return err
 	}
 	for _, desc := range manifest.ManifestReferences(platformFilter) {
 		err := c.doValidateManifest(ctx, models.ManifestReference{Digest: desc.Digest}, level+1, session, platformFilter)
@@ -123,11 +156,44 @@ func (c *RepoClient) doValidateManifest(ctx context.Context, reference models.Ma
 	}
 
 	// write validity into cache only after all references have been validated as well
-	session.isValid[c.validationCacheKey(manifestDesc.Digest.String())] = true
-	session.isValid[c.validationCacheKey(reference.String())] = true
+	session.markValid(
+		c.validationCacheKey(manifestDesc.Digest.String()),
+		c.validationCacheKey(reference.String()),
+	)
 	return nil
 }
 
+func (c *RepoClient) validateBlobsOfManifest(ctx context.Context, descs []distribution.Descriptor, level int, session *ValidationSession) error {
+	if session.Parallelism < 2 {
+		for _, desc := range descs {
+			err := c.doValidateBlobContents(ctx, desc.Digest, level, session)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// when validating concurrently, all blobs are validated even if one of them
+	// fails; the errors are reported in the order of the blobs in the manifest
+	// (not in the order in which the validations finished) to keep the result
+	// deterministic
+	errs := make([]error, len(descs))
+	semaphore := make(chan struct{}, session.Parallelism)
+	var wg sync.WaitGroup
+	for idx, desc := range descs {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			errs[idx] = c.doValidateBlobContents(ctx, desc.Digest, level, session)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
 // ValidateBlobContents fetches the given blob from the repo and verifies that
 // the contents produce the correct digest.
 func (c *RepoClient) ValidateBlobContents(ctx context.Context, blobDigest digest.Digest, session *ValidationSession) error {
@@ -136,12 +202,12 @@ func (c *RepoClient) ValidateBlobContents(ctx context.Context, blobDigest digest
 
 func (c *RepoClient) doValidateBlobContents(ctx context.Context, blobDigest digest.Digest, level int, session *ValidationSession) (returnErr error) {
 	cacheKey := c.validationCacheKey(blobDigest.String())
-	if session.isValid[cacheKey] {
-		session.Logger.LogBlob(blobDigest, level, nil, true)
+	if session.isCached(cacheKey) {
+		session.logBlob(blobDigest, level, nil, true)
 		return nil
 	}
 	defer func() {
-		session.Logger.LogBlob(blobDigest, level, returnErr, false)
+		session.logBlob(blobDigest, level, returnErr, false)
 	}()
 
 	readCloser, _, err := c.DownloadBlob(ctx, blobDigest)
@@ -167,6 +233,6 @@ func (c *RepoClient) doValidateBlobContents(ctx context.Context, blobDigest dige
 		return fmt.Errorf("actual digest is %s", actualDigest)
 	}
 
-	session.isValid[cacheKey] = true
+	session.markValid(cacheKey)
 	return nil
 }
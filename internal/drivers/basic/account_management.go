@@ -21,6 +21,7 @@ package basic
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"slices"
 	"strings"
@@ -42,6 +43,15 @@ type AccountManagementDriver struct {
 
 type AccountConfig struct {
 	Accounts []Account `json:"accounts"`
+	// PlatformFilterTemplates contains named platform filters that accounts can
+	// refer to via Account.PlatformFilterTemplate instead of repeating the same
+	// filter on each account.
+	PlatformFilterTemplates map[string]models.PlatformFilter `json:"platform_filter_templates"`
+	// DefaultGCPolicies are applied to all managed accounts. They are placed in
+	// front of each account's own GC policies, so that they take precedence and
+	// cannot be overridden by the per-account configuration (e.g. a "protect"
+	// policy for release tags cannot be undercut by an account's "delete" policy).
+	DefaultGCPolicies []keppel.GCPolicy `json:"default_gc_policies"`
 }
 
 type Account struct {
@@ -53,6 +63,9 @@ type Account struct {
 	SecurityScanPolicies []keppel.SecurityScanPolicy `json:"security_scan_policies"`
 	ValidationPolicy     *keppel.ValidationPolicy    `json:"validation"`
 	PlatformFilter       models.PlatformFilter       `json:"platform_filter"`
+	// PlatformFilterTemplate refers to an entry in AccountConfig.PlatformFilterTemplates.
+	// It is mutually exclusive with PlatformFilter.
+	PlatformFilterTemplate string `json:"platform_filter_template"`
 }
 
 func init() {
@@ -85,14 +98,24 @@ func (a *AccountManagementDriver) ConfigureAccount(accountName models.AccountNam
 			continue
 		}
 
+		platformFilter := cfgAccount.PlatformFilter
+		if cfgAccount.PlatformFilterTemplate != "" {
+			platformFilter = a.config.PlatformFilterTemplates[cfgAccount.PlatformFilterTemplate]
+		}
+
+		var gcPolicies []keppel.GCPolicy
+		if len(a.config.DefaultGCPolicies) > 0 || len(cfgAccount.GCPolicies) > 0 {
+			gcPolicies = append(slices.Clone(a.config.DefaultGCPolicies), cfgAccount.GCPolicies...)
+		}
+
 		account := &keppel.Account{
 			AuthTenantID:      cfgAccount.AuthTenantID,
-			GCPolicies:        cfgAccount.GCPolicies,
+			GCPolicies:        gcPolicies,
 			Name:              cfgAccount.Name,
 			RBACPolicies:      cfgAccount.RBACPolicies,
 			ReplicationPolicy: cfgAccount.ReplicationPolicy,
 			ValidationPolicy:  cfgAccount.ValidationPolicy,
-			PlatformFilter:    cfgAccount.PlatformFilter,
+			PlatformFilter:    platformFilter,
 		}
 
 		return account, cfgAccount.SecurityScanPolicies, nil
@@ -139,6 +162,10 @@ func (a *AccountManagementDriver) LoadConfig() error {
 	if err != nil {
 		return err
 	}
+	err = config.validate()
+	if err != nil {
+		return fmt.Errorf("invalid account management config in %s: %w", a.ConfigPath, err)
+	}
 
 	a.lock.Lock()
 	defer a.lock.Unlock()
@@ -146,3 +173,32 @@ func (a *AccountManagementDriver) LoadConfig() error {
 
 	return nil
 }
+
+func (c AccountConfig) validate() error {
+	for idx, policy := range c.DefaultGCPolicies {
+		err := policy.Validate()
+		if err != nil {
+			return fmt.Errorf("default_gc_policies[%d] is invalid: %w", idx, err)
+		}
+	}
+
+	for name, filter := range c.PlatformFilterTemplates {
+		if len(filter) == 0 {
+			return fmt.Errorf("platform filter template %q is empty", name)
+		}
+	}
+
+	for _, account := range c.Accounts {
+		if account.PlatformFilterTemplate == "" {
+			continue
+		}
+		if account.PlatformFilter != nil {
+			return fmt.Errorf("account %q has both platform_filter and platform_filter_template", account.Name)
+		}
+		_, exists := c.PlatformFilterTemplates[account.PlatformFilterTemplate]
+		if !exists {
+			return fmt.Errorf("account %q refers to unknown platform filter template %q", account.Name, account.PlatformFilterTemplate)
+		}
+	}
+	return nil
+}
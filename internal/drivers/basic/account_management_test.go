@@ -106,3 +106,73 @@ func TestConfigureAccount(t *testing.T) {
 
 	assert.DeepEqual(t, "account", newAccount, expectedAccount)
 }
+
+func TestConfigureAccountWithPlatformFilterTemplate(t *testing.T) {
+	driver := AccountManagementDriver{
+		ConfigPath: "./fixtures/account_management_templates.json",
+	}
+
+	listOfAccounts, err := driver.ManagedAccountNames()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "account", listOfAccounts, []models.AccountName{"first", "second"})
+
+	expectedFilter := models.PlatformFilter{{OS: "linux", Architecture: "amd64"}}
+	for _, accountName := range listOfAccounts {
+		account, _, err := driver.ConfigureAccount(accountName)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "account.PlatformFilter", account.PlatformFilter, expectedFilter)
+	}
+
+	// referring to an unknown template is rejected when loading the config
+	driver.ConfigPath = "./fixtures/account_management_unknown_template.json"
+	_, err = driver.ManagedAccountNames()
+	expectedError := `invalid account management config in ./fixtures/account_management_unknown_template.json: account "first" refers to unknown platform filter template "linux-amd64"`
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("expected error %q, but got %v", expectedError, err)
+	}
+}
+
+func TestConfigureAccountWithDefaultGCPolicies(t *testing.T) {
+	driver := AccountManagementDriver{
+		ConfigPath: "./fixtures/account_management_default_gc_policies.json",
+	}
+	err := driver.LoadConfig()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defaultPolicy := keppel.GCPolicy{
+		RepositoryRx: ".*",
+		TagRx:        `v[0-9]+\.[0-9]+\.[0-9]+`,
+		Action:       "protect",
+	}
+
+	// accounts without their own policies receive only the default policies
+	account, _, err := driver.ConfigureAccount("first")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "account.GCPolicies", account.GCPolicies, []keppel.GCPolicy{defaultPolicy})
+
+	// default policies are placed in front of the account's own policies, so that they take precedence
+	account, _, err = driver.ConfigureAccount("second")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "account.GCPolicies", account.GCPolicies, []keppel.GCPolicy{
+		defaultPolicy,
+		{RepositoryRx: ".*", OnlyUntagged: true, Action: "delete"},
+	})
+
+	// invalid default policies are rejected when loading the config
+	driver.ConfigPath = "./fixtures/account_management_invalid_default_gc_policy.json"
+	err = driver.LoadConfig()
+	expectedError := `invalid account management config in ./fixtures/account_management_invalid_default_gc_policy.json: default_gc_policies[0] is invalid: "keep" is not a valid action for a GC policy`
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("expected error %q, but got %v", expectedError, err)
+	}
+}
@@ -0,0 +1,297 @@
+/******************************************************************************
+*
+*  Copyright 2020 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package basic
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // required by the CloudFront signed URL format
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sapcc/go-bits/osext"
+	"github.com/sapcc/go-bits/regexpext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// CDNDriver is the CDN driver "basic".
+type CDNDriver struct {
+	ConfigPath string
+	config     CDNConfig
+}
+
+// CDNConfig is the format of the config file that CDNDriver reads.
+type CDNConfig struct {
+	CDNs []CDNConfigEntry `json:"cdns"`
+}
+
+// CDNConfigEntry appears in type CDNConfig. It describes the CDN that is used
+// for all accounts matching AccountRx.
+type CDNConfigEntry struct {
+	AccountRx              regexpext.BoundedRegexp `json:"match_account"`
+	BaseURL                string                  `json:"base_url"`
+	PathTemplate           string                  `json:"path_template"`
+	MinBlobSizeBytes       uint64                  `json:"min_blob_size_bytes"`
+	URLLifetime            keppel.Duration         `json:"url_lifetime"`
+	CloudFront             *CloudFrontSigningKey   `json:"cloudfront"`
+	AkamaiToken            *AkamaiTokenKey         `json:"akamai_token"`
+	InvalidationWebhookURL string                  `json:"invalidation_webhook_url"`
+}
+
+// CloudFrontSigningKey appears in type CDNConfigEntry. It contains the key
+// pair that CloudFront-style signed URLs are signed with.
+type CloudFrontSigningKey struct {
+	KeyPairID     string `json:"key_pair_id"`
+	PrivateKeyPEM string `json:"private_key"`
+	privateKey    *rsa.PrivateKey
+}
+
+// AkamaiTokenKey appears in type CDNConfigEntry. It contains the shared secret
+// that Akamai-style authorization tokens are signed with.
+type AkamaiTokenKey struct {
+	// TokenName is the name of the query parameter carrying the token.
+	// Defaults to "__token__".
+	TokenName string `json:"token_name"`
+	// KeyHex is the shared secret in hexadecimal encoding.
+	KeyHex string `json:"key"`
+	key    []byte
+}
+
+func init() {
+	keppel.CDNDriverRegistry.Add(func() keppel.CDNDriver { return &CDNDriver{} })
+}
+
+// PluginTypeID implements the keppel.CDNDriver interface.
+func (d *CDNDriver) PluginTypeID() string { return "basic" }
+
+// Init implements the keppel.CDNDriver interface.
+func (d *CDNDriver) Init(ad keppel.AuthDriver, cfg keppel.Configuration) error {
+	configPath, err := osext.NeedGetenv("KEPPEL_CDN_CONFIG_PATH")
+	if err != nil {
+		return err
+	}
+	d.ConfigPath = configPath
+	return d.LoadConfig()
+}
+
+// LoadConfig reads the config file at d.ConfigPath.
+func (d *CDNDriver) LoadConfig() error {
+	reader, err := os.Open(d.ConfigPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	var config CDNConfig
+	err = decoder.Decode(&config)
+	if err != nil {
+		return err
+	}
+	err = config.validate()
+	if err != nil {
+		return fmt.Errorf("invalid CDN config in %s: %w", d.ConfigPath, err)
+	}
+	d.config = config
+	return nil
+}
+
+func (c *CDNConfig) validate() error {
+	for idx := range c.CDNs {
+		entry := &c.CDNs[idx]
+		if entry.AccountRx == "" {
+			return fmt.Errorf("cdns[%d] is missing match_account", idx)
+		}
+		baseURL, err := url.Parse(entry.BaseURL)
+		if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+			return fmt.Errorf("cdns[%d] has invalid base_url: %q", idx, entry.BaseURL)
+		}
+		if !strings.HasPrefix(entry.PathTemplate, "/") {
+			return fmt.Errorf("cdns[%d] has invalid path_template: %q (must start with a slash)", idx, entry.PathTemplate)
+		}
+		if entry.URLLifetime <= 0 {
+			return fmt.Errorf("cdns[%d] is missing url_lifetime", idx)
+		}
+
+		switch {
+		case entry.CloudFront != nil && entry.AkamaiToken != nil:
+			return fmt.Errorf("cdns[%d] may not have both cloudfront and akamai_token", idx)
+		case entry.CloudFront != nil:
+			if entry.CloudFront.KeyPairID == "" {
+				return fmt.Errorf("cdns[%d].cloudfront is missing key_pair_id", idx)
+			}
+			entry.CloudFront.privateKey, err = parseRSAPrivateKey(entry.CloudFront.PrivateKeyPEM)
+			if err != nil {
+				return fmt.Errorf("cdns[%d].cloudfront has invalid private_key: %w", idx, err)
+			}
+		case entry.AkamaiToken != nil:
+			if entry.AkamaiToken.TokenName == "" {
+				entry.AkamaiToken.TokenName = "__token__"
+			}
+			entry.AkamaiToken.key, err = hex.DecodeString(entry.AkamaiToken.KeyHex)
+			if err != nil || len(entry.AkamaiToken.key) == 0 {
+				return fmt.Errorf("cdns[%d].akamai_token has invalid key (expected a hex-encoded string)", idx)
+			}
+		default:
+			return fmt.Errorf("cdns[%d] needs either cloudfront or akamai_token", idx)
+		}
+
+		if entry.InvalidationWebhookURL != "" {
+			_, err := url.Parse(entry.InvalidationWebhookURL)
+			if err != nil {
+				return fmt.Errorf("cdns[%d] has invalid invalidation_webhook_url: %w", idx, err)
+			}
+		}
+	}
+	return nil
+}
+
+func parseRSAPrivateKey(pemStr string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("expected RSA key, but got %T", key)
+	}
+	return rsaKey, nil
+}
+
+func (d *CDNDriver) findEntry(account models.ReducedAccount, blob models.Blob) *CDNConfigEntry {
+	for idx, entry := range d.config.CDNs {
+		if !entry.AccountRx.MatchString(string(account.Name)) {
+			continue
+		}
+		if blob.SizeBytes < entry.MinBlobSizeBytes {
+			return nil
+		}
+		return &d.config.CDNs[idx]
+	}
+	return nil
+}
+
+func (e CDNConfigEntry) pathForBlob(account models.ReducedAccount, blob models.Blob) string {
+	return strings.NewReplacer(
+		"%ACCOUNT_NAME%", string(account.Name),
+		"%DIGEST%", blob.Digest.String(),
+		"%STORAGE_ID%", blob.StorageID,
+	).Replace(e.PathTemplate)
+}
+
+// URLForBlob implements the keppel.CDNDriver interface.
+func (d *CDNDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob, now time.Time) (string, error) {
+	entry := d.findEntry(account, blob)
+	if entry == nil {
+		return "", keppel.ErrCannotGenerateURL
+	}
+
+	path := entry.pathForBlob(account, blob)
+	resourceURL := strings.TrimSuffix(entry.BaseURL, "/") + path
+	expiresAt := now.Add(time.Duration(entry.URLLifetime)).Unix()
+
+	query := url.Values{}
+	switch {
+	case entry.CloudFront != nil:
+		// canned policy, see <https://docs.aws.amazon.com/AmazonCloudFront/latest/DeveloperGuide/private-content-creating-signed-url-canned-policy.html>
+		policy := fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`, resourceURL, expiresAt)
+		hash := sha1.Sum([]byte(policy)) //nolint:gosec // required by the CloudFront signed URL format
+		signature, err := rsa.SignPKCS1v15(rand.Reader, entry.CloudFront.privateKey, crypto.SHA1, hash[:])
+		if err != nil {
+			return "", fmt.Errorf("cannot sign CDN URL: %w", err)
+		}
+		query.Set("Expires", strconv.FormatInt(expiresAt, 10))
+		query.Set("Signature", cloudFrontBase64(signature))
+		query.Set("Key-Pair-Id", entry.CloudFront.KeyPairID)
+	case entry.AkamaiToken != nil:
+		// token format of Akamai's Token Authentication 2.0
+		token := fmt.Sprintf("exp=%d~acl=%s", expiresAt, path)
+		mac := hmac.New(sha256.New, entry.AkamaiToken.key)
+		mac.Write([]byte(token))
+		query.Set(entry.AkamaiToken.TokenName, token+"~hmac="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return resourceURL + "?" + query.Encode(), nil
+}
+
+func cloudFrontBase64(buf []byte) string {
+	return strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(buf))
+}
+
+// CDNInvalidationRequest is the request body that CDNDriver sends to the
+// invalidation webhook of a CDN.
+type CDNInvalidationRequest struct {
+	AccountName models.AccountName `json:"account"`
+	Paths       []string           `json:"paths"`
+}
+
+// InvalidateBlob implements the keppel.CDNDriver interface.
+func (d *CDNDriver) InvalidateBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob) error {
+	entry := d.findEntry(account, blob)
+	if entry == nil || entry.InvalidationWebhookURL == "" {
+		return nil
+	}
+
+	reqBody, err := json.Marshal(CDNInvalidationRequest{
+		AccountName: account.Name,
+		Paths:       []string{entry.pathForBlob(account, blob)},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, entry.InvalidationWebhookURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot invalidate blob %s in CDN: %w", blob.Digest, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("cannot invalidate blob %s in CDN: webhook returned status %d", blob.Digest, resp.StatusCode)
+	}
+	return nil
+}
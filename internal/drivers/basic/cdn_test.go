@@ -0,0 +1,167 @@
+/******************************************************************************
+*
+*  Copyright 2020 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package basic
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // required by the CloudFront signed URL format
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var (
+	cdnTestNow  = time.Unix(1700000000, 0).UTC()
+	cdnTestBlob = models.Blob{
+		Digest:    digest.FromString("example"),
+		SizeBytes: 2 << 20,
+		StorageID: "abcdef",
+	}
+)
+
+func TestCDNWithAkamaiToken(t *testing.T) {
+	driver := CDNDriver{ConfigPath: "./fixtures/cdn_akamai.json"}
+	err := driver.LoadConfig()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx := context.Background()
+
+	// accounts not matching any config entry are not served via the CDN
+	_, err = driver.URLForBlob(ctx, models.ReducedAccount{Name: "test1"}, cdnTestBlob, cdnTestNow)
+	assert.DeepEqual(t, "error for unmatched account", errors.Is(err, keppel.ErrCannotGenerateURL), true)
+
+	// blobs below the configured size threshold are not served via the CDN
+	smallBlob := cdnTestBlob
+	smallBlob.SizeBytes = 1024
+	_, err = driver.URLForBlob(ctx, models.ReducedAccount{Name: "small-test"}, smallBlob, cdnTestNow)
+	assert.DeepEqual(t, "error for small blob", errors.Is(err, keppel.ErrCannotGenerateURL), true)
+
+	// check generated URL
+	urlStr, err := driver.URLForBlob(ctx, models.ReducedAccount{Name: "prod-test"}, cdnTestBlob, cdnTestNow)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "URL host", parsedURL.Host, "cdn.example.org")
+	assert.DeepEqual(t, "URL path", parsedURL.Path, "/prod-test/blobs/abcdef")
+
+	token := parsedURL.Query().Get("auth")
+	expectedPayload := fmt.Sprintf("exp=%d~acl=/prod-test/blobs/abcdef", cdnTestNow.Add(10*time.Minute).Unix())
+	payload, signature, _ := strings.Cut(token, "~hmac=")
+	assert.DeepEqual(t, "token payload", payload, expectedPayload)
+	key, _ := hex.DecodeString("0123456789abcdef")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(expectedPayload))
+	assert.DeepEqual(t, "token signature", signature, hex.EncodeToString(mac.Sum(nil)))
+
+	// invalidation is a no-op for accounts without invalidation webhook
+	err = driver.InvalidateBlob(ctx, models.ReducedAccount{Name: "prod-test"}, cdnTestBlob)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestCDNWithCloudFrontSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	configJSON, err := json.Marshal(map[string]any{
+		"cdns": []map[string]any{{
+			"match_account": ".*",
+			"base_url":      "https://d111111abcdef8.cloudfront.net",
+			"path_template": "/%ACCOUNT_NAME%/%DIGEST%",
+			"url_lifetime":  map[string]any{"value": 1, "unit": "h"},
+			"cloudfront":    map[string]any{"key_pair_id": "K2JCJMDEHXQW5F", "private_key": string(keyPEM)},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	configPath := filepath.Join(t.TempDir(), "cdn.json")
+	err = os.WriteFile(configPath, configJSON, 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	driver := CDNDriver{ConfigPath: configPath}
+	err = driver.LoadConfig()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	urlStr, err := driver.URLForBlob(context.Background(), models.ReducedAccount{Name: "test1"}, cdnTestBlob, cdnTestNow)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	resourceURL, queryStr, _ := strings.Cut(urlStr, "?")
+	assert.DeepEqual(t, "resource URL", resourceURL, "https://d111111abcdef8.cloudfront.net/test1/"+cdnTestBlob.Digest.String())
+	query, err := url.ParseQuery(queryStr)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expiresAt := cdnTestNow.Add(time.Hour).Unix()
+	assert.DeepEqual(t, "Expires", query.Get("Expires"), fmt.Sprint(expiresAt))
+	assert.DeepEqual(t, "Key-Pair-Id", query.Get("Key-Pair-Id"), "K2JCJMDEHXQW5F")
+
+	policy := fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`, resourceURL, expiresAt)
+	hash := sha1.Sum([]byte(policy)) //nolint:gosec // required by the CloudFront signed URL format
+	signature, err := base64.StdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(query.Get("Signature")))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA1, hash[:], signature)
+	if err != nil {
+		t.Error("signature does not verify: " + err.Error())
+	}
+}
+
+func TestCDNConfigValidation(t *testing.T) {
+	driver := CDNDriver{ConfigPath: "./fixtures/cdn_invalid.json"}
+	err := driver.LoadConfig()
+	assert.DeepEqual(t, "error message", err.Error(),
+		"invalid CDN config in ./fixtures/cdn_invalid.json: cdns[0] needs either cloudfront or akamai_token")
+}
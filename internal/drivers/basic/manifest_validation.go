@@ -0,0 +1,133 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package basic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sapcc/go-bits/osext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// ManifestValidationDriver is the manifest validation driver "basic". It
+// POSTs each manifest (see type ManifestValidationInput) to a fixed URL and
+// expects a verdict in return (see type ManifestValidationResult).
+//
+// The request and response bodies follow the format of the Data API of the
+// Open Policy Agent, so the webhook URL can point directly at a Rego policy
+// document, e.g. "http://opa:8181/v1/data/keppel/manifest".
+type ManifestValidationDriver struct {
+	WebhookURL string
+}
+
+// ManifestValidationInput is what ManifestValidationDriver sends in the
+// "input" field of its request body.
+type ManifestValidationInput struct {
+	AccountName  string            `json:"account"`
+	AuthTenantID string            `json:"auth_tenant_id"`
+	RepoName     string            `json:"repository"`
+	TagName      string            `json:"tag,omitempty"`
+	Digest       string            `json:"digest"`
+	MediaType    string            `json:"media_type"`
+	Manifest     json.RawMessage   `json:"manifest"`
+	Config       json.RawMessage   `json:"config,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	UserName     string            `json:"user_name,omitempty"`
+}
+
+// ManifestValidationResult is what ManifestValidationDriver expects in the
+// "result" field of the response body.
+type ManifestValidationResult struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func init() {
+	keppel.ManifestValidationDriverRegistry.Add(func() keppel.ManifestValidationDriver { return &ManifestValidationDriver{} })
+}
+
+// PluginTypeID implements the keppel.ManifestValidationDriver interface.
+func (d *ManifestValidationDriver) PluginTypeID() string { return "basic" }
+
+// Init implements the keppel.ManifestValidationDriver interface.
+func (d *ManifestValidationDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	webhookURL, err := osext.NeedGetenv("KEPPEL_MANIFEST_VALIDATION_WEBHOOK_URL")
+	if err != nil {
+		return err
+	}
+	d.WebhookURL = webhookURL
+	return nil
+}
+
+// ValidateManifest implements the keppel.ManifestValidationDriver interface.
+func (d *ManifestValidationDriver) ValidateManifest(ctx context.Context, req keppel.ManifestValidationRequest) (keppel.ManifestValidationVerdict, error) {
+	input := ManifestValidationInput{
+		AccountName:  string(req.Account.Name),
+		AuthTenantID: req.Account.AuthTenantID,
+		RepoName:     req.Repository.Name,
+		TagName:      req.TagName,
+		Digest:       req.Digest.String(),
+		MediaType:    req.MediaType,
+		Manifest:     json.RawMessage(req.Contents),
+		Labels:       req.Labels,
+		UserName:     req.UserName,
+	}
+	if len(req.ConfigContents) > 0 {
+		input.Config = json.RawMessage(req.ConfigContents)
+	}
+	reqBody, err := json.Marshal(struct {
+		Input ManifestValidationInput `json:"input"`
+	}{input})
+	if err != nil {
+		return keppel.ManifestValidationVerdict{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, d.WebhookURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return keppel.ManifestValidationVerdict{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return keppel.ManifestValidationVerdict{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return keppel.ManifestValidationVerdict{}, fmt.Errorf("POST %s returned unexpected status %s", d.WebhookURL, resp.Status)
+	}
+
+	// if the policy document does not exist, OPA responds without a "result"
+	// field; we do not want to accept everything in this case
+	var respBody struct {
+		Result *ManifestValidationResult `json:"result"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	if err != nil {
+		return keppel.ManifestValidationVerdict{}, fmt.Errorf("could not parse response from POST %s: %w", d.WebhookURL, err)
+	}
+	if respBody.Result == nil {
+		return keppel.ManifestValidationVerdict{}, fmt.Errorf("response from POST %s does not contain a result", d.WebhookURL)
+	}
+	return keppel.ManifestValidationVerdict(*respBody.Result), nil
+}
@@ -0,0 +1,125 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package basic
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestManifestValidationDriver(t *testing.T) {
+	var received []ManifestValidationInput
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var payload struct {
+			Input ManifestValidationInput `json:"input"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received = append(received, payload.Input)
+
+		// this policy only allows images with a "maintainer" label
+		result := ManifestValidationResult{Allowed: true}
+		if payload.Input.Labels["maintainer"] == "" {
+			result = ManifestValidationResult{Allowed: false, Reason: "missing maintainer"}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"result": result})
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	t.Setenv("KEPPEL_MANIFEST_VALIDATION_WEBHOOK_URL", server.URL)
+	driver, err := keppel.NewManifestValidationDriver(ctx, "basic", keppel.Configuration{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	manifestDigest := digest.FromString("manifest")
+	req := keppel.ManifestValidationRequest{
+		Account:        models.ReducedAccount{Name: "test1", AuthTenantID: "tenant1"},
+		Repository:     models.Repository{AccountName: "test1", Name: "foo"},
+		TagName:        "latest",
+		Digest:         manifestDigest,
+		MediaType:      "application/vnd.oci.image.manifest.v1+json",
+		Contents:       []byte(`{"schemaVersion":2}`),
+		ConfigContents: []byte(`{"config":{"labels":{"maintainer":"alice"}}}`),
+		Labels:         map[string]string{"maintainer": "alice"},
+		UserName:       "johndoe",
+	}
+	verdict, err := driver.ValidateManifest(ctx, req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "verdict", verdict, keppel.ManifestValidationVerdict{Allowed: true})
+	assert.DeepEqual(t, "received input", received, []ManifestValidationInput{{
+		AccountName:  "test1",
+		AuthTenantID: "tenant1",
+		RepoName:     "foo",
+		TagName:      "latest",
+		Digest:       manifestDigest.String(),
+		MediaType:    "application/vnd.oci.image.manifest.v1+json",
+		Manifest:     json.RawMessage(`{"schemaVersion":2}`),
+		Config:       json.RawMessage(`{"config":{"labels":{"maintainer":"alice"}}}`),
+		Labels:       map[string]string{"maintainer": "alice"},
+		UserName:     "johndoe",
+	}})
+
+	// the reason for a rejection is passed on to the caller
+	req.Labels = nil
+	verdict, err = driver.ValidateManifest(ctx, req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "verdict", verdict, keppel.ManifestValidationVerdict{Allowed: false, Reason: "missing maintainer"})
+
+	// a response without a result (e.g. because the OPA policy does not exist) is an error
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	})
+	_, err = driver.ValidateManifest(ctx, req)
+	if err == nil {
+		t.Fatal("expected error from response without result, but got none")
+	}
+
+	// errors from the webhook are propagated to the caller
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusServiceUnavailable)
+	})
+	_, err = driver.ValidateManifest(ctx, req)
+	if err == nil {
+		t.Fatal("expected error from failing webhook, but got none")
+	}
+}
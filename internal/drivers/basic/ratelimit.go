@@ -51,6 +51,10 @@ var (
 		keppel.ManifestPushAction:        {"KEPPEL_RATELIMIT_MANIFEST_PUSHES", "KEPPEL_BURST_MANIFEST_PUSHES"},
 		keppel.AnycastBlobBytePullAction: {"KEPPEL_RATELIMIT_ANYCAST_BLOB_PULL_BYTES", "KEPPEL_BURST_ANYCAST_BLOB_PULL_BYTES"},
 		keppel.TrivyReportRetrieveAction: {"KEPPEL_RATELIMIT_TRIVY_REPORT_RETRIEVALS", "KEPPEL_BURST_TRIVY_REPORT_RETRIEVALS"},
+		// these are optional (see isOptional)
+		keppel.AnonymousBlobPullAction:     {"KEPPEL_RATELIMIT_ANONYMOUS_BLOB_PULLS", "KEPPEL_BURST_ANONYMOUS_BLOB_PULLS"},
+		keppel.AnonymousManifestPullAction: {"KEPPEL_RATELIMIT_ANONYMOUS_MANIFEST_PULLS", "KEPPEL_BURST_ANONYMOUS_MANIFEST_PULLS"},
+		keppel.SecurityScanRequestAction:   {"KEPPEL_RATELIMIT_SECURITY_SCAN_REQUESTS", "KEPPEL_BURST_SECURITY_SCAN_REQUESTS"},
 	}
 	valueRx           = regexp.MustCompile(`^\s*([0-9]+)\s*[Br]/([smh])\s*$`)
 	limitConstructors = map[string]func(int) redis_rate.Limit{
@@ -99,7 +103,7 @@ func (d RateLimitDriver) GetRateLimit(account models.ReducedAccount, action kepp
 
 func parseRateLimit(envVar string) (*redis_rate.Limit, error) {
 	var valStr string
-	if strings.HasSuffix(envVar, "_BYTES") {
+	if isOptional(envVar) {
 		valStr = os.Getenv(envVar)
 		if valStr == "" {
 			return nil, nil
@@ -135,3 +139,9 @@ func parseBurst(envVar string) (int, error) {
 	}
 	return val, nil
 }
+
+// Rate limits for anycast bytes and for anonymous users are optional.
+// All others are required.
+func isOptional(envVar string) bool {
+	return strings.HasSuffix(envVar, "_BYTES") || strings.Contains(envVar, "_ANONYMOUS_") || strings.Contains(envVar, "_SECURITY_SCAN_")
+}
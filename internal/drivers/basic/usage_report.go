@@ -0,0 +1,97 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package basic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sapcc/go-bits/osext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// UsageReportDriver is the usage report driver "basic". It POSTs each report
+// as a JSON document (see type UsageReportPayload) to a fixed URL.
+type UsageReportDriver struct {
+	WebhookURL string
+}
+
+// UsageReportPayload is the request body that UsageReportDriver sends.
+type UsageReportPayload struct {
+	ReportedAt int64                    `json:"reported_at"`
+	Tenants    []UsageReportTenantEntry `json:"tenants"`
+}
+
+// UsageReportTenantEntry appears in type UsageReportPayload.
+type UsageReportTenantEntry struct {
+	AuthTenantID string `json:"auth_tenant_id"`
+	StorageBytes uint64 `json:"storage_bytes"`
+	EgressBytes  uint64 `json:"egress_bytes"`
+}
+
+func init() {
+	keppel.UsageReportDriverRegistry.Add(func() keppel.UsageReportDriver { return &UsageReportDriver{} })
+}
+
+// PluginTypeID implements the keppel.UsageReportDriver interface.
+func (d *UsageReportDriver) PluginTypeID() string { return "basic" }
+
+// Init implements the keppel.UsageReportDriver interface.
+func (d *UsageReportDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	webhookURL, err := osext.NeedGetenv("KEPPEL_USAGE_REPORT_WEBHOOK_URL")
+	if err != nil {
+		return err
+	}
+	d.WebhookURL = webhookURL
+	return nil
+}
+
+// ReportUsage implements the keppel.UsageReportDriver interface.
+func (d *UsageReportDriver) ReportUsage(ctx context.Context, report keppel.UsageReport) error {
+	payload := UsageReportPayload{
+		ReportedAt: report.ReportedAt.Unix(),
+		Tenants:    make([]UsageReportTenantEntry, len(report.Tenants)),
+	}
+	for idx, u := range report.Tenants {
+		payload.Tenants[idx] = UsageReportTenantEntry(u)
+	}
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.WebhookURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s returned unexpected status %s", d.WebhookURL, resp.Status)
+	}
+	return nil
+}
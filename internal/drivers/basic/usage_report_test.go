@@ -0,0 +1,85 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package basic
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+func TestUsageReportDriver(t *testing.T) {
+	var received []UsageReportPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var payload UsageReportPayload
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received = append(received, payload)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	t.Setenv("KEPPEL_USAGE_REPORT_WEBHOOK_URL", server.URL)
+	driver, err := keppel.NewUsageReportDriver(ctx, "basic", keppel.Configuration{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = driver.ReportUsage(ctx, keppel.UsageReport{
+		ReportedAt: time.Unix(1700000000, 0),
+		Tenants: []keppel.TenantUsage{
+			{AuthTenantID: "tenant1", StorageBytes: 1024, EgressBytes: 4096},
+			{AuthTenantID: "tenant2", StorageBytes: 0, EgressBytes: 42},
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "received payloads", received, []UsageReportPayload{{
+		ReportedAt: 1700000000,
+		Tenants: []UsageReportTenantEntry{
+			{AuthTenantID: "tenant1", StorageBytes: 1024, EgressBytes: 4096},
+			{AuthTenantID: "tenant2", StorageBytes: 0, EgressBytes: 42},
+		},
+	}})
+
+	// errors from the webhook are propagated to the caller
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusServiceUnavailable)
+	})
+	err = driver.ReportUsage(ctx, keppel.UsageReport{ReportedAt: time.Unix(1700003600, 0)})
+	if err == nil {
+		t.Fatal("expected error from failing webhook, but got none")
+	}
+}
@@ -118,6 +118,24 @@ func (d *StorageDriver) ReadBlob(ctx context.Context, account models.ReducedAcco
 	return f, keppel.AtLeastZero(stat.Size()), nil
 }
 
+// ReadBlobRange implements the keppel.StorageDriver interface.
+func (d *StorageDriver) ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (io.ReadCloser, error) {
+	path := d.getBlobPath(account, storageID)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Seek(int64(offset), io.SeekStart) //nolint:gosec // offset is within the blob, so it fits into int64
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, int64(length)), f}, nil //nolint:gosec // length is within the blob, so it fits into int64
+}
+
 // URLForBlob implements the keppel.StorageDriver interface.
 func (d *StorageDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
 	return "", keppel.ErrCannotGenerateURL
@@ -157,97 +175,74 @@ func (d *StorageDriver) DeleteManifest(ctx context.Context, account models.Reduc
 }
 
 // ListStorageContents implements the keppel.StorageDriver interface.
-func (d *StorageDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount) ([]keppel.StoredBlobInfo, []keppel.StoredManifestInfo, error) {
-	blobs, err := d.getBlobs(account)
-	if err != nil {
-		return nil, nil, err
-	}
-	manifests, err := d.getManifests(account)
-	if err != nil {
-		return nil, nil, err
-	}
-	return blobs, manifests, nil
-}
-
-func (d *StorageDriver) getBlobs(account models.ReducedAccount) ([]keppel.StoredBlobInfo, error) {
-	var blobs []keppel.StoredBlobInfo
-	directory, err := os.Open(d.getBlobBasePath(account))
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return []keppel.StoredBlobInfo{}, nil
-		}
-		return nil, err
-	}
-	defer directory.Close()
-	names, err := directory.Readdirnames(-1)
-	if err != nil {
-		return nil, err
-	}
-	for _, name := range names {
+func (d *StorageDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount, handleBlobs func([]keppel.StoredBlobInfo) error, handleManifests func([]keppel.StoredManifestInfo) error) error {
+	blobBatcher := keppel.StorageListingBatcher[keppel.StoredBlobInfo]{Callback: handleBlobs}
+	err := foreachDirectoryEntry(d.getBlobBasePath(account), func(name string) error {
 		if strings.HasSuffix(name, ".tmp") {
-			continue
+			return nil
 		}
-		blobs = append(blobs, keppel.StoredBlobInfo{
+		return blobBatcher.Add(keppel.StoredBlobInfo{
 			StorageID: name,
 		})
-	}
-	return blobs, nil
-}
-
-func (d *StorageDriver) getManifests(account models.ReducedAccount) ([]keppel.StoredManifestInfo, error) {
-	var manifests []keppel.StoredManifestInfo
-	directory, err := os.Open(d.getManifestBasePath(account))
+	})
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return []keppel.StoredManifestInfo{}, nil
-		}
-		return nil, err
+		return err
 	}
-	defer directory.Close()
-	repos, err := directory.Readdirnames(-1)
+	err = blobBatcher.Flush()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	for _, repo := range repos {
-		repoManifests, err := d.getRepoManifests(account, repo)
-		if err != nil {
-			return nil, err
-		}
-		manifests = append(manifests, repoManifests...)
+
+	manifestBatcher := keppel.StorageListingBatcher[keppel.StoredManifestInfo]{Callback: handleManifests}
+	err = foreachDirectoryEntry(d.getManifestBasePath(account), func(repo string) error {
+		return foreachDirectoryEntry(filepath.Join(d.getManifestBasePath(account), repo), func(digestStr string) error {
+			if strings.HasSuffix(digestStr, ".tmp") {
+				return nil
+			}
+			manifestDigest, err := digest.Parse(digestStr)
+			if err != nil {
+				return err
+			}
+			return manifestBatcher.Add(keppel.StoredManifestInfo{
+				RepoName: repo,
+				Digest:   manifestDigest,
+			})
+		})
+	})
+	if err != nil {
+		return err
 	}
-	return manifests, nil
+	return manifestBatcher.Flush()
 }
 
-func (d *StorageDriver) getRepoManifests(account models.ReducedAccount, repo string) ([]keppel.StoredManifestInfo, error) {
-	var manifests []keppel.StoredManifestInfo
-	directory, err := os.Open(filepath.Join(d.getManifestBasePath(account), repo))
+// Calls the callback for each entry in the given directory, without reading
+// the entire directory listing into memory at once. A nonexistent directory
+// is treated like an empty one.
+func foreachDirectoryEntry(path string, callback func(name string) error) error {
+	directory, err := os.Open(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return []keppel.StoredManifestInfo{}, nil
+			return nil
 		}
-		return nil, err
+		return err
 	}
 	defer directory.Close()
-	digests, err := directory.Readdirnames(-1)
-	if err != nil {
-		return nil, err
-	}
-	for _, digestStr := range digests {
-		if strings.HasSuffix(digestStr, ".tmp") {
-			continue
-		}
 
-		manifestDigest, err := digest.Parse(digestStr)
+	for {
+		names, err := directory.Readdirnames(keppel.StorageListingBatchSize)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
 		if err != nil {
-			return nil, err
+			return err
+		}
+		for _, name := range names {
+			err := callback(name)
+			if err != nil {
+				return err
+			}
 		}
-
-		manifests = append(manifests, keppel.StoredManifestInfo{
-			RepoName: repo,
-			Digest:   manifestDigest,
-		})
 	}
-	return manifests, nil
 }
 
 // CanSetupAccount implements the keppel.StorageDriver interface.
@@ -259,19 +254,20 @@ func (d *StorageDriver) CanSetupAccount(ctx context.Context, account models.Redu
 func (d *StorageDriver) CleanupAccount(ctx context.Context, account models.ReducedAccount) error {
 	// double-check that cleanup order is right; when the account gets deleted,
 	// all blobs and manifests must have been deleted from it before
-	storedBlobs, storedManifests, err := d.ListStorageContents(ctx, account)
-	if len(storedBlobs) > 0 {
-		return fmt.Errorf(
-			"found undeleted blob during CleanupAccount: storageID = %q",
-			storedBlobs[0].StorageID,
-		)
-	}
-	if len(storedManifests) > 0 {
-		return fmt.Errorf(
-			"found undeleted manifest during CleanupAccount: %s@%s",
-			storedManifests[0].RepoName,
-			storedManifests[0].Digest,
-		)
-	}
-	return err
+	return d.ListStorageContents(ctx, account, failOnUndeletedBlobs, failOnUndeletedManifests)
+}
+
+func failOnUndeletedBlobs(storedBlobs []keppel.StoredBlobInfo) error {
+	return fmt.Errorf(
+		"found undeleted blob during CleanupAccount: storageID = %q",
+		storedBlobs[0].StorageID,
+	)
+}
+
+func failOnUndeletedManifests(storedManifests []keppel.StoredManifestInfo) error {
+	return fmt.Errorf(
+		"found undeleted manifest during CleanupAccount: %s@%s",
+		storedManifests[0].RepoName,
+		storedManifests[0].Digest,
+	)
 }
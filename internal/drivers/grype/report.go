@@ -0,0 +1,195 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package grype
+
+import (
+	"fmt"
+	"strings"
+
+	dbtypes "github.com/aquasecurity/trivy-db/pkg/types"
+	ftypes "github.com/aquasecurity/trivy/pkg/fanal/types"
+	stypes "github.com/aquasecurity/trivy/pkg/module/serialize"
+
+	"github.com/sapcc/keppel/internal/trivy"
+)
+
+// Document is the subset of the JSON report generated by `grype -o json` that
+// Keppel needs to understand.
+type Document struct {
+	Matches []Match `json:"matches"`
+	Source  Source  `json:"source"`
+	Distro  Distro  `json:"distro"`
+}
+
+// Match appears in type Document.
+type Match struct {
+	Vulnerability Vulnerability `json:"vulnerability"`
+	Artifact      Artifact      `json:"artifact"`
+}
+
+// Vulnerability appears in type Match.
+type Vulnerability struct {
+	ID          string   `json:"id"`
+	DataSource  string   `json:"dataSource"`
+	Severity    string   `json:"severity"`
+	URLs        []string `json:"urls"`
+	Description string   `json:"description"`
+	Fix         struct {
+		Versions []string `json:"versions"`
+		State    string   `json:"state"`
+	} `json:"fix"`
+}
+
+// Artifact appears in type Match. It describes the package containing the vulnerability.
+type Artifact struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Type      string `json:"type"`
+	Locations []struct {
+		Path    string `json:"path"`
+		LayerID string `json:"layerID"`
+	} `json:"locations"`
+	PURL string `json:"purl"`
+}
+
+// Source appears in type Document.
+type Source struct {
+	Type   string `json:"type"`
+	Target struct {
+		UserInput   string   `json:"userInput"`
+		ImageID     string   `json:"imageID"`
+		RepoDigests []string `json:"repoDigests"`
+		Tags        []string `json:"tags"`
+		ImageSize   int64    `json:"imageSize"`
+	} `json:"target"`
+}
+
+// Distro appears in type Document.
+type Distro struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Grype severities, as listed in github.com/anchore/grype/grype/vulnerability/severity.go.
+var severityToTrivySeverity = map[string]string{
+	"negligible": "LOW", // Trivy does not have a separate level for this
+	"low":        "LOW",
+	"medium":     "MEDIUM",
+	"high":       "HIGH",
+	"critical":   "CRITICAL",
+}
+
+// Package types that Grype reports for packages installed by the OS package manager.
+var osPackageTypes = map[string]bool{
+	"apk": true,
+	"deb": true,
+	"rpm": true,
+}
+
+// Grype fix states, as listed in github.com/anchore/grype/grype/vulnerability/fix.go.
+var fixStateToTrivyStatus = map[string]dbtypes.Status{
+	"fixed":     dbtypes.StatusFixed,
+	"not-fixed": dbtypes.StatusAffected,
+	"wont-fix":  dbtypes.StatusWillNotFix,
+}
+
+// ToTrivyReport converts this report into the report format used by Trivy,
+// which is the format that Keppel expects from all scanners.
+func (d Document) ToTrivyReport() trivy.Report {
+	report := trivy.Report{
+		SchemaVersion: 2,
+		ArtifactName:  d.Source.Target.UserInput,
+		ArtifactType:  "container_image",
+		Metadata: trivy.Metadata{
+			Size:        d.Source.Target.ImageSize,
+			ImageID:     d.Source.Target.ImageID,
+			RepoTags:    d.Source.Target.Tags,
+			RepoDigests: d.Source.Target.RepoDigests,
+		},
+	}
+	if d.Distro.Name != "" {
+		report.Metadata.OS = &ftypes.OS{
+			Family: ftypes.OSType(d.Distro.Name),
+			Name:   d.Distro.Version,
+		}
+	}
+
+	// Trivy groups vulnerabilities by the location where the affected packages
+	// were found (the OS package database, or the individual files containing
+	// language-specific packages), so we do the same
+	resultIndexByTarget := make(map[string]int)
+	for _, match := range d.Matches {
+		target, class, targetType := d.trivyTargetFor(match.Artifact)
+		idx, exists := resultIndexByTarget[target]
+		if !exists {
+			idx = len(report.Results)
+			resultIndexByTarget[target] = idx
+			report.Results = append(report.Results, stypes.Result{
+				Target: target,
+				Class:  class,
+				Type:   targetType,
+			})
+		}
+		report.Results[idx].Vulnerabilities = append(report.Results[idx].Vulnerabilities, match.toTrivyVulnerability())
+	}
+	return report
+}
+
+func (d Document) trivyTargetFor(artifact Artifact) (target, class, targetType string) {
+	if osPackageTypes[artifact.Type] {
+		target = d.Source.Target.UserInput
+		if d.Distro.Name != "" {
+			target = fmt.Sprintf("%s (%s %s)", target, d.Distro.Name, d.Distro.Version)
+		}
+		return target, "os-pkgs", d.Distro.Name
+	}
+
+	target = artifact.Type
+	if len(artifact.Locations) > 0 {
+		target = artifact.Locations[0].Path
+	}
+	return target, "lang-pkgs", artifact.Type
+}
+
+func (m Match) toTrivyVulnerability() stypes.DetectedVulnerability {
+	severity, exists := severityToTrivySeverity[strings.ToLower(m.Vulnerability.Severity)]
+	if !exists {
+		severity = "UNKNOWN"
+	}
+
+	result := stypes.DetectedVulnerability{
+		VulnerabilityID:  m.Vulnerability.ID,
+		PkgID:            m.Artifact.Name + "@" + m.Artifact.Version,
+		PkgName:          m.Artifact.Name,
+		InstalledVersion: m.Artifact.Version,
+		FixedVersion:     strings.Join(m.Vulnerability.Fix.Versions, ", "),
+		Status:           fixStateToTrivyStatus[m.Vulnerability.Fix.State], // defaults to StatusUnknown
+		PrimaryURL:       m.Vulnerability.DataSource,
+		Vulnerability: dbtypes.Vulnerability{
+			Description: m.Vulnerability.Description,
+			Severity:    severity,
+			References:  m.Vulnerability.URLs,
+		},
+	}
+	if len(m.Artifact.Locations) > 0 {
+		// Grype reports layers by their diff ID (the digest of the uncompressed layer)
+		result.Layer.DiffID = m.Artifact.Locations[0].LayerID
+	}
+	return result
+}
@@ -0,0 +1,119 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package grype
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sapcc/go-bits/osext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/trivy"
+)
+
+// Header names used in requests to a grype-proxy deployment.
+const (
+	TokenHeader       = "Grype-Token"
+	KeppelTokenHeader = "Keppel-Token"
+)
+
+// ScannerDriver is the scanner driver "grype". It talks to a grype-proxy
+// deployment, which runs Grype on the requested image.
+type ScannerDriver struct {
+	Token string
+	URL   url.URL
+}
+
+func init() {
+	keppel.ScannerDriverRegistry.Add(func() keppel.ScannerDriver { return &ScannerDriver{} })
+}
+
+// PluginTypeID implements the keppel.ScannerDriver interface.
+func (d *ScannerDriver) PluginTypeID() string { return "grype" }
+
+// Init implements the keppel.ScannerDriver interface.
+func (d *ScannerDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	urlStr, err := osext.NeedGetenv("KEPPEL_GRYPE_URL")
+	if err != nil {
+		return err
+	}
+	grypeURL, err := url.Parse(urlStr)
+	if err != nil {
+		return fmt.Errorf("malformed KEPPEL_GRYPE_URL: %w", err)
+	}
+	d.URL = *grypeURL
+	d.Token, err = osext.NeedGetenv("KEPPEL_GRYPE_TOKEN")
+	return err
+}
+
+// SupportsReportFormat implements the keppel.ScannerDriver interface.
+func (d *ScannerDriver) SupportsReportFormat(format string) bool {
+	// Grype only reports vulnerabilities, it does not generate SBOMs
+	return format == "json"
+}
+
+// ScanManifest implements the keppel.ScannerDriver interface.
+func (d *ScannerDriver) ScanManifest(ctx context.Context, keppelToken string, manifestRef models.ImageReference, format string) (trivy.ReportPayload, error) {
+	if !d.SupportsReportFormat(format) {
+		return trivy.ReportPayload{}, fmt.Errorf("report format %q is not supported by Grype", format)
+	}
+
+	requestURL := d.URL
+	requestURL.Path = "/grype"
+	requestURL.RawQuery = url.Values{"image": {manifestRef.String()}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), http.NoBody)
+	if err != nil {
+		return trivy.ReportPayload{}, err
+	}
+	req.Header.Set(TokenHeader, d.Token)
+	req.Header.Set(KeppelTokenHeader, keppelToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return trivy.ReportPayload{}, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return trivy.ReportPayload{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		respCleaned := strings.Join(strings.Fields(strings.TrimSpace(string(respBody))), " ")
+		return trivy.ReportPayload{}, fmt.Errorf("grype proxy did not return 200: %d %s", resp.StatusCode, respCleaned)
+	}
+
+	var doc Document
+	err = json.Unmarshal(respBody, &doc)
+	if err != nil {
+		return trivy.ReportPayload{}, fmt.Errorf("cannot parse report from Grype: %w", err)
+	}
+	contents, err := json.Marshal(doc.ToTrivyReport())
+	if err != nil {
+		return trivy.ReportPayload{}, err
+	}
+	return trivy.ReportPayload{Format: format, Contents: contents}, nil
+}
@@ -0,0 +1,101 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package grype
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestScanManifest(t *testing.T) {
+	grypeReport, err := os.ReadFile("fixtures/grype-report.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	imageRef, _, err := models.ParseImageReference("registry.example.org/test1/foo@sha256:3333333333333333333333333333333333333333333333333333333333333333")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// mock a grype-proxy deployment
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/grype" || r.Header.Get(TokenHeader) != "secret" || r.Header.Get(KeppelTokenHeader) != "keppel-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("image") != imageRef.String() {
+			http.Error(w, "unexpected image", http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(grypeReport)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	driver := &ScannerDriver{Token: "secret", URL: *serverURL}
+
+	// only JSON reports can be generated
+	assert.DeepEqual(t, "SupportsReportFormat(json)", driver.SupportsReportFormat("json"), true)
+	assert.DeepEqual(t, "SupportsReportFormat(spdx-json)", driver.SupportsReportFormat("spdx-json"), false)
+	_, err = driver.ScanManifest(context.Background(), "keppel-token", imageRef, "spdx-json")
+	if err == nil {
+		t.Error("expected ScanManifest to fail for unsupported format, but it succeeded")
+	}
+
+	// the Grype report is converted into the Trivy report format
+	payload, err := driver.ScanManifest(context.Background(), "keppel-token", imageRef, "json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "report format", payload.Format, "json")
+	expectedReport, err := os.ReadFile("fixtures/trivy-report.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var expected, actual any
+	err = json.Unmarshal(expectedReport, &expected)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = json.Unmarshal(payload.Contents, &actual)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "report contents", actual, expected)
+
+	// errors from the proxy are reported
+	driver.Token = "wrong"
+	_, err = driver.ScanManifest(context.Background(), "keppel-token", imageRef, "json")
+	if err == nil {
+		t.Fatal("expected ScanManifest to fail with wrong token, but it succeeded")
+	}
+	assert.DeepEqual(t, "error message", err.Error(), "grype proxy did not return 200: 401 unauthorized")
+}
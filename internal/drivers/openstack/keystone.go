@@ -236,13 +236,15 @@ func newKeystoneUserIdentity(t *gopherpolicy.Token, isRelevantRole map[string]bo
 }
 
 var ruleForPerm = map[keppel.Permission]string{
-	keppel.CanViewAccount:       "account:show",
-	keppel.CanPullFromAccount:   "account:pull",
-	keppel.CanPushToAccount:     "account:push",
-	keppel.CanDeleteFromAccount: "account:delete",
-	keppel.CanChangeAccount:     "account:edit",
-	keppel.CanViewQuotas:        "quota:show",
-	keppel.CanChangeQuotas:      "quota:edit",
+	keppel.CanViewAccount:         "account:show",
+	keppel.CanPullFromAccount:     "account:pull",
+	keppel.CanPushToAccount:       "account:push",
+	keppel.CanDeleteFromAccount:   "account:delete",
+	keppel.CanChangeAccount:       "account:edit",
+	keppel.CanViewQuotas:          "quota:show",
+	keppel.CanChangeQuotas:        "quota:edit",
+	keppel.CanViewClusterStatus:   "cluster:show",
+	keppel.CanChangeClusterConfig: "cluster:edit",
 }
 
 // PluginTypeID implements the keppel.UserIdentity interface.
@@ -260,11 +262,15 @@ func (a *keystoneUserIdentity) UserName() string {
 
 // HasPermission implements the keppel.UserIdentity interface.
 func (a *keystoneUserIdentity) HasPermission(perm keppel.Permission, tenantID string) bool {
-	if tenantID == "" {
+	switch {
+	case perm == keppel.CanViewClusterStatus || perm == keppel.CanChangeClusterConfig:
+		// these permissions do not pertain to any particular project
+		delete(a.t.Context.Request, "target.project.id")
+	case tenantID == "":
 		return false
+	default:
+		a.t.Context.Request["target.project.id"] = tenantID
 	}
-
-	a.t.Context.Request["target.project.id"] = tenantID
 	logg.Debug("token has object attributes = %v", a.t.Context.Request)
 
 	rule, hasRule := ruleForPerm[perm]
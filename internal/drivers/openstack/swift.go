@@ -28,8 +28,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -39,6 +41,7 @@ import (
 	"github.com/opencontainers/go-digest"
 	"github.com/sapcc/go-bits/errext"
 	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/osext"
 
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
@@ -53,6 +56,10 @@ type swiftDriver struct {
 	mainAccount         *schwift.Account
 	containerInfos      map[models.AccountName]*swiftContainerInfo
 	containerInfosMutex sync.RWMutex
+	// If non-zero, chunks larger than this are split into segments of this
+	// size, which are uploaded in parallel (see AppendToBlob).
+	segmentSizeBytes  uint64
+	uploadConcurrency int
 }
 
 func init() {
@@ -79,6 +86,20 @@ func (d *swiftDriver) Init(ad keppel.AuthDriver, cfg keppel.Configuration) error
 		return err
 	}
 	d.containerInfos = make(map[models.AccountName]*swiftContainerInfo)
+
+	segmentSizeStr := os.Getenv("KEPPEL_SWIFT_SEGMENT_SIZE_MIB")
+	if segmentSizeStr != "" {
+		segmentSizeMiB, err := strconv.ParseUint(segmentSizeStr, 10, 32)
+		if err != nil || segmentSizeMiB == 0 {
+			return fmt.Errorf("invalid value for KEPPEL_SWIFT_SEGMENT_SIZE_MIB: %q", segmentSizeStr)
+		}
+		d.segmentSizeBytes = segmentSizeMiB << 20
+	}
+	concurrencyStr := osext.GetenvOrDefault("KEPPEL_SWIFT_SEGMENT_UPLOAD_CONCURRENCY", "4")
+	d.uploadConcurrency, err = strconv.Atoi(concurrencyStr)
+	if err != nil || d.uploadConcurrency <= 0 {
+		return fmt.Errorf("invalid value for KEPPEL_SWIFT_SEGMENT_UPLOAD_CONCURRENCY: %q", concurrencyStr)
+	}
 	return nil
 }
 
@@ -165,6 +186,39 @@ func chunkObject(c *schwift.Container, storageID string, chunkNumber uint32) *sc
 	return c.Object(fmt.Sprintf("_chunks/%s/%s/%s/%010d", storageID[0:2], storageID[2:4], storageID[4:], chunkNumber))
 }
 
+func segmentObject(c *schwift.Container, storageID string, chunkNumber, segmentNumber uint32) *schwift.Object {
+	return c.Object(fmt.Sprintf("_chunks/%s/%s/%s/%010d-%010d", storageID[0:2], storageID[2:4], storageID[4:], chunkNumber, segmentNumber))
+}
+
+// When a chunk is split into segments by AppendToBlob(), its chunk object is
+// an empty marker object that records the number of segments in this metadata
+// field. (We do not use container listings to find the segments since they
+// are only eventually consistent.)
+const segmentCountMetadataKey = "Keppel-Segment-Count"
+
+// Returns the objects that make up the given chunk, in order. If the chunk
+// was split into segments, the marker object is returned as well.
+func chunkParts(ctx context.Context, c *schwift.Container, storageID string, chunkNumber uint32) (parts []*schwift.Object, marker *schwift.Object, err error) {
+	co := chunkObject(c, storageID, chunkNumber)
+	hdr, err := co.Headers(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	segmentCountStr := hdr.Metadata().Get(segmentCountMetadataKey)
+	if segmentCountStr == "" {
+		return []*schwift.Object{co}, nil, nil
+	}
+	segmentCount, err := strconv.ParseUint(segmentCountStr, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed segment count on %s: %w", co.FullName(), err)
+	}
+	parts = make([]*schwift.Object, segmentCount)
+	for idx := range parts {
+		parts[idx] = segmentObject(c, storageID, chunkNumber, uint32(idx+1)) //nolint:gosec // idx is bounded by segmentCount which fits into uint32
+	}
+	return parts, co, nil
+}
+
 func manifestObject(c *schwift.Container, repoName string, manifestDigest digest.Digest) *schwift.Object {
 	return c.Object(fmt.Sprintf("%s/_manifests/%s", repoName, manifestDigest))
 }
@@ -194,7 +248,114 @@ func (d *swiftDriver) AppendToBlob(ctx context.Context, account models.ReducedAc
 		hdr.SizeBytes().Set(*chunkLength)
 	}
 	o := chunkObject(c, storageID, chunkNumber)
-	return uploadToObject(ctx, o, chunk, nil, hdr.ToOpts())
+	if d.segmentSizeBytes == 0 || (chunkLength != nil && *chunkLength <= d.segmentSizeBytes) {
+		return uploadToObject(ctx, o, chunk, nil, hdr.ToOpts())
+	}
+	return d.uploadSegmentedChunk(ctx, c, storageID, chunkNumber, chunkLength, chunk)
+}
+
+// Splits a chunk into segments of d.segmentSizeBytes and uploads up to
+// d.uploadConcurrency of them in parallel. Since the segments need to be
+// buffered in memory for this, this uses up to (d.uploadConcurrency + 2)
+// times d.segmentSizeBytes of memory per upload.
+func (d *swiftDriver) uploadSegmentedChunk(ctx context.Context, c *schwift.Container, storageID string, chunkNumber uint32, chunkLength *uint64, chunk io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg           sync.WaitGroup
+		semaphore    = make(chan struct{}, d.uploadConcurrency)
+		errMutex     sync.Mutex
+		firstError   error
+		segmentCount uint32
+		bytesRead    uint64
+		pending      []byte
+	)
+	setError := func(err error) {
+		errMutex.Lock()
+		defer errMutex.Unlock()
+		if firstError == nil {
+			firstError = err
+			cancel()
+		}
+	}
+	uploadSegment := func(segmentNumber uint32, contents []byte) {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			o := segmentObject(c, storageID, chunkNumber, segmentNumber)
+			hdr := schwift.NewObjectHeaders()
+			hdr.SizeBytes().Set(uint64(len(contents)))
+			err := uploadToObject(ctx, o, bytes.NewReader(contents), nil, hdr.ToOpts())
+			if err != nil {
+				setError(fmt.Errorf("while uploading %s: %w", o.FullName(), err))
+			}
+		}()
+	}
+
+	// we hold back each segment until we know that it is not the only one;
+	// chunks that fit into one segment are uploaded as a regular chunk object
+	for ctx.Err() == nil {
+		buf := make([]byte, d.segmentSizeBytes)
+		n, err := io.ReadFull(chunk, buf)
+		bytesRead += uint64(n) //nolint:gosec // n is non-negative
+		isEOF := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !isEOF {
+			setError(err)
+			break
+		}
+		if n > 0 {
+			if pending != nil {
+				segmentCount++
+				uploadSegment(segmentCount, pending)
+			}
+			pending = buf[:n]
+		}
+		if isEOF {
+			break
+		}
+	}
+	if segmentCount > 0 && ctx.Err() == nil {
+		segmentCount++
+		uploadSegment(segmentCount, pending)
+	}
+	wg.Wait()
+
+	if firstError == nil && ctx.Err() != nil {
+		firstError = ctx.Err()
+	}
+	if firstError == nil && chunkLength != nil && bytesRead != *chunkLength {
+		firstError = keppel.ErrSizeInvalid.With("expected %d bytes, but got %d bytes", *chunkLength, bytesRead)
+	}
+	if firstError == nil {
+		o := chunkObject(c, storageID, chunkNumber)
+		hdr := schwift.NewObjectHeaders()
+		if segmentCount == 0 {
+			hdr.SizeBytes().Set(uint64(len(pending)))
+			firstError = uploadToObject(ctx, o, bytes.NewReader(pending), nil, hdr.ToOpts())
+		} else {
+			hdr.Metadata().Set(segmentCountMetadataKey, strconv.FormatUint(uint64(segmentCount), 10))
+			firstError = uploadToObject(ctx, o, bytes.NewReader(nil), nil, hdr.ToOpts())
+		}
+	}
+	if firstError == nil {
+		return nil
+	}
+
+	// clean up segments that were already uploaded (AbortBlobUpload() cannot
+	// find them because the marker object was not written)
+	for segmentNumber := uint32(1); segmentNumber <= segmentCount; segmentNumber++ {
+		o := segmentObject(c, storageID, chunkNumber, segmentNumber)
+		err := o.Delete(context.WithoutCancel(ctx), nil, nil)
+		if err != nil && !schwift.Is(err, http.StatusNotFound) {
+			logg.Error("encountered additional error while cleaning up %s: %s", o.FullName(), err.Error())
+		}
+	}
+	return firstError
 }
 
 // FinalizeBlob implements the keppel.StorageDriver interface.
@@ -215,23 +376,44 @@ func (d *swiftDriver) FinalizeBlob(ctx context.Context, account models.ReducedAc
 		return err
 	}
 
+	var markers []*schwift.Object
 	for chunkNumber := uint32(1); chunkNumber <= chunkCount; chunkNumber++ {
-		co := chunkObject(c, storageID, chunkNumber)
-		hdr, err := co.Headers(ctx)
+		parts, marker, err := chunkParts(ctx, c, storageID, chunkNumber)
 		if err != nil {
 			return err
 		}
-		err = lo.AddSegment(schwift.SegmentInfo{
-			Object:    co,
-			SizeBytes: hdr.SizeBytes().Get(),
-			Etag:      hdr.Etag().Get(),
-		})
-		if err != nil {
-			return err
+		if marker != nil {
+			markers = append(markers, marker)
+		}
+		for _, part := range parts {
+			hdr, err := part.Headers(ctx)
+			if err != nil {
+				return err
+			}
+			err = lo.AddSegment(schwift.SegmentInfo{
+				Object:    part,
+				SizeBytes: hdr.SizeBytes().Get(),
+				Etag:      hdr.Etag().Get(),
+			})
+			if err != nil {
+				return err
+			}
 		}
 	}
 
-	return lo.WriteManifest(ctx, nil)
+	err = lo.WriteManifest(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// marker objects are not part of the SLO, so they would not be cleaned up by DeleteBlob()
+	for _, marker := range markers {
+		err := marker.Delete(ctx, nil, nil)
+		if err != nil && !schwift.Is(err, http.StatusNotFound) {
+			logg.Error("could not delete segment marker %s: %s", marker.FullName(), err.Error())
+		}
+	}
+	return nil
 }
 
 // AbortBlobUpload implements the keppel.StorageDriver interface.
@@ -244,16 +426,25 @@ func (d *swiftDriver) AbortBlobUpload(ctx context.Context, account models.Reduce
 	// we didn't construct the LargeObject yet, so we need to delete the segments individually
 	var firstError error
 	for chunkNumber := uint32(1); chunkNumber <= chunkCount; chunkNumber++ {
-		err := chunkObject(c, storageID, chunkNumber).Delete(ctx, nil, nil)
-		// keep going even when some segments cannot be deleted, to clean up as much as we can
-		// (404 errors are ignored entirely; they are not really an error since we want the objects to be not there anyway)
-		if err != nil && !schwift.Is(err, http.StatusNotFound) {
-			if firstError == nil {
-				firstError = err
-			} else {
-				logg.Error("encountered additional error while cleaning up segments of %s: %s",
-					chunkObject(c, storageID, chunkNumber).FullName(), err.Error(),
-				)
+		// segmented chunks need to have their segments deleted before the marker object
+		objects := []*schwift.Object{chunkObject(c, storageID, chunkNumber)}
+		parts, marker, err := chunkParts(ctx, c, storageID, chunkNumber)
+		if err == nil && marker != nil {
+			objects = append(parts, marker)
+		}
+
+		for _, o := range objects {
+			err := o.Delete(ctx, nil, nil)
+			// keep going even when some segments cannot be deleted, to clean up as much as we can
+			// (404 errors are ignored entirely; they are not really an error since we want the objects to be not there anyway)
+			if err != nil && !schwift.Is(err, http.StatusNotFound) {
+				if firstError == nil {
+					firstError = err
+				} else {
+					logg.Error("encountered additional error while cleaning up segments of %s: %s",
+						o.FullName(), err.Error(),
+					)
+				}
 			}
 		}
 	}
@@ -276,6 +467,30 @@ func (d *swiftDriver) ReadBlob(ctx context.Context, account models.ReducedAccoun
 	return reader, hdr.SizeBytes().Get(), err
 }
 
+// ReadBlobRange implements the keppel.StorageDriver interface.
+func (d *swiftDriver) ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (io.ReadCloser, error) {
+	c, _, err := d.getBackendConnection(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	o := blobObject(c, storageID)
+
+	// schwift.Object.Download() only accepts 200 responses, so we need to build the request ourselves
+	hdr := make(schwift.Headers)
+	hdr.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := schwift.Request{
+		Method:            http.MethodGet,
+		ContainerName:     c.Name(),
+		ObjectName:        o.Name(),
+		Options:           &schwift.RequestOptions{Headers: hdr},
+		ExpectStatusCodes: []int{http.StatusPartialContent},
+	}.Do(ctx, c.Account().Backend())
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
 // URLForBlob implements the keppel.StorageDriver interface.
 func (d *swiftDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
 	c, info, err := d.getBackendConnection(ctx, account)
@@ -298,6 +513,77 @@ func (d *swiftDriver) DeleteBlob(ctx context.Context, account models.ReducedAcco
 	return err
 }
 
+// BlobLocation implements the keppel.BlobCopyingStorageDriver interface.
+func (d *swiftDriver) BlobLocation(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
+	c, _, err := d.getBackendConnection(ctx, account)
+	if err != nil {
+		return "", err
+	}
+	return c.Account().Name() + "/" + blobObject(c, storageID).FullName(), nil
+}
+
+// CopyBlobFromLocation implements the keppel.BlobCopyingStorageDriver interface.
+func (d *swiftDriver) CopyBlobFromLocation(ctx context.Context, account models.ReducedAccount, storageID, location string) (uint64, error) {
+	// location has the format "AUTH_$project_id/$container/$object", see BlobLocation()
+	fields := strings.SplitN(location, "/", 3)
+	if len(fields) != 3 || !strings.HasPrefix(fields[0], "AUTH_") || fields[1] == "" || fields[2] == "" {
+		return 0, keppel.ErrCannotCopyBlob
+	}
+	source := d.mainAccount.SwitchAccount(fields[0]).Container(fields[1]).Object(fields[2])
+	lo, err := source.AsLargeObject(ctx)
+	if err != nil {
+		if schwift.Is(err, http.StatusNotFound) || schwift.Is(err, http.StatusForbidden) || schwift.Is(err, http.StatusUnauthorized) {
+			return 0, keppel.ErrCannotCopyBlob
+		}
+		return 0, err
+	}
+	segments, err := lo.Segments()
+	if err != nil {
+		return 0, err
+	}
+
+	// We cannot just copy the SLO manifest since its segments belong to the
+	// source blob and will be deleted with it. Instead, we copy each segment
+	// into a chunk of our own, and then assemble the chunks like FinalizeBlob()
+	// does for a regular upload.
+	c, _, err := d.getBackendConnection(ctx, account)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		sizeBytes  uint64
+		chunkCount uint32
+	)
+	for _, segment := range segments {
+		if segment.Object == nil || segment.RangeLength != 0 {
+			// we never write SLOs with ranges or inline data, so this was not written by Keppel
+			return 0, fmt.Errorf("cannot copy %s: unexpected segment layout", source.FullName())
+		}
+		chunkCount++
+		err := segment.Object.CopyTo(ctx, chunkObject(c, storageID, chunkCount), nil, nil)
+		if err != nil {
+			abortErr := d.AbortBlobUpload(ctx, account, storageID, chunkCount)
+			if abortErr != nil {
+				logg.Error("additional error encountered when aborting copy of %s into account %s: %s",
+					source.FullName(), account.Name, abortErr.Error())
+			}
+			return 0, err
+		}
+		sizeBytes += segment.SizeBytes
+	}
+
+	err = d.FinalizeBlob(ctx, account, storageID, chunkCount)
+	if err != nil {
+		abortErr := d.AbortBlobUpload(ctx, account, storageID, chunkCount)
+		if abortErr != nil {
+			logg.Error("additional error encountered when aborting copy of %s into account %s: %s",
+				source.FullName(), account.Name, abortErr.Error())
+		}
+		return 0, err
+	}
+	return sizeBytes, nil
+}
+
 func reportObjectErrorsIfAny(operation string, err error) {
 	if berr, ok := errext.As[schwift.BulkError](err); ok {
 		// When we return this `err` to the Keppel core, it will only look at
@@ -342,85 +628,159 @@ func (d *swiftDriver) DeleteManifest(ctx context.Context, account models.Reduced
 
 var (
 	// These regexes are used to reconstruct the storage ID from a blob's or chunk's object name.
-	// It's kinda the reverse of func blobObject() or func checkObject().
+	// It's kinda the reverse of func blobObject() or func chunkObject() or func segmentObject().
 	blobObjectNameRx  = regexp.MustCompile(`^_blobs/([^/]{2})/([^/]{2})/([^/]+)$`)
-	chunkObjectNameRx = regexp.MustCompile(`^_chunks/([^/]{2})/([^/]{2})/([^/]+)/([0-9]+)$`)
+	chunkObjectNameRx = regexp.MustCompile(`^_chunks/([^/]{2})/([^/]{2})/([^/]+)/([0-9]+)(?:-[0-9]+)?$`)
 	// This regex recovers the repo name and manifest digest from a manifest's object name.
 	// It's kinda the reverse of func manifestObject().
 	manifestObjectNameRx = regexp.MustCompile(`^(.+)/_manifests/([^/]+)$`)
 )
 
 // ListStorageContents implements the keppel.StorageDriver interface.
-func (d *swiftDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount) ([]keppel.StoredBlobInfo, []keppel.StoredManifestInfo, error) {
+func (d *swiftDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount, handleBlobs func([]keppel.StoredBlobInfo) error, handleManifests func([]keppel.StoredManifestInfo) error) error {
 	c, _, err := d.getBackendConnection(ctx, account)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
-	chunkCounts := make(map[string]uint32) // key = storage ID, value = same semantics as keppel.StoredBlobInfo.ChunkCount
-	var manifests []keppel.StoredManifestInfo
+	err = listBlobsAndChunks(ctx, c, keppel.StorageListingBatcher[keppel.StoredBlobInfo]{Callback: handleBlobs})
+	if err != nil {
+		return fmt.Errorf("while listing blobs in account %s: %w", account.Name, err)
+	}
 
-	err = c.Objects().Foreach(ctx, func(o *schwift.Object) error {
-		if match := blobObjectNameRx.FindStringSubmatch(o.Name()); match != nil {
-			storageID := match[1] + match[2] + match[3]
-			mergeChunkCount(chunkCounts, storageID, 0)
-			return nil
-		}
-		if match := chunkObjectNameRx.FindStringSubmatch(o.Name()); match != nil {
-			storageID := match[1] + match[2] + match[3]
-			chunkNumber, err := strconv.ParseUint(match[4], 10, 32)
-			if err != nil {
-				return fmt.Errorf("while parsing chunk object name %s: %s", o.Name(), err.Error())
+	// manifests are stored below the repository name, and repository names
+	// always start with a lowercase letter or digit (see models.RepoNameRx), so
+	// we can list them without going through all the blobs and chunks again
+	manifestBatcher := keppel.StorageListingBatcher[keppel.StoredManifestInfo]{Callback: handleManifests}
+	for _, firstChar := range "0123456789abcdefghijklmnopqrstuvwxyz" {
+		iter := c.Objects()
+		iter.Prefix = string(firstChar)
+		err = iter.Foreach(ctx, func(o *schwift.Object) error {
+			match := manifestObjectNameRx.FindStringSubmatch(o.Name())
+			if match == nil {
+				return fmt.Errorf("encountered unexpected object while listing storage contents of account %s: %s", account.Name, o.Name())
 			}
-			mergeChunkCount(chunkCounts, storageID, uint32(chunkNumber))
-			return nil
-		}
-		if match := manifestObjectNameRx.FindStringSubmatch(o.Name()); match != nil {
 			manifestDigest, err := digest.Parse(match[2])
 			if err != nil {
 				return err
 			}
-
-			manifests = append(manifests, keppel.StoredManifestInfo{
+			return manifestBatcher.Add(keppel.StoredManifestInfo{
 				RepoName: match[1],
 				Digest:   manifestDigest,
 			})
-			return nil
+		})
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("encountered unexpected object while listing storage contents of account %s: %s", account.Name, o.Name())
-	})
-	if err != nil {
-		return nil, nil, err
 	}
+	return manifestBatcher.Flush()
+}
 
-	blobs := make([]keppel.StoredBlobInfo, 0, len(chunkCounts))
-	for storageID, chunkCount := range chunkCounts {
-		blobs = append(blobs, keppel.StoredBlobInfo{
-			StorageID:  storageID,
-			ChunkCount: chunkCount,
-		})
+// The chunks of a finalized blob remain in place as the segments of its large
+// object, so a storage ID can appear both below "_blobs/" and "_chunks/". To
+// report each storage ID only once without holding the entire listing in
+// memory, we walk through both listings in parallel. This works because both
+// listings are sorted by storage ID (storage IDs all have the same length).
+func listBlobsAndChunks(ctx context.Context, c *schwift.Container, batcher keppel.StorageListingBatcher[keppel.StoredBlobInfo]) error {
+	blobs := swiftListingCursor{iter: c.Objects(), rx: blobObjectNameRx}
+	blobs.iter.Prefix = "_blobs/"
+	chunks := swiftListingCursor{iter: c.Objects(), rx: chunkObjectNameRx}
+	chunks.iter.Prefix = "_chunks/"
+
+	for {
+		blobMatch, err := blobs.Peek(ctx)
+		if err != nil {
+			return err
+		}
+		chunkMatch, err := chunks.Peek(ctx)
+		if err != nil {
+			return err
+		}
+		if blobMatch == nil && chunkMatch == nil {
+			return batcher.Flush()
+		}
+
+		// finalized blob: report with ChunkCount = 0 and skip over its chunks
+		if blobMatch != nil && (chunkMatch == nil || blobMatch.StorageID <= chunkMatch.StorageID) {
+			blobs.Advance()
+			for chunkMatch != nil && chunkMatch.StorageID == blobMatch.StorageID {
+				chunks.Advance()
+				chunkMatch, err = chunks.Peek(ctx)
+				if err != nil {
+					return err
+				}
+			}
+			err = batcher.Add(keppel.StoredBlobInfo{StorageID: blobMatch.StorageID})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// unfinalized blob: the highest chunk number is the chunk count
+		info := keppel.StoredBlobInfo{StorageID: chunkMatch.StorageID}
+		for chunkMatch != nil && chunkMatch.StorageID == info.StorageID {
+			info.ChunkCount = max(info.ChunkCount, chunkMatch.ChunkNumber)
+			chunks.Advance()
+			chunkMatch, err = chunks.Peek(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		err = batcher.Add(info)
+		if err != nil {
+			return err
+		}
 	}
+}
+
+// swiftListingCursor is used by listBlobsAndChunks() to walk through an object listing.
+type swiftListingCursor struct {
+	iter *schwift.ObjectIterator
+	rx   *regexp.Regexp
+	page []*schwift.Object
+	done bool
+}
 
-	return blobs, manifests, nil
+type swiftListingMatch struct {
+	StorageID   string
+	ChunkNumber uint32 // only for chunk objects
 }
 
-// See comment on keppel.StoredBlobInfo.ChunkCount for explanation of semantics.
-func mergeChunkCount(chunkCounts map[string]uint32, key string, chunkNumber uint32) {
-	prevCount, exists := chunkCounts[key]
-	if !exists {
-		// nothing to merge, just record the new value
-		chunkCounts[key] = chunkNumber
-		return
+// Peek returns the current object in the listing, or nil at the end of the listing.
+func (l *swiftListingCursor) Peek(ctx context.Context) (*swiftListingMatch, error) {
+	if len(l.page) == 0 && !l.done {
+		var err error
+		l.page, err = l.iter.NextPage(ctx, keppel.StorageListingBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		l.done = len(l.page) == 0
+	}
+	if l.done {
+		return nil, nil
 	}
 
-	// The value 0 indicates a finalized blob and therefore takes precedence over actual chunk numbers.
-	if prevCount == 0 || chunkNumber == 0 {
-		chunkCounts[key] = 0
-		return
+	o := l.page[0]
+	match := l.rx.FindStringSubmatch(o.Name())
+	if match == nil {
+		return nil, fmt.Errorf("encountered unexpected object: %s", o.Name())
+	}
+	result := swiftListingMatch{StorageID: match[1] + match[2] + match[3]}
+	if len(match) > 4 {
+		chunkNumber, err := strconv.ParseUint(match[4], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing chunk object name %s: %s", o.Name(), err.Error())
+		}
+		result.ChunkNumber = uint32(chunkNumber)
 	}
-	// If 0 is not involved, remember the largest chunk number as that's gonna be the chunk count.
-	if chunkNumber > prevCount {
-		chunkCounts[key] = chunkNumber
+	return &result, nil
+}
+
+// Advance moves past the current object in the listing.
+func (l *swiftListingCursor) Advance() {
+	if len(l.page) > 0 {
+		l.page = l.page[1:]
 	}
 }
 
@@ -449,3 +809,15 @@ func (d *swiftDriver) CleanupAccount(ctx context.Context, account models.Reduced
 	}
 	return c.Delete(ctx, nil)
 }
+
+// ClassifyError implements the keppel.StorageErrorClassifier interface.
+func (d *swiftDriver) ClassifyError(err error) keppel.StorageErrorClass {
+	switch {
+	case schwift.Is(err, http.StatusNotFound):
+		return keppel.StorageErrorNotFound
+	case schwift.Is(err, http.StatusUnauthorized), schwift.Is(err, http.StatusForbidden):
+		return keppel.StorageErrorAuthFailure
+	default:
+		return ""
+	}
+}
@@ -47,7 +47,7 @@ func (uid *userIdentity) PluginTypeID() string {
 }
 
 func (uid *userIdentity) HasPermission(perm keppel.Permission, tenantID string) bool {
-	return tenantID != ""
+	return tenantID != "" || perm == keppel.CanViewClusterStatus || perm == keppel.CanChangeClusterConfig
 }
 
 func (uid *userIdentity) UserInfo() audittools.UserInfo {
@@ -44,6 +44,9 @@ type StorageDriver struct {
 	blobChunkCounts   map[string]uint32 // previous chunkNumber for running upload, 0 when finished (same semantics as keppel.StoredBlobInfo.ChunkCount field)
 	manifests         map[string][]byte
 	ForbidNewAccounts bool
+	// If set, CopyBlobFromLocation() can copy blobs out of this other instance,
+	// to simulate two Keppel instances sharing the same storage backend.
+	SharedStorage *StorageDriver
 }
 
 // PluginTypeID implements the keppel.StorageDriver interface.
@@ -128,6 +131,15 @@ func (d *StorageDriver) ReadBlob(ctx context.Context, account models.ReducedAcco
 	return io.NopCloser(bytes.NewReader(contents)), uint64(len(contents)), nil
 }
 
+// ReadBlobRange implements the keppel.StorageDriver interface.
+func (d *StorageDriver) ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (io.ReadCloser, error) {
+	contents, exists := d.blobs[blobKey(account, storageID)]
+	if !exists {
+		return nil, errNoSuchBlob
+	}
+	return io.NopCloser(bytes.NewReader(contents[offset : offset+length])), nil
+}
+
 // URLForBlob implements the keppel.StorageDriver interface.
 func (d *StorageDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
 	return "", keppel.ErrCannotGenerateURL
@@ -145,6 +157,31 @@ func (d *StorageDriver) DeleteBlob(ctx context.Context, account models.ReducedAc
 	return nil
 }
 
+// BlobLocation implements the keppel.BlobCopyingStorageDriver interface.
+func (d *StorageDriver) BlobLocation(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
+	k := blobKey(account, storageID)
+	_, exists := d.blobs[k]
+	if !exists {
+		return "", errNoSuchBlob
+	}
+	return k, nil
+}
+
+// CopyBlobFromLocation implements the keppel.BlobCopyingStorageDriver interface.
+func (d *StorageDriver) CopyBlobFromLocation(ctx context.Context, account models.ReducedAccount, storageID, location string) (uint64, error) {
+	if d.SharedStorage == nil {
+		return 0, keppel.ErrCannotCopyBlob
+	}
+	contents, exists := d.SharedStorage.blobs[location]
+	if !exists || d.SharedStorage.blobChunkCounts[location] != 0 {
+		return 0, keppel.ErrCannotCopyBlob
+	}
+	k := blobKey(account, storageID)
+	d.blobs[k] = bytes.Clone(contents)
+	d.blobChunkCounts[k] = 0
+	return uint64(len(contents)), nil
+}
+
 // ReadManifest implements the keppel.StorageDriver interface.
 func (d *StorageDriver) ReadManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) ([]byte, error) {
 	k := manifestKey(account, repoName, manifestDigest)
@@ -174,7 +211,8 @@ func (d *StorageDriver) DeleteManifest(ctx context.Context, account models.Reduc
 }
 
 // ListStorageContents implements the keppel.StorageDriver interface.
-func (d *StorageDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount) ([]keppel.StoredBlobInfo, []keppel.StoredManifestInfo, error) {
+func (d *StorageDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount, handleBlobs func([]keppel.StoredBlobInfo) error, handleManifests func([]keppel.StoredManifestInfo) error) error {
+	// collect the listing before reporting it, since the callbacks may modify the storage
 	var (
 		blobs     []keppel.StoredBlobInfo
 		manifests []keppel.StoredManifestInfo
@@ -197,7 +235,7 @@ func (d *StorageDriver) ListStorageContents(ctx context.Context, account models.
 		if match != nil {
 			manifestDigest, err := digest.Parse(match[2])
 			if err != nil {
-				return nil, nil, err
+				return err
 			}
 			manifests = append(manifests, keppel.StoredManifestInfo{
 				RepoName: match[1],
@@ -206,7 +244,25 @@ func (d *StorageDriver) ListStorageContents(ctx context.Context, account models.
 		}
 	}
 
-	return blobs, manifests, nil
+	blobBatcher := keppel.StorageListingBatcher[keppel.StoredBlobInfo]{Callback: handleBlobs}
+	for _, blob := range blobs {
+		err := blobBatcher.Add(blob)
+		if err != nil {
+			return err
+		}
+	}
+	err := blobBatcher.Flush()
+	if err != nil {
+		return err
+	}
+	manifestBatcher := keppel.StorageListingBatcher[keppel.StoredManifestInfo]{Callback: handleManifests}
+	for _, manifest := range manifests {
+		err := manifestBatcher.Add(manifest)
+		if err != nil {
+			return err
+		}
+	}
+	return manifestBatcher.Flush()
 }
 
 // CanSetupAccount implements the keppel.StorageDriver interface.
@@ -221,21 +277,30 @@ func (d *StorageDriver) CanSetupAccount(ctx context.Context, account models.Redu
 func (d *StorageDriver) CleanupAccount(ctx context.Context, account models.ReducedAccount) error {
 	// double-check that cleanup order is right; when the account gets deleted,
 	// all blobs and manifests must have been deleted from it before
-	storedBlobs, storedManifests, err := d.ListStorageContents(ctx, account)
-	if len(storedBlobs) > 0 {
-		return fmt.Errorf(
-			"found undeleted blob during CleanupAccount: storageID = %q",
-			storedBlobs[0].StorageID,
-		)
-	}
-	if len(storedManifests) > 0 {
-		return fmt.Errorf(
-			"found undeleted manifest during CleanupAccount: %s@%s",
-			storedManifests[0].RepoName,
-			storedManifests[0].Digest,
-		)
-	}
-	return err
+	return d.ListStorageContents(ctx, account, failOnUndeletedBlobs, failOnUndeletedManifests)
+}
+
+func failOnUndeletedBlobs(storedBlobs []keppel.StoredBlobInfo) error {
+	return fmt.Errorf(
+		"found undeleted blob during CleanupAccount: storageID = %q",
+		storedBlobs[0].StorageID,
+	)
+}
+
+func failOnUndeletedManifests(storedManifests []keppel.StoredManifestInfo) error {
+	return fmt.Errorf(
+		"found undeleted manifest during CleanupAccount: %s@%s",
+		storedManifests[0].RepoName,
+		storedManifests[0].Digest,
+	)
+}
+
+// ClassifyError implements the keppel.StorageErrorClassifier interface.
+func (d *StorageDriver) ClassifyError(err error) keppel.StorageErrorClass {
+	if errors.Is(err, errNoSuchBlob) || errors.Is(err, errNoSuchManifest) {
+		return keppel.StorageErrorNotFound
+	}
+	return ""
 }
 
 // BlobCount returns how many blobs exist in this storage driver. This is mostly
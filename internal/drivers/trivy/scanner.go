@@ -0,0 +1,137 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package trivy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/osext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/trivy"
+)
+
+var (
+	endpointRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_trivy_endpoint_requests",
+			Help: "Counts scan requests sent to each Trivy proxy endpoint.",
+		},
+		[]string{"endpoint"},
+	)
+	endpointErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_trivy_endpoint_errors",
+			Help: "Counts scan requests to each Trivy proxy endpoint that failed.",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+// ScannerDriver is the scanner driver "trivy". It talks to one or more Trivy
+// servers through trivy-proxy deployments.
+type ScannerDriver struct {
+	// one entry for each trivy-proxy endpoint; scans are distributed among them in a round-robin fashion
+	Endpoints []trivy.Config
+	// index into Endpoints where the next scan starts
+	nextEndpoint atomic.Uint64
+}
+
+func init() {
+	keppel.ScannerDriverRegistry.Add(func() keppel.ScannerDriver { return &ScannerDriver{} })
+	prometheus.MustRegister(endpointRequestsCounter)
+	prometheus.MustRegister(endpointErrorsCounter)
+}
+
+// PluginTypeID implements the keppel.ScannerDriver interface.
+func (d *ScannerDriver) PluginTypeID() string { return "trivy" }
+
+// Init implements the keppel.ScannerDriver interface.
+func (d *ScannerDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	urlsStr, err := osext.NeedGetenv("KEPPEL_TRIVY_URL")
+	if err != nil {
+		return err
+	}
+	token, err := osext.NeedGetenv("KEPPEL_TRIVY_TOKEN")
+	if err != nil {
+		return err
+	}
+
+	d.Endpoints = nil
+	for _, urlStr := range strings.Split(urlsStr, ",") {
+		trivyURL, err := url.Parse(strings.TrimSpace(urlStr))
+		if err != nil {
+			return fmt.Errorf("malformed KEPPEL_TRIVY_URL: %w", err)
+		}
+		if trivyURL.Host == "" {
+			return fmt.Errorf("malformed KEPPEL_TRIVY_URL: %q is not an absolute URL", urlStr)
+		}
+		d.Endpoints = append(d.Endpoints, trivy.Config{
+			Token: token,
+			URL:   *trivyURL,
+		})
+	}
+	return nil
+}
+
+// SupportsReportFormat implements the keppel.ScannerDriver interface.
+func (d *ScannerDriver) SupportsReportFormat(format string) bool {
+	// all formats offered by the Keppel API are native Trivy formats
+	return true
+}
+
+// ScanManifest implements the keppel.ScannerDriver interface.
+//
+// If there are multiple endpoints, each scan starts at the next endpoint in
+// turn. When an endpoint fails, the scan is retried on the next one.
+func (d *ScannerDriver) ScanManifest(ctx context.Context, keppelToken string, manifestRef models.ImageReference, format string) (trivy.ReportPayload, error) {
+	if len(d.Endpoints) == 0 {
+		return trivy.ReportPayload{}, errors.New("no Trivy endpoints configured")
+	}
+
+	numEndpoints := uint64(len(d.Endpoints))
+	start := d.nextEndpoint.Add(1) - 1
+	var lastErr error
+	for offset := range numEndpoints {
+		endpoint := d.Endpoints[(start+offset)%numEndpoints]
+		endpointName := endpoint.URL.Host
+		endpointRequestsCounter.WithLabelValues(endpointName).Inc()
+
+		report, err := endpoint.ScanManifest(ctx, keppelToken, manifestRef, format)
+		if err == nil {
+			return report, nil
+		}
+		endpointErrorsCounter.WithLabelValues(endpointName).Inc()
+		lastErr = err
+
+		if numEndpoints == 1 || ctx.Err() != nil {
+			return trivy.ReportPayload{}, err
+		}
+		logg.Info("scan of %s failed on Trivy endpoint %s (will try next endpoint): %s", manifestRef, endpointName, err.Error())
+	}
+	return trivy.ReportPayload{}, fmt.Errorf("scan failed on all %d Trivy endpoints (last error: %w)", numEndpoints, lastErr)
+}
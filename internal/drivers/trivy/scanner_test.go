@@ -0,0 +1,96 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package trivy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestScannerDriverWithMultipleEndpoints(t *testing.T) {
+	var (
+		healthyRequests   int
+		unhealthyRequests int
+	)
+	healthyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyRequests++
+		if r.Header.Get("Trivy-Token") != "secret" {
+			http.Error(w, "wrong token", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"image":"` + r.URL.Query().Get("image") + `"}`))
+	}))
+	defer healthyServer.Close()
+	unhealthyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unhealthyRequests++
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer unhealthyServer.Close()
+
+	ctx := context.Background()
+	t.Setenv("KEPPEL_TRIVY_TOKEN", "secret")
+	t.Setenv("KEPPEL_TRIVY_URL", healthyServer.URL+", "+unhealthyServer.URL)
+	sd, err := keppel.NewScannerDriver(ctx, "trivy", keppel.Configuration{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "number of endpoints", len(sd.(*ScannerDriver).Endpoints), 2)
+
+	imageRef, _, err := models.ParseImageReference("registry.example.org/test1/foo:latest")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// scans alternate between both endpoints, and scans starting at the unhealthy endpoint fail over to the healthy one
+	for range 4 {
+		report, err := sd.ScanManifest(ctx, "token", imageRef, "json")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.DeepEqual(t, "report", string(report.Contents), `{"image":"`+imageRef.String()+`"}`)
+	}
+	assert.DeepEqual(t, "requests to healthy endpoint", healthyRequests, 4)
+	assert.DeepEqual(t, "requests to unhealthy endpoint", unhealthyRequests, 2)
+
+	// when all endpoints fail, the error from the last attempt is reported
+	t.Setenv("KEPPEL_TRIVY_URL", unhealthyServer.URL+","+unhealthyServer.URL)
+	sd, err = keppel.NewScannerDriver(ctx, "trivy", keppel.Configuration{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = sd.ScanManifest(ctx, "token", imageRef, "json")
+	if err == nil || !strings.Contains(err.Error(), "scan failed on all 2 Trivy endpoints (last error: trivy proxy did not return 200: 503 overloaded)") {
+		t.Errorf("expected failure on all endpoints, but got %v", err)
+	}
+
+	// malformed URLs are rejected
+	t.Setenv("KEPPEL_TRIVY_URL", healthyServer.URL+",not-a-url")
+	_, err = keppel.NewScannerDriver(ctx, "trivy", keppel.Configuration{})
+	if err == nil {
+		t.Error("expected error for malformed KEPPEL_TRIVY_URL, but got none")
+	}
+}
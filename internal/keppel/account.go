@@ -32,6 +32,13 @@ type Account struct {
 	State             string                `json:"state,omitempty"`
 	ValidationPolicy  *ValidationPolicy     `json:"validation,omitempty"`
 	PlatformFilter    models.PlatformFilter `json:"platform_filter,omitempty"`
+	PullPolicy        *PullPolicy           `json:"pull_policy,omitempty"`
+	QuarantinePolicy  *QuarantinePolicy     `json:"quarantine,omitempty"`
+	ReadOnly          bool                  `json:"read_only,omitempty"`
+	// only rendered for managed accounts with state "deletion_scheduled"
+	DeletionScheduledAt *int64 `json:"deletion_scheduled_at,omitempty"`
+	// experimental
+	ImageTransformations []models.ImageTransformation `json:"image_transformations,omitempty"`
 
 	// TODO: deprecated, and remove
 	InMaintenance bool               `json:"in_maintenance"`
@@ -53,8 +60,13 @@ func RenderAccount(dbAccount models.Account) (Account, error) {
 		rbacPolicies = []RBACPolicy{}
 	}
 	var state string
-	if dbAccount.IsDeleting {
+	var deletionScheduledAt *int64
+	switch {
+	case dbAccount.IsDeleting:
 		state = "deleting"
+	case dbAccount.DeletionScheduledAt != nil:
+		state = "deletion_scheduled"
+		deletionScheduledAt = MaybeTimeToUnix(dbAccount.DeletionScheduledAt)
 	}
 
 	return Account{
@@ -64,8 +76,14 @@ func RenderAccount(dbAccount models.Account) (Account, error) {
 		State:             state,
 		RBACPolicies:      rbacPolicies,
 		ReplicationPolicy: RenderReplicationPolicy(dbAccount),
-		ValidationPolicy:  RenderValidationPolicy(dbAccount.Reduced()),
+		ValidationPolicy:  RenderValidationPolicy(dbAccount),
 		PlatformFilter:    dbAccount.PlatformFilter,
-		InMaintenance:     dbAccount.InMaintenance,
+		PullPolicy:        RenderPullPolicy(dbAccount.Reduced()),
+		QuarantinePolicy:  RenderQuarantinePolicy(dbAccount.Reduced()),
+		ReadOnly:          dbAccount.IsReadOnly,
+
+		DeletionScheduledAt:  deletionScheduledAt,
+		ImageTransformations: dbAccount.Reduced().SplitImageTransformations(),
+		InMaintenance:        dbAccount.InMaintenance,
 	}, nil
 }
@@ -0,0 +1,212 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+const (
+	// DSSEEnvelopeMediaType is the media type of layers in cosign attestation
+	// manifests. These layers contain a DSSE envelope with a signed in-toto statement.
+	DSSEEnvelopeMediaType = "application/vnd.dsse.envelope.v1+json"
+	// InTotoPayloadType is the payload type of DSSE envelopes that contain an in-toto statement.
+	InTotoPayloadType = "application/vnd.in-toto+json"
+	// CosignPredicateTypeAnnotation is the layer annotation in cosign
+	// attestation manifests that holds the predicate type of the attestation.
+	CosignPredicateTypeAnnotation = "predicateType"
+)
+
+// CosignAttestationTagName returns the name of the tag under which cosign
+// stores the attestations for the manifest with the given digest.
+func CosignAttestationTagName(manifestDigest digest.Digest) string {
+	return fmt.Sprintf("%s-%s.att", manifestDigest.Algorithm(), manifestDigest.Encoded())
+}
+
+// ParseCosignAttestationTagName is the inverse of CosignAttestationTagName. It
+// returns false if the given tag name is not a cosign attestation tag.
+func ParseCosignAttestationTagName(tagName string) (digest.Digest, bool) {
+	if !strings.HasSuffix(tagName, ".att") || !IsCosignArtifactTagName(tagName) {
+		return "", false
+	}
+	algorithm, encoded, _ := strings.Cut(strings.TrimSuffix(tagName, ".att"), "-")
+	d := digest.NewDigestFromEncoded(digest.Algorithm(algorithm), encoded)
+	if d.Validate() != nil {
+		return "", false
+	}
+	return d, true
+}
+
+// AttestationPolicy represents the "require_attestations" section of a validation policy in the API.
+type AttestationPolicy struct {
+	PredicateTypes    []string `json:"predicate_types"`
+	TrustedPublicKeys []string `json:"trusted_public_keys"`
+}
+
+// RenderAttestationPolicy builds an AttestationPolicy object out of the
+// information in the given account model.
+func RenderAttestationPolicy(account models.Account) *AttestationPolicy {
+	if account.RequiredAttestationTypes == "" {
+		return nil
+	}
+
+	var keys []string
+	rest := []byte(account.AttestationPublicKeys)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		keys = append(keys, strings.TrimSpace(string(pem.EncodeToMemory(block))))
+	}
+
+	return &AttestationPolicy{
+		PredicateTypes:    account.Reduced().SplitRequiredAttestationTypes(),
+		TrustedPublicKeys: keys,
+	}
+}
+
+// ApplyToAccount validates this policy and stores it in the given account model.
+//
+// WARNING: The replication policy must be applied to the account model before
+// this, since attestation requirements are not supported on replica accounts.
+func (a AttestationPolicy) ApplyToAccount(account *models.Account) *RegistryV2Error {
+	if len(a.PredicateTypes) == 0 {
+		err := errors.New(`attestation requirement needs at least one predicate type`)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+	for _, predicateType := range a.PredicateTypes {
+		if predicateType == "" || strings.ContainsAny(predicateType, ", ") {
+			err := fmt.Errorf(`invalid predicate type for attestation requirement: %q`, predicateType)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+	}
+	if len(a.TrustedPublicKeys) == 0 {
+		err := errors.New(`attestation requirement needs at least one trusted public key`)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+	if account.UpstreamPeerHostName != "" || account.ExternalPeerURL != "" {
+		err := errors.New(`attestation requirement is only allowed on primary accounts`)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+
+	// normalize all keys into a single PEM bundle
+	var bundle []string
+	for idx, keyPEM := range a.TrustedPublicKeys {
+		keys, err := ParseSignaturePublicKeys(keyPEM)
+		if err == nil && len(keys) != 1 {
+			err = fmt.Errorf("expected exactly one PEM block, but found %d", len(keys))
+		}
+		if err != nil {
+			err = fmt.Errorf(`invalid trusted public key for attestations at index %d: %w`, idx, err)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+		der, err := x509.MarshalPKIXPublicKey(keys[0])
+		if err != nil {
+			return AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+		}
+		bundle = append(bundle, strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))))
+	}
+
+	account.RequiredAttestationTypes = strings.Join(a.PredicateTypes, ",")
+	account.AttestationPublicKeys = strings.Join(bundle, "\n")
+	return nil
+}
+
+// The contents of a layer in a cosign attestation manifest.
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// The payload of a DSSE envelope with payload type InTotoPayloadType.
+// The predicate itself is not interesting to us.
+type inTotoStatement struct {
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+}
+
+// VerifyCosignAttestation checks that the given DSSE envelope contains an
+// in-toto statement about the manifest with the given digest, and that the
+// envelope was signed by one of the given keys. On success, the predicate type
+// of the statement is returned.
+func VerifyCosignAttestation(manifestDigest digest.Digest, envelopeBytes []byte, keys []crypto.PublicKey) (string, error) {
+	var envelope dsseEnvelope
+	err := json.Unmarshal(envelopeBytes, &envelope)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse DSSE envelope: %w", err)
+	}
+	if envelope.PayloadType != InTotoPayloadType {
+		return "", fmt.Errorf("unexpected payload type in DSSE envelope: %q", envelope.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode DSSE payload: %w", err)
+	}
+
+	// the signature is over the "pre-authentication encoding" of the payload
+	pae := fmt.Appendf(nil, "DSSEv1 %d %s %d ", len(envelope.PayloadType), envelope.PayloadType, len(payload))
+	pae = append(pae, payload...)
+	isSigned := false
+	for _, sig := range envelope.Signatures {
+		signature, err := base64.StdEncoding.DecodeString(sig.Sig)
+		if err == nil && isSignedByAnyOf(pae, signature, keys) {
+			isSigned = true
+			break
+		}
+	}
+	if !isSigned {
+		return "", errors.New("DSSE envelope is not signed by any of the trusted public keys")
+	}
+
+	var statement inTotoStatement
+	err = json.Unmarshal(payload, &statement)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse in-toto statement: %w", err)
+	}
+	if statement.PredicateType == "" {
+		return "", errors.New("in-toto statement does not have a predicate type")
+	}
+	for _, subject := range statement.Subject {
+		if subject.Digest[manifestDigest.Algorithm().String()] == manifestDigest.Encoded() {
+			return statement.PredicateType, nil
+		}
+	}
+	return "", fmt.Errorf("in-toto statement does not refer to manifest %q", manifestDigest)
+}
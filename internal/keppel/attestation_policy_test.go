@@ -0,0 +1,167 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestCosignAttestationTagNames(t *testing.T) {
+	d := digest.Canonical.FromString("hello")
+	tagName := CosignAttestationTagName(d)
+	assert.DeepEqual(t, "tag name", tagName, "sha256-"+d.Encoded()+".att")
+
+	parsed, ok := ParseCosignAttestationTagName(tagName)
+	assert.DeepEqual(t, "ParseCosignAttestationTagName ok", ok, true)
+	assert.DeepEqual(t, "ParseCosignAttestationTagName digest", parsed, d)
+
+	for _, name := range []string{"latest", CosignSignatureTagName(d), "sha256-1234.att"} {
+		_, ok := ParseCosignAttestationTagName(name)
+		assert.DeepEqual(t, "ParseCosignAttestationTagName ok for "+name, ok, false)
+	}
+}
+
+func TestAttestationPolicyApplyAndRender(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keyPEM := mustMarshalPublicKeyPEM(t, &key.PublicKey)
+
+	// happy path
+	policy := AttestationPolicy{
+		PredicateTypes:    []string{"https://slsa.dev/provenance/v1", "https://cosign.sigstore.dev/attestation/vuln/v1"},
+		TrustedPublicKeys: []string{"\n" + keyPEM + "\n"},
+	}
+	var account models.Account
+	rerr := policy.ApplyToAccount(&account)
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	assert.DeepEqual(t, "RequiredAttestationTypes", account.RequiredAttestationTypes,
+		"https://slsa.dev/provenance/v1,https://cosign.sigstore.dev/attestation/vuln/v1")
+	policy.TrustedPublicKeys = []string{keyPEM}
+	assert.DeepEqual(t, "rendered policy", *RenderAttestationPolicy(account), policy)
+	if RenderAttestationPolicy(models.Account{}) != nil {
+		t.Error("expected no attestation policy to be rendered for an empty account")
+	}
+
+	// error cases
+	errorCases := []struct {
+		Policy        AttestationPolicy
+		Account       models.Account
+		ExpectedError string
+	}{
+		{
+			Policy:        AttestationPolicy{TrustedPublicKeys: []string{keyPEM}},
+			ExpectedError: `attestation requirement needs at least one predicate type`,
+		},
+		{
+			Policy:        AttestationPolicy{PredicateTypes: []string{"foo,bar"}, TrustedPublicKeys: []string{keyPEM}},
+			ExpectedError: `invalid predicate type for attestation requirement: "foo,bar"`,
+		},
+		{
+			Policy:        AttestationPolicy{PredicateTypes: []string{"foo"}},
+			ExpectedError: `attestation requirement needs at least one trusted public key`,
+		},
+		{
+			Policy:        AttestationPolicy{PredicateTypes: []string{"foo"}, TrustedPublicKeys: []string{"foo"}},
+			ExpectedError: `invalid trusted public key for attestations at index 0: not a PEM-encoded public key`,
+		},
+		{
+			Policy:        AttestationPolicy{PredicateTypes: []string{"foo"}, TrustedPublicKeys: []string{keyPEM}},
+			Account:       models.Account{ExternalPeerURL: "registry.example.org"},
+			ExpectedError: `attestation requirement is only allowed on primary accounts`,
+		},
+	}
+	for _, tc := range errorCases {
+		account := tc.Account
+		rerr := tc.Policy.ApplyToAccount(&account)
+		if rerr == nil {
+			t.Errorf("expected error %q, but got none", tc.ExpectedError)
+		} else {
+			assert.DeepEqual(t, "error message", rerr.Error(), tc.ExpectedError)
+		}
+	}
+}
+
+func TestVerifyCosignAttestation(t *testing.T) {
+	trustedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	untrustedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keys, err := ParseSignaturePublicKeys(mustMarshalPublicKeyPEM(t, &trustedKey.PublicKey))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	manifestDigest := digest.Canonical.FromString("manifest")
+	makeEnvelope := func(key *ecdsa.PrivateKey, d digest.Digest, payloadType string) []byte {
+		statement := fmt.Sprintf(`{"_type":"https://in-toto.io/Statement/v0.1","predicateType":"https://slsa.dev/provenance/v1","subject":[{"name":"foo","digest":{"sha256":%q}}],"predicate":{}}`, d.Encoded())
+		hash := sha256.Sum256(fmt.Appendf(nil, "DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(statement), statement))
+		signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		buf, err := json.Marshal(dsseEnvelope{
+			PayloadType: payloadType,
+			Payload:     base64.StdEncoding.EncodeToString([]byte(statement)),
+			Signatures: []struct {
+				KeyID string `json:"keyid"`
+				Sig   string `json:"sig"`
+			}{{Sig: base64.StdEncoding.EncodeToString(signature)}},
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return buf
+	}
+
+	// happy path
+	predicateType, err := VerifyCosignAttestation(manifestDigest, makeEnvelope(trustedKey, manifestDigest, InTotoPayloadType), keys)
+	if err != nil {
+		t.Errorf("expected attestation to be valid, but got: %s", err.Error())
+	}
+	assert.DeepEqual(t, "predicate type", predicateType, "https://slsa.dev/provenance/v1")
+
+	// error cases
+	_, err = VerifyCosignAttestation(manifestDigest, makeEnvelope(untrustedKey, manifestDigest, InTotoPayloadType), keys)
+	assert.DeepEqual(t, "error for untrusted key", err.Error(), "DSSE envelope is not signed by any of the trusted public keys")
+	otherDigest := digest.Canonical.FromString("other")
+	_, err = VerifyCosignAttestation(manifestDigest, makeEnvelope(trustedKey, otherDigest, InTotoPayloadType), keys)
+	assert.DeepEqual(t, "error for other manifest", err.Error(), fmt.Sprintf("in-toto statement does not refer to manifest %q", manifestDigest))
+	_, err = VerifyCosignAttestation(manifestDigest, makeEnvelope(trustedKey, manifestDigest, "text/plain"), keys)
+	assert.DeepEqual(t, "error for wrong payload type", err.Error(), `unexpected payload type in DSSE envelope: "text/plain"`)
+}
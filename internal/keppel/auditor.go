@@ -20,12 +20,16 @@ package keppel
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"os"
+	"sync"
 
 	"github.com/sapcc/go-api-declarations/bininfo"
 	"github.com/sapcc/go-bits/audittools"
 	"github.com/sapcc/go-bits/logg"
+
+	"github.com/sapcc/keppel/internal/models"
 )
 
 // AuditContext collects arguments that business logic methods need only for
@@ -45,11 +49,61 @@ func InitAuditTrail(ctx context.Context) (audittools.Auditor, error) {
 	} else {
 		return audittools.NewAuditor(ctx, audittools.AuditorOpts{
 			EnvPrefix: "KEPPEL_AUDIT_RABBITMQ",
-			Observer: audittools.Observer{
-				TypeURI: "service/docker-registry",
-				Name:    bininfo.Component(),
-				ID:      audittools.GenerateUUID(),
-			},
+			Observer:  getAuditObserver(),
 		})
 	}
 }
+
+var getAuditObserver = sync.OnceValue(func() audittools.Observer {
+	return audittools.Observer{
+		TypeURI: "service/docker-registry",
+		Name:    bininfo.Component(),
+		ID:      audittools.GenerateUUID(),
+	}
+})
+
+// AccountScopedAuditTarget is implemented by audittools.Target types that
+// refer to an account or to an object within an account.
+type AccountScopedAuditTarget interface {
+	audittools.Target
+	AuditAccountName() models.AccountName
+}
+
+// PersistAuditEvents wraps the given Auditor such that, in addition to being
+// forwarded to it, all events with an AccountScopedAuditTarget are stored in
+// the `audit_events` table. This is used if Configuration.AuditEventRetention
+// is set.
+func PersistAuditEvents(inner audittools.Auditor, db *DB) audittools.Auditor {
+	return persistingAuditor{inner, db}
+}
+
+type persistingAuditor struct {
+	Inner audittools.Auditor
+	DB    *DB
+}
+
+// Record implements the audittools.Auditor interface.
+func (a persistingAuditor) Record(event audittools.Event) {
+	a.Inner.Record(event)
+
+	target, ok := event.Target.(AccountScopedAuditTarget)
+	if !ok {
+		return
+	}
+	accountName := target.AuditAccountName()
+
+	buf, err := json.Marshal(event.ToCADF(getAuditObserver().ToCADF()))
+	if err != nil {
+		logg.Error("could not serialize audit event for account %q: %s", accountName, err.Error())
+		return
+	}
+	err = a.DB.Insert(&models.AuditEvent{
+		AccountName: accountName,
+		RecordedAt:  event.Time,
+		Action:      string(event.Action),
+		CADFJSON:    string(buf),
+	})
+	if err != nil {
+		logg.Error("could not persist audit event for account %q: %s", accountName, err.Error())
+	}
+}
@@ -48,6 +48,16 @@ const (
 	CanViewQuotas Permission = "viewquota"
 	// CanChangeQuotas is the permission for changing an auth tenant's quotas.
 	CanChangeQuotas Permission = "changequota"
+	// CanViewClusterStatus is the permission for viewing the status of the
+	// Keppel deployment as a whole, e.g. the status of janitor jobs. Since this
+	// permission does not pertain to a single auth tenant, it is always checked
+	// with an empty tenant ID.
+	CanViewClusterStatus Permission = "viewcluster"
+	// CanChangeClusterConfig is the permission for changing configuration that
+	// applies to the Keppel deployment as a whole, e.g. the instance-wide
+	// blocklist of digests. Like CanViewClusterStatus, it is always checked
+	// with an empty tenant ID.
+	CanChangeClusterConfig Permission = "changecluster"
 )
 
 // AuthDriver represents an authentication backend that supports multiple
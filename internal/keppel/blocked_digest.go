@@ -0,0 +1,71 @@
+/*******************************************************************************
+*
+* Copyright 2026 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppel
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// If a digest is blocked both instance-wide and in the account, the
+// instance-wide entry takes precedence since it is usually the more severe one.
+var findBlockedDigestQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM blocked_digests
+	 WHERE digest = $1 AND (account_name IS NULL OR account_name = $2)
+	 ORDER BY account_name NULLS FIRST
+	 LIMIT 1
+`)
+
+// FindBlockedDigest returns the blocklist entry that applies to the given
+// digest within the given account, or nil if the digest is not blocked.
+func FindBlockedDigest(db gorp.SqlExecutor, accountName models.AccountName, d digest.Digest) (*models.BlockedDigest, error) {
+	var entry models.BlockedDigest
+	err := db.SelectOne(&entry, findBlockedDigestQuery, d.String(), accountName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// CheckDigestNotBlocked returns a RegistryV2Error if the given digest is
+// blocked within the given account, either by the account's own blocklist or
+// by the instance-wide blocklist. Blocked digests may neither be pulled nor pushed.
+func CheckDigestNotBlocked(db gorp.SqlExecutor, accountName models.AccountName, d digest.Digest) error {
+	entry, err := FindBlockedDigest(db, accountName, d)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return nil
+	}
+	msg := fmt.Sprintf("%s is blocked by policy: %s", d, entry.Reason)
+	return ErrDenied.With(msg).WithStatus(http.StatusUnavailableForLegalReasons)
+}
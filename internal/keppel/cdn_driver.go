@@ -0,0 +1,74 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/pluggable"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// CDNDriver is the abstract interface for a content delivery network that is
+// fronting the storage backend. When configured, blob pulls can be redirected
+// to the CDN instead of being served by keppel-api or the storage backend
+// directly.
+type CDNDriver interface {
+	pluggable.Plugin
+	// Init is called before any other interface methods, and allows the plugin to
+	// perform first-time initialization.
+	//
+	// Implementations should inspect the auth driver to ensure that the
+	// federation driver can work with this authentication method, or return
+	// ErrAuthDriverMismatch otherwise.
+	Init(AuthDriver, Configuration) error
+
+	// URLForBlob returns a signed URL through which the CDN serves the given
+	// blob. If the CDN shall not be used for this account or this blob,
+	// ErrCannotGenerateURL shall be returned to instruct the caller to fall back
+	// to StorageDriver.URLForBlob() or StorageDriver.ReadBlob().
+	//
+	// time.Now() is given in the last argument to allow for tests to use an
+	// artificial wall clock.
+	URLForBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob, now time.Time) (string, error)
+	// InvalidateBlob is called after a blob has been deleted from the storage.
+	// The implementation shall ensure that the CDN does not serve cached copies
+	// of this blob anymore. For accounts where the CDN is not used, this shall be
+	// a no-op.
+	InvalidateBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob) error
+}
+
+// CDNDriverRegistry is a pluggable.Registry for CDNDriver implementations.
+var CDNDriverRegistry pluggable.Registry[CDNDriver]
+
+// NewCDNDriver creates a new CDNDriver using one of the plugins registered
+// with CDNDriverRegistry.
+func NewCDNDriver(pluginTypeID string, ad AuthDriver, cfg Configuration) (CDNDriver, error) {
+	logg.Debug("initializing CDN driver %q...", pluginTypeID)
+
+	cdn := CDNDriverRegistry.Instantiate(pluginTypeID)
+	if cdn == nil {
+		return nil, errors.New("no such CDN driver: " + pluginTypeID)
+	}
+	return cdn, cdn.Init(ad, cfg)
+}
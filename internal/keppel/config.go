@@ -20,14 +20,20 @@
 package keppel
 
 import (
+	"bytes"
 	"crypto"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
@@ -37,7 +43,7 @@ import (
 	"github.com/sapcc/go-bits/must"
 	"github.com/sapcc/go-bits/osext"
 
-	"github.com/sapcc/keppel/internal/trivy"
+	"github.com/sapcc/keppel/internal/models"
 )
 
 // Configuration contains all configuration values that are not specific to a
@@ -47,7 +53,107 @@ type Configuration struct {
 	AnycastAPIPublicHostname string
 	JWTIssuerKeys            []crypto.PrivateKey
 	AnycastJWTIssuerKeys     []crypto.PrivateKey
-	Trivy                    *trivy.Config
+	// If not empty, keppel-api is mounted below this path (e.g. "/registry") by
+	// a reverse proxy. This is always normalized to have a leading slash and no
+	// trailing slash (see ParseAPIPathPrefix).
+	APIPathPrefix string
+	// If non-nil, vulnerability scanning is enabled and uses this driver. This
+	// is not filled by ParseConfiguration() since the driver itself is
+	// initialized with the Configuration; see GetScannerDriverNameFromEnvironment().
+	Scanner ScannerDriver
+	// Repos that the scanner is allowed to pull from in addition to the repo
+	// containing the scanned image (e.g. for mirrors of vulnerability databases).
+	ScannerAdditionalPullableRepos []string
+	// If non-nil, keppel-api counts egress bytes per auth tenant and
+	// keppel-janitor periodically reports storage and egress usage through this
+	// driver. Like Scanner, this is not filled by ParseConfiguration(); see
+	// GetUsageReportDriverNameFromEnvironment().
+	UsageReporter UsageReportDriver
+	// If non-nil, each pushed manifest is checked with this driver before it
+	// is stored. Like Scanner, this is not filled by ParseConfiguration(); see
+	// GetManifestValidationDriverNameFromEnvironment().
+	ManifestValidator ManifestValidationDriver
+	// If non-zero, audit events for accounts are persisted in the database and
+	// kept for this long, so that they can be retrieved through the Keppel API.
+	AuditEventRetention time.Duration
+	// If non-zero, keppel-janitor generates a report of end-of-life images at
+	// this interval (see EOLReportMaxImageAge).
+	EOLReportInterval time.Duration
+	// If non-zero, images whose newest layer is older than this are included in
+	// the EOL report, in addition to those with vulnerability status "Rotten".
+	EOLReportMaxImageAge time.Duration
+	// If not empty, each EOL report is POSTed to this URL.
+	EOLReportWebhookURL string
+	// If non-zero, managed accounts that disappear from the account management
+	// driver's configuration are only marked for deletion after this period.
+	ManagedAccountDeletionGracePeriod time.Duration
+	// If not empty, a notification is POSTed to this URL when the deletion of a
+	// managed account is scheduled.
+	ManagedAccountDeletionWebhookURL string
+	// Platforms that all image indexes are expected to contain, unless the
+	// respective account has a platform filter (see tasks.ManifestPlatformCheckJob).
+	RequiredPlatforms models.PlatformFilter
+	// If true, the entire Keppel instance is in read-only mode (e.g. during a
+	// storage migration). See models.Account.IsReadOnly for details.
+	ReadOnlyMode bool
+	// If true, our peers use the same storage backend as we do, so blobs can be
+	// replicated from them by copying within the storage backend if the
+	// StorageDriver implements BlobCopyingStorageDriver.
+	PeersShareStorage bool
+	// If true, manifests listed in an image index under a referrers fallback tag
+	// (see ReferrersFallbackTagName) are recorded as referrers of the manifest
+	// named by that tag, unless they declare a subject of their own.
+	NormalizeReferrersFallbackTags bool
+	// If true, the registry API implements the additions from version 1.1 of
+	// the OCI Distribution Spec that are not part of the original Registry V2
+	// API, most notably the Referrers API.
+	EnableOCIDistributionSpecV11 bool
+	// Accounts whose auth challenges point to a different token endpoint.
+	AuthRealmOverrides map[models.AccountName]AuthRealmOverride
+	// If non-zero, keppel-api caches account lookups for authorization for
+	// this long (see DB.EnableAccountCache).
+	AccountCacheTTL time.Duration
+	// If true, keppel-api counts pulls for each repository, and keppel-janitor
+	// exports per-account storage metrics and per-repository pull metrics (see
+	// tasks.AccountMetricsJob).
+	AccountMetricsEnabled bool
+	// The maximum number of accounts (and repositories, respectively) for which
+	// the metrics described above are exported. The largest accounts and the
+	// most-pulled repositories take precedence.
+	AccountMetricsMaxSeries int
+	// If true, keppel-api counts pulls for each manifest and day, which is
+	// required for GC policies with a pull count constraint.
+	ManifestPullCountsEnabled bool
+	// If positive, keppel-janitor performs at most this many operations per
+	// second on the storage backend, summed over all its jobs (see
+	// ThrottleStorageDriver).
+	JanitorStorageOpsPerSecond float64
+	// How many tags and manifests per repository may fail to sync before the
+	// manifest sync of the repository as a whole is considered failed.
+	JanitorManifestSyncErrorTolerance int
+	// The number of goroutines that keppel-janitor runs for each of the jobs
+	// listed in ConcurrentJanitorJobs, keyed by job name. Jobs that are not
+	// listed here run with their default concurrency.
+	JanitorConcurrency map[string]uint32
+	// If not empty, keppel-api reads the client certificates of peers from this
+	// request header (as set by a TLS-terminating reverse proxy) when the
+	// connection itself was not made through TLS.
+	PeerClientCertHeader string
+	// If not nil, blob contents are moved into the storage location of this
+	// pseudo-account by tasks.GlobalBlobDeduplicationJob, and blobs with the
+	// same digest in different accounts share the same storage object there
+	// (see BlobStorageLocation).
+	GlobalBlobStore *models.ReducedAccount
+}
+
+// AuthRealmOverride appears in type Configuration. It replaces the realm (and
+// optionally the service) that is advertised in the Www-Authenticate header
+// for requests concerning a particular account.
+type AuthRealmOverride struct {
+	// The URL of the token endpoint that clients shall use.
+	Realm string `json:"realm"`
+	// If empty, the service value that Keppel would usually advertise is used.
+	Service string `json:"service,omitempty"`
 }
 
 var (
@@ -86,6 +192,45 @@ func ParseIssuerKey(in string) (crypto.PrivateKey, error) {
 	return nil, fmt.Errorf("neither an ed25519 private key (%q) nor an RSA private key (%q)", err1.Error(), err2.Error())
 }
 
+// ParseIssuerKeys is like ParseIssuerKey, but accepts any number of keys. When
+// given as PEM, the blocks are simply concatenated. When given as filenames,
+// the filenames are separated by commas.
+func ParseIssuerKeys(in string) ([]crypto.PrivateKey, error) {
+	var keys []crypto.PrivateKey
+	if !looksLikePEMRx.MatchString(in) {
+		for _, path := range strings.Split(in, ",") {
+			path = strings.TrimSpace(path)
+			if path == "" {
+				continue
+			}
+			key, err := ParseIssuerKey(path)
+			if err != nil {
+				return nil, fmt.Errorf("in %s: %w", path, err)
+			}
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}
+
+	rest := stripWhitespaceRx.ReplaceAll([]byte(in), nil)
+	for idx := 1; ; idx++ {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		key, err := ParseIssuerKey(string(pem.EncodeToMemory(block)))
+		if err != nil {
+			return nil, fmt.Errorf("in PEM block #%d: %w", idx, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM blocks found")
+	}
+	return keys, nil
+}
+
 // GetDatabaseURLFromEnvironment reads the KEPPEL_DB_* environment variables.
 func GetDatabaseURLFromEnvironment() (dbURL url.URL, dbName string) {
 	dbName = osext.GetenvOrDefault("KEPPEL_DB_NAME", "keppel")
@@ -99,14 +244,35 @@ func GetDatabaseURLFromEnvironment() (dbURL url.URL, dbName string) {
 	})), dbName
 }
 
+// GetReadReplicaURLFromEnvironment reads the KEPPEL_DB_RO_URI environment
+// variable. If it is not set, nil is returned.
+func GetReadReplicaURLFromEnvironment() (*url.URL, error) {
+	uriStr := os.Getenv("KEPPEL_DB_RO_URI")
+	if uriStr == "" {
+		return nil, nil
+	}
+	dbURL, err := url.Parse(uriStr)
+	if err != nil {
+		return nil, fmt.Errorf("malformed KEPPEL_DB_RO_URI: %w", err)
+	}
+	if dbURL.Scheme != "postgres" && dbURL.Scheme != "postgresql" {
+		return nil, fmt.Errorf("malformed KEPPEL_DB_RO_URI: expected scheme \"postgres\", got %q", dbURL.Scheme)
+	}
+	return dbURL, nil
+}
+
 // ParseConfiguration obtains a keppel.Configuration instance from the
 // corresponding environment variables. Aborts on error.
 func ParseConfiguration() Configuration {
 	logg.Debug("parsing configuration...")
 
 	cfg := Configuration{
-		APIPublicHostname:        osext.MustGetenv("KEPPEL_API_PUBLIC_FQDN"),
-		AnycastAPIPublicHostname: os.Getenv("KEPPEL_API_ANYCAST_FQDN"),
+		APIPublicHostname:              osext.MustGetenv("KEPPEL_API_PUBLIC_FQDN"),
+		AnycastAPIPublicHostname:       os.Getenv("KEPPEL_API_ANYCAST_FQDN"),
+		ReadOnlyMode:                   osext.GetenvBool("KEPPEL_READ_ONLY_MODE"),
+		PeersShareStorage:              osext.GetenvBool("KEPPEL_PEERS_SHARE_STORAGE"),
+		NormalizeReferrersFallbackTags: osext.GetenvBool("KEPPEL_NORMALIZE_REFERRERS_FALLBACK_TAGS"),
+		EnableOCIDistributionSpecV11:   osext.GetenvBool("KEPPEL_ENABLE_OCI_DISTRIBUTION_SPEC_V1_1"),
 	}
 
 	parseIssuerKeys := func(prefix string) []crypto.PrivateKey {
@@ -114,15 +280,16 @@ func ParseConfiguration() Configuration {
 		if err != nil {
 			logg.Fatal("failed to read %s_ISSUER_KEY: %s", prefix, err.Error())
 		}
-		prevKeyStr := os.Getenv(prefix + "_PREVIOUS_ISSUER_KEY")
-		if prevKeyStr == "" {
+		// the first key is used for signing, all others are only accepted for validation
+		prevKeysStr := os.Getenv(prefix + "_PREVIOUS_ISSUER_KEY")
+		if prevKeysStr == "" {
 			return []crypto.PrivateKey{key}
 		}
-		prevKey, err := ParseIssuerKey(prevKeyStr)
+		prevKeys, err := ParseIssuerKeys(prevKeysStr)
 		if err != nil {
 			logg.Fatal("failed to read %s_PREVIOUS_ISSUER_KEY: %s", prefix, err.Error())
 		}
-		return []crypto.PrivateKey{key, prevKey}
+		return append([]crypto.PrivateKey{key}, prevKeys...)
 	}
 
 	cfg.JWTIssuerKeys = parseIssuerKeys("KEPPEL")
@@ -130,29 +297,231 @@ func ParseConfiguration() Configuration {
 		cfg.AnycastJWTIssuerKeys = parseIssuerKeys("KEPPEL_ANYCAST")
 	}
 
-	trivyURL := mayGetenvURL("KEPPEL_TRIVY_URL")
-	if trivyURL != nil {
-		additionalPullableRepos := strings.Split(os.Getenv("KEPPEL_TRIVY_ADDITIONAL_PULLABLE_REPOS"), ",")
-		cfg.Trivy = &trivy.Config{
-			AdditionalPullableRepos: additionalPullableRepos,
-			Token:                   osext.MustGetenv("KEPPEL_TRIVY_TOKEN"),
-			URL:                     *trivyURL,
+	additionalPullableRepos := os.Getenv("KEPPEL_SCANNER_ADDITIONAL_PULLABLE_REPOS")
+	if additionalPullableRepos == "" {
+		// fallback to the variable name from before scanner drivers were introduced
+		additionalPullableRepos = os.Getenv("KEPPEL_TRIVY_ADDITIONAL_PULLABLE_REPOS")
+	}
+	if additionalPullableRepos != "" {
+		cfg.ScannerAdditionalPullableRepos = strings.Split(additionalPullableRepos, ",")
+	}
+
+	retentionStr := os.Getenv("KEPPEL_AUDIT_EVENT_RETENTION")
+	if retentionStr != "" {
+		retention, err := time.ParseDuration(retentionStr)
+		if err != nil || retention <= 0 {
+			logg.Fatal("invalid value for KEPPEL_AUDIT_EVENT_RETENTION: %q", retentionStr)
+		}
+		cfg.AuditEventRetention = retention
+	}
+
+	eolReportIntervalStr := os.Getenv("KEPPEL_EOL_REPORT_INTERVAL")
+	if eolReportIntervalStr != "" {
+		interval, err := time.ParseDuration(eolReportIntervalStr)
+		if err != nil || interval <= 0 {
+			logg.Fatal("invalid value for KEPPEL_EOL_REPORT_INTERVAL: %q", eolReportIntervalStr)
+		}
+		cfg.EOLReportInterval = interval
+		maxAgeStr := os.Getenv("KEPPEL_EOL_REPORT_MAX_IMAGE_AGE_DAYS")
+		if maxAgeStr != "" {
+			maxAgeDays, err := strconv.ParseUint(maxAgeStr, 10, 32)
+			if err != nil || maxAgeDays == 0 {
+				logg.Fatal("invalid value for KEPPEL_EOL_REPORT_MAX_IMAGE_AGE_DAYS: %q", maxAgeStr)
+			}
+			cfg.EOLReportMaxImageAge = time.Duration(maxAgeDays) * 24 * time.Hour
+		}
+		cfg.EOLReportWebhookURL = os.Getenv("KEPPEL_EOL_REPORT_WEBHOOK_URL")
+	}
+
+	gracePeriodStr := os.Getenv("KEPPEL_MANAGED_ACCOUNT_DELETION_GRACE_PERIOD")
+	if gracePeriodStr != "" {
+		gracePeriod, err := time.ParseDuration(gracePeriodStr)
+		if err != nil || gracePeriod < 0 {
+			logg.Fatal("invalid value for KEPPEL_MANAGED_ACCOUNT_DELETION_GRACE_PERIOD: %q", gracePeriodStr)
+		}
+		cfg.ManagedAccountDeletionGracePeriod = gracePeriod
+	}
+	cfg.ManagedAccountDeletionWebhookURL = os.Getenv("KEPPEL_MANAGED_ACCOUNT_DELETION_WEBHOOK_URL")
+
+	requiredPlatformsStr := os.Getenv("KEPPEL_REQUIRED_PLATFORMS")
+	if requiredPlatformsStr != "" {
+		requiredPlatforms, err := ParseRequiredPlatforms(requiredPlatformsStr)
+		if err != nil {
+			logg.Fatal("invalid value for KEPPEL_REQUIRED_PLATFORMS: %s", err.Error())
+		}
+		cfg.RequiredPlatforms = requiredPlatforms
+	}
+
+	accountCacheTTLStr := os.Getenv("KEPPEL_ACCOUNT_CACHE_TTL")
+	if accountCacheTTLStr != "" {
+		ttl, err := time.ParseDuration(accountCacheTTLStr)
+		if err != nil || ttl < 0 {
+			logg.Fatal("invalid value for KEPPEL_ACCOUNT_CACHE_TTL: %q", accountCacheTTLStr)
+		}
+		cfg.AccountCacheTTL = ttl
+	}
+
+	cfg.AccountMetricsEnabled = osext.GetenvBool("KEPPEL_ACCOUNT_METRICS_ENABLE")
+	if cfg.AccountMetricsEnabled {
+		maxSeriesStr := osext.GetenvOrDefault("KEPPEL_ACCOUNT_METRICS_MAX_SERIES", "1000")
+		maxSeries, err := strconv.Atoi(maxSeriesStr)
+		if err != nil || maxSeries <= 0 {
+			logg.Fatal("invalid value for KEPPEL_ACCOUNT_METRICS_MAX_SERIES: %q", maxSeriesStr)
+		}
+		cfg.AccountMetricsMaxSeries = maxSeries
+	}
+
+	cfg.ManifestPullCountsEnabled = osext.GetenvBool("KEPPEL_MANIFEST_PULL_COUNTS_ENABLE")
+
+	opsPerSecondStr := os.Getenv("KEPPEL_JANITOR_STORAGE_OPS_PER_SECOND")
+	if opsPerSecondStr != "" {
+		opsPerSecond, err := strconv.ParseFloat(opsPerSecondStr, 64)
+		if err != nil || opsPerSecond < 0 {
+			logg.Fatal("invalid value for KEPPEL_JANITOR_STORAGE_OPS_PER_SECOND: %q", opsPerSecondStr)
+		}
+		cfg.JanitorStorageOpsPerSecond = opsPerSecond
+	}
+
+	errorToleranceStr := os.Getenv("KEPPEL_JANITOR_MANIFEST_SYNC_ERROR_TOLERANCE")
+	if errorToleranceStr != "" {
+		errorTolerance, err := strconv.Atoi(errorToleranceStr)
+		if err != nil || errorTolerance < 0 {
+			logg.Fatal("invalid value for KEPPEL_JANITOR_MANIFEST_SYNC_ERROR_TOLERANCE: %q", errorToleranceStr)
 		}
+		cfg.JanitorManifestSyncErrorTolerance = errorTolerance
 	}
 
+	concurrencyStr := os.Getenv("KEPPEL_JANITOR_CONCURRENCY")
+	if concurrencyStr != "" {
+		concurrency, err := ParseJanitorConcurrency([]byte(concurrencyStr))
+		if err != nil {
+			logg.Fatal("invalid value for KEPPEL_JANITOR_CONCURRENCY: %s", err.Error())
+		}
+		cfg.JanitorConcurrency = concurrency
+	}
+
+	overridesStr := os.Getenv("KEPPEL_AUTH_REALM_OVERRIDES")
+	if overridesStr != "" {
+		overrides, err := ParseAuthRealmOverrides([]byte(overridesStr))
+		if err != nil {
+			logg.Fatal("invalid value for KEPPEL_AUTH_REALM_OVERRIDES: %s", err.Error())
+		}
+		cfg.AuthRealmOverrides = overrides
+	}
+
+	cfg.PeerClientCertHeader = os.Getenv("KEPPEL_PEER_CLIENT_CERT_HEADER")
+
+	globalBlobStoreTenantID := os.Getenv("KEPPEL_GLOBAL_BLOB_STORE_AUTH_TENANT_ID")
+	if globalBlobStoreTenantID != "" {
+		cfg.GlobalBlobStore = &models.ReducedAccount{
+			Name:         GlobalBlobStoreAccountName,
+			AuthTenantID: globalBlobStoreTenantID,
+		}
+	}
+
+	pathPrefix, err := ParseAPIPathPrefix(os.Getenv("KEPPEL_API_PATH_PREFIX"))
+	if err != nil {
+		logg.Fatal("invalid value for KEPPEL_API_PATH_PREFIX: %s", err.Error())
+	}
+	cfg.APIPathPrefix = pathPrefix
+
 	return cfg
 }
 
-func mayGetenvURL(key string) *url.URL {
-	val := os.Getenv(key)
-	if val == "" {
-		return nil
+// ParseAPIPathPrefix parses the contents of the KEPPEL_API_PATH_PREFIX
+// variable. The result has a leading slash and no trailing slash, or is empty
+// if keppel-api is mounted at the domain root.
+func ParseAPIPathPrefix(input string) (string, error) {
+	prefix := "/" + strings.Trim(input, "/")
+	if prefix == "/" {
+		return "", nil
+	}
+	u, err := url.Parse(prefix)
+	if err != nil {
+		return "", err
+	}
+	if u.Path != prefix || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%q is not a valid URL path", input)
+	}
+	return prefix, nil
+}
+
+// ParseRequiredPlatforms parses the contents of the KEPPEL_REQUIRED_PLATFORMS
+// variable, which is a comma-separated list of platforms in the format
+// accepted by models.ParsePlatform().
+func ParseRequiredPlatforms(input string) (models.PlatformFilter, error) {
+	var result models.PlatformFilter
+	for _, field := range strings.Split(input, ",") {
+		platform, err := models.ParsePlatform(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, platform)
+	}
+	return result, nil
+}
+
+// ParseAuthRealmOverrides parses the contents of the
+// KEPPEL_AUTH_REALM_OVERRIDES variable, which is a JSON object mapping account
+// names to AuthRealmOverride objects.
+func ParseAuthRealmOverrides(buf []byte) (map[models.AccountName]AuthRealmOverride, error) {
+	var result map[models.AccountName]AuthRealmOverride
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	for accountName, override := range result {
+		if !models.IsAccountName(string(accountName)) {
+			return nil, fmt.Errorf("%q is not a valid account name", accountName)
+		}
+		if override.Realm == "" {
+			return nil, fmt.Errorf("missing realm for account %q", accountName)
+		}
+		realmURL, err := url.Parse(override.Realm)
+		if err != nil {
+			return nil, fmt.Errorf("malformed realm for account %q: %w", accountName, err)
+		}
+		if realmURL.Scheme != "https" && realmURL.Scheme != "http" {
+			return nil, fmt.Errorf("realm for account %q must be an absolute http(s) URL, but is %q", accountName, override.Realm)
+		}
+		if realmURL.Host == "" || realmURL.User != nil || realmURL.Fragment != "" {
+			return nil, fmt.Errorf("realm for account %q must be an absolute http(s) URL without credentials or fragment, but is %q", accountName, override.Realm)
+		}
+		// both values are rendered into quoted strings in the Www-Authenticate header
+		if strings.ContainsAny(override.Realm, "\"\\") || strings.ContainsAny(override.Service, "\"\\ ") {
+			return nil, fmt.Errorf("realm or service for account %q contains forbidden characters", accountName)
+		}
 	}
-	parsed, err := url.Parse(val)
+	return result, nil
+}
+
+// ConcurrentJanitorJobs lists the janitor jobs that can process multiple tasks
+// in parallel, and thus accept a setting in KEPPEL_JANITOR_CONCURRENCY.
+var ConcurrentJanitorJobs = []string{"manifest_validation", "trivy_security_check"}
+
+// ParseJanitorConcurrency parses the contents of the
+// KEPPEL_JANITOR_CONCURRENCY variable, which is a JSON object mapping janitor
+// job names to the number of goroutines for that job.
+func ParseJanitorConcurrency(buf []byte) (map[string]uint32, error) {
+	var result map[string]uint32
+	err := json.Unmarshal(buf, &result)
 	if err != nil {
-		logg.Fatal("malformed %s: %s", key, err.Error())
+		return nil, err
+	}
+
+	for jobName, numGoroutines := range result {
+		if !slices.Contains(ConcurrentJanitorJobs, jobName) {
+			return nil, fmt.Errorf("job %q does not support concurrency (supported jobs are: %s)",
+				jobName, strings.Join(ConcurrentJanitorJobs, ", "))
+		}
+		if numGoroutines == 0 {
+			return nil, fmt.Errorf("concurrency for job %q must be at least 1, but is %d", jobName, numGoroutines)
+		}
 	}
-	return parsed
+	return result, nil
 }
 
 // GetRedisOptions returns a redis.Options by getting the required parameters
@@ -333,11 +333,482 @@ var sqlMigrations = map[string]string{
 		ALTER TABLE accounts
 			DROP COLUMN in_maintenance;
 	`,
+	"045_add_accounts_require_digest_pulls_repo_rx.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN require_digest_pulls_repo_rx TEXT NOT NULL DEFAULT '';
+	`,
+	"045_add_accounts_require_digest_pulls_repo_rx.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN require_digest_pulls_repo_rx;
+	`,
+	"046_add_accounts_is_proxy_cache.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN is_proxy_cache BOOLEAN NOT NULL DEFAULT FALSE;
+	`,
+	"046_add_accounts_is_proxy_cache.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN is_proxy_cache;
+	`,
+	"047_add_upload_quarantine.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN quarantine_severity_threshold TEXT NOT NULL DEFAULT '';
+		ALTER TABLE manifests
+			ADD COLUMN quarantine_status TEXT NOT NULL DEFAULT '';
+		CREATE TABLE quarantined_tags (
+			repo_id   BIGINT      NOT NULL REFERENCES repos ON DELETE CASCADE,
+			name      TEXT        NOT NULL,
+			digest    TEXT        NOT NULL,
+			pushed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (repo_id, name),
+			FOREIGN KEY (repo_id, digest) REFERENCES manifests ON DELETE CASCADE
+		);
+	`,
+	"047_add_upload_quarantine.down.sql": `
+		DROP TABLE quarantined_tags;
+		ALTER TABLE manifests
+			DROP COLUMN quarantine_status;
+		ALTER TABLE accounts
+			DROP COLUMN quarantine_severity_threshold;
+	`,
+	"048_add_audit_events.up.sql": `
+		CREATE TABLE audit_events (
+			id           BIGSERIAL   NOT NULL PRIMARY KEY,
+			account_name TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			recorded_at  TIMESTAMPTZ NOT NULL,
+			action       TEXT        NOT NULL,
+			cadf_json    TEXT        NOT NULL
+		);
+		CREATE INDEX audit_events_account_name_recorded_at_idx ON audit_events (account_name, recorded_at);
+	`,
+	"048_add_audit_events.down.sql": `
+		DROP TABLE audit_events;
+	`,
+	"049_add_signature_policy.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN require_signature_mode TEXT NOT NULL DEFAULT '',
+			ADD COLUMN signature_public_keys TEXT NOT NULL DEFAULT '';
+		ALTER TABLE manifests
+			ADD COLUMN signature_status TEXT NOT NULL DEFAULT '',
+			ADD COLUMN next_signature_check_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"049_add_signature_policy.down.sql": `
+		ALTER TABLE manifests
+			DROP COLUMN signature_status,
+			DROP COLUMN next_signature_check_at;
+		ALTER TABLE accounts
+			DROP COLUMN require_signature_mode,
+			DROP COLUMN signature_public_keys;
+	`,
+	"050_add_replica_tag_divergences.up.sql": `
+		ALTER TABLE repos
+			ADD COLUMN next_consistency_check_at TIMESTAMPTZ DEFAULT NULL;
+		CREATE TABLE replica_tag_divergences (
+			repo_id        BIGINT      NOT NULL REFERENCES repos ON DELETE CASCADE,
+			tag_name       TEXT        NOT NULL,
+			replica_digest TEXT        NOT NULL,
+			primary_digest TEXT        NOT NULL,
+			detected_at    TIMESTAMPTZ NOT NULL,
+			is_confirmed   BOOLEAN     NOT NULL DEFAULT FALSE,
+			PRIMARY KEY (repo_id, tag_name)
+		);
+	`,
+	"050_add_replica_tag_divergences.down.sql": `
+		DROP TABLE replica_tag_divergences;
+		ALTER TABLE repos
+			DROP COLUMN next_consistency_check_at;
+	`,
+	"051_add_replication_queue.up.sql": `
+		ALTER TABLE peers
+			ADD COLUMN cold_start_replications_per_minute INTEGER NOT NULL DEFAULT 0,
+			ADD COLUMN next_cold_start_replication_at TIMESTAMPTZ DEFAULT NULL;
+		CREATE TABLE replication_queue (
+			repo_id           BIGINT      NOT NULL REFERENCES repos ON DELETE CASCADE,
+			reference         TEXT        NOT NULL,
+			enqueued_at       TIMESTAMPTZ NOT NULL,
+			last_requested_at TIMESTAMPTZ NOT NULL,
+			request_count     BIGINT      NOT NULL DEFAULT 1,
+			PRIMARY KEY (repo_id, reference)
+		);
+	`,
+	"051_add_replication_queue.down.sql": `
+		DROP TABLE replication_queue;
+		ALTER TABLE peers
+			DROP COLUMN cold_start_replications_per_minute,
+			DROP COLUMN next_cold_start_replication_at;
+	`,
+	"052_add_eol_reports.up.sql": `
+		CREATE TABLE eol_reports (
+			id            BIGSERIAL   NOT NULL PRIMARY KEY,
+			generated_at  TIMESTAMPTZ NOT NULL,
+			entry_count   BIGINT      NOT NULL,
+			contents_json TEXT        NOT NULL
+		);
+	`,
+	"052_add_eol_reports.down.sql": `
+		DROP TABLE eol_reports;
+	`,
+	"053_add_security_summary_snapshots.up.sql": `
+		CREATE TABLE security_summary_snapshots (
+			auth_tenant_id TEXT        NOT NULL,
+			taken_at       TIMESTAMPTZ NOT NULL,
+			vuln_status    TEXT        NOT NULL,
+			manifest_count BIGINT      NOT NULL,
+			PRIMARY KEY (auth_tenant_id, taken_at, vuln_status)
+		);
+	`,
+	"053_add_security_summary_snapshots.down.sql": `
+		DROP TABLE security_summary_snapshots;
+	`,
+	"054_add_manifest_variants.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN image_transformations TEXT NOT NULL DEFAULT '';
+		CREATE TABLE manifest_variants (
+			repo_id         BIGINT      NOT NULL,
+			source_digest   TEXT        NOT NULL,
+			transformation  TEXT        NOT NULL,
+			variant_digest  TEXT        DEFAULT NULL,
+			updated_at      TIMESTAMPTZ NOT NULL,
+			error_message   TEXT        NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMPTZ DEFAULT NULL,
+			PRIMARY KEY (repo_id, source_digest, transformation),
+			FOREIGN KEY (repo_id, source_digest) REFERENCES manifests (repo_id, digest) ON DELETE CASCADE,
+			FOREIGN KEY (repo_id, variant_digest) REFERENCES manifests (repo_id, digest) ON DELETE CASCADE
+		);
+	`,
+	"054_add_manifest_variants.down.sql": `
+		DROP TABLE manifest_variants;
+		ALTER TABLE accounts
+			DROP COLUMN image_transformations;
+	`,
+	"055_add_accounts_deletion_scheduled_at.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN deletion_scheduled_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"055_add_accounts_deletion_scheduled_at.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN deletion_scheduled_at;
+	`,
+	"056_add_accounts_is_read_only.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN is_read_only BOOLEAN NOT NULL DEFAULT FALSE;
+	`,
+	"056_add_accounts_is_read_only.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN is_read_only;
+	`,
+	"057_add_gc_runs.up.sql": `
+		CREATE TABLE gc_runs (
+			id                     BIGSERIAL   NOT NULL PRIMARY KEY,
+			account_name           TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			repo_name              TEXT        NOT NULL,
+			started_at             TIMESTAMPTZ NOT NULL,
+			finished_at            TIMESTAMPTZ NOT NULL,
+			policies_evaluated     BIGINT      NOT NULL,
+			manifests_deleted      BIGINT      NOT NULL,
+			bytes_freed            BIGINT      NOT NULL,
+			deleted_manifests_json TEXT        NOT NULL DEFAULT '[]',
+			error_message          TEXT        NOT NULL DEFAULT ''
+		);
+		CREATE INDEX gc_runs_account_name_finished_at_idx ON gc_runs (account_name, finished_at);
+	`,
+	"057_add_gc_runs.down.sql": `
+		DROP TABLE gc_runs;
+	`,
+	"058_add_manifests_artifact_metadata.up.sql": `
+		ALTER TABLE manifests
+			ADD COLUMN artifact_type TEXT NOT NULL DEFAULT '',
+			ADD COLUMN subject_digest TEXT NOT NULL DEFAULT '',
+			ADD COLUMN annotations_json TEXT NOT NULL DEFAULT '';
+		CREATE INDEX manifests_subject_digest_idx ON manifests (subject_digest) WHERE subject_digest != '';
+	`,
+	"058_add_manifests_artifact_metadata.down.sql": `
+		DROP INDEX manifests_subject_digest_idx;
+		ALTER TABLE manifests
+			DROP COLUMN artifact_type,
+			DROP COLUMN subject_digest,
+			DROP COLUMN annotations_json;
+	`,
+	"059_add_accounts_config_sync.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN config_sync_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN config_sync_excluded_fields TEXT NOT NULL DEFAULT '',
+			ADD COLUMN next_config_sync_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"059_add_accounts_config_sync.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN config_sync_enabled,
+			DROP COLUMN config_sync_excluded_fields,
+			DROP COLUMN next_config_sync_at;
+	`,
+	"060_add_manifests_missing_platforms.up.sql": `
+		ALTER TABLE manifests
+			ADD COLUMN missing_platforms TEXT NOT NULL DEFAULT '',
+			ADD COLUMN next_platform_check_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"060_add_manifests_missing_platforms.down.sql": `
+		ALTER TABLE manifests
+			DROP COLUMN missing_platforms,
+			DROP COLUMN next_platform_check_at;
+	`,
+	"061_add_tag_history.up.sql": `
+		CREATE TABLE tag_history (
+			id         BIGSERIAL   NOT NULL PRIMARY KEY,
+			repo_id    BIGINT      NOT NULL REFERENCES repos ON DELETE CASCADE,
+			tag_name   TEXT        NOT NULL,
+			old_digest TEXT        NOT NULL,
+			new_digest TEXT        NOT NULL,
+			moved_at   TIMESTAMPTZ NOT NULL,
+			user_name  TEXT        NOT NULL DEFAULT ''
+		);
+		CREATE INDEX tag_history_repo_id_tag_name_idx ON tag_history (repo_id, tag_name);
+	`,
+	"061_add_tag_history.down.sql": `
+		DROP TABLE tag_history;
+	`,
+	"062_add_repos_pull_count.up.sql": `
+		ALTER TABLE repos ADD COLUMN pull_count BIGINT NOT NULL DEFAULT 0;
+	`,
+	"062_add_repos_pull_count.down.sql": `
+		ALTER TABLE repos DROP COLUMN pull_count;
+	`,
+	"063_add_accounts_external_peer_tls_and_proxy.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN external_peer_ca_bundle TEXT NOT NULL DEFAULT '',
+			ADD COLUMN external_peer_proxy_url TEXT NOT NULL DEFAULT '';
+	`,
+	"063_add_accounts_external_peer_tls_and_proxy.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN external_peer_ca_bundle,
+			DROP COLUMN external_peer_proxy_url;
+	`,
+	"064_add_accounts_allowed_media_types.up.sql": `
+		ALTER TABLE accounts ADD COLUMN allowed_media_types TEXT NOT NULL DEFAULT '';
+	`,
+	"064_add_accounts_allowed_media_types.down.sql": `
+		ALTER TABLE accounts DROP COLUMN allowed_media_types;
+	`,
+	"065_add_robot_tokens.up.sql": `
+		CREATE TABLE robot_tokens (
+			id           BIGSERIAL   NOT NULL PRIMARY KEY,
+			account_name TEXT        NOT NULL REFERENCES accounts ON DELETE CASCADE,
+			repo_name    TEXT        NOT NULL,
+			secret_hash  TEXT        NOT NULL,
+			description  TEXT        NOT NULL DEFAULT '',
+			created_by   TEXT        NOT NULL DEFAULT '',
+			created_at   TIMESTAMPTZ NOT NULL,
+			expires_at   TIMESTAMPTZ NOT NULL,
+			revoked_at   TIMESTAMPTZ DEFAULT NULL
+		);
+		CREATE INDEX robot_tokens_account_name_idx ON robot_tokens (account_name);
+	`,
+	"065_add_robot_tokens.down.sql": `
+		DROP TABLE robot_tokens;
+	`,
+	"066_add_accounts_reject_empty_config_artifacts.up.sql": `
+		ALTER TABLE accounts ADD COLUMN reject_empty_config_artifacts BOOLEAN NOT NULL DEFAULT FALSE;
+	`,
+	"066_add_accounts_reject_empty_config_artifacts.down.sql": `
+		ALTER TABLE accounts DROP COLUMN reject_empty_config_artifacts;
+	`,
+	"067_add_orphan_retention.up.sql": `
+		ALTER TABLE accounts ADD COLUMN retain_orphans_for_secs BIGINT NOT NULL DEFAULT 0;
+		ALTER TABLE manifests ADD COLUMN orphaned_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"067_add_orphan_retention.down.sql": `
+		ALTER TABLE accounts DROP COLUMN retain_orphans_for_secs;
+		ALTER TABLE manifests DROP COLUMN orphaned_at;
+	`,
+	"068_add_blob_prefetch.up.sql": `
+		ALTER TABLE accounts ADD COLUMN prefetch_blobs BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE blobs ADD COLUMN next_prefetch_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"068_add_blob_prefetch.down.sql": `
+		ALTER TABLE accounts DROP COLUMN prefetch_blobs;
+		ALTER TABLE blobs DROP COLUMN next_prefetch_at;
+	`,
+	"069_add_attestation_verification.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN required_attestation_types TEXT NOT NULL DEFAULT '',
+			ADD COLUMN attestation_public_keys TEXT NOT NULL DEFAULT '';
+		ALTER TABLE manifests
+			ADD COLUMN next_attestation_check_at TIMESTAMPTZ DEFAULT NULL;
+		CREATE TABLE attestation_results (
+			repo_id            BIGINT      NOT NULL,
+			digest             TEXT        NOT NULL,
+			predicate_type     TEXT        NOT NULL,
+			status             TEXT        NOT NULL,
+			attestation_digest TEXT        NOT NULL DEFAULT '',
+			message            TEXT        NOT NULL DEFAULT '',
+			checked_at         TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (repo_id, digest, predicate_type),
+			FOREIGN KEY (repo_id, digest) REFERENCES manifests ON DELETE CASCADE
+		);
+	`,
+	"069_add_attestation_verification.down.sql": `
+		DROP TABLE attestation_results;
+		ALTER TABLE manifests
+			DROP COLUMN next_attestation_check_at;
+		ALTER TABLE accounts
+			DROP COLUMN required_attestation_types,
+			DROP COLUMN attestation_public_keys;
+	`,
+	"070_add_vanity_domains.up.sql": `
+		CREATE TABLE vanity_domains (
+			hostname     TEXT NOT NULL PRIMARY KEY,
+			account_name TEXT NOT NULL REFERENCES accounts ON DELETE CASCADE
+		);
+		CREATE INDEX vanity_domains_account_name_idx ON vanity_domains (account_name);
+	`,
+	"070_add_vanity_domains.down.sql": `
+		DROP TABLE vanity_domains;
+	`,
+	"071_add_janitor_jobs.up.sql": `
+		CREATE TABLE janitor_jobs (
+			name               TEXT        NOT NULL PRIMARY KEY,
+			last_run_at        TIMESTAMPTZ DEFAULT NULL,
+			last_error         TEXT        NOT NULL DEFAULT '',
+			backlog            BIGINT      NOT NULL DEFAULT 0,
+			backlog_checked_at TIMESTAMPTZ DEFAULT NULL
+		);
+	`,
+	"071_add_janitor_jobs.down.sql": `
+		DROP TABLE janitor_jobs;
+	`,
+	"072_add_egress_counters.up.sql": `
+		CREATE TABLE egress_counters (
+			auth_tenant_id TEXT   NOT NULL PRIMARY KEY,
+			bytes          BIGINT NOT NULL DEFAULT 0
+		);
+	`,
+	"072_add_egress_counters.down.sql": `
+		DROP TABLE egress_counters;
+	`,
+	"073_add_accounts_external_peer_password_ref.up.sql": `
+		ALTER TABLE accounts ADD COLUMN external_peer_password_ref TEXT NOT NULL DEFAULT '';
+	`,
+	"073_add_accounts_external_peer_password_ref.down.sql": `
+		ALTER TABLE accounts DROP COLUMN external_peer_password_ref;
+	`,
+	"074_add_peers_client_certs.up.sql": `
+		ALTER TABLE peers
+			ADD COLUMN client_cert_path TEXT NOT NULL DEFAULT '',
+			ADD COLUMN client_key_path TEXT NOT NULL DEFAULT '',
+			ADD COLUMN client_ca_path TEXT NOT NULL DEFAULT '';
+	`,
+	"074_add_peers_client_certs.down.sql": `
+		ALTER TABLE peers
+			DROP COLUMN client_cert_path,
+			DROP COLUMN client_key_path,
+			DROP COLUMN client_ca_path;
+	`,
+	"075_add_blocked_digests.up.sql": `
+		CREATE TABLE blocked_digests (
+			id           BIGSERIAL   NOT NULL PRIMARY KEY,
+			account_name TEXT        DEFAULT NULL REFERENCES accounts ON DELETE CASCADE,
+			digest       TEXT        NOT NULL,
+			reason       TEXT        NOT NULL,
+			created_by   TEXT        NOT NULL DEFAULT '',
+			created_at   TIMESTAMPTZ NOT NULL
+		);
+		CREATE UNIQUE INDEX blocked_digests_account_name_digest_idx ON blocked_digests (COALESCE(account_name, ''), digest);
+		CREATE INDEX blocked_digests_digest_idx ON blocked_digests (digest);
+	`,
+	"075_add_blocked_digests.down.sql": `
+		DROP TABLE blocked_digests;
+	`,
+	"076_add_account_egress_counters.up.sql": `
+		CREATE TABLE account_egress_counters (
+			account_name TEXT   NOT NULL PRIMARY KEY REFERENCES accounts ON DELETE CASCADE,
+			bytes        BIGINT NOT NULL DEFAULT 0
+		);
+	`,
+	"076_add_account_egress_counters.down.sql": `
+		DROP TABLE account_egress_counters;
+	`,
+	"077_add_accounts_failover_upstream.up.sql": `
+		ALTER TABLE accounts
+			ADD COLUMN failover_upstream TEXT NOT NULL DEFAULT '',
+			ADD COLUMN failover_after_secs BIGINT NOT NULL DEFAULT 0,
+			ADD COLUMN upstream_unreachable_since TIMESTAMPTZ DEFAULT NULL,
+			ADD COLUMN is_upstream_failover_active BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN next_upstream_check_at TIMESTAMPTZ DEFAULT NULL;
+	`,
+	"077_add_accounts_failover_upstream.down.sql": `
+		ALTER TABLE accounts
+			DROP COLUMN failover_upstream,
+			DROP COLUMN failover_after_secs,
+			DROP COLUMN upstream_unreachable_since,
+			DROP COLUMN is_upstream_failover_active,
+			DROP COLUMN next_upstream_check_at;
+	`,
+	"078_add_repos_metadata.up.sql": `
+		ALTER TABLE repos
+			ADD COLUMN description TEXT NOT NULL DEFAULT '',
+			ADD COLUMN source_url TEXT NOT NULL DEFAULT '',
+			ADD COLUMN owner_team TEXT NOT NULL DEFAULT '';
+	`,
+	"078_add_repos_metadata.down.sql": `
+		ALTER TABLE repos
+			DROP COLUMN description,
+			DROP COLUMN source_url,
+			DROP COLUMN owner_team;
+	`,
+	"079_add_sweep_cursors.up.sql": `
+		ALTER TABLE accounts ADD COLUMN blob_sweep_cursor BIGINT NOT NULL DEFAULT 0;
+		ALTER TABLE repos ADD COLUMN blob_mount_sweep_cursor BIGINT NOT NULL DEFAULT 0;
+	`,
+	"079_add_sweep_cursors.down.sql": `
+		ALTER TABLE accounts DROP COLUMN blob_sweep_cursor;
+		ALTER TABLE repos DROP COLUMN blob_mount_sweep_cursor;
+	`,
+	"080_add_global_blobs.up.sql": `
+		CREATE TABLE global_blobs (
+			digest     TEXT   NOT NULL PRIMARY KEY,
+			storage_id TEXT   NOT NULL,
+			size_bytes BIGINT NOT NULL,
+			ref_count  BIGINT NOT NULL
+		);
+		ALTER TABLE blobs ADD COLUMN is_deduplicated BOOLEAN NOT NULL DEFAULT FALSE;
+		CREATE INDEX ON blobs (id) WHERE storage_id != '' AND NOT is_deduplicated;
+	`,
+	"080_add_global_blobs.down.sql": `
+		ALTER TABLE blobs DROP COLUMN is_deduplicated;
+		DROP TABLE global_blobs;
+	`,
+	"081_add_manifest_pull_counts.up.sql": `
+		CREATE TABLE manifest_pull_counts (
+			repo_id BIGINT      NOT NULL,
+			digest  TEXT        NOT NULL,
+			day     TIMESTAMPTZ NOT NULL,
+			count   BIGINT      NOT NULL DEFAULT 0,
+			PRIMARY KEY (repo_id, digest, day),
+			FOREIGN KEY (repo_id, digest) REFERENCES manifests ON DELETE CASCADE
+		);
+		CREATE INDEX ON manifest_pull_counts (day);
+	`,
+	"081_add_manifest_pull_counts.down.sql": `
+		DROP TABLE manifest_pull_counts;
+	`,
+	"082_add_blobs_is_global.up.sql": `
+		ALTER TABLE blobs RENAME COLUMN is_deduplicated TO is_global;
+		CREATE TABLE unknown_global_blobs (
+			storage_id        TEXT        NOT NULL PRIMARY KEY,
+			can_be_deleted_at TIMESTAMPTZ NOT NULL
+		);
+	`,
+	"082_add_blobs_is_global.down.sql": `
+		DROP TABLE unknown_global_blobs;
+		ALTER TABLE blobs RENAME COLUMN is_global TO is_deduplicated;
+	`,
 }
 
 // DB adds convenience functions on top of gorp.DbMap.
 type DB struct {
 	gorp.DbMap
+	// optional, see AttachReadReplica()
+	replica *readReplica
+	// optional, see EnableAccountCache()
+	accountCache *accountCache
 }
 
 // SelectBool is analogous to the other SelectFoo() functions from gorp.DbMap
@@ -352,6 +823,8 @@ func (db *DB) SelectBool(query string, args ...any) (bool, error) {
 func DBConfiguration() easypg.Configuration {
 	return easypg.Configuration{
 		Migrations: sqlMigrations,
+		// if tracing is enabled, use the Postgres driver with tracing instrumentation
+		OverrideDriverName: tracedPostgresDriverName,
 	}
 }
 
@@ -361,19 +834,38 @@ func InitORM(dbConn *sql.DB) *DB {
 	dbConn.SetMaxOpenConns(16)
 
 	result := &DB{DbMap: gorp.DbMap{Db: dbConn, Dialect: gorp.PostgresDialect{}}}
-	result.DbMap.AddTableWithName(models.Account{}, "accounts").SetKeys(false, "name")
-	result.DbMap.AddTableWithName(models.Blob{}, "blobs").SetKeys(true, "id")
-	result.DbMap.AddTableWithName(models.Upload{}, "uploads").SetKeys(false, "repo_id", "uuid")
-	result.DbMap.AddTableWithName(models.Repository{}, "repos").SetKeys(true, "id")
-	result.DbMap.AddTableWithName(models.Manifest{}, "manifests").SetKeys(false, "repo_id", "digest")
-	result.DbMap.AddTableWithName(models.Tag{}, "tags").SetKeys(false, "repo_id", "name")
-	result.DbMap.AddTableWithName(models.ManifestContent{}, "manifest_contents").SetKeys(false, "repo_id", "digest")
-	result.DbMap.AddTableWithName(models.Quotas{}, "quotas").SetKeys(false, "auth_tenant_id")
-	result.DbMap.AddTableWithName(models.Peer{}, "peers").SetKeys(false, "hostname")
-	result.DbMap.AddTableWithName(models.PendingBlob{}, "pending_blobs").SetKeys(false, "account_name", "digest")
-	result.DbMap.AddTableWithName(models.UnknownBlob{}, "unknown_blobs").SetKeys(false, "account_name", "storage_id")
-	result.DbMap.AddTableWithName(models.UnknownManifest{}, "unknown_manifests").SetKeys(false, "account_name", "repo_name", "digest")
-	result.DbMap.AddTableWithName(models.TrivySecurityInfo{}, "trivy_security_info").SetKeys(false, "repo_id", "digest")
-
+	addTableMappings(&result.DbMap)
 	return result
 }
+
+func addTableMappings(dbMap *gorp.DbMap) {
+	dbMap.AddTableWithName(models.Account{}, "accounts").SetKeys(false, "name")
+	dbMap.AddTableWithName(models.Blob{}, "blobs").SetKeys(true, "id")
+	dbMap.AddTableWithName(models.GlobalBlob{}, "global_blobs").SetKeys(false, "digest")
+	dbMap.AddTableWithName(models.Upload{}, "uploads").SetKeys(false, "repo_id", "uuid")
+	dbMap.AddTableWithName(models.Repository{}, "repos").SetKeys(true, "id")
+	dbMap.AddTableWithName(models.Manifest{}, "manifests").SetKeys(false, "repo_id", "digest")
+	dbMap.AddTableWithName(models.Tag{}, "tags").SetKeys(false, "repo_id", "name")
+	dbMap.AddTableWithName(models.TagHistoryEntry{}, "tag_history").SetKeys(true, "id")
+	dbMap.AddTableWithName(models.QuarantinedTag{}, "quarantined_tags").SetKeys(false, "repo_id", "name")
+	dbMap.AddTableWithName(models.ManifestContent{}, "manifest_contents").SetKeys(false, "repo_id", "digest")
+	dbMap.AddTableWithName(models.Quotas{}, "quotas").SetKeys(false, "auth_tenant_id")
+	dbMap.AddTableWithName(models.Peer{}, "peers").SetKeys(false, "hostname")
+	dbMap.AddTableWithName(models.PendingBlob{}, "pending_blobs").SetKeys(false, "account_name", "digest")
+	dbMap.AddTableWithName(models.UnknownBlob{}, "unknown_blobs").SetKeys(false, "account_name", "storage_id")
+	dbMap.AddTableWithName(models.UnknownGlobalBlob{}, "unknown_global_blobs").SetKeys(false, "storage_id")
+	dbMap.AddTableWithName(models.UnknownManifest{}, "unknown_manifests").SetKeys(false, "account_name", "repo_name", "digest")
+	dbMap.AddTableWithName(models.TrivySecurityInfo{}, "trivy_security_info").SetKeys(false, "repo_id", "digest")
+	dbMap.AddTableWithName(models.AuditEvent{}, "audit_events").SetKeys(true, "id")
+	dbMap.AddTableWithName(models.ReplicaTagDivergence{}, "replica_tag_divergences").SetKeys(false, "repo_id", "tag_name")
+	dbMap.AddTableWithName(models.ReplicationQueueEntry{}, "replication_queue").SetKeys(false, "repo_id", "reference")
+	dbMap.AddTableWithName(models.EOLReport{}, "eol_reports").SetKeys(true, "id")
+	dbMap.AddTableWithName(models.ManifestVariant{}, "manifest_variants").SetKeys(false, "repo_id", "source_digest", "transformation")
+	dbMap.AddTableWithName(models.SecuritySummarySnapshot{}, "security_summary_snapshots").SetKeys(false, "auth_tenant_id", "taken_at", "vuln_status")
+	dbMap.AddTableWithName(models.GCRun{}, "gc_runs").SetKeys(true, "id")
+	dbMap.AddTableWithName(models.RobotToken{}, "robot_tokens").SetKeys(true, "id")
+	dbMap.AddTableWithName(models.AttestationResult{}, "attestation_results").SetKeys(false, "repo_id", "digest", "predicate_type")
+	dbMap.AddTableWithName(models.VanityDomain{}, "vanity_domains").SetKeys(false, "hostname")
+	dbMap.AddTableWithName(models.JanitorJob{}, "janitor_jobs").SetKeys(false, "name")
+	dbMap.AddTableWithName(models.BlockedDigest{}, "blocked_digests").SetKeys(true, "id")
+}
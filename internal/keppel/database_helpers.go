@@ -41,23 +41,38 @@ func FindAccount(db gorp.SqlExecutor, name models.AccountName) (*models.Account,
 	return &account, err
 }
 
+const reducedAccountColumns = `
+	auth_tenant_id, upstream_peer_hostname,
+	external_peer_url, external_peer_username, external_peer_password, external_peer_password_ref,
+	external_peer_ca_bundle, external_peer_proxy_url,
+	is_proxy_cache, platform_filter, retain_orphans_for_secs, prefetch_blobs, failover_upstream, is_upstream_failover_active, required_labels, allowed_media_types, reject_empty_config_artifacts, is_deleting, is_read_only,
+	require_digest_pulls_repo_rx, quarantine_severity_threshold, require_signature_mode,
+	image_transformations, required_attestation_types
+`
+
 var reducedAccountGetByNameQuery = sqlext.SimplifyWhitespace(`
-	SELECT auth_tenant_id, upstream_peer_hostname,
-	       external_peer_url, external_peer_username, external_peer_password,
-	       platform_filter, required_labels, is_deleting
+	SELECT ` + reducedAccountColumns + `
 	  FROM accounts
 	 WHERE name = $1
 `)
 
+// Returns scan targets matching the order of `reducedAccountColumns`.
+func reducedAccountScanTargets(a *models.ReducedAccount) []any {
+	return []any{
+		&a.AuthTenantID, &a.UpstreamPeerHostName,
+		&a.ExternalPeerURL, &a.ExternalPeerUserName, &a.ExternalPeerPassword, &a.ExternalPeerPasswordRef,
+		&a.ExternalPeerCABundle, &a.ExternalPeerProxyURL,
+		&a.IsProxyCache, &a.PlatformFilter, &a.RetainOrphansForSecs, &a.PrefetchBlobs, &a.FailoverUpstream, &a.IsUpstreamFailoverActive, &a.RequiredLabels, &a.AllowedMediaTypes, &a.RejectEmptyConfigArtifacts, &a.IsDeleting, &a.IsReadOnly,
+		&a.RequireDigestPullsRepoRx, &a.QuarantineSeverityThreshold, &a.RequireSignatureMode,
+		&a.ImageTransformations, &a.RequiredAttestationTypes,
+	}
+}
+
 // FindReducedAccount is like FindAccount, but it returns a ReducedAccount instead.
 // This can be significantly faster than FindAccount if only the most common stuff is needed.
 func FindReducedAccount(db gorp.SqlExecutor, name models.AccountName) (*models.ReducedAccount, error) {
 	a := models.ReducedAccount{Name: name}
-	err := db.QueryRow(reducedAccountGetByNameQuery, name).Scan(
-		&a.AuthTenantID, &a.UpstreamPeerHostName,
-		&a.ExternalPeerURL, &a.ExternalPeerUserName, &a.ExternalPeerPassword,
-		&a.PlatformFilter, &a.RequiredLabels, &a.IsDeleting,
-	)
+	err := db.QueryRow(reducedAccountGetByNameQuery, name).Scan(reducedAccountScanTargets(&a)...)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
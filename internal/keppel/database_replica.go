@@ -0,0 +1,100 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/sapcc/go-bits/logg"
+)
+
+// How often the health of the read replica is checked, and how long each check may take.
+const (
+	readReplicaCheckInterval = 10 * time.Second
+	readReplicaCheckTimeout  = 2 * time.Second
+)
+
+type readReplica struct {
+	dbMap   gorp.DbMap
+	healthy atomic.Bool
+}
+
+// AttachReadReplica configures a connection to a read-only replica of the
+// primary database. Afterwards, ReadReplica() will direct queries to it for
+// as long as it is reachable. Reachability is checked periodically in a
+// background goroutine that runs until `ctx` expires.
+//
+// Since no migrations are run on the replica, this must only be called after
+// the primary has been fully migrated.
+func (db *DB) AttachReadReplica(ctx context.Context, dbConn *sql.DB) {
+	// ensure that this process does not starve other Keppel processes for DB connections
+	dbConn.SetMaxOpenConns(16)
+
+	r := &readReplica{dbMap: gorp.DbMap{Db: dbConn, Dialect: gorp.PostgresDialect{}}}
+	addTableMappings(&r.dbMap)
+	r.healthy.Store(true) // so that the initial check only logs on failure
+	r.checkHealth(ctx)
+	db.replica = r
+
+	go func() {
+		ticker := time.NewTicker(readReplicaCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.checkHealth(ctx)
+			}
+		}
+	}()
+}
+
+func (r *readReplica) checkHealth(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, readReplicaCheckTimeout)
+	defer cancel()
+	err := r.dbMap.Db.PingContext(ctx)
+
+	wasHealthy := r.healthy.Swap(err == nil)
+	switch {
+	case err != nil && wasHealthy:
+		logg.Error("read replica is unreachable, falling back to primary database: %s", err.Error())
+	case err == nil && !wasHealthy:
+		logg.Info("read replica is reachable, will use it for read-only queries")
+	}
+}
+
+// ReadReplica returns an executor for read-only queries that can tolerate
+// being slightly behind the primary because of replication lag. If no read
+// replica is configured, or if it is currently unreachable, the primary is
+// returned instead.
+//
+// Callers that would act on a negative result (e.g. by replicating a
+// manifest that appears to not exist) should double-check against the primary.
+func (db *DB) ReadReplica() gorp.SqlExecutor {
+	if db.replica != nil && db.replica.healthy.Load() {
+		return &db.replica.dbMap
+	}
+	return db
+}
@@ -0,0 +1,82 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/go-gorp/gorp/v3"
+)
+
+func TestReadReplicaFallback(t *testing.T) {
+	primaryConn, err := sql.Open("postgres", "postgres://localhost:1/primary?sslmode=disable")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	db := InitORM(primaryConn)
+
+	// without a replica, everything goes to the primary
+	if db.ReadReplica() != gorp.SqlExecutor(db) {
+		t.Error("expected ReadReplica() to return the primary when no replica is configured")
+	}
+
+	// an unreachable replica is not used
+	replicaConn, err := sql.Open("postgres", "postgres://localhost:1/replica?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.AttachReadReplica(ctx, replicaConn)
+	if db.ReadReplica() != gorp.SqlExecutor(db) {
+		t.Error("expected ReadReplica() to return the primary when the replica is unreachable")
+	}
+
+	// once the replica is known to be healthy, it is used
+	db.replica.healthy.Store(true)
+	if db.ReadReplica() != gorp.SqlExecutor(&db.replica.dbMap) {
+		t.Error("expected ReadReplica() to return the replica when it is reachable")
+	}
+}
+
+func TestGetReadReplicaURLFromEnvironment(t *testing.T) {
+	t.Setenv("KEPPEL_DB_RO_URI", "")
+	dbURL, err := GetReadReplicaURLFromEnvironment()
+	if err != nil || dbURL != nil {
+		t.Errorf("expected (nil, nil) for empty KEPPEL_DB_RO_URI, got (%v, %v)", dbURL, err)
+	}
+
+	t.Setenv("KEPPEL_DB_RO_URI", "postgres://keppel@replica.example.org:5432/keppel")
+	dbURL, err = GetReadReplicaURLFromEnvironment()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if dbURL.Host != "replica.example.org:5432" {
+		t.Errorf("expected host %q, got %q", "replica.example.org:5432", dbURL.Host)
+	}
+
+	t.Setenv("KEPPEL_DB_RO_URI", "mysql://replica.example.org/keppel")
+	_, err = GetReadReplicaURLFromEnvironment()
+	if err == nil {
+		t.Error("expected error for non-postgres KEPPEL_DB_RO_URI")
+	}
+}
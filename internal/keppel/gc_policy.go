@@ -27,6 +27,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/opencontainers/go-digest"
 	"github.com/sapcc/go-bits/regexpext"
 
 	"github.com/sapcc/keppel/internal/models"
@@ -35,13 +36,16 @@ import (
 // GCPolicy is a policy enabling optional garbage collection runs in an account.
 // It is stored in serialized form in the GCPoliciesJSON field of type Account.
 type GCPolicy struct {
-	RepositoryRx         regexpext.BoundedRegexp `json:"match_repository"`
-	NegativeRepositoryRx regexpext.BoundedRegexp `json:"except_repository,omitempty"`
-	TagRx                regexpext.BoundedRegexp `json:"match_tag,omitempty"`
-	NegativeTagRx        regexpext.BoundedRegexp `json:"except_tag,omitempty"`
-	OnlyUntagged         bool                    `json:"only_untagged,omitempty"`
-	TimeConstraint       *GCTimeConstraint       `json:"time_constraint,omitempty"`
-	Action               string                  `json:"action"`
+	RepositoryRx          regexpext.BoundedRegexp `json:"match_repository"`
+	NegativeRepositoryRx  regexpext.BoundedRegexp `json:"except_repository,omitempty"`
+	TagRx                 regexpext.BoundedRegexp `json:"match_tag,omitempty"`
+	NegativeTagRx         regexpext.BoundedRegexp `json:"except_tag,omitempty"`
+	OnlyUntagged          bool                    `json:"only_untagged,omitempty"`
+	ArtifactTypeRx        regexpext.BoundedRegexp `json:"match_artifact_type,omitempty"`
+	OnlyOrphanedReferrers bool                    `json:"only_orphaned_referrers,omitempty"`
+	TimeConstraint        *GCTimeConstraint       `json:"time_constraint,omitempty"`
+	PullCountConstraint   *GCPullCountConstraint  `json:"pull_count_constraint,omitempty"`
+	Action                string                  `json:"action"`
 }
 
 // GCTimeConstraint appears in type GCPolicy.
@@ -53,6 +57,23 @@ type GCTimeConstraint struct {
 	MaxAge      Duration `json:"newer_than,omitempty"`
 }
 
+// GCPullCountConstraint appears in type GCPolicy.
+type GCPullCountConstraint struct {
+	FewerThan uint64   `json:"fewer_than"`
+	Within    Duration `json:"within"`
+}
+
+// ManifestPullCountRetention is how long pull counts are kept in the
+// `manifest_pull_counts` table. GCPullCountConstraint.Within may not exceed
+// this.
+const ManifestPullCountRetention = 90 * 24 * time.Hour
+
+// PullCountDay returns the day (as stored in `manifest_pull_counts.day`) into
+// which a pull at the given time is counted.
+func PullCountDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
 // MatchesRepository evaluates the repository regexes in this policy.
 func (g GCPolicy) MatchesRepository(repoName string) bool {
 	//NOTE: NegativeRepositoryRx takes precedence and is thus evaluated first.
@@ -90,6 +111,27 @@ func (g GCPolicy) MatchesTags(tagNames []string) bool {
 	return g.TagRx == ""
 }
 
+// MatchesArtifactType evaluates the artifact type regex in this policy for
+// the given manifest. Manifests that do not declare an artifact type (e.g.
+// regular images) never match a policy that has this regex.
+func (g GCPolicy) MatchesArtifactType(manifest models.Manifest) bool {
+	if g.ArtifactTypeRx == "" {
+		return true
+	}
+	return manifest.ArtifactType != "" && g.ArtifactTypeRx.MatchString(manifest.ArtifactType)
+}
+
+// MatchesSubject evaluates the subject constraint in this policy for the given
+// manifest. The second argument must report whether the manifest with the
+// given digest exists in the same repo (and has not been deleted by an earlier
+// policy in the same GC run).
+func (g GCPolicy) MatchesSubject(manifest models.Manifest, manifestExists func(digest.Digest) bool) bool {
+	if !g.OnlyOrphanedReferrers {
+		return true
+	}
+	return manifest.SubjectDigest != "" && !manifestExists(digest.Digest(manifest.SubjectDigest))
+}
+
 // MatchesTimeConstraint evaluates the time constraint in this policy for the
 // given manifest. A full list of all manifests in this repo must be supplied in
 // order to evaluate "newest" and "oldest" time constraints. The final argument
@@ -167,6 +209,15 @@ func (g GCPolicy) MatchesTimeConstraint(manifest models.Manifest, allManifestsIn
 	return false
 }
 
+// MatchesPullCountConstraint evaluates the pull count constraint in this
+// policy for the given number of pulls within the constraint's time window.
+func (g GCPolicy) MatchesPullCountConstraint(pullCount uint64) bool {
+	if g.PullCountConstraint == nil {
+		return true
+	}
+	return pullCount < g.PullCountConstraint.FewerThan
+}
+
 // Validate returns an error if this policy is invalid.
 func (g GCPolicy) Validate() error {
 	if g.RepositoryRx == "" {
@@ -219,6 +270,19 @@ func (g GCPolicy) Validate() error {
 		}
 	}
 
+	if g.PullCountConstraint != nil {
+		pc := *g.PullCountConstraint
+		if pc.FewerThan == 0 {
+			return errors.New(`GC policy pull count constraint must have a positive "fewer_than" attribute`)
+		}
+		if pc.Within <= 0 {
+			return errors.New(`GC policy pull count constraint must have a positive "within" attribute`)
+		}
+		if time.Duration(pc.Within) > ManifestPullCountRetention {
+			return fmt.Errorf(`GC policy pull count constraint cannot have a "within" attribute longer than %d days`, ManifestPullCountRetention/(24*time.Hour))
+		}
+	}
+
 	switch g.Action {
 	case "delete", "protect":
 		// valid
@@ -249,9 +313,16 @@ type GCStatus struct {
 	// True if the manifest was uploaded less than 10 minutes ago and is therefore
 	// protected from GC.
 	ProtectedByRecentUpload bool `json:"protected_by_recent_upload,omitempty"`
+	// True if the manifest is held in quarantine until its initial vulnerability
+	// scan completes, and is therefore protected from GC.
+	ProtectedByQuarantine bool `json:"protected_by_quarantine,omitempty"`
 	// If a parent manifest references this manifest and thus protects it from GC,
 	// contains the parent manifest's digest.
 	ProtectedByParentManifest string `json:"protected_by_parent,omitempty"`
+	// If this manifest is a variant generated from another manifest (e.g. a
+	// squashed image), contains the source manifest's digest. The variant is
+	// deleted together with its source.
+	ProtectedAsVariantOf string `json:"protected_as_variant_of,omitempty"`
 	// If a policy with action "protect" applies to this image, contains the
 	// definition of the policy.
 	ProtectedByPolicy *GCPolicy `json:"protected_by_policy,omitempty"`
@@ -262,5 +333,15 @@ type GCStatus struct {
 
 // IsProtected returns whether any of the ProtectedBy... fields is filled.
 func (s GCStatus) IsProtected() bool {
-	return s.ProtectedByRecentUpload || s.ProtectedByParentManifest != "" || s.ProtectedByPolicy != nil
+	return s.ProtectedByRecentUpload || s.ProtectedByQuarantine || s.ProtectedByParentManifest != "" || s.ProtectedAsVariantOf != "" || s.ProtectedByPolicy != nil
+}
+
+// GCDeletedManifest describes a manifest that was deleted by a GC policy. It
+// is stored in serialized form in the DeletedManifestsJSON field of type
+// models.GCRun.
+type GCDeletedManifest struct {
+	Digest    digest.Digest `json:"digest"`
+	TagNames  []string      `json:"tags,omitempty"`
+	SizeBytes uint64        `json:"size_bytes"`
+	Policy    GCPolicy      `json:"policy"`
 }
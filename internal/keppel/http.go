@@ -20,19 +20,31 @@
 package keppel
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/sapcc/go-api-declarations/bininfo"
 	"github.com/sapcc/go-bits/httpext"
 	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/go-bits/osext"
+
+	"github.com/sapcc/keppel/internal/models"
 )
 
-var wrap *httpext.WrappedTransport
+var (
+	wrap *httpext.WrappedTransport
+	// the original http.DefaultTransport, before it gets wrapped by SetupHTTPClient()
+	baseTransport, _ = http.DefaultTransport.(*http.Transport)
+)
 
 func SetupHTTPClient() {
 	wrap = httpext.WrapTransport(&http.DefaultTransport)
 	wrap.SetInsecureSkipVerify(osext.GetenvBool("KEPPEL_INSECURE")) // for debugging with mitmproxy etc. (DO NOT SET IN PRODUCTION)
+	wrap.Attach(wrapTransportForTracing)
 	wrap.SetOverrideUserAgent(bininfo.Component(), bininfo.VersionOr("rolling"))
 }
 
@@ -41,3 +53,90 @@ func SetTaskName(taskName string) {
 	wrap.SetOverrideUserAgent(bininfo.Component(), bininfo.VersionOr("rolling"))
 	logg.Info("starting %s %s", bininfo.Component(), bininfo.VersionOr("rolling"))
 }
+
+// NewHTTPClientForExternalPeer returns an HTTP client that applies the CA
+// bundle and proxy settings of the given account's external peer. If the
+// account does not have any such settings, nil is returned to indicate that
+// http.DefaultClient shall be used.
+func NewHTTPClientForExternalPeer(account models.ReducedAccount) (*http.Client, error) {
+	if account.ExternalPeerCABundle == "" && account.ExternalPeerProxyURL == "" {
+		return nil, nil
+	}
+
+	var transport *http.Transport
+	if baseTransport != nil {
+		transport = baseTransport.Clone()
+	} else {
+		transport = &http.Transport{}
+	}
+	if account.ExternalPeerCABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(account.ExternalPeerCABundle)) {
+			return nil, errors.New("CA bundle for external peer does not contain any PEM-encoded certificates")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	if account.ExternalPeerProxyURL != "" {
+		proxyURL, err := url.Parse(account.ExternalPeerProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL for external peer: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	// the User-Agent override from SetupHTTPClient() only applies to http.DefaultTransport, so we need to replicate it here
+	return &http.Client{Transport: userAgentRoundTripper{wrapTransportForTracing(transport)}}, nil
+}
+
+// NewHTTPClientForPeer returns an HTTP client that presents the client
+// certificate configured for the given peer. If the peer does not have a
+// client certificate configured, nil is returned to indicate that
+// http.DefaultClient shall be used.
+func NewHTTPClientForPeer(peer models.Peer) (*http.Client, error) {
+	if peer.ClientCertPath == "" {
+		return nil, nil
+	}
+
+	// the certificate is loaded anew for each client, so that rotated certificates are picked up without a restart
+	client, err := NewHTTPClientWithClientCertificate(peer.ClientCertPath, peer.ClientKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load client certificate for peer %s: %w", peer.HostName, err)
+	}
+	return client, nil
+}
+
+// NewHTTPClientWithClientCertificate returns an HTTP client that presents the
+// TLS client certificate from the given PEM files.
+func NewHTTPClientWithClientCertificate(certPath, keyPath string) (*http.Client, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var transport *http.Transport
+	if baseTransport != nil {
+		transport = baseTransport.Clone()
+	} else {
+		transport = &http.Transport{}
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+
+	// the User-Agent override from SetupHTTPClient() only applies to http.DefaultTransport, so we need to replicate it here
+	return &http.Client{Transport: userAgentRoundTripper{wrapTransportForTracing(transport)}}, nil
+}
+
+type userAgentRoundTripper struct {
+	inner http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (u userAgentRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.Header.Set("User-Agent", fmt.Sprintf("%s/%s", bininfo.Component(), bininfo.VersionOr("rolling")))
+	return u.inner.RoundTrip(r)
+}
@@ -0,0 +1,195 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestNewHTTPClientForExternalPeer(t *testing.T) {
+	ctx := context.Background()
+
+	// without any settings, the default client shall be used
+	client, err := NewHTTPClientForExternalPeer(models.ReducedAccount{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if client != nil {
+		t.Error("expected nil client for account without external peer settings")
+	}
+
+	// garbage CA bundles are rejected
+	_, err = NewHTTPClientForExternalPeer(models.ReducedAccount{ExternalPeerCABundle: "not a certificate"})
+	if err == nil {
+		t.Error("expected error for invalid CA bundle, but got none")
+	}
+
+	// a TLS server with a self-signed certificate is only reachable when its certificate is in the CA bundle
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer tlsServer.Close()
+	caBundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}))
+
+	client, err = NewHTTPClientForExternalPeer(models.ReducedAccount{ExternalPeerCABundle: caBundle})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectStatus(t, ctx, client, tlsServer.URL, http.StatusNoContent)
+
+	// requests go through the configured proxy
+	var proxiedHost string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHost = r.Host
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer proxyServer.Close()
+
+	client, err = NewHTTPClientForExternalPeer(models.ReducedAccount{ExternalPeerProxyURL: proxyServer.URL})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectStatus(t, ctx, client, "http://registry.example.org/v2/", http.StatusAccepted)
+	if proxiedHost != "registry.example.org" {
+		t.Errorf("expected request for registry.example.org to go through proxy, but proxy saw host %q", proxiedHost)
+	}
+}
+
+func TestNewHTTPClientForPeer(t *testing.T) {
+	ctx := context.Background()
+
+	// without a client certificate, the default client shall be used
+	client, err := NewHTTPClientForPeer(models.Peer{HostName: "peer.example.org"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if client != nil {
+		t.Error("expected nil client for peer without client certificate")
+	}
+
+	// missing certificate files are reported as errors
+	_, err = NewHTTPClientForPeer(models.Peer{
+		HostName:       "peer.example.org",
+		ClientCertPath: "/does/not/exist.pem",
+		ClientKeyPath:  "/does/not/exist.key",
+	})
+	if err == nil {
+		t.Error("expected error for missing client certificate, but got none")
+	}
+
+	// a TLS server that requires client certificates is only reachable when the client certificate is presented
+	var seenClientCN string
+	tlsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenClientCN = r.TLS.PeerCertificates[0].Subject.CommonName
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	tlsServer.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	// trust the test server's certificate for the duration of this test
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsServer.Certificate())
+	originalBaseTransport := baseTransport
+	baseTransport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}
+	defer func() { baseTransport = originalBaseTransport }()
+
+	certPath, keyPath := writeSelfSignedClientCertificate(t, "keppel.example.org")
+	client, err = NewHTTPClientForPeer(models.Peer{
+		HostName:       "peer.example.org",
+		ClientCertPath: certPath,
+		ClientKeyPath:  keyPath,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectStatus(t, ctx, client, tlsServer.URL, http.StatusNoContent)
+	if seenClientCN != "keppel.example.org" {
+		t.Errorf("expected server to see client certificate for keppel.example.org, but got %q", seenClientCN)
+	}
+}
+
+func writeSelfSignedClientCertificate(t *testing.T, commonName string) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	dir := t.TempDir()
+	certPath = filepath.Join(dir, "client.pem")
+	keyPath = filepath.Join(dir, "client.key")
+	err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return certPath, keyPath
+}
+
+func expectStatus(t *testing.T, ctx context.Context, client *http.Client, url string, expectedStatus int) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != expectedStatus {
+		t.Errorf("expected GET %s to return %d, but got %d", url, expectedStatus, resp.StatusCode)
+	}
+}
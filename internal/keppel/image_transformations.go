@@ -0,0 +1,63 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppel
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ApplyImageTransformationsToAccount validates the given list of image
+// transformations and stores it in the given account model.
+//
+// WARNING: The replication policy must be applied to the account model before
+// this, since image transformations are not supported on replica accounts.
+func ApplyImageTransformationsToAccount(transformations []models.ImageTransformation, account *models.Account) *RegistryV2Error {
+	if len(transformations) == 0 {
+		account.ImageTransformations = ""
+		return nil
+	}
+
+	isSeen := make(map[models.ImageTransformation]bool)
+	names := make([]string, len(transformations))
+	for idx, t := range transformations {
+		if !t.IsValid() {
+			err := fmt.Errorf("unknown image transformation: %q", t)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+		if isSeen[t] {
+			err := fmt.Errorf("duplicate image transformation: %q", t)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+		isSeen[t] = true
+		names[idx] = string(t)
+	}
+	if account.UpstreamPeerHostName != "" || account.ExternalPeerURL != "" {
+		err := errors.New(`image transformations are only allowed on primary accounts`)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+
+	account.ImageTransformations = strings.Join(names, ",")
+	return nil
+}
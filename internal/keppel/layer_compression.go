@@ -0,0 +1,95 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/klauspost/compress/zstd"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// LayerCompression is an enum that identifies how the contents of an image
+// layer are compressed.
+type LayerCompression string
+
+const (
+	// LayerUncompressed is a LayerCompression.
+	LayerUncompressed LayerCompression = "none"
+	// LayerCompressedWithGzip is a LayerCompression.
+	LayerCompressedWithGzip LayerCompression = "gzip"
+	// LayerCompressedWithZstd is a LayerCompression.
+	LayerCompressedWithZstd LayerCompression = "zstd"
+)
+
+// LayerCompressionForMediaType returns how image layers with the given media
+// type are compressed. If the media type is not a known tar layer type, false
+// is returned.
+func LayerCompressionForMediaType(mediaType string) (LayerCompression, bool) {
+	switch mediaType {
+	case imgspecv1.MediaTypeImageLayer:
+		return LayerUncompressed, true
+	case schema2.MediaTypeLayer, imgspecv1.MediaTypeImageLayerGzip:
+		return LayerCompressedWithGzip, true
+	case imgspecv1.MediaTypeImageLayerZstd:
+		return LayerCompressedWithZstd, true
+	default:
+		return "", false
+	}
+}
+
+// Decompress wraps the given reader of compressed layer contents into a reader
+// that yields the uncompressed contents. Closing the returned reader also
+// closes the original reader.
+func (c LayerCompression) Decompress(reader io.ReadCloser) (io.ReadCloser, error) {
+	switch c {
+	case LayerCompressedWithGzip:
+		gzr, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return decompressingReadCloser{gzr, gzr.Close, reader}, nil
+	case LayerCompressedWithZstd:
+		zr, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return decompressingReadCloser{zr, func() error { zr.Close(); return nil }, reader}, nil
+	default:
+		return reader, nil
+	}
+}
+
+type decompressingReadCloser struct {
+	io.Reader
+	closeReader func() error
+	inner       io.ReadCloser
+}
+
+// Close implements the io.ReadCloser interface.
+func (r decompressingReadCloser) Close() error {
+	err := r.closeReader()
+	errInner := r.inner.Close()
+	if err == nil {
+		err = errInner
+	}
+	return err
+}
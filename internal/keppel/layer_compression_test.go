@@ -0,0 +1,81 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/klauspost/compress/zstd"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sapcc/go-bits/assert"
+)
+
+func TestLayerCompression(t *testing.T) {
+	payload := []byte("hello world, this is the layer contents")
+
+	var gzipBuf bytes.Buffer
+	gzw := gzip.NewWriter(&gzipBuf)
+	gzw.Write(payload) //nolint:errcheck
+	gzw.Close()
+
+	var zstdBuf bytes.Buffer
+	zw, err := zstd.NewWriter(&zstdBuf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	zw.Write(payload) //nolint:errcheck
+	zw.Close()
+
+	testCases := []struct {
+		MediaType           string
+		ExpectedCompression LayerCompression
+		Contents            []byte
+	}{
+		{imgspecv1.MediaTypeImageLayer, LayerUncompressed, payload},
+		{schema2.MediaTypeLayer, LayerCompressedWithGzip, gzipBuf.Bytes()},
+		{imgspecv1.MediaTypeImageLayerGzip, LayerCompressedWithGzip, gzipBuf.Bytes()},
+		{imgspecv1.MediaTypeImageLayerZstd, LayerCompressedWithZstd, zstdBuf.Bytes()},
+	}
+	for _, tc := range testCases {
+		compression, ok := LayerCompressionForMediaType(tc.MediaType)
+		assert.DeepEqual(t, "ok for "+tc.MediaType, ok, true)
+		assert.DeepEqual(t, "compression for "+tc.MediaType, compression, tc.ExpectedCompression)
+
+		reader, err := compression.Decompress(io.NopCloser(bytes.NewReader(tc.Contents)))
+		if err != nil {
+			t.Fatalf("cannot decompress %s: %s", tc.MediaType, err.Error())
+		}
+		contents, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("cannot read %s: %s", tc.MediaType, err.Error())
+		}
+		assert.DeepEqual(t, "contents for "+tc.MediaType, string(contents), string(payload))
+		err = reader.Close()
+		if err != nil {
+			t.Errorf("cannot close reader for %s: %s", tc.MediaType, err.Error())
+		}
+	}
+
+	_, ok := LayerCompressionForMediaType("application/vnd.example.unknown")
+	assert.DeepEqual(t, "ok for unknown media type", ok, false)
+}
@@ -48,6 +48,10 @@ type ParsedManifest interface {
 	// FindImageLayerBlobs returns the descriptors of the blobs containing this
 	// manifest's image layers, or an empty list if the manifest does not have layers.
 	FindImageLayerBlobs() []distribution.Descriptor
+	// HasEmptyConfig returns whether this manifest is an OCI artifact whose
+	// config is the empty JSON object (media type "application/vnd.oci.empty.v1+json").
+	// Such artifacts usually carry all their metadata in annotations instead.
+	HasEmptyConfig() bool
 	// BlobReferences returns all blobs referenced by this manifest.
 	BlobReferences() []distribution.Descriptor
 	// ManifestReferences returns all manifests referenced by this manifest.
@@ -90,6 +94,10 @@ func (a v2ManifestAdapter) FindImageLayerBlobs() []distribution.Descriptor {
 	return a.m.Layers
 }
 
+func (a v2ManifestAdapter) HasEmptyConfig() bool {
+	return false
+}
+
 func (a v2ManifestAdapter) BlobReferences() []distribution.Descriptor {
 	return a.m.References()
 }
@@ -123,6 +131,10 @@ func (a ociManifestAdapter) FindImageLayerBlobs() []distribution.Descriptor {
 	return a.m.Layers
 }
 
+func (a ociManifestAdapter) HasEmptyConfig() bool {
+	return a.m.Config.MediaType == v1.MediaTypeEmptyJSON
+}
+
 func (a ociManifestAdapter) BlobReferences() []distribution.Descriptor {
 	return a.m.References()
 }
@@ -148,6 +160,10 @@ func (a listManifestAdapter) FindImageLayerBlobs() []distribution.Descriptor {
 	return nil
 }
 
+func (a listManifestAdapter) HasEmptyConfig() bool {
+	return false
+}
+
 func (a listManifestAdapter) BlobReferences() []distribution.Descriptor {
 	return nil
 }
@@ -0,0 +1,97 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/pluggable"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ManifestValidationDriver is the abstract interface for an external policy
+// service that decides whether a manifest may be stored in Keppel. This
+// allows operators to enforce organization-specific rules on pushed images
+// without changing Keppel itself.
+type ManifestValidationDriver interface {
+	pluggable.Plugin
+	// Init is called before any other interface methods, and allows the plugin to
+	// perform first-time initialization.
+	Init(context.Context, Configuration) error
+	// ValidateManifest is called whenever a manifest is pushed into an account
+	// (including pushes that are performed by Keppel itself, e.g. during
+	// replication), after Keppel's own validation has succeeded and before the
+	// manifest is stored. If the returned verdict does not allow the manifest,
+	// the push is rejected. If an error is returned, the push fails as well.
+	ValidateManifest(ctx context.Context, req ManifestValidationRequest) (ManifestValidationVerdict, error)
+}
+
+// ManifestValidationRequest is the payload of ManifestValidationDriver.ValidateManifest().
+type ManifestValidationRequest struct {
+	Account    models.ReducedAccount
+	Repository models.Repository
+	// Empty if the manifest is pushed by digest.
+	TagName   string
+	Digest    digest.Digest
+	MediaType string
+	Contents  []byte
+	// The contents of the image config blob, or nil if the manifest does not
+	// reference one (e.g. for image indexes).
+	ConfigContents []byte
+	// The labels that Keppel reports for this manifest (for image indexes,
+	// those labels that all constituent manifests agree on).
+	Labels map[string]string
+	// The name of the user that pushed the manifest, if any.
+	UserName string
+}
+
+// ManifestValidationVerdict is the result of ManifestValidationDriver.ValidateManifest().
+type ManifestValidationVerdict struct {
+	Allowed bool
+	// A human-readable explanation that is shown to the user if the manifest
+	// is rejected.
+	Reason string
+}
+
+// ManifestValidationDriverRegistry is a pluggable.Registry for ManifestValidationDriver implementations.
+var ManifestValidationDriverRegistry pluggable.Registry[ManifestValidationDriver]
+
+// NewManifestValidationDriver creates a new ManifestValidationDriver using
+// one of the plugins registered with ManifestValidationDriverRegistry.
+func NewManifestValidationDriver(ctx context.Context, pluginTypeID string, cfg Configuration) (ManifestValidationDriver, error) {
+	logg.Debug("initializing manifest validation driver %q...", pluginTypeID)
+
+	mvd := ManifestValidationDriverRegistry.Instantiate(pluginTypeID)
+	if mvd == nil {
+		return nil, errors.New("no such manifest validation driver: " + pluginTypeID)
+	}
+	return mvd, mvd.Init(ctx, cfg)
+}
+
+// GetManifestValidationDriverNameFromEnvironment reads the
+// KEPPEL_DRIVER_MANIFEST_VALIDATION environment variable. If external
+// manifest validation is not enabled, an empty string is returned.
+func GetManifestValidationDriverNameFromEnvironment() string {
+	return os.Getenv("KEPPEL_DRIVER_MANIFEST_VALIDATION")
+}
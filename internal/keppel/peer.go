@@ -20,6 +20,8 @@
 package keppel
 
 import (
+	"slices"
+
 	"github.com/go-gorp/gorp/v3"
 
 	"github.com/sapcc/keppel/internal/models"
@@ -36,3 +38,59 @@ func GetPeerFromAccount(db gorp.SqlExecutor, account models.Account) (models.Pee
 	}
 	return peer, nil
 }
+
+// PeerAPIVersion is the version of the peer API that this Keppel instance
+// implements. It is advertised to peers through the GET /peer/v1/version
+// endpoint. Increment this when making backwards-incompatible changes to the
+// peer API, and add capability flags for backwards-compatible additions.
+const PeerAPIVersion = 1
+
+// PeerCapability is an enum of optional features of the peer API. Peers use
+// these to decide whether a certain feature can be used with a given peer
+// while a mixed-version fleet is being upgraded.
+type PeerCapability string
+
+const (
+	// PeerCapabilityDelegatedPull indicates support for the GET /peer/v1/delegatedpull endpoint.
+	PeerCapabilityDelegatedPull PeerCapability = "delegated_pull"
+	// PeerCapabilitySyncReplica indicates support for the POST /peer/v1/sync-replica endpoint.
+	PeerCapabilitySyncReplica PeerCapability = "sync_replica"
+	// PeerCapabilityBlobLocation indicates support for the GET /peer/v1/blob-location endpoint.
+	PeerCapabilityBlobLocation PeerCapability = "blob_location"
+)
+
+// PeerAPIInfo is the response body format of the GET /peer/v1/version endpoint.
+type PeerAPIInfo struct {
+	Version      int              `json:"version"`
+	Capabilities []PeerCapability `json:"capabilities"`
+}
+
+// OurPeerAPIInfo returns the PeerAPIInfo that describes this Keppel instance.
+func OurPeerAPIInfo() PeerAPIInfo {
+	return PeerAPIInfo{
+		Version: PeerAPIVersion,
+		Capabilities: []PeerCapability{
+			PeerCapabilityDelegatedPull,
+			PeerCapabilitySyncReplica,
+			PeerCapabilityBlobLocation,
+		},
+	}
+}
+
+// LegacyPeerAPIInfo returns the PeerAPIInfo that we assume for peers that do
+// not have the GET /peer/v1/version endpoint yet. Those peers support the
+// features that existed before version negotiation was introduced.
+func LegacyPeerAPIInfo() PeerAPIInfo {
+	return PeerAPIInfo{
+		Version: 1,
+		Capabilities: []PeerCapability{
+			PeerCapabilityDelegatedPull,
+			PeerCapabilitySyncReplica,
+		},
+	}
+}
+
+// HasCapability returns whether the given capability is advertised.
+func (i PeerAPIInfo) HasCapability(c PeerCapability) bool {
+	return slices.Contains(i.Capabilities, c)
+}
@@ -0,0 +1,51 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppel
+
+import (
+	"github.com/sapcc/go-bits/regexpext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// PullPolicy represents a pull policy in the API.
+type PullPolicy struct {
+	RequireDigestRepositoryRx regexpext.BoundedRegexp `json:"require_digest_for_repositories,omitempty"`
+}
+
+// RenderPullPolicy builds a PullPolicy object out of the information in the
+// given account model.
+func RenderPullPolicy(account models.ReducedAccount) *PullPolicy {
+	if account.RequireDigestPullsRepoRx == "" {
+		return nil
+	}
+
+	return &PullPolicy{
+		RequireDigestRepositoryRx: account.RequireDigestPullsRepoRx,
+	}
+}
+
+// ApplyToAccount stores this policy in the given account model.
+//
+// No further validation is required here since the regex was already
+// validated during JSON unmarshalling.
+func (p PullPolicy) ApplyToAccount(account *models.Account) {
+	account.RequireDigestPullsRepoRx = p.RequireDigestRepositoryRx
+}
@@ -0,0 +1,68 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// QuarantinePolicy represents a quarantine policy in the API.
+type QuarantinePolicy struct {
+	SeverityThreshold models.VulnerabilityStatus `json:"severity_threshold"`
+}
+
+// RenderQuarantinePolicy builds a QuarantinePolicy object out of the
+// information in the given account model.
+func RenderQuarantinePolicy(account models.ReducedAccount) *QuarantinePolicy {
+	if !account.IsQuarantineEnabled() {
+		return nil
+	}
+
+	return &QuarantinePolicy{
+		SeverityThreshold: account.QuarantineSeverityThreshold,
+	}
+}
+
+// ApplyToAccount validates this policy and stores it in the given account model.
+//
+// WARNING: The replication policy must be applied to the account model before
+// this, since quarantine is not supported on replica accounts.
+func (q QuarantinePolicy) ApplyToAccount(account *models.Account) *RegistryV2Error {
+	if q.SeverityThreshold == "" {
+		// an empty threshold disables the quarantine
+		account.QuarantineSeverityThreshold = ""
+		return nil
+	}
+
+	if !q.SeverityThreshold.HasReport() || q.SeverityThreshold == models.CleanSeverity {
+		err := fmt.Errorf(`invalid severity threshold for quarantine: %q`, q.SeverityThreshold)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+	if account.UpstreamPeerHostName != "" || account.ExternalPeerURL != "" {
+		err := errors.New(`quarantine policy is only allowed on primary accounts`)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+
+	account.QuarantineSeverityThreshold = q.SeverityThreshold
+	return nil
+}
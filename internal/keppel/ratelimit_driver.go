@@ -52,8 +52,36 @@ const (
 	// TrivyReportRetrieveAction is a RateLimitedAction.
 	// It refers to reports being retrieved from keppel through the trivy proxy from trivy itself.
 	TrivyReportRetrieveAction RateLimitedAction = "retrievetrivyreport"
+	// SecurityScanRequestAction is a RateLimitedAction.
+	// It refers to users requesting an immediate re-scan of a manifest by the vulnerability scanner.
+	SecurityScanRequestAction RateLimitedAction = "requestsecurityscan"
+	// AnonymousBlobPullAction is a RateLimitedAction.
+	// It refers to blob pulls by anonymous users, which are counted per client IP across all accounts.
+	AnonymousBlobPullAction RateLimitedAction = "pullblobanonymous"
+	// AnonymousManifestPullAction is a RateLimitedAction.
+	// It refers to manifest pulls by anonymous users, which are counted per client IP across all accounts.
+	AnonymousManifestPullAction RateLimitedAction = "pullmanifestanonymous"
 )
 
+// AnonymousVariant returns the action that anonymous users additionally need
+// to pass the rate limit for when performing this action, if any.
+func (a RateLimitedAction) AnonymousVariant() (RateLimitedAction, bool) {
+	switch a {
+	case BlobPullAction:
+		return AnonymousBlobPullAction, true
+	case ManifestPullAction:
+		return AnonymousManifestPullAction, true
+	default:
+		return "", false
+	}
+}
+
+// isKeyedOnClientIP returns whether the rate limit for this action is shared
+// between all accounts (i.e. only keyed on the client IP).
+func (a RateLimitedAction) isKeyedOnClientIP() bool {
+	return a == AnonymousBlobPullAction || a == AnonymousManifestPullAction
+}
+
 // RateLimitDriver is a pluggable strategy that determines the rate limits of
 // each account.
 type RateLimitDriver interface {
@@ -124,6 +152,10 @@ func (e RateLimitEngine) RateLimitAllows(ctx context.Context, remoteAddr string,
 
 	limiter := redis_rate.NewLimiter(e.Client)
 	key := fmt.Sprintf("keppel-ratelimit-%s-%s-%s", remoteAddr, account.Name, string(action))
+	if action.isKeyedOnClientIP() {
+		// scrapers tend to walk across many accounts, so these buckets must not be per-account
+		key = fmt.Sprintf("keppel-ratelimit-ip-%s-%s", remoteAddr, string(action))
+	}
 	result, err := limiter.AllowN(ctx, key, *rateQuota, int(amount))
 	if err != nil {
 		return false, &redis_rate.Result{}, err
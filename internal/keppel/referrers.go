@@ -0,0 +1,48 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// ReferrersFallbackTagName returns the name of the tag under which clients
+// store an image index listing all referrers of the manifest with the given
+// digest, when the registry does not offer the Referrers API. This is known as
+// the "referrers tag schema" in the OCI Distribution Spec.
+func ReferrersFallbackTagName(subjectDigest digest.Digest) string {
+	return fmt.Sprintf("%s-%s", subjectDigest.Algorithm(), subjectDigest.Encoded())
+}
+
+// ParseReferrersFallbackTagName is the inverse of ReferrersFallbackTagName. It
+// returns false if the given tag name is not a referrers fallback tag.
+func ParseReferrersFallbackTagName(tagName string) (digest.Digest, bool) {
+	algorithm, encoded, ok := strings.Cut(tagName, "-")
+	if !ok {
+		return "", false
+	}
+	d := digest.NewDigestFromEncoded(digest.Algorithm(algorithm), encoded)
+	if d.Validate() != nil {
+		return "", false
+	}
+	return d, true
+}
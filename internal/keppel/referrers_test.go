@@ -0,0 +1,41 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+)
+
+func TestReferrersFallbackTagNames(t *testing.T) {
+	d := digest.Canonical.FromString("hello")
+	tagName := ReferrersFallbackTagName(d)
+	assert.DeepEqual(t, "tag name", tagName, "sha256-"+d.Encoded())
+
+	parsed, ok := ParseReferrersFallbackTagName(tagName)
+	assert.DeepEqual(t, "ParseReferrersFallbackTagName ok", ok, true)
+	assert.DeepEqual(t, "ParseReferrersFallbackTagName digest", parsed, d)
+
+	for _, name := range []string{"latest", "v1-2", "sha256-1234", "sha256-" + d.Encoded() + ".sig", "SHA256-" + d.Encoded()} {
+		_, ok := ParseReferrersFallbackTagName(name)
+		assert.DeepEqual(t, "ParseReferrersFallbackTagName ok for "+name, ok, false)
+	}
+}
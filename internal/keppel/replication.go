@@ -19,9 +19,14 @@
 package keppel
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/sapcc/keppel/internal/models"
 )
@@ -31,8 +36,14 @@ type ReplicationPolicy struct {
 	Strategy ReplicationStrategy `json:"strategy"`
 	// only for `on_first_use`
 	UpstreamPeerHostName string `json:"upstream_peer_hostname"`
-	// only for `from_external_on_first_use`
+	// only for `on_first_use` (optional)
+	ConfigSync *ReplicationConfigSyncSpec `json:"config_sync,omitempty"`
+	// only for `from_external_on_first_use` and `proxy_cache`
 	ExternalPeer ReplicationExternalPeerSpec `json:"external_peer"`
+	// optional for all strategies
+	RetainOrphansFor *Duration                `json:"retain_orphans_for,omitempty"`
+	PrefetchBlobs    bool                     `json:"prefetch_blobs,omitempty"`
+	Failover         *ReplicationFailoverSpec `json:"failover,omitempty"`
 }
 
 // ReplicationStrategy is an enum that appears in type ReplicationPolicy.
@@ -42,6 +53,7 @@ const (
 	NoReplicationStrategy          ReplicationStrategy = ""
 	OnFirstUseStrategy             ReplicationStrategy = "on_first_use"
 	FromExternalOnFirstUseStrategy ReplicationStrategy = "from_external_on_first_use"
+	ProxyCacheStrategy             ReplicationStrategy = "proxy_cache"
 )
 
 // ReplicationExternalPeerSpec appears in type ReplicationPolicy.
@@ -49,6 +61,64 @@ type ReplicationExternalPeerSpec struct {
 	URL      string `json:"url"`
 	UserName string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
+	// PasswordSecretRef may be given instead of Password (see ValidateSecretRef
+	// for the format). Only managed accounts may use this, since the referenced
+	// secrets are resolved with the privileges of the Keppel process.
+	PasswordSecretRef string `json:"password_secret_ref,omitempty"`
+	CABundle          string `json:"ca_bundle,omitempty"`
+	ProxyURL          string `json:"proxy_url,omitempty"`
+}
+
+// ReplicationFailoverSpec appears in type ReplicationPolicy. If present, the
+// replica account replicates from the secondary upstream given here while its
+// primary upstream has been unreachable for at least the given duration (see
+// tasks.UpstreamHealthCheckJob).
+type ReplicationFailoverSpec struct {
+	// for `on_first_use`, the hostname of another peer; for the other
+	// strategies, an upstream URL in the same format as in
+	// ReplicationExternalPeerSpec (the credentials and connection settings of
+	// the primary upstream are reused for it)
+	Upstream string   `json:"upstream"`
+	After    Duration `json:"after"`
+}
+
+// ReplicationConfigSyncSpec appears in type ReplicationPolicy. If present and
+// enabled, the replica account periodically copies its configuration from the
+// primary account.
+type ReplicationConfigSyncSpec struct {
+	Enabled        bool              `json:"enabled"`
+	ExcludedFields []ConfigSyncField `json:"exclude,omitempty"`
+}
+
+// ConfigSyncField is an enum that appears in type ReplicationConfigSyncSpec.
+// It identifies a part of the account configuration that can be copied from
+// the primary account.
+type ConfigSyncField string
+
+const (
+	ConfigSyncRBACPolicies   ConfigSyncField = "rbac_policies"
+	ConfigSyncGCPolicies     ConfigSyncField = "gc_policies"
+	ConfigSyncPlatformFilter ConfigSyncField = "platform_filter"
+)
+
+// AllConfigSyncFields lists all valid values of type ConfigSyncField.
+var AllConfigSyncFields = []ConfigSyncField{
+	ConfigSyncRBACPolicies,
+	ConfigSyncGCPolicies,
+	ConfigSyncPlatformFilter,
+}
+
+// ParseConfigSyncExcludedFields parses the ConfigSyncExcludedFields field of
+// the given account model.
+func ParseConfigSyncExcludedFields(account models.Account) []ConfigSyncField {
+	if account.ConfigSyncExcludedFields == "" {
+		return nil
+	}
+	var result []ConfigSyncField
+	for _, field := range strings.Split(account.ConfigSyncExcludedFields, ",") {
+		result = append(result, ConfigSyncField(field))
+	}
+	return result
 }
 
 // MarshalJSON implements the json.Marshaler interface.
@@ -56,15 +126,22 @@ func (r ReplicationPolicy) MarshalJSON() ([]byte, error) {
 	switch r.Strategy {
 	case OnFirstUseStrategy:
 		data := struct {
-			Strategy             ReplicationStrategy `json:"strategy"`
-			UpstreamPeerHostName string              `json:"upstream"`
-		}{r.Strategy, r.UpstreamPeerHostName}
+			Strategy             ReplicationStrategy        `json:"strategy"`
+			UpstreamPeerHostName string                     `json:"upstream"`
+			ConfigSync           *ReplicationConfigSyncSpec `json:"config_sync,omitempty"`
+			RetainOrphansFor     *Duration                  `json:"retain_orphans_for,omitempty"`
+			PrefetchBlobs        bool                       `json:"prefetch_blobs,omitempty"`
+			Failover             *ReplicationFailoverSpec   `json:"failover,omitempty"`
+		}{r.Strategy, r.UpstreamPeerHostName, r.ConfigSync, r.RetainOrphansFor, r.PrefetchBlobs, r.Failover}
 		return json.Marshal(data)
-	case FromExternalOnFirstUseStrategy:
+	case FromExternalOnFirstUseStrategy, ProxyCacheStrategy:
 		data := struct {
-			Strategy     ReplicationStrategy         `json:"strategy"`
-			ExternalPeer ReplicationExternalPeerSpec `json:"upstream"`
-		}{r.Strategy, r.ExternalPeer}
+			Strategy         ReplicationStrategy         `json:"strategy"`
+			ExternalPeer     ReplicationExternalPeerSpec `json:"upstream"`
+			RetainOrphansFor *Duration                   `json:"retain_orphans_for,omitempty"`
+			PrefetchBlobs    bool                        `json:"prefetch_blobs,omitempty"`
+			Failover         *ReplicationFailoverSpec    `json:"failover,omitempty"`
+		}{r.Strategy, r.ExternalPeer, r.RetainOrphansFor, r.PrefetchBlobs, r.Failover}
 		return json.Marshal(data)
 	default:
 		return nil, fmt.Errorf("do not know how to serialize ReplicationPolicy with strategy %q", r.Strategy)
@@ -74,14 +151,22 @@ func (r ReplicationPolicy) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON implements the json.Unmarshaler interface.
 func (r *ReplicationPolicy) UnmarshalJSON(buf []byte) error {
 	var s struct {
-		Strategy ReplicationStrategy `json:"strategy"`
-		Upstream json.RawMessage     `json:"upstream"`
+		Strategy         ReplicationStrategy        `json:"strategy"`
+		Upstream         json.RawMessage            `json:"upstream"`
+		ConfigSync       *ReplicationConfigSyncSpec `json:"config_sync"`
+		RetainOrphansFor *Duration                  `json:"retain_orphans_for"`
+		PrefetchBlobs    bool                       `json:"prefetch_blobs"`
+		Failover         *ReplicationFailoverSpec   `json:"failover"`
 	}
 	err := json.Unmarshal(buf, &s)
 	if err != nil {
 		return err
 	}
 	r.Strategy = s.Strategy
+	r.ConfigSync = s.ConfigSync
+	r.RetainOrphansFor = s.RetainOrphansFor
+	r.PrefetchBlobs = s.PrefetchBlobs
+	r.Failover = s.Failover
 
 	if len(s.Upstream) == 0 {
 		// need a more explicit error for this, otherwise the next json.Unmarshal()
@@ -92,7 +177,7 @@ func (r *ReplicationPolicy) UnmarshalJSON(buf []byte) error {
 	switch r.Strategy {
 	case OnFirstUseStrategy:
 		return json.Unmarshal(s.Upstream, &r.UpstreamPeerHostName)
-	case FromExternalOnFirstUseStrategy:
+	case FromExternalOnFirstUseStrategy, ProxyCacheStrategy:
 		return json.Unmarshal(s.Upstream, &r.ExternalPeer)
 	default:
 		return fmt.Errorf("do not know how to deserialize ReplicationPolicy with strategy %q", r.Strategy)
@@ -102,21 +187,54 @@ func (r *ReplicationPolicy) UnmarshalJSON(buf []byte) error {
 // RenderReplicationPolicy builds a ReplicationPolicy object out of the
 // information in the given account model.
 func RenderReplicationPolicy(account models.Account) *ReplicationPolicy {
+	var retainOrphansFor *Duration
+	if account.RetainOrphansForSecs > 0 {
+		d := Duration(time.Duration(account.RetainOrphansForSecs) * time.Second)
+		retainOrphansFor = &d
+	}
+	var failover *ReplicationFailoverSpec
+	if account.FailoverUpstream != "" {
+		failover = &ReplicationFailoverSpec{
+			Upstream: account.FailoverUpstream,
+			After:    Duration(time.Duration(account.FailoverAfterSecs) * time.Second),
+		}
+	}
+
 	if account.UpstreamPeerHostName != "" {
-		return &ReplicationPolicy{
+		rp := &ReplicationPolicy{
 			Strategy:             OnFirstUseStrategy,
 			UpstreamPeerHostName: account.UpstreamPeerHostName,
+			RetainOrphansFor:     retainOrphansFor,
+			PrefetchBlobs:        account.PrefetchBlobs,
+			Failover:             failover,
+		}
+		if account.ConfigSyncEnabled {
+			rp.ConfigSync = &ReplicationConfigSyncSpec{
+				Enabled:        true,
+				ExcludedFields: ParseConfigSyncExcludedFields(account),
+			}
 		}
+		return rp
 	}
 
 	if account.ExternalPeerURL != "" {
+		strategy := FromExternalOnFirstUseStrategy
+		if account.IsProxyCache {
+			strategy = ProxyCacheStrategy
+		}
 		return &ReplicationPolicy{
-			Strategy: FromExternalOnFirstUseStrategy,
+			Strategy: strategy,
 			ExternalPeer: ReplicationExternalPeerSpec{
 				URL:      account.ExternalPeerURL,
 				UserName: account.ExternalPeerUserName,
 				//NOTE: Password is omitted here for security reasons
+				PasswordSecretRef: account.ExternalPeerPasswordRef,
+				CABundle:          account.ExternalPeerCABundle,
+				ProxyURL:          redactURLPassword(account.ExternalPeerProxyURL),
 			},
+			RetainOrphansFor: retainOrphansFor,
+			PrefetchBlobs:    account.PrefetchBlobs,
+			Failover:         failover,
 		}
 	}
 
@@ -146,23 +264,88 @@ func (r ReplicationPolicy) ApplyToAccount(account *models.Account) error {
 			return ErrIncompatibleReplicationPolicy
 		}
 
-	case FromExternalOnFirstUseStrategy:
-		rerr := r.ExternalPeer.applyToAccount(account)
+		// the config sync settings can be changed at will
+		err := r.ConfigSync.applyToAccount(account)
+		if err != nil {
+			return err
+		}
+
+	case FromExternalOnFirstUseStrategy, ProxyCacheStrategy:
+		if r.ConfigSync != nil {
+			return fmt.Errorf(`config sync is only supported for %q replication`, OnFirstUseStrategy)
+		}
+		rerr := r.ExternalPeer.applyToAccount(account, r.Strategy)
 		if rerr != nil {
 			return rerr
 		}
+		account.IsProxyCache = r.Strategy == ProxyCacheStrategy
 
 	default:
 		return fmt.Errorf("strategy %s is unsupported", r.Strategy)
 	}
 
+	// the orphan retention period can be changed at will
+	account.RetainOrphansForSecs = 0
+	if r.RetainOrphansFor != nil {
+		if *r.RetainOrphansFor < 0 {
+			return errors.New(`"retain_orphans_for" may not be negative`)
+		}
+		account.RetainOrphansForSecs = int64(time.Duration(*r.RetainOrphansFor) / time.Second)
+	}
+
+	// the same goes for blob prefetching and the failover upstream
+	account.PrefetchBlobs = r.PrefetchBlobs
+	return r.Failover.applyToAccount(account)
+}
+
+func (f *ReplicationFailoverSpec) applyToAccount(account *models.Account) error {
+	if f == nil {
+		account.FailoverUpstream = ""
+		account.FailoverAfterSecs = 0
+		account.UpstreamUnreachableSince = nil
+		account.IsUpstreamFailoverActive = false
+		return nil
+	}
+
+	if f.Upstream == "" {
+		return errors.New(`missing upstream for replication failover`)
+	}
+	if f.Upstream == account.UpstreamPeerHostName || f.Upstream == account.ExternalPeerURL {
+		return errors.New(`upstream for replication failover must be different from the primary upstream`)
+	}
+	if f.After < Duration(time.Minute) {
+		return errors.New(`"failover.after" must be at least one minute`)
+	}
+	account.FailoverUpstream = f.Upstream
+	account.FailoverAfterSecs = int64(time.Duration(f.After) / time.Second)
 	return nil
 }
 
-func (r ReplicationExternalPeerSpec) applyToAccount(account *models.Account) error {
+func (c *ReplicationConfigSyncSpec) applyToAccount(account *models.Account) error {
+	if c == nil || !c.Enabled {
+		account.ConfigSyncEnabled = false
+		account.ConfigSyncExcludedFields = ""
+		return nil
+	}
+
+	excludedFields := make([]string, 0, len(c.ExcludedFields))
+	for _, field := range c.ExcludedFields {
+		if !slices.Contains(AllConfigSyncFields, field) {
+			return fmt.Errorf(`%q is not a valid field for config sync`, field)
+		}
+		if !slices.Contains(excludedFields, string(field)) {
+			excludedFields = append(excludedFields, string(field))
+		}
+	}
+	account.ConfigSyncEnabled = true
+	account.ConfigSyncExcludedFields = strings.Join(excludedFields, ",")
+	return nil
+}
+
+func (r ReplicationExternalPeerSpec) applyToAccount(account *models.Account, strategy ReplicationStrategy) error {
 	// peer URL must be given for new accounts, and stay consistent for existing accounts
 	if r.URL == "" {
-		return errors.New(`missing upstream URL for "from_external_on_first_use" replication`)
+		return fmt.Errorf(`missing upstream URL for %q replication`, strategy)
 	}
 	isNewAccount := account.ExternalPeerURL == ""
 	if isNewAccount {
@@ -174,19 +357,56 @@ func (r ReplicationExternalPeerSpec) applyToAccount(account *models.Account) err
 	// on existing accounts, having only a username is acceptable if it's unchanged
 	// (this case occurs when a client GETs the account, changes something not related
 	// to replication, and PUTs the result; the password is redacted in GET)
-	if !isNewAccount && r.UserName != "" && r.Password == "" {
+	if !isNewAccount && r.UserName != "" && r.Password == "" && r.PasswordSecretRef == "" {
 		if r.UserName == account.ExternalPeerUserName {
 			r.Password = account.ExternalPeerPassword // to save it from being overwritten below
 		} else {
-			return errors.New(`cannot change username for "from_external_on_first_use" replication without also changing password`)
+			return fmt.Errorf(`cannot change username for %q replication without also changing password`, strategy)
 		}
 	}
 
 	// pull credentials can be updated mostly at will
-	if (r.UserName == "") != (r.Password == "") {
-		return errors.New(`need either both username and password or neither for "from_external_on_first_use" replication`)
+	if r.Password != "" && r.PasswordSecretRef != "" {
+		return fmt.Errorf(`cannot have both password and password_secret_ref for %q replication`, strategy)
+	}
+	hasPassword := r.Password != "" || r.PasswordSecretRef != ""
+	if (r.UserName != "") != hasPassword {
+		return fmt.Errorf(`need either both username and password or neither for %q replication`, strategy)
+	}
+	if r.PasswordSecretRef != "" {
+		err := ValidateSecretRef(r.PasswordSecretRef)
+		if err != nil {
+			return fmt.Errorf(`invalid password_secret_ref for %q replication: %w`, strategy, err)
+		}
 	}
 	account.ExternalPeerUserName = r.UserName
 	account.ExternalPeerPassword = r.Password
+	account.ExternalPeerPasswordRef = r.PasswordSecretRef
+
+	// connection settings can be updated at will
+	if r.CABundle != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(r.CABundle)) {
+		return fmt.Errorf(`CA bundle for %q replication does not contain any PEM-encoded certificates`, strategy)
+	}
+	if r.ProxyURL != "" && r.ProxyURL == redactURLPassword(account.ExternalPeerProxyURL) {
+		// the proxy URL is rendered with a redacted password, so accept that form as "unchanged" (same reasoning as above)
+		r.ProxyURL = account.ExternalPeerProxyURL
+	}
+	if r.ProxyURL != "" {
+		proxyURL, err := url.Parse(r.ProxyURL)
+		if err != nil || (proxyURL.Scheme != "http" && proxyURL.Scheme != "https") || proxyURL.Host == "" {
+			return fmt.Errorf(`invalid proxy URL for %q replication: %q`, strategy, redactURLPassword(r.ProxyURL))
+		}
+	}
+	account.ExternalPeerCABundle = r.CABundle
+	account.ExternalPeerProxyURL = r.ProxyURL
 	return nil
 }
+
+// Proxy URLs may contain credentials, so their passwords are redacted in the API.
+func redactURLPassword(input string) string {
+	u, err := url.Parse(input)
+	if err != nil {
+		return input
+	}
+	return u.Redacted()
+}
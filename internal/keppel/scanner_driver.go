@@ -0,0 +1,95 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/pluggable"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/trivy"
+)
+
+// ScannerDriver is the abstract interface for a vulnerability scanner that
+// inspects images stored in Keppel.
+//
+// Keppel uses the report format of Trivy as its lingua franca: Reports in the
+// format "json" must always follow the structure of type trivy.Report. Drivers
+// for scanners with a different native report format need to convert their
+// reports into that structure.
+type ScannerDriver interface {
+	pluggable.Plugin
+	// Init is called before any other interface methods, and allows the plugin to
+	// perform first-time initialization.
+	Init(context.Context, Configuration) error
+	// SupportsReportFormat returns whether ScanManifest can produce reports in
+	// the given format. The format "json" must always be supported.
+	SupportsReportFormat(format string) bool
+	// ScanManifest scans the given manifest and returns a report in the given
+	// format. The scanner shall pull the image from Keppel using the given token
+	// (see auth.IssueTokenForTrivy).
+	ScanManifest(ctx context.Context, keppelToken string, manifestRef models.ImageReference, format string) (trivy.ReportPayload, error)
+}
+
+// ScannerDriverRegistry is a pluggable.Registry for ScannerDriver implementations.
+var ScannerDriverRegistry pluggable.Registry[ScannerDriver]
+
+// NewScannerDriver creates a new ScannerDriver using one of the plugins
+// registered with ScannerDriverRegistry.
+func NewScannerDriver(ctx context.Context, pluginTypeID string, cfg Configuration) (ScannerDriver, error) {
+	logg.Debug("initializing scanner driver %q...", pluginTypeID)
+
+	sd := ScannerDriverRegistry.Instantiate(pluginTypeID)
+	if sd == nil {
+		return nil, errors.New("no such scanner driver: " + pluginTypeID)
+	}
+	return sd, sd.Init(ctx, cfg)
+}
+
+// GetScannerDriverNameFromEnvironment reads the KEPPEL_DRIVER_SCANNER
+// environment variable. If vulnerability scanning is not enabled, an empty
+// string is returned.
+func GetScannerDriverNameFromEnvironment() string {
+	driverName := os.Getenv("KEPPEL_DRIVER_SCANNER")
+	if driverName == "" && os.Getenv("KEPPEL_TRIVY_URL") != "" {
+		// before scanner drivers were introduced, Trivy was enabled just by
+		// configuring its URL, so we need to keep supporting that
+		driverName = "trivy"
+	}
+	return driverName
+}
+
+// ScanManifestAndParse is like ScannerDriver.ScanManifest, except that the
+// result is parsed instead of being returned as a bytestring. The report
+// format "json" is implied in order to match the return type.
+func ScanManifestAndParse(ctx context.Context, sd ScannerDriver, keppelToken string, manifestRef models.ImageReference) (trivy.Report, error) {
+	report, err := sd.ScanManifest(ctx, keppelToken, manifestRef, "json")
+	if err != nil {
+		return trivy.Report{}, err
+	}
+
+	var parsedReport trivy.Report
+	err = json.Unmarshal(report.Contents, &parsedReport)
+	return parsedReport, err
+}
@@ -0,0 +1,157 @@
+/*******************************************************************************
+*
+* Copyright 2022 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/sapcc/go-bits/osext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ValidateSecretRef checks whether the given string is a well-formed secret
+// reference. The following formats are understood:
+//
+//   - "env:NAME" refers to the value of the environment variable NAME (e.g. as
+//     populated from a Kubernetes secret).
+//   - "file:/path/to/file" refers to the contents of a file (e.g. a Kubernetes
+//     secret mounted into the container). A single trailing newline is ignored.
+//   - "vault:path/to/secret#key" refers to the field "key" of a secret in a
+//     KV engine of the Vault server at $VAULT_ADDR. Both KV version 1 and 2 are
+//     supported. For KV version 2, the path needs to include the "data/"
+//     segment, e.g. "vault:secret/data/keppel/dockerhub#password".
+func ValidateSecretRef(ref string) error {
+	scheme, value, ok := strings.Cut(ref, ":")
+	if !ok || value == "" {
+		return fmt.Errorf("malformed secret reference: %q", ref)
+	}
+	switch scheme {
+	case "env", "file":
+		return nil
+	case "vault":
+		path, key, ok := strings.Cut(value, "#")
+		if !ok || path == "" || key == "" {
+			return fmt.Errorf(`malformed secret reference: %q (expected "vault:<path>#<key>")`, ref)
+		}
+		return nil
+	default:
+		return fmt.Errorf("malformed secret reference: %q (unknown scheme %q)", ref, scheme)
+	}
+}
+
+// ResolveSecretRef obtains the current value of the secret referenced by the
+// given string. The reference is not cached, so rotated secrets are picked up
+// immediately.
+func ResolveSecretRef(ctx context.Context, ref string) (string, error) {
+	err := ValidateSecretRef(ref)
+	if err != nil {
+		return "", err
+	}
+	scheme, value, _ := strings.Cut(ref, ":")
+
+	var secret string
+	switch scheme {
+	case "env":
+		secret = os.Getenv(value)
+	case "file":
+		buf, err := os.ReadFile(value)
+		if err != nil {
+			return "", fmt.Errorf("cannot resolve secret reference %q: %w", ref, err)
+		}
+		secret = strings.TrimSuffix(string(buf), "\n")
+	case "vault":
+		path, key, _ := strings.Cut(value, "#")
+		secret, err = readSecretFromVault(ctx, path, key)
+		if err != nil {
+			return "", fmt.Errorf("cannot resolve secret reference %q: %w", ref, err)
+		}
+	}
+
+	if secret == "" {
+		return "", fmt.Errorf("cannot resolve secret reference %q: secret is empty", ref)
+	}
+	return secret, nil
+}
+
+func readSecretFromVault(ctx context.Context, path, key string) (string, error) {
+	vaultAddr, err := osext.NeedGetenv("VAULT_ADDR")
+	if err != nil {
+		return "", err
+	}
+	vaultToken, err := osext.NeedGetenv("VAULT_TOKEN")
+	if err != nil {
+		return "", err
+	}
+
+	reqURL, err := url.JoinPath(vaultAddr, "v1", path)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", vaultToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s returned unexpected status %s", reqURL, resp.Status)
+	}
+
+	var respBody struct {
+		Data map[string]any `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode response from GET %s: %w", reqURL, err)
+	}
+
+	// KV version 1 has the fields directly in "data", KV version 2 has them in "data.data"
+	fields := respBody.Data
+	if nested, ok := fields["data"].(map[string]any); ok {
+		fields = nested
+	}
+	secret, ok := fields[key].(string)
+	if !ok {
+		return "", errors.New("secret does not have a string field named " + key)
+	}
+	return secret, nil
+}
+
+// ResolveExternalPeerPassword returns the password for pulling from the
+// external peer of the given replica account. If the account refers to a
+// secret instead of storing the password directly, that secret is resolved.
+func ResolveExternalPeerPassword(ctx context.Context, account models.ReducedAccount) (string, error) {
+	if account.ExternalPeerPasswordRef == "" {
+		return account.ExternalPeerPassword, nil
+	}
+	return ResolveSecretRef(ctx, account.ExternalPeerPasswordRef)
+}
@@ -0,0 +1,110 @@
+/*******************************************************************************
+*
+* Copyright 2022 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestSecretRefs(t *testing.T) {
+	ctx := context.Background()
+
+	// malformed references are rejected
+	for _, ref := range []string{"", "hunter2", "env:", "magic:foo", "vault:secret/data/foo", "vault:#password"} {
+		if ValidateSecretRef(ref) == nil {
+			t.Errorf("expected secret reference %q to be rejected, but it was accepted", ref)
+		}
+	}
+
+	// "env:" references
+	t.Setenv("KEPPEL_TEST_SECRET", "hunter2")
+	expectSecret(t, ctx, "env:KEPPEL_TEST_SECRET", "hunter2")
+	_, err := ResolveSecretRef(ctx, "env:KEPPEL_TEST_SECRET_UNSET")
+	if err == nil {
+		t.Error("expected error for unset environment variable, but got none")
+	}
+
+	// "file:" references (a trailing newline is ignored)
+	path := filepath.Join(t.TempDir(), "password")
+	err = os.WriteFile(path, []byte("swordfish\n"), 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectSecret(t, ctx, "file:"+path, "swordfish")
+
+	// "vault:" references (for KV version 1 and 2)
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.vaulttoken" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/kv1/keppel/dockerhub":
+			w.Write([]byte(`{"data":{"password":"kv1secret"}}`)) //nolint:errcheck
+		case "/v1/secret/data/keppel/dockerhub":
+			w.Write([]byte(`{"data":{"data":{"password":"kv2secret"},"metadata":{"version":3}}}`)) //nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vault.Close()
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "s.vaulttoken")
+	expectSecret(t, ctx, "vault:kv1/keppel/dockerhub#password", "kv1secret")
+	expectSecret(t, ctx, "vault:secret/data/keppel/dockerhub#password", "kv2secret")
+	for _, ref := range []string{"vault:secret/data/keppel/dockerhub#username", "vault:secret/data/keppel/other#password"} {
+		_, err := ResolveSecretRef(ctx, ref)
+		if err == nil {
+			t.Errorf("expected error when resolving %q, but got none", ref)
+		}
+	}
+
+	// ResolveExternalPeerPassword prefers the secret reference if there is one
+	password, err := ResolveExternalPeerPassword(ctx, models.ReducedAccount{ExternalPeerPassword: "stored"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "password", password, "stored")
+	password, err = ResolveExternalPeerPassword(ctx, models.ReducedAccount{ExternalPeerPasswordRef: "env:KEPPEL_TEST_SECRET"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "password", password, "hunter2")
+}
+
+func expectSecret(t *testing.T, ctx context.Context, ref, expected string) {
+	t.Helper()
+	secret, err := ResolveSecretRef(ctx, ref)
+	if err != nil {
+		t.Errorf("cannot resolve %q: %s", ref, err.Error())
+		return
+	}
+	assert.DeepEqual(t, "secret for "+ref, secret, expected)
+}
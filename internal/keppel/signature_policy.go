@@ -0,0 +1,241 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+const (
+	// CosignSimpleSigningMediaType is the media type of layers in cosign
+	// signature manifests. These layers contain the payload that was signed.
+	CosignSimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// CosignSignatureAnnotation is the layer annotation that holds the base64-encoded signature of the payload.
+	CosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+// matches tag names like "sha256-1234abcd.sig" that cosign uses to store
+// signatures, attestations and SBOMs next to the manifest that they refer to
+var cosignArtifactTagNameRx = regexp.MustCompile(`^[a-z0-9]+-[0-9a-f]+\.(?:sig|att|sbom)$`)
+
+// CosignSignatureTagName returns the name of the tag under which cosign stores
+// the signatures for the manifest with the given digest.
+func CosignSignatureTagName(manifestDigest digest.Digest) string {
+	return fmt.Sprintf("%s-%s.sig", manifestDigest.Algorithm(), manifestDigest.Encoded())
+}
+
+// ParseCosignSignatureTagName is the inverse of CosignSignatureTagName. It
+// returns false if the given tag name is not a cosign signature tag.
+func ParseCosignSignatureTagName(tagName string) (digest.Digest, bool) {
+	if !strings.HasSuffix(tagName, ".sig") || !IsCosignArtifactTagName(tagName) {
+		return "", false
+	}
+	algorithm, encoded, _ := strings.Cut(strings.TrimSuffix(tagName, ".sig"), "-")
+	d := digest.NewDigestFromEncoded(digest.Algorithm(algorithm), encoded)
+	if d.Validate() != nil {
+		return "", false
+	}
+	return d, true
+}
+
+// IsCosignArtifactTagName returns whether the given tag name follows the
+// naming scheme that cosign uses for signatures, attestations and SBOMs.
+func IsCosignArtifactTagName(tagName string) bool {
+	return cosignArtifactTagNameRx.MatchString(tagName)
+}
+
+// SignaturePolicy represents the "require_signature" section of a validation policy in the API.
+type SignaturePolicy struct {
+	Enforcement       models.SignatureEnforcement `json:"enforcement"`
+	TrustedPublicKeys []string                    `json:"trusted_public_keys"`
+}
+
+// RenderSignaturePolicy builds a SignaturePolicy object out of the
+// information in the given account model.
+func RenderSignaturePolicy(account models.Account) *SignaturePolicy {
+	if account.RequireSignatureMode == models.SignatureNotRequired {
+		return nil
+	}
+
+	var keys []string
+	rest := []byte(account.SignaturePublicKeys)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		keys = append(keys, strings.TrimSpace(string(pem.EncodeToMemory(block))))
+	}
+
+	return &SignaturePolicy{
+		Enforcement:       account.RequireSignatureMode,
+		TrustedPublicKeys: keys,
+	}
+}
+
+// ApplyToAccount validates this policy and stores it in the given account model.
+//
+// WARNING: The replication policy must be applied to the account model before
+// this, since signature requirements are not supported on replica accounts.
+func (s SignaturePolicy) ApplyToAccount(account *models.Account) *RegistryV2Error {
+	switch s.Enforcement {
+	case models.SignatureRejectUnsigned, models.SignatureFlagUnsigned:
+		// acceptable
+	default:
+		err := fmt.Errorf(`invalid enforcement for signature requirement: %q`, s.Enforcement)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+	if len(s.TrustedPublicKeys) == 0 {
+		err := errors.New(`signature requirement needs at least one trusted public key`)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+	if account.UpstreamPeerHostName != "" || account.ExternalPeerURL != "" {
+		err := errors.New(`signature requirement is only allowed on primary accounts`)
+		return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+	}
+
+	// normalize all keys into a single PEM bundle
+	var bundle []string
+	for idx, keyPEM := range s.TrustedPublicKeys {
+		keys, err := ParseSignaturePublicKeys(keyPEM)
+		if err == nil && len(keys) != 1 {
+			err = fmt.Errorf("expected exactly one PEM block, but found %d", len(keys))
+		}
+		if err != nil {
+			err = fmt.Errorf(`invalid trusted public key at index %d: %w`, idx, err)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+		der, err := x509.MarshalPKIXPublicKey(keys[0])
+		if err != nil {
+			return AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+		}
+		bundle = append(bundle, strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))))
+	}
+
+	account.RequireSignatureMode = s.Enforcement
+	account.SignaturePublicKeys = strings.Join(bundle, "\n")
+	return nil
+}
+
+// ParseSignaturePublicKeys parses a sequence of PEM-encoded public keys, as
+// stored in the Account.SignaturePublicKeys field. Only ECDSA, RSA and Ed25519
+// keys are accepted, since those are the key types supported by cosign.
+func ParseSignaturePublicKeys(in string) ([]crypto.PublicKey, error) {
+	var result []crypto.PublicKey
+	rest := []byte(strings.TrimSpace(in))
+	for len(rest) > 0 {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("not a PEM-encoded public key")
+		}
+		if block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("expected PEM block of type %q, but got %q", "PUBLIC KEY", block.Type)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+			result = append(result, key)
+		default:
+			return nil, fmt.Errorf("unsupported public key type: %T", key)
+		}
+		rest = []byte(strings.TrimSpace(string(rest)))
+	}
+	return result, nil
+}
+
+// The payload of a cosign signature, in the "simple signing" format.
+type cosignSimpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// VerifyCosignSignature checks that the given cosign signature payload refers
+// to the manifest with the given digest, and that the signature was made by
+// one of the given keys.
+func VerifyCosignSignature(manifestDigest digest.Digest, payload []byte, signatureBase64 string, keys []crypto.PublicKey) error {
+	var p cosignSimpleSigningPayload
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		return fmt.Errorf("cannot parse signature payload: %w", err)
+	}
+	if p.Critical.Type != "cosign container image signature" {
+		return fmt.Errorf("unexpected signature payload type: %q", p.Critical.Type)
+	}
+	if p.Critical.Image.DockerManifestDigest != manifestDigest {
+		return fmt.Errorf("signature payload refers to manifest %q instead of %q", p.Critical.Image.DockerManifestDigest, manifestDigest)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(signatureBase64)
+	if err != nil {
+		return fmt.Errorf("cannot decode signature: %w", err)
+	}
+	if !isSignedByAnyOf(payload, signature, keys) {
+		return errors.New("signature does not match any of the trusted public keys")
+	}
+	return nil
+}
+
+// Checks whether the given signature over the given message was made by one of
+// the given keys, using the same signature schemes as cosign.
+func isSignedByAnyOf(message, signature []byte, keys []crypto.PublicKey) bool {
+	hash := sha256.Sum256(message)
+	for _, key := range keys {
+		switch key := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, hash[:], signature) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil {
+				return true
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, message, signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
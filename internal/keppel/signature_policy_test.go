@@ -0,0 +1,196 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func mustMarshalPublicKeyPEM(t *testing.T, key any) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+}
+
+func TestCosignSignatureTagNames(t *testing.T) {
+	d := digest.Canonical.FromString("hello")
+	tagName := CosignSignatureTagName(d)
+	assert.DeepEqual(t, "tag name", tagName, "sha256-"+d.Encoded()+".sig")
+	assert.DeepEqual(t, "IsCosignArtifactTagName", IsCosignArtifactTagName(tagName), true)
+
+	parsed, ok := ParseCosignSignatureTagName(tagName)
+	assert.DeepEqual(t, "ParseCosignSignatureTagName ok", ok, true)
+	assert.DeepEqual(t, "ParseCosignSignatureTagName digest", parsed, d)
+
+	for _, name := range []string{"latest", "sha256-" + d.Encoded() + ".att", "sha256-1234.sig", "sha256-" + d.Encoded()} {
+		_, ok := ParseCosignSignatureTagName(name)
+		assert.DeepEqual(t, "ParseCosignSignatureTagName ok for "+name, ok, false)
+	}
+	assert.DeepEqual(t, "IsCosignArtifactTagName for attestation", IsCosignArtifactTagName("sha256-"+d.Encoded()+".att"), true)
+	assert.DeepEqual(t, "IsCosignArtifactTagName for regular tag", IsCosignArtifactTagName("v1.0-sig"), false)
+}
+
+func TestSignaturePolicyApplyAndRender(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	edPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ecdsaPEM := mustMarshalPublicKeyPEM(t, &ecdsaKey.PublicKey)
+	edPEM := mustMarshalPublicKeyPEM(t, edPublicKey)
+
+	// happy path: keys are normalized and can be rendered back
+	var account models.Account
+	rerr := SignaturePolicy{
+		Enforcement:       models.SignatureRejectUnsigned,
+		TrustedPublicKeys: []string{"\n" + ecdsaPEM + "\n", edPEM},
+	}.ApplyToAccount(&account)
+	if rerr != nil {
+		t.Fatal(rerr.Error())
+	}
+	assert.DeepEqual(t, "RequireSignatureMode", account.RequireSignatureMode, models.SignatureRejectUnsigned)
+	assert.DeepEqual(t, "rendered policy", *RenderSignaturePolicy(account), SignaturePolicy{
+		Enforcement:       models.SignatureRejectUnsigned,
+		TrustedPublicKeys: []string{ecdsaPEM, edPEM},
+	})
+	keys, err := ParseSignaturePublicKeys(account.SignaturePublicKeys)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.DeepEqual(t, "number of parsed keys", len(keys), 2)
+
+	// error cases
+	errorCases := []struct {
+		Policy        SignaturePolicy
+		Account       models.Account
+		ExpectedError string
+	}{
+		{
+			Policy:        SignaturePolicy{Enforcement: "warn", TrustedPublicKeys: []string{ecdsaPEM}},
+			ExpectedError: `invalid enforcement for signature requirement: "warn"`,
+		},
+		{
+			Policy:        SignaturePolicy{Enforcement: models.SignatureFlagUnsigned},
+			ExpectedError: `signature requirement needs at least one trusted public key`,
+		},
+		{
+			Policy:        SignaturePolicy{Enforcement: models.SignatureFlagUnsigned, TrustedPublicKeys: []string{"foo"}},
+			ExpectedError: `invalid trusted public key at index 0: not a PEM-encoded public key`,
+		},
+		{
+			Policy:        SignaturePolicy{Enforcement: models.SignatureFlagUnsigned, TrustedPublicKeys: []string{ecdsaPEM + "\n" + edPEM}},
+			ExpectedError: `invalid trusted public key at index 0: expected exactly one PEM block, but found 2`,
+		},
+		{
+			Policy:        SignaturePolicy{Enforcement: models.SignatureFlagUnsigned, TrustedPublicKeys: []string{ecdsaPEM}},
+			Account:       models.Account{UpstreamPeerHostName: "registry.example.org"},
+			ExpectedError: `signature requirement is only allowed on primary accounts`,
+		},
+	}
+	for _, tc := range errorCases {
+		account := tc.Account
+		rerr := tc.Policy.ApplyToAccount(&account)
+		if rerr == nil {
+			t.Errorf("expected error %q, but got none", tc.ExpectedError)
+		} else {
+			assert.DeepEqual(t, "error message", rerr.Error(), tc.ExpectedError)
+		}
+	}
+}
+
+func TestVerifyCosignSignature(t *testing.T) {
+	trustedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	untrustedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	edPublicKey, edPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keys, err := ParseSignaturePublicKeys(mustMarshalPublicKeyPEM(t, &trustedKey.PublicKey) + "\n" + mustMarshalPublicKeyPEM(t, edPublicKey))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	manifestDigest := digest.Canonical.FromString("manifest")
+	makePayload := func(d digest.Digest) []byte {
+		return []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"registry.example.org/test1/foo"},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, d))
+	}
+	signECDSA := func(key *ecdsa.PrivateKey, payload []byte) string {
+		hash := sha256.Sum256(payload)
+		signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return base64.StdEncoding.EncodeToString(signature)
+	}
+
+	// valid signatures with both key types
+	payload := makePayload(manifestDigest)
+	err = VerifyCosignSignature(manifestDigest, payload, signECDSA(trustedKey, payload), keys)
+	if err != nil {
+		t.Errorf("expected ECDSA signature to be valid, but got: %s", err.Error())
+	}
+	err = VerifyCosignSignature(manifestDigest, payload, base64.StdEncoding.EncodeToString(ed25519.Sign(edPrivateKey, payload)), keys)
+	if err != nil {
+		t.Errorf("expected Ed25519 signature to be valid, but got: %s", err.Error())
+	}
+
+	// signature from an untrusted key
+	err = VerifyCosignSignature(manifestDigest, payload, signECDSA(untrustedKey, payload), keys)
+	assert.DeepEqual(t, "error for untrusted key", err.Error(), "signature does not match any of the trusted public keys")
+
+	// signature for a different manifest
+	otherDigest := digest.Canonical.FromString("other")
+	otherPayload := makePayload(otherDigest)
+	err = VerifyCosignSignature(manifestDigest, otherPayload, signECDSA(trustedKey, otherPayload), keys)
+	assert.DeepEqual(t, "error for other manifest", err.Error(),
+		fmt.Sprintf("signature payload refers to manifest %q instead of %q", otherDigest, manifestDigest))
+
+	// payload tampered with after signing
+	signature := signECDSA(trustedKey, payload)
+	tamperedPayload := []byte(string(payload) + " ")
+	err = VerifyCosignSignature(manifestDigest, tamperedPayload, signature, keys)
+	assert.DeepEqual(t, "error for tampered payload", err.Error(), "signature does not match any of the trusted public keys")
+}
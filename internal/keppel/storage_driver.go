@@ -66,6 +66,11 @@ type StorageDriver interface {
 	AbortBlobUpload(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error
 
 	ReadBlob(ctx context.Context, account models.ReducedAccount, storageID string) (contents io.ReadCloser, sizeBytes uint64, err error)
+	// ReadBlobRange is like ReadBlob, but only reads `length` bytes starting at
+	// the byte offset `offset`. This is used to serve HTTP range requests. The
+	// caller guarantees that `length` is not zero, and that the range lies
+	// within the blob.
+	ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (contents io.ReadCloser, err error)
 	// If the blob can be retrieved by a publicly accessible URL, URLForBlob shall
 	// return it. Otherwise ErrCannotGenerateURL shall be returned to instruct the
 	// caller fall back to ReadBlob().
@@ -79,13 +84,20 @@ type StorageDriver interface {
 	WriteManifest(ctx context.Context, account models.ReducedAccount, repoName string, digest digest.Digest, contents []byte) error
 	DeleteManifest(ctx context.Context, account models.ReducedAccount, repoName string, digest digest.Digest) error
 
+	// This method enumerates all blobs and manifests in the storage. To avoid
+	// holding the entire listing in memory for very large accounts, results are
+	// reported in batches of at most StorageListingBatchSize entries through the
+	// given callbacks (see type StorageListingBatcher). Each blob and manifest
+	// shall be reported exactly once. If a callback returns an error, the
+	// listing shall be aborted and that error shall be returned.
+	//
 	// This method shall only be used as a positive signal for the existence of a
 	// blob or manifest in the storage, not as a negative signal: If we expect a
-	// blob or manifest to be in the storage, but it does not show up in these
-	// lists, that does not necessarily mean it does not exist in the storage.
+	// blob or manifest to be in the storage, but it does not show up in this
+	// listing, that does not necessarily mean it does not exist in the storage.
 	// This is because storage implementations may be backed by object stores with
 	// eventual consistency.
-	ListStorageContents(ctx context.Context, account models.ReducedAccount) (blobs []StoredBlobInfo, manifests []StoredManifestInfo, err error)
+	ListStorageContents(ctx context.Context, account models.ReducedAccount, handleBlobs func([]StoredBlobInfo) error, handleManifests func([]StoredManifestInfo) error) error
 
 	// This method is called before a new account is set up in the DB. The
 	// StorageDriver can use this opportunity to check for any reasons why the
@@ -98,6 +110,32 @@ type StorageDriver interface {
 	CleanupAccount(ctx context.Context, account models.ReducedAccount) error
 }
 
+// BlobCopyingStorageDriver is an optional interface that a StorageDriver can
+// implement if it can copy blobs on the server side from a storage location
+// that belongs to a peer. This is used when replicating blobs from a peer that
+// uses the same storage backend as we do (e.g. the same Swift cluster), so
+// that blob contents do not need to be streamed through keppel-api.
+type BlobCopyingStorageDriver interface {
+	// BlobLocation returns an opaque string identifying the given finalized blob
+	// within the storage backend. The peer will give this string to
+	// CopyBlobFromLocation() on its own storage driver.
+	BlobLocation(ctx context.Context, account models.ReducedAccount, storageID string) (string, error)
+	// CopyBlobFromLocation creates a finalized blob with the given storage ID by
+	// copying the contents of the blob at the given location, which was
+	// obtained from BlobLocation() on a peer. The copy must not depend on the
+	// source blob continuing to exist afterwards. If the location cannot be
+	// accessed by this driver, ErrCannotCopyBlob shall be returned to instruct
+	// the caller to fall back to downloading the blob from the peer.
+	CopyBlobFromLocation(ctx context.Context, account models.ReducedAccount, storageID, location string) (sizeBytes uint64, err error)
+}
+
+// AsBlobCopyingStorageDriver returns the given StorageDriver as a
+// BlobCopyingStorageDriver if it implements that interface.
+func AsBlobCopyingStorageDriver(sd StorageDriver) (BlobCopyingStorageDriver, bool) {
+	bcsd, ok := UnwrapStorageDriver(sd).(BlobCopyingStorageDriver)
+	return bcsd, ok
+}
+
 // StoredBlobInfo is returned by StorageDriver.ListStorageContents().
 type StoredBlobInfo struct {
 	StorageID string
@@ -112,6 +150,38 @@ type StoredManifestInfo struct {
 	Digest   digest.Digest
 }
 
+// StorageListingBatchSize is the maximum number of entries that
+// StorageDriver.ListStorageContents() reports to its callbacks at once.
+const StorageListingBatchSize = 1000
+
+// StorageListingBatcher collects entries of type StoredBlobInfo or
+// StoredManifestInfo during StorageDriver.ListStorageContents(), and reports
+// them to the respective callback in batches of StorageListingBatchSize.
+type StorageListingBatcher[T StoredBlobInfo | StoredManifestInfo] struct {
+	Callback func([]T) error
+	entries  []T
+}
+
+// Add records an entry, and reports a full batch to the callback if necessary.
+func (b *StorageListingBatcher[T]) Add(entry T) error {
+	b.entries = append(b.entries, entry)
+	if len(b.entries) < StorageListingBatchSize {
+		return nil
+	}
+	return b.Flush()
+}
+
+// Flush reports all remaining entries to the callback. It must be called
+// once after the last call to Add().
+func (b *StorageListingBatcher[T]) Flush() error {
+	if len(b.entries) == 0 {
+		return nil
+	}
+	err := b.Callback(b.entries)
+	b.entries = nil
+	return err
+}
+
 // ErrAuthDriverMismatch is returned by Init() methods on most driver
 // interfaces, to indicate that the driver in question does not work with the
 // selected AuthDriver.
@@ -121,11 +191,17 @@ var ErrAuthDriverMismatch = errors.New("given AuthDriver is not supported by thi
 // StorageDriver does not support blob URLs.
 var ErrCannotGenerateURL = errors.New("URLForBlob() is not supported")
 
+// ErrCannotCopyBlob is returned by
+// BlobCopyingStorageDriver.CopyBlobFromLocation() when the given location is
+// not accessible to this driver.
+var ErrCannotCopyBlob = errors.New("CopyBlobFromLocation() is not supported for this location")
+
 // StorageDriverRegistry is a pluggable.Registry for StorageDriver implementations.
 var StorageDriverRegistry pluggable.Registry[StorageDriver]
 
 // NewStorageDriver creates a new StorageDriver using one of the factory functions
-// registered with RegisterStorageDriver().
+// registered with RegisterStorageDriver(). The result is wrapped to record
+// Prometheus metrics about all storage operations.
 func NewStorageDriver(pluginTypeID string, ad AuthDriver, cfg Configuration) (StorageDriver, error) {
 	logg.Debug("initializing storage driver %q...", pluginTypeID)
 
@@ -133,7 +209,7 @@ func NewStorageDriver(pluginTypeID string, ad AuthDriver, cfg Configuration) (St
 	if sd == nil {
 		return nil, errors.New("no such storage driver: " + pluginTypeID)
 	}
-	return sd, sd.Init(ad, cfg)
+	return instrumentedStorageDriver{sd}, sd.Init(ad, cfg)
 }
 
 // GenerateStorageID generates a new random storage ID for use with
@@ -0,0 +1,55 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// GlobalBlobStoreAccountName is the name of the pseudo-account in whose
+// storage location blob contents are stored once they have been moved into
+// the global blob store (see Configuration.GlobalBlobStore). This is not a
+// valid account name, so it cannot collide with any actual account.
+const GlobalBlobStoreAccountName models.AccountName = "_global-blobs"
+
+// BlobStorageLocation returns the account in whose storage location the
+// contents of the given blob are stored. This is the account that the blob
+// belongs to, unless the blob has been moved into the global blob store by
+// tasks.GlobalBlobDeduplicationJob.
+//
+// Since storage IDs are chosen randomly for each upload, blobs from different
+// accounts do not collide in the global blob store.
+func (cfg Configuration) BlobStorageLocation(account models.ReducedAccount, blob models.Blob) models.ReducedAccount {
+	if blob.IsGlobal {
+		return cfg.GlobalBlobStoreLocation()
+	}
+	return account
+}
+
+// GlobalBlobStoreLocation returns the pseudo-account in whose storage location
+// the global blob store keeps its blob contents.
+func (cfg Configuration) GlobalBlobStoreLocation() models.ReducedAccount {
+	if cfg.GlobalBlobStore == nil {
+		// should not happen: once blobs have been moved into the global blob
+		// store, it must stay configured; but we can at least give the storage
+		// driver the right location name
+		return models.ReducedAccount{Name: GlobalBlobStoreAccountName}
+	}
+	return *cfg.GlobalBlobStore
+}
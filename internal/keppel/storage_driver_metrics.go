@@ -0,0 +1,301 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// StorageErrorClass is an enum that classifies errors returned by
+// StorageDriver methods. It appears in the "result" label of the storage
+// operation metrics.
+type StorageErrorClass string
+
+const (
+	// StorageErrorTimeout is for errors caused by timeouts.
+	StorageErrorTimeout StorageErrorClass = "timeout"
+	// StorageErrorNotFound is for errors caused by nonexistent objects.
+	StorageErrorNotFound StorageErrorClass = "not_found"
+	// StorageErrorAuthFailure is for errors caused by failed authentication or authorization.
+	StorageErrorAuthFailure StorageErrorClass = "auth_failure"
+	// StorageErrorOther is for all other errors.
+	StorageErrorOther StorageErrorClass = "error"
+)
+
+// StorageErrorClassifier is an optional interface for StorageDriver
+// implementations. If implemented, it is used to classify errors that are
+// returned by the StorageDriver's methods. Implementations may return an empty
+// string to fall back to the default classification.
+type StorageErrorClassifier interface {
+	ClassifyError(err error) StorageErrorClass
+}
+
+var (
+	storageOperationDurationHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "keppel_storage_operation_duration_seconds",
+			Help:    "Duration of operations on the storage backend.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 4, 8),
+		},
+		[]string{"driver", "operation"},
+	)
+	storageOperationsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_storage_operations",
+			Help: "Counts operations on the storage backend, labeled by result (either \"success\" or an error classification).",
+		},
+		[]string{"driver", "operation", "account", "result"},
+	)
+	storageBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_storage_transferred_bytes",
+			Help: "Counts bytes that are read from or written into the storage backend.",
+		},
+		[]string{"driver", "operation", "account"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(storageOperationDurationHistogram)
+	prometheus.MustRegister(storageOperationsCounter)
+	prometheus.MustRegister(storageBytesCounter)
+}
+
+// instrumentedStorageDriver wraps a StorageDriver to record metrics about all
+// operations on it. NewStorageDriver() applies this wrapper to all drivers.
+type instrumentedStorageDriver struct {
+	StorageDriver
+}
+
+// UnwrapStorageDriver returns the actual StorageDriver implementation behind
+// the metrics wrapper that NewStorageDriver() puts around it (and the
+// throttling wrapper from ThrottleStorageDriver(), if any). This is used in
+// tests to access the test double behind the StorageDriver interface.
+func UnwrapStorageDriver(sd StorageDriver) StorageDriver {
+	if tsd, ok := sd.(throttledStorageDriver); ok {
+		sd = tsd.StorageDriver
+	}
+	if isd, ok := sd.(instrumentedStorageDriver); ok {
+		return isd.StorageDriver
+	}
+	return sd
+}
+
+// Starts timing an operation and opens a tracing span for it. The returned
+// function records the metrics for the operation and ends the span once it is
+// done. This is usually called as `ctx, record := d.observe(ctx, ...)`
+// followed by `defer record(&err)` to catch the final value of the named error
+// return value.
+func (d instrumentedStorageDriver) observe(ctx context.Context, operation string, account models.ReducedAccount) (context.Context, func(*error)) {
+	startedAt := time.Now()
+	driver := d.PluginTypeID()
+	ctx, endSpan := StartSpan(ctx, "storage."+operation,
+		attribute.String("keppel.storage.driver", driver),
+		attribute.String("keppel.account", string(account.Name)),
+	)
+	return ctx, func(errPtr *error) {
+		if errors.Is(*errPtr, ErrCannotGenerateURL) {
+			// not supporting URLs is not an error that needs to be counted
+			endSpan(nil)
+			return
+		}
+		endSpan(errPtr)
+		storageOperationDurationHistogram.WithLabelValues(driver, operation).Observe(time.Since(startedAt).Seconds())
+		result := "success"
+		if *errPtr != nil {
+			result = string(d.classifyError(*errPtr))
+		}
+		storageOperationsCounter.WithLabelValues(driver, operation, string(account.Name), result).Inc()
+	}
+}
+
+func (d instrumentedStorageDriver) countBytes(operation string, account models.ReducedAccount, count int) {
+	storageBytesCounter.WithLabelValues(d.PluginTypeID(), operation, string(account.Name)).Add(float64(count))
+}
+
+func (d instrumentedStorageDriver) classifyError(err error) StorageErrorClass {
+	if classifier, ok := d.StorageDriver.(StorageErrorClassifier); ok {
+		class := classifier.ClassifyError(err)
+		if class != "" {
+			return class
+		}
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return StorageErrorTimeout
+	case errors.Is(err, fs.ErrNotExist):
+		return StorageErrorNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return StorageErrorAuthFailure
+	default:
+		return StorageErrorOther
+	}
+}
+
+// AppendToBlob implements the StorageDriver interface.
+func (d instrumentedStorageDriver) AppendToBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkNumber uint32, chunkLength *uint64, chunk io.Reader) (err error) {
+	ctx, record := d.observe(ctx, "AppendToBlob", account)
+	defer record(&err)
+	reader := &countingReader{Reader: chunk}
+	defer func() { d.countBytes("AppendToBlob", account, reader.BytesRead) }()
+	return d.StorageDriver.AppendToBlob(ctx, account, storageID, chunkNumber, chunkLength, reader)
+}
+
+// FinalizeBlob implements the StorageDriver interface.
+func (d instrumentedStorageDriver) FinalizeBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) (err error) {
+	ctx, record := d.observe(ctx, "FinalizeBlob", account)
+	defer record(&err)
+	return d.StorageDriver.FinalizeBlob(ctx, account, storageID, chunkCount)
+}
+
+// AbortBlobUpload implements the StorageDriver interface.
+func (d instrumentedStorageDriver) AbortBlobUpload(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) (err error) {
+	ctx, record := d.observe(ctx, "AbortBlobUpload", account)
+	defer record(&err)
+	return d.StorageDriver.AbortBlobUpload(ctx, account, storageID, chunkCount)
+}
+
+// ReadBlob implements the StorageDriver interface.
+func (d instrumentedStorageDriver) ReadBlob(ctx context.Context, account models.ReducedAccount, storageID string) (contents io.ReadCloser, sizeBytes uint64, err error) {
+	ctx, record := d.observe(ctx, "ReadBlob", account)
+	defer record(&err)
+	contents, sizeBytes, err = d.StorageDriver.ReadBlob(ctx, account, storageID)
+	if err != nil {
+		return nil, 0, err
+	}
+	// the bytes are counted once the caller is done reading
+	reader := &countingReader{Reader: contents}
+	return countingReadCloser{reader, func() error {
+		d.countBytes("ReadBlob", account, reader.BytesRead)
+		return contents.Close()
+	}}, sizeBytes, nil
+}
+
+// ReadBlobRange implements the StorageDriver interface.
+func (d instrumentedStorageDriver) ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (contents io.ReadCloser, err error) {
+	ctx, record := d.observe(ctx, "ReadBlobRange", account)
+	defer record(&err)
+	contents, err = d.StorageDriver.ReadBlobRange(ctx, account, storageID, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	// the bytes are counted once the caller is done reading
+	reader := &countingReader{Reader: contents}
+	return countingReadCloser{reader, func() error {
+		d.countBytes("ReadBlobRange", account, reader.BytesRead)
+		return contents.Close()
+	}}, nil
+}
+
+// URLForBlob implements the StorageDriver interface.
+func (d instrumentedStorageDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (url string, err error) {
+	ctx, record := d.observe(ctx, "URLForBlob", account)
+	defer record(&err)
+	return d.StorageDriver.URLForBlob(ctx, account, storageID)
+}
+
+// DeleteBlob implements the StorageDriver interface.
+func (d instrumentedStorageDriver) DeleteBlob(ctx context.Context, account models.ReducedAccount, storageID string) (err error) {
+	ctx, record := d.observe(ctx, "DeleteBlob", account)
+	defer record(&err)
+	return d.StorageDriver.DeleteBlob(ctx, account, storageID)
+}
+
+// ReadManifest implements the StorageDriver interface.
+func (d instrumentedStorageDriver) ReadManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) (contents []byte, err error) {
+	ctx, record := d.observe(ctx, "ReadManifest", account)
+	defer record(&err)
+	contents, err = d.StorageDriver.ReadManifest(ctx, account, repoName, manifestDigest)
+	if err == nil {
+		d.countBytes("ReadManifest", account, len(contents))
+	}
+	return contents, err
+}
+
+// WriteManifest implements the StorageDriver interface.
+func (d instrumentedStorageDriver) WriteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest, contents []byte) (err error) {
+	ctx, record := d.observe(ctx, "WriteManifest", account)
+	defer record(&err)
+	err = d.StorageDriver.WriteManifest(ctx, account, repoName, manifestDigest, contents)
+	if err == nil {
+		d.countBytes("WriteManifest", account, len(contents))
+	}
+	return err
+}
+
+// DeleteManifest implements the StorageDriver interface.
+func (d instrumentedStorageDriver) DeleteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) (err error) {
+	ctx, record := d.observe(ctx, "DeleteManifest", account)
+	defer record(&err)
+	return d.StorageDriver.DeleteManifest(ctx, account, repoName, manifestDigest)
+}
+
+// ListStorageContents implements the StorageDriver interface.
+func (d instrumentedStorageDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount, handleBlobs func([]StoredBlobInfo) error, handleManifests func([]StoredManifestInfo) error) (err error) {
+	ctx, record := d.observe(ctx, "ListStorageContents", account)
+	defer record(&err)
+	return d.StorageDriver.ListStorageContents(ctx, account, handleBlobs, handleManifests)
+}
+
+// CanSetupAccount implements the StorageDriver interface.
+func (d instrumentedStorageDriver) CanSetupAccount(ctx context.Context, account models.ReducedAccount) (err error) {
+	ctx, record := d.observe(ctx, "CanSetupAccount", account)
+	defer record(&err)
+	return d.StorageDriver.CanSetupAccount(ctx, account)
+}
+
+// CleanupAccount implements the StorageDriver interface.
+func (d instrumentedStorageDriver) CleanupAccount(ctx context.Context, account models.ReducedAccount) (err error) {
+	ctx, record := d.observe(ctx, "CleanupAccount", account)
+	defer record(&err)
+	return d.StorageDriver.CleanupAccount(ctx, account)
+}
+
+type countingReader struct {
+	io.Reader
+	BytesRead int
+}
+
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.Reader.Read(buf)
+	r.BytesRead += n
+	return n, err
+}
+
+type countingReadCloser struct {
+	*countingReader
+	close func() error
+}
+
+func (r countingReadCloser) Close() error {
+	return r.close()
+}
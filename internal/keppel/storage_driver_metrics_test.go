@@ -0,0 +1,63 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+var errTestForbidden = errors.New("forbidden")
+
+// A StorageDriver that only implements the StorageErrorClassifier interface.
+type classifyingStorageDriver struct {
+	StorageDriver
+}
+
+func (classifyingStorageDriver) ClassifyError(err error) StorageErrorClass {
+	if errors.Is(err, errTestForbidden) {
+		return StorageErrorAuthFailure
+	}
+	return ""
+}
+
+func TestStorageErrorClassification(t *testing.T) {
+	testCases := []struct {
+		Input    error
+		Expected StorageErrorClass
+	}{
+		{fmt.Errorf("while uploading: %w", context.DeadlineExceeded), StorageErrorTimeout},
+		{fmt.Errorf("cannot open file: %w", fs.ErrNotExist), StorageErrorNotFound},
+		{fmt.Errorf("cannot open file: %w", fs.ErrPermission), StorageErrorAuthFailure},
+		{fmt.Errorf("while downloading: %w", errTestForbidden), StorageErrorAuthFailure},
+		{errors.New("something else"), StorageErrorOther},
+	}
+
+	sd := instrumentedStorageDriver{classifyingStorageDriver{}}
+	for _, tc := range testCases {
+		actual := sd.classifyError(tc.Input)
+		if actual != tc.Expected {
+			t.Errorf("expected %q to be classified as %q, but got %q", tc.Input.Error(), tc.Expected, actual)
+		}
+	}
+}
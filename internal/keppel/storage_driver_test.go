@@ -0,0 +1,83 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStorageListingBatcher(t *testing.T) {
+	var batchSizes []int
+	var seen []string
+	b := StorageListingBatcher[StoredBlobInfo]{Callback: func(blobs []StoredBlobInfo) error {
+		batchSizes = append(batchSizes, len(blobs))
+		for _, blob := range blobs {
+			seen = append(seen, blob.StorageID)
+		}
+		return nil
+	}}
+
+	// entries are reported in full batches, and the rest is reported on Flush()
+	count := 2*StorageListingBatchSize + 5
+	for idx := range count {
+		err := b.Add(StoredBlobInfo{StorageID: fmt.Sprintf("blob%d", idx)})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if len(batchSizes) != 2 {
+		t.Errorf("expected 2 batches before Flush(), but got %d", len(batchSizes))
+	}
+	err := b.Flush()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectedSizes := []int{StorageListingBatchSize, StorageListingBatchSize, 5}
+	if fmt.Sprint(batchSizes) != fmt.Sprint(expectedSizes) {
+		t.Errorf("expected batch sizes %v, but got %v", expectedSizes, batchSizes)
+	}
+	for idx, storageID := range seen {
+		if storageID != fmt.Sprintf("blob%d", idx) {
+			t.Fatalf("expected entries to be reported in order, but entry %d is %q", idx, storageID)
+		}
+	}
+
+	// an empty Flush() does not invoke the callback
+	err = b.Flush()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(batchSizes) != 3 {
+		t.Errorf("expected no callback on empty Flush(), but got %d batches", len(batchSizes))
+	}
+
+	// errors from the callback are passed through
+	errExpected := errors.New("callback failed")
+	b = StorageListingBatcher[StoredBlobInfo]{Callback: func([]StoredBlobInfo) error { return errExpected }}
+	err = b.Add(StoredBlobInfo{StorageID: "foo"})
+	if err != nil {
+		t.Fatalf("expected no error before the batch is full, but got %s", err.Error())
+	}
+	err = b.Flush()
+	if !errors.Is(err, errExpected) {
+		t.Errorf("expected callback error from Flush(), but got %v", err)
+	}
+}
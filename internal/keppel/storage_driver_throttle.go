@@ -0,0 +1,233 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var (
+	storageThrottleSecondsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_storage_throttle_seconds",
+			Help: "Total time that operations on the storage backend were delayed by the rate limit from KEPPEL_JANITOR_STORAGE_OPS_PER_SECOND.",
+		},
+		[]string{"driver", "operation"},
+	)
+	storageThrottledOperationsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_storage_throttled_operations",
+			Help: "Counts operations on the storage backend that were delayed by the rate limit from KEPPEL_JANITOR_STORAGE_OPS_PER_SECOND.",
+		},
+		[]string{"driver", "operation"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(storageThrottleSecondsCounter)
+	prometheus.MustRegister(storageThrottledOperationsCounter)
+}
+
+// ThrottleStorageDriver wraps the given StorageDriver such that all operations
+// on it are limited to the given rate in total. This is used by the janitor to
+// avoid saturating the storage backend with its background jobs, since all of
+// them share the same StorageDriver instance.
+//
+// If `opsPerSecond` is not positive, the StorageDriver is returned unchanged.
+func ThrottleStorageDriver(sd StorageDriver, opsPerSecond float64) StorageDriver {
+	if opsPerSecond <= 0 {
+		return sd
+	}
+	return throttledStorageDriver{sd, &storageOpsLimiter{
+		interval: time.Duration(float64(time.Second) / opsPerSecond),
+	}}
+}
+
+// storageOpsLimiter spaces out operations such that they occur at most once
+// every `interval`. Operations that arrive while the limiter is busy are
+// scheduled in the order of arrival.
+type storageOpsLimiter struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	next     time.Time
+}
+
+// Wait blocks until the next operation may be performed, and returns how long
+// it was blocked. If the context expires before that, its error is returned.
+func (l *storageOpsLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mutex.Unlock()
+
+	if delay <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		return delay, ctx.Err()
+	}
+}
+
+// throttledStorageDriver wraps a StorageDriver to apply a storageOpsLimiter to
+// all operations on it. ThrottleStorageDriver() applies this wrapper.
+type throttledStorageDriver struct {
+	StorageDriver
+	limiter *storageOpsLimiter
+}
+
+func (d throttledStorageDriver) wait(ctx context.Context, operation string) error {
+	delay, err := d.limiter.Wait(ctx)
+	if delay > 0 {
+		driver := d.PluginTypeID()
+		storageThrottleSecondsCounter.WithLabelValues(driver, operation).Add(delay.Seconds())
+		storageThrottledOperationsCounter.WithLabelValues(driver, operation).Inc()
+	}
+	return err
+}
+
+// AppendToBlob implements the StorageDriver interface.
+func (d throttledStorageDriver) AppendToBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkNumber uint32, chunkLength *uint64, chunk io.Reader) error {
+	err := d.wait(ctx, "AppendToBlob")
+	if err != nil {
+		return err
+	}
+	return d.StorageDriver.AppendToBlob(ctx, account, storageID, chunkNumber, chunkLength, chunk)
+}
+
+// FinalizeBlob implements the StorageDriver interface.
+func (d throttledStorageDriver) FinalizeBlob(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error {
+	err := d.wait(ctx, "FinalizeBlob")
+	if err != nil {
+		return err
+	}
+	return d.StorageDriver.FinalizeBlob(ctx, account, storageID, chunkCount)
+}
+
+// AbortBlobUpload implements the StorageDriver interface.
+func (d throttledStorageDriver) AbortBlobUpload(ctx context.Context, account models.ReducedAccount, storageID string, chunkCount uint32) error {
+	err := d.wait(ctx, "AbortBlobUpload")
+	if err != nil {
+		return err
+	}
+	return d.StorageDriver.AbortBlobUpload(ctx, account, storageID, chunkCount)
+}
+
+// ReadBlob implements the StorageDriver interface.
+func (d throttledStorageDriver) ReadBlob(ctx context.Context, account models.ReducedAccount, storageID string) (io.ReadCloser, uint64, error) {
+	err := d.wait(ctx, "ReadBlob")
+	if err != nil {
+		return nil, 0, err
+	}
+	return d.StorageDriver.ReadBlob(ctx, account, storageID)
+}
+
+// ReadBlobRange implements the StorageDriver interface.
+func (d throttledStorageDriver) ReadBlobRange(ctx context.Context, account models.ReducedAccount, storageID string, offset, length uint64) (io.ReadCloser, error) {
+	err := d.wait(ctx, "ReadBlobRange")
+	if err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.ReadBlobRange(ctx, account, storageID, offset, length)
+}
+
+// URLForBlob implements the StorageDriver interface.
+func (d throttledStorageDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, storageID string) (string, error) {
+	// not throttled: this usually does not involve a request to the storage backend
+	return d.StorageDriver.URLForBlob(ctx, account, storageID)
+}
+
+// DeleteBlob implements the StorageDriver interface.
+func (d throttledStorageDriver) DeleteBlob(ctx context.Context, account models.ReducedAccount, storageID string) error {
+	err := d.wait(ctx, "DeleteBlob")
+	if err != nil {
+		return err
+	}
+	return d.StorageDriver.DeleteBlob(ctx, account, storageID)
+}
+
+// ReadManifest implements the StorageDriver interface.
+func (d throttledStorageDriver) ReadManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) ([]byte, error) {
+	err := d.wait(ctx, "ReadManifest")
+	if err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.ReadManifest(ctx, account, repoName, manifestDigest)
+}
+
+// WriteManifest implements the StorageDriver interface.
+func (d throttledStorageDriver) WriteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest, contents []byte) error {
+	err := d.wait(ctx, "WriteManifest")
+	if err != nil {
+		return err
+	}
+	return d.StorageDriver.WriteManifest(ctx, account, repoName, manifestDigest, contents)
+}
+
+// DeleteManifest implements the StorageDriver interface.
+func (d throttledStorageDriver) DeleteManifest(ctx context.Context, account models.ReducedAccount, repoName string, manifestDigest digest.Digest) error {
+	err := d.wait(ctx, "DeleteManifest")
+	if err != nil {
+		return err
+	}
+	return d.StorageDriver.DeleteManifest(ctx, account, repoName, manifestDigest)
+}
+
+// ListStorageContents implements the StorageDriver interface.
+func (d throttledStorageDriver) ListStorageContents(ctx context.Context, account models.ReducedAccount, handleBlobs func([]StoredBlobInfo) error, handleManifests func([]StoredManifestInfo) error) error {
+	err := d.wait(ctx, "ListStorageContents")
+	if err != nil {
+		return err
+	}
+	return d.StorageDriver.ListStorageContents(ctx, account, handleBlobs, handleManifests)
+}
+
+// CanSetupAccount implements the StorageDriver interface.
+func (d throttledStorageDriver) CanSetupAccount(ctx context.Context, account models.ReducedAccount) error {
+	err := d.wait(ctx, "CanSetupAccount")
+	if err != nil {
+		return err
+	}
+	return d.StorageDriver.CanSetupAccount(ctx, account)
+}
+
+// CleanupAccount implements the StorageDriver interface.
+func (d throttledStorageDriver) CleanupAccount(ctx context.Context, account models.ReducedAccount) error {
+	err := d.wait(ctx, "CleanupAccount")
+	if err != nil {
+		return err
+	}
+	return d.StorageDriver.CleanupAccount(ctx, account)
+}
@@ -0,0 +1,78 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// A StorageDriver that only implements DeleteBlob (as a no-op).
+type noopStorageDriver struct {
+	StorageDriver
+}
+
+func (noopStorageDriver) PluginTypeID() string { return "noop" }
+
+func (noopStorageDriver) DeleteBlob(ctx context.Context, account models.ReducedAccount, storageID string) error {
+	return nil
+}
+
+func TestThrottleStorageDriver(t *testing.T) {
+	ctx := context.Background()
+
+	// without a rate limit, the StorageDriver is not wrapped at all
+	var sd StorageDriver = noopStorageDriver{}
+	if ThrottleStorageDriver(sd, 0) != sd {
+		t.Error("expected ThrottleStorageDriver() to be a no-op for opsPerSecond = 0")
+	}
+
+	// with a rate of 50 ops/sec, 5 operations take at least 80 ms (the first one is not delayed)
+	tsd := ThrottleStorageDriver(sd, 50)
+	if UnwrapStorageDriver(tsd) != sd {
+		t.Error("expected UnwrapStorageDriver() to remove the throttling wrapper")
+	}
+	startedAt := time.Now()
+	for range 5 {
+		err := tsd.DeleteBlob(ctx, models.ReducedAccount{}, "foo")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if elapsed := time.Since(startedAt); elapsed < 80*time.Millisecond {
+		t.Errorf("expected 5 throttled operations to take at least 80ms, but took only %s", elapsed)
+	}
+
+	// when the context expires while waiting, the operation is not performed
+	tsd = ThrottleStorageDriver(sd, 0.1)
+	err := tsd.DeleteBlob(ctx, models.ReducedAccount{}, "foo")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err = tsd.DeleteBlob(shortCtx, models.ReducedAccount{}, "foo")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded error, but got %v", err)
+	}
+}
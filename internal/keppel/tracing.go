@@ -0,0 +1,163 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/XSAM/otelsql"
+	"github.com/sapcc/go-api-declarations/bininfo"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/osext"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer is used for all spans that Keppel creates itself. Until InitTracing()
+// has installed a tracer provider, all spans are discarded.
+var Tracer = otel.Tracer("github.com/sapcc/keppel")
+
+// Name of the database/sql driver that wraps the Postgres driver with tracing
+// instrumentation. Empty if tracing is not enabled.
+var tracedPostgresDriverName string
+
+// IsTracingEnabled returns whether tracing has been enabled through the
+// standard OTel environment variables.
+func IsTracingEnabled() bool {
+	if osext.GetenvBool("OTEL_SDK_DISABLED") {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// InitTracing sets up the OpenTelemetry tracer provider with an OTLP exporter
+// if an OTLP endpoint has been configured through the standard environment
+// variables (OTEL_EXPORTER_OTLP_ENDPOINT etc.). Otherwise, this does nothing.
+//
+// This must be called before SetupHTTPClient() and before connecting to the
+// database, so that outgoing HTTP requests and DB queries are instrumented.
+// The returned function flushes all pending spans and must be called on shutdown.
+func InitTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	if !IsTracingEnabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES are read last, so that they
+	// can override our defaults
+	resource, err := sdkresource.New(ctx,
+		sdkresource.WithTelemetrySDK(),
+		sdkresource.WithAttributes(
+			attribute.String("service.name", bininfo.Component()),
+			attribute.String("service.version", bininfo.VersionOr("rolling")),
+		),
+		sdkresource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	// instrument DB queries; queries that are not executed within a traced
+	// operation (i.e. without a parent span) are not traced to avoid noise
+	tracedPostgresDriverName, err = otelsql.Register("postgres",
+		otelsql.WithAttributes(attribute.String("db.system", "postgresql")),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	logg.Info("tracing enabled: exporting spans via OTLP")
+	return tp.Shutdown, nil
+}
+
+// PostgresDriverName returns the name of the database/sql driver that shall be
+// used for connecting to Postgres. If tracing is enabled, this is a driver
+// with tracing instrumentation.
+func PostgresDriverName() string {
+	if tracedPostgresDriverName == "" {
+		return "postgres"
+	}
+	return tracedPostgresDriverName
+}
+
+// Wraps an HTTP transport such that outgoing requests are recorded as spans
+// and carry the trace context to the server (e.g. to an upstream peer during
+// replication). If tracing is not enabled, the transport is returned unchanged.
+func wrapTransportForTracing(transport http.RoundTripper) http.RoundTripper {
+	if !IsTracingEnabled() {
+		return transport
+	}
+	return otelhttp.NewTransport(transport)
+}
+
+// WrapHandlerForTracing wraps an HTTP handler such that each incoming request
+// is recorded as a span. If tracing is not enabled, the handler is returned
+// unchanged.
+func WrapHandlerForTracing(handler http.Handler) http.Handler {
+	if !IsTracingEnabled() {
+		return handler
+	}
+	return otelhttp.NewHandler(handler, "keppel-api",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
+
+// StartSpan starts a span as a child of whatever span is contained in the
+// given context. The returned function ends the span, and should be called as
+// `defer endSpan(&err)` to record the final value of the named error return
+// value on the span.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(*error)) {
+	ctx, span := Tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, func(errPtr *error) {
+		if errPtr != nil && *errPtr != nil && !errors.Is(*errPtr, context.Canceled) {
+			span.RecordError(*errPtr)
+			span.SetStatus(codes.Error, (*errPtr).Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,82 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// A StorageDriver that checks that it is called within a span.
+type tracingTestStorageDriver struct {
+	StorageDriver
+	t *testing.T
+}
+
+func (tracingTestStorageDriver) PluginTypeID() string { return "test" }
+
+func (d tracingTestStorageDriver) ReadManifest(ctx context.Context, _ models.ReducedAccount, _ string, _ digest.Digest) ([]byte, error) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		d.t.Error("expected ReadManifest to be called within a span")
+	}
+	return nil, fs.ErrNotExist
+}
+
+func (tracingTestStorageDriver) URLForBlob(_ context.Context, _ models.ReducedAccount, _ string) (string, error) {
+	return "", ErrCannotGenerateURL
+}
+
+func TestStorageDriverTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	origTracer := Tracer
+	Tracer = tp.Tracer("test")
+	defer func() { Tracer = origTracer }()
+
+	sd := instrumentedStorageDriver{tracingTestStorageDriver{t: t}}
+	account := models.ReducedAccount{Name: "test1"}
+	ctx, parentSpan := Tracer.Start(context.Background(), "parent")
+	_, err := sd.ReadManifest(ctx, account, "foo", digest.Canonical.FromString("foo"))
+	assert.DeepEqual(t, "error from ReadManifest", err, fs.ErrNotExist)
+	_, err = sd.URLForBlob(ctx, account, "abc")
+	assert.DeepEqual(t, "error from URLForBlob", err, ErrCannotGenerateURL)
+	parentSpan.End()
+
+	spans := recorder.Ended()
+	assert.DeepEqual(t, "number of spans", len(spans), 3)
+
+	// failed operations are recorded as errors
+	assert.DeepEqual(t, "name of span", spans[0].Name(), "storage.ReadManifest")
+	assert.DeepEqual(t, "parent of span", spans[0].Parent().SpanID(), parentSpan.SpanContext().SpanID())
+	assert.DeepEqual(t, "status of span", spans[0].Status().Code, codes.Error)
+
+	// not supporting URLs is not an error
+	assert.DeepEqual(t, "name of span", spans[1].Name(), "storage.URLForBlob")
+	assert.DeepEqual(t, "status of span", spans[1].Status().Code, codes.Unset)
+}
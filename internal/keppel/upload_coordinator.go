@@ -0,0 +1,115 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sapcc/go-bits/logg"
+)
+
+// ErrUploadLocked is returned by UploadCoordinator.LockUpload() when a
+// different request is already modifying the same upload.
+var ErrUploadLocked = errors.New("upload is being modified by a concurrent request")
+
+// UploadCoordinator serializes requests that modify the same blob upload, even
+// if these requests are handled by different keppel-api instances. Without
+// this, concurrent PATCH requests for the same upload could both append a
+// chunk with the same chunk number to the storage.
+//
+// A nil *UploadCoordinator is valid and does not perform any locking. This is
+// the case when Redis is not configured.
+type UploadCoordinator struct {
+	Client *redis.Client
+}
+
+// How long an upload lock lives without being refreshed. This is kept short so
+// that the lock is released soon when the keppel-api holding it dies. While the
+// lock is held, it is refreshed in regular intervals.
+const uploadLockTTL = 30 * time.Second
+
+var (
+	// KEYS[1] = lock key, ARGV[1] = lock token, ARGV[2] = TTL in milliseconds
+	refreshUploadLockScript = redis.NewScript(`
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+		end
+		return 0
+	`)
+	// KEYS[1] = lock key, ARGV[1] = lock token
+	releaseUploadLockScript = redis.NewScript(`
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("DEL", KEYS[1])
+		end
+		return 0
+	`)
+)
+
+// LockUpload acquires the lock for the upload with the given UUID, or returns
+// ErrUploadLocked if the lock is held by someone else. On success, the caller
+// must call the returned function to release the lock once it is done
+// modifying the upload.
+func (c *UploadCoordinator) LockUpload(ctx context.Context, uploadUUID string) (release func(), err error) {
+	if c == nil {
+		return func() {}, nil
+	}
+
+	// the lock must be released even if the request gets canceled, so all
+	// operations on the lock after this point use a non-cancelable context
+	ctx = context.WithoutCancel(ctx)
+	key := "keppel-upload-lock-" + uploadUUID
+	token := GenerateStorageID() // just some random string
+
+	ok, err := c.Client.SetNX(ctx, key, token, uploadLockTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrUploadLocked
+	}
+
+	// keep refreshing the lock until it is released
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(uploadLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := refreshUploadLockScript.Run(ctx, c.Client, []string{key}, token, uploadLockTTL.Milliseconds()).Err()
+				if err != nil {
+					logg.Error("could not refresh lock for upload %s: %s", uploadUUID, err.Error())
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		err := releaseUploadLockScript.Run(ctx, c.Client, []string{key}, token).Err()
+		if err != nil {
+			logg.Error("could not release lock for upload %s: %s", uploadUUID, err.Error())
+		}
+	}, nil
+}
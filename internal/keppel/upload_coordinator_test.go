@@ -0,0 +1,99 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestUploadCoordinator(t *testing.T) {
+	ctx := context.Background()
+
+	// a nil UploadCoordinator does not lock anything
+	var nilCoordinator *UploadCoordinator
+	release, err := nilCoordinator.LockUpload(ctx, "foo")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	release()
+
+	sr := miniredis.RunT(t)
+	uc := &UploadCoordinator{Client: redis.NewClient(&redis.Options{
+		Addr: sr.Addr(),
+		// SETINFO not supported by miniredis
+		DisableIndentity: true,
+	})}
+
+	// locks on different uploads do not interfere with each other
+	releaseFoo, err := uc.LockUpload(ctx, "foo")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	releaseBar, err := uc.LockUpload(ctx, "bar")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the same upload cannot be locked twice
+	_, err = uc.LockUpload(ctx, "foo")
+	if !errors.Is(err, ErrUploadLocked) {
+		t.Errorf("expected ErrUploadLocked, but got %v", err)
+	}
+
+	// after release, the upload can be locked again
+	releaseFoo()
+	releaseFoo, err = uc.LockUpload(ctx, "foo")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	releaseFoo()
+	releaseBar()
+
+	// the lock expires when its holder does not refresh it anymore (e.g. because
+	// the keppel-api holding it died)
+	err = uc.Client.Set(ctx, "keppel-upload-lock-qux", "some-other-token", uploadLockTTL).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = uc.LockUpload(ctx, "qux")
+	if !errors.Is(err, ErrUploadLocked) {
+		t.Errorf("expected ErrUploadLocked, but got %v", err)
+	}
+	sr.FastForward(uploadLockTTL)
+	releaseQux, err := uc.LockUpload(ctx, "qux")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// releasing our lock does not delete a lock taken over by someone else
+	sr.FastForward(uploadLockTTL)
+	err = uc.Client.Set(ctx, "keppel-upload-lock-qux", "some-other-token", uploadLockTTL).Err()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	releaseQux()
+	if !sr.Exists("keppel-upload-lock-qux") {
+		t.Error("expected lock taken over by someone else to still exist")
+	}
+}
@@ -0,0 +1,82 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package keppel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/pluggable"
+)
+
+// UsageReportDriver is the abstract interface for an external billing or
+// accounting system that receives periodic reports about how much storage and
+// egress traffic each auth tenant has consumed.
+type UsageReportDriver interface {
+	pluggable.Plugin
+	// Init is called before any other interface methods, and allows the plugin to
+	// perform first-time initialization.
+	Init(context.Context, Configuration) error
+	// ReportUsage is called periodically by keppel-janitor. If an error is
+	// returned, the same usage data will be reported again in the next run.
+	ReportUsage(ctx context.Context, report UsageReport) error
+}
+
+// UsageReport is the payload of UsageReportDriver.ReportUsage().
+type UsageReport struct {
+	ReportedAt time.Time
+	Tenants    []TenantUsage
+}
+
+// TenantUsage appears in type UsageReport.
+type TenantUsage struct {
+	AuthTenantID string
+	// Total size of all blobs in all accounts belonging to this tenant.
+	StorageBytes uint64
+	// Total number of blob bytes that were pulled from accounts belonging to
+	// this tenant since egress accounting was first enabled. This is a counter
+	// that only ever goes up, so consumers need to compute the difference
+	// between two reports to obtain the egress within a billing period.
+	EgressBytes uint64
+}
+
+// UsageReportDriverRegistry is a pluggable.Registry for UsageReportDriver implementations.
+var UsageReportDriverRegistry pluggable.Registry[UsageReportDriver]
+
+// NewUsageReportDriver creates a new UsageReportDriver using one of the
+// plugins registered with UsageReportDriverRegistry.
+func NewUsageReportDriver(ctx context.Context, pluginTypeID string, cfg Configuration) (UsageReportDriver, error) {
+	logg.Debug("initializing usage report driver %q...", pluginTypeID)
+
+	urd := UsageReportDriverRegistry.Instantiate(pluginTypeID)
+	if urd == nil {
+		return nil, errors.New("no such usage report driver: " + pluginTypeID)
+	}
+	return urd, urd.Init(ctx, cfg)
+}
+
+// GetUsageReportDriverNameFromEnvironment reads the KEPPEL_DRIVER_USAGE_REPORT
+// environment variable. If usage reporting is not enabled, an empty string is
+// returned.
+func GetUsageReportDriverNameFromEnvironment() string {
+	return os.Getenv("KEPPEL_DRIVER_USAGE_REPORT")
+}
@@ -53,6 +53,7 @@ type UserIdentity interface {
 	pluggable.Plugin
 
 	// Returns whether the given auth tenant grants the given permission to this user.
+	// For CanViewClusterStatus, the tenant ID is empty.
 	// The AnonymousUserIdentity always returns false.
 	HasPermission(perm Permission, tenantID string) bool
 
@@ -28,19 +28,30 @@ import (
 
 // ValidationPolicy represents a validation policy in the API.
 type ValidationPolicy struct {
-	RequiredLabels []string `json:"required_labels,omitempty"`
+	RequiredLabels             []string           `json:"required_labels,omitempty"`
+	AllowedMediaTypes          []string           `json:"allowed_media_types,omitempty"`
+	RejectEmptyConfigArtifacts bool               `json:"reject_empty_config_artifacts,omitempty"`
+	RequireSignature           *SignaturePolicy   `json:"require_signature,omitempty"`
+	RequireAttestations        *AttestationPolicy `json:"require_attestations,omitempty"`
 }
 
 // RenderValidationPolicy builds a ValidationPolicy object out of the
 // information in the given account model.
-func RenderValidationPolicy(account models.ReducedAccount) *ValidationPolicy {
-	if account.RequiredLabels == "" {
+func RenderValidationPolicy(account models.Account) *ValidationPolicy {
+	if account.RequiredLabels == "" && account.AllowedMediaTypes == "" && !account.RejectEmptyConfigArtifacts &&
+		account.RequireSignatureMode == models.SignatureNotRequired && account.RequiredAttestationTypes == "" {
 		return nil
 	}
 
-	return &ValidationPolicy{
-		RequiredLabels: account.SplitRequiredLabels(),
+	var result ValidationPolicy
+	if account.RequiredLabels != "" {
+		result.RequiredLabels = account.Reduced().SplitRequiredLabels()
 	}
+	result.AllowedMediaTypes = account.Reduced().SplitAllowedMediaTypes()
+	result.RejectEmptyConfigArtifacts = account.RejectEmptyConfigArtifacts
+	result.RequireSignature = RenderSignaturePolicy(account)
+	result.RequireAttestations = RenderAttestationPolicy(account)
+	return &result
 }
 
 // ApplyToAccount validates this policy and stores it in the given account model.
@@ -51,7 +62,36 @@ func (v ValidationPolicy) ApplyToAccount(account *models.Account) *RegistryV2Err
 			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
 		}
 	}
+	for _, mediaType := range v.AllowedMediaTypes {
+		prefix, _ := strings.CutSuffix(mediaType, "*")
+		if prefix == "" || strings.ContainsAny(prefix, ",*") {
+			err := fmt.Errorf(`invalid media type: %q`, mediaType)
+			return AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+	}
+
+	if v.RequireSignature == nil {
+		account.RequireSignatureMode = models.SignatureNotRequired
+		account.SignaturePublicKeys = ""
+	} else {
+		rerr := v.RequireSignature.ApplyToAccount(account)
+		if rerr != nil {
+			return rerr
+		}
+	}
+
+	if v.RequireAttestations == nil {
+		account.RequiredAttestationTypes = ""
+		account.AttestationPublicKeys = ""
+	} else {
+		rerr := v.RequireAttestations.ApplyToAccount(account)
+		if rerr != nil {
+			return rerr
+		}
+	}
 
 	account.RequiredLabels = strings.Join(v.RequiredLabels, ",")
+	account.AllowedMediaTypes = strings.Join(v.AllowedMediaTypes, ",")
+	account.RejectEmptyConfigArtifacts = v.RejectEmptyConfigArtifacts
 	return nil
 }
@@ -22,6 +22,8 @@ package models
 import (
 	"strings"
 	"time"
+
+	"github.com/sapcc/go-bits/regexpext"
 )
 
 // AccountName identifies an account. This typedef is used to distinguish these
@@ -40,16 +42,94 @@ type Account struct {
 	ExternalPeerURL      string `db:"external_peer_url"`
 	ExternalPeerUserName string `db:"external_peer_username"`
 	ExternalPeerPassword string `db:"external_peer_password"`
+	// ExternalPeerPasswordRef may be set instead of ExternalPeerPassword to
+	// refer to a secret that is resolved at runtime (see
+	// keppel.ResolveExternalPeerPassword).
+	ExternalPeerPasswordRef string `db:"external_peer_password_ref"`
+	// ExternalPeerCABundle and ExternalPeerProxyURL are optional settings for
+	// talking to the external peer: a PEM-encoded bundle of CA certificates that
+	// is trusted in addition to the system CAs, and the URL of an HTTP proxy.
+	ExternalPeerCABundle string `db:"external_peer_ca_bundle"`
+	ExternalPeerProxyURL string `db:"external_peer_proxy_url"`
+	// IsProxyCache is set if and only if the "proxy_cache" replication strategy
+	// is used. This strategy also uses the ExternalPeer* fields.
+	IsProxyCache bool `db:"is_proxy_cache"`
 	// PlatformFilter restricts which submanifests get replicated when a list manifest is replicated.
 	PlatformFilter PlatformFilter `db:"platform_filter"`
+	// RetainOrphansForSecs is only set on replica accounts. It specifies for how
+	// many seconds a manifest that was deleted upstream is kept around (see
+	// Manifest.OrphanedAt) before the deletion is replicated into this account.
+	RetainOrphansForSecs int64 `db:"retain_orphans_for_secs"`
+	// PrefetchBlobs is only set on replica accounts. If set, all blobs referenced
+	// by a replicated manifest are replicated in the background (see
+	// tasks.BlobPrefetchJob) instead of waiting for the first pull of each blob.
+	PrefetchBlobs bool `db:"prefetch_blobs"`
+	// FailoverUpstream is only set on replica accounts. It names a secondary
+	// upstream (a peer hostname for "on_first_use", or an URL like
+	// ExternalPeerURL otherwise) that is replicated from instead of the primary
+	// upstream while IsUpstreamFailoverActive is set.
+	FailoverUpstream string `db:"failover_upstream"`
+	// FailoverAfterSecs is how long the primary upstream must be unreachable
+	// before failing over to the FailoverUpstream.
+	FailoverAfterSecs int64 `db:"failover_after_secs"`
+	// UpstreamUnreachableSince and IsUpstreamFailoverActive are maintained by
+	// tasks.UpstreamHealthCheckJob for accounts with a FailoverUpstream.
+	UpstreamUnreachableSince *time.Time `db:"upstream_unreachable_since"`
+	IsUpstreamFailoverActive bool       `db:"is_upstream_failover_active"`
 
 	// RequiredLabels is a comma-separated list of labels that must be present on
 	// all image manifests in this account.
 	RequiredLabels string `db:"required_labels"`
+	// AllowedMediaTypes is a comma-separated list of media types that manifests
+	// and blobs pushed into this account may have. A trailing "*" matches any
+	// suffix. If empty, all media types are allowed.
+	AllowedMediaTypes string `db:"allowed_media_types"`
+	// RejectEmptyConfigArtifacts is set if OCI artifacts with an empty config
+	// (media type "application/vnd.oci.empty.v1+json") may not be pushed into this account.
+	RejectEmptyConfigArtifacts bool `db:"reject_empty_config_artifacts"`
+	// RequireDigestPullsRepoRx matches the names of repositories in which
+	// manifests may only be pulled by digest, not by tag. If empty, pulling by
+	// tag is allowed everywhere.
+	RequireDigestPullsRepoRx regexpext.BoundedRegexp `db:"require_digest_pulls_repo_rx"`
+	// QuarantineSeverityThreshold is set if newly pushed manifests shall be held
+	// in quarantine until their initial vulnerability scan shows a severity
+	// below this threshold. If empty, pushed manifests are visible immediately.
+	QuarantineSeverityThreshold VulnerabilityStatus `db:"quarantine_severity_threshold"`
+	// RequireSignatureMode is set if manifests in this account must carry a
+	// cosign signature made with one of the SignaturePublicKeys.
+	RequireSignatureMode SignatureEnforcement `db:"require_signature_mode"`
+	// SignaturePublicKeys contains the PEM-encoded public keys that are trusted
+	// for verifying cosign signatures, or the empty string.
+	SignaturePublicKeys string `db:"signature_public_keys"`
+	// RequiredAttestationTypes is a comma-separated list of in-toto predicate
+	// types for which manifests in this account must have a cosign attestation
+	// made with one of the AttestationPublicKeys, or the empty string.
+	RequiredAttestationTypes string `db:"required_attestation_types"`
+	// AttestationPublicKeys contains the PEM-encoded public keys that are trusted
+	// for verifying cosign attestations, or the empty string.
+	AttestationPublicKeys string `db:"attestation_public_keys"`
+	// ImageTransformations is a comma-separated list of transformations for which
+	// variants of tagged manifests are generated (see type ImageTransformation),
+	// or the empty string.
+	ImageTransformations string `db:"image_transformations"`
+	// ConfigSyncEnabled is only set on internal replica accounts whose RBAC
+	// policies, GC policies and platform filter shall be periodically copied
+	// from the primary account, except for those listed in ConfigSyncExcludedFields.
+	ConfigSyncEnabled bool `db:"config_sync_enabled"`
+	// ConfigSyncExcludedFields is a comma-separated list of fields (see type
+	// keppel.ConfigSyncField) that are not copied from the primary account, or the empty string.
+	ConfigSyncExcludedFields string `db:"config_sync_excluded_fields"`
 	// IsDeleting indicates whether the account is currently being deleted.
 	IsDeleting bool `db:"is_deleting"`
+	// IsReadOnly indicates whether the account is in read-only mode (e.g. during
+	// a storage migration). In this mode, pulls are served, but all writes are rejected.
+	IsReadOnly bool `db:"is_read_only"`
 	// IsManaged indicates if the account was created by AccountManagementDriver
 	IsManaged bool `db:"is_managed"`
+	// DeletionScheduledAt is only set on managed accounts that have disappeared
+	// from the AccountManagementDriver's configuration. Unless the account
+	// reappears there, it will be marked for deletion at this time.
+	DeletionScheduledAt *time.Time `db:"deletion_scheduled_at"`
 
 	// RBACPoliciesJSON contains a JSON string of []keppel.RBACPolicy, or the empty string.
 	RBACPoliciesJSON string `db:"rbac_policies_json"`
@@ -63,6 +143,12 @@ type Account struct {
 	NextEnforcementAt            *time.Time `db:"next_enforcement_at"`             // see tasks.CreateManagedAccountsJob
 	NextStorageSweepedAt         *time.Time `db:"next_storage_sweep_at"`           // see tasks.StorageSweepJob
 	NextFederationAnnouncementAt *time.Time `db:"next_federation_announcement_at"` // see tasks.AnnounceAccountToFederationJob
+	NextConfigSyncAt             *time.Time `db:"next_config_sync_at"`             // see tasks.AccountConfigSyncJob
+	NextUpstreamCheckAt          *time.Time `db:"next_upstream_check_at"`          // see tasks.UpstreamHealthCheckJob
+
+	// BlobSweepCursor is the ID of the last blob that was processed by the
+	// current pass of tasks.BlobSweepJob, or 0 if no pass is in progress.
+	BlobSweepCursor int64 `db:"blob_sweep_cursor"`
 
 	// TODO: remove once the Elektra UI has been updated to not require this flag to proceed with account deletion
 	InMaintenance bool `db:"in_maintenance"`
@@ -71,15 +157,32 @@ type Account struct {
 // Reduced converts an Account into a ReducedAccount.
 func (a Account) Reduced() ReducedAccount {
 	return ReducedAccount{
-		Name:                 a.Name,
-		AuthTenantID:         a.AuthTenantID,
-		UpstreamPeerHostName: a.UpstreamPeerHostName,
-		ExternalPeerURL:      a.ExternalPeerURL,
-		ExternalPeerUserName: a.ExternalPeerUserName,
-		ExternalPeerPassword: a.ExternalPeerPassword,
-		PlatformFilter:       a.PlatformFilter,
-		RequiredLabels:       a.RequiredLabels,
-		IsDeleting:           a.IsDeleting,
+		Name:                    a.Name,
+		AuthTenantID:            a.AuthTenantID,
+		UpstreamPeerHostName:    a.UpstreamPeerHostName,
+		ExternalPeerURL:         a.ExternalPeerURL,
+		ExternalPeerUserName:    a.ExternalPeerUserName,
+		ExternalPeerPassword:    a.ExternalPeerPassword,
+		ExternalPeerPasswordRef: a.ExternalPeerPasswordRef,
+		ExternalPeerCABundle:    a.ExternalPeerCABundle,
+		ExternalPeerProxyURL:    a.ExternalPeerProxyURL,
+		IsProxyCache:            a.IsProxyCache,
+		PlatformFilter:          a.PlatformFilter,
+		RetainOrphansForSecs:    a.RetainOrphansForSecs,
+		PrefetchBlobs:           a.PrefetchBlobs,
+		FailoverUpstream:        a.FailoverUpstream,
+		RequiredLabels:          a.RequiredLabels,
+		AllowedMediaTypes:       a.AllowedMediaTypes,
+		IsDeleting:              a.IsDeleting,
+		IsReadOnly:              a.IsReadOnly,
+
+		IsUpstreamFailoverActive:    a.IsUpstreamFailoverActive,
+		RejectEmptyConfigArtifacts:  a.RejectEmptyConfigArtifacts,
+		RequireDigestPullsRepoRx:    a.RequireDigestPullsRepoRx,
+		QuarantineSeverityThreshold: a.QuarantineSeverityThreshold,
+		RequireSignatureMode:        a.RequireSignatureMode,
+		ImageTransformations:        a.ImageTransformations,
+		RequiredAttestationTypes:    a.RequiredAttestationTypes,
 	}
 }
 
@@ -91,20 +194,129 @@ type ReducedAccount struct {
 	AuthTenantID string
 
 	// replication policy
-	UpstreamPeerHostName string
-	ExternalPeerURL      string
-	ExternalPeerUserName string
-	ExternalPeerPassword string
-	PlatformFilter       PlatformFilter
+	UpstreamPeerHostName    string
+	ExternalPeerURL         string
+	ExternalPeerUserName    string
+	ExternalPeerPassword    string
+	ExternalPeerPasswordRef string
+	ExternalPeerCABundle    string
+	ExternalPeerProxyURL    string
+	IsProxyCache            bool
+	PlatformFilter          PlatformFilter
+	RetainOrphansForSecs    int64
+	PrefetchBlobs           bool
+	FailoverUpstream        string
+	// failover status
+	IsUpstreamFailoverActive bool
 
 	// validation policy, status
-	RequiredLabels string
-	IsDeleting     bool
+	RequiredLabels             string
+	AllowedMediaTypes          string
+	RejectEmptyConfigArtifacts bool
+	IsDeleting                 bool
+	IsReadOnly                 bool
+
+	// pull policy
+	RequireDigestPullsRepoRx regexpext.BoundedRegexp
+
+	// quarantine policy
+	QuarantineSeverityThreshold VulnerabilityStatus
 
-	// NOTE: When adding or removing fields, always adjust Account.Reduced() and keppel.FindReducedAccount() too!
+	// content trust policy (the trusted public keys are only needed by the janitor)
+	RequireSignatureMode SignatureEnforcement
+
+	// attestation policy (the trusted public keys are only needed by the janitor)
+	RequiredAttestationTypes string
+
+	// image transformation policy
+	ImageTransformations string
+
+	// NOTE: When adding or removing fields, always adjust Account.Reduced() and keppel.reducedAccountColumns too!
 }
 
+// RequiresDigestPulls returns whether manifests in the given repository may
+// only be pulled by digest.
+func (a ReducedAccount) RequiresDigestPulls(repoName string) bool {
+	return a.RequireDigestPullsRepoRx != "" && a.RequireDigestPullsRepoRx.MatchString(repoName)
+}
+
+// OrphanRetentionPeriod returns for how long manifests that were deleted
+// upstream are kept in this replica account before being deleted here as well.
+func (a ReducedAccount) OrphanRetentionPeriod() time.Duration {
+	return time.Duration(a.RetainOrphansForSecs) * time.Second
+}
+
+// IsQuarantineEnabled returns whether newly pushed manifests in this account
+// are held in quarantine until their initial vulnerability scan completes.
+func (a ReducedAccount) IsQuarantineEnabled() bool {
+	return a.QuarantineSeverityThreshold != ""
+}
+
+// RequiresSignature returns whether manifests in this account must have a
+// valid cosign signature.
+func (a ReducedAccount) RequiresSignature() bool {
+	return a.RequireSignatureMode != SignatureNotRequired
+}
+
+// SplitRequiredAttestationTypes parses the RequiredAttestationTypes field.
+func (a ReducedAccount) SplitRequiredAttestationTypes() []string {
+	if a.RequiredAttestationTypes == "" {
+		return nil
+	}
+	return strings.Split(a.RequiredAttestationTypes, ",")
+}
+
+// SplitImageTransformations parses the ImageTransformations field.
+func (a ReducedAccount) SplitImageTransformations() []ImageTransformation {
+	if a.ImageTransformations == "" {
+		return nil
+	}
+	var result []ImageTransformation
+	for _, name := range strings.Split(a.ImageTransformations, ",") {
+		result = append(result, ImageTransformation(name))
+	}
+	return result
+}
+
+// SignatureEnforcement enumerates the possible values for Account.RequireSignatureMode.
+type SignatureEnforcement string
+
+const (
+	// SignatureNotRequired is the SignatureEnforcement of accounts without a "require_signature" policy.
+	SignatureNotRequired SignatureEnforcement = ""
+	// SignatureRejectUnsigned is the SignatureEnforcement of accounts where
+	// pulls of manifests without a valid signature are rejected.
+	SignatureRejectUnsigned SignatureEnforcement = "reject"
+	// SignatureFlagUnsigned is the SignatureEnforcement of accounts where pulls
+	// of manifests without a valid signature are allowed, but flagged with a
+	// response header.
+	SignatureFlagUnsigned SignatureEnforcement = "flag"
+)
+
 // SplitRequiredLabels parses the RequiredLabels field.
 func (a ReducedAccount) SplitRequiredLabels() []string {
 	return strings.Split(a.RequiredLabels, ",")
 }
+
+// SplitAllowedMediaTypes parses the AllowedMediaTypes field.
+func (a ReducedAccount) SplitAllowedMediaTypes() []string {
+	if a.AllowedMediaTypes == "" {
+		return nil
+	}
+	return strings.Split(a.AllowedMediaTypes, ",")
+}
+
+// IsMediaTypeAllowed returns whether manifests or blobs with the given media
+// type may be pushed into this account.
+func (a ReducedAccount) IsMediaTypeAllowed(mediaType string) bool {
+	if a.AllowedMediaTypes == "" {
+		return true
+	}
+	for _, pattern := range a.SplitAllowedMediaTypes() {
+		prefix, isWildcard := strings.CutSuffix(pattern, "*")
+		if mediaType == pattern || (isWildcard && strings.HasPrefix(mediaType, prefix)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package models
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// AttestationResult contains a record from the `attestation_results` table.
+//
+// For manifests in accounts that require attestations (see
+// Account.RequiredAttestationTypes), there is one record for each required
+// predicate type, which caches the result of the last verification by
+// tasks.AttestationVerificationJob.
+type AttestationResult struct {
+	RepositoryID  int64             `db:"repo_id"`
+	Digest        digest.Digest     `db:"digest"`
+	PredicateType string            `db:"predicate_type"`
+	Status        AttestationStatus `db:"status"`
+	// AttestationDigest is the digest of the cosign attestation manifest that
+	// contained the valid (or last rejected) attestation, or the empty string.
+	AttestationDigest string `db:"attestation_digest"`
+	// Message explains why an attestation was rejected, or is the empty string.
+	Message   string    `db:"message"`
+	CheckedAt time.Time `db:"checked_at"`
+}
+
+// AttestationStatus enumerates the possible values for AttestationResult.Status.
+type AttestationStatus string
+
+const (
+	// AttestationValid is the AttestationStatus for predicate types where an attestation signed by a trusted key was found.
+	AttestationValid AttestationStatus = "valid"
+	// AttestationMissing is the AttestationStatus for predicate types where no attestation was found.
+	AttestationMissing AttestationStatus = "missing"
+	// AttestationInvalid is the AttestationStatus for predicate types where
+	// attestations were found, but none of them could be verified with a trusted key.
+	AttestationInvalid AttestationStatus = "invalid"
+	// AttestationExempt is the AttestationStatus for manifests that are cosign
+	// artifacts (e.g. signatures or attestations) themselves.
+	AttestationExempt AttestationStatus = "exempt"
+)
+
+// IsTrusted returns whether an AttestationResult with this status satisfies the attestation requirement.
+func (s AttestationStatus) IsTrusted() bool {
+	return s == AttestationValid || s == AttestationExempt
+}
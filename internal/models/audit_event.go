@@ -0,0 +1,34 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package models
+
+import "time"
+
+// AuditEvent contains a record from the `audit_events` table. Records are only
+// written if persistence of audit events is enabled in the configuration.
+type AuditEvent struct {
+	ID          int64       `db:"id"`
+	AccountName AccountName `db:"account_name"`
+	RecordedAt  time.Time   `db:"recorded_at"`
+	Action      string      `db:"action"`
+	// CADFJSON is the full event in the same CADF format that is sent to the
+	// audit trail.
+	CADFJSON string `db:"cadf_json"`
+}
@@ -47,6 +47,8 @@ type Blob struct {
 	ValidationErrorMessage string        `db:"validation_error_message"`
 	CanBeDeletedAt         *time.Time    `db:"can_be_deleted_at"` // see tasks.BlobSweepJob
 	BlocksVulnScanning     *bool         `db:"blocks_vuln_scanning"`
+	NextPrefetchAt         *time.Time    `db:"next_prefetch_at"` // see tasks.BlobPrefetchJob (only set for unbacked blobs in replica accounts)
+	IsGlobal               bool          `db:"is_global"`        // see keppel.Configuration.BlobStorageLocation and tasks.GlobalBlobDeduplicationJob
 }
 
 // SafeMediaType returns the MediaType field, but falls back to "application/octet-stream" if it is empty.
@@ -57,6 +59,20 @@ func (b Blob) SafeMediaType() string {
 	return b.MediaType
 }
 
+// GlobalBlob contains a record from the `global_blobs` table.
+//
+// This table is only used if keppel.Configuration.GlobalBlobStore is set. In
+// this case, each record describes a storage object in the global blob store
+// that is shared by all blobs with this digest in all accounts. RefCount is
+// the number of such blobs, and the storage object is deleted when it drops
+// to zero.
+type GlobalBlob struct {
+	Digest    digest.Digest `db:"digest"`
+	StorageID string        `db:"storage_id"`
+	SizeBytes uint64        `db:"size_bytes"`
+	RefCount  uint64        `db:"ref_count"`
+}
+
 const (
 	// BlobValidationInterval is how often each blob will be validated by BlobValidationJob.
 	// This is here instead of near the job because package processor also needs to know it.
@@ -64,6 +80,9 @@ const (
 	// BlobValidationAfterErrorInterval is how quickly BlobValidationJob will
 	// retry a failed blob validation.
 	BlobValidationAfterErrorInterval = 10 * time.Minute
+	// BlobPrefetchAfterErrorInterval is how quickly BlobPrefetchJob will retry
+	// a failed blob replication.
+	BlobPrefetchAfterErrorInterval = 10 * time.Minute
 )
 
 // Upload contains a record from the `uploads` table.
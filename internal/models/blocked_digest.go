@@ -0,0 +1,39 @@
+/*******************************************************************************
+*
+* Copyright 2026 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package models
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// BlockedDigest contains a record from the `blocked_digests` table. Each
+// record describes a manifest or blob digest that may neither be pulled nor
+// pushed, e.g. because the respective content was found to contain malware.
+type BlockedDigest struct {
+	ID int64 `db:"id"`
+	// AccountName is nil for entries on the instance-wide blocklist.
+	AccountName *AccountName  `db:"account_name"`
+	Digest      digest.Digest `db:"digest"`
+	Reason      string        `db:"reason"`
+	CreatedBy   string        `db:"created_by"`
+	CreatedAt   time.Time     `db:"created_at"`
+}
@@ -0,0 +1,32 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package models
+
+import "time"
+
+// EOLReport contains a record from the `eol_reports` table.
+type EOLReport struct {
+	ID          int64     `db:"id"`
+	GeneratedAt time.Time `db:"generated_at"`
+	EntryCount  uint64    `db:"entry_count"`
+	// ContentsJSON is the full report in the format that is sent to the webhook
+	// (see type tasks.EOLReportContents).
+	ContentsJSON string `db:"contents_json"`
+}
@@ -0,0 +1,40 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package models
+
+import "time"
+
+// GCRun contains a record from the `gc_runs` table. Each record describes one
+// run of the manifest garbage collection in a single repository.
+type GCRun struct {
+	ID          int64       `db:"id"`
+	AccountName AccountName `db:"account_name"`
+	// RepositoryName is not a foreign key since runs shall still be visible
+	// after the repository has been deleted.
+	RepositoryName    string    `db:"repo_name"`
+	StartedAt         time.Time `db:"started_at"`
+	FinishedAt        time.Time `db:"finished_at"`
+	PoliciesEvaluated uint64    `db:"policies_evaluated"`
+	ManifestsDeleted  uint64    `db:"manifests_deleted"`
+	BytesFreed        uint64    `db:"bytes_freed"`
+	// DeletedManifestsJSON is a list of keppel.GCDeletedManifest.
+	DeletedManifestsJSON string `db:"deleted_manifests_json"`
+	ErrorMessage         string `db:"error_message"`
+}
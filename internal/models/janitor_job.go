@@ -0,0 +1,36 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package models
+
+import "time"
+
+// JanitorJob contains a record from the `janitor_jobs` table. Each record
+// describes the status of one of the jobs running in keppel-janitor, as last
+// reported by the janitor itself.
+type JanitorJob struct {
+	Name      string     `db:"name"`
+	LastRunAt *time.Time `db:"last_run_at"`
+	// LastError is empty if the last run succeeded.
+	LastError string `db:"last_error"`
+	// Backlog is the number of tasks that are due, but have not been processed yet.
+	// Jobs that run on a fixed schedule do not report a backlog.
+	Backlog          uint64     `db:"backlog"`
+	BacklogCheckedAt *time.Time `db:"backlog_checked_at"`
+}
@@ -20,6 +20,8 @@
 package models
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/opencontainers/go-digest"
@@ -42,6 +44,72 @@ type Manifest struct {
 	GCStatusJSON      string     `db:"gc_status_json"`
 	MinLayerCreatedAt *time.Time `db:"min_layer_created_at"`
 	MaxLayerCreatedAt *time.Time `db:"max_layer_created_at"`
+	// QuarantineStatus is only set for manifests that were pushed into an
+	// account with a quarantine policy.
+	QuarantineStatus QuarantineStatus `db:"quarantine_status"`
+	// SignatureStatus is only maintained for manifests in accounts that require
+	// signatures. It is a cached result of the last signature verification.
+	SignatureStatus      SignatureStatus `db:"signature_status"`
+	NextSignatureCheckAt *time.Time      `db:"next_signature_check_at"` // see tasks.SignatureVerificationJob
+	// NextAttestationCheckAt is only maintained for manifests in accounts that
+	// require attestations. The verification results are in the `attestation_results` table.
+	NextAttestationCheckAt *time.Time `db:"next_attestation_check_at"` // see tasks.AttestationVerificationJob
+	// These fields are only filled for OCI manifests that declare them.
+	// AnnotationsJSON contains a JSON string of a map[string]string, or an empty string.
+	ArtifactType    string `db:"artifact_type"`
+	SubjectDigest   string `db:"subject_digest"`
+	AnnotationsJSON string `db:"annotations_json"`
+	// MissingPlatforms is only filled for image indexes. It is a comma-separated
+	// list of required platforms (formatted like "linux/arm64/v8") for which the
+	// index does not reference an existing child manifest.
+	MissingPlatforms    string     `db:"missing_platforms"`
+	NextPlatformCheckAt *time.Time `db:"next_platform_check_at"` // see tasks.ManifestPlatformCheckJob
+	// OrphanedAt is only set in replica accounts with an orphan retention period
+	// (see Account.RetainOrphansForSecs). It records when the manifest sync
+	// first noticed that this manifest was deleted upstream.
+	OrphanedAt *time.Time `db:"orphaned_at"`
+}
+
+// QuarantineStatus enumerates the possible values for Manifest.QuarantineStatus.
+type QuarantineStatus string
+
+const (
+	// NotQuarantined is the QuarantineStatus of manifests that were never held in quarantine.
+	NotQuarantined QuarantineStatus = ""
+	// QuarantinePending is the QuarantineStatus of manifests that are waiting for their initial vulnerability scan.
+	QuarantinePending QuarantineStatus = "pending"
+	// QuarantinePromoted is the QuarantineStatus of manifests that passed their initial vulnerability scan.
+	QuarantinePromoted QuarantineStatus = "promoted"
+	// QuarantineRejected is the QuarantineStatus of manifests that failed their initial vulnerability scan.
+	QuarantineRejected QuarantineStatus = "rejected"
+)
+
+// IsPullable returns whether manifests with this QuarantineStatus may be pulled by regular users.
+func (s QuarantineStatus) IsPullable() bool {
+	return s == NotQuarantined || s == QuarantinePromoted
+}
+
+// SignatureStatus enumerates the possible values for Manifest.SignatureStatus.
+type SignatureStatus string
+
+const (
+	// SignatureUnverified is the SignatureStatus of manifests that have not been checked for signatures yet.
+	SignatureUnverified SignatureStatus = ""
+	// SignatureValid is the SignatureStatus of manifests that have a cosign signature from a trusted key.
+	SignatureValid SignatureStatus = "valid"
+	// SignatureMissing is the SignatureStatus of manifests that do not have any cosign signature.
+	SignatureMissing SignatureStatus = "missing"
+	// SignatureInvalid is the SignatureStatus of manifests that have cosign
+	// signatures, but none of them could be verified with a trusted key.
+	SignatureInvalid SignatureStatus = "invalid"
+	// SignatureExempt is the SignatureStatus of manifests that are cosign
+	// artifacts (e.g. signatures or attestations) themselves.
+	SignatureExempt SignatureStatus = "exempt"
+)
+
+// IsTrusted returns whether manifests with this SignatureStatus satisfy a "require_signature" policy.
+func (s SignatureStatus) IsTrusted() bool {
+	return s == SignatureValid || s == SignatureExempt
 }
 
 const (
@@ -50,6 +118,17 @@ const (
 	ManifestValidationInterval = 24 * time.Hour
 	// ManifestValidationAfterErrorInterval is how quickly ManifestValidationJob will retry a failed manifest validation.
 	ManifestValidationAfterErrorInterval = 10 * time.Minute
+	// SignatureVerificationInterval is how often SignatureVerificationJob re-verifies trusted manifests.
+	SignatureVerificationInterval = 24 * time.Hour
+	// SignatureVerificationRetryInterval is how quickly SignatureVerificationJob
+	// re-verifies manifests whose signature was missing or invalid.
+	SignatureVerificationRetryInterval = 5 * time.Minute
+	// AttestationVerificationInterval is how often AttestationVerificationJob
+	// re-verifies manifests that have all required attestations.
+	AttestationVerificationInterval = 24 * time.Hour
+	// AttestationVerificationRetryInterval is how quickly AttestationVerificationJob
+	// re-verifies manifests where some required attestation was missing or invalid.
+	AttestationVerificationRetryInterval = 5 * time.Minute
 )
 
 // Tag contains a record from the `tags` table.
@@ -61,9 +140,52 @@ type Tag struct {
 	LastPulledAt *time.Time    `db:"last_pulled_at"`
 }
 
+// TagHistoryEntry contains a record from the `tag_history` table. Each record
+// describes one movement of an existing tag from one manifest to another.
+// The initial creation of a tag is not recorded here since it is already
+// evident from the tag's own `pushed_at` (or the first entry's `old_digest`).
+type TagHistoryEntry struct {
+	ID           int64         `db:"id"`
+	RepositoryID int64         `db:"repo_id"`
+	TagName      string        `db:"tag_name"`
+	OldDigest    digest.Digest `db:"old_digest"`
+	NewDigest    digest.Digest `db:"new_digest"`
+	MovedAt      time.Time     `db:"moved_at"`
+	// UserName is empty if the tag was moved by Keppel itself (e.g. during replication).
+	UserName string `db:"user_name"`
+}
+
+// QuarantinedTag contains a record from the `quarantined_tags` table. These
+// are tags that were pushed together with a quarantined manifest, and which
+// will be moved into the `tags` table once that manifest is promoted.
+type QuarantinedTag struct {
+	RepositoryID int64         `db:"repo_id"`
+	Name         string        `db:"name"`
+	Digest       digest.Digest `db:"digest"`
+	PushedAt     time.Time     `db:"pushed_at"`
+}
+
 // ManifestContent contains a record from the `manifest_contents` table.
 type ManifestContent struct {
 	RepositoryID int64  `db:"repo_id"`
 	Digest       string `db:"digest"`
 	Content      []byte `db:"content"`
 }
+
+// SplitMissingPlatforms parses the MissingPlatforms field.
+func (m Manifest) SplitMissingPlatforms() []string {
+	if m.MissingPlatforms == "" {
+		return nil
+	}
+	return strings.Split(m.MissingPlatforms, ",")
+}
+
+// ParseAnnotations parses the AnnotationsJSON field.
+func (m Manifest) ParseAnnotations() (map[string]string, error) {
+	if m.AnnotationsJSON == "" {
+		return nil, nil
+	}
+	var result map[string]string
+	err := json.Unmarshal([]byte(m.AnnotationsJSON), &result)
+	return result, err
+}
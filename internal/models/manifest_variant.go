@@ -0,0 +1,69 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package models
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// ImageTransformation enumerates the transformations that can be applied to
+// image manifests to generate variants of them.
+type ImageTransformation string
+
+const (
+	// SquashTransformation merges all layers of an image into a single layer.
+	SquashTransformation ImageTransformation = "squash"
+)
+
+// IsValid returns whether this is one of the known transformations.
+func (t ImageTransformation) IsValid() bool {
+	return t == SquashTransformation
+}
+
+// DerivedTagSuffix returns the suffix that is appended to a tag name to refer
+// to the variant of the tagged manifest that was generated by this transformation.
+func (t ImageTransformation) DerivedTagSuffix() string {
+	switch t {
+	case SquashTransformation:
+		return "-squashed"
+	default:
+		return "-" + string(t)
+	}
+}
+
+// ManifestVariant contains a record from the `manifest_variants` table.
+//
+// A variant is an image manifest that was generated from a source manifest in
+// the same repository by applying an ImageTransformation. It is stored like any
+// other manifest, but can also be pulled by appending the transformation's
+// DerivedTagSuffix() to any tag pointing to the source manifest.
+type ManifestVariant struct {
+	RepositoryID   int64               `db:"repo_id"`
+	SourceDigest   digest.Digest       `db:"source_digest"`
+	Transformation ImageTransformation `db:"transformation"`
+	// VariantDigest is nil if the variant could not be generated. In that case,
+	// ErrorMessage explains why, and generation will be retried at NextAttemptAt.
+	VariantDigest *digest.Digest `db:"variant_digest"`
+	UpdatedAt     time.Time      `db:"updated_at"`
+	ErrorMessage  string         `db:"error_message"`
+	NextAttemptAt *time.Time     `db:"next_attempt_at"`
+}
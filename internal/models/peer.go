@@ -38,6 +38,31 @@ type Peer struct {
 	TheirCurrentPasswordHash  string `db:"their_current_password_hash"`
 	TheirPreviousPasswordHash string `db:"their_previous_password_hash"`
 
+	// If ClientCertPath and ClientKeyPath are set, we present this client
+	// certificate whenever we connect to the peer (mutual TLS).
+	ClientCertPath string `db:"client_cert_path"`
+	ClientKeyPath  string `db:"client_key_path"`
+	// If ClientCAPath is set, the peer must present a client certificate that
+	// was issued by one of the CAs in this file and is valid for its hostname
+	// whenever it uses our peer API.
+	ClientCAPath string `db:"client_ca_path"`
+
 	// LastPeeredAt is when we last issued a new password for this peer.
 	LastPeeredAt *time.Time `db:"last_peered_at"` // see tasks.IssueNewPasswordForPeer
+
+	// If non-zero, replication from this peer is in cold-start mode: Manifests
+	// are replicated at most this often per minute, and all further requests
+	// for replication are queued in the `replication_queue` table.
+	ColdStartReplicationsPerMinute uint32 `db:"cold_start_replications_per_minute"`
+	// When the next replication from this peer may start during cold-start mode.
+	NextColdStartReplicationAt *time.Time `db:"next_cold_start_replication_at"`
+}
+
+// ColdStartReplicationInterval returns the minimum time between two
+// replications from this peer, or zero if cold-start mode is not enabled.
+func (p Peer) ColdStartReplicationInterval() time.Duration {
+	if p.ColdStartReplicationsPerMinute == 0 {
+		return 0
+	}
+	return time.Minute / time.Duration(p.ColdStartReplicationsPerMinute)
 }
@@ -24,6 +24,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"slices"
+	"strings"
 
 	"github.com/docker/distribution/manifest/manifestlist"
 )
@@ -84,6 +86,29 @@ func (f PlatformFilter) Includes(platform manifestlist.PlatformSpec) bool {
 	return false
 }
 
+// ParsePlatform parses a platform in the format "os/arch" or "os/arch/variant",
+// e.g. "linux/amd64" or "linux/arm64/v8".
+func ParsePlatform(input string) (manifestlist.PlatformSpec, error) {
+	fields := strings.Split(input, "/")
+	if len(fields) < 2 || len(fields) > 3 || slices.Contains(fields, "") {
+		return manifestlist.PlatformSpec{}, fmt.Errorf(`expected platform in the format "os/arch" or "os/arch/variant", but got %q`, input)
+	}
+	result := manifestlist.PlatformSpec{OS: fields[0], Architecture: fields[1]}
+	if len(fields) == 3 {
+		result.Variant = fields[2]
+	}
+	return result, nil
+}
+
+// FormatPlatform is the inverse of ParsePlatform. Fields other than OS,
+// Architecture and Variant are not included in the result.
+func FormatPlatform(platform manifestlist.PlatformSpec) string {
+	if platform.Variant == "" {
+		return platform.OS + "/" + platform.Architecture
+	}
+	return platform.OS + "/" + platform.Architecture + "/" + platform.Variant
+}
+
 // IsEqualTo checks whether both filters are equal.
 func (f PlatformFilter) IsEqualTo(other PlatformFilter) bool {
 	if len(f) != len(other) {
@@ -27,6 +27,7 @@ var (
 	RepoNameRx          = `[a-z0-9]+(?:[._-][a-z0-9]+)*`
 	RepoPathRx          = regexp.MustCompile(`^` + RepoNameRx + `(?:/` + RepoNameRx + `)*$`)
 	RepoPathComponentRx = regexp.MustCompile(`^` + RepoNameRx + `$`)
+	TagNameRx           = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
 )
 
 // The "with leading slash" simplifies the regex because we don't need to write the
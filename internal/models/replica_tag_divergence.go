@@ -0,0 +1,61 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package models
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// ReplicaTagDivergence contains a record from the `replica_tag_divergences`
+// table. Records are written by tasks.ReplicaConsistencyCheckJob for tags in
+// replica repos that do not match the primary account.
+type ReplicaTagDivergence struct {
+	RepositoryID int64  `db:"repo_id"`
+	TagName      string `db:"tag_name"`
+	// ReplicaDigest is the digest that the tag points to in the replica.
+	ReplicaDigest digest.Digest `db:"replica_digest"`
+	// PrimaryDigest is the digest that the tag points to in the primary account,
+	// or the empty string if the tag does not exist there anymore.
+	PrimaryDigest digest.Digest `db:"primary_digest"`
+	DetectedAt    time.Time     `db:"detected_at"`
+	// IsConfirmed is set once the divergence was observed in two consecutive
+	// checks, i.e. it was not just a temporary lag of the regular tag sync.
+	IsConfirmed bool `db:"is_confirmed"`
+}
+
+// ReplicaDivergenceKind enumerates the kinds of ReplicaTagDivergence.
+type ReplicaDivergenceKind string
+
+const (
+	// DivergenceDeletedOnPrimary is the kind of divergence where a tag exists in the replica, but not in the primary account.
+	DivergenceDeletedOnPrimary ReplicaDivergenceKind = "deleted_on_primary"
+	// DivergenceDigestMismatch is the kind of divergence where a tag points to different manifests in the replica and the primary account.
+	DivergenceDigestMismatch ReplicaDivergenceKind = "digest_mismatch"
+)
+
+// Kind returns the kind of this divergence.
+func (d ReplicaTagDivergence) Kind() ReplicaDivergenceKind {
+	if d.PrimaryDigest == "" {
+		return DivergenceDeletedOnPrimary
+	}
+	return DivergenceDigestMismatch
+}
@@ -0,0 +1,38 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package models
+
+import "time"
+
+// ReplicationQueueEntry contains a record from the `replication_queue` table.
+//
+// While replication from a peer is in cold-start mode (see
+// Peer.ColdStartReplicationsPerMinute), first pulls of manifests that exceed
+// the peer's rate limit are not replicated immediately. Instead, they are
+// recorded here and replicated by the janitor as soon as the rate limit
+// allows, with the most recently requested manifests going first.
+type ReplicationQueueEntry struct {
+	RepositoryID int64 `db:"repo_id"`
+	// Reference is the string form of a ManifestReference, i.e. either a digest or a tag name.
+	Reference       string    `db:"reference"`
+	EnqueuedAt      time.Time `db:"enqueued_at"`
+	LastRequestedAt time.Time `db:"last_requested_at"`
+	RequestCount    uint64    `db:"request_count"`
+}
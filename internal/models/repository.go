@@ -28,9 +28,16 @@ type Repository struct {
 	ID                      int64       `db:"id"`
 	AccountName             AccountName `db:"account_name"`
 	Name                    string      `db:"name"`
-	NextBlobMountSweepAt    *time.Time  `db:"next_blob_mount_sweep_at"` // see tasks.BlobMountSweepJob
-	NextManifestSyncAt      *time.Time  `db:"next_manifest_sync_at"`    // see tasks.ManifestSyncJob (only set for replica accounts)
-	NextGarbageCollectionAt *time.Time  `db:"next_gc_at"`               // see tasks.GarbageCollectManifestsJob
+	NextBlobMountSweepAt    *time.Time  `db:"next_blob_mount_sweep_at"`  // see tasks.BlobMountSweepJob
+	NextManifestSyncAt      *time.Time  `db:"next_manifest_sync_at"`     // see tasks.ManifestSyncJob (only set for replica accounts)
+	NextGarbageCollectionAt *time.Time  `db:"next_gc_at"`                // see tasks.GarbageCollectManifestsJob
+	NextConsistencyCheckAt  *time.Time  `db:"next_consistency_check_at"` // see tasks.ReplicaConsistencyCheckJob (only set for replica accounts)
+	PullCount               uint64      `db:"pull_count"`                // only counted if keppel.Configuration.AccountMetricsEnabled is set
+	BlobMountSweepCursor    int64       `db:"blob_mount_sweep_cursor"`   // see tasks.BlobMountSweepJob (0 if no pass is in progress)
+	// metadata for display purposes, editable through the Keppel API
+	Description string `db:"description"`
+	SourceURL   string `db:"source_url"`
+	OwnerTeam   string `db:"owner_team"`
 }
 
 // FullName prepends the account name to the repository name.
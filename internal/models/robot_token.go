@@ -0,0 +1,47 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package models
+
+import "time"
+
+// RobotToken contains a record from the `robot_tokens` table. Each record
+// describes a credential issued by Keppel itself that grants pull access to a
+// single repository, e.g. for use in Kubernetes imagePullSecrets.
+type RobotToken struct {
+	ID          int64       `db:"id"`
+	AccountName AccountName `db:"account_name"`
+	// RepositoryName is not a foreign key since tokens may be issued before the
+	// repository is first pushed to.
+	RepositoryName string `db:"repo_name"`
+	// SecretHash is the hex-encoded SHA-256 hash of the token secret. (We do not
+	// need a slow hash like bcrypt here because the secret is long and random.)
+	SecretHash  string     `db:"secret_hash"`
+	Description string     `db:"description"`
+	CreatedBy   string     `db:"created_by"`
+	CreatedAt   time.Time  `db:"created_at"`
+	ExpiresAt   time.Time  `db:"expires_at"`
+	RevokedAt   *time.Time `db:"revoked_at"`
+}
+
+// IsUsableAt returns whether this token can be used to authenticate at the
+// given time, i.e. whether it is neither expired nor revoked.
+func (t RobotToken) IsUsableAt(now time.Time) bool {
+	return t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
@@ -0,0 +1,32 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package models
+
+import "time"
+
+// SecuritySummarySnapshot contains a record from the `security_summary_snapshots`
+// table. Each record counts how many manifests in the given auth tenant had
+// the given vulnerability status on the day identified by TakenAt.
+type SecuritySummarySnapshot struct {
+	AuthTenantID        string              `db:"auth_tenant_id"`
+	TakenAt             time.Time           `db:"taken_at"`
+	VulnerabilityStatus VulnerabilityStatus `db:"vuln_status"`
+	ManifestCount       uint64              `db:"manifest_count"`
+}
@@ -33,6 +33,13 @@ type UnknownBlob struct {
 	CanBeDeletedAt time.Time   `db:"can_be_deleted_at"`
 }
 
+// UnknownGlobalBlob contains a record from the `unknown_global_blobs` table.
+// This is only used by tasks.GlobalBlobStorageSweepJob().
+type UnknownGlobalBlob struct {
+	StorageID      string    `db:"storage_id"`
+	CanBeDeletedAt time.Time `db:"can_be_deleted_at"`
+}
+
 // UnknownManifest contains a record from the `unknown_manifests` table.
 // This is only used by tasks.StorageSweepJob().
 //
@@ -0,0 +1,28 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package models
+
+// VanityDomain contains a record from the `vanity_domains` table. Each record
+// describes an additional hostname under which the OCI Distribution API of a
+// single account is offered, in the same way as for domain-remapped APIs.
+type VanityDomain struct {
+	Hostname    string      `db:"hostname"`
+	AccountName AccountName `db:"account_name"`
+}
@@ -65,6 +65,12 @@ func (s VulnerabilityStatus) HasReport() bool {
 	return sevMap[s] > 0
 }
 
+// IsLessSevereThan checks whether this VulnerabilityStatus is ranked below the
+// other one. This is only meaningful if both statuses have a report.
+func (s VulnerabilityStatus) IsLessSevereThan(other VulnerabilityStatus) bool {
+	return sevMap[s] < sevMap[other]
+}
+
 // MergeVulnerabilityStatuses combines multiple VulnerabilityStatus values into one.
 //
 // * Any ErrorVulnerabilityStatus input results in an ErrorVulnerabilityStatus result.
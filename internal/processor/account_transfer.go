@@ -0,0 +1,637 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package processor
+
+import (
+	"archive/tar"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// AccountTransferReport is returned by ExportAccount and ImportAccount.
+type AccountTransferReport struct {
+	Repos     uint64
+	Manifests uint64
+	Tags      uint64
+	Blobs     uint64
+	BlobBytes uint64
+}
+
+// The format version is increased whenever the archive format changes in an
+// incompatible way, so that ImportAccount can reject archives that it does not understand.
+const accountExportFormatVersion = 1
+
+// The archive produced by ExportAccount is a tar stream containing the
+// following files, in this order:
+//
+//   - "keppel-export.json" (type accountExportHeader)
+//   - "db/<table>.json" (one file per DB table, see type accountExportRecords)
+//   - "manifests/<repo_id>/<digest>" (one file per manifest)
+//   - "blobs/<blob_id>" (one file per blob, except for unbacked blobs in replica accounts)
+//
+// Repo IDs and blob IDs refer to the IDs in the DB of the exporting Keppel.
+// ImportAccount relies on this order to be able to stream the archive.
+type accountExportHeader struct {
+	FormatVersion int                `json:"format_version"`
+	AccountName   models.AccountName `json:"account_name"`
+}
+
+type accountExportRecords struct {
+	Account              models.Account
+	Repos                []models.Repository
+	Blobs                []models.Blob
+	BlobMounts           []accountExportBlobMount
+	Manifests            []models.Manifest
+	ManifestBlobRefs     []accountExportManifestBlobRef
+	ManifestManifestRefs []accountExportManifestManifestRef
+	Tags                 []models.Tag
+	SecurityInfos        []models.TrivySecurityInfo
+}
+
+type accountExportBlobMount struct {
+	BlobID int64 `json:"blob_id"`
+	RepoID int64 `json:"repo_id"`
+}
+
+type accountExportManifestBlobRef struct {
+	RepoID int64         `json:"repo_id"`
+	Digest digest.Digest `json:"digest"`
+	BlobID int64         `json:"blob_id"`
+}
+
+type accountExportManifestManifestRef struct {
+	RepoID       int64         `json:"repo_id"`
+	ParentDigest digest.Digest `json:"parent_digest"`
+	ChildDigest  digest.Digest `json:"child_digest"`
+}
+
+// Returns pointers into the given records, keyed by the file names under which they appear in the archive.
+func (r *accountExportRecords) files() []struct {
+	Name   string
+	Target any
+} {
+	return []struct {
+		Name   string
+		Target any
+	}{
+		{"db/accounts.json", &r.Account},
+		{"db/repos.json", &r.Repos},
+		{"db/blobs.json", &r.Blobs},
+		{"db/blob_mounts.json", &r.BlobMounts},
+		{"db/manifests.json", &r.Manifests},
+		{"db/manifest_blob_refs.json", &r.ManifestBlobRefs},
+		{"db/manifest_manifest_refs.json", &r.ManifestManifestRefs},
+		{"db/tags.json", &r.Tags},
+		{"db/trivy_security_info.json", &r.SecurityInfos},
+	}
+}
+
+var (
+	accountExportBlobMountsQuery = sqlext.SimplifyWhitespace(`
+		SELECT bm.blob_id, bm.repo_id FROM blob_mounts bm
+		  JOIN repos r ON r.id = bm.repo_id
+		 WHERE r.account_name = $1
+		 ORDER BY bm.repo_id, bm.blob_id
+	`)
+	accountExportManifestsQuery = sqlext.SimplifyWhitespace(`
+		SELECT m.* FROM manifests m
+		  JOIN repos r ON r.id = m.repo_id
+		 WHERE r.account_name = $1
+		 ORDER BY m.repo_id, m.digest
+	`)
+	accountExportManifestBlobRefsQuery = sqlext.SimplifyWhitespace(`
+		SELECT mbr.repo_id, mbr.digest, mbr.blob_id FROM manifest_blob_refs mbr
+		  JOIN repos r ON r.id = mbr.repo_id
+		 WHERE r.account_name = $1
+		 ORDER BY mbr.repo_id, mbr.digest, mbr.blob_id
+	`)
+	accountExportManifestManifestRefsQuery = sqlext.SimplifyWhitespace(`
+		SELECT mmr.repo_id, mmr.parent_digest, mmr.child_digest FROM manifest_manifest_refs mmr
+		  JOIN repos r ON r.id = mmr.repo_id
+		 WHERE r.account_name = $1
+		 ORDER BY mmr.repo_id, mmr.parent_digest, mmr.child_digest
+	`)
+	accountExportTagsQuery = sqlext.SimplifyWhitespace(`
+		SELECT t.* FROM tags t
+		  JOIN repos r ON r.id = t.repo_id
+		 WHERE r.account_name = $1
+		 ORDER BY t.repo_id, t.name
+	`)
+	accountExportSecurityInfosQuery = sqlext.SimplifyWhitespace(`
+		SELECT tsi.* FROM trivy_security_info tsi
+		  JOIN repos r ON r.id = tsi.repo_id
+		 WHERE r.account_name = $1
+		 ORDER BY tsi.repo_id, tsi.digest
+	`)
+)
+
+// ExportAccount writes the DB records and storage contents of the given
+// account into `w` as a tar stream that can be restored with ImportAccount,
+// possibly in a different Keppel instance.
+//
+// All DB records are read from a consistent snapshot, but storage contents
+// are read afterwards. To ensure that the export succeeds even if blobs are
+// deleted while it is ongoing, the account should be put into read-only mode first.
+func (p *Processor) ExportAccount(ctx context.Context, account models.Account, w io.Writer) (report AccountTransferReport, err error) {
+	records, err := p.collectAccountExportRecords(account)
+	if err != nil {
+		return report, err
+	}
+
+	tw := tar.NewWriter(w)
+	err = writeJSONToTar(tw, "keppel-export.json", accountExportHeader{
+		FormatVersion: accountExportFormatVersion,
+		AccountName:   account.Name,
+	})
+	if err != nil {
+		return report, err
+	}
+	for _, file := range records.files() {
+		err = writeJSONToTar(tw, file.Name, file.Target)
+		if err != nil {
+			return report, err
+		}
+	}
+	report.Repos = uint64(len(records.Repos))
+	report.Tags = uint64(len(records.Tags))
+
+	reducedAccount := account.Reduced()
+	repoNameByID := make(map[int64]string, len(records.Repos))
+	for _, repo := range records.Repos {
+		repoNameByID[repo.ID] = repo.Name
+	}
+	for _, manifest := range records.Manifests {
+		contents, err := p.sd.ReadManifest(ctx, reducedAccount, repoNameByID[manifest.RepositoryID], manifest.Digest)
+		if err != nil {
+			return report, fmt.Errorf("cannot read manifest %s/%s@%s: %w", account.Name, repoNameByID[manifest.RepositoryID], manifest.Digest, err)
+		}
+		err = writeBytesToTar(tw, fmt.Sprintf("manifests/%d/%s", manifest.RepositoryID, manifest.Digest), contents)
+		if err != nil {
+			return report, err
+		}
+		report.Manifests++
+	}
+
+	for _, blob := range records.Blobs {
+		if blob.StorageID == "" {
+			// in replica accounts, blobs that have not been replicated yet do not have any contents to export
+			continue
+		}
+		err := p.exportBlob(ctx, reducedAccount, blob, tw)
+		if err != nil {
+			return report, fmt.Errorf("cannot read blob %s: %w", blob.Digest, err)
+		}
+		report.Blobs++
+		report.BlobBytes += blob.SizeBytes
+	}
+
+	return report, tw.Close()
+}
+
+func (p *Processor) collectAccountExportRecords(account models.Account) (records accountExportRecords, err error) {
+	// read everything from the same snapshot, to ensure that all records are consistent with each other
+	tx, err := p.db.Begin()
+	if err != nil {
+		return records, err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+	_, err = tx.Exec(`SET TRANSACTION ISOLATION LEVEL REPEATABLE READ`)
+	if err != nil {
+		return records, err
+	}
+
+	err = tx.SelectOne(&records.Account, `SELECT * FROM accounts WHERE name = $1`, account.Name)
+	if err != nil {
+		return records, err
+	}
+	_, err = tx.Select(&records.Repos, `SELECT * FROM repos WHERE account_name = $1 ORDER BY id`, account.Name)
+	if err != nil {
+		return records, err
+	}
+	_, err = tx.Select(&records.Blobs, `SELECT * FROM blobs WHERE account_name = $1 ORDER BY id`, account.Name)
+	if err != nil {
+		return records, err
+	}
+	err = sqlext.ForeachRow(tx, accountExportBlobMountsQuery, []any{account.Name}, func(rows *sql.Rows) error {
+		var bm accountExportBlobMount
+		err := rows.Scan(&bm.BlobID, &bm.RepoID)
+		records.BlobMounts = append(records.BlobMounts, bm)
+		return err
+	})
+	if err != nil {
+		return records, err
+	}
+	_, err = tx.Select(&records.Manifests, accountExportManifestsQuery, account.Name)
+	if err != nil {
+		return records, err
+	}
+	err = sqlext.ForeachRow(tx, accountExportManifestBlobRefsQuery, []any{account.Name}, func(rows *sql.Rows) error {
+		var ref accountExportManifestBlobRef
+		err := rows.Scan(&ref.RepoID, &ref.Digest, &ref.BlobID)
+		records.ManifestBlobRefs = append(records.ManifestBlobRefs, ref)
+		return err
+	})
+	if err != nil {
+		return records, err
+	}
+	err = sqlext.ForeachRow(tx, accountExportManifestManifestRefsQuery, []any{account.Name}, func(rows *sql.Rows) error {
+		var ref accountExportManifestManifestRef
+		err := rows.Scan(&ref.RepoID, &ref.ParentDigest, &ref.ChildDigest)
+		records.ManifestManifestRefs = append(records.ManifestManifestRefs, ref)
+		return err
+	})
+	if err != nil {
+		return records, err
+	}
+	_, err = tx.Select(&records.Tags, accountExportTagsQuery, account.Name)
+	if err != nil {
+		return records, err
+	}
+	_, err = tx.Select(&records.SecurityInfos, accountExportSecurityInfosQuery, account.Name)
+	if err != nil {
+		return records, err
+	}
+
+	return records, tx.Commit()
+}
+
+func (p *Processor) exportBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob, tw *tar.Writer) error {
+	contents, sizeBytes, err := p.sd.ReadBlob(ctx, p.cfg.BlobStorageLocation(account, blob), blob.StorageID)
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+	if sizeBytes != blob.SizeBytes {
+		return fmt.Errorf("expected %d bytes in storage, but found %d bytes", blob.SizeBytes, sizeBytes)
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name: "blobs/" + strconv.FormatInt(blob.ID, 10),
+		Mode: 0o644,
+		Size: int64(sizeBytes), //nolint:gosec // blob sizes are far below the overflow threshold
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, contents)
+	return err
+}
+
+func writeJSONToTar(tw *tar.Writer, name string, data any) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return writeBytesToTar(tw, name, buf)
+}
+
+func writeBytesToTar(tw *tar.Writer, name string, contents []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0o644,
+		Size: int64(len(contents)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(contents)
+	return err
+}
+
+// ImportAccount restores an account from a tar stream produced by
+// ExportAccount. The account must not exist yet in this Keppel instance. Its
+// name is claimed through the federation driver like for a newly created account.
+//
+// Storage contents are written as they are read from the stream, but DB records
+// are only inserted once the entire stream has been read successfully. If the
+// import fails, the storage contents written so far will be cleaned up by the
+// storage sweep eventually.
+func (p *Processor) ImportAccount(ctx context.Context, r io.Reader) (account models.Account, report AccountTransferReport, err error) {
+	var (
+		records          accountExportRecords
+		header           *accountExportHeader
+		recordFiles      = records.files()
+		readRecordFiles  = 0
+		manifestContents = make(map[int64]map[digest.Digest][]byte)
+		blobsByID        = make(map[int64]*models.Blob)
+		storedBlobIDs    = make(map[int64]bool)
+	)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return account, report, err
+		}
+
+		switch {
+		case header == nil:
+			// the archive header must come first
+			if hdr.Name != "keppel-export.json" {
+				return account, report, fmt.Errorf("expected keppel-export.json at the start of the archive, but found %q", hdr.Name)
+			}
+			header = &accountExportHeader{}
+			err = json.NewDecoder(tr).Decode(header)
+			if err != nil {
+				return account, report, fmt.Errorf("cannot decode %s: %w", hdr.Name, err)
+			}
+			if header.FormatVersion != accountExportFormatVersion {
+				return account, report, fmt.Errorf("unsupported format version: %d", header.FormatVersion)
+			}
+
+		case readRecordFiles < len(recordFiles):
+			// next come all DB records
+			file := recordFiles[readRecordFiles]
+			if hdr.Name != file.Name {
+				return account, report, fmt.Errorf("expected %s in the archive, but found %q", file.Name, hdr.Name)
+			}
+			err = json.NewDecoder(tr).Decode(file.Target)
+			if err != nil {
+				return account, report, fmt.Errorf("cannot decode %s: %w", hdr.Name, err)
+			}
+			readRecordFiles++
+
+			// once the account is known, check that we can create it
+			if file.Target == &records.Account {
+				account = records.Account
+				if account.Name != header.AccountName {
+					return account, report, fmt.Errorf("expected records for account %q, but found %q", header.AccountName, account.Name)
+				}
+				err = p.prepareAccountImport(ctx, account)
+				if err != nil {
+					return account, report, err
+				}
+			}
+			if file.Target == &records.Blobs {
+				for idx := range records.Blobs {
+					blobsByID[records.Blobs[idx].ID] = &records.Blobs[idx]
+				}
+			}
+
+		case strings.HasPrefix(hdr.Name, "manifests/"):
+			repoIDStr, digestStr := path.Split(strings.TrimPrefix(hdr.Name, "manifests/"))
+			repoID, err := strconv.ParseInt(strings.TrimSuffix(repoIDStr, "/"), 10, 64)
+			if err != nil {
+				return account, report, fmt.Errorf("unexpected file in archive: %q", hdr.Name)
+			}
+			manifestDigest, err := digest.Parse(digestStr)
+			if err != nil {
+				return account, report, fmt.Errorf("unexpected file in archive: %q", hdr.Name)
+			}
+			contents, err := io.ReadAll(tr)
+			if err != nil {
+				return account, report, err
+			}
+			if manifestDigest.Algorithm().FromBytes(contents) != manifestDigest {
+				return account, report, fmt.Errorf("contents of %s do not match their digest", hdr.Name)
+			}
+			if manifestContents[repoID] == nil {
+				manifestContents[repoID] = make(map[digest.Digest][]byte)
+			}
+			manifestContents[repoID][manifestDigest] = contents
+
+		case strings.HasPrefix(hdr.Name, "blobs/"):
+			blobID, err := strconv.ParseInt(strings.TrimPrefix(hdr.Name, "blobs/"), 10, 64)
+			if err != nil {
+				return account, report, fmt.Errorf("unexpected file in archive: %q", hdr.Name)
+			}
+			blob, exists := blobsByID[blobID]
+			if !exists || blob.StorageID == "" {
+				return account, report, fmt.Errorf("found %s in archive, but no DB record for it", hdr.Name)
+			}
+			blob.StorageID = p.generateStorageID()
+			err = p.importBlob(ctx, account.Reduced(), *blob, tr)
+			if err != nil {
+				return account, report, fmt.Errorf("cannot import blob %s: %w", blob.Digest, err)
+			}
+			storedBlobIDs[blobID] = true
+			report.Blobs++
+			report.BlobBytes += blob.SizeBytes
+
+		default:
+			return account, report, fmt.Errorf("unexpected file in archive: %q", hdr.Name)
+		}
+	}
+
+	// check that the archive was complete
+	if readRecordFiles < len(recordFiles) {
+		return account, report, errors.New("archive ended before all DB records were read")
+	}
+	for _, blob := range records.Blobs {
+		if blob.StorageID != "" && !storedBlobIDs[blob.ID] {
+			return account, report, fmt.Errorf("archive does not contain contents for blob %s", blob.Digest)
+		}
+	}
+	for _, manifest := range records.Manifests {
+		if manifestContents[manifest.RepositoryID][manifest.Digest] == nil {
+			return account, report, fmt.Errorf("archive does not contain contents for manifest %s", manifest.Digest)
+		}
+	}
+
+	err = p.insertAccountImportRecords(ctx, records, manifestContents)
+	if err != nil {
+		return account, report, err
+	}
+	report.Repos = uint64(len(records.Repos))
+	report.Manifests = uint64(len(records.Manifests))
+	report.Tags = uint64(len(records.Tags))
+	return account, report, nil
+}
+
+func (p *Processor) prepareAccountImport(ctx context.Context, account models.Account) error {
+	existingAccount, err := keppel.FindAccount(p.db, account.Name)
+	if err != nil {
+		return err
+	}
+	if existingAccount != nil {
+		return fmt.Errorf("account %s already exists", account.Name)
+	}
+
+	claimResult, err := p.fd.ClaimAccountName(ctx, account, "")
+	if claimResult != keppel.ClaimSucceeded {
+		return fmt.Errorf("cannot claim account name %s: %w", account.Name, err)
+	}
+	err = p.sd.CanSetupAccount(ctx, account.Reduced())
+	if err != nil {
+		return fmt.Errorf("cannot set up backing storage for account %s: %w", account.Name, err)
+	}
+	return nil
+}
+
+func (p *Processor) importBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob, contents io.Reader) (returnErr error) {
+	// if anything goes wrong, do not leave a partial upload in the storage
+	upload := models.Upload{StorageID: blob.StorageID}
+	defer func() {
+		if returnErr != nil && upload.NumChunks > 0 {
+			err := p.sd.AbortBlobUpload(ctx, account, blob.StorageID, upload.NumChunks)
+			if err != nil {
+				logg.Error("additional error encountered during AbortBlobUpload: " + err.Error())
+			}
+		}
+	}()
+
+	verifier := blob.Digest.Verifier()
+	sizeBytes := blob.SizeBytes
+	err := appendToBlobIn(ctx, p.sd, account, &upload, io.TeeReader(contents, verifier), &sizeBytes)
+	if err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("contents in archive do not match digest %s", blob.Digest)
+	}
+	err = p.sd.FinalizeBlob(ctx, account, blob.StorageID, upload.NumChunks)
+	if err != nil {
+		return err
+	}
+	upload.NumChunks = 0 // do not abort the upload after a successful FinalizeBlob
+	return nil
+}
+
+func (p *Processor) insertAccountImportRecords(ctx context.Context, records accountExportRecords, manifestContents map[int64]map[digest.Digest][]byte) error {
+	account := records.Account
+	// the account does not carry over any scheduling state from the exporting Keppel
+	account.NextBlobSweepedAt = nil
+	account.BlobSweepCursor = 0
+	account.NextDeletionAttempt = nil
+	account.NextEnforcementAt = nil
+	account.NextStorageSweepedAt = nil
+	account.NextFederationAnnouncementAt = nil
+	account.NextConfigSyncAt = nil
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	err = tx.Insert(&account)
+	if err != nil {
+		return err
+	}
+
+	// repos and blobs get new IDs in our DB, so all references to them need to be remapped
+	newRepoIDs := make(map[int64]int64, len(records.Repos))
+	repoNameByNewID := make(map[int64]string, len(records.Repos))
+	for _, repo := range records.Repos {
+		oldID := repo.ID
+		repo.ID = 0
+		repo.NextBlobMountSweepAt = nil
+		repo.BlobMountSweepCursor = 0
+		repo.NextManifestSyncAt = nil
+		repo.NextGarbageCollectionAt = nil
+		repo.NextConsistencyCheckAt = nil
+		err := tx.Insert(&repo)
+		if err != nil {
+			return err
+		}
+		newRepoIDs[oldID] = repo.ID
+		repoNameByNewID[repo.ID] = repo.Name
+	}
+	newBlobIDs := make(map[int64]int64, len(records.Blobs))
+	for _, blob := range records.Blobs {
+		oldID := blob.ID
+		blob.ID = 0
+		blob.IsGlobal = false
+		err := tx.Insert(&blob)
+		if err != nil {
+			return err
+		}
+		newBlobIDs[oldID] = blob.ID
+	}
+	for _, bm := range records.BlobMounts {
+		_, err := tx.Exec(`INSERT INTO blob_mounts (blob_id, repo_id) VALUES ($1, $2)`, newBlobIDs[bm.BlobID], newRepoIDs[bm.RepoID])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, manifest := range records.Manifests {
+		contents := manifestContents[manifest.RepositoryID][manifest.Digest]
+		manifest.RepositoryID = newRepoIDs[manifest.RepositoryID]
+		manifest.NextAttestationCheckAt = nil // attestation results are not exported
+		err := tx.Insert(&manifest)
+		if err != nil {
+			return err
+		}
+		err = tx.Insert(&models.ManifestContent{
+			RepositoryID: manifest.RepositoryID,
+			Digest:       manifest.Digest.String(),
+			Content:      contents,
+		})
+		if err != nil {
+			return err
+		}
+		err = p.sd.WriteManifest(ctx, account.Reduced(), repoNameByNewID[manifest.RepositoryID], manifest.Digest, contents)
+		if err != nil {
+			return fmt.Errorf("cannot write manifest %s to storage: %w", manifest.Digest, err)
+		}
+	}
+	for _, ref := range records.ManifestBlobRefs {
+		_, err := tx.Exec(`INSERT INTO manifest_blob_refs (repo_id, digest, blob_id) VALUES ($1, $2, $3)`, newRepoIDs[ref.RepoID], ref.Digest, newBlobIDs[ref.BlobID])
+		if err != nil {
+			return err
+		}
+	}
+	for _, ref := range records.ManifestManifestRefs {
+		_, err := tx.Exec(`INSERT INTO manifest_manifest_refs (repo_id, parent_digest, child_digest) VALUES ($1, $2, $3)`, newRepoIDs[ref.RepoID], ref.ParentDigest, ref.ChildDigest)
+		if err != nil {
+			return err
+		}
+	}
+	err = insertRemapped(tx, records.Tags, newRepoIDs, func(t *models.Tag) *int64 { return &t.RepositoryID })
+	if err != nil {
+		return err
+	}
+	err = insertRemapped(tx, records.SecurityInfos, newRepoIDs, func(s *models.TrivySecurityInfo) *int64 { return &s.RepositoryID })
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertRemapped[T any](tx *gorp.Transaction, records []T, newRepoIDs map[int64]int64, repoIDOf func(*T) *int64) error {
+	for _, record := range records {
+		repoID := repoIDOf(&record)
+		*repoID = newRepoIDs[*repoID]
+		err := tx.Insert(&record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
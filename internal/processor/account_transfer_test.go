@@ -0,0 +1,104 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package processor_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/processor"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestExportImportAccount(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "test1authtenant"}),
+		test.WithRepo(models.Repository{AccountName: "test1", Name: "foo"}),
+		test.WithQuotas,
+	)
+	image := test.GenerateImage(test.GenerateExampleLayer(1), test.GenerateExampleLayer(2))
+	image.MustUpload(t, s, models.Repository{AccountName: "test1", Name: "foo"}, "latest")
+
+	p := processor.New(s.Config, s.DB, s.SD, s.ICD, s.Auditor, s.FD, s.Clock.Now)
+	account, err := keppel.FindAccount(s.DB, "test1")
+	mustDo(t, err)
+
+	// export the account
+	var buf bytes.Buffer
+	report, err := p.ExportAccount(s.Ctx, *account, &buf)
+	mustDo(t, err)
+	expectedReport := processor.AccountTransferReport{
+		Repos:     1,
+		Manifests: 1,
+		Tags:      1,
+		Blobs:     3,
+		BlobBytes: image.SizeBytes() - uint64(len(image.Manifest.Contents)),
+	}
+	assert.DeepEqual(t, "export report", report, expectedReport)
+	archive := buf.Bytes()
+
+	// import is refused while the account still exists
+	_, _, err = p.ImportAccount(s.Ctx, bytes.NewReader(archive))
+	expectError(t, "account test1 already exists", err)
+
+	// lose the account's DB records (all storage contents will be written again with new storage IDs)
+	_, err = s.DB.Exec(`DELETE FROM manifests`)
+	mustDo(t, err)
+	_, err = s.DB.Exec(`DELETE FROM accounts WHERE name = $1`, "test1")
+	mustDo(t, err)
+
+	// truncated archives are rejected
+	_, _, err = p.ImportAccount(s.Ctx, bytes.NewReader(archive[:len(archive)/2]))
+	if err == nil {
+		t.Error("expected import of truncated archive to fail, but it succeeded")
+	}
+
+	// happy path
+	importedAccount, report, err := p.ImportAccount(s.Ctx, bytes.NewReader(archive))
+	mustDo(t, err)
+	assert.DeepEqual(t, "import report", report, expectedReport)
+	assert.DeepEqual(t, "imported account name", importedAccount.Name, account.Name)
+
+	// the image can be pulled again
+	repo, err := keppel.FindRepository(s.DB, "foo", "test1")
+	mustDo(t, err)
+	tagDigest, err := s.DB.SelectStr(`SELECT digest FROM tags WHERE repo_id = $1 AND name = $2`, repo.ID, "latest")
+	mustDo(t, err)
+	assert.DeepEqual(t, "tag digest", tagDigest, image.Manifest.Digest.String())
+	manifestContents, err := s.SD.ReadManifest(s.Ctx, account.Reduced(), "foo", image.Manifest.Digest)
+	mustDo(t, err)
+	assert.DeepEqual(t, "manifest contents", string(manifestContents), string(image.Manifest.Contents))
+
+	var blobs []models.Blob
+	_, err = s.DB.Select(&blobs, `SELECT * FROM blobs WHERE account_name = $1`, "test1")
+	mustDo(t, err)
+	assert.DeepEqual(t, "blob count", len(blobs), 3)
+	for _, blob := range blobs {
+		reader, _, err := s.SD.ReadBlob(s.Ctx, account.Reduced(), blob.StorageID)
+		mustDo(t, err)
+		contents, err := io.ReadAll(reader)
+		mustDo(t, err)
+		assert.DeepEqual(t, "blob digest", blob.Digest.Algorithm().FromBytes(contents), blob.Digest)
+	}
+}
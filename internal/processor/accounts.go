@@ -29,6 +29,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/sapcc/keppel/internal/auth"
 	peerclient "github.com/sapcc/keppel/internal/client/peer"
@@ -42,6 +43,16 @@ import (
 
 // GetPlatformFilterFromPrimaryAccount takes a replica account and queries the peer holding the primary account for that account.
 func (p *Processor) GetPlatformFilterFromPrimaryAccount(ctx context.Context, peer models.Peer, replicaAccount models.Account) (models.PlatformFilter, error) {
+	upstreamAccount, err := p.GetPrimaryAccountConfiguration(ctx, peer, replicaAccount)
+	if err != nil {
+		return nil, err
+	}
+	return upstreamAccount.PlatformFilter, nil
+}
+
+// GetPrimaryAccountConfiguration takes a replica account and queries the peer
+// holding the primary account for the full configuration of that account.
+func (p *Processor) GetPrimaryAccountConfiguration(ctx context.Context, peer models.Peer, replicaAccount models.Account) (keppel.Account, error) {
 	viewScope := auth.Scope{
 		ResourceType: "keppel_account",
 		ResourceName: string(replicaAccount.Name),
@@ -49,15 +60,12 @@ func (p *Processor) GetPlatformFilterFromPrimaryAccount(ctx context.Context, pee
 	}
 	client, err := peerclient.New(ctx, p.cfg, peer, viewScope)
 	if err != nil {
-		return nil, err
+		return keppel.Account{}, err
 	}
 
 	var upstreamAccount keppel.Account
 	err = client.GetForeignAccountConfigurationInto(ctx, &upstreamAccount, replicaAccount.Name)
-	if err != nil {
-		return nil, err
-	}
-	return upstreamAccount.PlatformFilter, nil
+	return upstreamAccount, err
 }
 
 var looksLikeAPIVersionRx = regexp.MustCompile(`^v[0-9][1-9]*$`)
@@ -65,119 +73,16 @@ var ErrAccountNameEmpty = errors.New("account name cannot be empty string")
 
 // CreateOrUpdate can be used on an API account and returns the database representation of it.
 func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Account, userInfo audittools.UserInfo, r *http.Request, getSubleaseToken func(models.Peer) (keppel.SubleaseToken, error), setCustomFields func(*models.Account) *keppel.RegistryV2Error) (models.Account, *keppel.RegistryV2Error) {
-	if account.Name == "" {
-		return models.Account{}, keppel.AsRegistryV2Error(ErrAccountNameEmpty)
-	}
-	// reserve identifiers for internal pseudo-accounts and anything that might
-	// appear like the first path element of a legal endpoint path on any of our
-	// APIs (we will soon start recognizing image-like URLs such as
-	// keppel.example.org/account/repo and offer redirection to a suitable UI;
-	// this requires the account name to not overlap with API endpoint paths)
-	if strings.HasPrefix(string(account.Name), "keppel") {
-		return models.Account{}, keppel.AsRegistryV2Error(errors.New(`account names with the prefix "keppel" are reserved for internal use`)).WithStatus(http.StatusUnprocessableEntity)
-	}
-	if looksLikeAPIVersionRx.MatchString(string(account.Name)) {
-		return models.Account{}, keppel.AsRegistryV2Error(errors.New(`account names that look like API versions (e.g. v1) are reserved for internal use`)).WithStatus(http.StatusUnprocessableEntity)
-	}
-
 	// check if account already exists
 	originalAccount, err := keppel.FindAccount(p.db, account.Name)
 	if err != nil {
 		return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
 	}
-	if originalAccount != nil && originalAccount.AuthTenantID != account.AuthTenantID {
-		return models.Account{}, keppel.AsRegistryV2Error(errors.New(`account name already in use by a different tenant`)).WithStatus(http.StatusConflict)
-	}
 
-	// PUT can either create a new account or update an existing account;
-	// this distinction is important because several fields can only be set at creation
-	var targetAccount models.Account
-	if originalAccount == nil {
-		targetAccount = models.Account{
-			Name:                     account.Name,
-			AuthTenantID:             account.AuthTenantID,
-			SecurityScanPoliciesJSON: "[]",
-			// all other attributes are set below or in the ApplyToAccount() methods called below
-		}
-	} else {
-		targetAccount = *originalAccount
-	}
-
-	// validate and update fields as requested
-	targetAccount.IsDeleting = account.State == "deleting"
-	targetAccount.InMaintenance = account.InMaintenance
-
-	// validate GC policies
-	if len(account.GCPolicies) == 0 {
-		targetAccount.GCPoliciesJSON = "[]"
-	} else {
-		for _, policy := range account.GCPolicies {
-			err := policy.Validate()
-			if err != nil {
-				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
-			}
-		}
-		buf, _ := json.Marshal(account.GCPolicies)
-		targetAccount.GCPoliciesJSON = string(buf)
-	}
-
-	// validate replication policy (for OnFirstUseStrategy, the peer hostname is
-	// checked for correctness down below when validating the platform filter)
-	var originalStrategy keppel.ReplicationStrategy
-	if originalAccount != nil {
-		rp := keppel.RenderReplicationPolicy(*originalAccount)
-		if rp == nil {
-			originalStrategy = keppel.NoReplicationStrategy
-		} else {
-			originalStrategy = rp.Strategy
-		}
-	}
-
-	var replicationStrategy keppel.ReplicationStrategy
-	if account.ReplicationPolicy == nil {
-		if originalAccount == nil {
-			replicationStrategy = keppel.NoReplicationStrategy
-		} else {
-			// PUT on existing account can omit replication policy to reuse existing policy
-			replicationStrategy = originalStrategy
-		}
-	} else {
-		// on existing accounts, we do not allow changing the strategy
-		rp := *account.ReplicationPolicy
-		if originalAccount != nil && originalStrategy != rp.Strategy {
-			return models.Account{}, keppel.AsRegistryV2Error(keppel.ErrIncompatibleReplicationPolicy).WithStatus(http.StatusConflict)
-		}
-
-		err := rp.ApplyToAccount(&targetAccount)
-		if errors.Is(err, keppel.ErrIncompatibleReplicationPolicy) {
-			return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusConflict)
-		} else if err != nil {
-			return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
-		}
-		replicationStrategy = rp.Strategy
-	}
-
-	// validate RBAC policies
-	if len(account.RBACPolicies) == 0 {
-		targetAccount.RBACPoliciesJSON = ""
-	} else {
-		for idx, policy := range account.RBACPolicies {
-			err := policy.ValidateAndNormalize(replicationStrategy)
-			if err != nil {
-				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
-			}
-			account.RBACPolicies[idx] = policy
-		}
-		buf, _ := json.Marshal(account.RBACPolicies)
-		targetAccount.RBACPoliciesJSON = string(buf)
-	}
-
-	// validate validation policy
-	if account.ValidationPolicy != nil {
-		rerr := account.ValidationPolicy.ApplyToAccount(&targetAccount)
-		if rerr != nil {
-			return models.Account{}, rerr
-		}
+	// validate the requested configuration and apply it to the account model
+	targetAccount, replicationStrategy, rerr := p.ApplyAccountConfiguration(account, originalAccount)
+	if rerr != nil {
+		return models.Account{}, rerr
 	}
 
 	var peer models.Peer
@@ -191,6 +96,17 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 		if err != nil {
 			return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
 		}
+
+		if targetAccount.FailoverUpstream != "" {
+			err := p.db.SelectOne(&models.Peer{}, `SELECT * FROM peers WHERE hostname = $1`, targetAccount.FailoverUpstream)
+			if errors.Is(err, sql.ErrNoRows) {
+				msg := fmt.Errorf(`unknown peer registry for replication failover: %q`, targetAccount.FailoverUpstream)
+				return models.Account{}, keppel.AsRegistryV2Error(msg).WithStatus(http.StatusUnprocessableEntity)
+			}
+			if err != nil {
+				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+			}
+		}
 	}
 
 	// validate platform filter
@@ -200,7 +116,7 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 			if account.PlatformFilter != nil {
 				return models.Account{}, keppel.AsRegistryV2Error(errors.New(`platform filter is only allowed on replica accounts`)).WithStatus(http.StatusUnprocessableEntity)
 			}
-		case keppel.FromExternalOnFirstUseStrategy:
+		case keppel.FromExternalOnFirstUseStrategy, keppel.ProxyCacheStrategy:
 			targetAccount.PlatformFilter = account.PlatformFilter
 		case keppel.OnFirstUseStrategy:
 			// for internal replica accounts, the platform filter must match that of the primary account,
@@ -222,7 +138,7 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 		return models.Account{}, keppel.AsRegistryV2Error(errors.New(`cannot change platform filter on existing account`)).WithStatus(http.StatusConflict)
 	}
 
-	rerr := setCustomFields(&targetAccount)
+	rerr = setCustomFields(&targetAccount)
 	if rerr != nil {
 		return models.Account{}, rerr
 	}
@@ -293,6 +209,23 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 			if err != nil {
 				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
 			}
+			p.db.InvalidateCachedAccount(targetAccount.Name)
+		}
+
+		// cached signature verification results are outdated when the set of trusted keys changes
+		if originalAccount.SignaturePublicKeys != targetAccount.SignaturePublicKeys {
+			_, err := p.db.Exec(signatureRecheckAccountQuery, targetAccount.Name)
+			if err != nil {
+				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+			}
+		}
+		// same for attestations (and also when the set of required predicate types changes)
+		if originalAccount.AttestationPublicKeys != targetAccount.AttestationPublicKeys ||
+			originalAccount.RequiredAttestationTypes != targetAccount.RequiredAttestationTypes {
+			_, err := p.db.Exec(attestationRecheckAccountQuery, targetAccount.Name)
+			if err != nil {
+				return models.Account{}, keppel.AsRegistryV2Error(err).WithStatus(http.StatusInternalServerError)
+			}
 		}
 
 		// audit log is necessary for all changes except to InMaintenance
@@ -314,6 +247,168 @@ func (p *Processor) CreateOrUpdateAccount(ctx context.Context, account keppel.Ac
 	return targetAccount, nil
 }
 
+// ApplyAccountConfiguration validates the given API representation of an
+// account and applies it to a copy of the original account model (or to a new
+// account model if originalAccount is nil), as far as this is possible without
+// talking to the federation driver, the storage driver or a peer. This is the
+// first step of CreateOrUpdateAccount(), but it can also be used on its own to
+// preview the effect of an account update. The DB is not modified.
+func (p *Processor) ApplyAccountConfiguration(account keppel.Account, originalAccount *models.Account) (models.Account, keppel.ReplicationStrategy, *keppel.RegistryV2Error) {
+	if account.Name == "" {
+		return models.Account{}, "", keppel.AsRegistryV2Error(ErrAccountNameEmpty)
+	}
+	// reserve identifiers for internal pseudo-accounts and anything that might
+	// appear like the first path element of a legal endpoint path on any of our
+	// APIs (we will soon start recognizing image-like URLs such as
+	// keppel.example.org/account/repo and offer redirection to a suitable UI;
+	// this requires the account name to not overlap with API endpoint paths)
+	if strings.HasPrefix(string(account.Name), "keppel") {
+		return models.Account{}, "", keppel.AsRegistryV2Error(errors.New(`account names with the prefix "keppel" are reserved for internal use`)).WithStatus(http.StatusUnprocessableEntity)
+	}
+	if looksLikeAPIVersionRx.MatchString(string(account.Name)) {
+		return models.Account{}, "", keppel.AsRegistryV2Error(errors.New(`account names that look like API versions (e.g. v1) are reserved for internal use`)).WithStatus(http.StatusUnprocessableEntity)
+	}
+
+	if originalAccount != nil && originalAccount.AuthTenantID != account.AuthTenantID {
+		return models.Account{}, "", keppel.AsRegistryV2Error(errors.New(`account name already in use by a different tenant`)).WithStatus(http.StatusConflict)
+	}
+
+	// PUT can either create a new account or update an existing account;
+	// this distinction is important because several fields can only be set at creation
+	var targetAccount models.Account
+	if originalAccount == nil {
+		targetAccount = models.Account{
+			Name:                     account.Name,
+			AuthTenantID:             account.AuthTenantID,
+			SecurityScanPoliciesJSON: "[]",
+			// all other attributes are set below or in the ApplyToAccount() methods called below
+		}
+	} else {
+		targetAccount = *originalAccount
+	}
+
+	// validate and update fields as requested
+	targetAccount.IsDeleting = account.State == "deleting"
+	targetAccount.InMaintenance = account.InMaintenance
+	targetAccount.IsReadOnly = account.ReadOnly
+
+	// validate GC policies
+	if len(account.GCPolicies) == 0 {
+		targetAccount.GCPoliciesJSON = "[]"
+	} else {
+		for _, policy := range account.GCPolicies {
+			err := policy.Validate()
+			if err != nil {
+				return models.Account{}, "", keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+			}
+			// without pull counting, every image would look like it was never pulled
+			if policy.PullCountConstraint != nil && !p.cfg.ManifestPullCountsEnabled {
+				msg := errors.New(`GC policy pull count constraint requires pull counting, which is not enabled on this registry`)
+				return models.Account{}, "", keppel.AsRegistryV2Error(msg).WithStatus(http.StatusUnprocessableEntity)
+			}
+		}
+		buf, _ := json.Marshal(account.GCPolicies)
+		targetAccount.GCPoliciesJSON = string(buf)
+	}
+
+	// validate replication policy (for OnFirstUseStrategy, the peer hostname is
+	// checked for correctness down below when validating the platform filter)
+	var originalStrategy keppel.ReplicationStrategy
+	if originalAccount != nil {
+		rp := keppel.RenderReplicationPolicy(*originalAccount)
+		if rp == nil {
+			originalStrategy = keppel.NoReplicationStrategy
+		} else {
+			originalStrategy = rp.Strategy
+		}
+	}
+
+	var replicationStrategy keppel.ReplicationStrategy
+	if account.ReplicationPolicy == nil {
+		if originalAccount == nil {
+			replicationStrategy = keppel.NoReplicationStrategy
+		} else {
+			// PUT on existing account can omit replication policy to reuse existing policy
+			replicationStrategy = originalStrategy
+		}
+	} else {
+		// on existing accounts, we do not allow changing the strategy
+		rp := *account.ReplicationPolicy
+		if originalAccount != nil && originalStrategy != rp.Strategy {
+			return models.Account{}, "", keppel.AsRegistryV2Error(keppel.ErrIncompatibleReplicationPolicy).WithStatus(http.StatusConflict)
+		}
+
+		err := rp.ApplyToAccount(&targetAccount)
+		if errors.Is(err, keppel.ErrIncompatibleReplicationPolicy) {
+			return models.Account{}, "", keppel.AsRegistryV2Error(err).WithStatus(http.StatusConflict)
+		} else if err != nil {
+			return models.Account{}, "", keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+		}
+		replicationStrategy = rp.Strategy
+	}
+
+	// validate RBAC policies
+	if len(account.RBACPolicies) == 0 {
+		targetAccount.RBACPoliciesJSON = ""
+	} else {
+		for idx, policy := range account.RBACPolicies {
+			err := policy.ValidateAndNormalize(replicationStrategy)
+			if err != nil {
+				return models.Account{}, "", keppel.AsRegistryV2Error(err).WithStatus(http.StatusUnprocessableEntity)
+			}
+			account.RBACPolicies[idx] = policy
+		}
+		buf, _ := json.Marshal(account.RBACPolicies)
+		targetAccount.RBACPoliciesJSON = string(buf)
+	}
+
+	// validate validation policy
+	if account.ValidationPolicy != nil {
+		rerr := account.ValidationPolicy.ApplyToAccount(&targetAccount)
+		if rerr != nil {
+			return models.Account{}, "", rerr
+		}
+	}
+
+	// apply pull policy
+	if account.PullPolicy != nil {
+		account.PullPolicy.ApplyToAccount(&targetAccount)
+	}
+
+	// validate quarantine policy (promotion out of quarantine is decided by the
+	// vulnerability scan, so this only makes sense if a scanner is configured)
+	if account.QuarantinePolicy != nil {
+		if account.QuarantinePolicy.SeverityThreshold != "" && p.cfg.Scanner == nil {
+			msg := errors.New(`quarantine policy requires vulnerability scanning, which is not enabled on this registry`)
+			return models.Account{}, "", keppel.AsRegistryV2Error(msg).WithStatus(http.StatusUnprocessableEntity)
+		}
+		rerr := account.QuarantinePolicy.ApplyToAccount(&targetAccount)
+		if rerr != nil {
+			return models.Account{}, "", rerr
+		}
+	}
+
+	// validate image transformations
+	if account.ImageTransformations != nil {
+		rerr := keppel.ApplyImageTransformationsToAccount(account.ImageTransformations, &targetAccount)
+		if rerr != nil {
+			return models.Account{}, "", rerr
+		}
+	}
+
+	return targetAccount, replicationStrategy, nil
+}
+
+var signatureRecheckAccountQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET next_signature_check_at = NULL
+	 WHERE repo_id IN (SELECT id FROM repos WHERE account_name = $1)
+`)
+
+var attestationRecheckAccountQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET next_attestation_check_at = NULL
+	 WHERE repo_id IN (SELECT id FROM repos WHERE account_name = $1)
+`)
+
 var (
 	markAccountForDeletion = `UPDATE accounts SET is_deleting = TRUE, next_deletion_attempt_at = $1 WHERE name = $2`
 )
@@ -323,6 +418,7 @@ func (p *Processor) MarkAccountForDeletion(account models.Account, actx keppel.A
 	if err != nil {
 		return err
 	}
+	p.db.InvalidateCachedAccount(account.Name)
 
 	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
 		p.auditor.Record(audittools.Event{
@@ -337,3 +433,70 @@ func (p *Processor) MarkAccountForDeletion(account models.Account, actx keppel.A
 
 	return nil
 }
+
+var (
+	scheduleAccountDeletionQuery = `UPDATE accounts SET deletion_scheduled_at = $1 WHERE name = $2 AND NOT is_deleting`
+	cancelAccountDeletionQuery   = sqlext.SimplifyWhitespace(`
+		UPDATE accounts SET deletion_scheduled_at = NULL, is_managed = FALSE
+		 WHERE name = $1 AND deletion_scheduled_at IS NOT NULL AND NOT is_deleting
+	`)
+)
+
+// ScheduleAccountDeletion records that the given managed account shall be
+// marked for deletion at the given time, unless the scheduled deletion is
+// cancelled before then.
+func (p *Processor) ScheduleAccountDeletion(account models.Account, deleteAt time.Time, actx keppel.AuditContext) error {
+	_, err := p.db.Exec(scheduleAccountDeletionQuery, deleteAt, account.Name)
+	if err != nil {
+		return err
+	}
+
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		account.DeletionScheduledAt = &deleteAt
+		p.auditor.Record(audittools.Event{
+			Time:       p.timeNow(),
+			Request:    actx.Request,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     cadf.UpdateAction,
+			Target:     AuditAccount{Account: account},
+		})
+	}
+
+	return nil
+}
+
+// CancelScheduledAccountDeletion cancels a deletion that was scheduled by
+// ScheduleAccountDeletion. Since the account is not in the account management
+// driver's configuration anymore, it is converted into an unmanaged account.
+// It becomes managed again if it reappears in the configuration.
+//
+// Returns sql.ErrNoRows if no deletion is scheduled for this account.
+func (p *Processor) CancelScheduledAccountDeletion(account models.Account, actx keppel.AuditContext) error {
+	result, err := p.db.Exec(cancelAccountDeletionQuery, account.Name)
+	if err != nil {
+		return err
+	}
+	rowsUpdated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsUpdated == 0 {
+		return sql.ErrNoRows
+	}
+
+	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
+		account.DeletionScheduledAt = nil
+		account.IsManaged = false
+		p.auditor.Record(audittools.Event{
+			Time:       p.timeNow(),
+			Request:    actx.Request,
+			User:       userInfo,
+			ReasonCode: http.StatusOK,
+			Action:     cadf.UpdateAction,
+			Target:     AuditAccount{Account: account},
+		})
+	}
+
+	return nil
+}
@@ -21,6 +21,7 @@ package processor
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/sapcc/go-api-declarations/cadf"
 	"github.com/sapcc/go-bits/must"
@@ -53,9 +54,19 @@ func (a AuditAccount) Render() cadf.Resource {
 		res.Attachments = append(res.Attachments, attachment)
 	}
 
+	if a.Account.DeletionScheduledAt != nil {
+		attachment := must.Return(cadf.NewJSONAttachment("deletion-scheduled-at", a.Account.DeletionScheduledAt.UTC().Format(time.RFC3339)))
+		res.Attachments = append(res.Attachments, attachment)
+	}
+
 	return res
 }
 
+// AuditAccountName implements the keppel.AccountScopedAuditTarget interface.
+func (a AuditAccount) AuditAccountName() models.AccountName {
+	return a.Account.Name
+}
+
 // AuditQuotas is an audittools.Target.
 type AuditQuotas struct {
 	QuotasBefore models.Quotas
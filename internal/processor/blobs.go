@@ -33,8 +33,11 @@ import (
 	"github.com/go-gorp/gorp/v3"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/logg"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/sapcc/keppel/internal/api"
+	"github.com/sapcc/keppel/internal/auth"
+	peerclient "github.com/sapcc/keppel/internal/client/peer"
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 )
@@ -48,7 +51,7 @@ func (p *Processor) ValidateExistingBlob(ctx context.Context, account models.Red
 		return fmt.Errorf("cannot parse blob digest: %s", err.Error())
 	}
 
-	readCloser, _, err := p.sd.ReadBlob(ctx, account, blob.StorageID)
+	readCloser, _, err := p.sd.ReadBlob(ctx, p.cfg.BlobStorageLocation(account, blob), blob.StorageID)
 	if err != nil {
 		return err
 	}
@@ -133,6 +136,12 @@ var (
 // this happened. It may be false if an error occurred before writing into the
 // ResponseWriter took place.
 func (p *Processor) ReplicateBlob(ctx context.Context, blob models.Blob, account models.ReducedAccount, repo models.Repository, w http.ResponseWriter) (responseWasWritten bool, returnErr error) {
+	ctx, endSpan := keppel.StartSpan(ctx, "processor.ReplicateBlob",
+		attribute.String("keppel.repository", repo.FullName()),
+		attribute.String("keppel.blob.digest", blob.Digest.String()),
+	)
+	defer endSpan(&returnErr)
+
 	// mark this blob as currently being replicated
 	pendingBlob := models.PendingBlob{
 		AccountName:  account.Name,
@@ -153,6 +162,12 @@ func (p *Processor) ReplicateBlob(ctx context.Context, blob models.Blob, account
 		return false, err
 	}
 
+	// count this replication attempt (only from this point on, since
+	// ErrConcurrentReplication does not indicate a failed replication)
+	defer func() {
+		countReplication(account, replicationKindBlob, blob.SizeBytes, returnErr)
+	}()
+
 	// whatever happens, don't forget to cleanup the PendingBlob DB entry afterwards
 	// to unblock others who are waiting for this replication to come to an end
 	// (one way or the other)
@@ -166,12 +181,28 @@ func (p *Processor) ReplicateBlob(ctx context.Context, blob models.Blob, account
 		}
 	}()
 
+	// if nobody is waiting for the blob contents, we may be able to skip the
+	// download entirely
+	if w == nil {
+		ok, err := p.copyBlobFromPeerStorage(ctx, blob, account)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			l := prometheus.Labels{"account": string(account.Name), "auth_tenant_id": account.AuthTenantID, "method": "replication"}
+			api.BlobsPushedCounter.With(l).Inc()
+			return false, nil
+		}
+	}
+
 	// query upstream for the blob
-	client, err := p.getRepoClientForUpstream(account, repo)
+	client, err := p.getRepoClientForUpstream(ctx, account, repo)
 	if err != nil {
 		return false, err
 	}
+	startedAt := time.Now()
 	blobReadCloser, blobLengthBytes, err := client.DownloadBlob(ctx, blob.Digest)
+	observeUpstreamRequest(account, client.Host, replicationKindBlob, startedAt, err)
 	if err != nil {
 		return false, err
 	}
@@ -198,6 +229,72 @@ func (p *Processor) ReplicateBlob(ctx context.Context, blob models.Blob, account
 	return true, nil
 }
 
+// Implements a fast path for ReplicateBlob() when the upstream is a peer that
+// uses the same storage backend as we do: Instead of downloading the blob, we
+// ask the peer where the blob is located in the storage, and have our storage
+// driver copy it from there. Returns false if this fast path cannot be used,
+// in which case the caller shall fall back to downloading the blob.
+func (p *Processor) copyBlobFromPeerStorage(ctx context.Context, blob models.Blob, account models.ReducedAccount) (ok bool, returnErr error) {
+	if !p.cfg.PeersShareStorage || account.UpstreamPeerHostName == "" {
+		return false, nil
+	}
+	bcsd, ok := keppel.AsBlobCopyingStorageDriver(p.sd)
+	if !ok {
+		return false, nil
+	}
+
+	var peer models.Peer
+	err := p.db.SelectOne(&peer, `SELECT * FROM peers WHERE hostname = $1`, account.UpstreamPeerHostName)
+	if err != nil {
+		return false, err
+	}
+	peerClient, err := peerclient.New(ctx, p.cfg, peer, auth.PeerAPIScope)
+	if err != nil {
+		return false, err
+	}
+	ok, err = peerClient.HasCapability(ctx, keppel.PeerCapabilityBlobLocation)
+	if err != nil || !ok {
+		return false, err
+	}
+	location, err := peerClient.GetBlobLocation(ctx, account.Name, blob.Digest)
+	if err != nil || location == "" {
+		return false, err
+	}
+
+	storageID := p.generateStorageID()
+	sizeBytes, err := bcsd.CopyBlobFromLocation(ctx, account, storageID, location)
+	if errors.Is(err, keppel.ErrCannotCopyBlob) {
+		logg.Info("cannot copy blob %s into account %s from %s, will download it instead", blob.Digest, account.Name, location)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// if errors occur from here on, we need to clean up the blob in the storage
+	defer func() {
+		if returnErr != nil {
+			deleteErr := p.sd.DeleteBlob(ctx, account, storageID)
+			if deleteErr != nil {
+				logg.Error("additional error encountered when deleting copied blob %s from account %s after replication error: %s",
+					storageID, account.Name, deleteErr.Error())
+			}
+		}
+	}()
+	if sizeBytes != blob.SizeBytes {
+		return false, fmt.Errorf("while copying blob %s from %s: expected %d bytes, but got %d bytes",
+			blob.Digest, location, blob.SizeBytes, sizeBytes)
+	}
+
+	// write blob metadata to DB
+	blob.StorageID = storageID
+	blob.PushedAt = p.timeNow()
+	blob.NextValidationAt = blob.PushedAt.Add(models.BlobValidationInterval)
+	blob.NextPrefetchAt = nil
+	_, err = p.db.Update(&blob)
+	return err == nil, err
+}
+
 func (p *Processor) uploadBlobToLocal(ctx context.Context, blob models.Blob, account models.ReducedAccount, blobReader io.Reader, blobLengthBytes uint64) (returnErr error) {
 	defer func() {
 		// if blob upload fails, count an aborted upload
@@ -247,10 +344,93 @@ func (p *Processor) uploadBlobToLocal(ctx context.Context, blob models.Blob, acc
 	blob.StorageID = upload.StorageID
 	blob.PushedAt = p.timeNow()
 	blob.NextValidationAt = blob.PushedAt.Add(models.BlobValidationInterval)
+	blob.NextPrefetchAt = nil
 	_, err = p.db.Update(&blob)
 	return err
 }
 
+// MountBlobFromForeignAccount makes the given blob from a different account
+// available in the target repository. If the target account already has a blob
+// with the same digest, that blob is mounted. Otherwise, the blob contents are
+// copied from the source account's storage into the target account's storage,
+// so that the client does not have to upload them again.
+//
+// The caller is responsible for checking that the user is allowed to pull the
+// blob from the source account.
+func (p *Processor) MountBlobFromForeignAccount(ctx context.Context, sourceBlob models.Blob, sourceAccount, targetAccount models.ReducedAccount, targetRepo models.Repository) (returnErr error) {
+	if sourceBlob.StorageID == "" {
+		// the blob was not replicated into the source account yet, so we cannot copy it from there
+		return keppel.ErrBlobUnknown.With("blob does not exist in source repository")
+	}
+
+	// fast path: the blob already exists in the target account
+	blob, err := keppel.FindBlobByAccountName(p.db, sourceBlob.Digest, targetAccount.Name)
+	switch {
+	case err == nil && blob.StorageID != "":
+		return keppel.MountBlobIntoRepo(p.db, *blob, targetRepo)
+	case err == nil:
+		// an unbacked blob can only exist in replica accounts, which cannot be pushed into
+		return keppel.ErrUnsupported.With("cannot mount into blob that is pending replication")
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	// slow path: copy blob contents between accounts
+	readCloser, sizeBytes, err := p.sd.ReadBlob(ctx, p.cfg.BlobStorageLocation(sourceAccount, sourceBlob), sourceBlob.StorageID)
+	if err != nil {
+		return err
+	}
+	defer readCloser.Close()
+
+	upload := models.Upload{
+		StorageID: p.generateStorageID(),
+		SizeBytes: 0,
+		NumChunks: 0,
+	}
+	err = p.AppendToBlob(ctx, targetAccount, &upload, readCloser, &sizeBytes)
+	if err == nil {
+		err = p.sd.FinalizeBlob(ctx, targetAccount, upload.StorageID, upload.NumChunks)
+	}
+	if err != nil {
+		abortErr := p.sd.AbortBlobUpload(ctx, targetAccount, upload.StorageID, upload.NumChunks)
+		if abortErr != nil {
+			logg.Error("additional error encountered when aborting upload %s into account %s: %s",
+				upload.StorageID, targetAccount.Name, abortErr.Error())
+		}
+		return err
+	}
+
+	// if errors occur while trying to update the DB, we need to clean up the blob in the storage
+	defer func() {
+		if returnErr != nil {
+			deleteErr := p.sd.DeleteBlob(ctx, targetAccount, upload.StorageID)
+			if deleteErr != nil {
+				logg.Error("additional error encountered when deleting copied blob %s from account %s after mount error: %s",
+					upload.StorageID, targetAccount.Name, deleteErr.Error())
+			}
+		}
+	}()
+
+	// write blob metadata to DB
+	return p.insideTransaction(ctx, func(ctx context.Context, tx *gorp.Transaction) error {
+		now := p.timeNow()
+		blob := models.Blob{
+			AccountName:      targetAccount.Name,
+			Digest:           sourceBlob.Digest,
+			SizeBytes:        upload.SizeBytes,
+			StorageID:        upload.StorageID,
+			MediaType:        sourceBlob.MediaType,
+			PushedAt:         now,
+			NextValidationAt: now.Add(models.BlobValidationInterval),
+		}
+		err := tx.Insert(&blob)
+		if err != nil {
+			return err
+		}
+		return keppel.MountBlobIntoRepo(tx, blob, targetRepo)
+	})
+}
+
 // AppendToBlob appends bytes to a blob upload, and updates the upload's
 // SizeBytes and NumChunks fields appropriately. Chunking of large uploads is
 // implemented at this level, to accommodate storage drivers that have a size
@@ -260,12 +440,19 @@ func (p *Processor) uploadBlobToLocal(ctx context.Context, blob models.Blob, acc
 // uploads, the caller is responsible for performing and validating the digest
 // computation.
 func (p *Processor) AppendToBlob(ctx context.Context, account models.ReducedAccount, upload *models.Upload, contents io.Reader, lengthBytes *uint64) error {
+	return appendToBlobIn(ctx, p.sd, account, upload, contents, lengthBytes)
+}
+
+// appendToBlobIn is the implementation of AppendToBlob. It takes the storage
+// driver as an argument to allow writing into a storage driver other than
+// p.sd (see MigrateAccountStorage).
+func appendToBlobIn(ctx context.Context, sd keppel.StorageDriver, account models.ReducedAccount, upload *models.Upload, contents io.Reader, lengthBytes *uint64) error {
 	// case 1: we know the length of the input and don't have to guess when to chunk
 	if lengthBytes != nil {
 		return foreachChunkWithKnownSize(contents, *lengthBytes, func(chunk io.Reader, chunkLengthBytes uint64) error {
 			upload.NumChunks++
 			upload.SizeBytes += chunkLengthBytes
-			return p.sd.AppendToBlob(ctx, account, upload.StorageID, upload.NumChunks, &chunkLengthBytes, chunk)
+			return sd.AppendToBlob(ctx, account, upload.StorageID, upload.NumChunks, &chunkLengthBytes, chunk)
 		})
 	}
 
@@ -273,7 +460,7 @@ func (p *Processor) AppendToBlob(ctx context.Context, account models.ReducedAcco
 	ctr := chunkingTrackingReader{wrapped: contents}
 	err := foreachChunkWithUnknownSize(&ctr, func(chunk io.Reader) error {
 		upload.NumChunks++
-		return p.sd.AppendToBlob(ctx, account, upload.StorageID, upload.NumChunks, nil, chunk)
+		return sd.AppendToBlob(ctx, account, upload.StorageID, upload.NumChunks, nil, chunk)
 	})
 	upload.SizeBytes += ctr.bytesRead
 	return err
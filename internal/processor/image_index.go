@@ -0,0 +1,107 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package processor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ImageIndexEntry appears in AssembleImageIndex(). It identifies one of the
+// image manifests that shall be referenced by the image index.
+type ImageIndexEntry struct {
+	Digest   digest.Digest      `json:"digest"`
+	Platform imgspecv1.Platform `json:"platform"`
+}
+
+// AssembleImageIndex builds an OCI image index that references the given
+// image manifests, which must already exist in the given repo. The image
+// index is stored through ValidateAndStoreManifest(), so it undergoes the
+// same validation as if it had been pushed by the client. If `tagName` is not
+// empty, the image index is tagged with it.
+func (p *Processor) AssembleImageIndex(ctx context.Context, account models.ReducedAccount, repo models.Repository, entries []ImageIndexEntry, tagName string, actx keppel.AuditContext) (*models.Manifest, error) {
+	if len(entries) == 0 {
+		return nil, keppel.ErrManifestInvalid.With("cannot assemble image index without any manifests").WithStatus(http.StatusUnprocessableEntity)
+	}
+
+	index := imgspecv1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: make([]imgspecv1.Descriptor, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		if entry.Platform.OS == "" || entry.Platform.Architecture == "" {
+			return nil, keppel.ErrManifestInvalid.With(`platform for manifest %s must have at least "os" and "architecture"`, entry.Digest).WithStatus(http.StatusUnprocessableEntity)
+		}
+
+		manifest, err := keppel.FindManifest(p.db, repo, entry.Digest)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, keppel.ErrManifestUnknown.With("manifest %s does not exist in this repository", entry.Digest).WithStatus(http.StatusUnprocessableEntity)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if manifest.MediaType != schema2.MediaTypeManifest && manifest.MediaType != imgspecv1.MediaTypeImageManifest {
+			return nil, keppel.ErrManifestInvalid.With("manifest %s has media type %s, but only image manifests can be referenced by an image index", entry.Digest, manifest.MediaType).WithStatus(http.StatusUnprocessableEntity)
+		}
+
+		// the descriptor needs the size of the manifest itself, not the size of the image
+		var contentSize int64
+		err = p.db.QueryRow(`SELECT LENGTH(content) FROM manifest_contents WHERE repo_id = $1 AND digest = $2`, repo.ID, manifest.Digest).Scan(&contentSize)
+		if err != nil {
+			return nil, fmt.Errorf("cannot determine size of manifest %s: %w", manifest.Digest, err)
+		}
+
+		platform := entry.Platform
+		index.Manifests = append(index.Manifests, imgspecv1.Descriptor{
+			MediaType: manifest.MediaType,
+			Digest:    manifest.Digest,
+			Size:      contentSize,
+			Platform:  &platform,
+		})
+	}
+
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	ref := models.ManifestReference{Digest: digest.Canonical.FromBytes(indexBytes)}
+	if tagName != "" {
+		ref = models.ManifestReference{Tag: tagName}
+	}
+	return p.ValidateAndStoreManifest(ctx, account, repo, IncomingManifest{
+		Reference: ref,
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Contents:  indexBytes,
+		PushedAt:  p.timeNow(),
+	}, actx)
+}
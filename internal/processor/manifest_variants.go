@@ -0,0 +1,234 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/squash"
+)
+
+// GenerateManifestVariant applies the given transformation to the given
+// manifest, and stores the resulting manifest in the same repository. The
+// caller is responsible for recording the result in the `manifest_variants`
+// table.
+func (p *Processor) GenerateManifestVariant(ctx context.Context, account models.ReducedAccount, repo models.Repository, source models.Manifest, transformation models.ImageTransformation, actx keppel.AuditContext) (*models.Manifest, error) {
+	var manifestBytes []byte
+	err := p.db.SelectOne(&manifestBytes, `SELECT content FROM manifest_contents WHERE repo_id = $1 AND digest = $2`, repo.ID, source.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load manifest %s: %w", source.Digest, err)
+	}
+	parsed, _, err := keppel.ParseManifest(source.MediaType, manifestBytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse manifest %s: %w", source.Digest, err)
+	}
+
+	var variantBytes []byte
+	switch transformation {
+	case models.SquashTransformation:
+		variantBytes, err = p.squashManifest(ctx, account, repo, source, manifestBytes, parsed)
+	default:
+		err = fmt.Errorf("unknown image transformation: %q", transformation)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return p.ValidateAndStoreManifest(ctx, account, repo, IncomingManifest{
+		Reference: models.ManifestReference{Digest: digest.Canonical.FromBytes(variantBytes)},
+		MediaType: source.MediaType,
+		Contents:  variantBytes,
+		PushedAt:  p.timeNow(),
+	}, actx)
+}
+
+// Implementation of GenerateManifestVariant for models.SquashTransformation.
+// Returns the contents of the squashed manifest. All blobs referenced by it
+// have been stored in the repo when this returns successfully.
+func (p *Processor) squashManifest(ctx context.Context, account models.ReducedAccount, repo models.Repository, source models.Manifest, manifestBytes []byte, parsed keppel.ParsedManifest) ([]byte, error) {
+	var layerMediaType string
+	switch source.MediaType {
+	case schema2.MediaTypeManifest:
+		layerMediaType = schema2.MediaTypeLayer
+	case imgspecv1.MediaTypeImageManifest:
+		layerMediaType = imgspecv1.MediaTypeImageLayerGzip
+	default:
+		return nil, fmt.Errorf("cannot squash manifests of type %s", source.MediaType)
+	}
+	configDesc := parsed.FindImageConfigBlob()
+	layerDescs := parsed.FindImageLayerBlobs()
+	if configDesc == nil || len(layerDescs) == 0 {
+		return nil, errors.New("cannot squash manifests without image config or layers")
+	}
+
+	// squash layers into a temporary file (layers can be too large to hold them in memory)
+	openers := make([]squash.LayerOpener, len(layerDescs))
+	for idx, desc := range layerDescs {
+		openers[idx] = func() (io.ReadCloser, error) {
+			return p.openUncompressedLayer(ctx, account, repo, desc)
+		}
+	}
+	tmpFile, err := os.CreateTemp("", "keppel-squash-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	compressedHash := sha256.New()
+	uncompressedHash := sha256.New()
+	gzw := gzip.NewWriter(io.MultiWriter(tmpFile, compressedHash))
+	err = squash.Layers(openers, io.MultiWriter(gzw, uncompressedHash))
+	if err == nil {
+		err = gzw.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while squashing layers: %w", err)
+	}
+	layerSize, err := tmpFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tmpFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	layerDesc := distribution.Descriptor{
+		MediaType: layerMediaType,
+		Digest:    digest.NewDigest(digest.SHA256, compressedHash),
+		Size:      layerSize,
+	}
+	err = p.storeGeneratedBlob(ctx, account, repo, layerDesc, tmpFile)
+	if err != nil {
+		return nil, fmt.Errorf("while storing squashed layer: %w", err)
+	}
+
+	// rewrite the image config to describe the squashed layer (all other fields
+	// are retained as they are, so we unmarshal into a generic map)
+	configBytes, err := p.readBlobInRepo(ctx, account, repo, configDesc.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read image config: %w", err)
+	}
+	var config map[string]any
+	err = json.Unmarshal(configBytes, &config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse image config: %w", err)
+	}
+	config["rootfs"] = map[string]any{
+		"type":     "layers",
+		"diff_ids": []digest.Digest{digest.NewDigest(digest.SHA256, uncompressedHash)},
+	}
+	config["history"] = []map[string]any{{
+		"created":    p.timeNow().UTC().Format(time.RFC3339),
+		"created_by": "keppel",
+		"comment":    fmt.Sprintf("squashed from %s", source.Digest),
+	}}
+	configBytes, err = json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	newConfigDesc := distribution.Descriptor{
+		MediaType: configDesc.MediaType,
+		Digest:    digest.Canonical.FromBytes(configBytes),
+		Size:      int64(len(configBytes)),
+	}
+	err = p.storeGeneratedBlob(ctx, account, repo, newConfigDesc, bytes.NewReader(configBytes))
+	if err != nil {
+		return nil, fmt.Errorf("while storing image config: %w", err)
+	}
+
+	// rewrite the manifest in the same way
+	var manifest map[string]any
+	err = json.Unmarshal(manifestBytes, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	manifest["config"] = newConfigDesc
+	manifest["layers"] = []distribution.Descriptor{layerDesc}
+	return json.Marshal(manifest)
+}
+
+// Returns a reader for the uncompressed contents of the given layer.
+func (p *Processor) openUncompressedLayer(ctx context.Context, account models.ReducedAccount, repo models.Repository, desc distribution.Descriptor) (io.ReadCloser, error) {
+	compression, ok := keppel.LayerCompressionForMediaType(desc.MediaType)
+	if !ok {
+		return nil, fmt.Errorf("cannot squash layers of type %s", desc.MediaType)
+	}
+
+	blob, err := keppel.FindBlobByRepository(p.db, desc.Digest, repo)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find layer %s: %w", desc.Digest, err)
+	}
+	reader, _, err := p.sd.ReadBlob(ctx, p.cfg.BlobStorageLocation(account, *blob), blob.StorageID)
+	if err != nil {
+		return nil, err
+	}
+	result, err := compression.Decompress(reader)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("cannot decompress layer %s: %w", desc.Digest, err)
+	}
+	return result, nil
+}
+
+func (p *Processor) readBlobInRepo(ctx context.Context, account models.ReducedAccount, repo models.Repository, blobDigest digest.Digest) ([]byte, error) {
+	blob, err := keppel.FindBlobByRepository(p.db, blobDigest, repo)
+	if err != nil {
+		return nil, err
+	}
+	reader, _, err := p.sd.ReadBlob(ctx, p.cfg.BlobStorageLocation(account, *blob), blob.StorageID)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Stores a blob that was generated by Keppel itself (rather than being pushed
+// by a client) in the given repo, unless the account already has it.
+func (p *Processor) storeGeneratedBlob(ctx context.Context, account models.ReducedAccount, repo models.Repository, desc distribution.Descriptor, contents io.Reader) error {
+	blob, err := p.FindBlobOrInsertUnbackedBlob(ctx, desc, account.Name)
+	if err != nil {
+		return err
+	}
+	if blob.StorageID == "" {
+		err = p.uploadBlobToLocal(ctx, *blob, account, contents, keppel.AtLeastZero(desc.Size))
+		if err != nil {
+			return err
+		}
+	}
+	return keppel.MountBlobIntoRepo(p.db, *blob, repo)
+}
@@ -40,6 +40,7 @@ import (
 	"github.com/sapcc/go-bits/errext"
 	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/go-bits/sqlext"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/sapcc/keppel/internal/auth"
 	"github.com/sapcc/keppel/internal/client"
@@ -73,6 +74,10 @@ func (p *Processor) ValidateAndStoreManifest(ctx context.Context, account models
 	// the actual upsert, so results should be taken with a grain of salt; but the
 	// result is accurate enough to avoid most duplicate audit events
 	contentsDigest := digest.Canonical.FromBytes(m.Contents)
+	err := keppel.CheckDigestNotBlocked(p.db, account.Name, contentsDigest)
+	if err != nil {
+		return nil, err
+	}
 	manifestExistsAlready, err := p.db.SelectBool(checkManifestExistsQuery, repo.ID, contentsDigest.String())
 	if err != nil {
 		return nil, err
@@ -103,6 +108,11 @@ func (p *Processor) ValidateAndStoreManifest(ctx context.Context, account models
 		PushedAt:         m.PushedAt,
 		NextValidationAt: m.PushedAt.Add(models.ManifestValidationInterval),
 	}
+	if account.IsQuarantineEnabled() {
+		// this only takes effect if the manifest gets inserted; for existing
+		// manifests, the existing quarantine status is retained
+		manifest.QuarantineStatus = models.QuarantinePending
+	}
 	if m.Reference.IsDigest() {
 		// allow validateAndStoreManifestCommon() to validate the user-supplied
 		// digest against the actual manifest data
@@ -110,9 +120,21 @@ func (p *Processor) ValidateAndStoreManifest(ctx context.Context, account models
 	}
 	err = p.validateAndStoreManifestCommon(ctx, account, repo, manifest, m.Contents, validateAndStoreManifestOpts{
 		IsBeingPushed: true,
+		TagName:       m.Reference.Tag,
+		UserName:      actx.UserIdentity.UserName(),
 		ActionBeforeCommit: func(tx *gorp.Transaction) error {
-			if m.Reference.IsTag() {
-				err = upsertTag(tx, models.Tag{
+			// tags for manifests in quarantine are held back until the manifest is promoted
+			quarantineStatus, err := tx.SelectStr(`SELECT quarantine_status FROM manifests WHERE repo_id = $1 AND digest = $2`, repo.ID, manifest.Digest)
+			if err != nil {
+				return err
+			}
+			manifest.QuarantineStatus = models.QuarantineStatus(quarantineStatus)
+			if manifest.QuarantineStatus == models.QuarantineRejected {
+				return keppel.ErrDenied.With("manifest was rejected by the vulnerability scan required by the quarantine policy").WithStatus(http.StatusForbidden)
+			}
+
+			if m.Reference.IsTag() && !manifest.QuarantineStatus.IsPullable() {
+				err = upsertQuarantinedTag(tx, models.QuarantinedTag{
 					RepositoryID: repo.ID,
 					Name:         m.Reference.Tag,
 					Digest:       manifest.Digest,
@@ -121,6 +143,41 @@ func (p *Processor) ValidateAndStoreManifest(ctx context.Context, account models
 				if err != nil {
 					return err
 				}
+			} else if m.Reference.IsTag() {
+				err = upsertTag(tx, models.Tag{
+					RepositoryID: repo.ID,
+					Name:         m.Reference.Tag,
+					Digest:       manifest.Digest,
+					PushedAt:     m.PushedAt,
+				}, actx.UserIdentity.UserName())
+				if err != nil {
+					return err
+				}
+
+				// when a cosign signature is pushed, the signed manifest shall be re-verified promptly
+				signedDigest, isSignature := keppel.ParseCosignSignatureTagName(m.Reference.Tag)
+				if isSignature && account.RequiresSignature() {
+					_, err = tx.Exec(signatureRecheckQuery, repo.ID, signedDigest)
+					if err != nil {
+						return err
+					}
+				}
+				// same for cosign attestations
+				attestedDigest, isAttestation := keppel.ParseCosignAttestationTagName(m.Reference.Tag)
+				if isAttestation && account.RequiredAttestationTypes != "" {
+					_, err = tx.Exec(attestationRecheckQuery, repo.ID, attestedDigest)
+					if err != nil {
+						return err
+					}
+				}
+				// when an index of referrers is pushed under a referrers fallback tag, record the referrers as such
+				subjectDigest, isReferrersFallbackTag := keppel.ParseReferrersFallbackTagName(m.Reference.Tag)
+				if isReferrersFallbackTag && p.cfg.NormalizeReferrersFallbackTags {
+					err = backfillReferrersForTag(tx, repo, m.Reference.Tag, subjectDigest)
+					if err != nil {
+						return err
+					}
+				}
 			}
 
 			// after making all DB changes, but before committing the DB transaction,
@@ -182,6 +239,9 @@ func (p *Processor) ValidateExistingManifest(ctx context.Context, account models
 type validateAndStoreManifestOpts struct {
 	IsBeingPushed      bool // only set when the manifest is pushed, not when it is later validated
 	ActionBeforeCommit func(*gorp.Transaction) error
+	// only used when IsBeingPushed (for the request to cfg.ManifestValidator)
+	TagName  string
+	UserName string
 }
 
 func (p *Processor) validateAndStoreManifestCommon(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifest *models.Manifest, manifestBytes []byte, opts validateAndStoreManifestOpts) error {
@@ -205,6 +265,10 @@ func (p *Processor) validateAndStoreManifestCommon(ctx context.Context, account
 	for _, desc := range manifestParsed.BlobReferences() {
 		manifest.SizeBytes += keppel.AtLeastZero(desc.Size)
 	}
+	err = parseManifestArtifactMetadata(manifest, manifestBytes)
+	if err != nil {
+		return keppel.ErrManifestInvalid.With(err.Error())
+	}
 
 	return p.insideTransaction(ctx, func(ctx context.Context, tx *gorp.Transaction) error {
 		refsInfo, err := findManifestReferencedObjects(tx, account, repo, manifestParsed)
@@ -213,7 +277,7 @@ func (p *Processor) validateAndStoreManifestCommon(ctx context.Context, account
 		}
 		manifest.SizeBytes += refsInfo.SumChildSizes
 
-		configInfo, err := parseManifestConfig(ctx, tx, p.sd, account, manifestParsed)
+		configInfo, err := parseManifestConfig(ctx, tx, p.cfg, p.sd, account, manifestParsed)
 		if err != nil {
 			return err
 		}
@@ -221,12 +285,25 @@ func (p *Processor) validateAndStoreManifestCommon(ctx context.Context, account
 		// enforce account-specific validation rules on manifest, but not list manifest
 		// and only when pushing (not when validating at a later point in time,
 		// the set of RequiredLabels could have been changed by then)
+		if opts.IsBeingPushed && account.RejectEmptyConfigArtifacts && manifestParsed.HasEmptyConfig() {
+			return keppel.ErrManifestInvalid.With("artifacts with empty config are not allowed in this account")
+		}
 		labelsRequired := opts.IsBeingPushed && account.RequiredLabels != "" &&
 			manifest.MediaType != manifestlist.MediaTypeManifestList && manifest.MediaType != imagespec.MediaTypeImageIndex
 		if labelsRequired {
+			// artifacts with empty config cannot have labels, so we look for the
+			// required labels in their annotations instead
+			labels := configInfo.Labels
+			if manifestParsed.HasEmptyConfig() {
+				labels, err = manifest.ParseAnnotations()
+				if err != nil {
+					return err
+				}
+			}
+
 			var missingLabels []string
 			for _, l := range account.SplitRequiredLabels() {
-				if _, exists := configInfo.Labels[l]; !exists {
+				if _, exists := labels[l]; !exists {
 					missingLabels = append(missingLabels, l)
 				}
 			}
@@ -236,6 +313,15 @@ func (p *Processor) validateAndStoreManifestCommon(ctx context.Context, account
 			}
 		}
 
+		// same for the account-specific restriction of media types, except that
+		// this one applies to list manifests as well
+		if opts.IsBeingPushed && account.AllowedMediaTypes != "" {
+			err := checkAllowedMediaTypes(account, *manifest, manifestParsed)
+			if err != nil {
+				return err
+			}
+		}
+
 		// for plain manifests, we report the labels from the manifest config; for
 		// list manifests (which do not have a config), we instead report all the
 		// labels that the constituent manifests agree on
@@ -253,6 +339,25 @@ func (p *Processor) validateAndStoreManifestCommon(ctx context.Context, account
 			manifest.LabelsJSON = ""
 		}
 
+		// the external policy service (if any) goes last, so that it only sees
+		// manifests that passed all of Keppel's own validations
+		if opts.IsBeingPushed && p.cfg.ManifestValidator != nil {
+			err := p.checkManifestWithValidationDriver(ctx, keppel.ManifestValidationRequest{
+				Account:        account,
+				Repository:     repo,
+				TagName:        opts.TagName,
+				Digest:         manifest.Digest,
+				MediaType:      manifest.MediaType,
+				Contents:       manifestBytes,
+				ConfigContents: configInfo.Contents,
+				Labels:         reportedLabels,
+				UserName:       opts.UserName,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
 		manifest.MinLayerCreatedAt = keppel.MinMaybeTime(refsInfo.MinCreationTime, configInfo.MinCreationTime)
 		manifest.MaxLayerCreatedAt = keppel.MaxMaybeTime(refsInfo.MaxCreationTime, configInfo.MaxCreationTime)
 
@@ -280,6 +385,41 @@ func (p *Processor) validateAndStoreManifestCommon(ctx context.Context, account
 	})
 }
 
+// Asks cfg.ManifestValidator whether the given manifest may be stored.
+func (p *Processor) checkManifestWithValidationDriver(ctx context.Context, req keppel.ManifestValidationRequest) error {
+	verdict, err := p.cfg.ManifestValidator.ValidateManifest(ctx, req)
+	if err != nil {
+		return fmt.Errorf("could not validate manifest with %s validation driver: %w", p.cfg.ManifestValidator.PluginTypeID(), err)
+	}
+	if !verdict.Allowed {
+		msg := "manifest was rejected by validation policy"
+		if verdict.Reason != "" {
+			msg += ": " + verdict.Reason
+		}
+		return keppel.ErrDenied.With(msg).WithStatus(http.StatusForbidden)
+	}
+	return nil
+}
+
+// Checks the media types of the manifest itself, its artifact type and its
+// referenced blobs against the account's list of allowed media types.
+func checkAllowedMediaTypes(account models.ReducedAccount, manifest models.Manifest, manifestParsed keppel.ParsedManifest) error {
+	mediaTypes := []string{manifest.MediaType}
+	if manifest.ArtifactType != "" {
+		mediaTypes = append(mediaTypes, manifest.ArtifactType)
+	}
+	for _, desc := range manifestParsed.BlobReferences() {
+		mediaTypes = append(mediaTypes, desc.MediaType)
+	}
+
+	for _, mediaType := range mediaTypes {
+		if !account.IsMediaTypeAllowed(mediaType) {
+			return keppel.ErrManifestInvalid.With("media type not allowed in this account: " + mediaType)
+		}
+	}
+	return nil
+}
+
 type blobRef struct {
 	ID        int64
 	MediaType string
@@ -374,13 +514,14 @@ func findManifestReferencedObjects(tx *gorp.Transaction, account models.ReducedA
 
 // Information about a manifest's config blob.
 type manifestConfigInfo struct {
+	Contents        []byte
 	Labels          map[string]string
 	MinCreationTime *time.Time // across all layers
 	MaxCreationTime *time.Time // across all layers
 }
 
 // Returns the list of missing labels, or nil if everything is ok.
-func parseManifestConfig(ctx context.Context, tx *gorp.Transaction, sd keppel.StorageDriver, account models.ReducedAccount, manifest keppel.ParsedManifest) (result manifestConfigInfo, err error) {
+func parseManifestConfig(ctx context.Context, tx *gorp.Transaction, cfg keppel.Configuration, sd keppel.StorageDriver, account models.ReducedAccount, manifest keppel.ParsedManifest) (result manifestConfigInfo, err error) {
 	// is this manifest an image that has labels?
 	configBlob := manifest.FindImageConfigBlob()
 	if configBlob == nil {
@@ -388,17 +529,18 @@ func parseManifestConfig(ctx context.Context, tx *gorp.Transaction, sd keppel.St
 	}
 
 	// load the config blob
-	storageID, err := tx.SelectStr(
-		`SELECT storage_id FROM blobs WHERE account_name = $1 AND digest = $2`,
+	var blob models.Blob
+	err = tx.SelectOne(&blob,
+		`SELECT * FROM blobs WHERE account_name = $1 AND digest = $2`,
 		account.Name, configBlob.Digest.String(),
 	)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && blob.StorageID == "") {
+		return manifestConfigInfo{}, keppel.ErrManifestBlobUnknown.With("").WithDetail(configBlob.Digest.String())
+	}
 	if err != nil {
 		return manifestConfigInfo{}, err
 	}
-	if storageID == "" {
-		return manifestConfigInfo{}, keppel.ErrManifestBlobUnknown.With("").WithDetail(configBlob.Digest.String())
-	}
-	blobReader, _, err := sd.ReadBlob(ctx, account, storageID)
+	blobReader, _, err := sd.ReadBlob(ctx, cfg.BlobStorageLocation(account, blob), blob.StorageID)
 	if err != nil {
 		return manifestConfigInfo{}, err
 	}
@@ -425,6 +567,7 @@ func parseManifestConfig(ctx context.Context, tx *gorp.Transaction, sd keppel.St
 	if err != nil {
 		return manifestConfigInfo{}, err
 	}
+	result.Contents = blobContents
 	result.Labels = data.Config.Labels
 
 	// collect layer creation times (but ignore layers with a creation timestamp
@@ -446,12 +589,49 @@ func parseManifestConfig(ctx context.Context, tx *gorp.Transaction, sd keppel.St
 	return result, nil
 }
 
+// Fills the fields of `manifest` that describe OCI artifacts. These are declared
+// on the top level of OCI image manifests and image indexes in the same way, so
+// we do not need to go through keppel.ParsedManifest for this.
+func parseManifestArtifactMetadata(manifest *models.Manifest, manifestBytes []byte) error {
+	var data struct {
+		ArtifactType string `json:"artifactType"`
+		Subject      *struct {
+			Digest digest.Digest `json:"digest"`
+		} `json:"subject"`
+		Annotations map[string]string `json:"annotations"`
+	}
+	err := json.Unmarshal(manifestBytes, &data)
+	if err != nil {
+		return err
+	}
+
+	manifest.ArtifactType = data.ArtifactType
+	manifest.SubjectDigest = ""
+	if data.Subject != nil {
+		err := data.Subject.Digest.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid subject digest: %w", err)
+		}
+		manifest.SubjectDigest = data.Subject.Digest.String()
+	}
+	manifest.AnnotationsJSON = ""
+	if len(data.Annotations) > 0 {
+		annotationsJSON, err := json.Marshal(data.Annotations)
+		if err != nil {
+			return err
+		}
+		manifest.AnnotationsJSON = string(annotationsJSON)
+	}
+	return nil
+}
+
 var upsertManifestQuery = sqlext.SimplifyWhitespace(`
-	INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at, labels_json, min_layer_created_at, max_layer_created_at)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	INSERT INTO manifests (repo_id, digest, media_type, size_bytes, pushed_at, next_validation_at, labels_json, min_layer_created_at, max_layer_created_at, quarantine_status, artifact_type, subject_digest, annotations_json)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 	ON CONFLICT (repo_id, digest) DO UPDATE
 		SET size_bytes = EXCLUDED.size_bytes, next_validation_at = EXCLUDED.next_validation_at, labels_json = EXCLUDED.labels_json,
-		min_layer_created_at = EXCLUDED.min_layer_created_at, max_layer_created_at = EXCLUDED.max_layer_created_at
+		min_layer_created_at = EXCLUDED.min_layer_created_at, max_layer_created_at = EXCLUDED.max_layer_created_at,
+		artifact_type = EXCLUDED.artifact_type, subject_digest = EXCLUDED.subject_digest, annotations_json = EXCLUDED.annotations_json
 `)
 
 var upsertManifestContentQuery = sqlext.SimplifyWhitespace(`
@@ -468,7 +648,7 @@ var upsertManifestSecurityInfo = sqlext.SimplifyWhitespace(`
 `)
 
 func upsertManifest(db gorp.SqlExecutor, m models.Manifest, manifestBytes []byte, timeNow time.Time) error {
-	_, err := db.Exec(upsertManifestQuery, m.RepositoryID, m.Digest, m.MediaType, m.SizeBytes, m.PushedAt, m.NextValidationAt, m.LabelsJSON, m.MinLayerCreatedAt, m.MaxLayerCreatedAt)
+	_, err := db.Exec(upsertManifestQuery, m.RepositoryID, m.Digest, m.MediaType, m.SizeBytes, m.PushedAt, m.NextValidationAt, m.LabelsJSON, m.MinLayerCreatedAt, m.MaxLayerCreatedAt, m.QuarantineStatus, m.ArtifactType, m.SubjectDigest, m.AnnotationsJSON)
 	if err != nil {
 		return err
 	}
@@ -481,6 +661,23 @@ func upsertManifest(db gorp.SqlExecutor, m models.Manifest, manifestBytes []byte
 	return err
 }
 
+// this also covers the submanifests of a signed list manifest, since those
+// inherit the signature of their parent (see tasks.SignatureVerificationJob)
+var signatureRecheckQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET next_signature_check_at = NULL
+	 WHERE repo_id = $1 AND (digest = $2 OR digest IN (
+		SELECT child_digest FROM manifest_manifest_refs WHERE repo_id = $1 AND parent_digest = $2
+	 ))
+`)
+
+// same as above, but for tasks.AttestationVerificationJob
+var attestationRecheckQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET next_attestation_check_at = NULL
+	 WHERE repo_id = $1 AND (digest = $2 OR digest IN (
+		SELECT child_digest FROM manifest_manifest_refs WHERE repo_id = $1 AND parent_digest = $2
+	 ))
+`)
+
 var upsertTagQuery = sqlext.SimplifyWhitespace(`
 	INSERT INTO tags (repo_id, name, digest, pushed_at)
 	VALUES ($1, $2, $3, $4)
@@ -492,8 +689,37 @@ var upsertTagQuery = sqlext.SimplifyWhitespace(`
 			last_pulled_at = (CASE WHEN tags.digest = EXCLUDED.digest THEN GREATEST(tags.last_pulled_at, EXCLUDED.last_pulled_at) ELSE EXCLUDED.last_pulled_at END)
 `)
 
-func upsertTag(db gorp.SqlExecutor, t models.Tag) error {
-	_, err := db.Exec(upsertTagQuery, t.RepositoryID, t.Name, t.Digest, t.PushedAt)
+var insertTagHistoryQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO tag_history (repo_id, tag_name, old_digest, new_digest, moved_at, user_name)
+	VALUES ($1, $2, $3, $4, $5, $6)
+`)
+
+// Creates or moves a tag. If an existing tag is moved to a different
+// manifest, the movement is recorded in the tag history.
+func upsertTag(db gorp.SqlExecutor, t models.Tag, userName string) error {
+	oldDigest, err := db.SelectNullStr(`SELECT digest FROM tags WHERE repo_id = $1 AND name = $2 FOR UPDATE`, t.RepositoryID, t.Name)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(upsertTagQuery, t.RepositoryID, t.Name, t.Digest, t.PushedAt)
+	if err != nil {
+		return err
+	}
+	if oldDigest.Valid && oldDigest.String != t.Digest.String() {
+		_, err = db.Exec(insertTagHistoryQuery, t.RepositoryID, t.Name, oldDigest.String, t.Digest, t.PushedAt, userName)
+	}
+	return err
+}
+
+var upsertQuarantinedTagQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO quarantined_tags (repo_id, name, digest, pushed_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (repo_id, name) DO UPDATE
+		SET digest = EXCLUDED.digest, pushed_at = EXCLUDED.pushed_at
+`)
+
+func upsertQuarantinedTag(db gorp.SqlExecutor, t models.QuarantinedTag) error {
+	_, err := db.Exec(upsertQuarantinedTagQuery, t.RepositoryID, t.Name, t.Digest, t.PushedAt)
 	return err
 }
 
@@ -652,9 +878,25 @@ func (e UpstreamManifestMissingError) Error() string {
 	return e.Inner.Error()
 }
 
+var enqueueBlobPrefetchQuery = sqlext.SimplifyWhitespace(`
+	UPDATE blobs SET next_prefetch_at = $2
+	 WHERE id = $1 AND storage_id = '' AND next_prefetch_at IS NULL
+`)
+
 // ReplicateManifest replicates the manifest from its account's upstream registry.
 // On success, the manifest's metadata and contents are returned.
 func (p *Processor) ReplicateManifest(ctx context.Context, account models.ReducedAccount, repo models.Repository, reference models.ManifestReference, actx keppel.AuditContext) (*models.Manifest, []byte, error) {
+	ctx, endSpan := keppel.StartSpan(ctx, "processor.ReplicateManifest",
+		attribute.String("keppel.repository", repo.FullName()),
+		attribute.String("keppel.manifest.reference", reference.String()),
+	)
+	manifest, manifestBytes, err := p.replicateManifest(ctx, account, repo, reference, actx)
+	endSpan(&err)
+	countReplication(account, replicationKindManifest, uint64(len(manifestBytes)), err)
+	return manifest, manifestBytes, err
+}
+
+func (p *Processor) replicateManifest(ctx context.Context, account models.ReducedAccount, repo models.Repository, reference models.ManifestReference, actx keppel.AuditContext) (*models.Manifest, []byte, error) {
 	manifestBytes, manifestMediaType, err := p.downloadManifestViaInboundCache(ctx, account, repo, reference)
 	if err != nil {
 		if errorIsManifestNotFound(err) {
@@ -694,6 +936,14 @@ func (p *Processor) ReplicateManifest(ctx context.Context, account models.Reduce
 		if err != nil {
 			return nil, nil, err
 		}
+		// if requested, have the janitor replicate missing blobs in the background
+		// before the first client asks for them (see tasks.BlobPrefetchJob)
+		if account.PrefetchBlobs && blob.StorageID == "" {
+			_, err = p.db.Exec(enqueueBlobPrefetchQuery, blob.ID, p.timeNow())
+			if err != nil {
+				return nil, nil, err
+			}
+		}
 	}
 
 	// if the manifest is an image, we need to replicate the image configuration
@@ -722,6 +972,33 @@ func (p *Processor) ReplicateManifest(ctx context.Context, account models.Reduce
 	return manifest, manifestBytes, err
 }
 
+// RevalidateTagInProxyCache checks with the upstream registry of a proxy cache
+// account whether the given tag still points to the given digest. If the tag
+// has moved upstream, the new manifest is replicated and returned. If the tag
+// has not moved, (nil, nil, nil) is returned.
+func (p *Processor) RevalidateTagInProxyCache(ctx context.Context, account models.ReducedAccount, repo models.Repository, tagName string, knownDigest digest.Digest, actx keppel.AuditContext) (*models.Manifest, []byte, error) {
+	if !account.IsProxyCache {
+		return nil, nil, fmt.Errorf("account %q is not a proxy cache", account.Name)
+	}
+	c, err := p.getRepoClientForUpstream(ctx, account, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ref := models.ManifestReference{Tag: tagName}
+	upstreamDigest, err := c.RevalidateManifest(ctx, ref, knownDigest)
+	if err != nil {
+		if errorIsManifestNotFound(err) {
+			return nil, nil, UpstreamManifestMissingError{ref, err}
+		}
+		return nil, nil, err
+	}
+	if upstreamDigest == knownDigest {
+		return nil, nil, nil
+	}
+	return p.ReplicateManifest(ctx, account, repo, ref, actx)
+}
+
 // CheckManifestOnPrimary checks if the given manifest exists on its account's
 // upstream registry. If not, false is returned, An error is returned only if
 // the account is not a replica, or if the upstream registry cannot be queried.
@@ -756,7 +1033,7 @@ func errorIsUpstreamRateLimit(err error) bool {
 // Downloads a manifest from an account's upstream using
 // RepoClient.DownloadManifest(), but also takes into account the inbound cache.
 func (p *Processor) downloadManifestViaInboundCache(ctx context.Context, account models.ReducedAccount, repo models.Repository, ref models.ManifestReference) (manifestBytes []byte, manifestMediaType string, err error) {
-	c, err := p.getRepoClientForUpstream(account, repo)
+	c, err := p.getRepoClientForUpstream(ctx, account, repo)
 	if err != nil {
 		return nil, "", err
 	}
@@ -767,29 +1044,39 @@ func (p *Processor) downloadManifestViaInboundCache(ctx context.Context, account
 		RepoName:  c.RepoName,
 		Reference: ref,
 	}
+	//
+	// (proxy caches skip this step for tags, since they are expected to always
+	// follow the upstream tags without delay)
 	labels := prometheus.Labels{"external_hostname": c.Host}
-	manifestBytes, manifestMediaType, err = p.icd.LoadManifest(ctx, imageRef, p.timeNow())
-	if err == nil {
-		InboundManifestCacheHitCounter.With(labels).Inc()
-		return manifestBytes, manifestMediaType, nil
-	}
-	if !errors.Is(err, sql.ErrNoRows) {
-		return nil, "", err
+	if !(account.IsProxyCache && ref.IsTag()) {
+		manifestBytes, manifestMediaType, err = p.icd.LoadManifest(ctx, imageRef, p.timeNow())
+		if err == nil {
+			InboundManifestCacheHitCounter.With(labels).Inc()
+			return manifestBytes, manifestMediaType, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, "", err
+		}
 	}
 
 	// cache miss -> download from actual upstream registry
+	startedAt := time.Now()
 	manifestBytes, manifestMediaType, err = c.DownloadManifest(ctx, ref, &client.DownloadManifestOpts{
 		DoNotCountTowardsLastPulled: true,
 	})
+	observeUpstreamRequest(account, c.Host, replicationKindManifest, startedAt, err)
 	if err != nil && account.ExternalPeerURL != "" && errorIsUpstreamRateLimit(err) {
 		// when a pull from an external registry runs into a rate limit, ask a
 		// random peer to retry the pull for us; they might be successful since
 		// rate limits are usually per source IP
 		var ok bool
-		manifestBytes, manifestMediaType, ok = p.downloadManifestViaPullDelegation(ctx, imageRef, account.ExternalPeerUserName, account.ExternalPeerPassword)
+		manifestBytes, manifestMediaType, ok = p.downloadManifestViaPullDelegation(ctx, imageRef, c.UserName, c.Password)
+		result := "failure"
 		if ok {
 			err = nil
+			result = "success"
 		}
+		PullDelegationCounter.With(prometheus.Labels{"account": string(account.Name), "result": result}).Inc()
 	}
 	if err != nil {
 		return nil, "", err
@@ -826,6 +1113,15 @@ func (p *Processor) downloadManifestViaPullDelegation(ctx context.Context, image
 		logg.Error(err.Error())
 		return nil, "", false
 	}
+	ok, err := peerClient.HasCapability(ctx, keppel.PeerCapabilityDelegatedPull)
+	if err != nil {
+		logg.Error(err.Error())
+		return nil, "", false
+	}
+	if !ok {
+		logg.Info("skipping pull delegation to %s: peer does not support it", peer.HostName)
+		return nil, "", false
+	}
 	respBytes, contentType, err = peerClient.DownloadManifestViaPullDelegation(ctx, imageRef, userName, password)
 	if err != nil {
 		logg.Error(err.Error())
@@ -854,6 +1150,15 @@ func (p *Processor) DeleteManifest(ctx context.Context, account models.ReducedAc
 		tags = append(tags, tagResult.Name)
 	}
 
+	// variants derived from this manifest (e.g. squashed images) are deleted along with it
+	var variantDigests []digest.Digest
+	_, err = p.db.Select(&variantDigests,
+		`SELECT variant_digest FROM manifest_variants WHERE repo_id = $1 AND source_digest = $2 AND variant_digest IS NOT NULL`,
+		repo.ID, manifestDigest)
+	if err != nil {
+		return err
+	}
+
 	result, err := p.db.Exec(
 		// this also deletes tags referencing this manifest because of "ON DELETE CASCADE"
 		`DELETE FROM manifests WHERE repo_id = $1 AND digest = $2`,
@@ -911,41 +1216,106 @@ func (p *Processor) DeleteManifest(ctx context.Context, account models.ReducedAc
 		})
 	}
 
+	for _, variantDigest := range variantDigests {
+		err := p.DeleteManifest(ctx, account, repo, variantDigest, actx)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("while deleting variant %s of manifest %s: %w", variantDigest, manifestDigest, err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteManifestAndReferrers is like DeleteManifest, but also deletes all
+// manifests in the same repository that declare this manifest as their subject
+// (e.g. signatures or SBOMs), and so on recursively.
+//
+// The subject is deleted first, so if the deletion of a referrer fails, or if
+// a referrer is pushed concurrently, the remaining referrers are orphaned and
+// will be cleaned up by the janitor eventually.
+func (p *Processor) DeleteManifestAndReferrers(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest, actx keppel.AuditContext) error {
+	var referrerDigests []digest.Digest
+	_, err := p.db.Select(&referrerDigests,
+		`SELECT digest FROM manifests WHERE repo_id = $1 AND subject_digest = $2`,
+		repo.ID, manifestDigest)
+	if err != nil {
+		return err
+	}
+
+	err = p.DeleteManifest(ctx, account, repo, manifestDigest, actx)
+	if err != nil {
+		return err
+	}
+
+	for _, referrerDigest := range referrerDigests {
+		err := p.DeleteManifestAndReferrers(ctx, account, repo, referrerDigest, actx)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("while deleting referrer %s of manifest %s: %w", referrerDigest, manifestDigest, err)
+		}
+	}
 	return nil
 }
 
 // DeleteTag deletes the given tag from the database. The manifest is not deleted.
+// If the tag was pushed along with a manifest that is held in quarantine, the
+// pending tag is discarded as well, so that it does not reappear on promotion.
 // If the tag does not exist, sql.ErrNoRows is returned.
 func (p *Processor) DeleteTag(account models.ReducedAccount, repo models.Repository, tagName string, actx keppel.AuditContext) error {
-	digestStr, err := p.db.SelectStr(
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	digestStr, err := tx.SelectStr(
 		`DELETE FROM tags WHERE repo_id = $1 AND name = $2 RETURNING digest`,
 		repo.ID, tagName)
 	if err != nil {
 		return err
 	}
-	if digestStr == "" {
+	quarantinedDigestStr, err := tx.SelectStr(
+		`DELETE FROM quarantined_tags WHERE repo_id = $1 AND name = $2 RETURNING digest`,
+		repo.ID, tagName)
+	if err != nil {
+		return err
+	}
+	if digestStr == "" && quarantinedDigestStr == "" {
 		return sql.ErrNoRows
 	}
-
-	tagDigest, err := digest.Parse(digestStr)
+	err = tx.Commit()
 	if err != nil {
 		return err
 	}
 
+	// each removed digest is audited separately
+	var tagDigests []digest.Digest
+	for _, str := range []string{digestStr, quarantinedDigestStr} {
+		if str == "" || (len(tagDigests) > 0 && tagDigests[0].String() == str) {
+			continue
+		}
+		tagDigest, err := digest.Parse(str)
+		if err != nil {
+			return err
+		}
+		tagDigests = append(tagDigests, tagDigest)
+	}
+
 	if userInfo := actx.UserIdentity.UserInfo(); userInfo != nil {
-		p.auditor.Record(audittools.Event{
-			Time:       p.timeNow(),
-			Request:    actx.Request,
-			User:       userInfo,
-			ReasonCode: http.StatusOK,
-			Action:     cadf.DeleteAction,
-			Target: auditTag{
-				Account:    account,
-				Repository: repo,
-				Digest:     tagDigest,
-				TagName:    tagName,
-			},
-		})
+		for _, tagDigest := range tagDigests {
+			p.auditor.Record(audittools.Event{
+				Time:       p.timeNow(),
+				Request:    actx.Request,
+				User:       userInfo,
+				ReasonCode: http.StatusOK,
+				Action:     cadf.DeleteAction,
+				Target: auditTag{
+					Account:    account,
+					Repository: repo,
+					Digest:     tagDigest,
+					TagName:    tagName,
+				},
+			})
+		}
 	}
 
 	return nil
@@ -981,6 +1351,11 @@ func (a auditManifest) Render() cadf.Resource {
 	return res
 }
 
+// AuditAccountName implements the keppel.AccountScopedAuditTarget interface.
+func (a auditManifest) AuditAccountName() models.AccountName {
+	return a.Account.Name
+}
+
 // auditTag is an audittools.Target.
 type auditTag struct {
 	Account    models.ReducedAccount
@@ -998,3 +1373,8 @@ func (a auditTag) Render() cadf.Resource {
 		ProjectID: a.Account.AuthTenantID,
 	}
 }
+
+// AuditAccountName implements the keppel.AccountScopedAuditTarget interface.
+func (a auditTag) AuditAccountName() models.AccountName {
+	return a.Account.Name
+}
@@ -19,7 +19,13 @@
 
 package processor
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sapcc/keppel/internal/models"
+)
 
 var (
 	// InboundManifestCacheHitCounter is a prometheus.CounterVec.
@@ -38,9 +44,89 @@ var (
 		},
 		[]string{"external_hostname"},
 	)
+	// ReplicationCounter is a prometheus.CounterVec.
+	ReplicationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_replications",
+			Help: "Counter for manifests and blobs replicated into replica accounts from their upstream registry.",
+		},
+		[]string{"account", "kind", "result"},
+	)
+	// ReplicatedBytesCounter is a prometheus.CounterVec.
+	ReplicatedBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_replicated_bytes",
+			Help: "Counter for bytes of manifests and blobs successfully replicated into replica accounts.",
+		},
+		[]string{"account", "kind"},
+	)
+	// UpstreamRequestDurationHistogram is a prometheus.HistogramVec.
+	UpstreamRequestDurationHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "keppel_replication_upstream_request_duration_seconds",
+			Help:    "Duration of requests to upstream registries for downloading manifests and blobs during replication, up to the point where the response headers have been received.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8), // 10ms .. ~160s
+		},
+		[]string{"upstream_hostname", "kind"},
+	)
+	// UpstreamRateLimitCounter is a prometheus.CounterVec.
+	UpstreamRateLimitCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_replication_upstream_rate_limits",
+			Help: "Counter for requests to upstream registries during replication that were rejected because of a rate limit.",
+		},
+		[]string{"account", "upstream_hostname", "kind"},
+	)
+	// PullDelegationCounter is a prometheus.CounterVec.
+	PullDelegationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_replication_pull_delegations",
+			Help: "Counter for manifest pulls from external registries that were delegated to a peer after running into a rate limit.",
+		},
+		[]string{"account", "result"},
+	)
+	// ColdStartQueuedReplicationCounter is a prometheus.CounterVec.
+	ColdStartQueuedReplicationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_replication_cold_start_queued",
+			Help: "Counter for first pulls of manifests in replica accounts that were queued instead of replicated immediately because replication from the primary is in cold-start mode.",
+		},
+		[]string{"account", "upstream_hostname"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(InboundManifestCacheHitCounter)
 	prometheus.MustRegister(InboundManifestCacheMissCounter)
+	prometheus.MustRegister(ReplicationCounter)
+	prometheus.MustRegister(ReplicatedBytesCounter)
+	prometheus.MustRegister(UpstreamRequestDurationHistogram)
+	prometheus.MustRegister(UpstreamRateLimitCounter)
+	prometheus.MustRegister(PullDelegationCounter)
+	prometheus.MustRegister(ColdStartQueuedReplicationCounter)
+}
+
+// values for the "kind" label on replication metrics
+const (
+	replicationKindManifest = "manifest"
+	replicationKindBlob     = "blob"
+)
+
+func countReplication(account models.ReducedAccount, kind string, sizeBytes uint64, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	ReplicationCounter.With(prometheus.Labels{"account": string(account.Name), "kind": kind, "result": result}).Inc()
+	if err == nil {
+		ReplicatedBytesCounter.With(prometheus.Labels{"account": string(account.Name), "kind": kind}).Add(float64(sizeBytes))
+	}
+}
+
+func observeUpstreamRequest(account models.ReducedAccount, upstreamHostname, kind string, startedAt time.Time, err error) {
+	UpstreamRequestDurationHistogram.With(prometheus.Labels{"upstream_hostname": upstreamHostname, "kind": kind}).
+		Observe(time.Since(startedAt).Seconds())
+	if err != nil && errorIsUpstreamRateLimit(err) {
+		UpstreamRateLimitCounter.With(prometheus.Labels{"account": string(account.Name), "upstream_hostname": upstreamHostname, "kind": kind}).Inc()
+	}
 }
@@ -85,7 +85,10 @@ func (p *Processor) WithLowlevelAccess(action func(*keppel.DB, keppel.StorageDri
 // callback returns success (i.e. a nil error), the transaction will be
 // committed.  If it returns an error or panics, the transaction will be rolled
 // back.
-func (p *Processor) insideTransaction(ctx context.Context, action func(context.Context, *gorp.Transaction) error) error {
+func (p *Processor) insideTransaction(ctx context.Context, action func(context.Context, *gorp.Transaction) error) (returnErr error) {
+	ctx, endSpan := keppel.StartSpan(ctx, "db.transaction")
+	defer endSpan(&returnErr)
+
 	tx, err := p.db.Begin()
 	if err != nil {
 		return err
@@ -141,10 +144,22 @@ func (p *Processor) checkQuotaForManifestPush(account models.ReducedAccount) err
 
 // Takes a repo in a replica account and returns a RepoClient for accessing its
 // the upstream repo in the corresponding primary account.
-func (p *Processor) getRepoClientForUpstream(account models.ReducedAccount, repo models.Repository) (*client.RepoClient, error) {
+func (p *Processor) getRepoClientForUpstream(ctx context.Context, account models.ReducedAccount, repo models.Repository) (*client.RepoClient, error) {
+	// while the primary upstream is unreachable, the failover upstream (if any)
+	// takes its place (see tasks.UpstreamHealthCheckJob)
+	cacheKey := repo.FullName()
+	if account.IsUpstreamFailoverActive && account.FailoverUpstream != "" {
+		if account.UpstreamPeerHostName != "" {
+			account.UpstreamPeerHostName = account.FailoverUpstream
+		} else {
+			account.ExternalPeerURL = account.FailoverUpstream
+		}
+		cacheKey += " (failover)"
+	}
+
 	// use cached client if possible (this one probably already contains a valid
 	// pull token)
-	if c, ok := p.repoClients[repo.FullName()]; ok {
+	if c, ok := p.repoClients[cacheKey]; ok {
 		return c, nil
 	}
 
@@ -155,22 +170,36 @@ func (p *Processor) getRepoClientForUpstream(account models.ReducedAccount, repo
 			return nil, err
 		}
 
+		httpClient, err := keppel.NewHTTPClientForPeer(peer)
+		if err != nil {
+			return nil, fmt.Errorf("cannot configure HTTP client for upstream of account %q: %w", account.Name, err)
+		}
 		c := &client.RepoClient{
-			Scheme:   "https",
-			Host:     peer.HostName,
-			RepoName: repo.FullName(),
-			UserName: "replication@" + p.cfg.APIPublicHostname,
-			Password: peer.OurPassword,
+			Scheme:     "https",
+			Host:       peer.HostName,
+			RepoName:   repo.FullName(),
+			UserName:   "replication@" + p.cfg.APIPublicHostname,
+			Password:   peer.OurPassword,
+			HTTPClient: httpClient,
 		}
-		p.repoClients[repo.FullName()] = c
+		p.repoClients[cacheKey] = c
 		return c, nil
 	}
 
 	if account.ExternalPeerURL != "" {
+		httpClient, err := keppel.NewHTTPClientForExternalPeer(account)
+		if err != nil {
+			return nil, fmt.Errorf("cannot configure HTTP client for upstream of account %q: %w", account.Name, err)
+		}
+		password, err := keppel.ResolveExternalPeerPassword(ctx, account)
+		if err != nil {
+			return nil, fmt.Errorf("cannot obtain credentials for upstream of account %q: %w", account.Name, err)
+		}
 		c := &client.RepoClient{
-			Scheme:   "https",
-			UserName: account.ExternalPeerUserName,
-			Password: account.ExternalPeerPassword,
+			Scheme:     "https",
+			UserName:   account.ExternalPeerUserName,
+			Password:   password,
+			HTTPClient: httpClient,
 		}
 		if strings.Contains(account.ExternalPeerURL, "/") {
 			fields := strings.SplitN(account.ExternalPeerURL, "/", 2)
@@ -180,9 +209,53 @@ func (p *Processor) getRepoClientForUpstream(account models.ReducedAccount, repo
 			c.Host = account.ExternalPeerURL
 			c.RepoName = repo.Name
 		}
-		p.repoClients[repo.FullName()] = c
+		if account.IsProxyCache {
+			rewriteDockerHubLocation(c)
+		}
+		p.repoClients[cacheKey] = c
 		return c, nil
 	}
 
 	return nil, fmt.Errorf("account %q does not have an upstream", account.Name)
 }
+
+// CheckUpstreamCredentials obtains the current credentials for the external
+// peer of the given replica account (resolving secret references if
+// necessary) and checks whether the external peer accepts them.
+func (p *Processor) CheckUpstreamCredentials(ctx context.Context, account models.ReducedAccount) error {
+	if account.ExternalPeerURL == "" {
+		return fmt.Errorf("account %q does not have an external upstream", account.Name)
+	}
+	c, err := p.getRepoClientForUpstream(ctx, account, models.Repository{AccountName: account.Name})
+	if err != nil {
+		return err
+	}
+	return c.CheckCredentials(ctx)
+}
+
+// CheckPrimaryUpstream checks whether the primary upstream of the given
+// replica account is reachable and accepts our credentials, regardless of
+// whether the account has currently failed over to its failover upstream.
+func (p *Processor) CheckPrimaryUpstream(ctx context.Context, account models.ReducedAccount) error {
+	account.IsUpstreamFailoverActive = false
+	c, err := p.getRepoClientForUpstream(ctx, account, models.Repository{AccountName: account.Name})
+	if err != nil {
+		return err
+	}
+	return c.CheckCredentials(ctx)
+}
+
+// Docker Hub is usually referred to as "docker.io", but its registry API is
+// served on a different hostname. Also, official images like "alpine" are
+// pulled by users under their short name, but Docker Hub only serves them
+// under their full name "library/alpine". Proxy caches for Docker Hub
+// transparently rewrite both of these.
+func rewriteDockerHubLocation(c *client.RepoClient) {
+	switch c.Host {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		c.Host = "registry-1.docker.io"
+		if !strings.Contains(c.RepoName, "/") {
+			c.RepoName = "library/" + c.RepoName
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package processor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var promotionBlobsQuery = sqlext.SimplifyWhitespace(`
+	SELECT b.*
+	  FROM blobs b
+	  JOIN manifest_blob_refs r ON b.id = r.blob_id
+	 WHERE r.repo_id = $1 AND r.digest = $2
+`)
+
+// PromoteManifest copies the given manifest from the source repository into
+// the target repository, which may be in a different account. All manifests
+// and blobs referenced by it are copied as well: blobs are mounted if the
+// target account already has them, and copied within the storage otherwise,
+// so that no contents need to go through the client. Cosign signatures,
+// attestations and SBOMs that are tagged for the manifest in the source
+// repository are promoted along with it, so that provenance is retained.
+//
+// If `tagName` is not empty, the promoted manifest is tagged with it in the
+// target repository.
+//
+// The caller is responsible for checking that the user is allowed to pull
+// from the source repository and push into the target repository.
+func (p *Processor) PromoteManifest(ctx context.Context, sourceAccount models.ReducedAccount, sourceRepo models.Repository, sourceManifest models.Manifest, targetAccount models.ReducedAccount, targetRepo models.Repository, tagName string, actx keppel.AuditContext) (*models.Manifest, error) {
+	if !sourceManifest.QuarantineStatus.IsPullable() {
+		return nil, keppel.ErrDenied.With("manifest %s cannot be promoted because of its vulnerability scan quarantine (quarantine status: %s)",
+			sourceManifest.Digest, sourceManifest.QuarantineStatus).WithStatus(http.StatusConflict)
+	}
+
+	result, err := p.promoteManifestRecursively(ctx, sourceAccount, sourceRepo, sourceManifest, targetAccount, targetRepo, tagName, actx)
+	if err != nil {
+		return nil, err
+	}
+
+	// cosign artifacts are stored as separate manifests that are tagged with a name derived from the digest
+	for _, suffix := range []string{"sig", "att", "sbom"} {
+		artifactTagName := fmt.Sprintf("%s-%s.%s", sourceManifest.Digest.Algorithm(), sourceManifest.Digest.Encoded(), suffix)
+		artifactDigestStr, err := p.db.SelectStr(`SELECT digest FROM tags WHERE repo_id = $1 AND name = $2`, sourceRepo.ID, artifactTagName)
+		if err != nil {
+			return nil, err
+		}
+		if artifactDigestStr == "" {
+			continue
+		}
+		artifactManifest, err := keppel.FindManifest(p.db, sourceRepo, digest.Digest(artifactDigestStr))
+		if err != nil {
+			return nil, fmt.Errorf("cannot find manifest for tag %s: %w", artifactTagName, err)
+		}
+		_, err = p.promoteManifestRecursively(ctx, sourceAccount, sourceRepo, *artifactManifest, targetAccount, targetRepo, artifactTagName, actx)
+		if err != nil {
+			return nil, fmt.Errorf("while promoting %s: %w", artifactTagName, err)
+		}
+	}
+
+	return result, nil
+}
+
+func (p *Processor) promoteManifestRecursively(ctx context.Context, sourceAccount models.ReducedAccount, sourceRepo models.Repository, sourceManifest models.Manifest, targetAccount models.ReducedAccount, targetRepo models.Repository, tagName string, actx keppel.AuditContext) (*models.Manifest, error) {
+	// child manifests (e.g. in image indexes) need to exist in the target repo before their parent
+	var childDigests []string
+	_, err := p.db.Select(&childDigests, `SELECT child_digest FROM manifest_manifest_refs WHERE repo_id = $1 AND parent_digest = $2`, sourceRepo.ID, sourceManifest.Digest)
+	if err != nil {
+		return nil, err
+	}
+	for _, childDigest := range childDigests {
+		childManifest, err := keppel.FindManifest(p.db, sourceRepo, digest.Digest(childDigest))
+		if err != nil {
+			return nil, fmt.Errorf("cannot find child manifest %s: %w", childDigest, err)
+		}
+		_, err = p.promoteManifestRecursively(ctx, sourceAccount, sourceRepo, *childManifest, targetAccount, targetRepo, "", actx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// make referenced blobs available in the target repo
+	var blobs []models.Blob
+	_, err = p.db.Select(&blobs, promotionBlobsQuery, sourceRepo.ID, sourceManifest.Digest)
+	if err != nil {
+		return nil, err
+	}
+	for _, blob := range blobs {
+		if sourceAccount.Name == targetAccount.Name {
+			err = keppel.MountBlobIntoRepo(p.db, blob, targetRepo)
+		} else {
+			err = p.MountBlobFromForeignAccount(ctx, blob, sourceAccount, targetAccount, targetRepo)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot copy blob %s: %w", blob.Digest, err)
+		}
+	}
+
+	// store the manifest in the target repo (this goes through the same
+	// validation as a regular push, including the quota check)
+	manifestBytes, err := p.readManifestContents(ctx, sourceAccount, sourceRepo, sourceManifest.Digest)
+	if err != nil {
+		return nil, err
+	}
+	ref := models.ManifestReference{Digest: sourceManifest.Digest}
+	if tagName != "" {
+		ref = models.ManifestReference{Tag: tagName}
+	}
+	return p.ValidateAndStoreManifest(ctx, targetAccount, targetRepo, IncomingManifest{
+		Reference: ref,
+		MediaType: sourceManifest.MediaType,
+		Contents:  manifestBytes,
+		PushedAt:  p.timeNow(),
+	}, actx)
+}
+
+// Reads the contents of a manifest from the DB, or from the storage if the DB
+// does not have them for some reason.
+func (p *Processor) readManifestContents(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest) ([]byte, error) {
+	var manifestBytes []byte
+	err := p.db.SelectOne(&manifestBytes, `SELECT content FROM manifest_contents WHERE repo_id = $1 AND digest = $2`, repo.ID, manifestDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p.sd.ReadManifest(ctx, account, repo.Name, manifestDigest)
+	}
+	return manifestBytes, err
+}
@@ -0,0 +1,104 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package processor
+
+import (
+	"context"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var quarantineUpdateStatusQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET quarantine_status = $3
+	 WHERE repo_id = $1 AND digest = $2 AND quarantine_status = 'pending'
+`)
+
+var quarantineDeleteTagsQuery = sqlext.SimplifyWhitespace(`
+	DELETE FROM quarantined_tags WHERE repo_id = $1 AND digest = $2
+`)
+
+// ResolveQuarantine decides whether a manifest that is held in quarantine can
+// be promoted or must be rejected, based on the result of its initial
+// vulnerability scan. On promotion, the tags that were pushed along with the
+// manifest become visible. On rejection, those tags are discarded.
+//
+// If the manifest is not in quarantine, or if the vulnerability scan has not
+// produced a conclusive result yet, nothing happens. The manifest's
+// QuarantineStatus after this call is returned.
+func (p *Processor) ResolveQuarantine(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifest models.Manifest, vulnStatus models.VulnerabilityStatus) (models.QuarantineStatus, error) {
+	if manifest.QuarantineStatus != models.QuarantinePending {
+		return manifest.QuarantineStatus, nil
+	}
+
+	// manifests that cannot be scanned at all (e.g. signatures or SBOMs) are
+	// promoted since they cannot exceed any severity threshold; when the scan
+	// failed or is not done yet, we need to wait for the next scan
+	var newStatus models.QuarantineStatus
+	switch {
+	case vulnStatus == models.UnsupportedVulnerabilityStatus:
+		newStatus = models.QuarantinePromoted
+	case !vulnStatus.HasReport():
+		return models.QuarantinePending, nil
+	case !account.IsQuarantineEnabled() || vulnStatus.IsLessSevereThan(account.QuarantineSeverityThreshold):
+		// if the quarantine policy was removed in the meantime, the manifest is let through as well
+		newStatus = models.QuarantinePromoted
+	default:
+		newStatus = models.QuarantineRejected
+	}
+
+	err := p.insideTransaction(ctx, func(ctx context.Context, tx *gorp.Transaction) error {
+		_, err := tx.Exec(quarantineUpdateStatusQuery, repo.ID, manifest.Digest, newStatus)
+		if err != nil {
+			return err
+		}
+
+		if newStatus == models.QuarantinePromoted {
+			var tags []models.QuarantinedTag
+			_, err = tx.Select(&tags, `SELECT * FROM quarantined_tags WHERE repo_id = $1 AND digest = $2`, repo.ID, manifest.Digest)
+			if err != nil {
+				return err
+			}
+			for _, tag := range tags {
+				err = upsertTag(tx, models.Tag{
+					RepositoryID: tag.RepositoryID,
+					Name:         tag.Name,
+					Digest:       tag.Digest,
+					PushedAt:     tag.PushedAt,
+				}, "")
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		_, err = tx.Exec(quarantineDeleteTagsQuery, repo.ID, manifest.Digest)
+		return err
+	})
+	if err != nil {
+		return models.QuarantinePending, err
+	}
+
+	logg.Info("manifest %s@%s was %s from quarantine (vulnerability status: %s, threshold: %s)",
+		repo.FullName(), manifest.Digest, newStatus, vulnStatus, account.QuarantineSeverityThreshold)
+	return newStatus, nil
+}
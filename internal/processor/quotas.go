@@ -20,6 +20,7 @@
 package processor
 
 import (
+	"database/sql"
 	"fmt"
 	"net/http"
 	"time"
@@ -63,6 +64,67 @@ func (e ImpossibleQuotaError) Error() string {
 	return e.Message
 }
 
+// TenantQuotaResponse appears in the response body for GET /keppel/v1/quotas.
+type TenantQuotaResponse struct {
+	AuthTenantID string              `json:"auth_tenant_id"`
+	Manifests    SingleQuotaResponse `json:"manifests"`
+}
+
+// IsOverQuota returns whether any usage value exceeds its respective quota.
+// This can happen when quotas are reduced by an admin without checking usage.
+func (r TenantQuotaResponse) IsOverQuota() bool {
+	return r.Manifests.Usage > r.Manifests.Quota
+}
+
+var listQuotasQuery = sqlext.SimplifyWhitespace(`
+	WITH tenants AS (
+	  SELECT auth_tenant_id FROM quotas
+	   UNION
+	  SELECT auth_tenant_id FROM accounts
+	), usage AS (
+	  SELECT a.auth_tenant_id, COUNT(m.digest) AS manifests
+	    FROM manifests m
+	    JOIN repos r ON m.repo_id = r.id
+	    JOIN accounts a ON a.name = r.account_name
+	   GROUP BY a.auth_tenant_id
+	)
+	SELECT t.auth_tenant_id, q.manifests, COALESCE(u.manifests, 0)
+	  FROM tenants t
+	  LEFT OUTER JOIN quotas q ON q.auth_tenant_id = t.auth_tenant_id
+	  LEFT OUTER JOIN usage u ON u.auth_tenant_id = t.auth_tenant_id
+	 ORDER BY t.auth_tenant_id
+`)
+
+// ListQuotas returns quota and usage for all auth tenants that have either a
+// quota set or at least one account. The result is sorted by auth tenant ID.
+// This is used by GET /keppel/v1/quotas.
+func (p *Processor) ListQuotas() ([]TenantQuotaResponse, error) {
+	var result []TenantQuotaResponse
+	err := sqlext.ForeachRow(p.db, listQuotasQuery, nil, func(rows *sql.Rows) error {
+		var (
+			authTenantID  string
+			manifestQuota *uint64
+			manifestCount int64
+		)
+		err := rows.Scan(&authTenantID, &manifestQuota, &manifestCount)
+		if err != nil {
+			return err
+		}
+		if manifestQuota == nil {
+			manifestQuota = &models.DefaultQuotas(authTenantID).ManifestCount
+		}
+		result = append(result, TenantQuotaResponse{
+			AuthTenantID: authTenantID,
+			Manifests: SingleQuotaResponse{
+				Quota: *manifestQuota,
+				Usage: keppel.AtLeastZero(manifestCount),
+			},
+		})
+		return nil
+	})
+	return result, err
+}
+
 // GetQuotas builds a response for GET /keppel/v1/quotas/:auth_tenant_id.
 func (p *Processor) GetQuotas(authTenantID string) (*QuotaResponse, error) {
 	quotas, err := keppel.FindQuotas(p.db, authTenantID)
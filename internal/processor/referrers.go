@@ -0,0 +1,72 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package processor
+
+import (
+	"fmt"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// This query records all manifests listed in an image index under a referrers
+// fallback tag (see keppel.ReferrersFallbackTagName) as referrers of the
+// manifest named by that tag. Manifests that declare a subject of their own are
+// left alone, since the subject declared within the manifest takes precedence.
+var referrersBackfillQueryTemplate = `
+	UPDATE manifests m SET subject_digest = %[1]s
+	  FROM tags t
+	  JOIN manifest_manifest_refs mmr ON mmr.repo_id = t.repo_id AND mmr.parent_digest = t.digest
+	 WHERE m.repo_id = mmr.repo_id AND m.digest = mmr.child_digest AND m.subject_digest = ''
+	   AND %[2]s
+`
+
+var (
+	referrersBackfillForTagQuery = sqlext.SimplifyWhitespace(fmt.Sprintf(referrersBackfillQueryTemplate,
+		`$3`, `t.repo_id = $1 AND t.name = $2`))
+	referrersBackfillQuery = sqlext.SimplifyWhitespace(fmt.Sprintf(referrersBackfillQueryTemplate,
+		// the first dash in the tag name separates the digest algorithm from the encoded digest
+		`regexp_replace(t.name, '-', ':')`,
+		`t.name ~ '^(sha256-[0-9a-f]{64}|sha384-[0-9a-f]{96}|sha512-[0-9a-f]{128})$'`))
+)
+
+// Records the referrers listed under the given referrers fallback tag.
+// This is called within the DB transaction that pushes the tag.
+func backfillReferrersForTag(tx *gorp.Transaction, repo models.Repository, tagName string, subjectDigest digest.Digest) error {
+	_, err := tx.Exec(referrersBackfillForTagQuery, repo.ID, tagName, subjectDigest)
+	return err
+}
+
+// BackfillReferrersFromFallbackTags looks at all referrers fallback tags in
+// all repositories, and records the manifests listed therein as referrers of
+// the manifest named by the respective tag. Returns how many manifests were
+// updated. This is used for referrers fallback tags that were pushed before
+// KEPPEL_NORMALIZE_REFERRERS_FALLBACK_TAGS was enabled, or that were
+// replicated from a primary account.
+func (p *Processor) BackfillReferrersFromFallbackTags() (int64, error) {
+	result, err := p.db.Exec(referrersBackfillQuery)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,139 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package processor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var claimColdStartSlotQuery = sqlext.SimplifyWhitespace(`
+	UPDATE peers SET next_cold_start_replication_at = $2
+	 WHERE hostname = $1 AND (next_cold_start_replication_at IS NULL OR next_cold_start_replication_at <= $3)
+`)
+
+// same as above, but does not allow jumping the queue
+var claimColdStartSlotIfQueueEmptyQuery = sqlext.SimplifyWhitespace(`
+	UPDATE peers SET next_cold_start_replication_at = $2
+	 WHERE hostname = $1 AND (next_cold_start_replication_at IS NULL OR next_cold_start_replication_at <= $3)
+	   AND NOT EXISTS (
+	     SELECT 1 FROM replication_queue q
+	       JOIN repos r ON r.id = q.repo_id
+	       JOIN accounts a ON a.name = r.account_name
+	      WHERE a.upstream_peer_hostname = $1
+	   )
+`)
+
+var enqueueReplicationQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO replication_queue (repo_id, reference, enqueued_at, last_requested_at) VALUES ($1, $2, $3, $3)
+	ON CONFLICT (repo_id, reference) DO UPDATE
+	  SET last_requested_at = EXCLUDED.last_requested_at, request_count = replication_queue.request_count + 1
+`)
+
+var replicationQueueLengthQuery = sqlext.SimplifyWhitespace(`
+	SELECT COUNT(*) FROM replication_queue q
+	  JOIN repos r ON r.id = q.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	 WHERE a.upstream_peer_hostname = $1
+`)
+
+// ReplicateManifestOnFirstPull is like ReplicateManifest, but is used when a
+// client pulls a manifest that does not exist in a replica account yet.
+//
+// If replication from the account's primary is in cold-start mode (see
+// models.Peer.ColdStartReplicationsPerMinute), the manifest is only replicated
+// if the peer's rate limit allows it and no other replications are waiting.
+// Otherwise, the manifest is put into the replication queue, and
+// keppel.ErrTooManyRequests is returned with a Retry-After header that
+// estimates how long it will take the janitor to work through the queue.
+func (p *Processor) ReplicateManifestOnFirstPull(ctx context.Context, account models.ReducedAccount, repo models.Repository, reference models.ManifestReference, actx keppel.AuditContext) (*models.Manifest, []byte, error) {
+	if account.UpstreamPeerHostName == "" {
+		// cold-start mode is only available for internal replicas
+		return p.ReplicateManifest(ctx, account, repo, reference, actx)
+	}
+	var peer models.Peer
+	err := p.db.SelectOne(&peer, `SELECT * FROM peers WHERE hostname = $1`, account.UpstreamPeerHostName)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && peer.ColdStartReplicationsPerMinute == 0) {
+		return p.ReplicateManifest(ctx, account, repo, reference, actx)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ok, err := p.ClaimColdStartReplicationSlot(peer, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ok {
+		return p.ReplicateManifest(ctx, account, repo, reference, actx)
+	}
+
+	// no capacity available right now -> put this manifest in the queue
+	_, err = p.db.Exec(enqueueReplicationQuery, repo.ID, reference.String(), p.timeNow())
+	if err != nil {
+		return nil, nil, err
+	}
+	ColdStartQueuedReplicationCounter.With(prometheus.Labels{"account": string(account.Name), "upstream_hostname": peer.HostName}).Inc()
+
+	queueLength, err := p.db.SelectInt(replicationQueueLengthQuery, peer.HostName)
+	if err != nil {
+		return nil, nil, err
+	}
+	retryAfter := max(1, math.Ceil((peer.ColdStartReplicationInterval() * time.Duration(queueLength)).Seconds()))
+	msg := fmt.Sprintf("replication from %s is currently rate-limited because this registry is still being populated; the image has been queued for replication",
+		peer.HostName)
+	return nil, nil, keppel.ErrTooManyRequests.With(msg).WithHeader("Retry-After", strconv.FormatFloat(retryAfter, 'f', 0, 64))
+}
+
+// ClaimColdStartReplicationSlot checks whether the rate limit for cold-start
+// replication from the given peer allows another replication right now, and
+// if so, consumes one unit of the rate limit. If ignoreQueue is false, the
+// rate limit is only consumed if there are no queued replications from this
+// peer (so that replications cannot jump the queue).
+//
+// For peers that are not in cold-start mode, true is always returned.
+func (p *Processor) ClaimColdStartReplicationSlot(peer models.Peer, ignoreQueue bool) (bool, error) {
+	interval := peer.ColdStartReplicationInterval()
+	if interval == 0 {
+		return true, nil
+	}
+	query := claimColdStartSlotIfQueueEmptyQuery
+	if ignoreQueue {
+		query = claimColdStartSlotQuery
+	}
+	now := p.timeNow()
+	result, err := p.db.Exec(query, peer.HostName, now.Add(interval), now)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
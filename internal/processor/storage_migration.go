@@ -0,0 +1,184 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package processor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// StorageMigrationReport is returned by MigrateAccountStorage.
+type StorageMigrationReport struct {
+	CopiedBlobs     uint64
+	CopiedBytes     uint64
+	CopiedManifests uint64
+	// objects that were found in the source storage, but are not referenced by
+	// the DB (these are left behind, the storage sweep would delete them anyway)
+	StrayBlobs     uint64
+	StrayManifests uint64
+}
+
+var storageMigrationManifestsQuery = sqlext.SimplifyWhitespace(`
+	SELECT r.name, m.digest FROM manifests m
+	  JOIN repos r ON r.id = m.repo_id
+	 WHERE r.account_name = $1
+	 ORDER BY r.name, m.digest
+`)
+
+// MigrateAccountStorage copies all blobs and manifests of the given account
+// from this processor's storage driver into the given target storage driver,
+// verifying their digests along the way. Blobs retain their storage IDs, so no
+// DB changes are required once the target storage driver is put into use.
+// Blobs that have been moved into the global blob store are not copied, since
+// their contents are not stored in the account's storage location.
+//
+// The account must be in read-only mode, otherwise new contents could be
+// pushed into the source storage while the migration is ongoing.
+func (p *Processor) MigrateAccountStorage(ctx context.Context, account models.ReducedAccount, targetSD keppel.StorageDriver) (report StorageMigrationReport, err error) {
+	if !account.IsReadOnly {
+		return report, fmt.Errorf("account %s must be in read-only mode during a storage migration", account.Name)
+	}
+	err = targetSD.CanSetupAccount(ctx, account)
+	if err != nil {
+		return report, fmt.Errorf("cannot set up account %s in target storage: %w", account.Name, err)
+	}
+
+	// the DB is authoritative for what needs to be copied
+	var blobs []models.Blob
+	_, err = p.db.Select(&blobs, `SELECT * FROM blobs WHERE account_name = $1 AND NOT is_global ORDER BY id`, account.Name)
+	if err != nil {
+		return report, err
+	}
+	var manifests []keppel.StoredManifestInfo
+	err = sqlext.ForeachRow(p.db, storageMigrationManifestsQuery, []any{account.Name}, func(rows *sql.Rows) error {
+		var m keppel.StoredManifestInfo
+		err := rows.Scan(&m.RepoName, &m.Digest)
+		manifests = append(manifests, m)
+		return err
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// the listing of the source storage is only used to report objects that will not be copied
+	isKnownStorageID := make(map[string]bool, len(blobs))
+	for _, blob := range blobs {
+		isKnownStorageID[blob.StorageID] = true
+	}
+	isKnownManifest := make(map[keppel.StoredManifestInfo]bool, len(manifests))
+	for _, manifest := range manifests {
+		isKnownManifest[manifest] = true
+	}
+	err = p.sd.ListStorageContents(ctx, account,
+		func(storedBlobs []keppel.StoredBlobInfo) error {
+			for _, storedBlob := range storedBlobs {
+				if !isKnownStorageID[storedBlob.StorageID] {
+					report.StrayBlobs++
+				}
+			}
+			return nil
+		},
+		func(storedManifests []keppel.StoredManifestInfo) error {
+			for _, storedManifest := range storedManifests {
+				if !isKnownManifest[storedManifest] {
+					report.StrayManifests++
+				}
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return report, fmt.Errorf("cannot list contents of source storage: %w", err)
+	}
+
+	for _, blob := range blobs {
+		err := p.migrateBlob(ctx, account, blob, targetSD)
+		if err != nil {
+			return report, fmt.Errorf("cannot migrate blob %s: %w", blob.Digest, err)
+		}
+		report.CopiedBlobs++
+		report.CopiedBytes += blob.SizeBytes
+	}
+	for _, manifest := range manifests {
+		err := p.migrateManifest(ctx, account, manifest, targetSD)
+		if err != nil {
+			return report, fmt.Errorf("cannot migrate manifest %s/%s@%s: %w", account.Name, manifest.RepoName, manifest.Digest, err)
+		}
+		report.CopiedManifests++
+	}
+	return report, nil
+}
+
+func (p *Processor) migrateBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob, targetSD keppel.StorageDriver) (returnErr error) {
+	contents, sizeBytes, err := p.sd.ReadBlob(ctx, account, blob.StorageID)
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+	if sizeBytes != blob.SizeBytes {
+		return fmt.Errorf("expected %d bytes in source storage, but found %d bytes", blob.SizeBytes, sizeBytes)
+	}
+
+	// if anything goes wrong, do not leave a partial upload in the target storage
+	upload := models.Upload{StorageID: blob.StorageID}
+	defer func() {
+		if returnErr != nil && upload.NumChunks > 0 {
+			err := targetSD.AbortBlobUpload(ctx, account, blob.StorageID, upload.NumChunks)
+			if err != nil {
+				logg.Error("additional error encountered during AbortBlobUpload: " + err.Error())
+			}
+		}
+	}()
+
+	verifier := blob.Digest.Verifier()
+	err = appendToBlobIn(ctx, targetSD, account, &upload, io.TeeReader(contents, verifier), &sizeBytes)
+	if err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("contents in source storage do not match digest %s", blob.Digest)
+	}
+	err = targetSD.FinalizeBlob(ctx, account, blob.StorageID, upload.NumChunks)
+	if err != nil {
+		return err
+	}
+	upload.NumChunks = 0 // do not abort the upload after a successful FinalizeBlob
+	return nil
+}
+
+func (p *Processor) migrateManifest(ctx context.Context, account models.ReducedAccount, manifest keppel.StoredManifestInfo, targetSD keppel.StorageDriver) error {
+	contents, err := p.sd.ReadManifest(ctx, account, manifest.RepoName, manifest.Digest)
+	if err != nil {
+		return err
+	}
+	actualDigest := manifest.Digest.Algorithm().FromBytes(contents)
+	if actualDigest != manifest.Digest {
+		return fmt.Errorf("contents in source storage have digest %s", actualDigest)
+	}
+	return targetSD.WriteManifest(ctx, account, manifest.RepoName, manifest.Digest, contents)
+}
@@ -0,0 +1,106 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package processor_test
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/easypg"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/processor"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestMain(m *testing.M) {
+	easypg.WithTestDB(m, func() int { return m.Run() })
+}
+
+func TestMigrateAccountStorage(t *testing.T) {
+	s := test.NewSetup(t,
+		test.WithAccount(models.Account{Name: "test1", AuthTenantID: "test1authtenant"}),
+		test.WithRepo(models.Repository{AccountName: "test1", Name: "foo"}),
+		test.WithQuotas,
+	)
+	image := test.GenerateImage(test.GenerateExampleLayer(1), test.GenerateExampleLayer(2))
+	image.MustUpload(t, s, models.Repository{AccountName: "test1", Name: "foo"}, "latest")
+
+	p := processor.New(s.Config, s.DB, s.SD, s.ICD, s.Auditor, s.FD, s.Clock.Now)
+	targetSD, err := keppel.NewStorageDriver("in-memory-for-testing", s.AD, s.Config)
+	mustDo(t, err)
+	account, err := keppel.FindReducedAccount(s.DB, "test1")
+	mustDo(t, err)
+
+	// migration is refused unless the account is read-only
+	_, err = p.MigrateAccountStorage(s.Ctx, *account, targetSD)
+	expectError(t, "account test1 must be in read-only mode during a storage migration", err)
+
+	// happy path
+	account.IsReadOnly = true
+	report, err := p.MigrateAccountStorage(s.Ctx, *account, targetSD)
+	mustDo(t, err)
+	assert.DeepEqual(t, "report", report, processor.StorageMigrationReport{
+		CopiedBlobs:     3,
+		CopiedBytes:     image.SizeBytes() - uint64(len(image.Manifest.Contents)),
+		CopiedManifests: 1,
+	})
+
+	// all contents are present in the target storage with the same storage IDs
+	var blobs []models.Blob
+	_, err = s.DB.Select(&blobs, `SELECT * FROM blobs WHERE account_name = $1`, "test1")
+	mustDo(t, err)
+	for _, blob := range blobs {
+		reader, _, err := targetSD.ReadBlob(s.Ctx, *account, blob.StorageID)
+		mustDo(t, err)
+		contents, err := io.ReadAll(reader)
+		mustDo(t, err)
+		assert.DeepEqual(t, "blob digest", blob.Digest.Algorithm().FromBytes(contents), blob.Digest)
+	}
+	manifestContents, err := targetSD.ReadManifest(s.Ctx, *account, "foo", image.Manifest.Digest)
+	mustDo(t, err)
+	assert.DeepEqual(t, "manifest contents", string(manifestContents), string(image.Manifest.Contents))
+
+	// corrupted contents in the source storage are not copied
+	wrongDigest := test.DeterministicDummyDigest(1)
+	_, err = s.DB.Exec(`UPDATE blobs SET digest = $1 WHERE digest = $2`, wrongDigest, image.Layers[0].Digest)
+	mustDo(t, err)
+	_, err = p.MigrateAccountStorage(s.Ctx, *account, targetSD)
+	expectError(t, fmt.Sprintf("cannot migrate blob %[1]s: contents in source storage do not match digest %[1]s", wrongDigest), err)
+}
+
+func mustDo(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func expectError(t *testing.T, expected string, actual error) {
+	t.Helper()
+	if actual == nil {
+		t.Errorf("expected err = %q, but got <nil>", expected)
+	} else if expected != actual.Error() {
+		t.Errorf("expected err = %q, but got %q", expected, actual.Error())
+	}
+}
@@ -0,0 +1,194 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+// Package squash merges the layers of a container image into a single layer.
+package squash
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// LayerOpener returns a reader for the uncompressed tar stream of a layer. It
+// may be called more than once for the same layer.
+type LayerOpener func() (io.ReadCloser, error)
+
+// Layers merges the given layers (in the order in which they appear in the
+// image manifest, i.e. the base layer first) into one layer, and writes the
+// resulting uncompressed tar stream into `out`. Whiteout files are applied to
+// the lower layers and do not appear in the result.
+//
+// Each layer is read twice: First from the top layer down to determine which
+// entries are visible in the final filesystem, then from the base layer up to
+// write the visible entries in an order that keeps hardlink targets in front
+// of their links.
+func Layers(layers []LayerOpener, out io.Writer) error {
+	// pass 1: find which entry of which layer provides each path
+	winners := make(map[string]entryID)
+	upper := newUpperLayers()
+	for idx := len(layers) - 1; idx >= 0; idx-- {
+		current := newUpperLayers()
+		err := foreachEntry(layers[idx], func(entryIdx int, hdr *tar.Header, name string, _ io.Reader) error {
+			dir, base := path.Split(name)
+			dir = strings.TrimSuffix(dir, "/")
+			switch {
+			case base == opaqueWhiteout:
+				current.opaqueDirs[dir] = true
+			case strings.HasPrefix(base, whiteoutPrefix):
+				current.deleted[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = true
+			default:
+				current.entries[name] = hdr.Typeflag == tar.TypeDir
+				if upper.hides(name) {
+					return nil
+				}
+				// within the same layer, later entries override earlier ones
+				winners[name] = entryID{idx, entryIdx}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("while reading layer %d: %w", idx+1, err)
+		}
+		upper.merge(current)
+	}
+
+	// pass 2: write the visible entries from the bottom up
+	tw := tar.NewWriter(out)
+	for idx := range layers {
+		err := foreachEntry(layers[idx], func(entryIdx int, hdr *tar.Header, name string, contents io.Reader) error {
+			if winners[name] != (entryID{idx, entryIdx}) {
+				return nil
+			}
+			err := tw.WriteHeader(hdr)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, contents)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("while copying layer %d: %w", idx+1, err)
+		}
+	}
+	return tw.Close()
+}
+
+type entryID struct {
+	LayerIdx int
+	EntryIdx int
+}
+
+// foreachEntry calls the action for all entries in the given layer, along with
+// the entry's index, its normalized path and a reader for its contents.
+func foreachEntry(open LayerOpener, action func(int, *tar.Header, string, io.Reader) error) (returnErr error) {
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := rc.Close()
+		if returnErr == nil {
+			returnErr = err
+		}
+	}()
+
+	tr := tar.NewReader(rc)
+	for entryIdx := 0; ; entryIdx++ {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := normalizePath(hdr.Name)
+		if name == "" {
+			// the root directory itself
+			continue
+		}
+		err = action(entryIdx, hdr, name, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func normalizePath(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+// upperLayers collects the effects that the layers above the current one have
+// on the visibility of entries in the current layer.
+type upperLayers struct {
+	entries    map[string]bool // value = whether the entry is a directory
+	deleted    map[string]bool // paths removed by whiteout files (including their subtrees)
+	opaqueDirs map[string]bool // directories whose lower contents were removed by opaque whiteouts
+}
+
+func newUpperLayers() upperLayers {
+	return upperLayers{
+		entries:    make(map[string]bool),
+		deleted:    make(map[string]bool),
+		opaqueDirs: make(map[string]bool),
+	}
+}
+
+func (u upperLayers) merge(other upperLayers) {
+	for name, isDir := range other.entries {
+		u.entries[name] = isDir
+	}
+	for name := range other.deleted {
+		u.deleted[name] = true
+	}
+	for name := range other.opaqueDirs {
+		u.opaqueDirs[name] = true
+	}
+}
+
+// hides returns whether an entry with the given path in a lower layer is
+// invisible in the final filesystem.
+func (u upperLayers) hides(name string) bool {
+	if _, exists := u.entries[name]; exists || u.deleted[name] {
+		return true
+	}
+	if u.opaqueDirs[""] {
+		return true
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if u.deleted[dir] || u.opaqueDirs[dir] {
+			return true
+		}
+		// if an upper layer replaced a parent directory with a non-directory
+		// (e.g. a symlink), nothing below that path is visible
+		if isDir, exists := u.entries[dir]; exists && !isDir {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package squash
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+)
+
+type testEntry struct {
+	Name     string
+	Type     byte
+	Contents string
+	Linkname string
+}
+
+func buildLayer(t *testing.T, entries ...testEntry) LayerOpener {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.Name, Typeflag: e.Type, Mode: 0o644, Linkname: e.Linkname}
+		if e.Type == tar.TypeReg {
+			hdr.Size = int64(len(e.Contents))
+		}
+		if e.Type == tar.TypeDir {
+			hdr.Mode = 0o755
+		}
+		err := tw.WriteHeader(hdr)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_, err = tw.Write([]byte(e.Contents))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	contents := buf.Bytes()
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(contents)), nil
+	}
+}
+
+func readLayer(t *testing.T, buf []byte) []testEntry {
+	t.Helper()
+	var result []testEntry
+	tr := tar.NewReader(bytes.NewReader(buf))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return result
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		result = append(result, testEntry{hdr.Name, hdr.Typeflag, string(contents), hdr.Linkname})
+	}
+}
+
+func dir(name string) testEntry { return testEntry{Name: name, Type: tar.TypeDir} }
+func file(name, contents string) testEntry {
+	return testEntry{Name: name, Type: tar.TypeReg, Contents: contents}
+}
+func symlink(name, target string) testEntry {
+	return testEntry{Name: name, Type: tar.TypeSymlink, Linkname: target}
+}
+
+func TestSquashLayers(t *testing.T) {
+	base := buildLayer(t,
+		dir("etc/"),
+		file("etc/hostname", "base"),
+		file("etc/passwd", "root"),
+		dir("var/"),
+		dir("var/cache/"),
+		file("var/cache/a", "a"),
+		file("var/cache/b", "b"),
+		dir("opt/"),
+		file("opt/tool", "v1"),
+		dir("lib/"),
+		file("lib/libc.so", "libc"),
+	)
+	middle := buildLayer(t,
+		file("etc/hostname", "middle"),
+		// remove the contents of var/cache, but keep the directory itself
+		file("var/cache/.wh..wh..opq", ""),
+		file("var/cache/c", "c"),
+		// remove a single file
+		file("etc/.wh.passwd", ""),
+		// replace a directory with a symlink
+		file(".wh.lib", ""),
+		symlink("lib", "usr/lib"),
+	)
+	top := buildLayer(t,
+		file("./opt/tool", "v2"),
+		// files in this layer override files with the same name in the same layer
+		file("opt/other", "old"),
+		file("opt/other", "new"),
+		file("etc/passwd", "restored"),
+	)
+
+	var buf bytes.Buffer
+	err := Layers([]LayerOpener{base, middle, top}, &buf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// entries appear in the order of the layers, with hidden entries removed
+	assert.DeepEqual(t, "squashed layer", readLayer(t, buf.Bytes()), []testEntry{
+		dir("etc/"),
+		dir("var/"),
+		dir("var/cache/"),
+		dir("opt/"),
+		file("etc/hostname", "middle"),
+		file("var/cache/c", "c"),
+		symlink("lib", "usr/lib"),
+		file("./opt/tool", "v2"),
+		file("opt/other", "new"),
+		file("etc/passwd", "restored"),
+	})
+}
+
+func TestSquashInvalidLayer(t *testing.T) {
+	broken := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader([]byte("this is not a tar file"))), nil
+	}
+	err := Layers([]LayerOpener{buildLayer(t, file("foo", "bar")), broken}, io.Discard)
+	if err == nil {
+		t.Fatal("expected error, but got nil")
+	}
+	assert.DeepEqual(t, "error message", err.Error(), "while reading layer 2: unexpected EOF")
+}
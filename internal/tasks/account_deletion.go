@@ -46,7 +46,7 @@ func (j *Janitor) DeleteAccountsJob(registerer prometheus.Registerer) jobloop.Jo
 			},
 		},
 		DiscoverTask: j.discoverAccountForDeletion,
-		ProcessTask:  j.deleteMarkedAccount,
+		ProcessTask:  trackTask(j, "account_deletion", j.deleteMarkedAccount),
 	}).Setup(registerer)
 }
 
@@ -29,6 +29,7 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/go-bits/sqlext"
 
 	"github.com/sapcc/keppel/internal/auth"
@@ -48,7 +49,7 @@ func (j *Janitor) EnforceManagedAccountsJob(registerer prometheus.Registerer) jo
 			},
 		},
 		DiscoverTask: j.discoverManagedAccount,
-		ProcessTask:  j.enforceManagedAccount,
+		ProcessTask:  trackTask(j, "managed_account_enforcement", j.enforceManagedAccount),
 	}).Setup(registerer)
 }
 
@@ -92,29 +93,18 @@ func (j *Janitor) enforceManagedAccount(ctx context.Context, accountName models.
 		return fmt.Errorf("could not ConfigureAccount(%q) in account management driver: %w", accountName, err)
 	}
 
-	// the error returned from either tryDeleteManagedAccount or createOrUpdateManagedAccount is the main error that this method returns...
+	// the error returned from either handleRemovedManagedAccount or createOrUpdateManagedAccount is the main error that this method returns...
 	var nextCheckDuration time.Duration
 	if account == nil {
 		var accountModel *models.Account
 		accountModel, err = keppel.FindAccount(j.db, accountName)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				nextCheckDuration = 0 // assume the account got already deleted
-			} else {
-				return err
-			}
+			return err
+		}
+		if accountModel == nil {
+			nextCheckDuration = 0 // assume the account got already deleted
 		} else {
-			actx := keppel.AuditContext{
-				UserIdentity: janitorUserIdentity{TaskName: "managed-account-enforcement"},
-				Request:      janitorDummyRequest,
-			}
-			err = j.processor().MarkAccountForDeletion(*accountModel, actx)
-			if err == nil {
-				nextCheckDuration = 1 * time.Hour // account will be deleted -> defer next check until probably after it was deleted
-			} else {
-				err = fmt.Errorf("could not mark account %q for deletion: %w", accountName, err)
-				nextCheckDuration = 5 * time.Minute // default interval for recheck after error
-			}
+			nextCheckDuration, err = j.handleRemovedManagedAccount(ctx, *accountModel)
 		}
 	} else {
 		err = j.createOrUpdateManagedAccount(ctx, *account, securityScanPolicies)
@@ -138,9 +128,88 @@ func (j *Janitor) enforceManagedAccount(ctx context.Context, accountName models.
 	}
 }
 
+// ManagedAccountDeletionNotification is the payload that is POSTed to the
+// KEPPEL_MANAGED_ACCOUNT_DELETION_WEBHOOK_URL when the deletion of a managed
+// account is scheduled.
+type ManagedAccountDeletionNotification struct {
+	AccountName  models.AccountName `json:"account"`
+	AuthTenantID string             `json:"auth_tenant_id"`
+	// When the account will be marked for deletion (UNIX timestamp).
+	DeletionScheduledAt int64 `json:"deletion_scheduled_at"`
+}
+
+// Handles a managed account that has disappeared from the account management
+// driver's config. To guard against typos in the config, the account is only
+// marked for deletion after the configured grace period. Returns when the
+// account shall be checked next.
+func (j *Janitor) handleRemovedManagedAccount(ctx context.Context, account models.Account) (time.Duration, error) {
+	actx := keppel.AuditContext{
+		UserIdentity: janitorUserIdentity{TaskName: "managed-account-enforcement"},
+		Request:      janitorDummyRequest,
+	}
+	now := j.timeNow()
+	gracePeriod := j.cfg.ManagedAccountDeletionGracePeriod
+
+	if !account.IsDeleting {
+		switch {
+		case account.DeletionScheduledAt != nil:
+			// deletion was scheduled in an earlier run -> wait for the grace period to end
+			if remaining := account.DeletionScheduledAt.Sub(now); remaining > 0 {
+				return min(remaining, 1*time.Hour), nil
+			}
+		case gracePeriod > 0:
+			// first run since the account disappeared -> schedule the deletion
+			deleteAt := now.Add(gracePeriod)
+			err := j.processor().ScheduleAccountDeletion(account, deleteAt, actx)
+			if err != nil {
+				return 5 * time.Minute, fmt.Errorf("could not schedule deletion of account %q: %w", account.Name, err)
+			}
+			logg.Info("managed account %q is not configured anymore and will be marked for deletion at %s", account.Name, deleteAt.UTC().Format(time.RFC3339))
+			err = j.notifyAboutManagedAccountDeletion(ctx, account, deleteAt)
+			return min(gracePeriod, 1*time.Hour), err
+		default:
+			// no grace period -> the account is marked for deletion right away
+			err := j.notifyAboutManagedAccountDeletion(ctx, account, now)
+			if err != nil {
+				return 5 * time.Minute, err
+			}
+		}
+	}
+
+	err := j.processor().MarkAccountForDeletion(account, actx)
+	if err != nil {
+		return 5 * time.Minute, fmt.Errorf("could not mark account %q for deletion: %w", account.Name, err)
+	}
+	return 1 * time.Hour, nil // account will be deleted -> defer next check until probably after it was deleted
+}
+
+func (j *Janitor) notifyAboutManagedAccountDeletion(ctx context.Context, account models.Account, deleteAt time.Time) error {
+	if j.cfg.ManagedAccountDeletionWebhookURL == "" {
+		return nil
+	}
+	buf, err := json.Marshal(ManagedAccountDeletionNotification{
+		AccountName:         account.Name,
+		AuthTenantID:        account.AuthTenantID,
+		DeletionScheduledAt: deleteAt.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	err = postJSONToWebhook(ctx, j.cfg.ManagedAccountDeletionWebhookURL, buf)
+	if err != nil {
+		return fmt.Errorf("could not send notification about deletion of account %q: %w", account.Name, err)
+	}
+	return nil
+}
+
 func (j *Janitor) createOrUpdateManagedAccount(ctx context.Context, account keppel.Account, securityScanPolicies []keppel.SecurityScanPolicy) error {
 	userIdentity := janitorUserIdentity{TaskName: "account-management"}
 
+	err := j.validateManagedPlatformFilter(ctx, account)
+	if err != nil {
+		return err
+	}
+
 	// if the managed account is an internal replica, the processor needs to ask the primary account for a sublease token
 	getSubleaseToken := func(peer models.Peer) (keppel.SubleaseToken, error) {
 		viewScope := auth.Scope{
@@ -164,6 +233,10 @@ func (j *Janitor) createOrUpdateManagedAccount(ctx context.Context, account kepp
 	}
 	setCustomFields := func(account *models.Account) *keppel.RegistryV2Error {
 		account.IsManaged = true
+		if account.DeletionScheduledAt != nil {
+			logg.Info("cancelling scheduled deletion of managed account %q since it is configured again", account.Name)
+			account.DeletionScheduledAt = nil
+		}
 		account.SecurityScanPoliciesJSON = string(jsonBytes)
 		nextAt := j.timeNow().Add(j.addJitter(1 * time.Hour))
 		account.NextEnforcementAt = &nextAt
@@ -177,3 +250,249 @@ func (j *Janitor) createOrUpdateManagedAccount(ctx context.Context, account kepp
 	}
 	return nil
 }
+
+// Checks the platform filter of a managed account before the account is
+// created or updated. Without this check, incompatible filters would either be
+// rejected with rather unspecific errors by CreateOrUpdateAccount, or only
+// surface later during replication.
+func (j *Janitor) validateManagedPlatformFilter(ctx context.Context, account keppel.Account) error {
+	if account.PlatformFilter == nil {
+		return nil
+	}
+	jsonFilter, _ := json.Marshal(account.PlatformFilter)
+
+	if account.ReplicationPolicy == nil {
+		return fmt.Errorf("platform filter %s is only allowed on replica accounts", jsonFilter)
+	}
+
+	// the platform filter cannot be changed once the account exists
+	existingAccount, err := keppel.FindAccount(j.db, account.Name)
+	if err != nil {
+		return err
+	}
+	if existingAccount != nil && !existingAccount.PlatformFilter.IsEqualTo(account.PlatformFilter) {
+		jsonExistingFilter, _ := json.Marshal(existingAccount.PlatformFilter)
+		return fmt.Errorf("platform filter %s differs from the platform filter %s that the account was created with, but platform filters cannot be changed on existing accounts",
+			jsonFilter, jsonExistingFilter)
+	}
+
+	// for internal replicas, the platform filter must match that of the primary account
+	if account.ReplicationPolicy.Strategy != keppel.OnFirstUseStrategy {
+		return nil
+	}
+	peer, err := keppel.GetPeerFromAccount(j.db, models.Account{UpstreamPeerHostName: account.ReplicationPolicy.UpstreamPeerHostName})
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("unknown peer registry: %q", account.ReplicationPolicy.UpstreamPeerHostName)
+	}
+	if err != nil {
+		return err
+	}
+	primaryFilter, err := j.processor().GetPlatformFilterFromPrimaryAccount(ctx, peer, models.Account{Name: account.Name})
+	if err != nil {
+		return fmt.Errorf("could not get platform filter of primary account from %s: %w", peer.HostName, err)
+	}
+	if !primaryFilter.IsEqualTo(account.PlatformFilter) {
+		jsonPrimaryFilter, _ := json.Marshal(primaryFilter)
+		return fmt.Errorf("platform filter %s is not compatible with the platform filter %s of the primary account on %s",
+			jsonFilter, jsonPrimaryFilter, peer.HostName)
+	}
+	return nil
+}
+
+// ManagedAccountChange appears in the result of PreviewManagedAccounts().
+type ManagedAccountChange struct {
+	AccountName models.AccountName `json:"account"`
+	// One of "create", "update", "schedule_deletion" or "delete".
+	Action string                      `json:"action"`
+	Fields []ManagedAccountFieldChange `json:"fields,omitempty"`
+	// If not empty, EnforceManagedAccountsJob would fail on this account.
+	Error string `json:"error,omitempty"`
+}
+
+// ManagedAccountFieldChange appears in type ManagedAccountChange. The field
+// names and values are those from the account's representation in the Keppel
+// API, except for the pseudo-field "security_scan_policies".
+type ManagedAccountFieldChange struct {
+	Field    string          `json:"field"`
+	OldValue json.RawMessage `json:"old,omitempty"`
+	NewValue json.RawMessage `json:"new,omitempty"`
+}
+
+// PreviewManagedAccounts computes which changes EnforceManagedAccountsJob
+// would make to the managed accounts based on the current configuration of
+// the account management driver, without making any changes itself. Accounts
+// that would not change are not included in the result.
+//
+// Checks that require talking to the federation driver, the storage driver or
+// a peer (e.g. whether a replica's platform filter matches that of its primary
+// account) are not performed, so the actual enforcement may still fail in
+// places where this preview does not report an error.
+func (j *Janitor) PreviewManagedAccounts() ([]ManagedAccountChange, error) {
+	managedAccountNames, err := j.amd.ManagedAccountNames()
+	if err != nil {
+		return nil, fmt.Errorf("could not get ManagedAccountNames() from account management driver: %w", err)
+	}
+	var existingAccounts []models.Account
+	_, err = j.db.Select(&existingAccounts, "SELECT * FROM accounts WHERE is_managed ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	existingAccountsByName := make(map[models.AccountName]models.Account, len(existingAccounts))
+	for _, account := range existingAccounts {
+		existingAccountsByName[account.Name] = account
+	}
+
+	accountNames := slices.Clone(managedAccountNames)
+	for _, account := range existingAccounts {
+		if !slices.Contains(accountNames, account.Name) {
+			accountNames = append(accountNames, account.Name)
+		}
+	}
+	slices.Sort(accountNames)
+
+	var result []ManagedAccountChange
+	for _, accountName := range accountNames {
+		// accounts that are only in the DB are still passed through the driver
+		// like in enforceManagedAccount(), since the driver decides about their deletion
+		change, err := j.previewManagedAccount(accountName)
+		if err != nil {
+			return nil, err
+		}
+		if change != nil {
+			result = append(result, *change)
+		}
+	}
+	return result, nil
+}
+
+func (j *Janitor) previewManagedAccount(accountName models.AccountName) (*ManagedAccountChange, error) {
+	// this also finds unmanaged accounts with the same name, which would be taken over by the driver
+	originalAccount, err := keppel.FindAccount(j.db, accountName)
+	if err != nil {
+		return nil, err
+	}
+	change := ManagedAccountChange{AccountName: accountName, Action: "create"}
+	if originalAccount != nil {
+		change.Action = "update"
+	}
+
+	account, securityScanPolicies, err := j.amd.ConfigureAccount(accountName)
+	if err != nil {
+		change.Error = fmt.Sprintf("could not ConfigureAccount(%q) in account management driver: %s", accountName, err.Error())
+		return &change, nil
+	}
+
+	// mirror the logic of handleRemovedManagedAccount()
+	if account == nil {
+		switch {
+		case originalAccount == nil || originalAccount.IsDeleting:
+			return nil, nil
+		case originalAccount.DeletionScheduledAt != nil:
+			if originalAccount.DeletionScheduledAt.After(j.timeNow()) {
+				return nil, nil // deletion is already scheduled, but not due yet
+			}
+			change.Action = "delete"
+		case j.cfg.ManagedAccountDeletionGracePeriod > 0:
+			change.Action = "schedule_deletion"
+		default:
+			change.Action = "delete"
+		}
+		return &change, nil
+	}
+
+	// mirror the logic of createOrUpdateManagedAccount()
+	targetAccount, _, rerr := j.processor().ApplyAccountConfiguration(*account, originalAccount)
+	if rerr != nil {
+		change.Error = rerr.Error()
+		return &change, nil
+	}
+	if originalAccount == nil && account.PlatformFilter != nil {
+		targetAccount.PlatformFilter = account.PlatformFilter
+	}
+	targetAccount.DeletionScheduledAt = nil
+	jsonBytes, err := json.Marshal(securityScanPolicies)
+	if err != nil {
+		return nil, err
+	}
+	targetAccount.SecurityScanPoliciesJSON = string(jsonBytes)
+
+	change.Fields, err = diffManagedAccount(originalAccount, targetAccount)
+	if err != nil {
+		return nil, err
+	}
+	if originalAccount != nil && len(change.Fields) == 0 {
+		return nil, nil
+	}
+	return &change, nil
+}
+
+// Compares the API representations of the given account models field by field.
+func diffManagedAccount(originalAccount *models.Account, targetAccount models.Account) ([]ManagedAccountFieldChange, error) {
+	renderFields := func(account *models.Account) (map[string]json.RawMessage, error) {
+		fields := make(map[string]json.RawMessage)
+		if account == nil {
+			return fields, nil
+		}
+		rendered, err := keppel.RenderAccount(*account)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := json.Marshal(rendered)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(buf, &fields)
+		if err != nil {
+			return nil, err
+		}
+		// the security scan policies are not part of the API representation,
+		// but they are managed by the driver as well
+		var policies []keppel.SecurityScanPolicy
+		if account.SecurityScanPoliciesJSON != "" {
+			err = json.Unmarshal([]byte(account.SecurityScanPoliciesJSON), &policies)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(policies) > 0 {
+			fields["security_scan_policies"], err = json.Marshal(policies)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return fields, nil
+	}
+
+	oldFields, err := renderFields(originalAccount)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := renderFields(&targetAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	var fieldNames []string
+	for fieldName := range newFields {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	for fieldName := range oldFields {
+		if _, exists := newFields[fieldName]; !exists {
+			fieldNames = append(fieldNames, fieldName)
+		}
+	}
+	slices.Sort(fieldNames)
+
+	var result []ManagedAccountFieldChange
+	for _, fieldName := range fieldNames {
+		// these fields are either immutable or not controlled by the driver
+		if fieldName == "name" || fieldName == "in_maintenance" || fieldName == "metadata" {
+			continue
+		}
+		oldValue, newValue := oldFields[fieldName], newFields[fieldName]
+		if string(oldValue) != string(newValue) {
+			result = append(result, ManagedAccountFieldChange{fieldName, oldValue, newValue})
+		}
+	}
+	return result, nil
+}
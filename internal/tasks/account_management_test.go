@@ -20,10 +20,14 @@ package tasks
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"testing"
 	"time"
 
+	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/assert"
 	"github.com/sapcc/go-bits/easypg"
 
 	"github.com/sapcc/keppel/internal/models"
@@ -84,6 +88,182 @@ func TestAccountManagementBasic(t *testing.T) {
 	`)
 }
 
+func TestPreviewManagedAccounts(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	managedAccountsJob := j.EnforceManagedAccountsJob(s.Registry)
+
+	// only report the action and names of changed fields to keep the test readable
+	expectPreview := func(expected map[models.AccountName]string) {
+		t.Helper()
+		changes, err := j.PreviewManagedAccounts()
+		mustDo(t, err)
+		actual := make(map[models.AccountName]string, len(changes))
+		for _, change := range changes {
+			summary := change.Action
+			for _, field := range change.Fields {
+				summary += " " + field.Field
+			}
+			if change.Error != "" {
+				summary += " (error: " + change.Error + ")"
+			}
+			actual[change.AccountName] = summary
+		}
+		assert.DeepEqual(t, "preview", actual, expected)
+	}
+
+	// without any configured accounts, nothing would change
+	s.AMD.ConfigPath = "./fixtures/account_management_empty.json"
+	expectPreview(map[models.AccountName]string{})
+
+	// a new account in the config would be created...
+	s.AMD.ConfigPath = "../drivers/basic/fixtures/account_management.json"
+	expectPreview(map[models.AccountName]string{
+		"abcde": "create auth_tenant_id gc_policies rbac_policies replication security_scan_policies validation",
+	})
+
+	// ...but the preview does not change anything by itself
+	tr, tr0 := easypg.NewTracker(t, s.DB.DbMap.Db)
+	tr0.Ignore()
+	_, err := j.PreviewManagedAccounts()
+	mustDo(t, err)
+	tr.DBChanges().AssertEmpty()
+
+	// once the account has been enforced, nothing would change anymore
+	expectSuccess(t, managedAccountsJob.ProcessOne(s.Ctx))
+	tr.DBChanges().Ignore()
+	expectPreview(map[models.AccountName]string{})
+
+	// when policies are removed from the config, the preview shows which fields would change
+	s.AMD.ConfigPath = "./fixtures/account_management_basic.json"
+	expectPreview(map[models.AccountName]string{
+		"abcde": "update gc_policies rbac_policies security_scan_policies",
+	})
+
+	// invalid configuration is reported as an error
+	s.AMD.ConfigPath = "./fixtures/account_management_error.json"
+	expectPreview(map[models.AccountName]string{
+		"":      "create (error: " + processor.ErrAccountNameEmpty.Error() + ")",
+		"abcde": "delete",
+	})
+
+	// when the account is removed from the config, it would be deleted, or
+	// scheduled for deletion if there is a grace period
+	s.AMD.ConfigPath = "./fixtures/account_management_empty.json"
+	expectPreview(map[models.AccountName]string{
+		"abcde": "delete",
+	})
+	j.cfg.ManagedAccountDeletionGracePeriod = 48 * time.Hour
+	expectPreview(map[models.AccountName]string{
+		"abcde": "schedule_deletion",
+	})
+	tr.DBChanges().AssertEmpty()
+}
+
+func TestAccountManagementWithDeletionGracePeriod(t *testing.T) {
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		j, s := setup(t)
+		j.cfg.ManagedAccountDeletionGracePeriod = 48 * time.Hour
+		j.cfg.ManagedAccountDeletionWebhookURL = "https://webhook.example.org/deletions"
+		managedAccountsJob := j.EnforceManagedAccountsJob(s.Registry)
+
+		// capture the notifications that are sent to the webhook
+		var notifications []ManagedAccountDeletionNotification
+		tt.Handlers["webhook.example.org"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var n ManagedAccountDeletionNotification
+			mustDo(t, json.NewDecoder(r.Body).Decode(&n))
+			notifications = append(notifications, n)
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		tr, tr0 := easypg.NewTracker(t, s.DB.DbMap.Db)
+		tr0.Ignore()
+
+		// create a managed account named "abcde"
+		s.AMD.ConfigPath = "./fixtures/account_management_basic.json"
+		expectSuccess(t, managedAccountsJob.ProcessOne(s.Ctx))
+		expectError(t, sql.ErrNoRows.Error(), managedAccountsJob.ProcessOne(s.Ctx))
+		tr.DBChanges().Ignore()
+		s.Auditor.IgnoreEventsUntilNow()
+
+		// remove the managed account: this only schedules the deletion
+		s.AMD.ConfigPath = "./fixtures/account_management_empty.json"
+		s.Clock.StepBy(2 * time.Hour)
+		expectSuccess(t, managedAccountsJob.ProcessOne(s.Ctx))
+		deleteAt := s.Clock.Now().Add(48 * time.Hour)
+		tr.DBChanges().AssertEqualf(`
+				UPDATE accounts SET next_enforcement_at = %d, deletion_scheduled_at = %d WHERE name = 'abcde';
+			`,
+			s.Clock.Now().Add(1*time.Hour).Unix(),
+			deleteAt.Unix(),
+		)
+		assert.DeepEqual(t, "notifications", notifications, []ManagedAccountDeletionNotification{{
+			AccountName:         "abcde",
+			AuthTenantID:        "12345",
+			DeletionScheduledAt: deleteAt.Unix(),
+		}})
+		s.Auditor.ExpectEvents(t, cadf.Event{
+			RequestPath: janitorDummyRequest.URL.String(),
+			Action:      cadf.UpdateAction,
+			Outcome:     "success",
+			Reason:      test.CADFReasonOK,
+			Target: cadf.Resource{
+				TypeURI:   "docker-registry/account",
+				ID:        "abcde",
+				ProjectID: "12345",
+				Attachments: []cadf.Attachment{{
+					Name:    "deletion-scheduled-at",
+					TypeURI: "mime:application/json",
+					Content: fmt.Sprintf("%q", deleteAt.UTC().Format(time.RFC3339)),
+				}},
+			},
+			Initiator: cadf.Resource{
+				TypeURI: "service/docker-registry/janitor-task",
+				ID:      "managed-account-enforcement",
+				Name:    "managed-account-enforcement",
+				Domain:  "keppel",
+			},
+		})
+
+		// while the grace period runs, nothing happens
+		s.Clock.StepBy(2 * time.Hour)
+		expectSuccess(t, managedAccountsJob.ProcessOne(s.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE accounts SET next_enforcement_at = %d WHERE name = 'abcde';
+			`,
+			s.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+
+		// when the account reappears in the config, the deletion is cancelled
+		s.AMD.ConfigPath = "./fixtures/account_management_basic.json"
+		s.Clock.StepBy(2 * time.Hour)
+		expectSuccess(t, managedAccountsJob.ProcessOne(s.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE accounts SET next_enforcement_at = %d, deletion_scheduled_at = NULL WHERE name = 'abcde';
+			`,
+			s.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+
+		// when the account disappears again, the grace period starts anew...
+		s.AMD.ConfigPath = "./fixtures/account_management_empty.json"
+		s.Clock.StepBy(2 * time.Hour)
+		expectSuccess(t, managedAccountsJob.ProcessOne(s.Ctx))
+		tr.DBChanges().Ignore()
+		assert.DeepEqual(t, "notification count", len(notifications), 2)
+
+		// ...and the account is marked for deletion once it is over
+		s.Clock.StepBy(49 * time.Hour)
+		expectSuccess(t, managedAccountsJob.ProcessOne(s.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE accounts SET next_enforcement_at = %d, is_deleting = TRUE, next_deletion_attempt_at = %d WHERE name = 'abcde';
+			`,
+			s.Clock.Now().Add(1*time.Hour).Unix(),
+			s.Clock.Now().Unix(),
+		)
+		assert.DeepEqual(t, "notification count", len(notifications), 2)
+	})
+}
+
 func TestAccountManagementWithReplicaCreation(t *testing.T) {
 	test.WithRoundTripper(func(_ *test.RoundTripper) {
 		_, s1 := setup(t)
@@ -113,6 +293,29 @@ func TestAccountManagementWithReplicaCreation(t *testing.T) {
 	})
 }
 
+func TestAccountManagementWithIncompatiblePlatformFilter(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		_, s1 := setup(t)
+		j2, s2 := setupReplica(t, s1, "on_first_use")
+
+		tr, tr0 := easypg.NewTracker(t, s2.DB.DbMap.Db)
+		tr0.Ignore()
+
+		// the primary account does not have a platform filter...
+		mustDo(t, s1.DB.Insert(&models.Account{Name: "managed", AuthTenantID: "managedauthtenant"}))
+		s1.FD.NextSubleaseTokenSecretToIssue = "thisisasecret"
+		s2.FD.ValidSubleaseTokenSecrets["managed"] = "thisisasecret"
+
+		// ...so the managed replica cannot be created with a platform filter from a template
+		s2.AMD.ConfigPath = "./fixtures/account_management_replica_with_template.json"
+		job := j2.EnforceManagedAccountsJob(s2.Registry)
+		expectError(t,
+			`could not configure managed account "managed": platform filter [{"architecture":"amd64","os":"linux"}] is not compatible with the platform filter null of the primary account on registry.example.org`,
+			job.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEmpty()
+	})
+}
+
 func TestAccountManagementWithComplexDeletion(t *testing.T) {
 	j, s := setup(t)
 	managedAccountsJob := j.EnforceManagedAccountsJob(s.Registry)
@@ -0,0 +1,184 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+)
+
+var (
+	accountBlobBytesDesc = prometheus.NewDesc(
+		"keppel_account_blob_bytes",
+		"Total size of all blobs stored in a Keppel account.",
+		[]string{"account"}, nil,
+	)
+	accountManifestCountDesc = prometheus.NewDesc(
+		"keppel_account_manifest_count",
+		"Number of manifests stored in a Keppel account.",
+		[]string{"account"}, nil,
+	)
+	accountEgressBytesDesc = prometheus.NewDesc(
+		"keppel_account_egress_bytes_total",
+		"Number of bytes served for blob pulls from a Keppel account, as counted by keppel-api.",
+		[]string{"account"}, nil,
+	)
+	repoPullsDesc = prometheus.NewDesc(
+		"keppel_repo_pulls_total",
+		"Number of manifest pulls from a Keppel repository, as counted by keppel-api.",
+		[]string{"account", "repo"}, nil,
+	)
+)
+
+// The account metrics are ordered such that only the largest accounts are
+// exported when the number of series is capped.
+var accountMetricsQuery = sqlext.SimplifyWhitespace(`
+	WITH blob_stats AS (
+		SELECT account_name, SUM(size_bytes) AS bytes FROM blobs GROUP BY account_name
+	), manifest_stats AS (
+		SELECT r.account_name, COUNT(*) AS count FROM manifests m JOIN repos r ON r.id = m.repo_id GROUP BY r.account_name
+	)
+	SELECT a.name, COALESCE(b.bytes, 0), COALESCE(m.count, 0), COALESCE(e.bytes, 0)
+	  FROM accounts a
+	  LEFT OUTER JOIN blob_stats b ON b.account_name = a.name
+	  LEFT OUTER JOIN manifest_stats m ON m.account_name = a.name
+	  LEFT OUTER JOIN account_egress_counters e ON e.account_name = a.name
+	 ORDER BY COALESCE(b.bytes, 0) DESC, a.name
+	 LIMIT $1
+`)
+
+var repoPullMetricsQuery = sqlext.SimplifyWhitespace(`
+	SELECT account_name, name, pull_count
+	  FROM repos
+	 WHERE pull_count > 0
+	 ORDER BY pull_count DESC, account_name, name
+	 LIMIT $1
+`)
+
+type accountMetricsSample struct {
+	AccountName   string
+	BlobBytes     uint64
+	ManifestCount uint64
+	EgressBytes   uint64
+}
+
+type repoPullsSample struct {
+	AccountName    string
+	RepositoryName string
+	PullCount      uint64
+}
+
+// accountMetricsCollector is a prometheus.Collector that reports the values
+// computed by the most recent run of AccountMetricsJob. The metrics are not
+// computed during Collect() because the respective queries are too expensive
+// to run on every scrape.
+type accountMetricsCollector struct {
+	mutex           sync.Mutex
+	accountSamples  []accountMetricsSample
+	repoPullSamples []repoPullsSample
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *accountMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- accountBlobBytesDesc
+	ch <- accountManifestCountDesc
+	ch <- accountEgressBytesDesc
+	ch <- repoPullsDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *accountMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, s := range c.accountSamples {
+		ch <- prometheus.MustNewConstMetric(accountBlobBytesDesc, prometheus.GaugeValue, float64(s.BlobBytes), s.AccountName)
+		ch <- prometheus.MustNewConstMetric(accountManifestCountDesc, prometheus.GaugeValue, float64(s.ManifestCount), s.AccountName)
+		ch <- prometheus.MustNewConstMetric(accountEgressBytesDesc, prometheus.CounterValue, float64(s.EgressBytes), s.AccountName)
+	}
+	for _, s := range c.repoPullSamples {
+		ch <- prometheus.MustNewConstMetric(repoPullsDesc, prometheus.CounterValue, float64(s.PullCount), s.AccountName, s.RepositoryName)
+	}
+}
+
+// AccountMetricsJob is a job that periodically computes per-account storage
+// and egress metrics and per-repository pull metrics from the database, and exports them
+// to Prometheus through the given registerer. It is only started if account
+// metrics are enabled in the configuration. To limit the cardinality of these
+// metrics, at most `cfg.AccountMetricsMaxSeries` accounts and repositories are
+// reported.
+func (j *Janitor) AccountMetricsJob(registerer prometheus.Registerer) jobloop.Job {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	collector := &accountMetricsCollector{}
+	registerer.MustRegister(collector)
+
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "collection of per-account metrics",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_account_metrics_collections",
+				Help: "Counter for collections of per-account storage and pull metrics.",
+			},
+		},
+		Interval:     5 * time.Minute,
+		InitialDelay: 10 * time.Second,
+		Task: j.trackCronTask("account_metrics", func(_ context.Context, _ prometheus.Labels) error {
+			return j.collectAccountMetrics(collector)
+		}),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) collectAccountMetrics(collector *accountMetricsCollector) error {
+	var accountSamples []accountMetricsSample
+	err := sqlext.ForeachRow(j.db, accountMetricsQuery, []any{j.cfg.AccountMetricsMaxSeries}, func(rows *sql.Rows) error {
+		var s accountMetricsSample
+		err := rows.Scan(&s.AccountName, &s.BlobBytes, &s.ManifestCount, &s.EgressBytes)
+		accountSamples = append(accountSamples, s)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var repoPullSamples []repoPullsSample
+	err = sqlext.ForeachRow(j.db, repoPullMetricsQuery, []any{j.cfg.AccountMetricsMaxSeries}, func(rows *sql.Rows) error {
+		var s repoPullsSample
+		err := rows.Scan(&s.AccountName, &s.RepositoryName, &s.PullCount)
+		repoPullSamples = append(repoPullSamples, s)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.accountSamples = accountSamples
+	collector.repoPullSamples = repoPullSamples
+	return nil
+}
@@ -0,0 +1,104 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestAccountMetricsJob(t *testing.T) {
+	j, s := setup(t,
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "test1authtenant"}),
+		test.WithRepo(models.Repository{AccountName: "test2", Name: "bar"}),
+	)
+	j.cfg.AccountMetricsEnabled = true
+	j.cfg.AccountMetricsMaxSeries = 1
+	job := j.AccountMetricsJob(s.Registry)
+
+	// test1 is the larger account, and test1/foo is the most-pulled repo, so
+	// only those appear in the metrics because of the cardinality cap
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, fooRepoRef, "")
+	mustExec(t, s.DB, `UPDATE repos SET pull_count = 5 WHERE name = $1`, "foo")
+	mustExec(t, s.DB, `UPDATE repos SET pull_count = 3 WHERE name = $1`, "bar")
+	mustExec(t, s.DB, `INSERT INTO account_egress_counters (account_name, bytes) VALUES ($1, $2)`, "test1", 4096)
+
+	// before the first run, no metrics are reported
+	assert.DeepEqual(t, "metrics", gatherAccountMetrics(t, s), []string(nil))
+
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	blobBytes := len(image.Layers[0].Contents) + len(image.Config.Contents)
+	assert.DeepEqual(t, "metrics", gatherAccountMetrics(t, s), []string{
+		fmt.Sprintf(`keppel_account_blob_bytes{account="test1"} %d`, blobBytes),
+		`keppel_account_egress_bytes_total{account="test1"} 4096`,
+		`keppel_account_manifest_count{account="test1"} 1`,
+		`keppel_repo_pulls_total{account="test1",repo="foo"} 5`,
+	})
+
+	// without the cap, all accounts and all pulled repos are reported
+	j.cfg.AccountMetricsMaxSeries = 1000
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	assert.DeepEqual(t, "metrics", gatherAccountMetrics(t, s), []string{
+		fmt.Sprintf(`keppel_account_blob_bytes{account="test1"} %d`, blobBytes),
+		`keppel_account_blob_bytes{account="test2"} 0`,
+		`keppel_account_egress_bytes_total{account="test1"} 4096`,
+		`keppel_account_egress_bytes_total{account="test2"} 0`,
+		`keppel_account_manifest_count{account="test1"} 1`,
+		`keppel_account_manifest_count{account="test2"} 0`,
+		`keppel_repo_pulls_total{account="test1",repo="foo"} 5`,
+		`keppel_repo_pulls_total{account="test2",repo="bar"} 3`,
+	})
+}
+
+// Renders the metrics reported by AccountMetricsJob in a simplified text format.
+func gatherAccountMetrics(t *testing.T, s test.Setup) []string {
+	t.Helper()
+	families, err := s.Registry.Gather()
+	mustDo(t, err)
+
+	var result []string
+	for _, family := range families {
+		name := family.GetName()
+		if !strings.HasPrefix(name, "keppel_account_") && !strings.HasPrefix(name, "keppel_repo_") {
+			continue
+		}
+		if name == "keppel_account_metrics_collections" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make([]string, 0, len(metric.GetLabel()))
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+			}
+			value := metric.GetGauge().GetValue() + metric.GetCounter().GetValue()
+			result = append(result, fmt.Sprintf("%s{%s} %g", name, strings.Join(labels, ","), value))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
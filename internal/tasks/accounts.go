@@ -20,6 +20,9 @@ package tasks
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -27,6 +30,7 @@ import (
 	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/go-bits/sqlext"
 
+	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 )
 
@@ -60,7 +64,7 @@ func (j *Janitor) AccountFederationAnnouncementJob(registerer prometheus.Registe
 			err = j.db.SelectOne(&account, accountAnnouncementSearchQuery, j.timeNow())
 			return account, err
 		},
-		ProcessTask: j.announceAccountToFederation,
+		ProcessTask: trackTask(j, "account_federation_announcement", j.announceAccountToFederation),
 	}).Setup(registerer)
 }
 
@@ -75,3 +79,109 @@ func (j *Janitor) announceAccountToFederation(ctx context.Context, account model
 	_, err = j.db.Exec(accountAnnouncementDoneQuery, account.Name, j.timeNow().Add(j.addJitter(1*time.Hour)))
 	return err
 }
+
+var accountConfigSyncSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM accounts
+		WHERE config_sync_enabled AND upstream_peer_hostname != '' AND NOT is_deleting
+		  AND (next_config_sync_at IS NULL OR next_config_sync_at < $1)
+	-- accounts without any config sync first, then sorted by last config sync
+	ORDER BY next_config_sync_at IS NULL DESC, next_config_sync_at ASC
+	-- only one account at a time
+	LIMIT 1
+`)
+
+var accountConfigSyncDoneQuery = sqlext.SimplifyWhitespace(`
+	UPDATE accounts SET next_config_sync_at = $2 WHERE name = $1
+`)
+
+var accountConfigSyncUpdateQuery = sqlext.SimplifyWhitespace(`
+	UPDATE accounts SET rbac_policies_json = $2, gc_policies_json = $3, platform_filter = $4
+	 WHERE name = $1 AND config_sync_enabled
+`)
+
+// AccountConfigSyncJob is a job. Each task finds an internal replica account
+// with config sync enabled whose configuration has not been synced in more
+// than an hour, and copies the RBAC policies, GC policies and platform filter
+// (except for those fields excluded by the account's replication policy) from
+// the primary account. If no accounts need to be synced, sql.ErrNoRows is
+// returned to instruct the caller to slow down.
+func (j *Janitor) AccountConfigSyncJob(registerer prometheus.Registerer) jobloop.Job { //nolint: dupl // interface implementation of different things
+	return (&jobloop.ProducerConsumerJob[models.Account]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "account config sync in replica accounts",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_account_config_syncs",
+				Help: "Counter for config syncs of replica accounts from their primary accounts.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (account models.Account, err error) {
+			err = j.db.SelectOne(&account, accountConfigSyncSearchQuery, j.timeNow())
+			return account, err
+		},
+		ProcessTask: trackTask(j, "account_config_sync", j.syncAccountConfigFromPrimary),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) syncAccountConfigFromPrimary(ctx context.Context, account models.Account, _ prometheus.Labels) error {
+	peer, err := keppel.GetPeerFromAccount(j.db, account)
+	if err != nil {
+		return fmt.Errorf("cannot find upstream peer for account %s: %w", account.Name, err)
+	}
+	upstreamAccount, err := j.processor().GetPrimaryAccountConfiguration(ctx, peer, account)
+	if err != nil {
+		return fmt.Errorf("cannot get configuration of primary account %s from %s: %w", account.Name, peer.HostName, err)
+	}
+
+	excludedFields := keppel.ParseConfigSyncExcludedFields(account)
+	isSynced := func(field keppel.ConfigSyncField) bool {
+		return !slices.Contains(excludedFields, field)
+	}
+
+	// compute the new configuration (fields that are not synced retain their current value)
+	rbacPoliciesJSON := account.RBACPoliciesJSON
+	if isSynced(keppel.ConfigSyncRBACPolicies) {
+		rbacPoliciesJSON = ""
+		if len(upstreamAccount.RBACPolicies) > 0 {
+			for idx, policy := range upstreamAccount.RBACPolicies {
+				err := policy.ValidateAndNormalize(keppel.OnFirstUseStrategy)
+				if err != nil {
+					return fmt.Errorf("cannot apply RBAC policies of primary account %s: %w", account.Name, err)
+				}
+				upstreamAccount.RBACPolicies[idx] = policy
+			}
+			buf, _ := json.Marshal(upstreamAccount.RBACPolicies)
+			rbacPoliciesJSON = string(buf)
+		}
+	}
+
+	gcPoliciesJSON := account.GCPoliciesJSON
+	if isSynced(keppel.ConfigSyncGCPolicies) {
+		gcPoliciesJSON = "[]"
+		if len(upstreamAccount.GCPolicies) > 0 {
+			for _, policy := range upstreamAccount.GCPolicies {
+				err := policy.Validate()
+				if err != nil {
+					return fmt.Errorf("cannot apply GC policies of primary account %s: %w", account.Name, err)
+				}
+			}
+			buf, _ := json.Marshal(upstreamAccount.GCPolicies)
+			gcPoliciesJSON = string(buf)
+		}
+	}
+
+	platformFilter := account.PlatformFilter
+	if isSynced(keppel.ConfigSyncPlatformFilter) {
+		platformFilter = upstreamAccount.PlatformFilter
+	}
+
+	if rbacPoliciesJSON != account.RBACPoliciesJSON || gcPoliciesJSON != account.GCPoliciesJSON || !platformFilter.IsEqualTo(account.PlatformFilter) {
+		_, err = j.db.Exec(accountConfigSyncUpdateQuery, account.Name, rbacPoliciesJSON, gcPoliciesJSON, platformFilter)
+		if err != nil {
+			return err
+		}
+		logg.Info("synced configuration of account %s from primary account in %s", account.Name, peer.HostName)
+	}
+
+	_, err = j.db.Exec(accountConfigSyncDoneQuery, account.Name, j.timeNow().Add(j.addJitter(1*time.Hour)))
+	return err
+}
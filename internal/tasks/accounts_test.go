@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/easypg"
 
 	"github.com/sapcc/keppel/internal/models"
 	"github.com/sapcc/keppel/internal/test"
@@ -80,3 +81,53 @@ func expectAccountsAnnouncedJustNow(t *testing.T, s test.Setup, accounts ...mode
 	// reset for next test step
 	s.FD.RecordedAccounts = nil
 }
+
+func TestAccountConfigSync(t *testing.T) {
+	_, s1 := setup(t)
+	j2, s2 := setupReplica(t, s1, "on_first_use")
+	syncJob := j2.AccountConfigSyncJob(s2.Registry)
+
+	// without config sync enabled, there is nothing to do
+	expectError(t, sql.ErrNoRows.Error(), syncJob.ProcessOne(s2.Ctx))
+
+	// configure some policies on the primary
+	rbacPoliciesJSON := `[{"match_repository":"foo","permissions":["anonymous_pull"]}]`
+	gcPoliciesJSON := `[{"match_repository":".*","only_untagged":true,"action":"delete"}]`
+	mustExec(t, s1.DB, `UPDATE accounts SET rbac_policies_json = $1, gc_policies_json = $2 WHERE name = $3`,
+		rbacPoliciesJSON, gcPoliciesJSON, "test1")
+
+	// enable config sync on the replica, but exclude the GC policies
+	mustExec(t, s2.DB, `UPDATE accounts SET config_sync_enabled = TRUE, config_sync_excluded_fields = $1 WHERE name = $2`,
+		"gc_policies", "test1")
+	tr, tr0 := easypg.NewTracker(t, s2.DB.Db)
+	tr0.Ignore()
+
+	expectSuccess(t, syncJob.ProcessOne(s2.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE accounts SET rbac_policies_json = '%[1]s', next_config_sync_at = %[2]d WHERE name = 'test1';
+		`,
+		rbacPoliciesJSON, s2.Clock.Now().Add(1*time.Hour).Unix(),
+	)
+	expectError(t, sql.ErrNoRows.Error(), syncJob.ProcessOne(s2.Ctx))
+
+	// when the policies on the primary change, the change is picked up on the next sync
+	rbacPoliciesJSON = `[{"match_cidr":"10.0.0.0/16","permissions":["pull"]}]`
+	mustExec(t, s1.DB, `UPDATE accounts SET rbac_policies_json = $1 WHERE name = $2`, rbacPoliciesJSON, "test1")
+	s2.Clock.StepBy(2 * time.Hour)
+	expectSuccess(t, syncJob.ProcessOne(s2.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE accounts SET rbac_policies_json = '%[1]s', next_config_sync_at = %[2]d WHERE name = 'test1';
+		`,
+		rbacPoliciesJSON, s2.Clock.Now().Add(1*time.Hour).Unix(),
+	)
+
+	// when all fields are synced, the GC policies get copied as well
+	mustExec(t, s2.DB, `UPDATE accounts SET config_sync_excluded_fields = '', next_config_sync_at = NULL WHERE name = $1`, "test1")
+	tr.DBChanges().Ignore()
+	expectSuccess(t, syncJob.ProcessOne(s2.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE accounts SET gc_policies_json = '%[1]s', next_config_sync_at = %[2]d WHERE name = 'test1';
+		`,
+		gcPoliciesJSON, s2.Clock.Now().Add(1*time.Hour).Unix(),
+	)
+}
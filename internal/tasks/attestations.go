@@ -0,0 +1,302 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// query that finds the next manifest whose attestations shall be verified
+var attestationVerificationSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT m.* FROM manifests m
+	  JOIN repos r ON r.id = m.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	 WHERE a.required_attestation_types != '' AND (m.next_attestation_check_at IS NULL OR m.next_attestation_check_at < $1)
+	 ORDER BY m.next_attestation_check_at ASC NULLS FIRST, m.pushed_at ASC
+	 LIMIT 1 -- one at a time
+`)
+
+var attestationVerificationFinishQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET next_attestation_check_at = $1
+	 WHERE repo_id = $2 AND digest = $3
+`)
+
+// DSSE envelopes are small JSON documents (unless the predicate contains
+// something like a full vulnerability report), so anything larger is suspicious
+const maxAttestationEnvelopeBytes = 16 << 20
+
+// AttestationVerificationJob is a job. Each task verifies the cosign
+// attestations of a manifest in an account with a "require_attestations"
+// validation policy, and caches the result for each required predicate type
+// in the `attestation_results` table.
+func (j *Janitor) AttestationVerificationJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.Manifest]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "manifest attestation verification",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_manifest_attestation_verifications",
+				Help: "Counter for manifest attestation verifications.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (manifest models.Manifest, err error) {
+			err = j.db.SelectOne(&manifest, attestationVerificationSearchQuery, j.timeNow())
+			return manifest, err
+		},
+		ProcessTask: trackTask(j, "attestation_verification", j.verifyManifestAttestations),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) verifyManifestAttestations(ctx context.Context, manifest models.Manifest, _ prometheus.Labels) error {
+	// find corresponding account and repo (we need the full account to get the trusted keys)
+	var repo models.Repository
+	err := j.db.SelectOne(&repo, `SELECT * FROM repos WHERE id = $1`, manifest.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("cannot find repo %d for manifest %s: %w", manifest.RepositoryID, manifest.Digest, err)
+	}
+	account, err := keppel.FindAccount(j.db, repo.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), manifest.Digest, err)
+	}
+	if account == nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), manifest.Digest, errors.New("no such account"))
+	}
+
+	results, err := j.determineAttestationResults(ctx, *account, repo, manifest)
+	if err != nil {
+		// on failure, retain the previous results and retry soon
+		_, updateErr := j.db.Exec(attestationVerificationFinishQuery,
+			j.timeNow().Add(j.addJitter(models.AttestationVerificationRetryInterval)),
+			repo.ID, manifest.Digest,
+		)
+		if updateErr != nil {
+			err = fmt.Errorf("%w (additional error encountered while scheduling next verification: %w)", err, updateErr)
+		}
+		return fmt.Errorf("while verifying attestations of manifest %s: %w", repo.FullName()+"@"+manifest.Digest.String(), err)
+	}
+
+	// manifests with missing or invalid attestations are rechecked more often
+	// since attestations are usually pushed shortly after the image
+	interval := models.AttestationVerificationInterval
+	for _, result := range results {
+		if !result.Status.IsTrusted() {
+			interval = models.AttestationVerificationRetryInterval
+		}
+	}
+
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+	err = replaceAttestationResults(tx, repo, manifest, results)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(attestationVerificationFinishQuery,
+		j.timeNow().Add(j.addJitter(interval)), repo.ID, manifest.Digest,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceAttestationResults(tx *gorp.Transaction, repo models.Repository, manifest models.Manifest, results []models.AttestationResult) error {
+	var oldResults []models.AttestationResult
+	_, err := tx.Select(&oldResults, `SELECT * FROM attestation_results WHERE repo_id = $1 AND digest = $2`, repo.ID, manifest.Digest)
+	if err != nil {
+		return err
+	}
+	oldStatus := make(map[string]models.AttestationStatus, len(oldResults))
+	for _, result := range oldResults {
+		oldStatus[result.PredicateType] = result.Status
+	}
+
+	_, err = tx.Exec(`DELETE FROM attestation_results WHERE repo_id = $1 AND digest = $2`, repo.ID, manifest.Digest)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if status, exists := oldStatus[result.PredicateType]; exists && status != result.Status {
+			logg.Info("status of %q attestation for manifest %s@%s changed from %q to %q",
+				result.PredicateType, repo.FullName(), manifest.Digest, status, result.Status)
+		}
+		err := tx.Insert(&result)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *Janitor) determineAttestationResults(ctx context.Context, account models.Account, repo models.Repository, manifest models.Manifest) ([]models.AttestationResult, error) {
+	requiredTypes := account.Reduced().SplitRequiredAttestationTypes()
+	now := j.timeNow()
+	makeResults := func(fill func(predicateType string, result *models.AttestationResult)) []models.AttestationResult {
+		results := make([]models.AttestationResult, len(requiredTypes))
+		for idx, predicateType := range requiredTypes {
+			results[idx] = models.AttestationResult{
+				RepositoryID:  repo.ID,
+				Digest:        manifest.Digest,
+				PredicateType: predicateType,
+				CheckedAt:     now,
+			}
+			fill(predicateType, &results[idx])
+		}
+		return results
+	}
+
+	// cosign artifacts (signatures, attestations, SBOMs) cannot be attested themselves
+	var tagNames []string
+	_, err := j.db.Select(&tagNames, signatureVerificationTagNamesQuery, repo.ID, manifest.Digest)
+	if err != nil {
+		return nil, err
+	}
+	for _, tagName := range tagNames {
+		if keppel.IsCosignArtifactTagName(tagName) {
+			return makeResults(func(_ string, result *models.AttestationResult) {
+				result.Status = models.AttestationExempt
+			}), nil
+		}
+	}
+
+	keys, err := keppel.ParseSignaturePublicKeys(account.AttestationPublicKeys)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse trusted public keys for attestations of account %q: %w", account.Name, err)
+	}
+
+	// like with signatures, a manifest is also considered attested if one of
+	// the list manifests containing it is attested
+	candidateDigests := []digest.Digest{manifest.Digest}
+	var parentDigests []digest.Digest
+	_, err = j.db.Select(&parentDigests, signatureVerificationParentsQuery, repo.ID, manifest.Digest)
+	if err != nil {
+		return nil, err
+	}
+	candidateDigests = append(candidateDigests, parentDigests...)
+
+	found := make(map[string]models.AttestationResult)
+	for _, candidateDigest := range candidateDigests {
+		err := j.checkCosignAttestations(ctx, account.Reduced(), repo, candidateDigest, keys, found)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return makeResults(func(predicateType string, result *models.AttestationResult) {
+		f, exists := found[predicateType]
+		if !exists {
+			result.Status = models.AttestationMissing
+			result.Message = "no attestation with this predicate type was found"
+			return
+		}
+		result.Status = f.Status
+		result.AttestationDigest = f.AttestationDigest
+		result.Message = f.Message
+	}), nil
+}
+
+// Checks the cosign attestations that are stored for the given manifest, and
+// records the outcome for each predicate type in `found`. A valid attestation
+// always takes precedence over an invalid one.
+func (j *Janitor) checkCosignAttestations(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest, keys []crypto.PublicKey, found map[string]models.AttestationResult) error {
+	attDigestStr, err := j.db.SelectStr(
+		`SELECT digest FROM tags WHERE repo_id = $1 AND name = $2`,
+		repo.ID, keppel.CosignAttestationTagName(manifestDigest))
+	if err != nil || attDigestStr == "" {
+		return err
+	}
+	attManifest, err := keppel.FindManifest(j.db, repo, digest.Digest(attDigestStr))
+	if err != nil {
+		return err
+	}
+	attManifestBytes, err := j.sd.ReadManifest(ctx, account, repo.Name, attManifest.Digest)
+	if err != nil {
+		return err
+	}
+	parsed, _, err := keppel.ParseManifest(attManifest.MediaType, attManifestBytes)
+	if err != nil {
+		return fmt.Errorf("cannot parse attestation manifest %s: %w", attManifest.Digest, err)
+	}
+
+	for _, layer := range parsed.FindImageLayerBlobs() {
+		if layer.MediaType != keppel.DSSEEnvelopeMediaType {
+			continue
+		}
+		// cosign declares the predicate type on the layer, so that we can
+		// attribute an attestation to a predicate type even if we reject it
+		declaredType := layer.Annotations[keppel.CosignPredicateTypeAnnotation]
+
+		envelope, err := j.readAttestationEnvelope(ctx, account, repo, layer.Digest)
+		if err != nil {
+			return err
+		}
+		predicateType, err := keppel.VerifyCosignAttestation(manifestDigest, envelope, keys)
+		if err == nil {
+			found[predicateType] = models.AttestationResult{
+				Status:            models.AttestationValid,
+				AttestationDigest: attManifest.Digest.String(),
+			}
+			continue
+		}
+		logg.Debug("rejecting attestation in %s@%s for manifest %s: %s", repo.FullName(), attManifest.Digest, manifestDigest, err.Error())
+		if declaredType != "" && found[declaredType].Status != models.AttestationValid {
+			found[declaredType] = models.AttestationResult{
+				Status:            models.AttestationInvalid,
+				AttestationDigest: attManifest.Digest.String(),
+				Message:           err.Error(),
+			}
+		}
+	}
+	return nil
+}
+
+func (j *Janitor) readAttestationEnvelope(ctx context.Context, account models.ReducedAccount, repo models.Repository, blobDigest digest.Digest) ([]byte, error) {
+	blob, err := keppel.FindBlobByRepository(j.db, blobDigest, repo)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find attestation blob %s: %w", blobDigest, err)
+	}
+	if blob.StorageID == "" {
+		return nil, fmt.Errorf("attestation blob %s is not available yet", blobDigest)
+	}
+	if blob.SizeBytes > maxAttestationEnvelopeBytes {
+		return nil, fmt.Errorf("attestation blob %s is too large (%d bytes)", blobDigest, blob.SizeBytes)
+	}
+
+	reader, _, err := j.sd.ReadBlob(ctx, j.cfg.BlobStorageLocation(account, *blob), blob.StorageID)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, maxAttestationEnvelopeBytes))
+}
@@ -0,0 +1,114 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestAttestationVerificationJob(t *testing.T) {
+	j, s := setup(t)
+	job := j.AttestationVerificationJob(s.Registry)
+
+	trustedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	mustDo(t, err)
+	untrustedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	mustDo(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&trustedKey.PublicKey)
+	mustDo(t, err)
+	const (
+		provenanceType = "https://slsa.dev/provenance/v1"
+		vulnType       = "https://cosign.sigstore.dev/attestation/vuln/v1"
+	)
+	mustExec(t, s.DB, `UPDATE accounts SET required_attestation_types = $1, attestation_public_keys = $2 WHERE name = $3`,
+		provenanceType+","+vulnType, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), "test1")
+
+	// setup: one image with both required attestations (the vuln attestation
+	// in the same attestation manifest is signed by an untrusted key, but there
+	// is also a valid one), and one image without attestations
+	attestedImage := test.GenerateImage(test.GenerateExampleLayer(1))
+	unattestedImage := test.GenerateImage(test.GenerateExampleLayer(2))
+	attestedImage.MustUpload(t, s, fooRepoRef, "attested")
+	unattestedImage.MustUpload(t, s, fooRepoRef, "unattested")
+	provenance := test.GenerateCosignAttestation(trustedKey, attestedImage.Manifest.Digest, provenanceType)
+	provenance.MustUpload(t, s, fooRepoRef, keppel.CosignAttestationTagName(attestedImage.Manifest.Digest))
+
+	expectResults := func(manifestDigest digest.Digest, expected map[string]models.AttestationStatus) {
+		t.Helper()
+		var results []models.AttestationResult
+		_, err := s.DB.Select(&results, `SELECT * FROM attestation_results WHERE digest = $1`, manifestDigest)
+		mustDo(t, err)
+		actual := make(map[string]models.AttestationStatus, len(results))
+		for _, result := range results {
+			actual[result.PredicateType] = result.Status
+		}
+		assert.DeepEqual(t, "attestation results for "+manifestDigest.String(), actual, expected)
+	}
+	processAll := func(expectedCount int) {
+		t.Helper()
+		for range expectedCount {
+			expectSuccess(t, job.ProcessOne(s.Ctx))
+		}
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+	}
+
+	// first pass: all 3 manifests are verified
+	processAll(3)
+	expectResults(attestedImage.Manifest.Digest, map[string]models.AttestationStatus{
+		provenanceType: models.AttestationValid,
+		vulnType:       models.AttestationMissing,
+	})
+	expectResults(unattestedImage.Manifest.Digest, map[string]models.AttestationStatus{
+		provenanceType: models.AttestationMissing,
+		vulnType:       models.AttestationMissing,
+	})
+	expectResults(provenance.Manifest.Digest, map[string]models.AttestationStatus{
+		provenanceType: models.AttestationExempt,
+		vulnType:       models.AttestationExempt,
+	})
+
+	// pushing an attestation with an untrusted key makes the image eligible for verification immediately
+	badAttestation := test.GenerateCosignAttestation(untrustedKey, attestedImage.Manifest.Digest, vulnType)
+	badAttestation.MustUpload(t, s, fooRepoRef, keppel.CosignAttestationTagName(attestedImage.Manifest.Digest))
+	processAll(2) // the attested manifest and the new attestation manifest
+	expectResults(attestedImage.Manifest.Digest, map[string]models.AttestationStatus{
+		provenanceType: models.AttestationMissing, // the tag was moved away from the provenance attestation
+		vulnType:       models.AttestationInvalid,
+	})
+
+	// images with missing or invalid attestations are rechecked soon, others only after a day
+	s.Clock.StepBy(10 * time.Minute)
+	processAll(2)
+	s.Clock.StepBy(24 * time.Hour)
+	processAll(4)
+}
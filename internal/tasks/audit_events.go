@@ -0,0 +1,50 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+)
+
+// AuditEventCleanupJob is a job that deletes persisted audit events once they
+// are older than the configured retention period. It is only started if
+// persistence of audit events is enabled.
+func (j *Janitor) AuditEventCleanupJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "cleanup of expired audit events",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_audit_event_cleanups",
+				Help: "Counter for cleanup operations for expired audit events.",
+			},
+		},
+		Interval:     1 * time.Hour,
+		InitialDelay: 1 * time.Minute,
+		Task:         j.trackCronTask("audit_event_cleanup", j.deleteExpiredAuditEvents),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) deleteExpiredAuditEvents(_ context.Context, _ prometheus.Labels) error {
+	_, err := j.db.Exec(`DELETE FROM audit_events WHERE recorded_at < $1`, j.timeNow().Add(-j.cfg.AuditEventRetention))
+	return err
+}
@@ -20,6 +20,7 @@ package tasks
 
 import (
 	"context"
+	"math"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -44,26 +45,40 @@ var blobMountSweepSearchQuery = sqlext.SimplifyWhitespace(`
 	LIMIT 1
 `)
 
+// Returns the blob ID of the last blob mount in the next batch after $2 (or
+// NULL if there are no blob mounts after $2), as well as the size of that batch.
+var blobMountSweepNextBatchQuery = sqlext.SimplifyWhitespace(`
+	SELECT MAX(blob_id), COUNT(*) FROM (
+		SELECT blob_id FROM blob_mounts WHERE repo_id = $1 AND blob_id > $2 ORDER BY blob_id LIMIT $3
+	) AS batch
+`)
+
 var blobMountMarkQuery = sqlext.SimplifyWhitespace(`
 	UPDATE blob_mounts SET can_be_deleted_at = $2
-	WHERE repo_id = $1 AND can_be_deleted_at IS NULL AND blob_id NOT IN (
-		SELECT DISTINCT blob_id FROM manifest_blob_refs WHERE repo_id = $1
+	WHERE repo_id = $1 AND blob_id > $3 AND blob_id <= $4 AND can_be_deleted_at IS NULL AND blob_id NOT IN (
+		SELECT DISTINCT blob_id FROM manifest_blob_refs WHERE repo_id = $1 AND blob_id > $3 AND blob_id <= $4
 	)
 `)
 
 var blobMountUnmarkQuery = sqlext.SimplifyWhitespace(`
 	UPDATE blob_mounts SET can_be_deleted_at = NULL
-	WHERE repo_id = $1 AND blob_id IN (
-		SELECT DISTINCT blob_id FROM manifest_blob_refs WHERE repo_id = $1
+	WHERE repo_id = $1 AND blob_id > $2 AND blob_id <= $3 AND blob_id IN (
+		SELECT DISTINCT blob_id FROM manifest_blob_refs WHERE repo_id = $1 AND blob_id > $2 AND blob_id <= $3
 	)
 `)
 
 var blobMountSweepMarkedQuery = sqlext.SimplifyWhitespace(`
-	DELETE FROM blob_mounts WHERE repo_id = $1 AND can_be_deleted_at < $2
+	DELETE FROM blob_mounts WHERE repo_id = $1 AND blob_id > $3 AND blob_id <= $4 AND can_be_deleted_at < $2
+`)
+
+// When a pass is not finished yet, the repo is due again right away, but goes
+// behind all other repos that have been waiting for longer.
+var blobMountSweepProgressQuery = sqlext.SimplifyWhitespace(`
+	UPDATE repos SET blob_mount_sweep_cursor = $2, next_blob_mount_sweep_at = $3 WHERE id = $1
 `)
 
 var blobMountSweepDoneQuery = sqlext.SimplifyWhitespace(`
-	UPDATE repos SET next_blob_mount_sweep_at = $2 WHERE id = $1
+	UPDATE repos SET blob_mount_sweep_cursor = 0, next_blob_mount_sweep_at = $2 WHERE id = $1
 `)
 
 // BlobMountSweepJob is a job. Each task finds one repo where blob mounts need to be
@@ -75,6 +90,10 @@ var blobMountSweepDoneQuery = sqlext.SimplifyWhitespace(`
 // This staged mark-and-sweep ensures that we don't remove fresh blob mounts
 // that were just created, but where the manifest has not yet been pushed.
 //
+// Like BlobSweepJob, each task only processes a bounded batch of blob mounts,
+// and persists its position within the current pass in the repo's
+// blob_mount_sweep_cursor.
+//
 // Blob mounts are sweeped in each repo at most once per hour.
 func (j *Janitor) BlobMountSweepJob(registerer prometheus.Registerer) jobloop.Job { //nolint:dupl // false positive
 	return (&jobloop.ProducerConsumerJob[models.Repository]{
@@ -89,7 +108,7 @@ func (j *Janitor) BlobMountSweepJob(registerer prometheus.Registerer) jobloop.Jo
 			err = j.db.SelectOne(&repo, blobMountSweepSearchQuery, j.timeNow())
 			return repo, err
 		},
-		ProcessTask: j.sweepBlobMountsInRepo,
+		ProcessTask: trackTask(j, "blob_mount_sweep", j.sweepBlobMountsInRepo),
 	}).Setup(registerer)
 }
 
@@ -98,21 +117,37 @@ func (j *Janitor) sweepBlobMountsInRepo(_ context.Context, repo models.Repositor
 	// slightly earlier cut-off time to account for the marking taking some time
 	canBeDeletedAt := j.timeNow().Add(30 * time.Minute)
 
+	// find the range of blob IDs to process in this task
+	var (
+		maxBlobID *int64
+		count     int
+	)
+	err := j.db.QueryRow(blobMountSweepNextBatchQuery, repo.ID, repo.BlobMountSweepCursor, sweepBatchSize).Scan(&maxBlobID, &count)
+	if err != nil {
+		return err
+	}
+	lowerBound := repo.BlobMountSweepCursor
+	upperBound := int64(math.MaxInt64)
+	isLastBatch := count < sweepBatchSize
+	if !isLastBatch && maxBlobID != nil {
+		upperBound = *maxBlobID
+	}
+
 	//NOTE: We don't need to pack the following steps in a single transaction, so
 	// we won't. The mark and unmark are obviously safe since they only update
 	// metadata, and the sweep only touches stuff that was marked in the
 	// *previous* sweep. The only thing that we need to make sure is that unmark
 	// is strictly ordered before sweep.
-	_, err := j.db.Exec(blobMountMarkQuery, repo.ID, canBeDeletedAt)
+	_, err = j.db.Exec(blobMountMarkQuery, repo.ID, canBeDeletedAt, lowerBound, upperBound)
 	if err != nil {
 		return err
 	}
-	_, err = j.db.Exec(blobMountUnmarkQuery, repo.ID)
+	_, err = j.db.Exec(blobMountUnmarkQuery, repo.ID, lowerBound, upperBound)
 	if err != nil {
 		return err
 	}
 	// delete blob-mounts that were marked in the last run
-	result, err := j.db.Exec(blobMountSweepMarkedQuery, repo.ID, j.timeNow())
+	result, err := j.db.Exec(blobMountSweepMarkedQuery, repo.ID, j.timeNow(), lowerBound, upperBound)
 	if err != nil {
 		return err
 	}
@@ -124,6 +159,10 @@ func (j *Janitor) sweepBlobMountsInRepo(_ context.Context, repo models.Repositor
 		logg.Info("%d blob mounts sweeped in repo %s", rowsDeleted, repo.FullName())
 	}
 
+	if !isLastBatch {
+		_, err = j.db.Exec(blobMountSweepProgressQuery, repo.ID, upperBound, j.timeNow())
+		return err
+	}
 	_, err = j.db.Exec(blobMountSweepDoneQuery, repo.ID, j.timeNow().Add(j.addJitter(1*time.Hour)))
 	return err
 }
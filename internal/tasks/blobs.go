@@ -20,7 +20,10 @@ package tasks
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -30,6 +33,7 @@ import (
 
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/processor"
 )
 
 var blobSweepSearchQuery = sqlext.SimplifyWhitespace(`
@@ -41,28 +45,47 @@ var blobSweepSearchQuery = sqlext.SimplifyWhitespace(`
 	LIMIT 1
 `)
 
+// sweepBatchSize is how many blobs (or blob mounts) BlobSweepJob (or
+// BlobMountSweepJob) processes in a single task. This is a variable instead of
+// a constant only so that unit tests can reduce it.
+var sweepBatchSize = 10000
+
+// Returns the ID of the last blob in the next batch after $2 (or NULL if there
+// are no blobs after $2), as well as the size of that batch.
+var blobSweepNextBatchQuery = sqlext.SimplifyWhitespace(`
+	SELECT MAX(id), COUNT(*) FROM (
+		SELECT id FROM blobs WHERE account_name = $1 AND id > $2 ORDER BY id LIMIT $3
+	) AS batch
+`)
+
 var blobMarkQuery = sqlext.SimplifyWhitespace(`
 	UPDATE blobs SET can_be_deleted_at = $2
-	WHERE account_name = $1 AND can_be_deleted_at IS NULL AND id NOT IN (
+	WHERE account_name = $1 AND id > $3 AND id <= $4 AND can_be_deleted_at IS NULL AND id NOT IN (
 		SELECT m.blob_id FROM blob_mounts m JOIN repos r ON m.repo_id = r.id
-		WHERE r.account_name = $1
+		WHERE r.account_name = $1 AND m.blob_id > $3 AND m.blob_id <= $4
 	)
 `)
 
 var blobUnmarkQuery = sqlext.SimplifyWhitespace(`
 	UPDATE blobs SET can_be_deleted_at = NULL
-	WHERE account_name = $1 AND id IN (
+	WHERE account_name = $1 AND id > $2 AND id <= $3 AND id IN (
 		SELECT m.blob_id FROM blob_mounts m JOIN repos r ON m.repo_id = r.id
-		WHERE r.account_name = $1
+		WHERE r.account_name = $1 AND m.blob_id > $2 AND m.blob_id <= $3
 	)
 `)
 
 var blobSelectMarkedQuery = sqlext.SimplifyWhitespace(`
-	SELECT * FROM blobs WHERE account_name = $1 AND can_be_deleted_at < $2
+	SELECT * FROM blobs WHERE account_name = $1 AND id > $3 AND id <= $4 AND can_be_deleted_at < $2
+`)
+
+// When a pass is not finished yet, the account is due again right away, but
+// goes behind all other accounts that have been waiting for longer.
+var blobSweepProgressQuery = sqlext.SimplifyWhitespace(`
+	UPDATE accounts SET blob_sweep_cursor = $2, next_blob_sweep_at = $3 WHERE name = $1
 `)
 
 var blobSweepDoneQuery = sqlext.SimplifyWhitespace(`
-	UPDATE accounts SET next_blob_sweep_at = $2 WHERE name = $1
+	UPDATE accounts SET blob_sweep_cursor = 0, next_blob_sweep_at = $2 WHERE name = $1
 `)
 
 // BlobSweepJob is a job. Each task finds one account where blobs need to be
@@ -73,6 +96,11 @@ var blobSweepDoneQuery = sqlext.SimplifyWhitespace(`
 // This staged mark-and-sweep ensures that we don't remove fresh blobs
 // that were just pushed and have not been mounted anywhere.
 //
+// Each task only processes a bounded batch of blobs (ordered by ID) to keep
+// the DB statements short. The position within the current pass is persisted
+// in the account's blob_sweep_cursor, so that large accounts are processed
+// over several tasks, and progress survives restarts of the janitor.
+//
 // Blobs are sweeped in each account at most once per hour.
 func (j *Janitor) BlobSweepJob(registerer prometheus.Registerer) jobloop.Job { //nolint:dupl // false positive
 	return (&jobloop.ProducerConsumerJob[models.Account]{
@@ -87,7 +115,7 @@ func (j *Janitor) BlobSweepJob(registerer prometheus.Registerer) jobloop.Job { /
 			err = j.db.SelectOne(&account, blobSweepSearchQuery, j.timeNow())
 			return account, err
 		},
-		ProcessTask: j.sweepBlobsInRepo,
+		ProcessTask: trackTask(j, "blob_sweep", j.sweepBlobsInRepo),
 	}).Setup(registerer)
 }
 
@@ -96,23 +124,39 @@ func (j *Janitor) sweepBlobsInRepo(ctx context.Context, account models.Account,
 	// slightly earlier cut-off time to account for the marking taking some time
 	canBeDeletedAt := j.timeNow().Add(30 * time.Minute)
 
+	// find the range of blob IDs to process in this task
+	var (
+		maxID *int64
+		count int
+	)
+	err := j.db.QueryRow(blobSweepNextBatchQuery, account.Name, account.BlobSweepCursor, sweepBatchSize).Scan(&maxID, &count)
+	if err != nil {
+		return err
+	}
+	lowerBound := account.BlobSweepCursor
+	upperBound := int64(math.MaxInt64)
+	isLastBatch := count < sweepBatchSize
+	if !isLastBatch && maxID != nil {
+		upperBound = *maxID
+	}
+
 	//NOTE: We don't need to pack the following steps in a single transaction, so
 	// we won't. The mark and unmark are obviously safe since they only update
 	// metadata, and the sweep only touches stuff that was marked in the
 	// *previous* sweep. The only thing that we need to make sure is that unmark
 	// is strictly ordered before sweep.
-	_, err := j.db.Exec(blobMarkQuery, account.Name, canBeDeletedAt)
+	_, err = j.db.Exec(blobMarkQuery, account.Name, canBeDeletedAt, lowerBound, upperBound)
 	if err != nil {
 		return err
 	}
-	_, err = j.db.Exec(blobUnmarkQuery, account.Name)
+	_, err = j.db.Exec(blobUnmarkQuery, account.Name, lowerBound, upperBound)
 	if err != nil {
 		return err
 	}
 
 	// select blobs for deletion that were marked in the last run
 	var blobs []models.Blob
-	_, err = j.db.Select(&blobs, blobSelectMarkedQuery, account.Name, j.timeNow())
+	_, err = j.db.Select(&blobs, blobSelectMarkedQuery, account.Name, j.timeNow(), lowerBound, upperBound)
 	if err != nil {
 		return err
 	}
@@ -133,19 +177,36 @@ func (j *Janitor) sweepBlobsInRepo(ctx context.Context, account models.Account,
 		logg.Info("sweeping %d blobs in account %s", len(blobs), account.Name)
 	}
 	for _, blob := range blobs {
-		// without transaction: we need this committed right now
-		_, err := j.db.Delete(&blob)
+		// no transaction spanning the whole loop: we need each deletion committed right now
+		// (unbacked blobs that were never replicated, and blobs whose storage
+		// object is shared in the global blob store, do not need to be deleted
+		// in the storage)
+		location, storageIDToDelete, err := j.deleteBlobRecord(account.Reduced(), blob)
 		if err != nil {
 			return err
 		}
-		if blob.StorageID != "" { // ignore unbacked blobs that were never replicated
-			err = j.sd.DeleteBlob(ctx, account.Reduced(), blob.StorageID)
+		if storageIDToDelete != "" {
+			err = j.sd.DeleteBlob(ctx, location, storageIDToDelete)
 			if err != nil {
 				return err
 			}
 		}
+		if blob.StorageID != "" {
+			// the blob is already gone at this point, so a failed invalidation
+			// cannot be retried; we can only make some noise about it
+			if j.cdn != nil {
+				err = j.cdn.InvalidateBlob(ctx, location, blob)
+				if err != nil {
+					logg.Error("while invalidating blob %s in account %s on the CDN: %s", blob.Digest, account.Name, err.Error())
+				}
+			}
+		}
 	}
 
+	if !isLastBatch {
+		_, err = j.db.Exec(blobSweepProgressQuery, account.Name, upperBound, j.timeNow())
+		return err
+	}
 	_, err = j.db.Exec(blobSweepDoneQuery, account.Name, j.timeNow().Add(j.addJitter(1*time.Hour)))
 	return err
 }
@@ -178,7 +239,7 @@ func (j *Janitor) BlobValidationJob(registerer prometheus.Registerer) jobloop.Jo
 			err = j.db.SelectOne(&blob, validateBlobSearchQuery, j.timeNow())
 			return blob, err
 		},
-		ProcessTask: j.validateBlob,
+		ProcessTask: trackTask(j, "blob_validation", j.validateBlob),
 	}).Setup(registerer)
 }
 
@@ -214,3 +275,86 @@ func (j *Janitor) validateBlob(ctx context.Context, blob models.Blob, _ promethe
 
 	return nil
 }
+
+var prefetchBlobSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT b.* FROM blobs b
+	  JOIN accounts a ON a.name = b.account_name
+	 WHERE b.storage_id = '' AND b.next_prefetch_at < $1
+	   AND a.prefetch_blobs AND NOT a.is_deleting
+	   -- skip blobs that are being replicated right now
+	   AND NOT EXISTS (SELECT 1 FROM pending_blobs pb WHERE pb.account_name = b.account_name AND pb.digest = b.digest)
+	ORDER BY b.next_prefetch_at ASC
+	LIMIT 1 -- one at a time
+`)
+
+var prefetchBlobRepoQuery = sqlext.SimplifyWhitespace(`
+	SELECT r.* FROM repos r
+	  JOIN blob_mounts bm ON bm.repo_id = r.id
+	 WHERE bm.blob_id = $1
+	 ORDER BY r.id
+	 LIMIT 1
+`)
+
+var prefetchBlobRescheduleQuery = sqlext.SimplifyWhitespace(`
+	UPDATE blobs SET next_prefetch_at = $2 WHERE id = $1 AND storage_id = ''
+`)
+
+// BlobPrefetchJob is a job. Each task replicates a blob that was referenced by
+// a manifest replicated into an account with blob prefetching enabled (see
+// Account.PrefetchBlobs), so that clients pulling the blob later do not need to
+// wait for the replication.
+//
+//nolint:dupl
+func (j *Janitor) BlobPrefetchJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.Blob]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "prefetching of replicated blobs",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_blob_prefetches",
+				Help: "Counter for blob replications performed in the background.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (blob models.Blob, err error) {
+			err = j.db.SelectOne(&blob, prefetchBlobSearchQuery, j.timeNow())
+			return blob, err
+		},
+		ProcessTask: trackTask(j, "blob_prefetch", j.prefetchBlob),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) prefetchBlob(ctx context.Context, blob models.Blob, _ prometheus.Labels) error {
+	account, err := keppel.FindReducedAccount(j.db, blob.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for blob %s/%s: %w", blob.AccountName, blob.Digest, err)
+	}
+
+	// the upstream registry is asked for the blob through a repo that it is mounted in
+	var repo models.Repository
+	err = j.db.SelectOne(&repo, prefetchBlobRepoQuery, blob.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the blob is not mounted anywhere anymore, so it will be swept soon and there is no point in replicating it
+		_, err = j.db.Exec(prefetchBlobRescheduleQuery, blob.ID, nil)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// on success, ReplicateBlob clears blob.NextPrefetchAt
+	_, err = j.processor().ReplicateBlob(ctx, blob, *account, repo, nil)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, processor.ErrConcurrentReplication) {
+		// a client pulled the blob in the meantime, so we're done here
+		return nil
+	}
+
+	// on failure, try again later
+	_, updateErr := j.db.Exec(prefetchBlobRescheduleQuery, blob.ID,
+		j.timeNow().Add(j.addJitter(models.BlobPrefetchAfterErrorInterval)))
+	if updateErr != nil {
+		err = fmt.Errorf("%w (additional error encountered while rescheduling prefetch: %s)", err, updateErr.Error())
+	}
+	return fmt.Errorf("cannot prefetch blob %s into account %s: %w", blob.Digest, account.Name, err)
+}
@@ -21,10 +21,13 @@ package tasks
 import (
 	"database/sql"
 	"fmt"
+	"net/http"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
 	"github.com/sapcc/go-bits/easypg"
 
 	"github.com/sapcc/keppel/internal/models"
@@ -36,6 +39,7 @@ func TestSweepBlobs(t *testing.T) {
 	s.Clock.StepBy(1 * time.Hour)
 
 	sweepBlobsJob := j.BlobSweepJob(s.Registry)
+	s.CDN.EnabledAccounts["test1"] = true
 
 	// insert some blobs into the DB
 	var dbBlobs []models.Blob
@@ -74,6 +78,74 @@ func TestSweepBlobs(t *testing.T) {
 	easypg.AssertDBContent(t, s.DB.DbMap.Db, "fixtures/blob-sweep-003.sql")
 	s.ExpectBlobsMissingInStorage(t, dbBlobs[0:2]...)
 	s.ExpectBlobsExistInStorage(t, dbBlobs[2:]...)
+
+	// the deleted blobs should have been invalidated on the CDN (in any order)
+	expectedURLs := []string{
+		"https://cdn.example.org/test1/" + dbBlobs[0].Digest.String(),
+		"https://cdn.example.org/test1/" + dbBlobs[1].Digest.String(),
+	}
+	slices.Sort(expectedURLs)
+	slices.Sort(s.CDN.InvalidatedURLs)
+	assert.DeepEqual(t, "invalidated CDN URLs", s.CDN.InvalidatedURLs, expectedURLs)
+}
+
+func TestSweepBlobsInBatches(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+
+	defer func(prev int) { sweepBatchSize = prev }(sweepBatchSize)
+	sweepBatchSize = 2
+	sweepBlobsJob := j.BlobSweepJob(s.Registry)
+
+	var dbBlobs []models.Blob
+	for idx := range 5 {
+		blob := test.GenerateExampleLayer(int64(idx))
+		dbBlobs = append(dbBlobs, blob.MustUpload(t, s, fooRepoRef))
+	}
+	mustExec(t, s.DB, `DELETE FROM blob_mounts WHERE blob_id IN ($1,$2)`, dbBlobs[0].ID, dbBlobs[3].ID)
+
+	expectCursor := func(expected int64) {
+		t.Helper()
+		cursor, err := s.DB.SelectInt(`SELECT blob_sweep_cursor FROM accounts WHERE name = 'test1'`)
+		mustDo(t, err)
+		assert.DeepEqual(t, "blob_sweep_cursor", cursor, expected)
+	}
+	expectBlobIDs := func(query string, expected ...int64) {
+		t.Helper()
+		var ids []int64
+		_, err := s.DB.Select(&ids, query)
+		mustDo(t, err)
+		assert.DeepEqual(t, "blob IDs", ids, expected)
+	}
+
+	// each task processes only one batch of blobs, and the position within the
+	// pass is persisted on the account
+	expectSuccess(t, sweepBlobsJob.ProcessOne(s.Ctx))
+	expectCursor(dbBlobs[1].ID)
+	expectBlobIDs(`SELECT id FROM blobs WHERE can_be_deleted_at IS NOT NULL ORDER BY id`, dbBlobs[0].ID)
+
+	// the account is due again right away to continue the pass
+	s.Clock.StepBy(1 * time.Minute)
+	expectSuccess(t, sweepBlobsJob.ProcessOne(s.Ctx))
+	expectCursor(dbBlobs[3].ID)
+	expectBlobIDs(`SELECT id FROM blobs WHERE can_be_deleted_at IS NOT NULL ORDER BY id`, dbBlobs[0].ID, dbBlobs[3].ID)
+
+	// the last batch completes the pass and resets the cursor
+	s.Clock.StepBy(1 * time.Minute)
+	expectSuccess(t, sweepBlobsJob.ProcessOne(s.Ctx))
+	expectCursor(0)
+	s.Clock.StepBy(1 * time.Minute)
+	expectError(t, sql.ErrNoRows.Error(), sweepBlobsJob.ProcessOne(s.Ctx))
+
+	// the next pass deletes the marked blobs, again spread over several tasks
+	for range 3 {
+		s.Clock.StepBy(2 * time.Hour)
+		expectSuccess(t, sweepBlobsJob.ProcessOne(s.Ctx))
+	}
+	expectCursor(0)
+	expectBlobIDs(`SELECT id FROM blobs ORDER BY id`, dbBlobs[1].ID, dbBlobs[2].ID, dbBlobs[4].ID)
+	s.ExpectBlobsMissingInStorage(t, dbBlobs[0], dbBlobs[3])
+	s.ExpectBlobsExistInStorage(t, dbBlobs[1], dbBlobs[2], dbBlobs[4])
 }
 
 func TestValidateBlobs(t *testing.T) {
@@ -139,3 +211,68 @@ func TestValidateBlobs(t *testing.T) {
 	expectError(t, sql.ErrNoRows.Error(), validateBlobJob.ProcessOne(s.Ctx))
 	easypg.AssertDBContent(t, s.DB.DbMap.Db, "fixtures/blob-validate-003.sql")
 }
+
+func TestBlobPrefetchJob(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		_, s1 := setup(t)
+		j2, s2 := setupReplica(t, s1, "on_first_use")
+		s1.Clock.StepBy(1 * time.Hour)
+		replicaToken := s2.GetToken(t, "repository:test1/foo:pull")
+		job := j2.BlobPrefetchJob(s2.Registry)
+
+		images := make([]test.Image, 2)
+		for idx := range images {
+			images[idx] = test.GenerateImage(test.GenerateExampleLayer(int64(idx+1)), test.GenerateExampleLayer(int64(idx+11)))
+			images[idx].MustUpload(t, s1, fooRepoRef, "")
+		}
+		pullManifest := func(idx int) {
+			t.Helper()
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         fmt.Sprintf("/v2/test1/foo/manifests/%s", images[idx].Manifest.Digest),
+				Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+				ExpectStatus: http.StatusOK,
+				ExpectBody:   assert.ByteData(images[idx].Manifest.Contents),
+			}.Check(t, s2.Handler)
+		}
+		countUnbackedLayers := func(idx int) int64 {
+			t.Helper()
+			count, err := s2.DB.SelectInt(`SELECT COUNT(*) FROM blobs WHERE storage_id = '' AND digest IN ($1, $2)`,
+				images[idx].Layers[0].Digest, images[idx].Layers[1].Digest)
+			mustDo(t, err)
+			return count
+		}
+
+		// without prefetching, replicating a manifest does not enqueue its blobs
+		pullManifest(0)
+		s2.Clock.StepBy(time.Minute)
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s2.Ctx))
+		assert.DeepEqual(t, "unbacked layers of image 0", countUnbackedLayers(0), int64(2))
+
+		// with prefetching, all missing blobs of the next replicated manifest are
+		// replicated in the background (the image config was already replicated
+		// together with the manifest)
+		mustExec(t, s2.DB, `UPDATE accounts SET prefetch_blobs = TRUE`)
+		pullManifest(1)
+		s2.Clock.StepBy(time.Minute)
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s2.Ctx))
+		assert.DeepEqual(t, "unbacked layers of image 1", countUnbackedLayers(1), int64(0))
+		assert.DeepEqual(t, "unbacked layers of image 0", countUnbackedLayers(0), int64(2))
+		queued, err := s2.DB.SelectInt(`SELECT COUNT(*) FROM blobs WHERE next_prefetch_at IS NOT NULL`)
+		mustDo(t, err)
+		assert.DeepEqual(t, "blobs queued for prefetch", queued, int64(0))
+
+		// the prefetched blobs can be pulled without asking the primary
+		for _, layer := range images[1].Layers {
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         "/v2/test1/foo/blobs/" + layer.Digest.String(),
+				Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+				ExpectStatus: http.StatusOK,
+				ExpectBody:   assert.ByteData(layer.Contents),
+			}.Check(t, s2.Handler)
+		}
+	})
+}
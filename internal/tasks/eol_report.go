@@ -0,0 +1,164 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// how many reports are kept in the `eol_reports` table
+const eolReportRetentionCount = 10
+
+// EOLReportContents is the format of the reports generated by EOLReportJob.
+// It is stored in the `eol_reports` table and sent to the webhook.
+type EOLReportContents struct {
+	GeneratedAt     int64            `json:"generated_at"`
+	MaxImageAgeDays uint64           `json:"max_image_age_days,omitempty"`
+	Entries         []EOLReportEntry `json:"entries"`
+}
+
+// EOLReportEntry appears in type EOLReportContents.
+type EOLReportEntry struct {
+	AuthTenantID        string                     `json:"auth_tenant_id"`
+	AccountName         models.AccountName         `json:"account"`
+	RepositoryName      string                     `json:"repository"`
+	Digest              digest.Digest              `json:"digest"`
+	VulnerabilityStatus models.VulnerabilityStatus `json:"vulnerability_status,omitempty"`
+	MaxLayerCreatedAt   *int64                     `json:"max_layer_created_at,omitempty"`
+	// Reasons contains "rotten" and/or "outdated".
+	Reasons []string `json:"reasons"`
+}
+
+var eolReportSelectQuery = sqlext.SimplifyWhitespace(`
+	SELECT a.auth_tenant_id, a.name, r.name, m.digest, COALESCE(t.vuln_status, ''), m.max_layer_created_at
+	  FROM manifests m
+	  JOIN repos r ON r.id = m.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	  LEFT OUTER JOIN trivy_security_info t ON t.repo_id = m.repo_id AND t.digest = m.digest
+	 WHERE t.vuln_status = $1 OR m.max_layer_created_at < $2
+	 ORDER BY a.name, r.name, m.digest
+`)
+
+var eolReportCleanupQuery = sqlext.SimplifyWhitespace(`
+	DELETE FROM eol_reports WHERE id NOT IN (SELECT id FROM eol_reports ORDER BY generated_at DESC LIMIT $1)
+`)
+
+// EOLReportJob is a job that periodically compiles a list of all manifests
+// that are based on end-of-life images, i.e. manifests with vulnerability
+// status "Rotten" and (if configured) manifests whose newest layer is older
+// than the configured maximum image age. The report is stored in the
+// `eol_reports` table and, if configured, sent to a webhook. It is only
+// started if EOL reports are enabled in the configuration.
+func (j *Janitor) EOLReportJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "generation of EOL image report",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_eol_report_generations",
+				Help: "Counter for generations of the report of end-of-life images.",
+			},
+		},
+		Interval:     j.cfg.EOLReportInterval,
+		InitialDelay: 5 * time.Minute,
+		Task:         j.trackCronTask("eol_report", j.generateEOLReport),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) generateEOLReport(ctx context.Context, _ prometheus.Labels) error {
+	now := j.timeNow()
+	report := EOLReportContents{
+		GeneratedAt: now.Unix(),
+		Entries:     []EOLReportEntry{},
+	}
+	var cutoff *time.Time
+	if j.cfg.EOLReportMaxImageAge > 0 {
+		report.MaxImageAgeDays = uint64(j.cfg.EOLReportMaxImageAge / (24 * time.Hour))
+		cutoffTime := now.Add(-j.cfg.EOLReportMaxImageAge)
+		cutoff = &cutoffTime
+	}
+
+	entryCountByAccount := make(map[models.AccountName]int)
+	err := sqlext.ForeachRow(j.db, eolReportSelectQuery, []any{models.RottenVulnerabilityStatus, cutoff}, func(rows *sql.Rows) error {
+		var (
+			entry             EOLReportEntry
+			maxLayerCreatedAt *time.Time
+		)
+		err := rows.Scan(&entry.AuthTenantID, &entry.AccountName, &entry.RepositoryName, &entry.Digest, &entry.VulnerabilityStatus, &maxLayerCreatedAt)
+		if err != nil {
+			return err
+		}
+		entry.Reasons = []string{}
+		if entry.VulnerabilityStatus == models.RottenVulnerabilityStatus {
+			entry.Reasons = append(entry.Reasons, "rotten")
+		}
+		entry.MaxLayerCreatedAt = keppel.MaybeTimeToUnix(maxLayerCreatedAt)
+		if cutoff != nil && maxLayerCreatedAt != nil && maxLayerCreatedAt.Before(*cutoff) {
+			entry.Reasons = append(entry.Reasons, "outdated")
+		}
+		report.Entries = append(report.Entries, entry)
+		entryCountByAccount[entry.AccountName]++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("while compiling EOL report: %w", err)
+	}
+
+	buf, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	err = j.db.Insert(&models.EOLReport{
+		GeneratedAt:  now,
+		EntryCount:   uint64(len(report.Entries)),
+		ContentsJSON: string(buf),
+	})
+	if err != nil {
+		return fmt.Errorf("while storing EOL report: %w", err)
+	}
+	_, err = j.db.Exec(eolReportCleanupQuery, eolReportRetentionCount)
+	if err != nil {
+		return fmt.Errorf("while cleaning up old EOL reports: %w", err)
+	}
+
+	EOLReportEntriesGauge.Reset()
+	for accountName, count := range entryCountByAccount {
+		EOLReportEntriesGauge.With(prometheus.Labels{"account": string(accountName)}).Set(float64(count))
+	}
+
+	if j.cfg.EOLReportWebhookURL != "" {
+		err = postJSONToWebhook(ctx, j.cfg.EOLReportWebhookURL, buf)
+		if err != nil {
+			return fmt.Errorf("while sending EOL report to webhook: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestEOLReportJob(t *testing.T) {
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		j, s := setup(t)
+		j.cfg.EOLReportInterval = 24 * time.Hour
+		j.cfg.EOLReportMaxImageAge = 365 * 24 * time.Hour
+		j.cfg.EOLReportWebhookURL = "https://webhook.example.org/eol"
+		s.Clock.StepBy(2 * 365 * 24 * time.Hour)
+		job := j.EOLReportJob(s.Registry)
+
+		// capture the reports that are sent to the webhook
+		var webhookBodies [][]byte
+		tt.Handlers["webhook.example.org"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buf, err := io.ReadAll(r.Body)
+			mustDo(t, err)
+			webhookBodies = append(webhookBodies, buf)
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		// upload some images:
+		// - images[0] is recent and clean (not in the report)
+		// - images[1] is Rotten
+		// - images[2] is older than the max image age
+		images := make([]test.Image, 3)
+		for idx := range images {
+			image := test.GenerateImage(test.GenerateExampleLayer(int64(idx + 1)))
+			images[idx] = image
+			image.MustUpload(t, s, fooRepoRef, "")
+		}
+		recentTime := s.Clock.Now().Add(-24 * time.Hour)
+		oldTime := s.Clock.Now().Add(-400 * 24 * time.Hour)
+		mustExec(t, s.DB, `UPDATE manifests SET max_layer_created_at = $1 WHERE digest != $2`, recentTime, images[2].Manifest.Digest)
+		mustExec(t, s.DB, `UPDATE manifests SET max_layer_created_at = $1 WHERE digest = $2`, oldTime, images[2].Manifest.Digest)
+		mustExec(t, s.DB, `UPDATE trivy_security_info SET vuln_status = $1 WHERE digest = $2`, models.RottenVulnerabilityStatus, images[1].Manifest.Digest)
+
+		expectedReport := EOLReportContents{
+			GeneratedAt:     s.Clock.Now().Unix(),
+			MaxImageAgeDays: 365,
+			Entries: []EOLReportEntry{
+				{
+					AuthTenantID:        "test1authtenant",
+					AccountName:         "test1",
+					RepositoryName:      "foo",
+					Digest:              images[1].Manifest.Digest,
+					VulnerabilityStatus: models.RottenVulnerabilityStatus,
+					MaxLayerCreatedAt:   keppel.MaybeTimeToUnix(&recentTime),
+					Reasons:             []string{"rotten"},
+				},
+				{
+					AuthTenantID:        "test1authtenant",
+					AccountName:         "test1",
+					RepositoryName:      "foo",
+					Digest:              images[2].Manifest.Digest,
+					VulnerabilityStatus: models.PendingVulnerabilityStatus,
+					MaxLayerCreatedAt:   keppel.MaybeTimeToUnix(&oldTime),
+					Reasons:             []string{"outdated"},
+				},
+			},
+		}
+		// the order of entries in the report is determined by digest
+		if expectedReport.Entries[0].Digest > expectedReport.Entries[1].Digest {
+			expectedReport.Entries[0], expectedReport.Entries[1] = expectedReport.Entries[1], expectedReport.Entries[0]
+		}
+
+		expectSuccess(t, job.ProcessOne(s.Ctx))
+
+		// check stored report
+		var reports []models.EOLReport
+		_, err := s.DB.Select(&reports, `SELECT * FROM eol_reports`)
+		mustDo(t, err)
+		assert.DeepEqual(t, "number of stored reports", len(reports), 1)
+		assert.DeepEqual(t, "entry count", reports[0].EntryCount, uint64(2))
+		var storedReport EOLReportContents
+		mustDo(t, json.Unmarshal([]byte(reports[0].ContentsJSON), &storedReport))
+		assert.DeepEqual(t, "stored report", storedReport, expectedReport)
+
+		// check report sent to webhook
+		assert.DeepEqual(t, "number of webhook calls", len(webhookBodies), 1)
+		assert.DeepEqual(t, "webhook payload", string(webhookBodies[0]), reports[0].ContentsJSON)
+
+		// only the most recent reports are retained
+		for range eolReportRetentionCount + 2 {
+			s.Clock.StepBy(24 * time.Hour)
+			expectSuccess(t, job.ProcessOne(s.Ctx))
+		}
+		reportCount, err := s.DB.SelectInt(`SELECT COUNT(*) FROM eol_reports`)
+		mustDo(t, err)
+		assert.DeepEqual(t, "number of stored reports", reportCount, int64(eolReportRetentionCount))
+
+		// webhook errors are reported
+		tt.Handlers["webhook.example.org"] = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "nope", http.StatusInternalServerError)
+		})
+		expectError(t, "while sending EOL report to webhook: POST https://webhook.example.org/eol returned unexpected status 500 Internal Server Error",
+			job.ProcessOne(s.Ctx))
+	})
+}
@@ -0,0 +1,446 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var globalBlobDeduplicationSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM blobs WHERE storage_id != '' AND NOT is_global
+	ORDER BY id
+	LIMIT 1 -- one at a time
+`)
+
+var globalBlobLockQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM global_blobs WHERE digest = $1 FOR UPDATE
+`)
+
+var globalBlobMarkGlobalQuery = sqlext.SimplifyWhitespace(`
+	UPDATE blobs SET storage_id = $3, is_global = TRUE
+	WHERE id = $1 AND storage_id = $2 AND NOT is_global
+`)
+
+// GlobalBlobDeduplicationJob is a job. Each task finds one blob whose contents
+// are still stored in its account's storage location, and moves it into the
+// global blob store: If there already is a shared storage object for its
+// digest, the blob is pointed to it; otherwise the blob contents are copied
+// into the global blob store and become the shared storage object for that
+// digest. Afterwards, the blob's storage object in the account's storage
+// location is deleted.
+//
+// This job is only used if keppel.Configuration.GlobalBlobStore is set.
+func (j *Janitor) GlobalBlobDeduplicationJob(registerer prometheus.Registerer) jobloop.Job { //nolint:dupl // false positive
+	return (&jobloop.ProducerConsumerJob[models.Blob]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "deduplicate blobs in global blob store",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_global_blob_deduplications",
+				Help: "Counter for blobs moved into the global blob store.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (blob models.Blob, err error) {
+			err = j.db.SelectOne(&blob, globalBlobDeduplicationSearchQuery)
+			return blob, err
+		},
+		ProcessTask: trackTask(j, "global_blob_deduplication", j.deduplicateBlob),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) deduplicateBlob(ctx context.Context, blob models.Blob, _ prometheus.Labels) (returnErr error) {
+	account, err := keppel.FindReducedAccount(j.db, blob.AccountName)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return fmt.Errorf("cannot find account %s", blob.AccountName)
+	}
+	globalLocation := j.cfg.GlobalBlobStoreLocation()
+
+	// unless there already is a matching shared storage object, copy the blob
+	// contents into the global blob store (this happens outside of the
+	// transaction below since it can take a long time)
+	var existingGlobalBlob models.GlobalBlob
+	err = j.db.SelectOne(&existingGlobalBlob, `SELECT * FROM global_blobs WHERE digest = $1`, blob.Digest)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	copiedStorageID := ""
+	if errors.Is(err, sql.ErrNoRows) || existingGlobalBlob.SizeBytes != blob.SizeBytes {
+		copiedStorageID, err = j.copyBlobIntoGlobalBlobStore(ctx, *account, globalLocation, blob)
+		if err != nil {
+			return fmt.Errorf("cannot copy blob %s in account %s into global blob store: %w", blob.Digest, blob.AccountName, err)
+		}
+	}
+
+	// if we end up not using the copy, do not leave it behind
+	defer func() {
+		if copiedStorageID != "" {
+			err := j.sd.DeleteBlob(ctx, globalLocation, copiedStorageID)
+			if err != nil {
+				if returnErr == nil {
+					returnErr = err
+				} else {
+					logg.Error("additional error encountered while deleting unused copy of blob %s in global blob store: %s",
+						blob.Digest, err.Error())
+				}
+			}
+		}
+	}()
+
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	// NOTE: The lock on the global_blobs record (if any) must always be taken
+	// before the lock on the blobs record, see deleteBlobRecord().
+	globalBlob, err := lockGlobalBlob(tx, blob)
+	if err != nil {
+		return err
+	}
+	var targetStorageID string
+	switch {
+	case globalBlob == nil:
+		if copiedStorageID == "" {
+			// the shared storage object was deleted since we looked -> try again later
+			return nil
+		}
+		// this is the first blob with this digest, so our copy becomes the
+		// shared storage object
+		targetStorageID = copiedStorageID
+		err = tx.Insert(&models.GlobalBlob{
+			Digest:    blob.Digest,
+			StorageID: targetStorageID,
+			SizeBytes: blob.SizeBytes,
+			RefCount:  1,
+		})
+	case globalBlob.SizeBytes != blob.SizeBytes:
+		// should be impossible since the digests match, but if it happens, the
+		// blob keeps its own storage object (but in the global blob store)
+		if copiedStorageID == "" {
+			return nil // the global_blobs record was replaced since we looked -> try again later
+		}
+		logg.Error("not deduplicating blob %s in account %s: size is %d bytes, but %d bytes in global blob store",
+			blob.Digest, blob.AccountName, blob.SizeBytes, globalBlob.SizeBytes)
+		targetStorageID = copiedStorageID
+	default:
+		// point the blob to the existing shared storage object
+		targetStorageID = globalBlob.StorageID
+		_, err = tx.Exec(`UPDATE global_blobs SET ref_count = ref_count + 1 WHERE digest = $1`, globalBlob.Digest)
+	}
+	if err != nil {
+		return err
+	}
+
+	// if the blob record has changed in the meantime, try again later
+	result, err := tx.Exec(globalBlobMarkGlobalQuery, blob.ID, blob.StorageID, targetStorageID)
+	if err != nil {
+		return err
+	}
+	rowsUpdated, err := result.RowsAffected()
+	if err != nil || rowsUpdated == 0 {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	if targetStorageID == copiedStorageID {
+		copiedStorageID = "" // our copy is in use now, so the deferred cleanup must not delete it
+	}
+
+	// the blob's storage object in the account's storage location is not referenced anymore
+	return j.sd.DeleteBlob(ctx, *account, blob.StorageID)
+}
+
+// Copies the contents of the given blob from its account's storage location
+// into the global blob store. Returns the storage ID of the copy.
+func (j *Janitor) copyBlobIntoGlobalBlobStore(ctx context.Context, account, globalLocation models.ReducedAccount, blob models.Blob) (storageID string, returnErr error) {
+	contents, sizeBytes, err := j.sd.ReadBlob(ctx, account, blob.StorageID)
+	if err != nil {
+		return "", err
+	}
+	defer contents.Close()
+	if sizeBytes != blob.SizeBytes {
+		return "", fmt.Errorf("expected %d bytes in storage, but found %d bytes", blob.SizeBytes, sizeBytes)
+	}
+
+	// if anything goes wrong, do not leave a partial upload in the global blob store
+	upload := models.Upload{StorageID: j.generateStorageID()}
+	defer func() {
+		if returnErr != nil && upload.NumChunks > 0 {
+			err := j.sd.AbortBlobUpload(ctx, globalLocation, upload.StorageID, upload.NumChunks)
+			if err != nil {
+				logg.Error("additional error encountered during AbortBlobUpload: " + err.Error())
+			}
+		}
+	}()
+
+	verifier := blob.Digest.Verifier()
+	err = j.processor().AppendToBlob(ctx, globalLocation, &upload, io.TeeReader(contents, verifier), &sizeBytes)
+	if err != nil {
+		return "", err
+	}
+	if !verifier.Verified() {
+		return "", fmt.Errorf("contents in storage do not match digest %s", blob.Digest)
+	}
+	err = j.sd.FinalizeBlob(ctx, globalLocation, upload.StorageID, upload.NumChunks)
+	if err != nil {
+		return "", err
+	}
+	upload.NumChunks = 0 // do not abort the upload after a successful FinalizeBlob
+	return upload.StorageID, nil
+}
+
+// Deletes the DB record of a blob in BlobSweepJob. Returns the storage location
+// and storage ID of the blob's storage object if it shall be deleted as well,
+// or the empty string as storage ID if not. The latter is the case for
+// unbacked blobs, and for blobs whose storage object is still shared with
+// other blobs in the global blob store.
+func (j *Janitor) deleteBlobRecord(account models.ReducedAccount, blob models.Blob) (location models.ReducedAccount, storageIDToDelete string, err error) {
+	if j.cfg.GlobalBlobStore == nil && !blob.IsGlobal {
+		_, err := j.db.Delete(&blob)
+		return account, blob.StorageID, err
+	}
+
+	tx, err := j.db.Begin()
+	if err != nil {
+		return account, "", err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	// lock the global_blobs record first to avoid deadlocks with deduplicateBlob()
+	globalBlob, err := lockGlobalBlob(tx, blob)
+	if err != nil {
+		return account, "", err
+	}
+
+	// re-read the blob record since deduplicateBlob() might have changed it
+	// since BlobSweepJob selected it
+	err = tx.SelectOne(&blob, `SELECT * FROM blobs WHERE id = $1 FOR UPDATE`, blob.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return account, "", nil
+	}
+	if err != nil {
+		return account, "", err
+	}
+	if blob.IsGlobal && globalBlob == nil {
+		// deduplicateBlob() might have created the global_blobs record while we
+		// were waiting for the lock on the blob
+		globalBlob, err = lockGlobalBlob(tx, blob)
+		if err != nil {
+			return account, "", err
+		}
+	}
+	location = j.cfg.BlobStorageLocation(account, blob)
+
+	_, err = tx.Delete(&blob)
+	if err != nil {
+		return location, "", err
+	}
+
+	isShared := blob.IsGlobal && globalBlob != nil && globalBlob.StorageID == blob.StorageID
+	switch {
+	case !isShared:
+		storageIDToDelete = blob.StorageID
+	case globalBlob.RefCount > 1:
+		storageIDToDelete = ""
+		_, err = tx.Exec(`UPDATE global_blobs SET ref_count = ref_count - 1 WHERE digest = $1`, globalBlob.Digest)
+	default:
+		// this was the last reference to the shared storage object
+		storageIDToDelete = blob.StorageID
+		_, err = tx.Delete(globalBlob)
+	}
+	if err != nil {
+		return location, "", err
+	}
+	return location, storageIDToDelete, tx.Commit()
+}
+
+func lockGlobalBlob(tx *gorp.Transaction, blob models.Blob) (*models.GlobalBlob, error) {
+	var globalBlob models.GlobalBlob
+	err := tx.SelectOne(&globalBlob, globalBlobLockQuery, blob.Digest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &globalBlob, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// storage sweep for the global blob store
+
+var globalBlobStorageSweepKnownBlobsQuery = sqlext.SimplifyWhitespace(`
+	SELECT storage_id FROM blobs
+	 WHERE is_global AND storage_id = ANY(string_to_array($1, ','))
+	UNION
+	SELECT storage_id FROM global_blobs
+	 WHERE storage_id = ANY(string_to_array($1, ','))
+`)
+
+var globalBlobStorageSweepUnmarkQuery = sqlext.SimplifyWhitespace(`
+	DELETE FROM unknown_global_blobs
+	 WHERE storage_id IN (SELECT storage_id FROM blobs WHERE is_global)
+	    OR storage_id IN (SELECT storage_id FROM global_blobs)
+`)
+
+// GlobalBlobStorageSweepJob is a job. It is the equivalent of StorageSweepJob
+// for the storage location of the global blob store, which does not belong to
+// any actual account and is therefore not covered by StorageSweepJob. Blobs in
+// there that are not referenced by the database are marked in one pass, and
+// deleted in the next pass if they are still not referenced by then.
+//
+// This job is only used if keppel.Configuration.GlobalBlobStore is set.
+func (j *Janitor) GlobalBlobStorageSweepJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "storage sweep in global blob store",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_global_blob_storage_sweeps",
+				Help: "Counter for garbage collections of the global blob store's backing storage.",
+			},
+		},
+		Interval:     6 * time.Hour,
+		InitialDelay: 10 * time.Minute,
+		Task:         j.trackCronTask("global_blob_storage_sweep", j.sweepGlobalBlobStorage),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) sweepGlobalBlobStorage(ctx context.Context, _ prometheus.Labels) error {
+	location := j.cfg.GlobalBlobStoreLocation()
+
+	// same as in sweepStorage(): the next pass 6 hours from now shall sweep new marks
+	canBeDeletedAt := j.timeNow().Add(4 * time.Hour)
+
+	err := j.sd.ListStorageContents(ctx, location,
+		func(actualBlobs []keppel.StoredBlobInfo) error {
+			return j.sweepGlobalBlobStorageBatch(ctx, location, actualBlobs, canBeDeletedAt)
+		},
+		func(actualManifests []keppel.StoredManifestInfo) error {
+			// manifests are never stored in the global blob store
+			if len(actualManifests) > 0 {
+				logg.Error("storage sweep in global blob store: ignoring %d unexpected manifests", len(actualManifests))
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// clean up marks that were not touched by the listing (see sweepStorage() for rationale)
+	_, err = j.db.Exec(globalBlobStorageSweepUnmarkQuery)
+	if err != nil {
+		return err
+	}
+	_, err = j.db.Exec(`DELETE FROM unknown_global_blobs WHERE can_be_deleted_at < $1`, j.timeNow())
+	return err
+}
+
+func (j *Janitor) sweepGlobalBlobStorageBatch(ctx context.Context, location models.ReducedAccount, actualBlobs []keppel.StoredBlobInfo, canBeDeletedAt time.Time) error {
+	storageIDs := make([]string, len(actualBlobs))
+	for idx, blobInfo := range actualBlobs {
+		storageIDs[idx] = blobInfo.StorageID
+	}
+	storageIDsStr := strings.Join(storageIDs, ",")
+
+	// find which of these blobs are known to the DB
+	isKnownStorageID := make(map[string]bool)
+	err := sqlext.ForeachRow(j.db, globalBlobStorageSweepKnownBlobsQuery, []any{storageIDsStr}, func(rows *sql.Rows) error {
+		var storageID string
+		err := rows.Scan(&storageID)
+		isKnownStorageID[storageID] = true
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// find which of these blobs have been marked in a previous pass
+	var unknownBlobs []models.UnknownGlobalBlob
+	_, err = j.db.Select(&unknownBlobs, `SELECT * FROM unknown_global_blobs WHERE storage_id = ANY(string_to_array($1, ','))`, storageIDsStr)
+	if err != nil {
+		return err
+	}
+	markedBlobs := make(map[string]models.UnknownGlobalBlob, len(unknownBlobs))
+	for _, unknownBlob := range unknownBlobs {
+		markedBlobs[unknownBlob.StorageID] = unknownBlob
+	}
+
+	for _, blobInfo := range actualBlobs {
+		if isKnownStorageID[blobInfo.StorageID] {
+			continue
+		}
+
+		// mark phase: record newly discovered unknown blobs in the DB
+		unknownBlob, isMarked := markedBlobs[blobInfo.StorageID]
+		if !isMarked {
+			err := j.db.Insert(&models.UnknownGlobalBlob{
+				StorageID:      blobInfo.StorageID,
+				CanBeDeletedAt: canBeDeletedAt,
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// sweep phase: delete blobs that have been marked long enough
+		if !unknownBlob.CanBeDeletedAt.Before(j.timeNow()) {
+			continue
+		}
+		if blobInfo.ChunkCount > 0 {
+			logg.Info("storage sweep in global blob store: removing unfinalized blob stored at %s with %d chunks",
+				unknownBlob.StorageID, blobInfo.ChunkCount)
+			err = j.sd.AbortBlobUpload(ctx, location, unknownBlob.StorageID, blobInfo.ChunkCount)
+		} else {
+			logg.Info("storage sweep in global blob store: removing finalized blob stored at %s",
+				unknownBlob.StorageID)
+			err = j.sd.DeleteBlob(ctx, location, unknownBlob.StorageID)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = j.db.Delete(&unknownBlob)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
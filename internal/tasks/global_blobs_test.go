@@ -0,0 +1,168 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestGlobalBlobDeduplication(t *testing.T) {
+	j, s := setup(t,
+		test.WithGlobalBlobStore,
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "test1authtenant"}),
+		test.WithRepo(models.Repository{AccountName: "test2", Name: "bar"}),
+	)
+	s.Clock.StepBy(1 * time.Hour)
+	dedupJob := j.GlobalBlobDeduplicationJob(s.Registry)
+	sweepBlobsJob := j.BlobSweepJob(s.Registry)
+
+	// runs BlobSweepJob on all accounts
+	sweepAllBlobs := func() {
+		t.Helper()
+		for {
+			err := sweepBlobsJob.ProcessOne(s.Ctx)
+			if errors.Is(err, sql.ErrNoRows) {
+				return
+			}
+			mustDo(t, err)
+		}
+	}
+	expectGlobalBlobs := func(expected ...models.GlobalBlob) {
+		t.Helper()
+		var globalBlobs []models.GlobalBlob
+		_, err := s.DB.Select(&globalBlobs, `SELECT * FROM global_blobs ORDER BY digest`)
+		mustDo(t, err)
+		slices.SortFunc(expected, func(lhs, rhs models.GlobalBlob) int {
+			return strings.Compare(lhs.Digest.String(), rhs.Digest.String())
+		})
+		assert.DeepEqual(t, "global blobs", globalBlobs, expected)
+	}
+
+	// loads the current state of a blob from the DB
+	reloadBlob := func(blob models.Blob) models.Blob {
+		t.Helper()
+		mustDo(t, s.DB.SelectOne(&blob, `SELECT * FROM blobs WHERE id = $1`, blob.ID))
+		return blob
+	}
+
+	// upload the same blob into both accounts, as well as another blob that
+	// only exists in one account: at first, each blob has its own storage
+	// object in its account's storage location
+	blob := test.GenerateExampleLayer(1)
+	blob1 := blob.MustUpload(t, s, fooRepoRef)
+	blob2 := blob.MustUpload(t, s, models.Repository{AccountName: "test2", Name: "bar"})
+	otherBlob := test.GenerateExampleLayer(2).MustUpload(t, s, fooRepoRef)
+	assert.DeepEqual(t, "storage object count", s.SD.BlobCount(), 3)
+	s.ExpectBlobsExistInStorage(t, blob1, blob2, otherBlob)
+
+	// deduplication moves the blobs into the global blob store, where both
+	// blobs with the same digest share the same storage object
+	for range 3 {
+		expectSuccess(t, dedupJob.ProcessOne(s.Ctx))
+	}
+	expectError(t, sql.ErrNoRows.Error(), dedupJob.ProcessOne(s.Ctx))
+	assert.DeepEqual(t, "storage object count", s.SD.BlobCount(), 2)
+	s.ExpectBlobsMissingInStorage(t, blob1, blob2, otherBlob)
+
+	blob1 = reloadBlob(blob1)
+	blob2 = reloadBlob(blob2)
+	otherBlob = reloadBlob(otherBlob)
+	assert.DeepEqual(t, "location of deduplicated blob", blob1.IsGlobal, true)
+	assert.DeepEqual(t, "location of deduplicated blob", blob2.IsGlobal, true)
+	assert.DeepEqual(t, "location of deduplicated blob", otherBlob.IsGlobal, true)
+	assert.DeepEqual(t, "storage ID of deduplicated blob", blob2.StorageID, blob1.StorageID)
+	expectGlobalBlobs(
+		models.GlobalBlob{Digest: blob.Digest, StorageID: blob1.StorageID, SizeBytes: blob1.SizeBytes, RefCount: 2},
+		models.GlobalBlob{Digest: otherBlob.Digest, StorageID: otherBlob.StorageID, SizeBytes: otherBlob.SizeBytes, RefCount: 1},
+	)
+	s.ExpectBlobsExistInStorage(t, blob1, blob2, otherBlob)
+
+	// when the blob is deleted from one account, the shared storage object
+	// needs to be retained for the other account
+	mustExec(t, s.DB, `DELETE FROM blob_mounts WHERE blob_id = $1`, blob1.ID)
+	sweepAllBlobs() // marks blob1 for deletion
+	s.Clock.StepBy(2 * time.Hour)
+	sweepAllBlobs() // deletes blob1
+	expectGlobalBlobs(
+		models.GlobalBlob{Digest: blob.Digest, StorageID: blob1.StorageID, SizeBytes: blob1.SizeBytes, RefCount: 1},
+		models.GlobalBlob{Digest: otherBlob.Digest, StorageID: otherBlob.StorageID, SizeBytes: otherBlob.SizeBytes, RefCount: 1},
+	)
+	s.ExpectBlobsExistInStorage(t, blob2, otherBlob)
+
+	// when the last reference goes away, the storage object is deleted
+	mustExec(t, s.DB, `DELETE FROM blob_mounts WHERE blob_id = $1`, blob2.ID)
+	s.Clock.StepBy(2 * time.Hour)
+	sweepAllBlobs() // marks blob2 for deletion
+	s.Clock.StepBy(2 * time.Hour)
+	sweepAllBlobs() // deletes blob2
+	expectGlobalBlobs(
+		models.GlobalBlob{Digest: otherBlob.Digest, StorageID: otherBlob.StorageID, SizeBytes: otherBlob.SizeBytes, RefCount: 1},
+	)
+	s.ExpectBlobsMissingInStorage(t, blob2)
+	s.ExpectBlobsExistInStorage(t, otherBlob)
+	assert.DeepEqual(t, "storage object count", s.SD.BlobCount(), 1)
+}
+
+func TestGlobalBlobStorageSweep(t *testing.T) {
+	j, s := setup(t, test.WithGlobalBlobStore)
+	s.Clock.StepBy(1 * time.Hour)
+	dedupJob := j.GlobalBlobDeduplicationJob(s.Registry)
+	sweepJob := j.GlobalBlobStorageSweepJob(s.Registry)
+
+	// move a blob into the global blob store
+	blob := test.GenerateExampleLayer(1).MustUpload(t, s, fooRepoRef)
+	expectSuccess(t, dedupJob.ProcessOne(s.Ctx))
+	mustDo(t, s.DB.SelectOne(&blob, `SELECT * FROM blobs WHERE id = $1`, blob.ID))
+
+	// put a blob into the global blob store without adding it in the DB
+	location := s.Config.GlobalBlobStoreLocation()
+	strayBlob := test.GenerateExampleLayer(2)
+	strayStorageID := strayBlob.Digest.Encoded()
+	sizeBytes := uint64(len(strayBlob.Contents))
+	mustDo(t, s.SD.AppendToBlob(s.Ctx, location, strayStorageID, 1, &sizeBytes, bytes.NewReader(strayBlob.Contents)))
+	mustDo(t, s.SD.FinalizeBlob(s.Ctx, location, strayStorageID, 1))
+	strayBlobRecord := models.Blob{Digest: strayBlob.Digest, StorageID: strayStorageID, IsGlobal: true}
+
+	// the first sweep only marks the stray blob...
+	expectSuccess(t, sweepJob.ProcessOne(s.Ctx))
+	count, err := s.DB.SelectInt(`SELECT COUNT(*) FROM unknown_global_blobs WHERE storage_id = $1`, strayStorageID)
+	mustDo(t, err)
+	assert.DeepEqual(t, "unknown global blob count", count, int64(1))
+	s.ExpectBlobsExistInStorage(t, blob, strayBlobRecord)
+
+	// ...and the next sweep deletes it, but leaves the known blob alone
+	s.Clock.StepBy(6 * time.Hour)
+	expectSuccess(t, sweepJob.ProcessOne(s.Ctx))
+	count, err = s.DB.SelectInt(`SELECT COUNT(*) FROM unknown_global_blobs`)
+	mustDo(t, err)
+	assert.DeepEqual(t, "unknown global blob count", count, int64(0))
+	s.ExpectBlobsExistInStorage(t, blob)
+	s.ExpectBlobsMissingInStorage(t, strayBlobRecord)
+}
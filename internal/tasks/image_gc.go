@@ -54,6 +54,13 @@ var imageGCRepoDoneQuery = sqlext.SimplifyWhitespace(`
 	UPDATE repos SET next_gc_at = $2 WHERE id = $1
 `)
 
+var imageGCPullCountsQuery = sqlext.SimplifyWhitespace(`
+	SELECT digest, SUM(count) FROM manifest_pull_counts WHERE repo_id = $1 AND day >= $2 GROUP BY digest
+`)
+
+// How long records of GC runs are kept in the `gc_runs` table.
+const gcRunRetention = 30 * 24 * time.Hour
+
 // ManifestGarbageCollectionJob is a job. Each task finds the a where GC has
 // not been performed for more than an hour, and performs GC based on the GC
 // policies configured on the repo's account.
@@ -70,7 +77,7 @@ func (j *Janitor) ManifestGarbageCollectionJob(registerer prometheus.Registerer)
 			err = j.db.SelectOne(&repo, imageGCRepoSelectQuery, j.timeNow())
 			return repo, err
 		},
-		ProcessTask: j.garbageCollectManifestsInRepo,
+		ProcessTask: trackTask(j, "manifest_gc", j.garbageCollectManifestsInRepo),
 	}).Setup(registerer)
 }
 
@@ -80,6 +87,29 @@ func (j *Janitor) garbageCollectManifestsInRepo(ctx context.Context, repo models
 	if err != nil {
 		return fmt.Errorf("cannot find account for repo %s: %w", repo.FullName(), err)
 	}
+
+	// record the outcome of this run for the account owner to inspect (unless
+	// there was nothing to do)
+	run := models.GCRun{
+		AccountName:    account.Name,
+		RepositoryName: repo.Name,
+		StartedAt:      j.timeNow(),
+	}
+	var deletedManifests []keppel.GCDeletedManifest
+	defer func() {
+		if run.PoliciesEvaluated == 0 && returnErr == nil {
+			return
+		}
+		err := j.recordGCRun(run, deletedManifests, returnErr)
+		if err != nil {
+			if returnErr == nil {
+				returnErr = err
+			} else {
+				logg.Error("additional error encountered while recording GC run for repo %s: %s", repo.FullName(), err.Error())
+			}
+		}
+	}()
+
 	policies, err := keppel.ParseGCPolicies(*account)
 	if err != nil {
 		return fmt.Errorf("cannot load GC policies for account %s: %w", account.Name, err)
@@ -97,7 +127,18 @@ func (j *Janitor) garbageCollectManifestsInRepo(ctx context.Context, repo models
 
 	// execute GC policies
 	if len(policiesForRepo) > 0 {
-		err = j.executeGCPolicies(ctx, account.Reduced(), repo, policiesForRepo)
+		run.PoliciesEvaluated = uint64(len(policiesForRepo))
+		manifests, err := j.executeGCPolicies(ctx, account.Reduced(), repo, policiesForRepo)
+		for _, m := range manifests {
+			if m.IsDeleted {
+				deletedManifests = append(deletedManifests, keppel.GCDeletedManifest{
+					Digest:    m.Manifest.Digest,
+					TagNames:  m.TagNames,
+					SizeBytes: m.Manifest.SizeBytes,
+					Policy:    *m.DeletedByPolicy,
+				})
+			}
+		}
 		if err != nil {
 			return err
 		}
@@ -121,14 +162,16 @@ type manifestData struct {
 	ParentDigests []string
 	GCStatus      keppel.GCStatus
 	IsDeleted     bool
+	// only set if IsDeleted is true
+	DeletedByPolicy *keppel.GCPolicy
 }
 
-func (j *Janitor) executeGCPolicies(ctx context.Context, account models.ReducedAccount, repo models.Repository, policies []keppel.GCPolicy) error {
+func (j *Janitor) executeGCPolicies(ctx context.Context, account models.ReducedAccount, repo models.Repository, policies []keppel.GCPolicy) ([]*manifestData, error) {
 	// load manifests in repo
 	var dbManifests []models.Manifest
 	_, err := j.db.Select(&dbManifests, `SELECT * FROM manifests WHERE repo_id = $1`, repo.ID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// setup a bit of structure to track state in during the policy evaluation
@@ -138,13 +181,14 @@ func (j *Janitor) executeGCPolicies(ctx context.Context, account models.ReducedA
 			Manifest: m,
 			GCStatus: keppel.GCStatus{
 				ProtectedByRecentUpload: m.PushedAt.After(j.timeNow().Add(-10 * time.Minute)),
+				ProtectedByQuarantine:   m.QuarantineStatus == models.QuarantinePending,
 			},
 			IsDeleted: false,
 		})
 	}
 
 	// load tags (for matching policies on match_tag, except_tag and only_untagged)
-	query := `SELECT digest, name FROM tags WHERE repo_id = $1`
+	query := `SELECT digest, name FROM tags WHERE repo_id = $1 ORDER BY name`
 	err = sqlext.ForeachRow(j.db, query, []any{repo.ID}, func(rows *sql.Rows) error {
 		var (
 			digest  digest.Digest
@@ -163,7 +207,7 @@ func (j *Janitor) executeGCPolicies(ctx context.Context, account models.ReducedA
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// check manifest-manifest relations to fill GCStatus.ProtectedByManifest
@@ -186,7 +230,7 @@ func (j *Janitor) executeGCPolicies(ctx context.Context, account models.ReducedA
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for _, m := range manifests {
 		if len(m.ParentDigests) > 0 {
@@ -195,25 +239,62 @@ func (j *Janitor) executeGCPolicies(ctx context.Context, account models.ReducedA
 		}
 	}
 
+	// variants of other manifests (e.g. squashed images) live exactly as long as their source
+	query = `SELECT source_digest, variant_digest FROM manifest_variants WHERE repo_id = $1 AND variant_digest IS NOT NULL`
+	err = sqlext.ForeachRow(j.db, query, []any{repo.ID}, func(rows *sql.Rows) error {
+		var (
+			sourceDigest  string
+			variantDigest digest.Digest
+		)
+		err := rows.Scan(&sourceDigest, &variantDigest)
+		if err != nil {
+			return err
+		}
+		for _, m := range manifests {
+			if m.Manifest.Digest == variantDigest {
+				m.GCStatus.ProtectedAsVariantOf = sourceDigest
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	// evaluate policies in order
 	proc := j.processor()
 	for _, policy := range policies {
 		err := j.evaluatePolicy(ctx, proc, manifests, account, repo, policy)
 		if err != nil {
-			return err
+			return manifests, err
 		}
 	}
 
-	return j.persistGCStatus(manifests, repo.ID)
+	return manifests, j.persistGCStatus(manifests, repo.ID)
 }
 
 func (j *Janitor) evaluatePolicy(ctx context.Context, proc *processor.Processor, manifests []*manifestData, account models.ReducedAccount, repo models.Repository, policy keppel.GCPolicy) error {
 	// for some time constraint matches, we need to know which manifests are
 	// still alive
 	var aliveManifests []models.Manifest
+	aliveDigests := make(map[digest.Digest]bool)
 	for _, m := range manifests {
 		if !m.IsDeleted {
 			aliveManifests = append(aliveManifests, m.Manifest)
+			aliveDigests[m.Manifest.Digest] = true
+		}
+	}
+	isAlive := func(d digest.Digest) bool { return aliveDigests[d] }
+
+	// for pull count constraints, we need the pull counts within the
+	// constraint's time window
+	var pullCounts map[digest.Digest]uint64
+	if policy.PullCountConstraint != nil {
+		var err error
+		pullCounts, err = j.getPullCounts(repo, policy.PullCountConstraint.Within)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -225,6 +306,12 @@ func (j *Janitor) evaluatePolicy(ctx context.Context, proc *processor.Processor,
 			continue
 		}
 
+		// since the artifact type of a manifest cannot change, policies for other
+		// artifact types will never apply and are thus not even relevant
+		if !policy.MatchesArtifactType(m.Manifest) {
+			continue
+		}
+
 		// track matching "delete" policies in GCStatus to allow users insight
 		// into how policies match
 		if policy.Action == "delete" {
@@ -235,9 +322,15 @@ func (j *Janitor) evaluatePolicy(ctx context.Context, proc *processor.Processor,
 		if !policy.MatchesTags(m.TagNames) {
 			continue
 		}
+		if !policy.MatchesSubject(m.Manifest, isAlive) {
+			continue
+		}
 		if !policy.MatchesTimeConstraint(m.Manifest, aliveManifests, j.timeNow()) {
 			continue
 		}
+		if !policy.MatchesPullCountConstraint(pullCounts[m.Manifest.Digest]) {
+			continue
+		}
 
 		pCopied := policy
 		// execute policy action
@@ -256,6 +349,7 @@ func (j *Janitor) evaluatePolicy(ctx context.Context, proc *processor.Processor,
 				return err
 			}
 			m.IsDeleted = true
+			m.DeletedByPolicy = &pCopied
 			policyJSON, _ := json.Marshal(policy)
 			logg.Info("GC on repo %s: deleted manifest %s because of policy %s", repo.FullName(), m.Manifest.Digest, string(policyJSON))
 		default:
@@ -267,6 +361,24 @@ func (j *Janitor) evaluatePolicy(ctx context.Context, proc *processor.Processor,
 	return nil
 }
 
+func (j *Janitor) getPullCounts(repo models.Repository, window keppel.Duration) (map[digest.Digest]uint64, error) {
+	result := make(map[digest.Digest]uint64)
+	since := keppel.PullCountDay(j.timeNow().Add(-time.Duration(window)))
+	err := sqlext.ForeachRow(j.db, imageGCPullCountsQuery, []any{repo.ID, since}, func(rows *sql.Rows) error {
+		var (
+			manifestDigest digest.Digest
+			count          uint64
+		)
+		err := rows.Scan(&manifestDigest, &count)
+		result[manifestDigest] = count
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot load pull counts for repo %s: %w", repo.FullName(), err)
+	}
+	return result, nil
+}
+
 func (j *Janitor) persistGCStatus(manifests []*manifestData, repoID int64) error {
 	// finalize and persist GCStatus for all affected manifests
 	query := `UPDATE manifests SET gc_status_json = $1 WHERE repo_id = $2 AND digest = $3`
@@ -296,3 +408,50 @@ func (j *Janitor) persistGCStatus(manifests []*manifestData, repoID int64) error
 	}
 	return nil
 }
+
+func (j *Janitor) recordGCRun(run models.GCRun, deletedManifests []keppel.GCDeletedManifest, runErr error) error {
+	run.FinishedAt = j.timeNow()
+	run.ManifestsDeleted = uint64(len(deletedManifests))
+	for _, m := range deletedManifests {
+		run.BytesFreed += m.SizeBytes
+	}
+	if deletedManifests == nil {
+		deletedManifests = []keppel.GCDeletedManifest{}
+	}
+	buf, err := json.Marshal(deletedManifests)
+	if err != nil {
+		return err
+	}
+	run.DeletedManifestsJSON = string(buf)
+	if runErr != nil {
+		run.ErrorMessage = runErr.Error()
+	}
+	return j.db.Insert(&run)
+}
+
+// GCRunCleanupJob is a job that deletes records of GC runs once they are older
+// than gcRunRetention, as well as pull counts once they are older than
+// keppel.ManifestPullCountRetention.
+func (j *Janitor) GCRunCleanupJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "cleanup of expired GC run records",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_gc_run_cleanups",
+				Help: "Counter for cleanup operations for expired records of GC runs.",
+			},
+		},
+		Interval:     1 * time.Hour,
+		InitialDelay: 1 * time.Minute,
+		Task:         j.trackCronTask("gc_run_cleanup", j.deleteExpiredGCRuns),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) deleteExpiredGCRuns(_ context.Context, _ prometheus.Labels) error {
+	_, err := j.db.Exec(`DELETE FROM gc_runs WHERE finished_at < $1`, j.timeNow().Add(-gcRunRetention))
+	if err != nil {
+		return err
+	}
+	_, err = j.db.Exec(`DELETE FROM manifest_pull_counts WHERE day < $1`, keppel.PullCountDay(j.timeNow().Add(-keppel.ManifestPullCountRetention)))
+	return err
+}
@@ -21,10 +21,13 @@ package tasks
 import (
 	"database/sql"
 	"fmt"
+	"net/http"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/sapcc/go-api-declarations/cadf"
+	"github.com/sapcc/go-bits/assert"
 	"github.com/sapcc/go-bits/easypg"
 
 	"github.com/sapcc/keppel/internal/test"
@@ -102,6 +105,7 @@ func TestGCUntaggedImages(t *testing.T) {
 	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
 	expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
 	tr.DBChanges().AssertEqualf(`
+			INSERT INTO gc_runs (id, account_name, repo_name, started_at, finished_at, policies_evaluated, manifests_deleted, bytes_freed) VALUES (2, 'test1', 'foo', %[5]d, %[5]d, 1, 0, 0);
 			UPDATE manifests SET gc_status_json = '{"protected_by_parent":"%[1]s"}' WHERE repo_id = 1 AND digest = '%[2]s';
 			UPDATE manifests SET gc_status_json = '{"protected_by_recent_upload":true}' WHERE repo_id = 1 AND digest = '%[1]s';
 			UPDATE manifests SET gc_status_json = '{"protected_by_parent":"%[1]s"}' WHERE repo_id = 1 AND digest = '%[3]s';
@@ -111,6 +115,7 @@ func TestGCUntaggedImages(t *testing.T) {
 		images[0].Manifest.Digest,
 		images[1].Manifest.Digest,
 		s.Clock.Now().Add(1*time.Hour).Unix(),
+		s.Clock.Now().Unix(),
 	)
 
 	// delete the image list manifest
@@ -126,6 +131,7 @@ func TestGCUntaggedImages(t *testing.T) {
 	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
 	expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
 	tr.DBChanges().AssertEqualf(`
+			INSERT INTO gc_runs (id, account_name, repo_name, started_at, finished_at, policies_evaluated, manifests_deleted, bytes_freed, deleted_manifests_json) VALUES (3, 'test1', 'foo', %[5]d, %[5]d, 1, 1, %[6]d, '[{"digest":"%[2]s","size_bytes":%[6]d,"policy":%[7]s}]');
 			DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[2]s' AND blob_id = 3;
 			DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[2]s' AND blob_id = 4;
 			DELETE FROM manifest_contents WHERE repo_id = 1 AND digest = '%[2]s';
@@ -138,6 +144,9 @@ func TestGCUntaggedImages(t *testing.T) {
 		images[1].Manifest.Digest,
 		matchingGCPoliciesJSON,
 		s.Clock.Now().Add(1*time.Hour).Unix(),
+		s.Clock.Now().Unix(),
+		images[1].SizeBytes(),
+		matchingGCPolicyJSON,
 	)
 
 	// there should be an audit event for when GC deletes an image
@@ -214,6 +223,7 @@ func TestGCMatchOnTag(t *testing.T) {
 	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
 	expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
 	tr.DBChanges().AssertEqualf(`
+			INSERT INTO gc_runs (id, account_name, repo_name, started_at, finished_at, policies_evaluated, manifests_deleted, bytes_freed, deleted_manifests_json) VALUES (1, 'test1', 'foo', %[9]d, %[9]d, 4, 1, %[10]d, '[{"digest":"%[1]s","tags":["zeroone","zerothree","zerotwo","zerozero"],"size_bytes":%[10]d,"policy":%[11]s}]');
 			DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[1]s' AND blob_id = 1;
 			DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[1]s' AND blob_id = 2;
 			DELETE FROM manifest_contents WHERE repo_id = 1 AND digest = '%[1]s';
@@ -236,6 +246,9 @@ func TestGCMatchOnTag(t *testing.T) {
 		protectingGCPolicyJSON2,
 		protectingGCPolicyJSON3,
 		s.Clock.Now().Add(1*time.Hour).Unix(),
+		s.Clock.Now().Unix(),
+		images[0].SizeBytes(),
+		deletingGCPolicyJSON,
 	)
 }
 
@@ -307,6 +320,7 @@ func TestGCProtectOldestAndNewest(t *testing.T) {
 		expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
 		expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
 		tr.DBChanges().AssertEqualf(`
+			INSERT INTO gc_runs (id, account_name, repo_name, started_at, finished_at, policies_evaluated, manifests_deleted, bytes_freed, deleted_manifests_json) VALUES (1, 'test1', 'foo', %[10]d, %[10]d, 3, 1, %[11]d, '[{"digest":"%[4]s","size_bytes":%[11]d,"policy":%[12]s}]');
 			DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[4]s' AND blob_id = 7;
 			DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[4]s' AND blob_id = 8;
 			DELETE FROM manifest_contents WHERE repo_id = 1 AND digest = '%[4]s';
@@ -328,6 +342,9 @@ func TestGCProtectOldestAndNewest(t *testing.T) {
 			protectingGCPolicyJSON1,
 			protectingGCPolicyJSON2,
 			s.Clock.Now().Add(1*time.Hour).Unix(),
+			s.Clock.Now().Unix(),
+			images[3].SizeBytes(),
+			deletingGCPolicyJSON,
 		)
 	}
 }
@@ -368,6 +385,7 @@ func TestGCProtectComesTooLate(t *testing.T) {
 	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
 	expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
 	tr.DBChanges().AssertEqualf(`
+			INSERT INTO gc_runs (id, account_name, repo_name, started_at, finished_at, policies_evaluated, manifests_deleted, bytes_freed, deleted_manifests_json) VALUES (1, 'test1', 'foo', %[5]d, %[5]d, 3, 1, %[6]d, '[{"digest":"%[2]s","tags":["latest"],"size_bytes":%[6]d,"policy":%[7]s}]');
 			DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[2]s' AND blob_id = 3;
 			DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[2]s' AND blob_id = 4;
 			DELETE FROM manifest_contents WHERE repo_id = 1 AND digest = '%[2]s';
@@ -381,5 +399,121 @@ func TestGCProtectComesTooLate(t *testing.T) {
 		images[1].Manifest.Digest,
 		protectingGCPolicyJSON1,
 		s.Clock.Now().Add(1*time.Hour).Unix(),
+		s.Clock.Now().Unix(),
+		images[1].SizeBytes(),
+		deletingGCPolicyJSON,
+	)
+}
+
+func TestGCMatchOnArtifactTypeAndSubject(t *testing.T) {
+	j, s := setup(t)
+
+	// upload an image with an SBOM and a signature, and another signature whose subject does not exist
+	image := test.GenerateImage(test.GenerateExampleLayer(0))
+	image.MustUpload(t, s, fooRepoRef, "latest")
+	sbom := test.GenerateReferrerArtifact(image.Manifest, "application/vnd.example.sbom.v1+json", test.NewBytes([]byte("sbom")))
+	sbom.MustUpload(t, s, fooRepoRef, "")
+	signature := test.GenerateReferrerArtifact(image.Manifest, "application/vnd.example.signature.v1+json", test.NewBytes([]byte("signature")))
+	signature.MustUpload(t, s, fooRepoRef, "")
+	missingImage := test.GenerateImage(test.GenerateExampleLayer(1))
+	orphanedSignature := test.GenerateReferrerArtifact(missingImage.Manifest, "application/vnd.example.signature.v1+json", test.NewBytes([]byte("orphaned signature")))
+	orphanedSignature.MustUpload(t, s, fooRepoRef, "")
+
+	// skip an hour to avoid protected_by_recent_upload
+	s.Clock.StepBy(1 * time.Hour)
+
+	expectRemainingManifests := func(expected ...test.Image) {
+		t.Helper()
+		var actual []string
+		_, err := s.DB.Select(&actual, `SELECT digest FROM manifests ORDER BY digest`)
+		mustDo(t, err)
+		var expectedDigests []string
+		for _, image := range expected {
+			expectedDigests = append(expectedDigests, image.Manifest.Digest.String())
+		}
+		slices.Sort(expectedDigests)
+		assert.DeepEqual(t, "remaining manifests", actual, expectedDigests)
+	}
+
+	// a policy for orphaned signatures only deletes the signature whose subject does not exist
+	signatureGCPolicyJSON := `{"match_repository":".*","match_artifact_type":".*signature.*","only_orphaned_referrers":true,"action":"delete"}`
+	mustExec(t, s.DB,
+		`UPDATE accounts SET gc_policies_json = $1`,
+		fmt.Sprintf("[%s]", signatureGCPolicyJSON),
+	)
+	garbageJob := j.ManifestGarbageCollectionJob(s.Registry)
+	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
+	expectRemainingManifests(image, sbom, signature)
+
+	// when the image gets deleted by an earlier policy, its signature is
+	// orphaned within the same GC run, but the SBOM is not affected since it has
+	// a different artifact type
+	imageGCPolicyJSON := `{"match_repository":".*","match_tag":"latest","action":"delete"}`
+	mustExec(t, s.DB,
+		`UPDATE accounts SET gc_policies_json = $1`,
+		fmt.Sprintf("[%s,%s]", imageGCPolicyJSON, signatureGCPolicyJSON),
 	)
+	s.Clock.StepBy(2 * time.Hour)
+	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
+	expectRemainingManifests(sbom)
+}
+
+func TestGCPullCountConstraint(t *testing.T) {
+	j, s := setup(t, test.WithManifestPullCounts)
+
+	// upload two images, one of which will be pulled regularly and one which
+	// will only be pulled once (e.g. by a scanner)
+	popularImage := test.GenerateImage(test.GenerateExampleLayer(0))
+	popularImage.MustUpload(t, s, fooRepoRef, "popular")
+	unpopularImage := test.GenerateImage(test.GenerateExampleLayer(1))
+	unpopularImage.MustUpload(t, s, fooRepoRef, "unpopular")
+
+	token := s.GetToken(t, "repository:test1/foo:pull")
+	pull := func(image test.Image) {
+		t.Helper()
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         fmt.Sprintf("/v2/test1/foo/manifests/%s", image.Manifest.Digest),
+			Header:       map[string]string{"Authorization": "Bearer " + token},
+			ExpectStatus: http.StatusOK,
+		}.Check(t, s.Handler)
+	}
+	for range 3 {
+		pull(popularImage)
+	}
+	pull(unpopularImage)
+
+	// skip an hour to avoid protected_by_recent_upload
+	s.Clock.StepBy(1 * time.Hour)
+
+	expectRemainingManifests := func(expected ...test.Image) {
+		t.Helper()
+		var actual []string
+		_, err := s.DB.Select(&actual, `SELECT digest FROM manifests ORDER BY digest`)
+		mustDo(t, err)
+		var expectedDigests []string
+		for _, image := range expected {
+			expectedDigests = append(expectedDigests, image.Manifest.Digest.String())
+		}
+		slices.Sort(expectedDigests)
+		assert.DeepEqual(t, "remaining manifests", actual, expectedDigests)
+	}
+
+	// the image that was only pulled once gets deleted even though its
+	// last_pulled_at is recent
+	mustExec(t, s.DB,
+		`UPDATE accounts SET gc_policies_json = $1`,
+		`[{"match_repository":".*","pull_count_constraint":{"fewer_than":2,"within":{"value":7,"unit":"d"}},"action":"delete"}]`,
+	)
+	garbageJob := j.ManifestGarbageCollectionJob(s.Registry)
+	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), garbageJob.ProcessOne(s.Ctx))
+	expectRemainingManifests(popularImage)
+
+	// once the pulls fall out of the time window, the other image gets deleted as well
+	s.Clock.StepBy(8 * 24 * time.Hour)
+	expectSuccess(t, garbageJob.ProcessOne(s.Ctx))
+	expectRemainingManifests()
 }
@@ -19,17 +19,24 @@
 package tasks
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/sapcc/go-api-declarations/cadf"
 	"github.com/sapcc/go-bits/audittools"
+	"github.com/sapcc/go-bits/jobloop"
 
 	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
 	"github.com/sapcc/keppel/internal/processor"
 )
 
@@ -52,10 +59,16 @@ type Janitor struct {
 	fd      keppel.FederationDriver
 	sd      keppel.StorageDriver
 	icd     keppel.InboundCacheDriver
+	cdn     keppel.CDNDriver // may be nil
 	db      *keppel.DB
 	amd     keppel.AccountManagementDriver
 	auditor audittools.Auditor
 
+	// status of all jobs that were set up on this Janitor
+	jobStatus *jobStatusTracker
+	// which account ManifestValidationJob has taken its last task from
+	manifestValidationRoundRobin *accountRoundRobin
+
 	// non-pure functions that can be replaced by deterministic doubles for unit tests
 	timeNow           func() time.Time
 	generateStorageID func() string
@@ -63,11 +76,21 @@ type Janitor struct {
 }
 
 // NewJanitor creates a new Janitor.
-func NewJanitor(cfg keppel.Configuration, fd keppel.FederationDriver, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, db *keppel.DB, amd keppel.AccountManagementDriver, auditor audittools.Auditor) *Janitor {
-	j := &Janitor{cfg, fd, sd, icd, db, amd, auditor, time.Now, keppel.GenerateStorageID, addJitter}
+func NewJanitor(cfg keppel.Configuration, fd keppel.FederationDriver, sd keppel.StorageDriver, icd keppel.InboundCacheDriver, cdn keppel.CDNDriver, db *keppel.DB, amd keppel.AccountManagementDriver, auditor audittools.Auditor) *Janitor {
+	j := &Janitor{cfg, fd, sd, icd, cdn, db, amd, auditor, newJobStatusTracker(), &accountRoundRobin{}, time.Now, keppel.GenerateStorageID, addJitter}
 	return j
 }
 
+// NumGoroutines returns the jobloop.NumGoroutines option for the given job,
+// as configured in KEPPEL_JANITOR_CONCURRENCY, or the given default value.
+func (j *Janitor) NumGoroutines(jobName string, defaultValue uint32) jobloop.Option {
+	numGoroutines, ok := j.cfg.JanitorConcurrency[jobName]
+	if !ok {
+		numGoroutines = defaultValue
+	}
+	return jobloop.NumGoroutines(numGoroutines)
+}
+
 // OverrideTimeNow replaces time.Now with a test double.
 func (j *Janitor) OverrideTimeNow(timeNow func() time.Time) *Janitor {
 	j.timeNow = timeNow
@@ -99,6 +122,44 @@ func (j *Janitor) processor() *processor.Processor {
 	return processor.New(j.cfg, j.db, j.sd, j.icd, j.auditor, j.fd, j.timeNow).OverrideTimeNow(j.timeNow).OverrideGenerateStorageID(j.generateStorageID)
 }
 
+////////////////////////////////////////////////////////////////////////////////
+// accountRoundRobin
+
+// accountRoundRobin remembers which account a job has taken its last task
+// from, so that the next task can be taken from the next account in
+// alphabetical order.
+type accountRoundRobin struct {
+	mutex           sync.Mutex
+	lastAccountName models.AccountName
+}
+
+// Next returns the next account that has tasks for the job. The given query
+// must select the name of the first account in alphabetical order after $2
+// that has tasks which are due at $1. If there is no such account,
+// sql.ErrNoRows is returned.
+func (rr *accountRoundRobin) Next(db *keppel.DB, query string, now time.Time) (models.AccountName, error) {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	accountName, err := db.SelectStr(query, now, rr.lastAccountName)
+	if err != nil {
+		return "", err
+	}
+	if accountName == "" && rr.lastAccountName != "" {
+		// we are at the end of the alphabet -> start over from the beginning
+		accountName, err = db.SelectStr(query, now, "")
+		if err != nil {
+			return "", err
+		}
+	}
+	if accountName == "" {
+		return "", sql.ErrNoRows
+	}
+
+	rr.lastAccountName = models.AccountName(accountName)
+	return rr.lastAccountName, nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // janitorUserIdentity
 
@@ -176,3 +237,25 @@ func (u janitorUserInfo) AsInitiator(_ cadf.Host) cadf.Resource {
 	}
 	return res
 }
+
+////////////////////////////////////////////////////////////////////////////////
+// webhooks
+
+// Sends a JSON payload to a webhook configured by the operator.
+func postJSONToWebhook(ctx context.Context, webhookURL string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s returned unexpected status %s", webhookURL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/go-gorp/gorp/v3"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+)
+
+// jobStatus is the status of a single job, as observed by this janitor process.
+type jobStatus struct {
+	LastRunAt *time.Time
+	LastError string
+}
+
+// jobStatusTracker records when each job last processed a task, and whether
+// that succeeded. Since this is recorded in memory, it is only persisted into
+// the DB by JobStatusReportJob.
+type jobStatusTracker struct {
+	mutex    sync.Mutex
+	statuses map[string]jobStatus
+}
+
+func newJobStatusTracker() *jobStatusTracker {
+	return &jobStatusTracker{statuses: make(map[string]jobStatus)}
+}
+
+// Register marks a job as running in this process. Only registered jobs are
+// reported by JobStatusReportJob.
+func (t *jobStatusTracker) Register(jobName string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, exists := t.statuses[jobName]; !exists {
+		t.statuses[jobName] = jobStatus{}
+	}
+}
+
+// RecordRun records the result of a single task of the given job.
+func (t *jobStatusTracker) RecordRun(jobName string, now time.Time, err error) {
+	status := jobStatus{LastRunAt: &now}
+	lastRunFailed := 0.0
+	if err != nil {
+		status.LastError = err.Error()
+		lastRunFailed = 1.0
+	}
+
+	t.mutex.Lock()
+	t.statuses[jobName] = status
+	t.mutex.Unlock()
+
+	JanitorJobLastRunGauge.WithLabelValues(jobName).Set(float64(now.Unix()))
+	JanitorJobLastRunFailedGauge.WithLabelValues(jobName).Set(lastRunFailed)
+}
+
+// Snapshot returns a copy of the current status of all registered jobs.
+func (t *jobStatusTracker) Snapshot() map[string]jobStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	result := make(map[string]jobStatus, len(t.statuses))
+	for jobName, status := range t.statuses {
+		result[jobName] = status
+	}
+	return result
+}
+
+// trackTask wraps the ProcessTask callback of a jobloop.ProducerConsumerJob
+// such that each task is recorded in the Janitor's jobStatusTracker.
+func trackTask[T any](j *Janitor, jobName string, process func(context.Context, T, prometheus.Labels) error) func(context.Context, T, prometheus.Labels) error {
+	j.jobStatus.Register(jobName)
+	return func(ctx context.Context, task T, labels prometheus.Labels) error {
+		err := process(ctx, task, labels)
+		j.jobStatus.RecordRun(jobName, j.timeNow(), err)
+		return err
+	}
+}
+
+// trackTxTask is like trackTask, but for the ProcessRow callback of a jobloop.TxGuardedJob.
+func trackTxTask[T any](j *Janitor, jobName string, process func(context.Context, *gorp.Transaction, T, prometheus.Labels) error) func(context.Context, *gorp.Transaction, T, prometheus.Labels) error {
+	j.jobStatus.Register(jobName)
+	return func(ctx context.Context, tx *gorp.Transaction, row T, labels prometheus.Labels) error {
+		err := process(ctx, tx, row, labels)
+		j.jobStatus.RecordRun(jobName, j.timeNow(), err)
+		return err
+	}
+}
+
+// trackCronTask is like trackTask, but for the Task callback of a jobloop.CronJob.
+func (j *Janitor) trackCronTask(jobName string, task func(context.Context, prometheus.Labels) error) func(context.Context, prometheus.Labels) error {
+	j.jobStatus.Register(jobName)
+	return func(ctx context.Context, labels prometheus.Labels) error {
+		err := task(ctx, labels)
+		j.jobStatus.RecordRun(jobName, j.timeNow(), err)
+		return err
+	}
+}
+
+// backlogQuery counts how many tasks of a certain job are currently due.
+// The current time is always given as $1, followed by Args.
+//
+// If AgeQuery is given, it selects the time at which the oldest of these tasks
+// became due (or NULL if there are none), and thus how far the job has fallen behind.
+type backlogQuery struct {
+	Query    string
+	AgeQuery string
+	Args     []any
+}
+
+// Jobs that run on a fixed schedule (i.e. jobloop.CronJob) do not have a backlog and are therefore not listed here.
+var backlogQueries = map[string]backlogQuery{
+	"account_deletion": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM accounts WHERE is_deleting AND next_deletion_attempt_at < $1
+	`)},
+	"account_federation_announcement": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM accounts WHERE next_federation_announcement_at IS NULL OR next_federation_announcement_at < $1
+	`)},
+	"account_config_sync": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM accounts
+		 WHERE config_sync_enabled AND upstream_peer_hostname != '' AND NOT is_deleting
+		   AND (next_config_sync_at IS NULL OR next_config_sync_at < $1)
+	`)},
+	"upstream_health_check": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM accounts
+		 WHERE failover_upstream != '' AND NOT is_deleting
+		   AND (next_upstream_check_at IS NULL OR next_upstream_check_at < $1)
+	`)},
+	"managed_account_enforcement": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM accounts WHERE is_managed AND next_enforcement_at < $1
+	`)},
+	"abandoned_upload_cleanup": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM uploads WHERE updated_at < $1::TIMESTAMPTZ - INTERVAL '1 day'
+	`)},
+	"orphaned_referrer_cleanup": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM manifests m
+		  JOIN repos r ON r.id = m.repo_id
+		  JOIN accounts a ON a.name = r.account_name
+		 WHERE m.subject_digest != '' AND m.pushed_at < $1::TIMESTAMPTZ - INTERVAL '1 day'
+		   AND a.upstream_peer_hostname = '' AND a.external_peer_url = '' AND NOT a.is_deleting
+		   AND NOT EXISTS (SELECT 1 FROM manifests s WHERE s.repo_id = m.repo_id AND s.digest = m.subject_digest)
+		   AND NOT EXISTS (SELECT 1 FROM tags t WHERE t.repo_id = m.repo_id AND t.digest = m.digest)
+		   AND NOT EXISTS (SELECT 1 FROM manifest_manifest_refs mmr WHERE mmr.repo_id = m.repo_id AND mmr.child_digest = m.digest)
+	`)},
+	"blob_mount_sweep": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM repos WHERE next_blob_mount_sweep_at IS NULL OR next_blob_mount_sweep_at < $1
+	`)},
+	"blob_sweep": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM accounts WHERE next_blob_sweep_at IS NULL OR next_blob_sweep_at < $1
+	`)},
+	"global_blob_deduplication": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM blobs WHERE storage_id != '' AND NOT is_global AND pushed_at < $1
+	`)},
+	"blob_validation": {
+		Query: sqlext.SimplifyWhitespace(`
+			SELECT COUNT(*) FROM blobs WHERE storage_id != '' AND next_validation_at < $1
+		`),
+		AgeQuery: sqlext.SimplifyWhitespace(`
+			SELECT MIN(next_validation_at) FROM blobs WHERE storage_id != '' AND next_validation_at < $1
+		`),
+	},
+	"blob_prefetch": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM blobs b
+		  JOIN accounts a ON a.name = b.account_name
+		 WHERE b.storage_id = '' AND b.next_prefetch_at < $1
+		   AND a.prefetch_blobs AND NOT a.is_deleting
+	`)},
+	"storage_sweep": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM accounts WHERE next_storage_sweep_at IS NULL OR next_storage_sweep_at < $1
+	`)},
+	"manifest_gc": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM repos WHERE next_gc_at IS NULL OR next_gc_at < $1
+	`)},
+	"manifest_sync": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM repos r
+		  JOIN accounts a ON r.account_name = a.name
+		 WHERE (r.next_manifest_sync_at IS NULL OR r.next_manifest_sync_at < $1)
+		   AND (a.upstream_peer_hostname != '' OR a.external_peer_url != '')
+	`)},
+	"manifest_validation": {
+		Query: sqlext.SimplifyWhitespace(`
+			SELECT COUNT(*) FROM manifests WHERE next_validation_at < $1
+		`),
+		AgeQuery: sqlext.SimplifyWhitespace(`
+			SELECT MIN(next_validation_at) FROM manifests WHERE next_validation_at < $1
+		`),
+	},
+	"manifest_platform_check": {
+		Query: sqlext.SimplifyWhitespace(`
+			SELECT COUNT(*) FROM manifests
+			 WHERE media_type IN ($2, $3) AND (next_platform_check_at IS NULL OR next_platform_check_at < $1)
+		`),
+		Args: []any{manifestlist.MediaTypeManifestList, imagespec.MediaTypeImageIndex},
+	},
+	"manifest_variant_generation": {
+		Query: sqlext.SimplifyWhitespace(`
+			SELECT COUNT(*) FROM manifests m
+			  JOIN repos r ON r.id = m.repo_id
+			  JOIN accounts a ON a.name = r.account_name
+			 CROSS JOIN LATERAL unnest(string_to_array(a.image_transformations, ',')) AS t(transformation)
+			 WHERE a.image_transformations != '' AND NOT a.is_deleting
+			   AND m.media_type IN ($2, $3)
+			   AND EXISTS (SELECT 1 FROM tags WHERE repo_id = m.repo_id AND digest = m.digest)
+			   AND NOT EXISTS (SELECT 1 FROM manifest_variants WHERE repo_id = m.repo_id AND variant_digest = m.digest)
+			   AND NOT EXISTS (
+			     SELECT 1 FROM manifest_variants mv
+			      WHERE mv.repo_id = m.repo_id AND mv.source_digest = m.digest AND mv.transformation = t.transformation
+			        AND (mv.variant_digest IS NOT NULL OR mv.next_attempt_at > $1)
+			   )
+		`),
+		Args: []any{schema2.MediaTypeManifest, imagespec.MediaTypeImageManifest},
+	},
+	"signature_verification": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM manifests m
+		  JOIN repos r ON r.id = m.repo_id
+		  JOIN accounts a ON a.name = r.account_name
+		 WHERE a.require_signature_mode != '' AND (m.next_signature_check_at IS NULL OR m.next_signature_check_at < $1)
+	`)},
+	"attestation_verification": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM manifests m
+		  JOIN repos r ON r.id = m.repo_id
+		  JOIN accounts a ON a.name = r.account_name
+		 WHERE a.required_attestation_types != '' AND (m.next_attestation_check_at IS NULL OR m.next_attestation_check_at < $1)
+	`)},
+	"replica_consistency_check": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM repos r
+		  JOIN accounts a ON r.account_name = a.name
+		 WHERE (r.next_consistency_check_at IS NULL OR r.next_consistency_check_at < $1)
+		   AND a.upstream_peer_hostname != '' AND NOT a.is_deleting
+	`)},
+	"cold_start_replication": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM replication_queue q
+		  JOIN repos r ON r.id = q.repo_id
+		  JOIN accounts a ON a.name = r.account_name
+		  JOIN peers p ON p.hostname = a.upstream_peer_hostname
+		 WHERE (p.next_cold_start_replication_at IS NULL OR p.next_cold_start_replication_at <= $1)
+		   AND NOT a.is_deleting
+	`)},
+	"trivy_security_check": {Query: sqlext.SimplifyWhitespace(`
+		SELECT COUNT(*) FROM trivy_security_info WHERE next_check_at <= $1
+	`)},
+}
+
+var (
+	jobStatusUpsertQuery = sqlext.SimplifyWhitespace(`
+		INSERT INTO janitor_jobs (name, last_run_at, last_error) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET last_run_at = EXCLUDED.last_run_at, last_error = EXCLUDED.last_error
+		-- when multiple janitors are running, the most recent run wins
+		WHERE janitor_jobs.last_run_at IS NULL OR janitor_jobs.last_run_at < EXCLUDED.last_run_at
+	`)
+	jobBacklogUpdateQuery = sqlext.SimplifyWhitespace(`
+		UPDATE janitor_jobs SET backlog = $2, backlog_checked_at = $3 WHERE name = $1
+	`)
+)
+
+// JobStatusReportJob is a job that persists the status of all jobs running in
+// this process into the DB, where it can be read by the Keppel API. For each
+// job that works through a queue of due tasks, the size of that backlog is
+// also counted and reported as a metric. For some jobs, the age of the oldest
+// task in the backlog is reported as well.
+func (j *Janitor) JobStatusReportJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "report of janitor job status",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_janitor_job_status_reports",
+				Help: "Counter for reports of the status of all janitor jobs.",
+			},
+		},
+		Interval:     1 * time.Minute,
+		InitialDelay: 10 * time.Second,
+		Task: func(_ context.Context, _ prometheus.Labels) error {
+			return j.reportJobStatus()
+		},
+	}).Setup(registerer)
+}
+
+func (j *Janitor) reportJobStatus() error {
+	statuses := j.jobStatus.Snapshot()
+	jobNames := make([]string, 0, len(statuses))
+	for jobName := range statuses {
+		jobNames = append(jobNames, jobName)
+	}
+	sort.Strings(jobNames) // for deterministic behavior in unit tests
+
+	now := j.timeNow()
+	for _, jobName := range jobNames {
+		status := statuses[jobName]
+		_, err := j.db.Exec(jobStatusUpsertQuery, jobName, status.LastRunAt, status.LastError)
+		if err != nil {
+			return err
+		}
+
+		bq, exists := backlogQueries[jobName]
+		if !exists {
+			continue
+		}
+		backlog, err := j.db.SelectInt(bq.Query, append([]any{now}, bq.Args...)...)
+		if err != nil {
+			return fmt.Errorf("cannot count backlog for job %q: %w", jobName, err)
+		}
+		_, err = j.db.Exec(jobBacklogUpdateQuery, jobName, backlog, now)
+		if err != nil {
+			return err
+		}
+		JanitorJobBacklogGauge.WithLabelValues(jobName).Set(float64(backlog))
+
+		if bq.AgeQuery == "" {
+			continue
+		}
+		var oldestDueAt *time.Time
+		err = j.db.QueryRow(bq.AgeQuery, append([]any{now}, bq.Args...)...).Scan(&oldestDueAt)
+		if err != nil {
+			return fmt.Errorf("cannot measure backlog age for job %q: %w", jobName, err)
+		}
+		backlogAge := 0.0
+		if oldestDueAt != nil {
+			backlogAge = now.Sub(*oldestDueAt).Seconds()
+		}
+		JanitorJobBacklogAgeGauge.WithLabelValues(jobName).Set(backlogAge)
+	}
+	return nil
+}
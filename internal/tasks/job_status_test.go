@@ -0,0 +1,105 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+func TestJobStatusReportJob(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(1 * time.Hour)
+	blobSweepJob := j.BlobSweepJob(s.Registry)
+	gcRunCleanupJob := j.GCRunCleanupJob(s.Registry)
+	reportJob := j.JobStatusReportJob(s.Registry)
+
+	type jobInfo struct {
+		Name             string
+		LastRunAt        *int64
+		LastError        string
+		Backlog          uint64
+		BacklogCheckedAt *int64
+	}
+	expectJobs := func(expected ...jobInfo) {
+		t.Helper()
+		var rows []models.JanitorJob
+		_, err := s.DB.Select(&rows, `SELECT * FROM janitor_jobs ORDER BY name`)
+		mustDo(t, err)
+		actual := []jobInfo{}
+		for _, row := range rows {
+			actual = append(actual, jobInfo{
+				Name:             row.Name,
+				LastRunAt:        keppel.MaybeTimeToUnix(row.LastRunAt),
+				LastError:        row.LastError,
+				Backlog:          row.Backlog,
+				BacklogCheckedAt: keppel.MaybeTimeToUnix(row.BacklogCheckedAt),
+			})
+		}
+		assert.DeepEqual(t, "janitor jobs", actual, expected)
+	}
+
+	// before any job has run, all set up jobs are reported with their backlog
+	// (only the blob sweep has a backlog, since the GC run cleanup is a cron job)
+	expectSuccess(t, reportJob.ProcessOne(s.Ctx))
+	t1 := s.Clock.Now().Unix()
+	expectJobs(
+		jobInfo{Name: "blob_sweep", Backlog: 1, BacklogCheckedAt: &t1},
+		jobInfo{Name: "gc_run_cleanup"},
+	)
+
+	// after running the jobs, their last run is reported and the backlog is gone
+	s.Clock.StepBy(1 * time.Minute)
+	expectSuccess(t, blobSweepJob.ProcessOne(s.Ctx))
+	expectSuccess(t, gcRunCleanupJob.ProcessOne(s.Ctx))
+	s.Clock.StepBy(1 * time.Minute)
+	expectSuccess(t, reportJob.ProcessOne(s.Ctx))
+	t2 := s.Clock.Now().Add(-1 * time.Minute).Unix()
+	t3 := s.Clock.Now().Unix()
+	expectJobs(
+		jobInfo{Name: "blob_sweep", LastRunAt: &t2, Backlog: 0, BacklogCheckedAt: &t3},
+		jobInfo{Name: "gc_run_cleanup", LastRunAt: &t2},
+	)
+
+	// failed runs are reported with their error message
+	s.Clock.StepBy(1 * time.Minute)
+	j.jobStatus.RecordRun("gc_run_cleanup", s.Clock.Now(), errors.New("datacenter on fire"))
+	t4 := s.Clock.Now().Unix()
+	expectSuccess(t, reportJob.ProcessOne(s.Ctx))
+	expectJobs(
+		jobInfo{Name: "blob_sweep", LastRunAt: &t2, Backlog: 0, BacklogCheckedAt: &t4},
+		jobInfo{Name: "gc_run_cleanup", LastRunAt: &t4, LastError: "datacenter on fire"},
+	)
+
+	// a status report from a janitor that has not run the job in a while does
+	// not overwrite a more recent run reported by another janitor
+	j2 := NewJanitor(s.Config, s.FD, s.SD, s.ICD, s.CDN, s.DB, s.AMD, s.Auditor).OverrideTimeNow(s.Clock.Now)
+	j2.jobStatus.RecordRun("gc_run_cleanup", time.Unix(t2, 0), nil)
+	expectSuccess(t, j2.reportJobStatus())
+	expectJobs(
+		jobInfo{Name: "blob_sweep", LastRunAt: &t2, Backlog: 0, BacklogCheckedAt: &t4},
+		jobInfo{Name: "gc_run_cleanup", LastRunAt: &t4, LastError: "datacenter on fire"},
+	)
+}
@@ -0,0 +1,162 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// query that finds the next image index to be checked for missing platforms
+var platformCheckSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM manifests
+	 WHERE media_type IN ($2, $3) AND (next_platform_check_at IS NULL OR next_platform_check_at < $1)
+	-- manifests without any check first, then sorted by last check
+	ORDER BY next_platform_check_at IS NULL DESC, next_platform_check_at ASC
+	LIMIT 1 -- one at a time
+`)
+
+var platformCheckFinishQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET missing_platforms = $1, next_platform_check_at = $2
+	 WHERE repo_id = $3 AND digest = $4
+`)
+
+var platformCheckChildrenQuery = sqlext.SimplifyWhitespace(`
+	SELECT child_digest FROM manifest_manifest_refs WHERE repo_id = $1 AND parent_digest = $2
+`)
+
+// ManifestPlatformCheckJob is a job. Each task finds an image index that has
+// not been checked for more than 24 hours, and records which of the required
+// platforms are not covered by an existing child manifest. The required
+// platforms are taken from the account's platform filter or, if the account
+// does not have one, from KEPPEL_REQUIRED_PLATFORMS.
+//
+//nolint:dupl
+func (j *Janitor) ManifestPlatformCheckJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.Manifest]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "manifest platform completeness check",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_manifest_platform_checks",
+				Help: "Counter for platform completeness checks of image indexes.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (manifest models.Manifest, err error) {
+			err = j.db.SelectOne(&manifest, platformCheckSearchQuery,
+				j.timeNow(), manifestlist.MediaTypeManifestList, imagespec.MediaTypeImageIndex)
+			return manifest, err
+		},
+		ProcessTask: trackTask(j, "manifest_platform_check", j.checkManifestPlatforms),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) checkManifestPlatforms(_ context.Context, manifest models.Manifest, _ prometheus.Labels) error {
+	// find corresponding account and repo
+	var repo models.Repository
+	err := j.db.SelectOne(&repo, `SELECT * FROM repos WHERE id = $1`, manifest.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("cannot find repo %d for manifest %s: %w", manifest.RepositoryID, manifest.Digest, err)
+	}
+	account, err := keppel.FindReducedAccount(j.db, repo.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), manifest.Digest, err)
+	}
+
+	requiredPlatforms := account.PlatformFilter
+	if len(requiredPlatforms) == 0 {
+		requiredPlatforms = j.cfg.RequiredPlatforms
+	}
+	var missingPlatforms []string
+	if len(requiredPlatforms) > 0 {
+		missingPlatforms, err = j.findMissingPlatforms(repo, manifest, requiredPlatforms)
+		if err != nil {
+			return fmt.Errorf("while checking platforms of manifest %s/%s: %w", repo.FullName(), manifest.Digest, err)
+		}
+	}
+
+	_, err = j.db.Exec(platformCheckFinishQuery,
+		strings.Join(missingPlatforms, ","), j.timeNow().Add(j.addJitter(24*time.Hour)),
+		repo.ID, manifest.Digest,
+	)
+	return err
+}
+
+func (j *Janitor) findMissingPlatforms(repo models.Repository, manifest models.Manifest, requiredPlatforms models.PlatformFilter) ([]string, error) {
+	var manifestBytes []byte
+	err := j.db.SelectOne(&manifestBytes, `SELECT content FROM manifest_contents WHERE repo_id = $1 AND digest = $2`, repo.ID, manifest.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load manifest: %w", err)
+	}
+	parsed, _, err := keppel.ParseManifest(manifest.MediaType, manifestBytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse manifest: %w", err)
+	}
+
+	// in replica accounts, child manifests might be listed in the index, but not replicated yet
+	isExistingChild := make(map[digest.Digest]bool)
+	err = sqlext.ForeachRow(j.db, platformCheckChildrenQuery, []any{repo.ID, manifest.Digest}, func(rows *sql.Rows) error {
+		var childDigest digest.Digest
+		err := rows.Scan(&childDigest)
+		isExistingChild[childDigest] = true
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, required := range requiredPlatforms {
+		isCovered := false
+		for _, ref := range parsed.ManifestReferences(nil) {
+			if isExistingChild[ref.Digest] && platformSatisfies(ref.Platform, required) {
+				isCovered = true
+				break
+			}
+		}
+		if !isCovered {
+			result = append(result, models.FormatPlatform(required))
+		}
+	}
+	return result, nil
+}
+
+// Returns whether the platform of a child manifest satisfies the required
+// platform. Unlike models.PlatformFilter.Includes(), this only compares the
+// fields that are set in the required platform, so that e.g. a requirement of
+// "linux/arm64" is satisfied by a child manifest for "linux/arm64/v8".
+func platformSatisfies(actual, required manifestlist.PlatformSpec) bool {
+	return actual.OS == required.OS &&
+		actual.Architecture == required.Architecture &&
+		(required.Variant == "" || actual.Variant == required.Variant) &&
+		(required.OSVersion == "" || actual.OSVersion == required.OSVersion)
+}
@@ -0,0 +1,94 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/easypg"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestManifestPlatformCheckJob(t *testing.T) {
+	j, s := setup(t)
+	job := j.ManifestPlatformCheckJob(s.Registry)
+
+	// upload an image index for linux/amd64 and linux/arm (single-arch images are not checked)
+	images := []test.Image{
+		test.GenerateImage(test.GenerateExampleLayer(1)),
+		test.GenerateImage(test.GenerateExampleLayer(2)),
+	}
+	imageList := test.GenerateImageList(images...)
+	imageList.MustUpload(t, s, fooRepoRef, "latest")
+
+	tr, tr0 := easypg.NewTracker(t, s.DB.Db)
+	tr0.Ignore()
+
+	// without any required platforms, nothing is reported as missing
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE manifests SET next_platform_check_at = %[1]d WHERE repo_id = 1 AND digest = '%[2]s';
+		`,
+		s.Clock.Now().Add(24*time.Hour).Unix(), imageList.Manifest.Digest,
+	)
+
+	// with required platforms, missing platforms are recorded on the next check
+	// (a requirement without variant is satisfied by any variant, but not vice versa)
+	j.cfg.RequiredPlatforms = models.PlatformFilter{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+		{OS: "linux", Architecture: "arm64"},
+	}
+	s.Clock.StepBy(25 * time.Hour)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE manifests SET missing_platforms = 'linux/arm/v7,linux/arm64', next_platform_check_at = %[1]d WHERE repo_id = 1 AND digest = '%[2]s';
+		`,
+		s.Clock.Now().Add(24*time.Hour).Unix(), imageList.Manifest.Digest,
+	)
+
+	// the account's platform filter takes precedence over the global configuration
+	mustExec(t, s.DB, `UPDATE accounts SET platform_filter = $1 WHERE name = $2`,
+		`[{"os":"linux","architecture":"amd64"},{"os":"linux","architecture":"arm"}]`, "test1")
+	s.Clock.StepBy(25 * time.Hour)
+	tr.DBChanges().Ignore()
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE manifests SET missing_platforms = '', next_platform_check_at = %[1]d WHERE repo_id = 1 AND digest = '%[2]s';
+		`,
+		s.Clock.Now().Add(24*time.Hour).Unix(), imageList.Manifest.Digest,
+	)
+
+	// child manifests that are listed in the index, but do not exist in the DB, do not count
+	mustExec(t, s.DB, `DELETE FROM manifest_manifest_refs WHERE repo_id = 1 AND child_digest = $1`, images[1].Manifest.Digest)
+	s.Clock.StepBy(25 * time.Hour)
+	tr.DBChanges().Ignore()
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE manifests SET missing_platforms = 'linux/arm', next_platform_check_at = %[1]d WHERE repo_id = 1 AND digest = '%[2]s';
+		`,
+		s.Clock.Now().Add(24*time.Hour).Unix(), imageList.Manifest.Digest,
+	)
+}
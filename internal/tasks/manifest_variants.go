@@ -0,0 +1,135 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/distribution/manifest/schema2"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// query that finds the next tagged image manifest that is missing a variant
+// for one of the image transformations configured on its account
+var manifestVariantSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT m.repo_id, m.digest AS source_digest, t.transformation
+	  FROM manifests m
+	  JOIN repos r ON r.id = m.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	 CROSS JOIN LATERAL unnest(string_to_array(a.image_transformations, ',')) AS t(transformation)
+	 WHERE a.image_transformations != '' AND NOT a.is_deleting
+	   AND m.media_type IN ($2, $3)
+	   AND EXISTS (SELECT 1 FROM tags WHERE repo_id = m.repo_id AND digest = m.digest)
+	   -- variants are not transformed further
+	   AND NOT EXISTS (SELECT 1 FROM manifest_variants WHERE repo_id = m.repo_id AND variant_digest = m.digest)
+	   AND NOT EXISTS (
+	     SELECT 1 FROM manifest_variants mv
+	      WHERE mv.repo_id = m.repo_id AND mv.source_digest = m.digest AND mv.transformation = t.transformation
+	        AND (mv.variant_digest IS NOT NULL OR mv.next_attempt_at > $1)
+	   )
+	 ORDER BY m.pushed_at ASC
+	 LIMIT 1 -- one at a time
+`)
+
+var manifestVariantFinishQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO manifest_variants (repo_id, source_digest, transformation, variant_digest, updated_at, error_message, next_attempt_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (repo_id, source_digest, transformation) DO UPDATE SET
+		variant_digest = EXCLUDED.variant_digest, updated_at = EXCLUDED.updated_at,
+		error_message = EXCLUDED.error_message, next_attempt_at = EXCLUDED.next_attempt_at
+`)
+
+// how long to wait before retrying to generate a variant that could not be generated
+const manifestVariantRetryInterval = 6 * time.Hour
+
+// ManifestVariantGenerationJob is a job. Each task generates one variant of a
+// tagged image manifest by applying one of the image transformations
+// configured on its account (e.g. squashing all layers into one).
+func (j *Janitor) ManifestVariantGenerationJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.ManifestVariant]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "manifest variant generation",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_manifest_variant_generations",
+				Help: "Counter for generations of manifest variants (e.g. squashed images).",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (variant models.ManifestVariant, err error) {
+			err = j.db.SelectOne(&variant, manifestVariantSearchQuery, j.timeNow(),
+				schema2.MediaTypeManifest, imgspecv1.MediaTypeImageManifest)
+			return variant, err
+		},
+		ProcessTask: trackTask(j, "manifest_variant_generation", j.generateManifestVariant),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) generateManifestVariant(ctx context.Context, variant models.ManifestVariant, _ prometheus.Labels) error {
+	var repo models.Repository
+	err := j.db.SelectOne(&repo, `SELECT * FROM repos WHERE id = $1`, variant.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("cannot find repo %d for manifest %s: %w", variant.RepositoryID, variant.SourceDigest, err)
+	}
+	account, err := keppel.FindReducedAccount(j.db, repo.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), variant.SourceDigest, err)
+	}
+	if account == nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), variant.SourceDigest, errors.New("no such account"))
+	}
+	var source models.Manifest
+	err = j.db.SelectOne(&source, `SELECT * FROM manifests WHERE repo_id = $1 AND digest = $2`, repo.ID, variant.SourceDigest)
+	if err != nil {
+		return fmt.Errorf("cannot find manifest %s/%s: %w", repo.FullName(), variant.SourceDigest, err)
+	}
+
+	variantManifest, err := j.processor().GenerateManifestVariant(ctx, *account, repo, source, variant.Transformation, keppel.AuditContext{
+		UserIdentity: janitorUserIdentity{TaskName: "manifest-variants"},
+		Request:      janitorDummyRequest,
+	})
+	now := j.timeNow()
+	if err != nil {
+		// remember the failure to not retry the same broken image over and over again
+		retryAt := now.Add(j.addJitter(manifestVariantRetryInterval))
+		_, updateErr := j.db.Exec(manifestVariantFinishQuery,
+			repo.ID, variant.SourceDigest, variant.Transformation, nil, now, err.Error(), retryAt,
+		)
+		if updateErr != nil {
+			err = fmt.Errorf("%w (additional error encountered while recording the failure: %w)", err, updateErr)
+		}
+		return fmt.Errorf("while generating %s variant of manifest %s: %w",
+			variant.Transformation, repo.FullName()+"@"+variant.SourceDigest.String(), err)
+	}
+
+	logg.Info("generated %s variant %s of manifest %s@%s", variant.Transformation, variantManifest.Digest, repo.FullName(), variant.SourceDigest)
+	_, err = j.db.Exec(manifestVariantFinishQuery,
+		repo.ID, variant.SourceDigest, variant.Transformation, variantManifest.Digest, now, "", nil,
+	)
+	return err
+}
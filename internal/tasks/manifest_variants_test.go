@@ -0,0 +1,163 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestManifestVariantGenerationJob(t *testing.T) {
+	j, s := setup(t)
+	job := j.ManifestVariantGenerationJob(s.Registry)
+	mustExec(t, s.DB, `UPDATE accounts SET image_transformations = $1 WHERE name = $2`, models.SquashTransformation, "test1")
+
+	// upload an image with two real tar layers (the second one deletes a file
+	// from the first one), and an image whose layer is not a tar archive
+	image := test.GenerateImage(
+		test.GenerateExampleTarLayer(map[string]string{"etc/a.txt": "a", "etc/b.txt": "b"}),
+		test.GenerateExampleTarLayer(map[string]string{"etc/.wh.a.txt": "", "etc/b.txt": "b2", "etc/c.txt": "c"}),
+	)
+	image.MustUpload(t, s, fooRepoRef, "latest")
+	s.Clock.StepBy(time.Minute)
+	brokenImage := test.GenerateImage(test.GenerateExampleLayer(1))
+	brokenImage.MustUpload(t, s, fooRepoRef, "broken")
+	s.Clock.StepBy(time.Minute)
+	// untagged images are ignored
+	test.GenerateImage(test.GenerateExampleLayer(2)).MustUpload(t, s, fooRepoRef, "")
+
+	// first run generates the squashed variant of the first image
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	variantDigest, err := s.DB.SelectStr(
+		`SELECT variant_digest FROM manifest_variants WHERE repo_id = 1 AND source_digest = $1 AND transformation = $2`,
+		image.Manifest.Digest, models.SquashTransformation)
+	mustDo(t, err)
+	if variantDigest == "" {
+		t.Fatal("expected squashed variant to be recorded, but got nothing")
+	}
+
+	// second run fails on the broken image and records the failure
+	err = job.ProcessOne(s.Ctx)
+	if err == nil {
+		t.Error("expected error when squashing broken image, but got none")
+	}
+	errorMessage, err := s.DB.SelectStr(
+		`SELECT error_message FROM manifest_variants WHERE repo_id = 1 AND source_digest = $1 AND variant_digest IS NULL`,
+		brokenImage.Manifest.Digest)
+	mustDo(t, err)
+	if errorMessage == "" {
+		t.Error("expected failure to be recorded for broken image, but got nothing")
+	}
+
+	// nothing else to do (the variant itself and the untagged image are not
+	// considered, and the broken image is not retried immediately)
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+
+	// the variant can be pulled with the derived tag, and contains a single layer
+	// with the merged filesystem contents
+	token := s.GetToken(t, "repository:test1/foo:pull")
+	_, manifestBytes := assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/foo/manifests/latest-squashed",
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+		ExpectHeader: map[string]string{"Docker-Content-Digest": variantDigest},
+	}.Check(t, s.Handler)
+	var manifest struct {
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+	}
+	mustDo(t, json.Unmarshal(manifestBytes, &manifest))
+	assert.DeepEqual(t, "layer count", len(manifest.Layers), 1)
+	_, layerBytes := assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/foo/blobs/" + manifest.Layers[0].Digest,
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, s.Handler)
+	assert.DeepEqual(t, "squashed layer contents", readTarLayer(t, layerBytes), map[string]string{
+		"etc/b.txt": "b2",
+		"etc/c.txt": "c",
+	})
+
+	// the failed image does not have a derived tag
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/foo/manifests/broken-squashed",
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusNotFound,
+	}.Check(t, s.Handler)
+
+	// after the retry interval, the broken image is retried
+	s.Clock.StepBy(manifestVariantRetryInterval + time.Minute)
+	if job.ProcessOne(s.Ctx) == nil {
+		t.Error("expected error when squashing broken image again, but got none")
+	}
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+
+	// deleting the source image also deletes its variant
+	account, err := keppel.FindReducedAccount(s.DB, "test1")
+	mustDo(t, err)
+	repo, err := keppel.FindRepository(s.DB, "foo", "test1")
+	mustDo(t, err)
+	mustDo(t, j.processor().DeleteManifest(s.Ctx, *account, *repo, image.Manifest.Digest, keppel.AuditContext{
+		UserIdentity: janitorUserIdentity{TaskName: "test"},
+		Request:      janitorDummyRequest,
+	}))
+	count, err := s.DB.SelectInt(`SELECT COUNT(*) FROM manifests WHERE digest = $1`, variantDigest)
+	mustDo(t, err)
+	assert.DeepEqual(t, "variant manifest count after deleting source", count, int64(0))
+}
+
+// Returns the regular files in a gzip-compressed tar archive (path -> contents).
+func readTarLayer(t *testing.T, layerBytes []byte) map[string]string {
+	t.Helper()
+	gzr, err := gzip.NewReader(bytes.NewReader(layerBytes))
+	mustDo(t, err)
+	tr := tar.NewReader(gzr)
+	result := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return result
+		}
+		mustDo(t, err)
+		if hdr.Typeflag == tar.TypeReg {
+			buf, err := io.ReadAll(tr)
+			mustDo(t, err)
+			result[hdr.Name] = string(buf)
+		}
+	}
+}
@@ -19,7 +19,6 @@
 package tasks
 
 import (
-	"compress/gzip"
 	"context"
 	"database/sql"
 	"errors"
@@ -28,7 +27,6 @@ import (
 	"maps"
 	"regexp"
 	"slices"
-	"strings"
 	"sync"
 	"time"
 
@@ -50,10 +48,21 @@ import (
 	"github.com/sapcc/keppel/internal/trivy"
 )
 
-// query that finds the next manifest to be validated
+// query that finds the next account (in alphabetical order after $2) that has manifests to be validated
+var validateManifestNextAccountQuery = sqlext.SimplifyWhitespace(`
+	SELECT a.name FROM accounts a
+	 WHERE a.name > $2 AND EXISTS (
+	   SELECT 1 FROM manifests m JOIN repos r ON r.id = m.repo_id
+	    WHERE r.account_name = a.name AND m.next_validation_at < $1
+	 )
+	 ORDER BY a.name LIMIT 1
+`)
+
+// query that finds the next manifest to be validated within a given account
 var validateManifestSearchQuery = sqlext.SimplifyWhitespace(`
-	SELECT * FROM manifests WHERE next_validation_at < $1
-	ORDER BY next_validation_at ASC, media_type DESC -- see below for why we sort by media_type
+	SELECT m.* FROM manifests m JOIN repos r ON r.id = m.repo_id
+	 WHERE r.account_name = $2 AND m.next_validation_at < $1
+	ORDER BY m.next_validation_at ASC, m.media_type DESC -- see below for why we sort by media_type
 	LIMIT 1 -- one at a time
 `)
 
@@ -64,6 +73,12 @@ var validateManifestSearchQuery = sqlext.SimplifyWhitespace(`
 // important because multi-arch images take into account the size_bytes
 // attribute of their constituent images.
 
+// query that pushes next_validation_at back while a manifest is being validated,
+// so that it does not get discovered again by the time the validation finishes
+var validateManifestClaimQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET next_validation_at = $1 WHERE repo_id = $2 AND digest = $3
+`)
+
 var validateManifestFinishQuery = sqlext.SimplifyWhitespace(`
 	UPDATE manifests SET next_validation_at = $1, validation_error_message = $2
 	WHERE repo_id = $3 AND digest = $4
@@ -72,24 +87,44 @@ var validateManifestFinishQuery = sqlext.SimplifyWhitespace(`
 // ManifestValidationJob is a job. Each task validates a manifest that has not been validated for more
 // than 24 hours.
 //
-//nolint:dupl
+// Tasks are taken from all accounts with due manifests in turn, so that a
+// large backlog in one account does not hold up validations in all other
+// accounts. The job can run on multiple goroutines (see
+// KEPPEL_JANITOR_CONCURRENCY).
 func (j *Janitor) ManifestValidationJob(registerer prometheus.Registerer) jobloop.Job {
 	return (&jobloop.ProducerConsumerJob[models.Manifest]{
 		Metadata: jobloop.JobMetadata{
-			ReadableName: "manifest validation",
+			ReadableName:    "manifest validation",
+			ConcurrencySafe: true,
 			CounterOpts: prometheus.CounterOpts{
 				Name: "keppel_manifest_validations",
 				Help: "Counter for manifest validations.",
 			},
 		},
-		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (manifest models.Manifest, err error) {
-			err = j.db.SelectOne(&manifest, validateManifestSearchQuery, j.timeNow())
-			return manifest, err
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (models.Manifest, error) {
+			return j.discoverManifestToValidate()
 		},
-		ProcessTask: j.validateManifest,
+		ProcessTask: trackTask(j, "manifest_validation", j.validateManifest),
 	}).Setup(registerer)
 }
 
+func (j *Janitor) discoverManifestToValidate() (manifest models.Manifest, err error) {
+	now := j.timeNow()
+	accountName, err := j.manifestValidationRoundRobin.Next(j.db, validateManifestNextAccountQuery, now)
+	if err != nil {
+		return manifest, err
+	}
+	err = j.db.SelectOne(&manifest, validateManifestSearchQuery, now, accountName)
+	if err != nil {
+		return manifest, err
+	}
+
+	// if the janitor goes down during the validation, the manifest will be picked up again after this interval
+	_, err = j.db.Exec(validateManifestClaimQuery,
+		now.Add(models.ManifestValidationAfterErrorInterval), manifest.RepositoryID, manifest.Digest)
+	return manifest, err
+}
+
 func (j *Janitor) validateManifest(ctx context.Context, manifest models.Manifest, _ prometheus.Labels) error {
 	// find corresponding account and repo
 	var repo models.Repository
@@ -153,9 +188,10 @@ var syncManifestCleanupEmptyQuery = sqlext.SimplifyWhitespace(`
 `)
 
 // ManifestSyncJob is a job. Each task finds a repository in a replica account where
-// manifests have not been synced for more than an hour, and syncs its manifests.
-// Syncing involves checking with the primary account which manifests have been
-// deleted there, and replicating the deletions on our side.
+// manifests have not been synced for more than an hour (or more than 10 minutes
+// in proxy cache accounts), and syncs its manifests. Syncing involves checking
+// with the primary account which manifests have been deleted there, and
+// replicating the deletions on our side.
 func (j *Janitor) ManifestSyncJob(registerer prometheus.Registerer) jobloop.Job { //nolint:dupl // false positive
 	return (&jobloop.ProducerConsumerJob[models.Repository]{
 		Metadata: jobloop.JobMetadata{
@@ -169,7 +205,7 @@ func (j *Janitor) ManifestSyncJob(registerer prometheus.Registerer) jobloop.Job
 			err = j.db.SelectOne(&repo, syncManifestRepoSelectQuery, j.timeNow())
 			return repo, err
 		},
-		ProcessTask: j.syncManifestsInReplicaRepo,
+		ProcessTask: trackTask(j, "manifest_sync", j.syncManifestsInReplicaRepo),
 	}).Setup(registerer)
 }
 
@@ -186,17 +222,30 @@ func (j *Janitor) syncManifestsInReplicaRepo(ctx context.Context, repo models.Re
 		if err != nil {
 			return err
 		}
-		err = j.performTagSync(ctx, account.Reduced(), repo, syncPayload)
+		// errors for individual tags and manifests do not stop the sync of the
+		// rest of the repo unless there are more of them than we tolerate
+		tolerance := j.cfg.JanitorManifestSyncErrorTolerance
+		tagErrs, err := j.performTagSync(ctx, account.Reduced(), repo, syncPayload, tolerance)
 		if err != nil {
 			return fmt.Errorf("while syncing tags in repo %s: %w", repo.FullName(), err)
 		}
-		err = j.performManifestSync(ctx, account.Reduced(), repo, syncPayload)
+		manifestErrs, err := j.performManifestSync(ctx, account.Reduced(), repo, syncPayload, tolerance-len(tagErrs))
 		if err != nil {
 			return fmt.Errorf("while syncing manifests in repo %s: %w", repo.FullName(), err)
 		}
+		if len(tagErrs)+len(manifestErrs) > 0 {
+			logg.Error("ignoring errors during manifest sync of repo %s (tolerance is %d errors): %s",
+				repo.FullName(), tolerance, errors.Join(append(tagErrs, manifestErrs...)...).Error())
+		}
 	}
 
-	_, err = j.db.Exec(syncManifestDoneQuery, repo.ID, j.timeNow().Add(j.addJitter(1*time.Hour)))
+	// proxy caches re-resolve their tags more often to keep up with upstream
+	// even when the tags in question are not being pulled
+	syncInterval := 1 * time.Hour
+	if account.IsProxyCache {
+		syncInterval = 10 * time.Minute
+	}
+	_, err = j.db.Exec(syncManifestDoneQuery, repo.ID, j.timeNow().Add(j.addJitter(syncInterval)))
 	if err != nil {
 		return err
 	}
@@ -228,6 +277,16 @@ func (j *Janitor) getReplicaSyncPayload(ctx context.Context, account models.Acco
 		return nil, err
 	}
 
+	// during a rolling upgrade, the peer might not support the replica-sync API;
+	// in this case, fall back to polling each manifest and tag individually
+	ok, err := client.HasCapability(ctx, keppel.PeerCapabilitySyncReplica)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+
 	// assemble request body
 	tagsByDigest := make(map[digest.Digest][]keppel.TagForSync)
 	query := `SELECT name, digest, last_pulled_at FROM tags WHERE repo_id = $1`
@@ -276,11 +335,13 @@ func (j *Janitor) getReplicaSyncPayload(ctx context.Context, account models.Acco
 	return client.PerformReplicaSync(ctx, repo.FullName(), keppel.ReplicaSyncPayload{Manifests: manifests})
 }
 
-func (j *Janitor) performTagSync(ctx context.Context, account models.ReducedAccount, repo models.Repository, syncPayload *keppel.ReplicaSyncPayload) error {
+// Errors for individual tags are returned in tagErrs, unless there are more
+// than maxTagErrs of them, in which case the sync is aborted.
+func (j *Janitor) performTagSync(ctx context.Context, account models.ReducedAccount, repo models.Repository, syncPayload *keppel.ReplicaSyncPayload, maxTagErrs int) (tagErrs []error, err error) {
 	var tags []models.Tag
-	_, err := j.db.Select(&tags, `SELECT * FROM tags WHERE repo_id = $1`, repo.ID)
+	_, err = j.db.Select(&tags, `SELECT * FROM tags WHERE repo_id = $1`, repo.ID)
 	if err != nil {
-		return fmt.Errorf("cannot list tags: %w", err)
+		return nil, fmt.Errorf("cannot list tags: %w", err)
 	}
 
 	p := j.processor()
@@ -296,7 +357,7 @@ TAG:
 				// the tag was deleted - replicate the tag deletion into our replica
 				_, err := j.db.Delete(&tag)
 				if err != nil {
-					return err
+					return nil, err
 				}
 				continue TAG
 			default:
@@ -308,28 +369,39 @@ TAG:
 
 		// we want to check if upstream still has the tag, and if it has moved to a
 		// different manifest, replicate that manifest; all of that boils down to
-		// just a ReplicateManifest() call
+		// just a ReplicateManifest() call (or in proxy caches, a cheaper
+		// revalidation that only downloads the manifest if the tag has moved)
 		ref := models.ManifestReference{Tag: tag.Name}
-		_, _, err := p.ReplicateManifest(ctx, account, repo, ref, keppel.AuditContext{
+		actx := keppel.AuditContext{
 			UserIdentity: janitorUserIdentity{TaskName: "tag-sync"},
 			Request:      janitorDummyRequest,
-		})
+		}
+		var err error
+		if account.IsProxyCache {
+			_, _, err = p.RevalidateTagInProxyCache(ctx, account, repo, tag.Name, tag.Digest, actx)
+		} else {
+			_, _, err = p.ReplicateManifest(ctx, account, repo, ref, actx)
+		}
 		if err != nil {
 			// if the tag itself (and only the tag itself!) 404s, we can replicate the tag deletion into our replica
 			err404, ok := errext.As[processor.UpstreamManifestMissingError](err)
 			if ok && err404.Ref == ref {
 				_, err := j.db.Delete(&tag)
 				if err != nil {
-					return err
+					return nil, err
 				}
 			} else {
-				// all other errors fail the sync
-				return fmt.Errorf("while syncing tag %s: %w", tag.Name, err)
+				// all other errors leave the tag unchanged until the next sync
+				ManifestSyncErrorsCounter.WithLabelValues(string(account.Name)).Inc()
+				tagErrs = append(tagErrs, fmt.Errorf("while syncing tag %s: %w", tag.Name, err))
+				if len(tagErrs) > maxTagErrs {
+					return nil, errors.Join(tagErrs...)
+				}
 			}
 		}
 	}
 
-	return nil
+	return tagErrs, nil
 }
 
 var repoUntaggedManifestsSelectQuery = sqlext.SimplifyWhitespace(`
@@ -338,24 +410,40 @@ var repoUntaggedManifestsSelectQuery = sqlext.SimplifyWhitespace(`
 		AND digest NOT IN (SELECT DISTINCT digest FROM tags WHERE repo_id = $1)
 `)
 
-func (j *Janitor) performManifestSync(ctx context.Context, account models.ReducedAccount, repo models.Repository, syncPayload *keppel.ReplicaSyncPayload) error {
+var repoTaggedOrphansUnflagQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET orphaned_at = NULL
+		WHERE repo_id = $1 AND orphaned_at IS NOT NULL
+		AND digest IN (SELECT DISTINCT digest FROM tags WHERE repo_id = $1)
+`)
+
+// Errors for individual manifests are returned in manifestErrs, unless there
+// are more than maxManifestErrs of them, in which case the sync is aborted.
+func (j *Janitor) performManifestSync(ctx context.Context, account models.ReducedAccount, repo models.Repository, syncPayload *keppel.ReplicaSyncPayload, maxManifestErrs int) (manifestErrs []error, err error) {
 	// enumerate manifests in this repo (this only needs to consider untagged
 	//manifests: we run right after performTagSync, therefore all images that are
 	// tagged right now were already confirmed to still be good)
 	var manifests []models.Manifest
-	_, err := j.db.Select(&manifests, repoUntaggedManifestsSelectQuery, repo.ID)
+	_, err = j.db.Select(&manifests, repoUntaggedManifestsSelectQuery, repo.ID)
 	if err != nil {
-		return fmt.Errorf("cannot list manifests: %w", err)
+		return nil, fmt.Errorf("cannot list manifests: %w", err)
 	}
 
-	// check which manifests need to be deleted
-	shallDeleteManifest := make(map[digest.Digest]bool)
+	// for the same reason, tagged manifests are not orphaned (anymore)
+	_, err = j.db.Exec(repoTaggedOrphansUnflagQuery, repo.ID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unflag tagged orphans: %w", err)
+	}
+
+	// check which manifests were deleted upstream (manifests that could not be
+	// checked are left unchanged until the next sync)
+	isDeletedUpstream := make(map[digest.Digest]bool)
+	isUnchecked := make(map[digest.Digest]bool)
 	p := j.processor()
 	for _, manifest := range manifests {
 		// if we have a ReplicaSyncPayload available, use it to check manifest existence
 		if syncPayload != nil {
 			if !syncPayload.HasManifest(manifest.Digest) {
-				shallDeleteManifest[manifest.Digest] = true
+				isDeletedUpstream[manifest.Digest] = true
 			}
 			continue
 		}
@@ -364,16 +452,56 @@ func (j *Janitor) performManifestSync(ctx context.Context, account models.Reduce
 		ref := models.ManifestReference{Digest: manifest.Digest}
 		exists, err := p.CheckManifestOnPrimary(ctx, account, repo, ref)
 		if err != nil {
-			return fmt.Errorf("cannot check existence of manifest %s on primary account: %w", manifest.Digest, err)
+			ManifestSyncErrorsCounter.WithLabelValues(string(account.Name)).Inc()
+			manifestErrs = append(manifestErrs, fmt.Errorf("cannot check existence of manifest %s on primary account: %w", manifest.Digest, err))
+			if len(manifestErrs) > maxManifestErrs {
+				return nil, errors.Join(manifestErrs...)
+			}
+			isUnchecked[manifest.Digest] = true
+			continue
 		}
 		if !exists {
+			isDeletedUpstream[manifest.Digest] = true
+		}
+	}
+
+	// check which manifests need to be deleted (if the account has an orphan
+	// retention period, manifests that were deleted upstream are only flagged at
+	// first, and deleted once the retention period has passed)
+	shallDeleteManifest := make(map[digest.Digest]bool)
+	isRetainedOrphan := make(map[digest.Digest]bool)
+	retentionPeriod := account.OrphanRetentionPeriod()
+	now := j.timeNow()
+	for _, manifest := range manifests {
+		switch {
+		case isUnchecked[manifest.Digest]:
+			continue
+		case !isDeletedUpstream[manifest.Digest]:
+			if manifest.OrphanedAt != nil {
+				// the manifest has reappeared upstream
+				_, err := j.db.Exec(`UPDATE manifests SET orphaned_at = NULL WHERE repo_id = $1 AND digest = $2`, repo.ID, manifest.Digest)
+				if err != nil {
+					return nil, fmt.Errorf("cannot unflag manifest %s as orphaned: %w", manifest.Digest, err)
+				}
+			}
+		case retentionPeriod > 0 && manifest.OrphanedAt == nil:
+			logg.Info("retaining manifest %s@%s for %s after it was deleted on corresponding primary account",
+				repo.FullName(), manifest.Digest, retentionPeriod.String())
+			_, err := j.db.Exec(`UPDATE manifests SET orphaned_at = $3 WHERE repo_id = $1 AND digest = $2`, repo.ID, manifest.Digest, now)
+			if err != nil {
+				return nil, fmt.Errorf("cannot flag manifest %s as orphaned: %w", manifest.Digest, err)
+			}
+			isRetainedOrphan[manifest.Digest] = true
+		case retentionPeriod > 0 && manifest.OrphanedAt.Add(retentionPeriod).After(now):
+			isRetainedOrphan[manifest.Digest] = true
+		default:
 			shallDeleteManifest[manifest.Digest] = true
 		}
 	}
 
 	// if nothing needs to be deleted, we're done here
 	if len(shallDeleteManifest) == 0 {
-		return nil
+		return manifestErrs, nil
 	}
 
 	// enumerate manifest-manifest refs in this repo
@@ -391,7 +519,22 @@ func (j *Janitor) performManifestSync(ctx context.Context, account models.Reduce
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("cannot enumerate manifest-manifest refs: %w", err)
+		return nil, fmt.Errorf("cannot enumerate manifest-manifest refs: %w", err)
+	}
+
+	// manifests referenced by retained orphans (or by manifests that could not be
+	// checked) need to be retained as well until their parents can be deleted
+	for foundMore := true; foundMore; {
+		foundMore = false
+		for digestToBeDeleted := range shallDeleteManifest {
+			if slices.ContainsFunc(parentDigestsOf[digestToBeDeleted], func(parentDigest digest.Digest) bool {
+				return isRetainedOrphan[parentDigest] || isUnchecked[parentDigest]
+			}) {
+				delete(shallDeleteManifest, digestToBeDeleted)
+				isRetainedOrphan[digestToBeDeleted] = true
+				foundMore = true
+			}
+		}
 	}
 
 	// delete manifests in correct order (if there is a parent-child relationship,
@@ -416,7 +559,7 @@ func (j *Janitor) performManifestSync(ctx context.Context, account models.Reduce
 				Request:      janitorDummyRequest,
 			})
 			if err != nil {
-				return fmt.Errorf("cannot remove deleted manifest %s: %w", digestToBeDeleted, err)
+				return nil, fmt.Errorf("cannot remove deleted manifest %s: %w", digestToBeDeleted, err)
 			}
 
 			// remove deletion from work queue (so that we can eventually exit from the outermost loop)
@@ -431,12 +574,12 @@ func (j *Janitor) performManifestSync(ctx context.Context, account models.Reduce
 
 		// we should be deleting something in each iteration, otherwise we will get stuck in an infinite loop
 		if !deletedSomething {
-			return fmt.Errorf("cannot remove deleted manifests %v because they are still being referenced by other manifests (this smells like an inconsistency on the primary account)",
+			return nil, fmt.Errorf("cannot remove deleted manifests %v because they are still being referenced by other manifests (this smells like an inconsistency on the primary account)",
 				slices.Collect(maps.Keys(shallDeleteManifest)))
 		}
 	}
 
-	return nil
+	return manifestErrs, nil
 }
 
 var vulnCheckBlobSelectQuery = sqlext.SimplifyWhitespace(`
@@ -515,7 +658,7 @@ func (j *Janitor) CheckTrivySecurityStatusJob(registerer prometheus.Registerer)
 
 			return securityInfos, err
 		},
-		ProcessRow: j.processTrivySecurityInfo,
+		ProcessRow: trackTxTask(j, "trivy_security_check", j.processTrivySecurityInfo),
 	}).Setup(registerer)
 }
 
@@ -560,6 +703,9 @@ func (j *Janitor) processTrivySecurityInfo(ctx context.Context, tx *gorp.Transac
 			// inputChan acts as a queue here and each go routine picks the next SecurityInfo task when it is done with the previous
 			for securityInfo := range inputChan {
 				err := j.doSecurityCheck(ctx, &securityInfo)
+				if err == nil {
+					err = j.resolveQuarantineAfterSecurityCheck(ctx, securityInfo)
+				}
 				returnChan <- chanReturnStruct{
 					securityInfo: securityInfo,
 					err:          err,
@@ -703,7 +849,7 @@ func (j *Janitor) doSecurityCheck(ctx context.Context, securityInfo *models.Triv
 	var securityStatuses []models.VulnerabilityStatus
 
 	if len(layerBlobs) > 0 {
-		parsedTrivyReport, err := j.cfg.Trivy.ScanManifestAndParse(ctx, tokenResp.Token, imageRef)
+		parsedTrivyReport, err := keppel.ScanManifestAndParse(ctx, j.cfg.Scanner, tokenResp.Token, imageRef)
 		if err != nil {
 			return fmt.Errorf("scan error: %w", err)
 		}
@@ -749,6 +895,43 @@ func (j *Janitor) doSecurityCheck(ctx context.Context, securityInfo *models.Triv
 	return nil
 }
 
+// If the manifest is held in quarantine, promotes or rejects it based on the
+// result of the security check that was just performed.
+func (j *Janitor) resolveQuarantineAfterSecurityCheck(ctx context.Context, securityInfo models.TrivySecurityInfo) error {
+	quarantineStatus, err := j.db.SelectStr(
+		`SELECT quarantine_status FROM manifests WHERE repo_id = $1 AND digest = $2`,
+		securityInfo.RepositoryID, securityInfo.Digest,
+	)
+	if err != nil {
+		return err
+	}
+	if models.QuarantineStatus(quarantineStatus) != models.QuarantinePending {
+		return nil
+	}
+
+	repo, err := keppel.FindRepositoryByID(j.db, securityInfo.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("cannot find repo for manifest %s: %w", securityInfo.Digest, err)
+	}
+	account, err := keppel.FindReducedAccount(j.db, repo.AccountName)
+	if err == nil && account == nil {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		return fmt.Errorf("cannot find account for repo %s: %w", repo.FullName(), err)
+	}
+	manifest, err := keppel.FindManifest(j.db, *repo, securityInfo.Digest)
+	if err != nil {
+		return fmt.Errorf("cannot find manifest for repo %s and digest %s: %w", repo.FullName(), securityInfo.Digest, err)
+	}
+
+	_, err = j.processor().ResolveQuarantine(ctx, *account, *repo, *manifest, securityInfo.VulnerabilityStatus)
+	if err != nil {
+		return fmt.Errorf("cannot resolve quarantine for manifest %s@%s: %w", repo.FullName(), securityInfo.Digest, err)
+	}
+	return nil
+}
+
 var blobUncompressedSizeTooBigGiB float64 = 10
 
 func (j *Janitor) checkPreConditionsForTrivy(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifest models.Manifest, securityInfo *models.TrivySecurityInfo) (continueCheck bool, layerBlobs []models.Blob, err error) {
@@ -759,7 +942,8 @@ func (j *Janitor) checkPreConditionsForTrivy(ctx context.Context, account models
 
 	// filter media types that trivy is known to support
 	for _, blob := range layerBlobs {
-		if blob.MediaType == schema2.MediaTypeLayer || blob.MediaType == imageSpecs.MediaTypeImageLayerGzip {
+		switch blob.MediaType {
+		case schema2.MediaTypeLayer, imageSpecs.MediaTypeImageLayerGzip, imageSpecs.MediaTypeImageLayerZstd:
 			continue
 		}
 
@@ -787,23 +971,24 @@ func (j *Janitor) checkPreConditionsForTrivy(ctx context.Context, account models
 			return j.checkPreConditionsForTrivy(ctx, account, repo, manifest, securityInfo)
 		}
 
-		if blob.BlocksVulnScanning == nil && strings.HasSuffix(blob.MediaType, "gzip") {
+		compression, _ := keppel.LayerCompressionForMediaType(blob.MediaType)
+		if blob.BlocksVulnScanning == nil && (compression == keppel.LayerCompressedWithGzip || compression == keppel.LayerCompressedWithZstd) {
 			// uncompress the blob to check if it's too large for Trivy to handle within its allotted timeout
-			reader, _, err := j.sd.ReadBlob(ctx, account, blob.StorageID)
+			reader, _, err := j.sd.ReadBlob(ctx, j.cfg.BlobStorageLocation(account, blob), blob.StorageID)
 			if err != nil {
 				return false, layerBlobs, fmt.Errorf("cannot read blob %s: %w", blob.Digest, err)
 			}
-			defer reader.Close()
-			gzipReader, err := gzip.NewReader(reader)
+			uncompressedReader, err := compression.Decompress(reader)
 			if err != nil {
+				reader.Close()
 				return false, layerBlobs, fmt.Errorf("cannot unzip blob %s: %w", blob.Digest, err)
 			}
-			defer gzipReader.Close()
+			defer uncompressedReader.Close()
 
 			// when measuring uncompressed size, use LimitReader as a simple but
 			// effective guard against zip bombs
 			limitBytes := int64(1 << 30 * blobUncompressedSizeTooBigGiB)
-			numberBytes, err := io.Copy(io.Discard, io.LimitReader(gzipReader, limitBytes+1))
+			numberBytes, err := io.Copy(io.Discard, io.LimitReader(uncompressedReader, limitBytes+1))
 			if err != nil {
 				return false, layerBlobs, fmt.Errorf("cannot unzip blob %s: %w", blob.Digest, err)
 			}
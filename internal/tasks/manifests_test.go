@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"testing"
 	"time"
 
@@ -191,6 +192,44 @@ func TestManifestValidationJobError(t *testing.T) {
 	easypg.AssertDBContent(t, s.DB.DbMap.Db, "fixtures/manifest-validate-error-002.sql")
 }
 
+func TestManifestValidationJobFairness(t *testing.T) {
+	j, s := setup(t,
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "test1authtenant"}),
+		test.WithRepo(models.Repository{AccountName: "test2", Name: "bar"}),
+	)
+	s.Clock.StepBy(1 * time.Hour)
+	validateManifestJob := j.ManifestValidationJob(s.Registry)
+
+	// setup three images in test1, and one image in test2 that was pushed last
+	for idx := range 3 {
+		test.GenerateImage(test.GenerateExampleLayer(int64(idx))).MustUpload(t, s, fooRepoRef, "")
+		s.Clock.StepBy(1 * time.Minute)
+	}
+	test.GenerateImage(test.GenerateExampleLayer(10)).MustUpload(t, s, models.Repository{AccountName: "test2", Name: "bar"}, "")
+
+	// even though the image in test2 is the last one to become due, it does not
+	// have to wait until all images in test1 have been validated
+	s.Clock.StepBy(36 * time.Hour)
+	expectValidatedAccounts := func(expected ...string) {
+		t.Helper()
+		var actual []string
+		_, err := s.DB.Select(&actual, `
+			SELECT r.account_name FROM manifests m JOIN repos r ON r.id = m.repo_id
+			 WHERE m.next_validation_at > $1 ORDER BY r.account_name
+		`, s.Clock.Now())
+		mustDo(t, err)
+		assert.DeepEqual(t, "accounts of validated manifests", actual, expected)
+	}
+	expectSuccess(t, validateManifestJob.ProcessOne(s.Ctx))
+	expectValidatedAccounts("test1")
+	expectSuccess(t, validateManifestJob.ProcessOne(s.Ctx))
+	expectValidatedAccounts("test1", "test2")
+	expectSuccess(t, validateManifestJob.ProcessOne(s.Ctx))
+	expectSuccess(t, validateManifestJob.ProcessOne(s.Ctx))
+	expectValidatedAccounts("test1", "test1", "test1", "test2")
+	expectError(t, sql.ErrNoRows.Error(), validateManifestJob.ProcessOne(s.Ctx))
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // tests for ManifestSyncJob
 
@@ -398,6 +437,7 @@ func TestManifestSyncJob(t *testing.T) {
 					DELETE FROM manifests WHERE repo_id = 1 AND digest = '%[1]s';
 					%[5]sUPDATE manifests SET next_validation_at = %[6]d WHERE repo_id = 1 AND digest = '%[3]s';
 					UPDATE repos SET next_manifest_sync_at = %[4]d WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+					INSERT INTO tag_history (id, repo_id, tag_name, old_digest, new_digest, moved_at) VALUES (1, 1, 'latest', '%[7]s', '%[3]s', %[2]d);
 					UPDATE tags SET digest = '%[3]s', pushed_at = %[2]d, last_pulled_at = NULL WHERE repo_id = 1 AND name = 'latest';
 					DELETE FROM trivy_security_info WHERE repo_id = 1 AND digest = '%[1]s';
 				`,
@@ -407,6 +447,7 @@ func TestManifestSyncJob(t *testing.T) {
 				s1.Clock.Now().Add(1*time.Hour).Unix(),
 				manifestValidationBecauseOfExistingTag,
 				s1.Clock.Now().Add(models.ManifestValidationInterval).Unix(),
+				images[1].Manifest.Digest, // the manifest previously tagged as "latest"
 			)
 			expectError(t, sql.ErrNoRows.Error(), syncManifestsJob2.ProcessOne(s2.Ctx))
 			tr.DBChanges().AssertEmpty()
@@ -542,6 +583,245 @@ func TestManifestSyncJob(t *testing.T) {
 	})
 }
 
+func TestManifestSyncJobWithOrphanRetention(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		_, s1 := setup(t)
+		j2, s2 := setupReplica(t, s1, "on_first_use")
+		s1.Clock.StepBy(1 * time.Hour)
+		replicaToken := s2.GetToken(t, "repository:test1/foo:pull")
+		syncManifestsJob := j2.ManifestSyncJob(s2.Registry)
+		mustExec(t, s2.DB, `UPDATE accounts SET retain_orphans_for_secs = $1`, 86400)
+
+		// upload an image to the primary account and replicate it
+		image := test.GenerateImage(
+			test.GenerateExampleLayer(1),
+			test.GenerateExampleLayer(2),
+		)
+		image.MustUpload(t, s1, fooRepoRef, "")
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         fmt.Sprintf("/v2/test1/foo/manifests/%s", image.Manifest.Digest),
+			Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, s2.Handler)
+
+		tr, _ := easypg.NewTracker(t, s2.DB.DbMap.Db)
+		expectSuccess(t, syncManifestsJob.ProcessOne(s2.Ctx))
+		tr.DBChanges().Ignore()
+
+		// when the manifest is deleted on the primary side, the replica only flags it as orphaned
+		s1.Clock.StepBy(2 * time.Hour)
+		mustExec(t, s1.DB, `DELETE FROM manifests WHERE digest = $1`, image.Manifest.Digest)
+		expectSuccess(t, syncManifestsJob.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE manifests SET orphaned_at = %[2]d WHERE repo_id = 1 AND digest = '%[1]s';
+				UPDATE repos SET next_manifest_sync_at = %[3]d WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`,
+			image.Manifest.Digest,
+			s1.Clock.Now().Unix(),
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+
+		// when the manifest reappears on the primary side, the flag is removed again
+		s1.Clock.StepBy(2 * time.Hour)
+		image.MustUpload(t, s1, fooRepoRef, "")
+		expectSuccess(t, syncManifestsJob.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE manifests SET orphaned_at = NULL WHERE repo_id = 1 AND digest = '%[1]s';
+				UPDATE repos SET next_manifest_sync_at = %[2]d WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`,
+			image.Manifest.Digest,
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+
+		// delete the manifest on the primary side again
+		s1.Clock.StepBy(2 * time.Hour)
+		mustExec(t, s1.DB, `DELETE FROM manifests WHERE digest = $1`, image.Manifest.Digest)
+		expectSuccess(t, syncManifestsJob.ProcessOne(s2.Ctx))
+		orphanedAt := s1.Clock.Now()
+		tr.DBChanges().AssertEqualf(`
+				UPDATE manifests SET orphaned_at = %[2]d WHERE repo_id = 1 AND digest = '%[1]s';
+				UPDATE repos SET next_manifest_sync_at = %[3]d WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`,
+			image.Manifest.Digest,
+			orphanedAt.Unix(),
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+
+		// during the retention period, the manifest is retained and can still be pulled
+		s1.Clock.StepBy(23 * time.Hour)
+		expectSuccess(t, syncManifestsJob.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE repos SET next_manifest_sync_at = %[1]d WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`,
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         fmt.Sprintf("/v2/test1/foo/manifests/%s", image.Manifest.Digest),
+			Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, s2.Handler)
+		tr.DBChanges().Ignore()
+
+		// after the retention period, the deletion is replicated
+		s1.Clock.StepBy(1 * time.Hour)
+		expectSuccess(t, syncManifestsJob.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[1]s' AND blob_id = 1;
+				DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[1]s' AND blob_id = 2;
+				DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[1]s' AND blob_id = 3;
+				DELETE FROM manifest_contents WHERE repo_id = 1 AND digest = '%[1]s';
+				DELETE FROM manifests WHERE repo_id = 1 AND digest = '%[1]s';
+				UPDATE repos SET next_manifest_sync_at = %[2]d WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+				DELETE FROM trivy_security_info WHERE repo_id = 1 AND digest = '%[1]s';
+			`,
+			image.Manifest.Digest,
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+	})
+}
+
+func TestManifestSyncJobErrorTolerance(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		_, s1 := setup(t)
+		j2, s2 := setupReplica(t, s1, "from_external_on_first_use")
+		s1.Clock.StepBy(1 * time.Hour)
+		replicaToken := s2.GetToken(t, "repository:test1/foo:pull")
+		syncManifestsJob := j2.ManifestSyncJob(s2.Registry)
+
+		// upload some images to the primary account and replicate them (since the
+		// replica pulls from an external registry, there is no ReplicaSyncPayload,
+		// so each tag and manifest is checked individually and can fail individually)
+		images := make([]test.Image, 4)
+		for idx := range images {
+			image := test.GenerateImage(
+				test.GenerateExampleLayer(int64(10*idx+1)),
+				test.GenerateExampleLayer(int64(10*idx+2)),
+			)
+			images[idx] = image
+			image.MustUpload(t, s1, fooRepoRef, "")
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         fmt.Sprintf("/v2/test1/foo/manifests/%s", image.Manifest.Digest),
+				Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+				ExpectStatus: http.StatusOK,
+				ExpectBody:   assert.ByteData(image.Manifest.Contents),
+			}.Check(t, s2.Handler)
+		}
+		imageList := test.GenerateImageList(images[2])
+		imageList.MustUpload(t, s1, fooRepoRef, "")
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         fmt.Sprintf("/v2/test1/foo/manifests/%s", imageList.Manifest.Digest),
+			Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.ByteData(imageList.Manifest.Contents),
+		}.Check(t, s2.Handler)
+
+		// the first two images are tagged, the rest is untagged
+		for _, db := range []*keppel.DB{s1.DB, s2.DB} {
+			for idx, tagName := range []string{"first", "second"} {
+				mustExec(t, db,
+					`INSERT INTO tags (repo_id, name, digest, pushed_at) VALUES (1, $1, $2, $3)`,
+					tagName,
+					images[idx].Manifest.Digest,
+					s1.Clock.Now(),
+				)
+			}
+		}
+
+		tr, _ := easypg.NewTracker(t, s2.DB.DbMap.Db)
+
+		// delete one of the untagged images on the primary side, and make the
+		// primary fail to answer for one of the tags
+		//
+		// (We step the clock past the max age of the inbound cache before each
+		// sync, so that the primary actually gets asked.)
+		s1.Clock.StepBy(7 * time.Hour)
+		mustExec(t, s1.DB, `DELETE FROM manifests WHERE digest = $1`, images[3].Manifest.Digest)
+		http.DefaultTransport.(*test.RoundTripper).Handlers["registry.example.org"] = answerWith500For(s1.Handler,
+			"/v2/test1/foo/manifests/first",
+		)
+
+		// with the default tolerance of 0, the sync is aborted at the first error
+		// and the deletion is not replicated
+		expectError(t, "while syncing tags in repo test1/foo: while syncing tag first: during GET https://registry.example.org/v2/test1/foo/manifests/first: expected status 200, but got 500 Internal Server Error",
+			syncManifestsJob.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEmpty()
+
+		// when the error is tolerated, the rest of the repo is synced as usual,
+		// and the tag that failed to sync is left unchanged
+		s1.Clock.StepBy(7 * time.Hour)
+		j2.cfg.JanitorManifestSyncErrorTolerance = 1
+		expectSuccess(t, syncManifestsJob.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[1]s' AND blob_id = 10;
+				DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[1]s' AND blob_id = 11;
+				DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[1]s' AND blob_id = 12;
+				DELETE FROM manifest_contents WHERE repo_id = 1 AND digest = '%[1]s';
+				DELETE FROM manifests WHERE repo_id = 1 AND digest = '%[1]s';
+				UPDATE repos SET next_manifest_sync_at = %[2]d WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+				DELETE FROM trivy_security_info WHERE repo_id = 1 AND digest = '%[1]s';
+			`,
+			images[3].Manifest.Digest,
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+
+		// tag errors and manifest errors count against the same tolerance, so
+		// when both a tag and a manifest fail to sync, the sync is aborted again
+		s1.Clock.StepBy(7 * time.Hour)
+		http.DefaultTransport.(*test.RoundTripper).Handlers["registry.example.org"] = answerWith500For(s1.Handler,
+			"/v2/test1/foo/manifests/first",
+			fmt.Sprintf("/v2/test1/foo/manifests/%s", images[2].Manifest.Digest),
+		)
+		expectError(t, fmt.Sprintf("while syncing manifests in repo test1/foo: cannot check existence of manifest %[1]s on primary account: during GET https://registry.example.org/v2/test1/foo/manifests/%[1]s: expected status 200, but got 500 Internal Server Error", images[2].Manifest.Digest),
+			syncManifestsJob.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEmpty()
+
+		// delete the image list and its child image on the primary side, but make
+		// the primary fail to answer for the image list: since the image list is
+		// left unchanged, its child image must not be deleted either even though
+		// its deletion could be confirmed
+		s1.Clock.StepBy(7 * time.Hour)
+		mustExec(t, s1.DB, `DELETE FROM manifests WHERE digest = $1`, imageList.Manifest.Digest)
+		mustExec(t, s1.DB, `DELETE FROM manifests WHERE digest = $1`, images[2].Manifest.Digest)
+		http.DefaultTransport.(*test.RoundTripper).Handlers["registry.example.org"] = answerWith500For(s1.Handler,
+			fmt.Sprintf("/v2/test1/foo/manifests/%s", imageList.Manifest.Digest),
+		)
+		expectSuccess(t, syncManifestsJob.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				UPDATE repos SET next_manifest_sync_at = %[1]d WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+			`,
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+
+		// once the primary answers again, both deletions are replicated
+		s1.Clock.StepBy(7 * time.Hour)
+		http.DefaultTransport.(*test.RoundTripper).Handlers["registry.example.org"] = s1.Handler
+		expectSuccess(t, syncManifestsJob.ProcessOne(s2.Ctx))
+		tr.DBChanges().AssertEqualf(`
+				DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[1]s' AND blob_id = 7;
+				DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[1]s' AND blob_id = 8;
+				DELETE FROM manifest_blob_refs WHERE repo_id = 1 AND digest = '%[1]s' AND blob_id = 9;
+				DELETE FROM manifest_contents WHERE repo_id = 1 AND digest = '%[1]s';
+				DELETE FROM manifest_contents WHERE repo_id = 1 AND digest = '%[2]s';
+				DELETE FROM manifest_manifest_refs WHERE repo_id = 1 AND parent_digest = '%[2]s' AND child_digest = '%[1]s';
+				DELETE FROM manifests WHERE repo_id = 1 AND digest = '%[1]s';
+				DELETE FROM manifests WHERE repo_id = 1 AND digest = '%[2]s';
+				UPDATE repos SET next_manifest_sync_at = %[3]d WHERE id = 1 AND account_name = 'test1' AND name = 'foo';
+				DELETE FROM trivy_security_info WHERE repo_id = 1 AND digest = '%[1]s';
+				DELETE FROM trivy_security_info WHERE repo_id = 1 AND digest = '%[2]s';
+			`,
+			images[2].Manifest.Digest,
+			imageList.Manifest.Digest,
+			s1.Clock.Now().Add(1*time.Hour).Unix(),
+		)
+	})
+}
+
 func answerMostWith404(h http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/keppel/v1/auth" {
@@ -552,6 +832,18 @@ func answerMostWith404(h http.Handler) http.HandlerFunc {
 	}
 }
 
+// answerWith500For wraps the given handler such that requests for the given
+// paths fail with a server error.
+func answerWith500For(h http.Handler, paths ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(paths, r.URL.Path) {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		} else {
+			h.ServeHTTP(w, r)
+		}
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // tests for CheckVulnerabilitiesForNextManifest
 
@@ -809,3 +1101,58 @@ func TestCheckTrivySecurityStatusWithEOSL(t *testing.T) {
 		`, image.Layers[0].Digest, models.RottenVulnerabilityStatus, s.Clock.Now().Add(60*time.Minute).Unix(), s.Clock.Now().Unix(), image.Manifest.Digest)
 	})
 }
+
+func TestCheckTrivySecurityStatusWithQuarantine(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		j, s := setup(t, test.WithTrivyDouble)
+		trivyJob := j.CheckTrivySecurityStatusJob(s.Registry)
+		mustExec(t, s.DB, `UPDATE accounts SET quarantine_severity_threshold = $1 WHERE name = $2`, models.HighSeverity, "test1")
+
+		// upload one image that will pass the scan and one that will not
+		goodImage := test.GenerateImage(test.GenerateExampleLayer(1))
+		badImage := test.GenerateImage(test.GenerateExampleLayer(2))
+		goodImage.MustUpload(t, s, fooRepoRef, "good")
+		badImage.MustUpload(t, s, fooRepoRef, "bad")
+		s.TrivyDouble.ReportFixtures[goodImage.ImageRef(s, fooRepoRef)] = "fixtures/trivy/report-clean.json"
+		s.TrivyDouble.ReportFixtures[badImage.ImageRef(s, fooRepoRef)] = "fixtures/trivy/report-eosl.json"
+
+		expectQuarantineStatus := func(image test.Image, expected models.QuarantineStatus) {
+			t.Helper()
+			actual, err := s.DB.SelectStr(`SELECT quarantine_status FROM manifests WHERE digest = $1`, image.Manifest.Digest)
+			mustDo(t, err)
+			assert.DeepEqual(t, "quarantine status of "+image.Manifest.Digest.String(), models.QuarantineStatus(actual), expected)
+		}
+		expectTagCounts := func(expectedVisible, expectedQuarantined int64) {
+			t.Helper()
+			visible, err := s.DB.SelectInt(`SELECT COUNT(*) FROM tags`)
+			mustDo(t, err)
+			assert.DeepEqual(t, "visible tag count", visible, expectedVisible)
+			quarantined, err := s.DB.SelectInt(`SELECT COUNT(*) FROM quarantined_tags`)
+			mustDo(t, err)
+			assert.DeepEqual(t, "quarantined tag count", quarantined, expectedQuarantined)
+		}
+
+		// before the scan, both images are in quarantine and their tags are not visible
+		expectQuarantineStatus(goodImage, models.QuarantinePending)
+		expectQuarantineStatus(badImage, models.QuarantinePending)
+		expectTagCounts(0, 2)
+
+		// the scan promotes the clean image and rejects the one with "Rotten" status
+		s.Clock.StepBy(1 * time.Hour)
+		expectSuccess(t, trivyJob.ProcessOne(s.Ctx))
+		expectError(t, sql.ErrNoRows.Error(), trivyJob.ProcessOne(s.Ctx))
+		expectQuarantineStatus(goodImage, models.QuarantinePromoted)
+		expectQuarantineStatus(badImage, models.QuarantineRejected)
+		expectTagCounts(1, 0)
+
+		tagDigest, err := s.DB.SelectStr(`SELECT digest FROM tags WHERE name = $1`, "good")
+		mustDo(t, err)
+		assert.DeepEqual(t, "digest of promoted tag", tagDigest, goodImage.Manifest.Digest.String())
+
+		// subsequent rescans do not change the quarantine status anymore
+		s.Clock.StepBy(2 * time.Hour)
+		expectSuccess(t, trivyJob.ProcessOne(s.Ctx))
+		expectQuarantineStatus(goodImage, models.QuarantinePromoted)
+		expectQuarantineStatus(badImage, models.QuarantineRejected)
+	})
+}
@@ -0,0 +1,99 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// ReplicaTagDivergenceGauge is a prometheus.GaugeVec.
+	ReplicaTagDivergenceGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_replica_tag_divergences",
+			Help: "Number of tags in replica accounts that were found to diverge from the primary account in consecutive consistency checks.",
+		},
+		[]string{"account", "kind"},
+	)
+	// EOLReportEntriesGauge is a prometheus.GaugeVec.
+	EOLReportEntriesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_eol_report_entries",
+			Help: "Number of manifests per account that were listed in the most recent report of end-of-life images.",
+		},
+		[]string{"account"},
+	)
+	// UpstreamFailoverActiveGauge is a prometheus.GaugeVec.
+	UpstreamFailoverActiveGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_account_upstream_failover_active",
+			Help: "Whether a replica account is currently replicating from its failover upstream (1) or its primary upstream (0).",
+		},
+		[]string{"account"},
+	)
+	// ManifestSyncErrorsCounter is a prometheus.CounterVec.
+	ManifestSyncErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keppel_manifest_sync_errors",
+			Help: "Counter for tags and manifests in replica accounts that could not be synced with the primary account.",
+		},
+		[]string{"account"},
+	)
+	// JanitorJobLastRunGauge is a prometheus.GaugeVec.
+	JanitorJobLastRunGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_janitor_job_last_run_timestamp_seconds",
+			Help: "UNIX timestamp of when each janitor job last processed a task in this process.",
+		},
+		[]string{"job"},
+	)
+	// JanitorJobLastRunFailedGauge is a prometheus.GaugeVec.
+	JanitorJobLastRunFailedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_janitor_job_last_run_failed",
+			Help: "Whether the last task processed by each janitor job in this process failed (1) or succeeded (0).",
+		},
+		[]string{"job"},
+	)
+	// JanitorJobBacklogGauge is a prometheus.GaugeVec.
+	JanitorJobBacklogGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_janitor_job_backlog",
+			Help: "Number of tasks that are due, but have not been processed yet by each janitor job.",
+		},
+		[]string{"job"},
+	)
+	// JanitorJobBacklogAgeGauge is a prometheus.GaugeVec.
+	JanitorJobBacklogAgeGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keppel_janitor_job_backlog_age_seconds",
+			Help: "How long the oldest task in the backlog of each janitor job has been due.",
+		},
+		[]string{"job"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(ReplicaTagDivergenceGauge)
+	prometheus.MustRegister(EOLReportEntriesGauge)
+	prometheus.MustRegister(UpstreamFailoverActiveGauge)
+	prometheus.MustRegister(ManifestSyncErrorsCounter)
+	prometheus.MustRegister(JanitorJobLastRunGauge)
+	prometheus.MustRegister(JanitorJobLastRunFailedGauge)
+	prometheus.MustRegister(JanitorJobBacklogGauge)
+	prometheus.MustRegister(JanitorJobBacklogAgeGauge)
+}
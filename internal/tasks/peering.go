@@ -112,7 +112,14 @@ func IssueNewPasswordForPeer(ctx context.Context, cfg keppel.Configuration, db *
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	httpClient, err := keppel.NewHTTPClientForPeer(peer)
+	if err != nil {
+		return err
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,138 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// ReferrersFallbackTagBackfillJob is a job that records the manifests listed
+// under referrers fallback tags as referrers of the manifest named by the
+// respective tag. Referrers fallback tags pushed by clients are already
+// handled during the push, so this job only covers tags that were pushed
+// before the feature was enabled, or that were replicated from a primary
+// account. It is only started if KEPPEL_NORMALIZE_REFERRERS_FALLBACK_TAGS is set.
+func (j *Janitor) ReferrersFallbackTagBackfillJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "backfill of referrers from referrers fallback tags",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_referrers_fallback_tag_backfills",
+				Help: "Counter for backfills of referrers from referrers fallback tags.",
+			},
+		},
+		Interval:     1 * time.Hour,
+		InitialDelay: 1 * time.Minute,
+		Task:         j.trackCronTask("referrers_fallback_tag_backfill", j.backfillReferrersFromFallbackTags),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) backfillReferrersFromFallbackTags(_ context.Context, _ prometheus.Labels) error {
+	count, err := j.processor().BackfillReferrersFromFallbackTags()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		logg.Info("recorded %d manifests as referrers based on referrers fallback tags", count)
+	}
+	return nil
+}
+
+// Referrers may legitimately be pushed before their subject, so a referrer is
+// only considered orphaned if its subject has been missing for this long.
+const orphanedReferrerGracePeriod = 24 * time.Hour
+
+// query that finds the next referrer whose subject does not exist (in replica
+// accounts, subjects may just not have been replicated yet, so those are
+// ignored; tagged referrers and referrers within an image index are also
+// ignored since a user evidently wants to keep them around)
+var orphanedReferrerSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT m.* FROM manifests m
+	  JOIN repos r ON r.id = m.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	 WHERE m.subject_digest != '' AND m.pushed_at < $1
+	   AND a.upstream_peer_hostname = '' AND a.external_peer_url = '' AND NOT a.is_deleting
+	   AND NOT EXISTS (SELECT 1 FROM manifests s WHERE s.repo_id = m.repo_id AND s.digest = m.subject_digest)
+	   AND NOT EXISTS (SELECT 1 FROM tags t WHERE t.repo_id = m.repo_id AND t.digest = m.digest)
+	   AND NOT EXISTS (SELECT 1 FROM manifest_manifest_refs mmr WHERE mmr.repo_id = m.repo_id AND mmr.child_digest = m.digest)
+	 ORDER BY m.pushed_at ASC
+	 LIMIT 1 -- one at a time
+`)
+
+// OrphanedReferrerCleanupJob is a job. Each task deletes a manifest (e.g. a
+// signature or SBOM) whose subject manifest has been deleted, along with its
+// own referrers. This catches referrers that were left behind when their
+// subject was deleted without "cascade=referrers", e.g. by a GC policy.
+func (j *Janitor) OrphanedReferrerCleanupJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.Manifest]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "cleanup of orphaned referrers",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_orphaned_referrer_cleanups",
+				Help: "Counter for cleanup operations for referrers whose subject manifest does not exist.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (manifest models.Manifest, err error) {
+			maxPushedAt := j.timeNow().Add(-orphanedReferrerGracePeriod)
+			err = j.db.SelectOne(&manifest, orphanedReferrerSearchQuery, maxPushedAt)
+			return manifest, err
+		},
+		ProcessTask: trackTask(j, "orphaned_referrer_cleanup", j.deleteOrphanedReferrer),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) deleteOrphanedReferrer(ctx context.Context, manifest models.Manifest, _ prometheus.Labels) error {
+	repo, err := keppel.FindRepositoryByID(j.db, manifest.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("cannot find repo %d for manifest %s: %w", manifest.RepositoryID, manifest.Digest, err)
+	}
+	account, err := keppel.FindReducedAccount(j.db, repo.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), manifest.Digest, err)
+	}
+	if account == nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), manifest.Digest, errors.New("no such account"))
+	}
+
+	err = j.processor().DeleteManifestAndReferrers(ctx, *account, *repo, manifest.Digest, keppel.AuditContext{
+		UserIdentity: janitorUserIdentity{TaskName: "orphaned-referrer-cleanup"},
+		Request:      janitorDummyRequest,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// deleted concurrently
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot delete orphaned referrer %s@%s: %w", repo.FullName(), manifest.Digest, err)
+	}
+	logg.Info("deleted manifest %s@%s since its subject %s does not exist", repo.FullName(), manifest.Digest, manifest.SubjectDigest)
+	return nil
+}
@@ -0,0 +1,115 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+	"github.com/sapcc/go-bits/easypg"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestReferrersFallbackTagBackfillJob(t *testing.T) {
+	j, s := setup(t)
+	j.cfg.NormalizeReferrersFallbackTags = true
+	job := j.ReferrersFallbackTagBackfillJob(s.Registry)
+
+	// upload an image, and a signature for it that does not declare a subject,
+	// but is listed under the referrers fallback tag for the image (this is
+	// what clients do when the registry does not support the Referrers API)
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, fooRepoRef, "latest")
+	signature := test.GenerateEmptyConfigArtifact("application/vnd.dev.cosign.artifact.sig.v1+json", nil, test.GenerateExampleLayer(2))
+	signature.MustUpload(t, s, fooRepoRef, "")
+	fallbackIndex := test.GenerateImageList(signature)
+	fallbackIndex.MustUpload(t, s, fooRepoRef, keppel.ReferrersFallbackTagName(image.Manifest.Digest))
+
+	// the registry API in this test is not configured to normalize referrers
+	// fallback tags on push, so the janitor needs to backfill the subject
+	tr, tr0 := easypg.NewTracker(t, s.DB.Db)
+	tr0.Ignore()
+
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEqualf(`
+			UPDATE manifests SET subject_digest = '%[1]s' WHERE repo_id = 1 AND digest = '%[2]s';
+		`,
+		image.Manifest.Digest, signature.Manifest.Digest,
+	)
+
+	// running the job again does not change anything
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEmpty()
+
+	// tags that merely look similar to referrers fallback tags are ignored
+	otherIndex := test.GenerateImageList(test.GenerateImage(test.GenerateExampleLayer(3)))
+	otherIndex.MustUpload(t, s, fooRepoRef, "sha256-notadigest")
+	tr.DBChanges().Ignore()
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	tr.DBChanges().AssertEmpty()
+}
+
+func TestOrphanedReferrerCleanupJob(t *testing.T) {
+	j, s := setup(t)
+	job := j.OrphanedReferrerCleanupJob(s.Registry)
+
+	// upload an image with an SBOM, a signature for that SBOM, and another SBOM that is tagged
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, fooRepoRef, "latest")
+	sbom := test.GenerateReferrerArtifact(image.Manifest, "application/vnd.example.sbom.v1+json", test.NewBytes([]byte("sbom")))
+	sbom.MustUpload(t, s, fooRepoRef, "")
+	signature := test.GenerateReferrerArtifact(sbom.Manifest, "application/vnd.example.signature.v1+json", test.NewBytes([]byte("signature")))
+	signature.MustUpload(t, s, fooRepoRef, "")
+	taggedSBOM := test.GenerateReferrerArtifact(image.Manifest, "application/vnd.example.sbom.v1+json", test.NewBytes([]byte("tagged sbom")))
+	taggedSBOM.MustUpload(t, s, fooRepoRef, "sbom")
+
+	expectManifestDigests := func(expected ...string) {
+		t.Helper()
+		var actual []string
+		_, err := s.DB.Select(&actual, `SELECT digest FROM manifests ORDER BY pushed_at, digest`)
+		mustDo(t, err)
+		assert.DeepEqual(t, "manifest digests", actual, expected)
+	}
+
+	// as long as the image exists, there is nothing to do
+	s.Clock.StepBy(48 * time.Hour)
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+
+	// when the image gets deleted without its referrers, the untagged SBOM is
+	// cleaned up along with its signature (the signature is not orphaned yet
+	// since its subject still exists at this point, but gets deleted in the
+	// same step since it is a referrer of the SBOM)
+	mustExec(t, s.DB, `DELETE FROM manifests WHERE digest = $1`, image.Manifest.Digest)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+	expectManifestDigests(taggedSBOM.Manifest.Digest.String())
+
+	// referrers that were pushed recently are not touched, since their subject may just not have been pushed yet
+	orphan := test.GenerateReferrerArtifact(image.Manifest, "application/vnd.example.sbom.v1+json", test.NewBytes([]byte("early sbom")))
+	orphan.MustUpload(t, s, fooRepoRef, "")
+	expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+	s.Clock.StepBy(25 * time.Hour)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectManifestDigests(taggedSBOM.Manifest.Digest.String())
+}
@@ -0,0 +1,268 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/auth"
+	peerclient "github.com/sapcc/keppel/internal/client/peer"
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+const (
+	// how often each replica repo is compared against its primary
+	replicaConsistencyCheckInterval = 24 * time.Hour
+	// when a divergence is found, the check is repeated after this interval to
+	// confirm it (this must be longer than the interval of ManifestSyncJob, so
+	// that the regular tag sync has had a chance to resolve the divergence)
+	replicaConsistencyRecheckInterval = 2 * time.Hour
+)
+
+var consistencyCheckRepoSelectQuery = sqlext.SimplifyWhitespace(`
+	SELECT r.* FROM repos r
+		JOIN accounts a ON r.account_name = a.name
+		WHERE (r.next_consistency_check_at IS NULL OR r.next_consistency_check_at < $1)
+		-- only internal replicas can be compared against their primary through the peer API
+		AND a.upstream_peer_hostname != '' AND NOT a.is_deleting
+	-- repos without any checks first, then sorted by last check
+	ORDER BY r.next_consistency_check_at IS NULL DESC, r.next_consistency_check_at ASC
+	-- only one repo at a time
+	LIMIT 1
+`)
+
+var consistencyCheckDoneQuery = sqlext.SimplifyWhitespace(`
+	UPDATE repos SET next_consistency_check_at = $2 WHERE id = $1
+`)
+
+var consistencyCheckCountQuery = sqlext.SimplifyWhitespace(`
+	SELECT d.primary_digest = '', COUNT(*) FROM replica_tag_divergences d
+	  JOIN repos r ON r.id = d.repo_id
+	 WHERE r.account_name = $1 AND d.is_confirmed
+	 GROUP BY d.primary_digest = ''
+`)
+
+// ReplicaConsistencyCheckJob is a job. Each task finds a repository in an
+// internal replica account that has not been checked for more than a day, and
+// compares its tags against the primary account. Tags that point to
+// different manifests than on the primary, or that do not exist on the primary
+// anymore, are recorded in the replica_tag_divergences table. This catches bugs
+// in ManifestSyncJob, which only considers what has changed recently.
+func (j *Janitor) ReplicaConsistencyCheckJob(registerer prometheus.Registerer) jobloop.Job { //nolint:dupl // false positive
+	return (&jobloop.ProducerConsumerJob[models.Repository]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "consistency check in replica repos",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_replica_consistency_checks",
+				Help: "Counter for consistency checks of replica repos against their primary.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (repo models.Repository, err error) {
+			err = j.db.SelectOne(&repo, consistencyCheckRepoSelectQuery, j.timeNow())
+			return repo, err
+		},
+		ProcessTask: trackTask(j, "replica_consistency_check", j.checkReplicaConsistency),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) checkReplicaConsistency(ctx context.Context, repo models.Repository, _ prometheus.Labels) error {
+	account, err := keppel.FindReducedAccount(j.db, repo.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for repo %s: %w", repo.FullName(), err)
+	}
+
+	primaryTags, err := j.getPrimaryTagDigests(ctx, *account, repo)
+	if err != nil {
+		return fmt.Errorf("cannot list tags on primary for repo %s: %w", repo.FullName(), err)
+	}
+	if primaryTags == nil {
+		// primary does not support the required API yet, so there is nothing to compare against
+		_, err = j.db.Exec(consistencyCheckDoneQuery, repo.ID, j.timeNow().Add(j.addJitter(replicaConsistencyCheckInterval)))
+		return err
+	}
+
+	hasUnconfirmed, err := j.recordReplicaTagDivergences(repo, primaryTags)
+	if err != nil {
+		return fmt.Errorf("while recording divergences for repo %s: %w", repo.FullName(), err)
+	}
+
+	interval := replicaConsistencyCheckInterval
+	if hasUnconfirmed {
+		interval = replicaConsistencyRecheckInterval
+	}
+	_, err = j.db.Exec(consistencyCheckDoneQuery, repo.ID, j.timeNow().Add(j.addJitter(interval)))
+	if err != nil {
+		return err
+	}
+	return j.updateReplicaTagDivergenceGauge(repo.AccountName)
+}
+
+// Returns the tags of this repo in the primary account. If the primary does
+// not support the replica-sync API, nil is returned. If the repo does not
+// exist on the primary, an empty map is returned.
+func (j *Janitor) getPrimaryTagDigests(ctx context.Context, account models.ReducedAccount, repo models.Repository) (map[string]digest.Digest, error) {
+	var peer models.Peer
+	err := j.db.SelectOne(&peer, `SELECT * FROM peers WHERE hostname = $1`, account.UpstreamPeerHostName)
+	if err != nil {
+		return nil, err
+	}
+	client, err := peerclient.New(ctx, j.cfg, peer, auth.PeerAPIScope)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := client.HasCapability(ctx, keppel.PeerCapabilitySyncReplica)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	// when we do not send any manifests of our own, the replica-sync API does
+	// not change anything on the primary side and just reports its own state
+	payload, err := client.PerformReplicaSync(ctx, repo.FullName(), keppel.ReplicaSyncPayload{})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]digest.Digest)
+	if payload == nil {
+		// repo does not exist on primary
+		return result, nil
+	}
+	for _, manifest := range payload.Manifests {
+		for _, tag := range manifest.Tags {
+			result[tag.Name] = manifest.Digest
+		}
+	}
+	return result, nil
+}
+
+// Compares the tags of this repo against the primary's tags, and updates the
+// replica_tag_divergences table accordingly. Returns whether there are
+// divergences that have not been confirmed by a previous check yet.
+func (j *Janitor) recordReplicaTagDivergences(repo models.Repository, primaryTags map[string]digest.Digest) (hasUnconfirmed bool, err error) {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	// find current divergences (tags that only exist on the primary are not
+	// divergences since replicas only contain what has been pulled from them)
+	currentDivergences := make(map[string]models.ReplicaTagDivergence)
+	err = sqlext.ForeachRow(tx, `SELECT name, digest FROM tags WHERE repo_id = $1`, []any{repo.ID}, func(rows *sql.Rows) error {
+		var (
+			tagName       string
+			replicaDigest digest.Digest
+		)
+		err := rows.Scan(&tagName, &replicaDigest)
+		if err != nil {
+			return err
+		}
+		primaryDigest := primaryTags[tagName]
+		if primaryDigest != replicaDigest {
+			currentDivergences[tagName] = models.ReplicaTagDivergence{
+				RepositoryID:  repo.ID,
+				TagName:       tagName,
+				ReplicaDigest: replicaDigest,
+				PrimaryDigest: primaryDigest,
+				DetectedAt:    j.timeNow(),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// divergences that were already found in the previous check are confirmed now
+	var previousDivergences []models.ReplicaTagDivergence
+	_, err = tx.Select(&previousDivergences, `SELECT * FROM replica_tag_divergences WHERE repo_id = $1`, repo.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, previous := range previousDivergences {
+		current, exists := currentDivergences[previous.TagName]
+		if exists && current.ReplicaDigest == previous.ReplicaDigest && current.PrimaryDigest == previous.PrimaryDigest {
+			if !previous.IsConfirmed {
+				previous.IsConfirmed = true
+				logg.Info("confirmed divergence of tag %s:%s from primary (replica digest: %s, primary digest: %q)",
+					repo.FullName(), previous.TagName, previous.ReplicaDigest, previous.PrimaryDigest)
+				_, err = tx.Update(&previous)
+				if err != nil {
+					return false, err
+				}
+			}
+			delete(currentDivergences, previous.TagName)
+			continue
+		}
+		_, err = tx.Delete(&previous)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// all remaining divergences are new
+	for _, current := range currentDivergences {
+		err = tx.Insert(&current)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return len(currentDivergences) > 0, tx.Commit()
+}
+
+func (j *Janitor) updateReplicaTagDivergenceGauge(accountName models.AccountName) error {
+	counts := map[models.ReplicaDivergenceKind]int{
+		models.DivergenceDeletedOnPrimary: 0,
+		models.DivergenceDigestMismatch:   0,
+	}
+	err := sqlext.ForeachRow(j.db, consistencyCheckCountQuery, []any{accountName}, func(rows *sql.Rows) error {
+		var (
+			isDeletedOnPrimary bool
+			count              int
+		)
+		err := rows.Scan(&isDeletedOnPrimary, &count)
+		if err != nil {
+			return err
+		}
+		if isDeletedOnPrimary {
+			counts[models.DivergenceDeletedOnPrimary] = count
+		} else {
+			counts[models.DivergenceDigestMismatch] = count
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for kind, count := range counts {
+		ReplicaTagDivergenceGauge.With(prometheus.Labels{"account": string(accountName), "kind": string(kind)}).Set(float64(count))
+	}
+	return nil
+}
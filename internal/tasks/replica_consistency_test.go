@@ -0,0 +1,137 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestReplicaConsistencyCheckJob(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		j1, s1 := setup(t)
+		j2, s2 := setupReplica(t, s1, "on_first_use")
+		s1.Clock.StepBy(1 * time.Hour)
+		replicaToken := s2.GetToken(t, "repository:test1/foo:pull")
+		consistencyJob1 := j1.ReplicaConsistencyCheckJob(s1.Registry)
+		consistencyJob2 := j2.ReplicaConsistencyCheckJob(s2.Registry)
+
+		// upload some images to the primary, and replicate them
+		images := make([]test.Image, 2)
+		for idx := range images {
+			image := test.GenerateImage(test.GenerateExampleLayer(int64(idx + 1)))
+			images[idx] = image
+			image.MustUpload(t, s1, fooRepoRef, "")
+			assert.HTTPRequest{
+				Method:       "GET",
+				Path:         fmt.Sprintf("/v2/test1/foo/manifests/%s", image.Manifest.Digest),
+				Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+				ExpectStatus: http.StatusOK,
+				ExpectBody:   assert.ByteData(image.Manifest.Contents),
+			}.Check(t, s2.Handler)
+		}
+
+		// tag them such that the replica diverges from the primary:
+		// - "latest" is consistent
+		// - "moved" points to a different image on the primary
+		// - "gone" was deleted on the primary
+		// - "new" was not replicated yet (this is not a divergence)
+		insertTag := func(s test.Setup, tagName string, d digest.Digest) {
+			t.Helper()
+			mustExec(t, s.DB, `INSERT INTO tags (repo_id, name, digest, pushed_at) VALUES (1, $1, $2, $3)`, tagName, d, s1.Clock.Now())
+		}
+		insertTag(s1, "latest", images[0].Manifest.Digest)
+		insertTag(s2, "latest", images[0].Manifest.Digest)
+		insertTag(s1, "moved", images[1].Manifest.Digest)
+		insertTag(s2, "moved", images[0].Manifest.Digest)
+		insertTag(s2, "gone", images[0].Manifest.Digest)
+		insertTag(s1, "new", images[1].Manifest.Digest)
+
+		type divergence struct {
+			TagName       string
+			PrimaryDigest digest.Digest
+			IsConfirmed   bool
+		}
+		expectDivergences := func(expected ...divergence) {
+			t.Helper()
+			var rows []models.ReplicaTagDivergence
+			_, err := s2.DB.Select(&rows, `SELECT * FROM replica_tag_divergences ORDER BY tag_name`)
+			mustDo(t, err)
+			actual := []divergence{}
+			for _, row := range rows {
+				assert.DeepEqual(t, "replica digest of "+row.TagName, row.ReplicaDigest, images[0].Manifest.Digest)
+				actual = append(actual, divergence{row.TagName, row.PrimaryDigest, row.IsConfirmed})
+			}
+			if expected == nil {
+				expected = []divergence{}
+			}
+			assert.DeepEqual(t, "divergences", actual, expected)
+		}
+		expectNextCheckAt := func(expected time.Time) {
+			t.Helper()
+			var actual time.Time
+			expectSuccess(t, s2.DB.QueryRow(`SELECT next_consistency_check_at FROM repos WHERE id = 1`).Scan(&actual))
+			assert.DeepEqual(t, "next_consistency_check_at", actual.Unix(), expected.Unix())
+		}
+
+		// the primary does not have any replica repos to check
+		expectError(t, sql.ErrNoRows.Error(), consistencyJob1.ProcessOne(s1.Ctx))
+
+		// first check on the replica finds the divergences, but does not confirm them yet
+		expectSuccess(t, consistencyJob2.ProcessOne(s2.Ctx))
+		expectDivergences(
+			divergence{"gone", "", false},
+			divergence{"moved", images[1].Manifest.Digest, false},
+		)
+		expectNextCheckAt(s2.Clock.Now().Add(replicaConsistencyRecheckInterval))
+		expectError(t, sql.ErrNoRows.Error(), consistencyJob2.ProcessOne(s2.Ctx))
+
+		// second check confirms the divergences that are still there
+		s1.Clock.StepBy(replicaConsistencyRecheckInterval + time.Second)
+		expectSuccess(t, consistencyJob2.ProcessOne(s2.Ctx))
+		expectDivergences(
+			divergence{"gone", "", true},
+			divergence{"moved", images[1].Manifest.Digest, true},
+		)
+		expectNextCheckAt(s2.Clock.Now().Add(replicaConsistencyCheckInterval))
+
+		// when the primary moves the tag back, the divergence is resolved on the next check
+		mustExec(t, s1.DB, `UPDATE tags SET digest = $1 WHERE name = $2`, images[0].Manifest.Digest, "moved")
+		s1.Clock.StepBy(replicaConsistencyCheckInterval + time.Second)
+		expectSuccess(t, consistencyJob2.ProcessOne(s2.Ctx))
+		expectDivergences(divergence{"gone", "", true})
+		expectNextCheckAt(s2.Clock.Now().Add(replicaConsistencyCheckInterval))
+
+		// when the tag is deleted on the replica, all divergences are resolved
+		mustExec(t, s2.DB, `DELETE FROM tags WHERE name = $1`, "gone")
+		s1.Clock.StepBy(replicaConsistencyCheckInterval + time.Second)
+		expectSuccess(t, consistencyJob2.ProcessOne(s2.Ctx))
+		expectDivergences()
+		expectNextCheckAt(s2.Clock.Now().Add(replicaConsistencyCheckInterval))
+	})
+}
@@ -0,0 +1,128 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+var coldStartReplicationSelectQuery = sqlext.SimplifyWhitespace(`
+	SELECT q.* FROM replication_queue q
+	  JOIN repos r ON r.id = q.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	  JOIN peers p ON p.hostname = a.upstream_peer_hostname
+	 WHERE (p.next_cold_start_replication_at IS NULL OR p.next_cold_start_replication_at <= $1)
+	   AND NOT a.is_deleting
+	-- images that are being pulled right now are most likely to be pulled again soon
+	ORDER BY q.last_requested_at DESC, q.request_count DESC
+	-- only one entry at a time
+	LIMIT 1
+`)
+
+var coldStartReplicationPeerSelectQuery = sqlext.SimplifyWhitespace(`
+	SELECT p.* FROM peers p
+	  JOIN accounts a ON a.upstream_peer_hostname = p.hostname
+	 WHERE a.name = $1
+`)
+
+// ColdStartReplicationJob is a job. Each task takes the most recently requested
+// entry from the replication queue (see Processor.ReplicateManifestOnFirstPull)
+// and replicates that manifest from the primary account, as far as the
+// primary's cold-start rate limit allows.
+func (j *Janitor) ColdStartReplicationJob(registerer prometheus.Registerer) jobloop.Job { //nolint:dupl // false positive
+	return (&jobloop.ProducerConsumerJob[models.ReplicationQueueEntry]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "replication of queued manifests",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_cold_start_replications",
+				Help: "Counter for replications of manifests from the replication queue.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (entry models.ReplicationQueueEntry, err error) {
+			err = j.db.SelectOne(&entry, coldStartReplicationSelectQuery, j.timeNow())
+			return entry, err
+		},
+		ProcessTask: trackTask(j, "cold_start_replication", j.replicateQueuedManifest),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) replicateQueuedManifest(ctx context.Context, entry models.ReplicationQueueEntry, _ prometheus.Labels) error {
+	repo, err := keppel.FindRepositoryByID(j.db, entry.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("cannot find repo %d: %w", entry.RepositoryID, err)
+	}
+	account, err := keppel.FindReducedAccount(j.db, repo.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for repo %s: %w", repo.FullName(), err)
+	}
+	var peer models.Peer
+	err = j.db.SelectOne(&peer, coldStartReplicationPeerSelectQuery, account.Name)
+	if err != nil {
+		return fmt.Errorf("cannot find upstream peer for account %s: %w", account.Name, err)
+	}
+
+	// another janitor (or a direct pull) might have taken the slot since DiscoverTask
+	ok, err := j.processor().ClaimColdStartReplicationSlot(peer, true)
+	if err != nil || !ok {
+		return err
+	}
+
+	// the entry is removed before replicating, and even if replication fails:
+	// if the client is still interested in this manifest, its next pull attempt
+	// will put it back into the queue
+	_, err = j.db.Delete(&entry)
+	if err != nil {
+		return err
+	}
+
+	ref := models.ParseManifestReference(entry.Reference)
+	var query string
+	if ref.IsTag() {
+		query = `SELECT COUNT(*) FROM tags WHERE repo_id = $1 AND name = $2`
+	} else {
+		query = `SELECT COUNT(*) FROM manifests WHERE repo_id = $1 AND digest = $2`
+	}
+	count, err := j.db.SelectInt(query, repo.ID, entry.Reference)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		// was replicated in the meantime (e.g. by the manifest sync or a direct pull)
+		return nil
+	}
+
+	_, _, err = j.processor().ReplicateManifest(ctx, *account, *repo, ref, keppel.AuditContext{
+		UserIdentity: janitorUserIdentity{TaskName: "cold-start-replication"},
+		Request:      janitorDummyRequest,
+	})
+	if err != nil {
+		logg.Error("cannot replicate queued manifest %s@%s: %s", repo.FullName(), entry.Reference, err.Error())
+	}
+	return nil
+}
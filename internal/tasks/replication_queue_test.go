@@ -0,0 +1,101 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestColdStartReplicationJob(t *testing.T) {
+	test.WithRoundTripper(func(_ *test.RoundTripper) {
+		_, s1 := setup(t)
+		j2, s2 := setupReplica(t, s1, "on_first_use")
+		s1.Clock.StepBy(1 * time.Hour)
+		replicaToken := s2.GetToken(t, "repository:test1/foo:pull")
+		job := j2.ColdStartReplicationJob(s2.Registry)
+
+		// put the replica into cold-start mode (2 replications per minute = one replication every 30 seconds)
+		mustExec(t, s2.DB, `UPDATE peers SET cold_start_replications_per_minute = 2`)
+
+		images := make([]test.Image, 3)
+		for idx := range images {
+			image := test.GenerateImage(test.GenerateExampleLayer(int64(idx + 1)))
+			images[idx] = image
+			image.MustUpload(t, s1, fooRepoRef, "")
+		}
+		pullManifest := func(idx int, expectStatus int) {
+			t.Helper()
+			req := assert.HTTPRequest{
+				Method:       "GET",
+				Path:         fmt.Sprintf("/v2/test1/foo/manifests/%s", images[idx].Manifest.Digest),
+				Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+				ExpectStatus: expectStatus,
+			}
+			if expectStatus == http.StatusOK {
+				req.ExpectBody = assert.ByteData(images[idx].Manifest.Contents)
+			}
+			req.Check(t, s2.Handler)
+		}
+		expectQueueLength := func(expected int64) {
+			t.Helper()
+			actual, err := s2.DB.SelectInt(`SELECT COUNT(*) FROM replication_queue`)
+			mustDo(t, err)
+			assert.DeepEqual(t, "queue length", actual, expected)
+		}
+
+		// the first pull can be replicated immediately
+		pullManifest(0, http.StatusOK)
+		expectQueueLength(0)
+
+		// the next pulls exceed the rate limit and go into the queue
+		pullManifest(1, http.StatusTooManyRequests)
+		pullManifest(2, http.StatusTooManyRequests)
+		pullManifest(2, http.StatusTooManyRequests)
+		expectQueueLength(2)
+		requestCount, err := s2.DB.SelectInt(`SELECT request_count FROM replication_queue WHERE reference = $1`, images[2].Manifest.Digest.String())
+		mustDo(t, err)
+		assert.DeepEqual(t, "request_count", requestCount, int64(2))
+
+		// the job does nothing while the rate limit is exhausted
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s2.Ctx))
+
+		// once the rate limit allows, the job replicates the most recently requested entry first
+		s1.Clock.StepBy(30 * time.Second)
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		expectQueueLength(1)
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s2.Ctx))
+		pullManifest(1, http.StatusTooManyRequests) // still in the queue
+		s1.Clock.StepBy(30 * time.Second)
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		expectQueueLength(0)
+
+		// the queued manifests can be pulled now without hitting the rate limit
+		pullManifest(1, http.StatusOK)
+		pullManifest(2, http.StatusOK)
+	})
+}
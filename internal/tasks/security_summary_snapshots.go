@@ -0,0 +1,87 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+)
+
+// how long snapshots are kept (this is the time span that the trend in the
+// tenant-level security summary can cover)
+const securitySummarySnapshotRetention = 90 * 24 * time.Hour
+
+var securitySummarySnapshotInsertQuery = sqlext.SimplifyWhitespace(`
+	INSERT INTO security_summary_snapshots (auth_tenant_id, taken_at, vuln_status, manifest_count)
+	SELECT a.auth_tenant_id, $1, t.vuln_status, COUNT(*)
+	  FROM trivy_security_info t
+	  JOIN repos r ON r.id = t.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	 GROUP BY a.auth_tenant_id, t.vuln_status
+`)
+
+// SecuritySummarySnapshotJob is a job that records, once per day and per auth
+// tenant, how many manifests have which vulnerability status. These snapshots
+// are shown as a trend in the tenant-level security summary in the Keppel API.
+// The snapshot for the current day is updated on every run of the job until
+// the day is over.
+func (j *Janitor) SecuritySummarySnapshotJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "snapshot of vulnerability statuses",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_security_summary_snapshots",
+				Help: "Counter for snapshots of vulnerability statuses per auth tenant.",
+			},
+		},
+		Interval:     1 * time.Hour,
+		InitialDelay: 1 * time.Minute,
+		Task:         j.trackCronTask("security_summary_snapshot", j.takeSecuritySummarySnapshot),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) takeSecuritySummarySnapshot(_ context.Context, _ prometheus.Labels) error {
+	day := j.timeNow().UTC().Truncate(24 * time.Hour)
+
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlext.RollbackUnlessCommitted(tx)
+
+	// replace any previous snapshot for the current day
+	_, err = tx.Exec(`DELETE FROM security_summary_snapshots WHERE taken_at = $1`, day)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(securitySummarySnapshotInsertQuery, day)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM security_summary_snapshots WHERE taken_at < $1`, day.Add(-securitySummarySnapshotRetention))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
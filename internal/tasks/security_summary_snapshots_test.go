@@ -0,0 +1,97 @@
+/*******************************************************************************
+*
+* Copyright 2025 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestSecuritySummarySnapshotJob(t *testing.T) {
+	j, s := setup(t)
+	s.Clock.StepBy(10 * 24 * time.Hour)
+	job := j.SecuritySummarySnapshotJob(s.Registry)
+
+	images := make([]test.Image, 3)
+	for idx := range images {
+		image := test.GenerateImage(test.GenerateExampleLayer(int64(idx + 1)))
+		images[idx] = image
+		image.MustUpload(t, s, fooRepoRef, "")
+	}
+	mustExec(t, s.DB, `UPDATE trivy_security_info SET vuln_status = $1`, models.CleanSeverity)
+	mustExec(t, s.DB, `UPDATE trivy_security_info SET vuln_status = $1 WHERE digest = $2`, models.HighSeverity, images[0].Manifest.Digest)
+
+	type snapshot struct {
+		TakenAt int64
+		Status  models.VulnerabilityStatus
+		Count   uint64
+	}
+	expectSnapshots := func(expected ...snapshot) {
+		t.Helper()
+		var rows []models.SecuritySummarySnapshot
+		_, err := s.DB.Select(&rows, `SELECT * FROM security_summary_snapshots ORDER BY taken_at, vuln_status`)
+		mustDo(t, err)
+		actual := []snapshot{}
+		for _, row := range rows {
+			assert.DeepEqual(t, "auth tenant ID", row.AuthTenantID, "test1authtenant")
+			actual = append(actual, snapshot{row.TakenAt.Unix(), row.VulnerabilityStatus, row.ManifestCount})
+		}
+		assert.DeepEqual(t, "snapshots", actual, expected)
+	}
+	day10 := s.Clock.Now().UTC().Truncate(24 * time.Hour).Unix()
+
+	// first snapshot
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectSnapshots(
+		snapshot{day10, models.CleanSeverity, 2},
+		snapshot{day10, models.HighSeverity, 1},
+	)
+
+	// later runs on the same day replace the snapshot for that day
+	mustExec(t, s.DB, `UPDATE trivy_security_info SET vuln_status = $1`, models.CleanSeverity)
+	s.Clock.StepBy(1 * time.Hour)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectSnapshots(
+		snapshot{day10, models.CleanSeverity, 3},
+	)
+
+	// the next day gets its own snapshot
+	s.Clock.StepBy(24 * time.Hour)
+	day11 := day10 + 86400
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	expectSnapshots(
+		snapshot{day10, models.CleanSeverity, 3},
+		snapshot{day11, models.CleanSeverity, 3},
+	)
+
+	// old snapshots are cleaned up eventually
+	s.Clock.StepBy(securitySummarySnapshotRetention)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	day101 := s.Clock.Now().UTC().Truncate(24 * time.Hour).Unix()
+	expectSnapshots(
+		snapshot{day11, models.CleanSeverity, 3},
+		snapshot{day101, models.CleanSeverity, 3},
+	)
+}
@@ -0,0 +1,232 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// query that finds the next manifest whose signature shall be verified
+var signatureVerificationSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT m.* FROM manifests m
+	  JOIN repos r ON r.id = m.repo_id
+	  JOIN accounts a ON a.name = r.account_name
+	 WHERE a.require_signature_mode != '' AND (m.next_signature_check_at IS NULL OR m.next_signature_check_at < $1)
+	 ORDER BY m.next_signature_check_at ASC NULLS FIRST, m.pushed_at ASC
+	 LIMIT 1 -- one at a time
+`)
+
+var signatureVerificationFinishQuery = sqlext.SimplifyWhitespace(`
+	UPDATE manifests SET signature_status = $1, next_signature_check_at = $2
+	 WHERE repo_id = $3 AND digest = $4
+`)
+
+var signatureVerificationTagNamesQuery = sqlext.SimplifyWhitespace(`
+	SELECT name FROM tags WHERE repo_id = $1 AND digest = $2
+`)
+
+var signatureVerificationParentsQuery = sqlext.SimplifyWhitespace(`
+	SELECT parent_digest FROM manifest_manifest_refs WHERE repo_id = $1 AND child_digest = $2 ORDER BY parent_digest
+`)
+
+// signature payloads are tiny JSON documents, so anything larger is suspicious
+const maxSignaturePayloadBytes = 1 << 20
+
+// SignatureVerificationJob is a job. Each task verifies the cosign signatures
+// of a manifest in an account with a "require_signature" validation policy,
+// and caches the result in the manifest's signature_status.
+func (j *Janitor) SignatureVerificationJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.ProducerConsumerJob[models.Manifest]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "manifest signature verification",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_manifest_signature_verifications",
+				Help: "Counter for manifest signature verifications.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (manifest models.Manifest, err error) {
+			err = j.db.SelectOne(&manifest, signatureVerificationSearchQuery, j.timeNow())
+			return manifest, err
+		},
+		ProcessTask: trackTask(j, "signature_verification", j.verifyManifestSignature),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) verifyManifestSignature(ctx context.Context, manifest models.Manifest, _ prometheus.Labels) error {
+	// find corresponding account and repo (we need the full account to get the trusted keys)
+	var repo models.Repository
+	err := j.db.SelectOne(&repo, `SELECT * FROM repos WHERE id = $1`, manifest.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("cannot find repo %d for manifest %s: %w", manifest.RepositoryID, manifest.Digest, err)
+	}
+	account, err := keppel.FindAccount(j.db, repo.AccountName)
+	if err != nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), manifest.Digest, err)
+	}
+	if account == nil {
+		return fmt.Errorf("cannot find account for manifest %s/%s: %w", repo.FullName(), manifest.Digest, errors.New("no such account"))
+	}
+
+	status, err := j.determineSignatureStatus(ctx, *account, repo, manifest)
+	if err != nil {
+		// on failure, retain the previous status and retry soon
+		_, updateErr := j.db.Exec(signatureVerificationFinishQuery,
+			manifest.SignatureStatus, j.timeNow().Add(j.addJitter(models.SignatureVerificationRetryInterval)),
+			repo.ID, manifest.Digest,
+		)
+		if updateErr != nil {
+			err = fmt.Errorf("%w (additional error encountered while scheduling next verification: %w)", err, updateErr)
+		}
+		return fmt.Errorf("while verifying signature of manifest %s: %w", repo.FullName()+"@"+manifest.Digest.String(), err)
+	}
+
+	// unsigned manifests are rechecked more often since signatures are usually pushed shortly after the image
+	interval := models.SignatureVerificationInterval
+	if !status.IsTrusted() {
+		interval = models.SignatureVerificationRetryInterval
+	}
+	if status != manifest.SignatureStatus {
+		logg.Info("signature status of manifest %s@%s changed from %q to %q", repo.FullName(), manifest.Digest, manifest.SignatureStatus, status)
+	}
+	_, err = j.db.Exec(signatureVerificationFinishQuery,
+		status, j.timeNow().Add(j.addJitter(interval)), repo.ID, manifest.Digest,
+	)
+	return err
+}
+
+func (j *Janitor) determineSignatureStatus(ctx context.Context, account models.Account, repo models.Repository, manifest models.Manifest) (models.SignatureStatus, error) {
+	// cosign artifacts (signatures, attestations, SBOMs) cannot be signed themselves
+	var tagNames []string
+	_, err := j.db.Select(&tagNames, signatureVerificationTagNamesQuery, repo.ID, manifest.Digest)
+	if err != nil {
+		return "", err
+	}
+	for _, tagName := range tagNames {
+		if keppel.IsCosignArtifactTagName(tagName) {
+			return models.SignatureExempt, nil
+		}
+	}
+
+	keys, err := keppel.ParseSignaturePublicKeys(account.SignaturePublicKeys)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse trusted public keys of account %q: %w", account.Name, err)
+	}
+
+	// a manifest is also considered signed if one of the list manifests
+	// containing it is signed, since cosign usually signs only the list manifest
+	candidateDigests := []digest.Digest{manifest.Digest}
+	var parentDigests []digest.Digest
+	_, err = j.db.Select(&parentDigests, signatureVerificationParentsQuery, repo.ID, manifest.Digest)
+	if err != nil {
+		return "", err
+	}
+	candidateDigests = append(candidateDigests, parentDigests...)
+
+	foundSignatures := false
+	for _, candidateDigest := range candidateDigests {
+		isSigned, hasSignatures, err := j.checkCosignSignatures(ctx, account.Reduced(), repo, candidateDigest, keys)
+		if err != nil {
+			return "", err
+		}
+		if isSigned {
+			return models.SignatureValid, nil
+		}
+		foundSignatures = foundSignatures || hasSignatures
+	}
+
+	if foundSignatures {
+		return models.SignatureInvalid, nil
+	}
+	return models.SignatureMissing, nil
+}
+
+// Checks the cosign signatures that are stored for the given manifest. Returns
+// whether a valid signature was found, and whether any signatures were found at all.
+func (j *Janitor) checkCosignSignatures(ctx context.Context, account models.ReducedAccount, repo models.Repository, manifestDigest digest.Digest, keys []crypto.PublicKey) (isSigned, hasSignatures bool, err error) {
+	sigDigestStr, err := j.db.SelectStr(
+		`SELECT digest FROM tags WHERE repo_id = $1 AND name = $2`,
+		repo.ID, keppel.CosignSignatureTagName(manifestDigest))
+	if err != nil || sigDigestStr == "" {
+		return false, false, err
+	}
+	sigManifest, err := keppel.FindManifest(j.db, repo, digest.Digest(sigDigestStr))
+	if err != nil {
+		return false, false, err
+	}
+	sigManifestBytes, err := j.sd.ReadManifest(ctx, account, repo.Name, sigManifest.Digest)
+	if err != nil {
+		return false, false, err
+	}
+	parsed, _, err := keppel.ParseManifest(sigManifest.MediaType, sigManifestBytes)
+	if err != nil {
+		return false, false, fmt.Errorf("cannot parse signature manifest %s: %w", sigManifest.Digest, err)
+	}
+
+	for _, layer := range parsed.FindImageLayerBlobs() {
+		signature := layer.Annotations[keppel.CosignSignatureAnnotation]
+		if layer.MediaType != keppel.CosignSimpleSigningMediaType || signature == "" {
+			continue
+		}
+		hasSignatures = true
+
+		payload, err := j.readSignaturePayload(ctx, account, repo, layer.Digest)
+		if err != nil {
+			return false, true, err
+		}
+		err = keppel.VerifyCosignSignature(manifestDigest, payload, signature, keys)
+		if err == nil {
+			return true, true, nil
+		}
+		logg.Debug("rejecting signature in %s@%s for manifest %s: %s", repo.FullName(), sigManifest.Digest, manifestDigest, err.Error())
+	}
+	return false, hasSignatures, nil
+}
+
+func (j *Janitor) readSignaturePayload(ctx context.Context, account models.ReducedAccount, repo models.Repository, blobDigest digest.Digest) ([]byte, error) {
+	blob, err := keppel.FindBlobByRepository(j.db, blobDigest, repo)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find signature payload blob %s: %w", blobDigest, err)
+	}
+	if blob.StorageID == "" {
+		return nil, fmt.Errorf("signature payload blob %s is not available yet", blobDigest)
+	}
+	if blob.SizeBytes > maxSignaturePayloadBytes {
+		return nil, fmt.Errorf("signature payload blob %s is too large (%d bytes)", blobDigest, blob.SizeBytes)
+	}
+
+	reader, _, err := j.sd.ReadBlob(ctx, j.cfg.BlobStorageLocation(account, *blob), blob.StorageID)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, maxSignaturePayloadBytes))
+}
@@ -0,0 +1,122 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestSignatureVerificationJob(t *testing.T) {
+	j, s := setup(t)
+	job := j.SignatureVerificationJob(s.Registry)
+
+	trustedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	mustDo(t, err)
+	untrustedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	mustDo(t, err)
+	marshalPEM := func(key *ecdsa.PublicKey) string {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		mustDo(t, err)
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+	mustExec(t, s.DB, `UPDATE accounts SET require_signature_mode = $1, signature_public_keys = $2 WHERE name = $3`,
+		models.SignatureRejectUnsigned, marshalPEM(&trustedKey.PublicKey), "test1")
+
+	// setup: one image with a valid signature, one with a signature from an
+	// untrusted key, one without signature, and a signed image list
+	signedImage := test.GenerateImage(test.GenerateExampleLayer(1))
+	badlySignedImage := test.GenerateImage(test.GenerateExampleLayer(2))
+	unsignedImage := test.GenerateImage(test.GenerateExampleLayer(3))
+	list := test.GenerateImageList(test.GenerateImage(test.GenerateExampleLayer(4)), test.GenerateImage(test.GenerateExampleLayer(5)))
+	signedImage.MustUpload(t, s, fooRepoRef, "signed")
+	badlySignedImage.MustUpload(t, s, fooRepoRef, "badly-signed")
+	unsignedImage.MustUpload(t, s, fooRepoRef, "unsigned")
+	list.MustUpload(t, s, fooRepoRef, "list")
+
+	signature := test.GenerateCosignSignature(trustedKey, signedImage.Manifest.Digest)
+	signature.MustUpload(t, s, fooRepoRef, keppel.CosignSignatureTagName(signedImage.Manifest.Digest))
+	badSignature := test.GenerateCosignSignature(untrustedKey, badlySignedImage.Manifest.Digest)
+	badSignature.MustUpload(t, s, fooRepoRef, keppel.CosignSignatureTagName(badlySignedImage.Manifest.Digest))
+	listSignature := test.GenerateCosignSignature(trustedKey, list.Manifest.Digest)
+	listSignature.MustUpload(t, s, fooRepoRef, keppel.CosignSignatureTagName(list.Manifest.Digest))
+
+	expectSignatureStatus := func(manifestDigest digest.Digest, expected models.SignatureStatus) {
+		t.Helper()
+		actual, err := s.DB.SelectStr(`SELECT signature_status FROM manifests WHERE digest = $1`, manifestDigest)
+		mustDo(t, err)
+		assert.DeepEqual(t, "signature status of "+manifestDigest.String(), models.SignatureStatus(actual), expected)
+	}
+	processAll := func(expectedCount int) {
+		t.Helper()
+		for range expectedCount {
+			expectSuccess(t, job.ProcessOne(s.Ctx))
+		}
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s.Ctx))
+	}
+
+	// first pass: all 9 manifests are verified
+	processAll(9)
+	expectSignatureStatus(signedImage.Manifest.Digest, models.SignatureValid)
+	expectSignatureStatus(badlySignedImage.Manifest.Digest, models.SignatureInvalid)
+	expectSignatureStatus(unsignedImage.Manifest.Digest, models.SignatureMissing)
+	expectSignatureStatus(list.Manifest.Digest, models.SignatureValid)
+	for _, image := range list.Images {
+		// submanifests inherit the signature of their parent
+		expectSignatureStatus(image.Manifest.Digest, models.SignatureValid)
+	}
+	for _, image := range []test.Image{signature, badSignature, listSignature} {
+		expectSignatureStatus(image.Manifest.Digest, models.SignatureExempt)
+	}
+
+	// pushing a signature for the unsigned image makes it eligible for verification immediately
+	lateSignature := test.GenerateCosignSignature(trustedKey, unsignedImage.Manifest.Digest)
+	lateSignature.MustUpload(t, s, fooRepoRef, keppel.CosignSignatureTagName(unsignedImage.Manifest.Digest))
+	processAll(2) // the signed manifest and the new signature manifest
+	expectSignatureStatus(unsignedImage.Manifest.Digest, models.SignatureValid)
+	expectSignatureStatus(lateSignature.Manifest.Digest, models.SignatureExempt)
+
+	// untrusted manifests are rechecked soon, trusted ones only after a day
+	s.Clock.StepBy(10 * time.Minute)
+	processAll(1) // only the badly signed image
+	s.Clock.StepBy(24 * time.Hour)
+	processAll(10)
+
+	// when the set of trusted keys changes, the next check uses the new keys
+	mustExec(t, s.DB, `UPDATE accounts SET signature_public_keys = $1 WHERE name = $2`,
+		marshalPEM(&untrustedKey.PublicKey), "test1")
+	s.Clock.StepBy(25 * time.Hour)
+	processAll(10)
+	expectSignatureStatus(signedImage.Manifest.Digest, models.SignatureInvalid)
+	expectSignatureStatus(badlySignedImage.Manifest.Digest, models.SignatureValid)
+	expectSignatureStatus(signature.Manifest.Digest, models.SignatureExempt)
+}
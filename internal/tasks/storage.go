@@ -21,6 +21,7 @@ package tasks
 import (
 	"context"
 	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -69,61 +70,91 @@ func (j *Janitor) StorageSweepJob(registerer prometheus.Registerer) jobloop.Job
 			err = j.db.SelectOne(&account, storageSweepSearchQuery, j.timeNow())
 			return account, err
 		},
-		ProcessTask: j.sweepStorage,
+		ProcessTask: trackTask(j, "storage_sweep", j.sweepStorage),
 	}).Setup(registerer)
 }
 
 func (j *Janitor) sweepStorage(ctx context.Context, account models.Account, _ prometheus.Labels) error {
 	reducedAccount := account.Reduced()
 
-	// enumerate blobs and manifests in the backing storage
-	actualBlobs, actualManifests, err := j.sd.ListStorageContents(ctx, reducedAccount)
-	if err != nil {
-		return err
-	}
-
 	// when creating new entries in `unknown_blobs` and `unknown_manifests`, set
 	// the `can_be_deleted_at` timestamp such that the next pass 6 hours from now
 	// will sweep them (we don't use .Add(6 * time.Hour) to account for the
 	// marking taking some time)
 	canBeDeletedAt := j.timeNow().Add(4 * time.Hour)
 
-	// handle blobs and manifests separately
-	err = j.sweepBlobStorage(ctx, reducedAccount, actualBlobs, canBeDeletedAt)
+	// enumerate blobs and manifests in the backing storage, and handle them batch by batch
+	err := j.sd.ListStorageContents(ctx, reducedAccount,
+		func(actualBlobs []keppel.StoredBlobInfo) error {
+			return j.sweepBlobStorage(ctx, reducedAccount, actualBlobs, canBeDeletedAt)
+		},
+		func(actualManifests []keppel.StoredManifestInfo) error {
+			return j.sweepManifestStorage(ctx, reducedAccount, actualManifests, canBeDeletedAt)
+		},
+	)
 	if err != nil {
 		return err
 	}
-	err = j.sweepManifestStorage(ctx, reducedAccount, actualManifests, canBeDeletedAt)
-	if err != nil {
-		return err
+
+	// clean up marks that were not touched by the listing: unmark blobs and
+	// manifests that have been recorded in the database in the meantime, and
+	// forget about those that have been marked long enough, but were not seen
+	// in the backing storage anymore (this protects against unexpected errors
+	// e.g. because an operator deleted the blob between the mark and sweep
+	// phases, or if we deleted the blob from the backing storage in a previous
+	// sweep, but could not remove the unknown_blobs entry from the DB)
+	for _, query := range []string{storageSweepUnmarkBlobsQuery, storageSweepUnmarkManifestsQuery} {
+		_, err = j.db.Exec(query, account.Name)
+		if err != nil {
+			return err
+		}
+	}
+	for _, query := range []string{storageSweepForgetBlobsQuery, storageSweepForgetManifestsQuery} {
+		_, err = j.db.Exec(query, account.Name, j.timeNow())
+		if err != nil {
+			return err
+		}
 	}
 
 	_, err = j.db.Exec(storageSweepDoneQuery, account.Name, j.timeNow().Add(j.addJitter(6*time.Hour)))
 	return err
 }
 
+var storageSweepKnownBlobsQuery = sqlext.SimplifyWhitespace(`
+	SELECT storage_id FROM blobs
+	 WHERE account_name = $1 AND storage_id = ANY(string_to_array($2, ',')) AND NOT is_global
+	UNION
+	-- blobs in the backing storage may also correspond to uploads in progress
+	SELECT storage_id FROM uploads
+	 WHERE storage_id = ANY(string_to_array($2, ',')) AND repo_id IN (SELECT id FROM repos WHERE account_name = $1)
+`)
+
+var storageSweepMarkedBlobsQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM unknown_blobs WHERE account_name = $1 AND storage_id = ANY(string_to_array($2, ','))
+`)
+
+var storageSweepUnmarkBlobsQuery = sqlext.SimplifyWhitespace(`
+	DELETE FROM unknown_blobs
+	 WHERE account_name = $1 AND (
+	   storage_id IN (SELECT storage_id FROM blobs WHERE account_name = $1 AND NOT is_global)
+	   OR storage_id IN (SELECT storage_id FROM uploads WHERE repo_id IN (SELECT id FROM repos WHERE account_name = $1))
+	 )
+`)
+
+var storageSweepForgetBlobsQuery = sqlext.SimplifyWhitespace(`
+	DELETE FROM unknown_blobs WHERE account_name = $1 AND can_be_deleted_at < $2
+`)
+
 func (j *Janitor) sweepBlobStorage(ctx context.Context, account models.ReducedAccount, actualBlobs []keppel.StoredBlobInfo, canBeDeletedAt time.Time) error {
-	actualBlobsByStorageID := make(map[string]keppel.StoredBlobInfo, len(actualBlobs))
-	for _, blobInfo := range actualBlobs {
-		actualBlobsByStorageID[blobInfo.StorageID] = blobInfo
+	storageIDs := make([]string, len(actualBlobs))
+	for idx, blobInfo := range actualBlobs {
+		storageIDs[idx] = blobInfo.StorageID
 	}
+	storageIDsStr := strings.Join(storageIDs, ",")
 
-	// enumerate blobs known to the DB
+	// find which of these blobs are known to the DB
 	isKnownStorageID := make(map[string]bool)
-	query := `SELECT storage_id FROM blobs WHERE account_name = $1`
-	err := sqlext.ForeachRow(j.db, query, []any{account.Name}, func(rows *sql.Rows) error {
-		var storageID string
-		err := rows.Scan(&storageID)
-		isKnownStorageID[storageID] = true
-		return err
-	})
-	if err != nil {
-		return err
-	}
-
-	// blobs in the backing storage may also correspond to uploads in progress
-	query = `SELECT storage_id FROM uploads WHERE repo_id IN (SELECT id FROM repos WHERE account_name = $1)`
-	err = sqlext.ForeachRow(j.db, query, []any{account.Name}, func(rows *sql.Rows) error {
+	err := sqlext.ForeachRow(j.db, storageSweepKnownBlobsQuery, []any{account.Name, storageIDsStr}, func(rows *sql.Rows) error {
 		var storageID string
 		err := rows.Scan(&storageID)
 		isKnownStorageID[storageID] = true
@@ -133,64 +164,57 @@ func (j *Janitor) sweepBlobStorage(ctx context.Context, account models.ReducedAc
 		return err
 	}
 
-	// unmark/sweep phase: enumerate all unknown blobs
+	// find which of these blobs have been marked in a previous pass
 	var unknownBlobs []models.UnknownBlob
-	_, err = j.db.Select(&unknownBlobs, `SELECT * FROM unknown_blobs WHERE account_name = $1`, account.Name)
+	_, err = j.db.Select(&unknownBlobs, storageSweepMarkedBlobsQuery, account.Name, storageIDsStr)
 	if err != nil {
 		return err
 	}
-	isMarkedStorageID := make(map[string]bool)
+	markedBlobs := make(map[string]models.UnknownBlob, len(unknownBlobs))
 	for _, unknownBlob := range unknownBlobs {
-		// unmark blobs that have been recorded in the database in the meantime
-		if isKnownStorageID[unknownBlob.StorageID] {
-			_, err = j.db.Delete(&unknownBlob)
-			if err != nil {
-				return err
-			}
+		markedBlobs[unknownBlob.StorageID] = unknownBlob
+	}
+
+	for _, blobInfo := range actualBlobs {
+		// blobs that have been recorded in the database in the meantime are
+		// unmarked by sweepStorage() once the listing is complete
+		if isKnownStorageID[blobInfo.StorageID] {
 			continue
 		}
 
-		// sweep blobs that have been marked long enough
-		isMarkedStorageID[unknownBlob.StorageID] = true
-		if unknownBlob.CanBeDeletedAt.Before(j.timeNow()) {
-			// only call DeleteBlob if we can still see the blob in the backing
-			// storage (this protects against unexpected errors e.g. because an
-			// operator deleted the blob between the mark and sweep phases, or if we
-			// deleted the blob from the backing storage in a previous sweep, but
-			// could not remove the unknown_blobs entry from the DB)
-			if blobInfo, exists := actualBlobsByStorageID[unknownBlob.StorageID]; exists {
-				// need to use different cleanup strategies depending on whether the
-				// blob upload was finalized or not
-				if blobInfo.ChunkCount > 0 {
-					logg.Info("storage sweep in account %s: removing unfinalized blob stored at %s with %d chunks",
-						account.Name, unknownBlob.StorageID, blobInfo.ChunkCount)
-					err = j.sd.AbortBlobUpload(ctx, account, unknownBlob.StorageID, blobInfo.ChunkCount)
-				} else {
-					logg.Info("storage sweep in account %s: removing finalized blob stored at %s",
-						account.Name, unknownBlob.StorageID)
-					err = j.sd.DeleteBlob(ctx, account, unknownBlob.StorageID)
-				}
-				if err != nil {
-					return err
-				}
-			}
-			_, err = j.db.Delete(&unknownBlob)
+		// mark phase: record newly discovered unknown blobs in the DB
+		unknownBlob, isMarked := markedBlobs[blobInfo.StorageID]
+		if !isMarked {
+			err := j.db.Insert(&models.UnknownBlob{
+				AccountName:    account.Name,
+				StorageID:      blobInfo.StorageID,
+				CanBeDeletedAt: canBeDeletedAt,
+			})
 			if err != nil {
 				return err
 			}
+			continue
 		}
-	}
 
-	// mark phase: record newly discovered unknown blobs in the DB
-	for storageID := range actualBlobsByStorageID {
-		if isKnownStorageID[storageID] || isMarkedStorageID[storageID] {
+		// sweep phase: delete blobs that have been marked long enough
+		if !unknownBlob.CanBeDeletedAt.Before(j.timeNow()) {
 			continue
 		}
-		err := j.db.Insert(&models.UnknownBlob{
-			AccountName:    account.Name,
-			StorageID:      storageID,
-			CanBeDeletedAt: canBeDeletedAt,
-		})
+		// need to use different cleanup strategies depending on whether the
+		// blob upload was finalized or not
+		if blobInfo.ChunkCount > 0 {
+			logg.Info("storage sweep in account %s: removing unfinalized blob stored at %s with %d chunks",
+				account.Name, unknownBlob.StorageID, blobInfo.ChunkCount)
+			err = j.sd.AbortBlobUpload(ctx, account, unknownBlob.StorageID, blobInfo.ChunkCount)
+		} else {
+			logg.Info("storage sweep in account %s: removing finalized blob stored at %s",
+				account.Name, unknownBlob.StorageID)
+			err = j.sd.DeleteBlob(ctx, account, unknownBlob.StorageID)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = j.db.Delete(&unknownBlob)
 		if err != nil {
 			return err
 		}
@@ -199,16 +223,42 @@ func (j *Janitor) sweepBlobStorage(ctx context.Context, account models.ReducedAc
 	return nil
 }
 
+var storageSweepKnownManifestsQuery = sqlext.SimplifyWhitespace(`
+	SELECT r.name, m.digest FROM repos r JOIN manifests m ON m.repo_id = r.id
+	 WHERE r.account_name = $1
+	   AND (r.name, m.digest) IN (SELECT * FROM unnest(string_to_array($2, ','), string_to_array($3, ',')))
+`)
+
+var storageSweepMarkedManifestsQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM unknown_manifests
+	 WHERE account_name = $1
+	   AND (repo_name, digest) IN (SELECT * FROM unnest(string_to_array($2, ','), string_to_array($3, ',')))
+`)
+
+var storageSweepUnmarkManifestsQuery = sqlext.SimplifyWhitespace(`
+	DELETE FROM unknown_manifests um
+	 WHERE um.account_name = $1 AND EXISTS (
+	   SELECT 1 FROM repos r JOIN manifests m ON m.repo_id = r.id
+	    WHERE r.account_name = $1 AND r.name = um.repo_name AND m.digest = um.digest
+	 )
+`)
+
+var storageSweepForgetManifestsQuery = sqlext.SimplifyWhitespace(`
+	DELETE FROM unknown_manifests WHERE account_name = $1 AND can_be_deleted_at < $2
+`)
+
 func (j *Janitor) sweepManifestStorage(ctx context.Context, account models.ReducedAccount, actualManifests []keppel.StoredManifestInfo, canBeDeletedAt time.Time) error {
-	isActualManifest := make(map[keppel.StoredManifestInfo]bool, len(actualManifests))
-	for _, m := range actualManifests {
-		isActualManifest[m] = true
+	repoNames := make([]string, len(actualManifests))
+	digests := make([]string, len(actualManifests))
+	for idx, m := range actualManifests {
+		repoNames[idx] = m.RepoName
+		digests[idx] = m.Digest.String()
 	}
+	queryArgs := []any{account.Name, strings.Join(repoNames, ","), strings.Join(digests, ",")}
 
-	// enumerate manifests known to the DB
+	// find which of these manifests are known to the DB
 	isKnownManifest := make(map[keppel.StoredManifestInfo]bool)
-	query := `SELECT r.name, m.digest FROM repos r JOIN manifests m ON m.repo_id = r.id WHERE r.account_name = $1`
-	err := sqlext.ForeachRow(j.db, query, []any{account.Name}, func(rows *sql.Rows) error {
+	err := sqlext.ForeachRow(j.db, storageSweepKnownManifestsQuery, queryArgs, func(rows *sql.Rows) error {
 		var m keppel.StoredManifestInfo
 		err := rows.Scan(&m.RepoName, &m.Digest)
 		isKnownManifest[m] = true
@@ -218,62 +268,53 @@ func (j *Janitor) sweepManifestStorage(ctx context.Context, account models.Reduc
 		return err
 	}
 
-	// unmark/sweep phase: enumerate all unknown manifests
+	// find which of these manifests have been marked in a previous pass
 	var unknownManifests []models.UnknownManifest
-	_, err = j.db.Select(&unknownManifests, `SELECT * FROM unknown_manifests WHERE account_name = $1`, account.Name)
+	_, err = j.db.Select(&unknownManifests, storageSweepMarkedManifestsQuery, queryArgs...)
 	if err != nil {
 		return err
 	}
-	isMarkedManifest := make(map[keppel.StoredManifestInfo]bool)
+	markedManifests := make(map[keppel.StoredManifestInfo]models.UnknownManifest, len(unknownManifests))
 	for _, unknownManifest := range unknownManifests {
-		unknownManifestInfo := keppel.StoredManifestInfo{
+		markedManifests[keppel.StoredManifestInfo{
 			RepoName: unknownManifest.RepositoryName,
 			Digest:   unknownManifest.Digest,
-		}
+		}] = unknownManifest
+	}
 
-		// unmark manifests that have been recorded in the database in the meantime
-		if isKnownManifest[unknownManifestInfo] {
-			_, err = j.db.Delete(&unknownManifest)
-			if err != nil {
-				return err
-			}
+	for _, manifest := range actualManifests {
+		// manifests that have been recorded in the database in the meantime are
+		// unmarked by sweepStorage() once the listing is complete
+		if isKnownManifest[manifest] {
 			continue
 		}
 
-		// sweep manifests that have been marked long enough
-		isMarkedManifest[unknownManifestInfo] = true
-		if unknownManifest.CanBeDeletedAt.Before(j.timeNow()) {
-			// only call DeleteManifest if we can still see the manifest in the
-			// backing storage (this protects against unexpected errors e.g. because
-			// an operator deleted the manifest between the mark and sweep phases, or
-			// if we deleted the manifest from the backing storage in a previous
-			// sweep, but could not remove the unknown_manifests entry from the DB)
-			if isActualManifest[unknownManifestInfo] {
-				logg.Info("storage sweep in account %s: removing manifest %s/%s",
-					account.Name, unknownManifest.RepositoryName, unknownManifest.Digest)
-				err := j.sd.DeleteManifest(ctx, account, unknownManifest.RepositoryName, unknownManifest.Digest)
-				if err != nil {
-					return err
-				}
-			}
-			_, err = j.db.Delete(&unknownManifest)
+		// mark phase: record newly discovered unknown manifests in the DB
+		unknownManifest, isMarked := markedManifests[manifest]
+		if !isMarked {
+			err := j.db.Insert(&models.UnknownManifest{
+				AccountName:    account.Name,
+				RepositoryName: manifest.RepoName,
+				Digest:         manifest.Digest,
+				CanBeDeletedAt: canBeDeletedAt,
+			})
 			if err != nil {
 				return err
 			}
+			continue
 		}
-	}
 
-	// mark phase: record newly discovered unknown manifests in the DB
-	for manifest := range isActualManifest {
-		if isKnownManifest[manifest] || isMarkedManifest[manifest] {
+		// sweep phase: delete manifests that have been marked long enough
+		if !unknownManifest.CanBeDeletedAt.Before(j.timeNow()) {
 			continue
 		}
-		err := j.db.Insert(&models.UnknownManifest{
-			AccountName:    account.Name,
-			RepositoryName: manifest.RepoName,
-			Digest:         manifest.Digest,
-			CanBeDeletedAt: canBeDeletedAt,
-		})
+		logg.Info("storage sweep in account %s: removing manifest %s/%s",
+			account.Name, unknownManifest.RepositoryName, unknownManifest.Digest)
+		err := j.sd.DeleteManifest(ctx, account, unknownManifest.RepositoryName, unknownManifest.Digest)
+		if err != nil {
+			return err
+		}
+		_, err = j.db.Delete(&unknownManifest)
 		if err != nil {
 			return err
 		}
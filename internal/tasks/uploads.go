@@ -63,7 +63,7 @@ func (j *Janitor) AbandonedUploadCleanupJob(registerer prometheus.Registerer) jo
 			err = tx.SelectOne(&upload, abandonedUploadSearchQuery, maxUpdatedAt)
 			return upload, err
 		},
-		ProcessRow: j.deleteAbandonedUpload,
+		ProcessRow: trackTxTask(j, "abandoned_upload_cleanup", j.deleteAbandonedUpload),
 	}).Setup(registerer)
 }
 
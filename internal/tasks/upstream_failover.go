@@ -0,0 +1,111 @@
+/*******************************************************************************
+*
+* Copyright 2026 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// how often the primary upstream of each replica account with a failover upstream is checked
+const upstreamHealthCheckInterval = 1 * time.Minute
+
+var upstreamHealthCheckSearchQuery = sqlext.SimplifyWhitespace(`
+	SELECT * FROM accounts
+		WHERE failover_upstream != '' AND NOT is_deleting
+		AND (next_upstream_check_at IS NULL OR next_upstream_check_at < $1)
+	-- accounts without any checks first, then sorted by last check
+	ORDER BY next_upstream_check_at IS NULL DESC, next_upstream_check_at ASC
+	-- only one account at a time
+	LIMIT 1
+`)
+
+var upstreamHealthCheckDoneQuery = sqlext.SimplifyWhitespace(`
+	UPDATE accounts
+	   SET upstream_unreachable_since = $2, is_upstream_failover_active = $3, next_upstream_check_at = $4
+	 WHERE name = $1
+`)
+
+// UpstreamHealthCheckJob is a job. Each task finds a replica account with a
+// failover upstream whose primary upstream has not been checked in more than
+// a minute, and checks whether the primary upstream is reachable. Once the
+// primary upstream has been unreachable for as long as the account's
+// replication policy says, the account fails over to the failover upstream
+// until the primary upstream becomes reachable again. If no accounts need to
+// be checked, sql.ErrNoRows is returned to instruct the caller to slow down.
+func (j *Janitor) UpstreamHealthCheckJob(registerer prometheus.Registerer) jobloop.Job { //nolint: dupl // interface implementation of different things
+	return (&jobloop.ProducerConsumerJob[models.Account]{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "health check of upstreams for replica accounts with failover",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_upstream_health_checks",
+				Help: "Counter for health checks of the primary upstream of replica accounts with a failover upstream.",
+			},
+		},
+		DiscoverTask: func(_ context.Context, _ prometheus.Labels) (account models.Account, err error) {
+			err = j.db.SelectOne(&account, upstreamHealthCheckSearchQuery, j.timeNow())
+			return account, err
+		},
+		ProcessTask: trackTask(j, "upstream_health_check", j.checkUpstreamHealth),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) checkUpstreamHealth(ctx context.Context, account models.Account, _ prometheus.Labels) error {
+	// an unreachable primary is not a failure of this task, but the expected
+	// outcome of the check (which is reported through the failover gauge)
+	unreachableSince := account.UpstreamUnreachableSince
+	isFailoverActive := false
+	checkErr := j.processor().CheckPrimaryUpstream(ctx, account.Reduced())
+	if checkErr == nil {
+		unreachableSince = nil
+	} else {
+		now := j.timeNow()
+		if unreachableSince == nil {
+			unreachableSince = &now
+		}
+		failoverAfter := time.Duration(account.FailoverAfterSecs) * time.Second
+		isFailoverActive = now.Sub(*unreachableSince) >= failoverAfter
+	}
+
+	switch {
+	case isFailoverActive && !account.IsUpstreamFailoverActive:
+		logg.Info("failing over account %s to upstream %s since the primary upstream is unreachable: %s",
+			account.Name, account.FailoverUpstream, checkErr.Error())
+	case !isFailoverActive && account.IsUpstreamFailoverActive:
+		logg.Info("account %s is no longer failed over to upstream %s since the primary upstream is reachable again",
+			account.Name, account.FailoverUpstream)
+	}
+	gaugeValue := 0.0
+	if isFailoverActive {
+		gaugeValue = 1.0
+	}
+	UpstreamFailoverActiveGauge.With(prometheus.Labels{"account": string(account.Name)}).Set(gaugeValue)
+
+	_, err := j.db.Exec(upstreamHealthCheckDoneQuery, account.Name, unreachableSince, isFailoverActive,
+		j.timeNow().Add(j.addJitter(upstreamHealthCheckInterval)))
+	return err
+}
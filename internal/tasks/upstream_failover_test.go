@@ -0,0 +1,96 @@
+/*******************************************************************************
+*
+* Copyright 2026 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package tasks
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestUpstreamHealthCheckJob(t *testing.T) {
+	test.WithRoundTripper(func(tt *test.RoundTripper) {
+		_, s1 := setup(t)
+		j2, s2 := setupReplica(t, s1, "on_first_use")
+		job := j2.UpstreamHealthCheckJob(s2.Registry)
+
+		// the replica's primary upstream is a peer that is currently down, and
+		// the usual primary takes the role of the failover upstream
+		tt.Handlers["registry-tertiary.example.org"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		})
+		mustDo(t, s2.DB.Insert(&models.Peer{HostName: "registry-tertiary.example.org"}))
+		mustExec(t, s2.DB,
+			`UPDATE accounts SET upstream_peer_hostname = $1, failover_upstream = $2, failover_after_secs = $3`,
+			"registry-tertiary.example.org", "registry.example.org", 600,
+		)
+
+		image := test.GenerateImage(test.GenerateExampleLayer(1))
+		image.MustUpload(t, s1, fooRepoRef, "")
+
+		expectAccountState := func(unreachableSince *time.Time, isFailoverActive bool) {
+			t.Helper()
+			account, err := keppel.FindAccount(s2.DB, "test1")
+			mustDo(t, err)
+			assert.DeepEqual(t, "UpstreamUnreachableSince", keppel.MaybeTimeToUnix(account.UpstreamUnreachableSince), keppel.MaybeTimeToUnix(unreachableSince))
+			assert.DeepEqual(t, "IsUpstreamFailoverActive", account.IsUpstreamFailoverActive, isFailoverActive)
+		}
+
+		// the first failed check does not trigger a failover yet
+		s2.Clock.StepBy(1 * time.Hour)
+		outageStartedAt := s2.Clock.Now()
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		expectAccountState(&outageStartedAt, false)
+
+		// no further checks are due until a minute has passed
+		expectError(t, sql.ErrNoRows.Error(), job.ProcessOne(s2.Ctx))
+
+		// once the primary upstream has been unreachable for long enough, the account fails over...
+		s2.Clock.StepBy(10 * time.Minute)
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		expectAccountState(&outageStartedAt, true)
+
+		// ...and replicates from the failover upstream instead
+		replicaToken := s2.GetToken(t, "repository:test1/foo:pull")
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         fmt.Sprintf("/v2/test1/foo/manifests/%s", image.Manifest.Digest),
+			Header:       map[string]string{"Authorization": "Bearer " + replicaToken},
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   assert.ByteData(image.Manifest.Contents),
+		}.Check(t, s2.Handler)
+
+		// when the primary upstream is reachable again, the failover ends
+		tt.Handlers["registry-tertiary.example.org"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		s2.Clock.StepBy(2 * time.Minute)
+		expectSuccess(t, job.ProcessOne(s2.Ctx))
+		expectAccountState(nil, false)
+	})
+}
@@ -0,0 +1,96 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/jobloop"
+	"github.com/sapcc/go-bits/sqlext"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// Storage usage only counts blobs that are actually backed by storage, i.e.
+// not blobs in replica accounts that have not been replicated yet. Tenants
+// without accounts are still reported if they have pulled anything before,
+// since their egress counter must not appear to go away.
+var usageReportQuery = sqlext.SimplifyWhitespace(`
+	WITH storage_stats AS (
+		SELECT a.auth_tenant_id, SUM(b.size_bytes) AS bytes
+		  FROM blobs b
+		  JOIN accounts a ON a.name = b.account_name
+		 WHERE b.storage_id != ''
+		 GROUP BY a.auth_tenant_id
+	), tenants AS (
+		SELECT auth_tenant_id FROM accounts
+		 UNION
+		SELECT auth_tenant_id FROM egress_counters
+	)
+	SELECT t.auth_tenant_id, COALESCE(s.bytes, 0), COALESCE(e.bytes, 0)
+	  FROM tenants t
+	  LEFT OUTER JOIN storage_stats s ON s.auth_tenant_id = t.auth_tenant_id
+	  LEFT OUTER JOIN egress_counters e ON e.auth_tenant_id = t.auth_tenant_id
+	 ORDER BY t.auth_tenant_id
+`)
+
+// UsageReportJob is a job that periodically reports the storage and egress
+// usage of each auth tenant through the configured UsageReportDriver, e.g. so
+// that an external billing system can charge for registry usage. It is only
+// started if a usage report driver is configured.
+func (j *Janitor) UsageReportJob(registerer prometheus.Registerer) jobloop.Job {
+	return (&jobloop.CronJob{
+		Metadata: jobloop.JobMetadata{
+			ReadableName: "reporting of per-tenant usage",
+			CounterOpts: prometheus.CounterOpts{
+				Name: "keppel_usage_reports",
+				Help: "Counter for reports of per-tenant storage and egress usage.",
+			},
+		},
+		Interval:     1 * time.Hour,
+		InitialDelay: 5 * time.Minute,
+		Task:         j.trackCronTask("usage_report", j.reportUsage),
+	}).Setup(registerer)
+}
+
+func (j *Janitor) reportUsage(ctx context.Context, _ prometheus.Labels) error {
+	report := keppel.UsageReport{
+		ReportedAt: j.timeNow(),
+		Tenants:    []keppel.TenantUsage{},
+	}
+	err := sqlext.ForeachRow(j.db, usageReportQuery, nil, func(rows *sql.Rows) error {
+		var u keppel.TenantUsage
+		err := rows.Scan(&u.AuthTenantID, &u.StorageBytes, &u.EgressBytes)
+		report.Tenants = append(report.Tenants, u)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("while collecting usage data: %w", err)
+	}
+
+	err = j.cfg.UsageReporter.ReportUsage(ctx, report)
+	if err != nil {
+		return fmt.Errorf("while sending usage report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package tasks
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sapcc/go-bits/assert"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+	"github.com/sapcc/keppel/internal/test"
+)
+
+func TestUsageReportJob(t *testing.T) {
+	j, s := setup(t,
+		test.WithUsageReporter,
+		test.WithAccount(models.Account{Name: "test2", AuthTenantID: "test2authtenant"}),
+	)
+	job := j.UsageReportJob(s.Registry)
+
+	image := test.GenerateImage(test.GenerateExampleLayer(1))
+	image.MustUpload(t, s, fooRepoRef, "")
+	storageBytes := uint64(len(image.Layers[0].Contents) + len(image.Config.Contents))
+
+	// before anything was pulled, only storage usage is reported
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	assert.DeepEqual(t, "usage reports", s.UsageReporter.Reports, []keppel.UsageReport{{
+		ReportedAt: s.Clock.Now(),
+		Tenants: []keppel.TenantUsage{
+			{AuthTenantID: "test1authtenant", StorageBytes: storageBytes, EgressBytes: 0},
+			{AuthTenantID: "test2authtenant", StorageBytes: 0, EgressBytes: 0},
+		},
+	}})
+
+	// pulling blobs (fully or partially) counts towards the egress of the account's tenant
+	token := s.GetToken(t, "repository:test1/foo:pull")
+	layer := image.Layers[0]
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/foo/blobs/" + layer.Digest.String(),
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   assert.ByteData(layer.Contents),
+	}.Check(t, s.Handler)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v2/test1/foo/blobs/" + layer.Digest.String(),
+		Header:       map[string]string{"Authorization": "Bearer " + token, "Range": "bytes=0-9"},
+		ExpectStatus: http.StatusPartialContent,
+		ExpectBody:   assert.ByteData(layer.Contents[0:10]),
+	}.Check(t, s.Handler)
+
+	// HEAD requests do not count as egress
+	assert.HTTPRequest{
+		Method:       "HEAD",
+		Path:         "/v2/test1/foo/blobs/" + layer.Digest.String(),
+		Header:       map[string]string{"Authorization": "Bearer " + token},
+		ExpectStatus: http.StatusOK,
+	}.Check(t, s.Handler)
+
+	// tenants whose accounts have all been deleted are still reported if they
+	// have an egress counter, since the counter must not appear to reset
+	mustExec(t, s.DB, `INSERT INTO egress_counters (auth_tenant_id, bytes) VALUES ($1, $2)`, "test3authtenant", 42)
+
+	s.Clock.StepBy(time.Hour)
+	expectSuccess(t, job.ProcessOne(s.Ctx))
+	assert.DeepEqual(t, "latest usage report", s.UsageReporter.Reports[1], keppel.UsageReport{
+		ReportedAt: s.Clock.Now(),
+		Tenants: []keppel.TenantUsage{
+			{AuthTenantID: "test1authtenant", StorageBytes: storageBytes, EgressBytes: uint64(len(layer.Contents) + 10)},
+			{AuthTenantID: "test2authtenant", StorageBytes: 0, EgressBytes: 0},
+			{AuthTenantID: "test3authtenant", StorageBytes: 0, EgressBytes: 42},
+		},
+	})
+}
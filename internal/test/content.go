@@ -20,17 +20,21 @@
 package test
 
 import (
+	"archive/tar"
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"math/rand"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/docker/distribution/manifest/manifestlist"
 	"github.com/docker/distribution/manifest/schema2"
 	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sapcc/go-bits/assert"
 
 	"github.com/sapcc/keppel/internal/models"
@@ -93,6 +97,33 @@ func GenerateExampleLayerSize(seed, sizeMiB int64) Bytes {
 	return newBytesWithMediaType(byteBuffer.Bytes(), schema2.MediaTypeLayer)
 }
 
+// GenerateExampleTarLayer generates an image layer containing the given
+// regular files (path -> contents) as a proper gzip-compressed tar archive.
+// Unlike the random data from GenerateExampleLayer, this can be used in tests
+// that look into the layer contents.
+func GenerateExampleTarLayer(files map[string]string) Bytes {
+	paths := slices.Sorted(maps.Keys(files))
+
+	var byteBuffer bytes.Buffer
+	gzw := gzip.NewWriter(&byteBuffer)
+	tw := tar.NewWriter(gzw)
+	for _, path := range paths {
+		contents := files[path]
+		tw.WriteHeader(&tar.Header{ //nolint: errcheck
+			Typeflag: tar.TypeReg,
+			Name:     path,
+			Mode:     0644,
+			Size:     int64(len(contents)),
+			ModTime:  time.Unix(0, 0),
+		})
+		tw.Write([]byte(contents)) //nolint: errcheck
+	}
+	tw.Close()
+	gzw.Close()
+
+	return newBytesWithMediaType(byteBuffer.Bytes(), schema2.MediaTypeLayer)
+}
+
 // Image contains all the pieces of a Docker image. The Layers and Config must
 // be uploaded to the registry as blobs.
 type Image struct {
@@ -228,6 +259,65 @@ func GenerateImageWithCustomConfig(change func(map[string]any), layers ...Bytes)
 	}
 }
 
+// GenerateEmptyConfigArtifact makes an Image that looks like an OCI artifact
+// with an empty config (media type "application/vnd.oci.empty.v1+json"), as
+// pushed e.g. by `oras push` when no config is given. Such artifacts carry
+// all their metadata in the given annotations.
+func GenerateEmptyConfigArtifact(artifactType string, annotations map[string]string, layers ...Bytes) Image {
+	return generateEmptyConfigArtifact(nil, artifactType, annotations, layers...)
+}
+
+// GenerateReferrerArtifact is like GenerateEmptyConfigArtifact, but the
+// resulting manifest refers to the given subject manifest (e.g. as an SBOM or
+// signature for it), so that it shows up in the referrers of the subject.
+func GenerateReferrerArtifact(subject Bytes, artifactType string, layers ...Bytes) Image {
+	return generateEmptyConfigArtifact(&subject, artifactType, nil, layers...)
+}
+
+func generateEmptyConfigArtifact(subject *Bytes, artifactType string, annotations map[string]string, layers ...Bytes) Image {
+	configBytesObj := newBytesWithMediaType([]byte("{}"), imagespec.MediaTypeEmptyJSON)
+
+	layerDescs := []map[string]any{}
+	for _, layer := range layers {
+		layerDescs = append(layerDescs, map[string]any{
+			"mediaType": layer.MediaType,
+			"size":      len(layer.Contents),
+			"digest":    layer.Digest,
+		})
+	}
+	manifestData := map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     imagespec.MediaTypeImageManifest,
+		"artifactType":  artifactType,
+		"config": assert.JSONObject{
+			"mediaType": configBytesObj.MediaType,
+			"size":      len(configBytesObj.Contents),
+			"digest":    configBytesObj.Digest,
+		},
+		"layers": layerDescs,
+	}
+	if subject != nil {
+		manifestData["subject"] = assert.JSONObject{
+			"mediaType": subject.MediaType,
+			"size":      len(subject.Contents),
+			"digest":    subject.Digest,
+		}
+	}
+	if len(annotations) > 0 {
+		manifestData["annotations"] = annotations
+	}
+	manifestBytes, err := json.Marshal(manifestData)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return Image{
+		Layers:   layers,
+		Config:   configBytesObj,
+		Manifest: newBytesWithMediaType(manifestBytes, imagespec.MediaTypeImageManifest),
+	}
+}
+
 // SizeBytes returns the value that we expect in the DB column
 // `manifests.size_bytes` for this image.
 func (i Image) SizeBytes() uint64 {
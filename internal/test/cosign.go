@@ -0,0 +1,112 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// GenerateCosignSignature makes an Image that looks like the signature that
+// `cosign sign` would upload for the manifest with the given digest. It must be
+// uploaded with the tag name from keppel.CosignSignatureTagName().
+func GenerateCosignSignature(key *ecdsa.PrivateKey, manifestDigest digest.Digest) Image {
+	payload := fmt.Sprintf(
+		`{"critical":{"identity":{"docker-reference":"registry.example.org/test1/foo"},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`,
+		manifestDigest,
+	)
+	payloadBytes := newBytesWithMediaType([]byte(payload), keppel.CosignSimpleSigningMediaType)
+	hash := sha256.Sum256(payloadBytes.Contents)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// GenerateImage() does not know about annotations, so we need to add the
+	// signature into the layer descriptor afterwards
+	image := GenerateImage(payloadBytes)
+	var manifestData map[string]any
+	err = json.Unmarshal(image.Manifest.Contents, &manifestData)
+	if err != nil {
+		panic(err.Error())
+	}
+	layerDesc := manifestData["layers"].([]any)[0].(map[string]any)
+	layerDesc["annotations"] = map[string]string{
+		keppel.CosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signature),
+	}
+	manifestBytes, err := json.Marshal(manifestData)
+	if err != nil {
+		panic(err.Error())
+	}
+	image.Manifest = newBytesWithMediaType(manifestBytes, schema2.MediaTypeManifest)
+	return image
+}
+
+// GenerateCosignAttestation makes an Image that looks like the attestation
+// that `cosign attest` would upload for the manifest with the given digest and
+// the given predicate type. It must be uploaded with the tag name from
+// keppel.CosignAttestationTagName().
+func GenerateCosignAttestation(key *ecdsa.PrivateKey, manifestDigest digest.Digest, predicateType string) Image {
+	statement := fmt.Sprintf(
+		`{"_type":"https://in-toto.io/Statement/v0.1","predicateType":%q,"subject":[{"name":"registry.example.org/test1/foo","digest":{%q:%q}}],"predicate":{}}`,
+		predicateType, manifestDigest.Algorithm(), manifestDigest.Encoded(),
+	)
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(keppel.InTotoPayloadType), keppel.InTotoPayloadType, len(statement), statement)
+	hash := sha256.Sum256([]byte(pae))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		panic(err.Error())
+	}
+	envelope, err := json.Marshal(map[string]any{
+		"payloadType": keppel.InTotoPayloadType,
+		"payload":     base64.StdEncoding.EncodeToString([]byte(statement)),
+		"signatures":  []map[string]string{{"keyid": "", "sig": base64.StdEncoding.EncodeToString(signature)}},
+	})
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// like in GenerateCosignSignature(), the annotation needs to be added afterwards
+	image := GenerateImage(newBytesWithMediaType(envelope, keppel.DSSEEnvelopeMediaType))
+	var manifestData map[string]any
+	err = json.Unmarshal(image.Manifest.Contents, &manifestData)
+	if err != nil {
+		panic(err.Error())
+	}
+	layerDesc := manifestData["layers"].([]any)[0].(map[string]any)
+	layerDesc["annotations"] = map[string]string{
+		keppel.CosignPredicateTypeAnnotation: predicateType,
+	}
+	manifestBytes, err := json.Marshal(manifestData)
+	if err != nil {
+		panic(err.Error())
+	}
+	image.Manifest = newBytesWithMediaType(manifestBytes, schema2.MediaTypeManifest)
+	return image
+}
@@ -0,0 +1,70 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sapcc/keppel/internal/keppel"
+	"github.com/sapcc/keppel/internal/models"
+)
+
+// CDNDriver (driver ID "unittest") is a keppel.CDNDriver for unit tests. It
+// generates CDN URLs only for accounts listed in EnabledAccounts, and records
+// all blob invalidations.
+type CDNDriver struct {
+	EnabledAccounts map[models.AccountName]bool
+	InvalidatedURLs []string
+}
+
+func init() {
+	keppel.CDNDriverRegistry.Add(func() keppel.CDNDriver { return &CDNDriver{} })
+}
+
+// PluginTypeID implements the keppel.CDNDriver interface.
+func (d *CDNDriver) PluginTypeID() string { return "unittest" }
+
+// Init implements the keppel.CDNDriver interface.
+func (d *CDNDriver) Init(ad keppel.AuthDriver, cfg keppel.Configuration) error {
+	d.EnabledAccounts = make(map[models.AccountName]bool)
+	return nil
+}
+
+// URLForBlob implements the keppel.CDNDriver interface.
+func (d *CDNDriver) URLForBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob, now time.Time) (string, error) {
+	if !d.EnabledAccounts[account.Name] {
+		return "", keppel.ErrCannotGenerateURL
+	}
+	return fmt.Sprintf("%s?expires=%d", d.urlForBlob(account, blob), now.Add(10*time.Minute).Unix()), nil
+}
+
+// InvalidateBlob implements the keppel.CDNDriver interface.
+func (d *CDNDriver) InvalidateBlob(ctx context.Context, account models.ReducedAccount, blob models.Blob) error {
+	if d.EnabledAccounts[account.Name] {
+		d.InvalidatedURLs = append(d.InvalidatedURLs, d.urlForBlob(account, blob))
+	}
+	return nil
+}
+
+func (d *CDNDriver) urlForBlob(account models.ReducedAccount, blob models.Blob) string {
+	return fmt.Sprintf("https://cdn.example.org/%s/%s", account.Name, blob.Digest)
+}
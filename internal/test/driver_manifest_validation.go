@@ -0,0 +1,54 @@
+/******************************************************************************
+*
+*  Copyright 2026 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package test
+
+import (
+	"context"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// ManifestValidationDriver (driver ID "unittest") is a
+// keppel.ManifestValidationDriver for unit tests. It records all requests that
+// it receives, and rejects all manifests while RejectionReason is set.
+type ManifestValidationDriver struct {
+	Requests        []keppel.ManifestValidationRequest
+	RejectionReason string
+}
+
+func init() {
+	keppel.ManifestValidationDriverRegistry.Add(func() keppel.ManifestValidationDriver { return &ManifestValidationDriver{} })
+}
+
+// PluginTypeID implements the keppel.ManifestValidationDriver interface.
+func (d *ManifestValidationDriver) PluginTypeID() string { return "unittest" }
+
+// Init implements the keppel.ManifestValidationDriver interface.
+func (d *ManifestValidationDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	return nil
+}
+
+// ValidateManifest implements the keppel.ManifestValidationDriver interface.
+func (d *ManifestValidationDriver) ValidateManifest(ctx context.Context, req keppel.ManifestValidationRequest) (keppel.ManifestValidationVerdict, error) {
+	d.Requests = append(d.Requests, req)
+	if d.RejectionReason != "" {
+		return keppel.ManifestValidationVerdict{Allowed: false, Reason: d.RejectionReason}, nil
+	}
+	return keppel.ManifestValidationVerdict{Allowed: true}, nil
+}
@@ -0,0 +1,49 @@
+/******************************************************************************
+*
+*  Copyright 2025 SAP SE
+*
+*  Licensed under the Apache License, Version 2.0 (the "License");
+*  you may not use this file except in compliance with the License.
+*  You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+*
+******************************************************************************/
+
+package test
+
+import (
+	"context"
+
+	"github.com/sapcc/keppel/internal/keppel"
+)
+
+// UsageReportDriver (driver ID "unittest") is a keppel.UsageReportDriver for
+// unit tests. It records all reports that it receives.
+type UsageReportDriver struct {
+	Reports []keppel.UsageReport
+}
+
+func init() {
+	keppel.UsageReportDriverRegistry.Add(func() keppel.UsageReportDriver { return &UsageReportDriver{} })
+}
+
+// PluginTypeID implements the keppel.UsageReportDriver interface.
+func (d *UsageReportDriver) PluginTypeID() string { return "unittest" }
+
+// Init implements the keppel.UsageReportDriver interface.
+func (d *UsageReportDriver) Init(ctx context.Context, cfg keppel.Configuration) error {
+	return nil
+}
+
+// ReportUsage implements the keppel.UsageReportDriver interface.
+func (d *UsageReportDriver) ReportUsage(ctx context.Context, report keppel.UsageReport) error {
+	d.Reports = append(d.Reports, report)
+	return nil
+}
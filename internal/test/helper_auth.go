@@ -53,6 +53,13 @@ func (s Setup) GetDomainRemappedToken(t *testing.T, accountName models.AccountNa
 	return s.getToken(t, auth.Audience{IsAnycast: false, AccountName: accountName}, scopes...)
 }
 
+// GetVanityDomainToken is like GetToken, but instead returns a token for the
+// given vanity domain of the given account.
+func (s Setup) GetVanityDomainToken(t *testing.T, accountName models.AccountName, hostname string, scopes ...string) string {
+	t.Helper()
+	return s.getToken(t, auth.Audience{IsAnycast: false, AccountName: accountName, VanityHostname: hostname}, scopes...)
+}
+
 func (s Setup) getToken(t *testing.T, audience auth.Audience, scopes ...string) string {
 	t.Helper()
 
@@ -26,12 +26,17 @@ import (
 	"github.com/sapcc/keppel/internal/models"
 )
 
+// Returns the account in whose storage location the contents of the given
+// blob are stored.
+func (s Setup) blobStorageLocation(blob models.Blob) models.ReducedAccount {
+	return s.Config.BlobStorageLocation(models.ReducedAccount{Name: blob.AccountName}, blob)
+}
+
 // ExpectBlobsExistInStorage is a test assertion.
 func (s Setup) ExpectBlobsExistInStorage(t *testing.T, blobs ...models.Blob) {
 	t.Helper()
 	for _, blob := range blobs {
-		account := models.ReducedAccount{Name: blob.AccountName}
-		readCloser, sizeBytes, err := s.SD.ReadBlob(s.Ctx, account, blob.StorageID)
+		readCloser, sizeBytes, err := s.SD.ReadBlob(s.Ctx, s.blobStorageLocation(blob), blob.StorageID)
 		if err != nil {
 			t.Errorf("expected blob %s to exist in the storage, but got: %s", blob.Digest, err.Error())
 			continue
@@ -70,8 +75,7 @@ func (s Setup) ExpectBlobsExistInStorage(t *testing.T, blobs ...models.Blob) {
 func (s Setup) ExpectBlobsMissingInStorage(t *testing.T, blobs ...models.Blob) {
 	t.Helper()
 	for _, blob := range blobs {
-		account := models.ReducedAccount{Name: blob.AccountName}
-		_, _, err := s.SD.ReadBlob(s.Ctx, account, blob.StorageID)
+		_, _, err := s.SD.ReadBlob(s.Ctx, s.blobStorageLocation(blob), blob.StorageID)
 		if err == nil {
 			t.Errorf("expected blob %s to be missing in the storage, but could read it", blob.Digest)
 			continue
@@ -25,6 +25,7 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/prometheus/client_golang/prometheus"
@@ -43,6 +44,7 @@ import (
 	registryv2 "github.com/sapcc/keppel/internal/api/registry"
 	"github.com/sapcc/keppel/internal/drivers/basic"
 	"github.com/sapcc/keppel/internal/drivers/trivial"
+	trivydriver "github.com/sapcc/keppel/internal/drivers/trivy"
 	"github.com/sapcc/keppel/internal/keppel"
 	"github.com/sapcc/keppel/internal/models"
 	"github.com/sapcc/keppel/internal/trivy"
@@ -56,9 +58,18 @@ type setupParams struct {
 	WithPeerAPI             bool
 	WithTrivyDouble         bool
 	WithQuotas              bool
+	WithAuditEventStore     bool
+	WithUploadCoordinator   bool
+	WithUsageReporter       bool
+	WithManifestValidator   bool
+	WithGlobalBlobStore     bool
+	WithManifestPullCounts  bool
 	WithPreviousIssuerKey   bool
 	WithoutCurrentIssuerKey bool
+	WithSharedStorage       bool
+	WithOCIDistSpecV11      bool
 	RateLimitEngine         *keppel.RateLimitEngine
+	AuthRealmOverrides      map[models.AccountName]keppel.AuthRealmOverride
 	SetupOfPrimary          *Setup
 	Accounts                []*models.Account
 	Repos                   []*models.Repository
@@ -85,6 +96,19 @@ func WithAnycast(withAnycast bool) SetupOption {
 	}
 }
 
+// WithSharedStorage is a SetupOption for use with IsSecondaryTo(). It
+// simulates that the secondary uses the same storage backend as the primary,
+// so that blobs can be replicated by copying them within the storage.
+func WithSharedStorage(params *setupParams) {
+	params.WithSharedStorage = true
+}
+
+// WithOCIDistributionSpecV11 is a SetupOption that enables the additions from
+// version 1.1 of the OCI Distribution Spec in the registry API.
+func WithOCIDistributionSpecV11(params *setupParams) {
+	params.WithOCIDistSpecV11 = true
+}
+
 // WithKeppelAPI is a SetupOption that enables the Keppel API.
 func WithKeppelAPI(params *setupParams) {
 	params.WithKeppelAPI = true
@@ -105,6 +129,36 @@ func WithQuotas(params *setupParams) {
 	params.WithQuotas = true
 }
 
+// WithManifestPullCounts is a SetupOption that enables counting of pulls per manifest and day.
+func WithManifestPullCounts(params *setupParams) {
+	params.WithManifestPullCounts = true
+}
+
+// WithAuditEventStore is a SetupOption that enables persistence of audit events in the DB.
+func WithAuditEventStore(params *setupParams) {
+	params.WithAuditEventStore = true
+}
+
+// WithUploadCoordinator is a SetupOption that enables Redis-based locking of blob uploads in the registry API.
+func WithUploadCoordinator(params *setupParams) {
+	params.WithUploadCoordinator = true
+}
+
+// WithUsageReporter is a SetupOption that enables usage reporting with a UsageReportDriver double.
+func WithUsageReporter(params *setupParams) {
+	params.WithUsageReporter = true
+}
+
+// WithManifestValidator is a SetupOption that enables external manifest validation with a ManifestValidationDriver double.
+func WithManifestValidator(params *setupParams) {
+	params.WithManifestValidator = true
+}
+
+// WithGlobalBlobStore is a SetupOption that configures a global blob store.
+func WithGlobalBlobStore(params *setupParams) {
+	params.WithGlobalBlobStore = true
+}
+
 // WithRateLimitEngine is a SetupOption to use a RateLimitEngine in enabled APIs.
 func WithRateLimitEngine(rle *keppel.RateLimitEngine) SetupOption {
 	return func(params *setupParams) {
@@ -112,6 +166,16 @@ func WithRateLimitEngine(rle *keppel.RateLimitEngine) SetupOption {
 	}
 }
 
+// WithAuthRealmOverride is a SetupOption that fills Configuration.AuthRealmOverrides.
+func WithAuthRealmOverride(accountName models.AccountName, override keppel.AuthRealmOverride) SetupOption {
+	return func(params *setupParams) {
+		if params.AuthRealmOverrides == nil {
+			params.AuthRealmOverrides = make(map[models.AccountName]keppel.AuthRealmOverride)
+		}
+		params.AuthRealmOverrides[accountName] = override
+	}
+}
+
 // WithAccount is a SetupOption that adds the given keppel.Account to the DB during NewSetup().
 func WithAccount(account models.Account) SetupOption {
 	return func(params *setupParams) {
@@ -158,11 +222,15 @@ type Setup struct {
 	FD           *FederationDriver
 	SD           *trivial.StorageDriver
 	ICD          *InboundCacheDriver
+	CDN          *CDNDriver
 	Handler      http.Handler
 	Ctx          context.Context //nolint: containedctx  // only used in tests
 	Registry     *prometheus.Registry
 	// fields that are only set if the respective With... setup option is included
-	TrivyDouble *TrivyDouble
+	TrivyDouble       *TrivyDouble
+	UploadCoordinator *keppel.UploadCoordinator
+	UsageReporter     *UsageReportDriver
+	ManifestValidator *ManifestValidationDriver
 	// fields that are filled by WithAccount and WithRepo (in order)
 	Accounts []*models.Account
 	Repos    []*models.Repository
@@ -208,12 +276,22 @@ func NewSetup(t *testing.T, opts ...SetupOption) Setup {
 	// build keppel.Configuration
 	s := Setup{
 		Config: keppel.Configuration{
-			APIPublicHostname: apiPublicHostname,
+			APIPublicHostname:            apiPublicHostname,
+			AuthRealmOverrides:           params.AuthRealmOverrides,
+			PeersShareStorage:            params.WithSharedStorage,
+			EnableOCIDistributionSpecV11: params.WithOCIDistSpecV11,
+			ManifestPullCountsEnabled:    params.WithManifestPullCounts,
 		},
 		Ctx:        context.Background(),
 		Registry:   prometheus.NewPedanticRegistry(),
 		tokenCache: make(map[string]string),
 	}
+	if params.WithGlobalBlobStore {
+		s.Config.GlobalBlobStore = &models.ReducedAccount{
+			Name:         keppel.GlobalBlobStoreAccountName,
+			AuthTenantID: "globalblobstoretenant",
+		}
+	}
 
 	// select issuer keys
 	if params.WithoutCurrentIssuerKey && !params.WithPreviousIssuerKey {
@@ -237,8 +315,8 @@ func NewSetup(t *testing.T, opts ...SetupOption) Setup {
 			t.Fatal(err)
 		}
 
-		s.Config.Trivy = &trivy.Config{
-			URL: *trivyURL,
+		s.Config.Scanner = &trivydriver.ScannerDriver{
+			Endpoints: []trivy.Config{{URL: *trivyURL}},
 		}
 		if tt, ok := http.DefaultTransport.(*RoundTripper); ok {
 			tt.Handlers[trivyURL.Host] = httpapi.Compose(s.TrivyDouble)
@@ -249,8 +327,8 @@ func NewSetup(t *testing.T, opts ...SetupOption) Setup {
 	dbOpts := []easypg.TestSetupOption{
 		// manifest_manifest_refs needs a specialized cleanup strategy because of an "ON DELETE RESTRICT" constraint
 		easypg.ClearContentsWith(`DELETE FROM manifest_manifest_refs WHERE parent_digest NOT IN (SELECT child_digest FROM manifest_manifest_refs)`),
-		easypg.ClearTables("manifest_blob_refs", "accounts", "peers", "quotas"),
-		easypg.ResetPrimaryKeys("blobs", "repos"),
+		easypg.ClearTables("manifest_blob_refs", "accounts", "peers", "quotas", "janitor_jobs", "egress_counters", "blocked_digests", "global_blobs", "unknown_global_blobs"),
+		easypg.ResetPrimaryKeys("blobs", "repos", "tag_history", "robot_tokens", "blocked_digests"),
 	}
 	if params.IsSecondary {
 		dbOpts = append(dbOpts, easypg.OverrideDatabaseName(t.Name()+"_secondary"))
@@ -293,10 +371,28 @@ func NewSetup(t *testing.T, opts ...SetupOption) Setup {
 	s.FD = fd.(*FederationDriver)
 	sd, err := keppel.NewStorageDriver("in-memory-for-testing", ad, s.Config)
 	mustDo(t, err)
-	s.SD = sd.(*trivial.StorageDriver)
+	s.SD = keppel.UnwrapStorageDriver(sd).(*trivial.StorageDriver)
+	if params.WithSharedStorage && params.SetupOfPrimary != nil {
+		s.SD.SharedStorage = params.SetupOfPrimary.SD
+	}
 	icd, err := keppel.NewInboundCacheDriver(s.Ctx, "unittest", s.Config)
 	mustDo(t, err)
 	s.ICD = icd.(*InboundCacheDriver)
+	cdn, err := keppel.NewCDNDriver("unittest", ad, s.Config)
+	mustDo(t, err)
+	s.CDN = cdn.(*CDNDriver)
+	if params.WithUsageReporter {
+		urd, err := keppel.NewUsageReportDriver(s.Ctx, "unittest", s.Config)
+		mustDo(t, err)
+		s.UsageReporter = urd.(*UsageReportDriver)
+		s.Config.UsageReporter = urd
+	}
+	if params.WithManifestValidator {
+		mvd, err := keppel.NewManifestValidationDriver(s.Ctx, "unittest", s.Config)
+		mustDo(t, err)
+		s.ManifestValidator = mvd.(*ManifestValidationDriver)
+		s.Config.ManifestValidator = mvd
+	}
 
 	if params.RateLimitEngine != nil {
 		sr := miniredis.RunT(t)
@@ -308,19 +404,33 @@ func NewSetup(t *testing.T, opts ...SetupOption) Setup {
 		})
 	}
 
+	if params.WithUploadCoordinator {
+		sr := miniredis.RunT(t)
+		s.UploadCoordinator = &keppel.UploadCoordinator{Client: redis.NewClient(&redis.Options{
+			Addr: sr.Addr(),
+			// SETINFO not supported by miniredis
+			DisableIndentity: true,
+		})}
+	}
+
 	// setup APIs
+	var auditor audittools.Auditor = s.Auditor
+	if params.WithAuditEventStore {
+		s.Config.AuditEventRetention = 30 * 24 * time.Hour
+		auditor = keppel.PersistAuditEvents(s.Auditor, s.DB)
+	}
 	apis := []httpapi.API{
 		httpapi.WithoutLogging(),
 		// Registry API (and thus Auth API) are nearly always needed for
 		// Bytes.Upload, Image.Upload and ImageList.Upload
-		registryv2.NewAPI(s.Config, ad, fd, sd, icd, s.DB, s.Auditor, params.RateLimitEngine).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next),
+		registryv2.NewAPI(s.Config, ad, fd, sd, icd, cdn, s.DB, auditor, params.RateLimitEngine, s.UploadCoordinator).OverrideTimeNow(s.Clock.Now).OverrideGenerateStorageID(s.SIDGenerator.Next),
 		authapi.NewAPI(s.Config, ad, fd, s.DB),
 	}
 	if params.WithKeppelAPI {
-		apis = append(apis, keppelv1.NewAPI(s.Config, ad, fd, sd, icd, s.DB, s.Auditor, params.RateLimitEngine).OverrideTimeNow(s.Clock.Now))
+		apis = append(apis, keppelv1.NewAPI(s.Config, ad, fd, sd, icd, s.DB, auditor, params.RateLimitEngine).OverrideTimeNow(s.Clock.Now))
 	}
 	if params.WithPeerAPI {
-		apis = append(apis, peerv1.NewAPI(s.Config, ad, s.DB))
+		apis = append(apis, peerv1.NewAPI(s.Config, ad, sd, s.DB))
 	}
 	s.Handler = httpapi.Compose(apis...)
 	if tt, ok := http.DefaultTransport.(*RoundTripper); ok {
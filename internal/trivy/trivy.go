@@ -21,7 +21,6 @@ package trivy
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -53,9 +52,8 @@ const (
 // Config contains credentials for talking to a Trivy server through a
 // trivy-proxy deployment.
 type Config struct {
-	AdditionalPullableRepos []string
-	Token                   string
-	URL                     url.URL
+	Token string
+	URL   url.URL
 }
 
 // ReportPayload contains a report that was returned by Trivy (and potentially
@@ -107,20 +105,6 @@ func stripColor(in string) string {
 	return ansiColorCodeRx.ReplaceAllString(in, "")
 }
 
-// ScanManifest is like ScanManifestAndParse, except that the result is parsed
-// instead of being returned as a bytestring. The report format "json" is
-// implied in order to match the return type.
-func (tc *Config) ScanManifestAndParse(ctx context.Context, keppelToken string, manifestRef models.ImageReference) (Report, error) {
-	report, err := tc.ScanManifest(ctx, keppelToken, manifestRef, "json")
-	if err != nil {
-		return Report{}, err
-	}
-
-	var parsedReport Report
-	err = json.Unmarshal(report.Contents, &parsedReport)
-	return parsedReport, err
-}
-
 // FixIsReleased returns whether v.FixedVersion is non-empty. (This particular
 // method name reads better in some situations than `v.FixedVersion != ""`.)
 func FixIsReleased(v serialize.DetectedVulnerability) bool {
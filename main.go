@@ -26,10 +26,18 @@ import (
 	"github.com/spf13/cobra"
 	"go.uber.org/automaxprocs/maxprocs"
 
+	accountcmd "github.com/sapcc/keppel/cmd/account"
 	anycastmonitorcmd "github.com/sapcc/keppel/cmd/anycastmonitor"
 	apicmd "github.com/sapcc/keppel/cmd/api"
+	exportaccountcmd "github.com/sapcc/keppel/cmd/exportaccount"
+	grypeproxycmd "github.com/sapcc/keppel/cmd/grypeproxy"
 	healthmonitorcmd "github.com/sapcc/keppel/cmd/healthmonitor"
+	importaccountcmd "github.com/sapcc/keppel/cmd/importaccount"
 	janitorcmd "github.com/sapcc/keppel/cmd/janitor"
+	migratestoragecmd "github.com/sapcc/keppel/cmd/migratestorage"
+	previewmanagedaccountscmd "github.com/sapcc/keppel/cmd/previewmanagedaccounts"
+	pullcmd "github.com/sapcc/keppel/cmd/pull"
+	pushcmd "github.com/sapcc/keppel/cmd/push"
 	trivyproxycmd "github.com/sapcc/keppel/cmd/trivyproxy"
 	validatecmd "github.com/sapcc/keppel/cmd/validate"
 	validateconfigcmd "github.com/sapcc/keppel/cmd/validateconfig"
@@ -38,10 +46,12 @@ import (
 	// include all known driver implementations
 	_ "github.com/sapcc/keppel/internal/drivers/basic"
 	_ "github.com/sapcc/keppel/internal/drivers/filesystem"
+	_ "github.com/sapcc/keppel/internal/drivers/grype"
 	_ "github.com/sapcc/keppel/internal/drivers/multi"
 	_ "github.com/sapcc/keppel/internal/drivers/openstack"
 	_ "github.com/sapcc/keppel/internal/drivers/redis"
 	_ "github.com/sapcc/keppel/internal/drivers/trivial"
+	_ "github.com/sapcc/keppel/internal/drivers/trivy"
 )
 
 func main() {
@@ -60,6 +70,9 @@ func main() {
 			cmd.Help()
 		},
 	}
+	accountcmd.AddCommandTo(rootCmd)
+	pullcmd.AddCommandTo(rootCmd)
+	pushcmd.AddCommandTo(rootCmd)
 	validatecmd.AddCommandTo(rootCmd)
 
 	serverCmd := &cobra.Command{
@@ -72,8 +85,13 @@ func main() {
 	}
 	anycastmonitorcmd.AddCommandTo(serverCmd)
 	apicmd.AddCommandTo(serverCmd)
+	exportaccountcmd.AddCommandTo(serverCmd)
+	grypeproxycmd.AddCommandTo(serverCmd)
 	healthmonitorcmd.AddCommandTo(serverCmd)
+	importaccountcmd.AddCommandTo(serverCmd)
 	janitorcmd.AddCommandTo(serverCmd)
+	migratestoragecmd.AddCommandTo(serverCmd)
+	previewmanagedaccountscmd.AddCommandTo(serverCmd)
 	trivyproxycmd.AddCommandTo(serverCmd)
 	validateconfigcmd.AddCommandTo(serverCmd)
 	rootCmd.AddCommand(serverCmd)
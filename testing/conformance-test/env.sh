@@ -19,5 +19,6 @@ if [ -d "${KEPPEL_FILESYSTEM_PATH}/bogus/conformance-test" ]; then
 fi
 
 export KEPPEL_RUN_DB_SETUP_FOR_CONFORMANCE_TEST=true
+export KEPPEL_ENABLE_OCI_DISTRIBUTION_SPEC_V1_1=true
 
 # export KEPPEL_OSLO_POLICY_PATH=docs/example-policy.yaml